@@ -1,39 +1,108 @@
 package main
 
 import (
+	"context"
+	"ecom_apiv1/config"
 	"ecom_apiv1/db"
+	"ecom_apiv1/db/migrate"
+	"ecom_apiv1/internal/address"
+	"ecom_apiv1/internal/blob"
 	"ecom_apiv1/internal/handler"
+	"ecom_apiv1/internal/payment"
+	"ecom_apiv1/internal/pricing"
 	"ecom_apiv1/internal/server"
+	"ecom_apiv1/internal/shipping"
 	"ecom_apiv1/internal/storer"
+	storerpq "ecom_apiv1/internal/storer_pq"
+	"ecom_apiv1/internal/tax"
+	"ecom_apiv1/token"
+	"fmt"
 	"log"
 	"os"
-
-	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 )
 
-const minSecretKeySize = 32
-
 func main() {
-	err := godotenv.Load("../.env")
+	cfg, args, err := config.Load(os.Args[1:])
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Fatal(err)
 	}
-	secretKey := os.Getenv("SECRET_KEY")
-	if len(secretKey) < minSecretKeySize {
-		log.Fatalf("SECRET_KEY must be at least %d characters", minSecretKeySize)
-	}
-	gormDB, err := db.GetConnection()
+
+	str, migrator, err := newStore(cfg.Database)
 	if err != nil {
 		log.Fatalf("error opening database: %v", err)
 	}
-	log.Println("Succesfully connecting database")
 
-	// str := storer.NewMySQLStorage(sqlx)
-	str := storer.NewGORMStorage(gormDB)
-	srv := server.NewServer(str)
+	if len(args) > 0 && args[0] == "migrate" {
+		if migrator == nil {
+			log.Fatal("migrate: the selected database backend has no SQL schema")
+		}
+		if err := runMigrate(context.Background(), migrator, args[1:]); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+
+	if migrator != nil {
+		if err := migrator.EnsureCurrent(context.Background()); err != nil {
+			log.Fatalf("refusing to start: %v (run `migrate up`)", err)
+		}
+	}
 
-	hdl := handler.NewHandler(srv, secretKey)
+	calc := pricing.NewCalculator(cfg.Pricing)
+	calc.SetShipping(shipping.FromConfig(cfg.Pricing, cfg.Shipping))
+	calc.SetTax(tax.FromConfig(cfg.Pricing, cfg.Tax))
+	srv := server.NewServer(str, calc, payment.FromConfig(cfg.Payment))
+	srv.SetAddressValidator(address.NewValidator(cfg.Address))
+	blobs, err := blob.FromConfig(cfg.Blob)
+	if err != nil {
+		log.Fatalf("error opening blob store: %v", err)
+	}
+	srv.SetImageStore(blobs, cfg.Images)
+	tokenMaker := token.NewJWTMaker(cfg.Auth.SecretKey, cfg.Auth.AccessTokenTTL, cfg.Auth.RefreshTokenTTL)
+
+	go srv.RunIdempotencyCleanup(context.Background(), cfg.Idempotency.CleanupInterval)
+
+	hdl := handler.NewHandler(srv, tokenMaker, cfg.Idempotency)
 	handler.RegisterRoutes(hdl)
-	handler.Start(":8000")
+	log.Printf("Starting %s server on %s", cfg.Env, cfg.HTTP.Addr)
+	if err := handler.Start(cfg.HTTP); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newStore opens the configured backend. The migrator is nil for memory.
+func newStore(cfg config.DatabaseConfig) (storer.Store, *migrate.Migrator, error) {
+	switch cfg.Backend {
+	case config.BackendMySQL:
+		gormDB, err := db.GetConnection(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		sqlDB, err := gormDB.DB()
+		if err != nil {
+			return nil, nil, err
+		}
+		migrator, err := migrate.New(sqlDB, migrate.MySQL)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Println("Succesfully connecting database")
+		return storer.NewGORMStorage(gormDB), migrator, nil
+	case config.BackendPostgres:
+		sqlxDB, err := db.GetPostgresConnection(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		migrator, err := migrate.New(sqlxDB.DB, migrate.Postgres)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Println("Succesfully connecting database")
+		return storerpq.NewPostgresStorage(sqlxDB), migrator, nil
+	case config.BackendMemory:
+		log.Println("Using in-memory storage, data will not be persisted")
+		return storer.NewMemoryStorage(), nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown database backend %q", cfg.Backend)
+	}
 }
@@ -0,0 +1,686 @@
+// Package config builds the application Config from, in increasing order of
+// precedence: built-in defaults, an optional YAML or TOML file, environment
+// variables (optionally seeded from a .env file) and command line flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+const MinSecretKeySize = 32
+
+const (
+	BackendMySQL    = "mysql"
+	BackendPostgres = "postgres"
+	BackendMemory   = "memory"
+)
+
+type Config struct {
+	Env      string         `yaml:"env" toml:"env"`
+	HTTP     HTTPConfig     `yaml:"http" toml:"http"`
+	Database DatabaseConfig `yaml:"database" toml:"database"`
+	Auth     AuthConfig     `yaml:"auth" toml:"auth"`
+	Pricing  PricingConfig  `yaml:"pricing" toml:"pricing"`
+	Payment  PaymentConfig  `yaml:"payment" toml:"payment"`
+	Address  AddressConfig  `yaml:"address" toml:"address"`
+	Shipping ShippingConfig `yaml:"shipping" toml:"shipping"`
+	Tax      TaxConfig      `yaml:"tax" toml:"tax"`
+	Blob     BlobConfig     `yaml:"blob" toml:"blob"`
+	Images   ImageConfig    `yaml:"images" toml:"images"`
+
+	Idempotency IdempotencyConfig `yaml:"idempotency" toml:"idempotency"`
+}
+
+type HTTPConfig struct {
+	Addr         string        `yaml:"addr" toml:"addr"`
+	ReadTimeout  time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+}
+
+type DatabaseConfig struct {
+	// Backend is one of mysql (GORM), postgres (sqlx) or memory.
+	Backend  string `yaml:"backend" toml:"backend"`
+	DSN      string `yaml:"dsn" toml:"dsn"`
+	LogLevel string `yaml:"log_level" toml:"log_level"`
+}
+
+type AuthConfig struct {
+	SecretKey       string        `yaml:"secret_key" toml:"secret_key"`
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl" toml:"access_token_ttl"`
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl" toml:"refresh_token_ttl"`
+}
+
+// IdempotencyConfig controls how long responses to requests sent with an
+// Idempotency-Key are kept for replay.
+type IdempotencyConfig struct {
+	TTL time.Duration `yaml:"ttl" toml:"ttl"`
+	// Lease is how long a request holds its key before answering. A key
+	// left behind by a request that never answered is free again after it,
+	// so it should outlast the HTTP write timeout.
+	Lease           time.Duration `yaml:"lease" toml:"lease"`
+	CleanupInterval time.Duration `yaml:"cleanup_interval" toml:"cleanup_interval"`
+}
+
+type PricingConfig struct {
+	// TaxRate is added to the order subtotal, e.g. 0.15 for 15%, where no
+	// tax jurisdiction applies.
+	TaxRate float64 `yaml:"tax_rate" toml:"tax_rate"`
+	// ShippingFee is charged unless the subtotal reaches FreeShippingOver;
+	// a zero FreeShippingOver disables free shipping.
+	ShippingFee      float64 `yaml:"shipping_fee" toml:"shipping_fee"`
+	FreeShippingOver float64 `yaml:"free_shipping_over" toml:"free_shipping_over"`
+}
+
+// ShippingConfig refines the flat pricing.shipping_fee. Orders outside every
+// zone pay the tiers when there are any; pricing.free_shipping_over still
+// makes them free.
+type ShippingConfig struct {
+	// TierBy is weight (kg) or price (order subtotal).
+	TierBy string               `yaml:"tier_by" toml:"tier_by"`
+	Tiers  []ShippingTierConfig `yaml:"tiers" toml:"tiers"`
+	Zones  []ShippingZoneConfig `yaml:"zones" toml:"zones"`
+}
+
+// ShippingTierConfig charges Amount up to and including UpTo. Tiers are
+// listed in ascending order and the last one leaves UpTo at 0, no limit.
+type ShippingTierConfig struct {
+	UpTo   float64 `yaml:"up_to" toml:"up_to"`
+	Amount float64 `yaml:"amount" toml:"amount"`
+}
+
+// ShippingZoneConfig prices orders to its countries: Fee, or the Tiers
+// when there are any, free from FreeOver unless it is 0.
+type ShippingZoneConfig struct {
+	Name      string               `yaml:"name" toml:"name"`
+	Countries []string             `yaml:"countries" toml:"countries"`
+	Fee       float64              `yaml:"fee" toml:"fee"`
+	TierBy    string               `yaml:"tier_by" toml:"tier_by"`
+	Tiers     []ShippingTierConfig `yaml:"tiers" toml:"tiers"`
+	FreeOver  float64              `yaml:"free_over" toml:"free_over"`
+}
+
+// TaxConfig sets how orders are taxed in each country. Orders to countries
+// without a jurisdiction pay pricing.tax_rate on top of every item.
+type TaxConfig struct {
+	// DefaultCountry is the jurisdiction carts and quotes are taxed in
+	// before the destination is known; empty uses pricing.tax_rate.
+	DefaultCountry string                  `yaml:"default_country" toml:"default_country"`
+	Jurisdictions  []TaxJurisdictionConfig `yaml:"jurisdictions" toml:"jurisdictions"`
+}
+
+// TaxJurisdictionConfig taxes orders shipped to Country.
+type TaxJurisdictionConfig struct {
+	Country string `yaml:"country" toml:"country"`
+	// Mode is exclusive, tax added to the prices as US sales tax is, or
+	// inclusive, tax contained in them as VAT is.
+	Mode string `yaml:"mode" toml:"mode"`
+	// Rates maps a product tax category to its rate; the standard rate
+	// applies to categories that are not listed.
+	Rates map[string]float64 `yaml:"rates" toml:"rates"`
+	// Rounding is order, the default, rounding the tax of the whole order
+	// once, or line, rounding the tax of every item.
+	Rounding string `yaml:"rounding" toml:"rounding"`
+	// RoundMode is half_up, the default, half_even or down.
+	RoundMode string `yaml:"round_mode" toml:"round_mode"`
+}
+
+// AddressConfig decides which shipping addresses are accepted.
+type AddressConfig struct {
+	// Countries lists the ISO 3166-1 alpha-2 codes orders ship to; empty
+	// accepts every country.
+	Countries []string `yaml:"countries" toml:"countries"`
+	// PostalCodes maps a country code to the regular expression its postal
+	// codes must match, replacing the built-in pattern for that country.
+	PostalCodes map[string]string `yaml:"postal_codes" toml:"postal_codes"`
+}
+
+const (
+	BlobLocal = "local"
+	BlobS3    = "s3"
+)
+
+// BlobConfig picks where uploaded files such as product images are kept.
+type BlobConfig struct {
+	// Backend is local or s3.
+	Backend string          `yaml:"backend" toml:"backend"`
+	Local   LocalBlobConfig `yaml:"local" toml:"local"`
+	S3      S3Config        `yaml:"s3" toml:"s3"`
+}
+
+// LocalBlobConfig keeps files under Dir, which the API serves at /media.
+type LocalBlobConfig struct {
+	Dir string `yaml:"dir" toml:"dir"`
+	// BaseURL is where clients fetch the files from, /media unless a proxy
+	// or CDN serves them.
+	BaseURL string `yaml:"base_url" toml:"base_url"`
+}
+
+// S3Config keeps files in a bucket of S3 or of an S3-compatible server
+// such as MinIO, addressed path-style.
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint" toml:"endpoint"`
+	Region    string `yaml:"region" toml:"region"`
+	Bucket    string `yaml:"bucket" toml:"bucket"`
+	AccessKey string `yaml:"access_key" toml:"access_key"`
+	SecretKey string `yaml:"secret_key" toml:"secret_key"`
+	// PublicURL is where clients fetch the objects from, e.g. a CDN; empty
+	// uses the bucket's URL on Endpoint.
+	PublicURL string `yaml:"public_url" toml:"public_url"`
+}
+
+// ImageConfig limits product image uploads.
+type ImageConfig struct {
+	// MaxSize is the largest upload accepted, in bytes.
+	MaxSize int `yaml:"max_size" toml:"max_size"`
+	// ThumbnailSize is the longest side of generated thumbnails, in pixels.
+	ThumbnailSize int `yaml:"thumbnail_size" toml:"thumbnail_size"`
+}
+
+type PaymentConfig struct {
+	// Currency is the ISO 4217 code orders are charged in.
+	Currency string `yaml:"currency" toml:"currency"`
+	// WebhookTolerance is how far a webhook's signed timestamp may be from
+	// the current time before it is rejected as a replay.
+	WebhookTolerance time.Duration `yaml:"webhook_tolerance" toml:"webhook_tolerance"`
+	Stripe           StripeConfig  `yaml:"stripe" toml:"stripe"`
+	PayPal           PayPalConfig  `yaml:"paypal" toml:"paypal"`
+}
+
+// StripeConfig enables the Stripe provider when SecretKey is set.
+type StripeConfig struct {
+	BaseURL   string `yaml:"base_url" toml:"base_url"`
+	SecretKey string `yaml:"secret_key" toml:"secret_key"`
+	// WebhookSecret verifies Stripe-Signature headers; webhooks are
+	// rejected while it is empty.
+	WebhookSecret string `yaml:"webhook_secret" toml:"webhook_secret"`
+}
+
+// PayPalConfig enables the PayPal provider when both credentials are set.
+type PayPalConfig struct {
+	BaseURL      string `yaml:"base_url" toml:"base_url"`
+	ClientID     string `yaml:"client_id" toml:"client_id"`
+	ClientSecret string `yaml:"client_secret" toml:"client_secret"`
+	// WebhookSecret verifies PayPal-Transmission-Sig headers; webhooks are
+	// rejected while it is empty.
+	WebhookSecret string `yaml:"webhook_secret" toml:"webhook_secret"`
+}
+
+func Default() Config {
+	return Config{
+		Env: "development",
+		HTTP: HTTPConfig{
+			Addr:         ":8000",
+			ReadTimeout:  15 * time.Second,
+			WriteTimeout: 15 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Backend:  BackendMySQL,
+			LogLevel: "warn",
+		},
+		Auth: AuthConfig{
+			AccessTokenTTL:  60 * time.Minute,
+			RefreshTokenTTL: 24 * time.Hour,
+		},
+		// the rules the storefront used to compute client-side
+		Pricing: PricingConfig{
+			TaxRate:          0.15,
+			ShippingFee:      10,
+			FreeShippingOver: 100,
+		},
+		Payment: PaymentConfig{
+			Currency:         "USD",
+			WebhookTolerance: 5 * time.Minute,
+			Stripe:           StripeConfig{BaseURL: "https://api.stripe.com"},
+			PayPal:           PayPalConfig{BaseURL: "https://api-m.sandbox.paypal.com"},
+		},
+		Blob: BlobConfig{
+			Backend: BlobLocal,
+			Local:   LocalBlobConfig{Dir: "uploads", BaseURL: "/media"},
+			S3:      S3Config{Region: "us-east-1"},
+		},
+		Images: ImageConfig{
+			MaxSize:       10 << 20,
+			ThumbnailSize: 320,
+		},
+		Idempotency: IdempotencyConfig{
+			TTL:             24 * time.Hour,
+			Lease:           time.Minute,
+			CleanupInterval: time.Hour,
+		},
+	}
+}
+
+// ValidationError lists every problem found while loading a Config.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+func (e *ValidationError) add(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+// setting binds one Config field to a flag and an environment variable.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+var settings = []setting{
+	{"env", "APP_ENV", "deployment environment name", setString(func(c *Config) *string { return &c.Env })},
+	{"http-addr", "HTTP_ADDR", "address the HTTP server listens on", setString(func(c *Config) *string { return &c.HTTP.Addr })},
+	{"http-read-timeout", "HTTP_READ_TIMEOUT", "HTTP server read timeout", setDuration(func(c *Config) *time.Duration { return &c.HTTP.ReadTimeout })},
+	{"http-write-timeout", "HTTP_WRITE_TIMEOUT", "HTTP server write timeout", setDuration(func(c *Config) *time.Duration { return &c.HTTP.WriteTimeout })},
+	{"db-backend", "STORE_BACKEND", "storage backend: mysql, postgres or memory", setString(func(c *Config) *string { return &c.Database.Backend })},
+	{"db-dsn", "DATABASE_DSN", "database connection string", setString(func(c *Config) *string { return &c.Database.DSN })},
+	{"db-log-level", "DB_LOG_LEVEL", "database log level: silent, error, warn or info", setString(func(c *Config) *string { return &c.Database.LogLevel })},
+	{"secret-key", "SECRET_KEY", "JWT signing key", setString(func(c *Config) *string { return &c.Auth.SecretKey })},
+	{"access-token-ttl", "ACCESS_TOKEN_TTL", "access token lifetime", setDuration(func(c *Config) *time.Duration { return &c.Auth.AccessTokenTTL })},
+	{"refresh-token-ttl", "REFRESH_TOKEN_TTL", "refresh token lifetime", setDuration(func(c *Config) *time.Duration { return &c.Auth.RefreshTokenTTL })},
+	{"tax-rate", "TAX_RATE", "tax rate applied to order subtotals, e.g. 0.15", setFloat(func(c *Config) *float64 { return &c.Pricing.TaxRate })},
+	{"shipping-fee", "SHIPPING_FEE", "flat shipping fee per order", setFloat(func(c *Config) *float64 { return &c.Pricing.ShippingFee })},
+	{"free-shipping-over", "FREE_SHIPPING_OVER", "subtotal from which shipping is free, 0 to disable", setFloat(func(c *Config) *float64 { return &c.Pricing.FreeShippingOver })},
+	{"payment-currency", "PAYMENT_CURRENCY", "ISO 4217 currency orders are charged in", setString(func(c *Config) *string { return &c.Payment.Currency })},
+	{"payment-webhook-tolerance", "PAYMENT_WEBHOOK_TOLERANCE", "maximum age of a signed payment webhook", setDuration(func(c *Config) *time.Duration { return &c.Payment.WebhookTolerance })},
+	{"stripe-base-url", "STRIPE_BASE_URL", "Stripe API base URL", setString(func(c *Config) *string { return &c.Payment.Stripe.BaseURL })},
+	{"stripe-secret-key", "STRIPE_SECRET_KEY", "Stripe secret API key, empty disables Stripe", setString(func(c *Config) *string { return &c.Payment.Stripe.SecretKey })},
+	{"stripe-webhook-secret", "STRIPE_WEBHOOK_SECRET", "Stripe webhook signing secret", setString(func(c *Config) *string { return &c.Payment.Stripe.WebhookSecret })},
+	{"paypal-base-url", "PAYPAL_BASE_URL", "PayPal API base URL", setString(func(c *Config) *string { return &c.Payment.PayPal.BaseURL })},
+	{"paypal-client-id", "PAYPAL_CLIENT_ID", "PayPal REST client ID, empty disables PayPal", setString(func(c *Config) *string { return &c.Payment.PayPal.ClientID })},
+	{"paypal-client-secret", "PAYPAL_CLIENT_SECRET", "PayPal REST client secret", setString(func(c *Config) *string { return &c.Payment.PayPal.ClientSecret })},
+	{"paypal-webhook-secret", "PAYPAL_WEBHOOK_SECRET", "PayPal webhook signing secret", setString(func(c *Config) *string { return &c.Payment.PayPal.WebhookSecret })},
+	{"tax-default-country", "TAX_DEFAULT_COUNTRY", "tax jurisdiction of carts before the destination is known", setString(func(c *Config) *string { return &c.Tax.DefaultCountry })},
+	{"ship-to-countries", "SHIP_TO_COUNTRIES", "comma separated country codes orders ship to, empty for any", setList(func(c *Config) *[]string { return &c.Address.Countries })},
+	{"blob-backend", "BLOB_BACKEND", "where uploaded files are kept: local or s3", setString(func(c *Config) *string { return &c.Blob.Backend })},
+	{"blob-dir", "BLOB_DIR", "directory uploaded files are kept in by the local backend", setString(func(c *Config) *string { return &c.Blob.Local.Dir })},
+	{"blob-base-url", "BLOB_BASE_URL", "URL clients fetch locally kept files from", setString(func(c *Config) *string { return &c.Blob.Local.BaseURL })},
+	{"s3-endpoint", "S3_ENDPOINT", "S3 API endpoint", setString(func(c *Config) *string { return &c.Blob.S3.Endpoint })},
+	{"s3-region", "S3_REGION", "S3 region", setString(func(c *Config) *string { return &c.Blob.S3.Region })},
+	{"s3-bucket", "S3_BUCKET", "S3 bucket uploaded files are kept in", setString(func(c *Config) *string { return &c.Blob.S3.Bucket })},
+	{"s3-access-key", "S3_ACCESS_KEY", "S3 access key ID", setString(func(c *Config) *string { return &c.Blob.S3.AccessKey })},
+	{"s3-secret-key", "S3_SECRET_KEY", "S3 secret access key", setString(func(c *Config) *string { return &c.Blob.S3.SecretKey })},
+	{"s3-public-url", "S3_PUBLIC_URL", "URL clients fetch S3 objects from, empty for the bucket URL", setString(func(c *Config) *string { return &c.Blob.S3.PublicURL })},
+	{"image-max-size", "IMAGE_MAX_SIZE", "largest product image upload in bytes", setInt(func(c *Config) *int { return &c.Images.MaxSize })},
+	{"image-thumbnail-size", "IMAGE_THUMBNAIL_SIZE", "longest side of product image thumbnails in pixels", setInt(func(c *Config) *int { return &c.Images.ThumbnailSize })},
+	{"idempotency-ttl", "IDEMPOTENCY_TTL", "how long idempotent responses are kept for replay", setDuration(func(c *Config) *time.Duration { return &c.Idempotency.TTL })},
+	{"idempotency-lease", "IDEMPOTENCY_LEASE", "how long a request holds its idempotency key before answering", setDuration(func(c *Config) *time.Duration { return &c.Idempotency.Lease })},
+	{"idempotency-cleanup-interval", "IDEMPOTENCY_CLEANUP_INTERVAL", "how often expired idempotency keys are deleted", setDuration(func(c *Config) *time.Duration { return &c.Idempotency.CleanupInterval })},
+}
+
+// Load parses args (usually os.Args[1:]) and returns the merged, validated
+// Config together with the positional arguments left after the flags.
+func Load(args []string) (*Config, []string, error) {
+	verr := &ValidationError{}
+
+	fs := flag.NewFlagSet("ecom", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFile := fs.String("config", "", "path to a YAML or TOML config file (env CONFIG_FILE)")
+	envFile := fs.String("env-file", ".env", "optional dotenv file loaded into the environment")
+	values := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		values[s.flag] = fs.String(s.flag, "", fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		verr.add("flags: %v", err)
+		return nil, nil, verr
+	}
+	setFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	if err := godotenv.Load(*envFile); err != nil && (setFlags["env-file"] || !errors.Is(err, os.ErrNotExist)) {
+		verr.add("env file %s: %v", *envFile, err)
+	}
+
+	cfg := Default()
+
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := loadFile(&cfg, path); err != nil {
+			verr.add("config file %s: %v", path, err)
+		}
+	}
+
+	for _, s := range settings {
+		if v := os.Getenv(s.env); v != "" {
+			if err := s.set(&cfg, v); err != nil {
+				verr.add("env %s: %v", s.env, err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if setFlags[s.flag] {
+			if err := s.set(&cfg, *values[s.flag]); err != nil {
+				verr.add("flag -%s: %v", s.flag, err)
+			}
+		}
+	}
+
+	cfg.validate(verr)
+	if len(verr.Problems) > 0 {
+		return nil, nil, verr
+	}
+	return &cfg, fs.Args(), nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(strings.NewReader(string(data)))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return err
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("unknown keys %v", undecoded)
+		}
+	default:
+		return fmt.Errorf("unsupported file extension %q, use .yaml, .yml or .toml", filepath.Ext(path))
+	}
+	return nil
+}
+
+// Validate reports every problem with c at once.
+func (c *Config) Validate() error {
+	verr := &ValidationError{}
+	c.validate(verr)
+	if len(verr.Problems) > 0 {
+		return verr
+	}
+	return nil
+}
+
+func (c *Config) validate(verr *ValidationError) {
+	if c.HTTP.Addr == "" {
+		verr.add("http.addr is required")
+	}
+	if c.HTTP.ReadTimeout < 0 {
+		verr.add("http.read_timeout must not be negative")
+	}
+	if c.HTTP.WriteTimeout < 0 {
+		verr.add("http.write_timeout must not be negative")
+	}
+
+	switch c.Database.Backend {
+	case BackendMySQL, BackendPostgres:
+		if c.Database.DSN == "" {
+			verr.add("database.dsn is required for the %s backend", c.Database.Backend)
+		}
+	case BackendMemory:
+	default:
+		verr.add("database.backend must be one of %s, %s, %s (got %q)", BackendMySQL, BackendPostgres, BackendMemory, c.Database.Backend)
+	}
+	switch c.Database.LogLevel {
+	case "silent", "error", "warn", "info":
+	default:
+		verr.add("database.log_level must be one of silent, error, warn, info (got %q)", c.Database.LogLevel)
+	}
+
+	if len(c.Auth.SecretKey) < MinSecretKeySize {
+		verr.add("auth.secret_key must be at least %d characters", MinSecretKeySize)
+	}
+	if c.Auth.AccessTokenTTL <= 0 {
+		verr.add("auth.access_token_ttl must be positive")
+	}
+	if c.Auth.RefreshTokenTTL <= 0 {
+		verr.add("auth.refresh_token_ttl must be positive")
+	} else if c.Auth.RefreshTokenTTL < c.Auth.AccessTokenTTL {
+		verr.add("auth.refresh_token_ttl must not be shorter than auth.access_token_ttl")
+	}
+
+	if c.Pricing.TaxRate < 0 || c.Pricing.TaxRate >= 1 {
+		verr.add("pricing.tax_rate must be between 0 and 1")
+	}
+	if c.Pricing.ShippingFee < 0 {
+		verr.add("pricing.shipping_fee must not be negative")
+	}
+	if c.Pricing.FreeShippingOver < 0 {
+		verr.add("pricing.free_shipping_over must not be negative")
+	}
+
+	if len(c.Payment.Currency) != 3 || strings.ToUpper(c.Payment.Currency) != c.Payment.Currency {
+		verr.add("payment.currency must be a 3 letter upper case ISO 4217 code (got %q)", c.Payment.Currency)
+	}
+	if c.Payment.WebhookTolerance <= 0 {
+		verr.add("payment.webhook_tolerance must be positive")
+	}
+	if !isAbsoluteURL(c.Payment.Stripe.BaseURL) {
+		verr.add("payment.stripe.base_url must be an absolute URL (got %q)", c.Payment.Stripe.BaseURL)
+	}
+	if !isAbsoluteURL(c.Payment.PayPal.BaseURL) {
+		verr.add("payment.paypal.base_url must be an absolute URL (got %q)", c.Payment.PayPal.BaseURL)
+	}
+	if (c.Payment.PayPal.ClientID == "") != (c.Payment.PayPal.ClientSecret == "") {
+		verr.add("payment.paypal.client_id and payment.paypal.client_secret must be set together")
+	}
+
+	for _, country := range c.Address.Countries {
+		if !isCountryCode(country) {
+			verr.add("address.countries must hold 2 letter upper case ISO 3166-1 codes (got %q)", country)
+		}
+	}
+	for country, pattern := range c.Address.PostalCodes {
+		if !isCountryCode(country) {
+			verr.add("address.postal_codes must be keyed by 2 letter upper case ISO 3166-1 codes (got %q)", country)
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			verr.add("address.postal_codes.%s is not a valid pattern: %v", country, err)
+		}
+	}
+
+	validateShippingTiers(verr, "shipping", c.Shipping.TierBy, c.Shipping.Tiers)
+	zoneOf := make(map[string]string)
+	for i, z := range c.Shipping.Zones {
+		if z.Name == "" {
+			verr.add("shipping.zones[%d].name is required", i)
+		}
+		name := fmt.Sprintf("shipping.zones[%d]", i)
+		if len(z.Countries) == 0 {
+			verr.add("%s.countries must not be empty", name)
+		}
+		for _, country := range z.Countries {
+			if !isCountryCode(country) {
+				verr.add("%s.countries must hold 2 letter upper case ISO 3166-1 codes (got %q)", name, country)
+			} else if other, ok := zoneOf[country]; ok {
+				verr.add("%s: %s is already in zone %q", name, country, other)
+			}
+			zoneOf[country] = z.Name
+		}
+		if z.Fee < 0 {
+			verr.add("%s.fee must not be negative", name)
+		}
+		if z.FreeOver < 0 {
+			verr.add("%s.free_over must not be negative", name)
+		}
+		validateShippingTiers(verr, name, z.TierBy, z.Tiers)
+	}
+
+	taxCountries := make(map[string]bool)
+	for i, j := range c.Tax.Jurisdictions {
+		name := fmt.Sprintf("tax.jurisdictions[%d]", i)
+		if !isCountryCode(j.Country) {
+			verr.add("%s.country must be a 2 letter upper case ISO 3166-1 code (got %q)", name, j.Country)
+		} else if taxCountries[j.Country] {
+			verr.add("%s: %s already has a jurisdiction", name, j.Country)
+		}
+		taxCountries[j.Country] = true
+		if j.Mode != "exclusive" && j.Mode != "inclusive" {
+			verr.add("%s.mode must be exclusive or inclusive (got %q)", name, j.Mode)
+		}
+		if _, ok := j.Rates["standard"]; !ok {
+			verr.add("%s.rates must have a standard rate", name)
+		}
+		for category, rate := range j.Rates {
+			if rate < 0 || rate >= 1 {
+				verr.add("%s.rates.%s must be between 0 and 1", name, category)
+			}
+		}
+		if j.Rounding != "" && j.Rounding != "order" && j.Rounding != "line" {
+			verr.add("%s.rounding must be order or line (got %q)", name, j.Rounding)
+		}
+		switch j.RoundMode {
+		case "", "half_up", "half_even", "down":
+		default:
+			verr.add("%s.round_mode must be half_up, half_even or down (got %q)", name, j.RoundMode)
+		}
+	}
+	if c.Tax.DefaultCountry != "" && !taxCountries[c.Tax.DefaultCountry] {
+		verr.add("tax.default_country must be one of the tax jurisdictions (got %q)", c.Tax.DefaultCountry)
+	}
+
+	switch c.Blob.Backend {
+	case BlobLocal:
+		if c.Blob.Local.Dir == "" {
+			verr.add("blob.local.dir is required for the %s backend", BlobLocal)
+		}
+		if c.Blob.Local.BaseURL == "" {
+			verr.add("blob.local.base_url is required for the %s backend", BlobLocal)
+		}
+	case BlobS3:
+		if !isAbsoluteURL(c.Blob.S3.Endpoint) {
+			verr.add("blob.s3.endpoint must be an absolute URL (got %q)", c.Blob.S3.Endpoint)
+		}
+		if c.Blob.S3.Region == "" || c.Blob.S3.Bucket == "" {
+			verr.add("blob.s3.region and blob.s3.bucket are required for the %s backend", BlobS3)
+		}
+		if c.Blob.S3.AccessKey == "" || c.Blob.S3.SecretKey == "" {
+			verr.add("blob.s3.access_key and blob.s3.secret_key are required for the %s backend", BlobS3)
+		}
+		if c.Blob.S3.PublicURL != "" && !isAbsoluteURL(c.Blob.S3.PublicURL) {
+			verr.add("blob.s3.public_url must be an absolute URL (got %q)", c.Blob.S3.PublicURL)
+		}
+	default:
+		verr.add("blob.backend must be one of %s, %s (got %q)", BlobLocal, BlobS3, c.Blob.Backend)
+	}
+	if c.Images.MaxSize <= 0 {
+		verr.add("images.max_size must be positive")
+	}
+	if c.Images.ThumbnailSize <= 0 {
+		verr.add("images.thumbnail_size must be positive")
+	}
+
+	if c.Idempotency.TTL <= 0 {
+		verr.add("idempotency.ttl must be positive")
+	}
+	switch {
+	case c.Idempotency.Lease <= 0:
+		verr.add("idempotency.lease must be positive")
+	case c.HTTP.WriteTimeout > 0 && c.Idempotency.Lease <= c.HTTP.WriteTimeout:
+		verr.add("idempotency.lease must be longer than http.write_timeout")
+	}
+	if c.Idempotency.CleanupInterval <= 0 {
+		verr.add("idempotency.cleanup_interval must be positive")
+	}
+}
+
+func isAbsoluteURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+func validateShippingTiers(verr *ValidationError, name, tierBy string, tiers []ShippingTierConfig) {
+	if len(tiers) == 0 {
+		return
+	}
+	if tierBy != "weight" && tierBy != "price" {
+		verr.add("%s.tier_by must be weight or price (got %q)", name, tierBy)
+	}
+	for i, t := range tiers {
+		last := i == len(tiers)-1
+		switch {
+		case t.Amount < 0:
+			verr.add("%s.tiers[%d].amount must not be negative", name, i)
+		case last && t.UpTo != 0:
+			verr.add("%s.tiers[%d].up_to must be 0 so the last tier has no limit", name, i)
+		case !last && (t.UpTo <= 0 || (i > 0 && t.UpTo <= tiers[i-1].UpTo)):
+			verr.add("%s.tiers[%d].up_to must be positive and above the tier before it", name, i)
+		}
+	}
+}
+
+func isCountryCode(s string) bool {
+	return len(s) == 2 && s[0] >= 'A' && s[0] <= 'Z' && s[1] >= 'A' && s[1] <= 'Z'
+}
+
+func setString(field func(c *Config) *string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func setList(field func(c *Config) *[]string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		var list []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(c) = list
+		return nil
+	}
+}
+
+func setInt(field func(c *Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", v)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func setFloat(field func(c *Config) *float64) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", v)
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+func setDuration(field func(c *Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", v)
+		}
+		*field(c) = d
+		return nil
+	}
+}
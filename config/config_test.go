@@ -0,0 +1,223 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+http:
+  addr: ":7000"
+database:
+  backend: postgres
+  dsn: "dbname=from_file"
+auth:
+  secret_key: "`+testSecret+`"
+  access_token_ttl: 30m
+`)
+	t.Setenv("DATABASE_DSN", "dbname=from_env")
+	t.Setenv("HTTP_ADDR", ":7500")
+
+	cfg, args, err := Load([]string{"-config", file, "-http-addr", ":9000", "migrate", "up"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if cfg.HTTP.Addr != ":9000" {
+		t.Errorf("flag should win over env and file, got %q", cfg.HTTP.Addr)
+	}
+	if cfg.Database.DSN != "dbname=from_env" {
+		t.Errorf("env should win over file, got %q", cfg.Database.DSN)
+	}
+	if cfg.Database.Backend != BackendPostgres {
+		t.Errorf("file should win over defaults, got %q", cfg.Database.Backend)
+	}
+	if cfg.Auth.AccessTokenTTL != 30*time.Minute {
+		t.Errorf("expected access token ttl from file, got %v", cfg.Auth.AccessTokenTTL)
+	}
+	if cfg.Auth.RefreshTokenTTL != 24*time.Hour {
+		t.Errorf("expected default refresh token ttl, got %v", cfg.Auth.RefreshTokenTTL)
+	}
+	if len(args) != 2 || args[0] != "migrate" || args[1] != "up" {
+		t.Errorf("unexpected remaining args %v", args)
+	}
+}
+
+func TestLoadTOML(t *testing.T) {
+	file := writeFile(t, "config.toml", `
+[database]
+backend = "memory"
+
+[auth]
+secret_key = "`+testSecret+`"
+refresh_token_ttl = "48h"
+`)
+	cfg, _, err := Load([]string{"-config", file})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Database.Backend != BackendMemory || cfg.Auth.RefreshTokenTTL != 48*time.Hour {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	t.Setenv("ACCESS_TOKEN_TTL", "soon")
+	_, _, err := Load([]string{"-db-backend", "oracle", "-db-log-level", "loud"})
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected *ValidationError, got %v", err)
+	}
+	// bad duration, bad backend, bad log level, missing secret key
+	if len(verr.Problems) != 4 {
+		t.Errorf("expected 4 problems, got %d:\n%v", len(verr.Problems), err)
+	}
+}
+
+func TestLoadRejectsUnknownFileKeys(t *testing.T) {
+	file := writeFile(t, "config.yaml", "database:\n  backend: memory\n  pool_size: 10\nauth:\n  secret_key: "+testSecret+"\n")
+	if _, _, err := Load([]string{"-config", file}); err == nil {
+		t.Error("expected error for unknown key")
+	}
+}
+
+func TestLoadValidatesPayment(t *testing.T) {
+	t.Setenv("SECRET_KEY", testSecret)
+	_, _, err := Load([]string{"-db-backend", "memory", "-payment-currency", "usd", "-stripe-base-url", "api.stripe.com", "-paypal-client-id", "id", "-payment-webhook-tolerance", "0s"})
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected *ValidationError, got %v", err)
+	}
+	// lower case currency, zero tolerance, relative base URL, PayPal secret missing
+	if len(verr.Problems) != 4 {
+		t.Errorf("expected 4 problems, got %d:\n%v", len(verr.Problems), err)
+	}
+}
+
+func TestLoadValidatesAddress(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+address:
+  postal_codes:
+    US: "^([0-9]{5}$"
+`)
+	t.Setenv("SECRET_KEY", testSecret)
+	t.Setenv("SHIP_TO_COUNTRIES", "US, CA,usa")
+	_, _, err := Load([]string{"-db-backend", "memory", "-config", file})
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected *ValidationError, got %v", err)
+	}
+	// bad country code, bad postal code pattern
+	if len(verr.Problems) != 2 {
+		t.Errorf("expected 2 problems, got %d:\n%v", len(verr.Problems), err)
+	}
+
+	t.Setenv("SHIP_TO_COUNTRIES", "US, CA")
+	cfg, _, err := Load([]string{"-db-backend", "memory"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(cfg.Address.Countries) != 2 || cfg.Address.Countries[1] != "CA" {
+		t.Errorf("unexpected countries %q", cfg.Address.Countries)
+	}
+}
+
+func TestLoadValidatesShipping(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+shipping:
+  tier_by: weight
+  tiers:
+    - {up_to: 5, amount: 8}
+    - {up_to: 2, amount: 5}
+    - {amount: 12}
+  zones:
+    - name: north-america
+      countries: [US, CA]
+      fee: 6
+    - name: overseas
+      countries: [GB, US]
+      tier_by: volume
+      tiers:
+        - {up_to: 1, amount: 20}
+`)
+	t.Setenv("SECRET_KEY", testSecret)
+	_, _, err := Load([]string{"-db-backend", "memory", "-config", file})
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected *ValidationError, got %v", err)
+	}
+	// descending tiers, US in two zones, bad tier_by and a limited last tier
+	if len(verr.Problems) != 4 {
+		t.Errorf("expected 4 problems, got %d:\n%v", len(verr.Problems), err)
+	}
+}
+
+func TestLoadValidatesTax(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+tax:
+  default_country: GB
+  jurisdictions:
+    - country: ID
+      mode: inclusive
+      rates: {standard: 0.11, exempt: 0}
+      rounding: line
+    - country: US
+      mode: added
+      rates: {reduced: 1.5}
+      round_mode: up
+`)
+	t.Setenv("SECRET_KEY", testSecret)
+	_, _, err := Load([]string{"-db-backend", "memory", "-config", file})
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected *ValidationError, got %v", err)
+	}
+	// bad mode, no standard rate, rate over 1, bad round_mode and a default
+	// country without a jurisdiction
+	if len(verr.Problems) != 5 {
+		t.Errorf("expected 5 problems, got %d:\n%v", len(verr.Problems), err)
+	}
+}
+
+func TestLoadValidatesBlob(t *testing.T) {
+	t.Setenv("SECRET_KEY", testSecret)
+	_, _, err := Load([]string{"-db-backend", "memory", "-blob-backend", "s3", "-s3-endpoint", "minio:9000", "-s3-access-key", "key", "-image-max-size", "0"})
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected *ValidationError, got %v", err)
+	}
+	// relative endpoint, bucket and secret key missing, zero max size
+	if len(verr.Problems) != 4 {
+		t.Errorf("expected 4 problems, got %d:\n%v", len(verr.Problems), err)
+	}
+
+	t.Setenv("S3_SECRET_KEY", "secret")
+	cfg, _, err := Load([]string{"-db-backend", "memory", "-blob-backend", "s3", "-s3-endpoint", "http://minio:9000", "-s3-bucket", "media", "-s3-access-key", "key"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Blob.S3.Region != "us-east-1" || cfg.Blob.S3.SecretKey != "secret" || cfg.Images.MaxSize != 10<<20 {
+		t.Errorf("unexpected blob config %+v, images %+v", cfg.Blob.S3, cfg.Images)
+	}
+}
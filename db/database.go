@@ -1,29 +1,47 @@
 package db
 
 import (
+	"ecom_apiv1/config"
 	"fmt"
 
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
-// func GetConnection() (*sqlx.DB, error) {
-// 	db, err := sqlx.Open("postgres", "user=dev password=dev dbname=go_kasus_1 sslmode=disable")
-// 	// db, err := sqlx.Open("mysql", "dev:dev@tcp(localhost:3306)/go_kasus_4?parseTime=true")
-// 	if err != nil {
-// 		return nil, fmt.Errorf("Error opening database: %w", err)
-// 	}
-// 	return db, nil
-// }
-
-func GetConnection() (*gorm.DB, error) {
-	dsn := "dev:dev@tcp(localhost:3306)/go_kasus_5?charset=utf8mb4&parseTime=True&loc=Local"
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+func GetConnection(cfg config.DatabaseConfig) (*gorm.DB, error) {
+	db, err := gorm.Open(mysql.Open(cfg.DSN), &gorm.Config{
+		Logger:         logger.Default.LogMode(logLevel(cfg.LogLevel)),
+		TranslateError: true,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("Error opening database: %w", err)
 	}
 	return db, nil
 }
+
+func GetPostgresConnection(cfg config.DatabaseConfig) (*sqlx.DB, error) {
+	db, err := sqlx.Open("postgres", cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("Error connecting database: %w", err)
+	}
+	return db, nil
+}
+
+func logLevel(level string) logger.LogLevel {
+	switch level {
+	case "silent":
+		return logger.Silent
+	case "error":
+		return logger.Error
+	case "info":
+		return logger.Info
+	default:
+		return logger.Warn
+	}
+}
@@ -0,0 +1,82 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"ecom_apiv1/internal/storer"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backfill moves data in Go after the statements of an up migration, in
+// the same transaction, where SQL cannot do it the way the application
+// does on every dialect.
+type backfill func(ctx context.Context, tx *sql.Tx, rebind func(string) string) error
+
+// backfills are keyed by the version of the migration they complete.
+var backfills = map[int]backfill{
+	17: backfillCategories,
+}
+
+// backfillCategories makes a top-level category of every product category
+// string. Spellings that storer.Slugify maps to the same slug, differing
+// only in case, spacing or punctuation, become one category, the way the
+// API looks categories up, and their products take its name.
+func backfillCategories(ctx context.Context, tx *sql.Tx, rebind func(string) string) error {
+	rows, err := tx.QueryContext(ctx, "SELECT DISTINCT category FROM products")
+	if err != nil {
+		return fmt.Errorf("error reading product categories: %w", err)
+	}
+	slugs := make(map[string]string)
+	names := make(map[string]string)
+	for rows.Next() {
+		var category string
+		if err := rows.Scan(&category); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning product category: %w", err)
+		}
+		slug := storer.Slugify(category)
+		if slug == "" {
+			continue
+		}
+		slugs[category] = slug
+		name := strings.TrimSpace(category)
+		if current, ok := names[slug]; !ok || name < current {
+			names[slug] = name
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading product categories: %w", err)
+	}
+
+	ordered := make([]string, 0, len(names))
+	for slug := range names {
+		ordered = append(ordered, slug)
+	}
+	sort.Strings(ordered)
+	now := time.Now().UTC()
+	ids := make(map[string]int64, len(names))
+	for _, slug := range ordered {
+		_, err := tx.ExecContext(ctx, rebind("INSERT INTO categories (created_at, updated_at, name, slug, position) VALUES (?, ?, ?, ?, 0)"),
+			now, now, names[slug], slug)
+		if err != nil {
+			return fmt.Errorf("error creating category %q: %w", slug, err)
+		}
+		var id int64
+		if err := tx.QueryRowContext(ctx, rebind("SELECT id FROM categories WHERE slug = ?"), slug).Scan(&id); err != nil {
+			return fmt.Errorf("error getting category %q: %w", slug, err)
+		}
+		ids[slug] = id
+	}
+	for category, slug := range slugs {
+		_, err := tx.ExecContext(ctx, rebind("UPDATE products SET category_id = ?, category = ? WHERE category = ?"),
+			ids[slug], names[slug], category)
+		if err != nil {
+			return fmt.Errorf("error moving products to category %q: %w", slug, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package migrate_test
+
+import (
+	"context"
+	"database/sql"
+	"ecom_apiv1/db/migrate"
+	"errors"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestDialectsShareVersions(t *testing.T) {
+	var versions [][]int
+	for _, dialect := range []string{migrate.MySQL, migrate.Postgres, migrate.SQLite} {
+		migrations, err := migrate.Load(dialect)
+		if err != nil {
+			t.Fatalf("Load(%s): %v", dialect, err)
+		}
+		var vs []int
+		for _, m := range migrations {
+			if m.Down == "" {
+				t.Errorf("%s migration %d has no down file", dialect, m.Version)
+			}
+			vs = append(vs, m.Version)
+		}
+		versions = append(versions, vs)
+	}
+	for i := 1; i < len(versions); i++ {
+		if len(versions[i]) != len(versions[0]) {
+			t.Fatalf("dialects have different migration counts: %v", versions)
+		}
+		for j := range versions[i] {
+			if versions[i][j] != versions[0][j] {
+				t.Fatalf("dialects have different migration versions: %v", versions)
+			}
+		}
+	}
+}
+
+func TestUpDownStatus(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	m, err := migrate.New(db, migrate.SQLite)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if err := m.EnsureCurrent(ctx); !errors.Is(err, migrate.ErrSchemaBehind) {
+		t.Fatalf("expected ErrSchemaBehind on empty database, got %v", err)
+	}
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if v, _ := m.Version(ctx); v != m.Latest() {
+		t.Errorf("expected version %d after Up, got %d", m.Latest(), v)
+	}
+	if err := m.EnsureCurrent(ctx); err != nil {
+		t.Errorf("EnsureCurrent after Up: %v", err)
+	}
+	if _, err := db.Exec("SELECT id FROM products"); err != nil {
+		t.Errorf("expected products table after Up: %v", err)
+	}
+
+	// Running Up again is a no-op
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("second Up: %v", err)
+	}
+
+	if err := m.To(ctx, 0); err != nil {
+		t.Fatalf("To(0): %v", err)
+	}
+	if v, _ := m.Version(ctx); v != 0 {
+		t.Errorf("expected version 0, got %d", v)
+	}
+	if _, err := db.Exec("SELECT id FROM products"); err == nil {
+		t.Error("expected products table to be dropped")
+	}
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	for _, st := range status {
+		if st.Applied {
+			t.Errorf("migration %d still marked applied", st.Version)
+		}
+	}
+
+	if err := m.To(ctx, m.Latest()+1); err == nil {
+		t.Error("expected error migrating to an unknown version")
+	}
+}
+
+func TestDownRevertsOneStep(t *testing.T) {
+	ctx := context.Background()
+	m, err := migrate.New(openSQLite(t), migrate.SQLite)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	before, _ := m.Version(ctx)
+	if err := m.Down(ctx); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	after, _ := m.Version(ctx)
+	if after >= before {
+		t.Errorf("expected Down to lower the version from %d, got %d", before, after)
+	}
+}
+
+func TestCategoriesMapExistingStrings(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	m, err := migrate.New(db, migrate.SQLite)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := m.To(ctx, 16); err != nil {
+		t.Fatalf("To(16): %v", err)
+	}
+	for _, category := range []string{"Electronics", "electronics", " Electronics ", "Home & Garden", "home  garden", "Home/Garden!", "", " - "} {
+		_, err := db.Exec("INSERT INTO products (name, image, category, rating, price, count_in_stock) VALUES ('p', '', ?, 0, 1, 1)", category)
+		if err != nil {
+			t.Fatalf("insert product: %v", err)
+		}
+	}
+	if err := m.To(ctx, 17); err != nil {
+		t.Fatalf("To(17): %v", err)
+	}
+
+	rows, err := db.Query("SELECT name, slug FROM categories ORDER BY slug")
+	if err != nil {
+		t.Fatalf("select categories: %v", err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var name, slug string
+		if err := rows.Scan(&name, &slug); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		got = append(got, name+"="+slug)
+	}
+	if len(got) != 2 || got[0] != "Electronics=electronics" || got[1] != "Home & Garden=home-garden" {
+		t.Errorf("categories = %v", got)
+	}
+
+	var mapped, unmapped int
+	db.QueryRow("SELECT COUNT(*) FROM products WHERE category_id IS NOT NULL AND category IN ('Electronics', 'Home & Garden')").Scan(&mapped)
+	db.QueryRow("SELECT COUNT(*) FROM products WHERE category_id IS NULL").Scan(&unmapped)
+	if mapped != 6 || unmapped != 2 {
+		t.Errorf("%d products mapped and %d left without a category, want 6 and 2", mapped, unmapped)
+	}
+}
+
+func TestProductImagesKeepExistingImage(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	m, err := migrate.New(db, migrate.SQLite)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := m.To(ctx, 18); err != nil {
+		t.Fatalf("To(18): %v", err)
+	}
+	for _, image := range []string{"https://img.example.com/lamp.jpg", ""} {
+		_, err := db.Exec("INSERT INTO products (name, image, category, rating, price, count_in_stock) VALUES ('Lamp', ?, '', 0, 1, 1)", image)
+		if err != nil {
+			t.Fatalf("insert product: %v", err)
+		}
+	}
+	if err := m.To(ctx, 19); err != nil {
+		t.Fatalf("To(19): %v", err)
+	}
+
+	var n int
+	var url, alt string
+	var position int
+	db.QueryRow("SELECT COUNT(*) FROM product_images").Scan(&n)
+	db.QueryRow("SELECT url, alt_text, position FROM product_images").Scan(&url, &alt, &position)
+	if n != 1 || url != "https://img.example.com/lamp.jpg" || alt != "Lamp" || position != 0 {
+		t.Errorf("%d images, first %q %q at %d, want the lamp's image", n, url, alt, position)
+	}
+}
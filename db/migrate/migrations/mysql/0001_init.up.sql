@@ -0,0 +1,68 @@
+-- Baseline schema. IF NOT EXISTS lets databases that were created by hand
+-- before migrations existed adopt this version without changes.
+CREATE TABLE IF NOT EXISTS products (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    created_at DATETIME(3) NULL,
+    updated_at DATETIME(3) NULL,
+    name VARCHAR(255) NOT NULL,
+    image VARCHAR(1024) NOT NULL,
+    category VARCHAR(255) NOT NULL,
+    description TEXT,
+    rating BIGINT NOT NULL,
+    num_reviews BIGINT NOT NULL DEFAULT 0,
+    price DECIMAL(10,2) NOT NULL,
+    count_in_stock BIGINT NOT NULL,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS users (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    created_at DATETIME(3) NULL,
+    updated_at DATETIME(3) NULL,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(191) NOT NULL,
+    password VARCHAR(255) NOT NULL,
+    is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (id),
+    UNIQUE INDEX idx_users_email (email)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS orders (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    created_at DATETIME(3) NULL,
+    updated_at DATETIME(3) NULL,
+    payment_method VARCHAR(32) NOT NULL,
+    tax_price DECIMAL(10,2) NOT NULL,
+    shipping_price DECIMAL(10,2) NOT NULL,
+    total_price DECIMAL(10,2) NOT NULL,
+    user_id BIGINT UNSIGNED NOT NULL,
+    PRIMARY KEY (id),
+    INDEX idx_orders_user_id (user_id),
+    CONSTRAINT fk_orders_user FOREIGN KEY (user_id) REFERENCES users (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS order_items (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    created_at DATETIME(3) NULL,
+    updated_at DATETIME(3) NULL,
+    name VARCHAR(255) NOT NULL,
+    quantity BIGINT NOT NULL,
+    image VARCHAR(1024) NOT NULL,
+    price DECIMAL(10,2) NOT NULL,
+    product_id BIGINT UNSIGNED NOT NULL,
+    order_id BIGINT UNSIGNED NOT NULL,
+    PRIMARY KEY (id),
+    INDEX idx_order_items_order_id (order_id),
+    CONSTRAINT fk_order_items_product FOREIGN KEY (product_id) REFERENCES products (id),
+    CONSTRAINT fk_order_items_order FOREIGN KEY (order_id) REFERENCES orders (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id VARCHAR(191) NOT NULL,
+    user_email VARCHAR(255) NOT NULL,
+    refresh_token VARCHAR(512) NOT NULL,
+    is_revoked BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at DATETIME(3) NULL,
+    expires_at DATETIME(3) NOT NULL,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
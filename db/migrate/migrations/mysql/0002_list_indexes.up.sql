@@ -0,0 +1,5 @@
+CREATE INDEX idx_products_category ON products (category);
+CREATE INDEX idx_products_price ON products (price);
+CREATE INDEX idx_products_created_at ON products (created_at);
+CREATE INDEX idx_orders_created_at ON orders (created_at);
+CREATE INDEX idx_users_created_at ON users (created_at);
@@ -0,0 +1,3 @@
+DROP TABLE order_status_events;
+DROP INDEX idx_orders_status ON orders;
+ALTER TABLE orders DROP COLUMN status;
@@ -0,0 +1,15 @@
+ALTER TABLE orders ADD COLUMN status VARCHAR(32) NOT NULL DEFAULT 'pending';
+CREATE INDEX idx_orders_status ON orders (status);
+
+CREATE TABLE order_status_events (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    created_at DATETIME(3) NULL,
+    order_id BIGINT UNSIGNED NOT NULL,
+    from_status VARCHAR(32) NOT NULL,
+    to_status VARCHAR(32) NOT NULL,
+    changed_by BIGINT UNSIGNED NULL,
+    note VARCHAR(1024) NOT NULL DEFAULT '',
+    PRIMARY KEY (id),
+    INDEX idx_order_status_events_order_id (order_id),
+    CONSTRAINT fk_order_status_events_order FOREIGN KEY (order_id) REFERENCES orders (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,6 @@
+-- Fails while unclaimed guest orders exist; claim or delete them first.
+DROP INDEX idx_orders_access_token_hash ON orders;
+ALTER TABLE orders DROP COLUMN access_token_hash;
+ALTER TABLE orders DROP COLUMN shipping_address;
+ALTER TABLE orders DROP COLUMN email;
+ALTER TABLE orders MODIFY user_id BIGINT UNSIGNED NOT NULL;
@@ -0,0 +1,5 @@
+ALTER TABLE orders MODIFY user_id BIGINT UNSIGNED NULL;
+ALTER TABLE orders ADD COLUMN email VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN shipping_address TEXT NULL;
+ALTER TABLE orders ADD COLUMN access_token_hash VARCHAR(64) NULL;
+CREATE UNIQUE INDEX idx_orders_access_token_hash ON orders (access_token_hash);
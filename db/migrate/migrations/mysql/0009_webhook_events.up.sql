@@ -0,0 +1,12 @@
+CREATE TABLE webhook_events (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    created_at DATETIME(3) NULL,
+    provider VARCHAR(32) NOT NULL,
+    event_id VARCHAR(255) NOT NULL,
+    type VARCHAR(64) NOT NULL,
+    payment_id BIGINT UNSIGNED NULL,
+    outcome VARCHAR(255) NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE INDEX idx_webhook_events_provider_event (provider, event_id),
+    CONSTRAINT fk_webhook_events_payment FOREIGN KEY (payment_id) REFERENCES payments (id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
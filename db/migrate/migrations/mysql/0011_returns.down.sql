@@ -0,0 +1,3 @@
+DROP TABLE refunds;
+DROP TABLE return_requests;
+ALTER TABLE orders DROP COLUMN refunded_amount;
@@ -0,0 +1,41 @@
+ALTER TABLE orders ADD COLUMN refunded_amount DECIMAL(10,2) NOT NULL DEFAULT 0;
+
+CREATE TABLE return_requests (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    created_at DATETIME(3) NULL,
+    updated_at DATETIME(3) NULL,
+    order_id BIGINT UNSIGNED NOT NULL,
+    order_item_id BIGINT UNSIGNED NOT NULL,
+    quantity BIGINT NOT NULL,
+    reason VARCHAR(32) NOT NULL,
+    comment TEXT NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    reviewed_by BIGINT UNSIGNED NULL,
+    review_note TEXT NOT NULL,
+    PRIMARY KEY (id),
+    INDEX idx_return_requests_order_id (order_id),
+    INDEX idx_return_requests_order_item_id (order_item_id),
+    CONSTRAINT fk_return_requests_order FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE,
+    CONSTRAINT fk_return_requests_order_item FOREIGN KEY (order_item_id) REFERENCES order_items (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE refunds (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    created_at DATETIME(3) NULL,
+    updated_at DATETIME(3) NULL,
+    order_id BIGINT UNSIGNED NOT NULL,
+    return_id BIGINT UNSIGNED NULL,
+    payment_id BIGINT UNSIGNED NULL,
+    amount DECIMAL(10,2) NOT NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    status VARCHAR(16) NOT NULL,
+    provider_refund_id VARCHAR(255) NOT NULL DEFAULT '',
+    failure_reason VARCHAR(255) NOT NULL DEFAULT '',
+    created_by BIGINT UNSIGNED NULL,
+    PRIMARY KEY (id),
+    INDEX idx_refunds_order_id (order_id),
+    INDEX idx_refunds_return_id (return_id),
+    CONSTRAINT fk_refunds_order FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE,
+    CONSTRAINT fk_refunds_return FOREIGN KEY (return_id) REFERENCES return_requests (id) ON DELETE SET NULL,
+    CONSTRAINT fk_refunds_payment FOREIGN KEY (payment_id) REFERENCES payments (id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,4 @@
+ALTER TABLE products DROP COLUMN height;
+ALTER TABLE products DROP COLUMN width;
+ALTER TABLE products DROP COLUMN length;
+ALTER TABLE products DROP COLUMN weight;
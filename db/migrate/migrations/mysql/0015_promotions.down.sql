@@ -0,0 +1,5 @@
+DROP TABLE promotion_redemptions;
+DROP TABLE promotions;
+ALTER TABLE order_items DROP COLUMN discount;
+ALTER TABLE orders DROP COLUMN discounts;
+ALTER TABLE orders DROP COLUMN discount_price;
@@ -0,0 +1,38 @@
+ALTER TABLE orders ADD COLUMN discount_price DECIMAL(10,2) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN discounts TEXT NULL;
+ALTER TABLE order_items ADD COLUMN discount DECIMAL(10,2) NOT NULL DEFAULT 0;
+
+CREATE TABLE promotions (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    created_at DATETIME(3) NULL,
+    updated_at DATETIME(3) NULL,
+    name VARCHAR(255) NOT NULL,
+    code VARCHAR(64) NULL,
+    kind VARCHAR(16) NOT NULL,
+    value DECIMAL(10,2) NOT NULL,
+    buy_quantity BIGINT NOT NULL DEFAULT 0,
+    get_quantity BIGINT NOT NULL DEFAULT 0,
+    min_order DECIMAL(10,2) NOT NULL DEFAULT 0,
+    scope TEXT NULL,
+    starts_at DATETIME(3) NULL,
+    ends_at DATETIME(3) NULL,
+    usage_limit BIGINT NOT NULL DEFAULT 0,
+    per_user_limit BIGINT NOT NULL DEFAULT 0,
+    used_count BIGINT NOT NULL DEFAULT 0,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    PRIMARY KEY (id),
+    UNIQUE INDEX idx_promotions_code (code)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE promotion_redemptions (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    created_at DATETIME(3) NULL,
+    promotion_id BIGINT UNSIGNED NOT NULL,
+    order_id BIGINT UNSIGNED NOT NULL,
+    user_id BIGINT UNSIGNED NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    PRIMARY KEY (id),
+    INDEX idx_promotion_redemptions_promotion_id (promotion_id),
+    CONSTRAINT fk_promotion_redemptions_promotion FOREIGN KEY (promotion_id) REFERENCES promotions (id) ON DELETE CASCADE,
+    CONSTRAINT fk_promotion_redemptions_order FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
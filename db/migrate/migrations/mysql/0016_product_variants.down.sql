@@ -0,0 +1,5 @@
+ALTER TABLE order_items DROP FOREIGN KEY fk_order_items_variant;
+ALTER TABLE order_items DROP COLUMN sku;
+ALTER TABLE order_items DROP COLUMN variant_id;
+DROP TABLE product_variants;
+ALTER TABLE products DROP COLUMN options;
@@ -0,0 +1,22 @@
+ALTER TABLE products ADD COLUMN options TEXT NULL;
+
+CREATE TABLE product_variants (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    created_at DATETIME(3) NULL,
+    updated_at DATETIME(3) NULL,
+    product_id BIGINT UNSIGNED NOT NULL,
+    sku VARCHAR(64) NOT NULL,
+    options TEXT NULL,
+    price DECIMAL(10,2) NULL,
+    count_in_stock BIGINT NOT NULL DEFAULT 0,
+    image VARCHAR(255) NOT NULL DEFAULT '',
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    PRIMARY KEY (id),
+    UNIQUE INDEX idx_product_variants_sku (sku),
+    INDEX idx_product_variants_product_id (product_id),
+    CONSTRAINT fk_product_variants_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+ALTER TABLE order_items ADD COLUMN variant_id BIGINT UNSIGNED NULL;
+ALTER TABLE order_items ADD COLUMN sku VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE order_items ADD CONSTRAINT fk_order_items_variant FOREIGN KEY (variant_id) REFERENCES product_variants (id) ON DELETE SET NULL;
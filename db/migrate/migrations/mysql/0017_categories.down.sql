@@ -0,0 +1,4 @@
+ALTER TABLE products DROP FOREIGN KEY fk_products_category;
+ALTER TABLE products DROP INDEX idx_products_category_id;
+ALTER TABLE products DROP COLUMN category_id;
+DROP TABLE categories;
@@ -0,0 +1,20 @@
+CREATE TABLE categories (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    created_at DATETIME(3) NULL,
+    updated_at DATETIME(3) NULL,
+    parent_id BIGINT UNSIGNED NULL,
+    name VARCHAR(255) NOT NULL,
+    slug VARCHAR(128) NOT NULL,
+    position BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (id),
+    UNIQUE INDEX idx_categories_slug (slug),
+    INDEX idx_categories_parent_id (parent_id),
+    CONSTRAINT fk_categories_parent FOREIGN KEY (parent_id) REFERENCES categories (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+ALTER TABLE products ADD COLUMN category_id BIGINT UNSIGNED NULL;
+ALTER TABLE products ADD INDEX idx_products_category_id (category_id);
+ALTER TABLE products ADD CONSTRAINT fk_products_category FOREIGN KEY (category_id) REFERENCES categories (id);
+
+-- the existing product categories are turned into categories by
+-- backfillCategories once these statements have run
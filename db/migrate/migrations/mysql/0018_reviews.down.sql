@@ -0,0 +1,2 @@
+ALTER TABLE products MODIFY COLUMN rating BIGINT NOT NULL;
+DROP TABLE reviews;
@@ -0,0 +1,23 @@
+CREATE TABLE reviews (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    created_at DATETIME(3) NULL,
+    updated_at DATETIME(3) NULL,
+    product_id BIGINT UNSIGNED NOT NULL,
+    user_id BIGINT UNSIGNED NOT NULL,
+    rating TINYINT NOT NULL,
+    title VARCHAR(255) NOT NULL DEFAULT '',
+    body TEXT NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    moderated_by BIGINT UNSIGNED NULL,
+    PRIMARY KEY (id),
+    UNIQUE INDEX idx_reviews_product_user (product_id, user_id),
+    INDEX idx_reviews_user_id (user_id),
+    INDEX idx_reviews_status (status),
+    CONSTRAINT fk_reviews_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE,
+    CONSTRAINT fk_reviews_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT fk_reviews_moderated_by FOREIGN KEY (moderated_by) REFERENCES users (id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- ratings now come from approved reviews only, the hand-entered ones go
+ALTER TABLE products MODIFY COLUMN rating DECIMAL(3,2) NOT NULL DEFAULT 0;
+UPDATE products SET rating = 0, num_reviews = 0;
@@ -0,0 +1,24 @@
+CREATE TABLE product_images (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    created_at DATETIME(3) NULL,
+    updated_at DATETIME(3) NULL,
+    product_id BIGINT UNSIGNED NOT NULL,
+    blob_key VARCHAR(255) NOT NULL DEFAULT '',
+    url VARCHAR(1024) NOT NULL,
+    thumbnail_key VARCHAR(255) NOT NULL DEFAULT '',
+    thumbnail_url VARCHAR(1024) NOT NULL DEFAULT '',
+    content_type VARCHAR(64) NOT NULL DEFAULT '',
+    size BIGINT NOT NULL DEFAULT 0,
+    width BIGINT NOT NULL DEFAULT 0,
+    height BIGINT NOT NULL DEFAULT 0,
+    alt_text VARCHAR(255) NOT NULL DEFAULT '',
+    position BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (id),
+    INDEX idx_product_images_product_position (product_id, position),
+    CONSTRAINT fk_product_images_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- the hand-hosted image of every product becomes its first image
+INSERT INTO product_images (created_at, updated_at, product_id, url, alt_text, position)
+SELECT CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, id, image, LEFT(name, 255), 0
+FROM products WHERE image <> '';
@@ -0,0 +1,6 @@
+-- lines of variants can't be told apart without the column, so they go
+DELETE FROM cart_items WHERE variant_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_cart_items_cart_product ON cart_items (cart_id, product_id);
+DROP INDEX idx_cart_items_cart_line ON cart_items;
+ALTER TABLE cart_items DROP FOREIGN KEY fk_cart_items_variant;
+ALTER TABLE cart_items DROP COLUMN variant_id;
@@ -0,0 +1,6 @@
+ALTER TABLE cart_items ADD COLUMN variant_id BIGINT UNSIGNED NULL;
+ALTER TABLE cart_items ADD CONSTRAINT fk_cart_items_variant FOREIGN KEY (variant_id) REFERENCES product_variants (id);
+
+-- a cart line is a product, or one variant of it
+CREATE UNIQUE INDEX idx_cart_items_cart_line ON cart_items (cart_id, product_id, (COALESCE(variant_id, 0)));
+DROP INDEX idx_cart_items_cart_product ON cart_items;
@@ -0,0 +1,60 @@
+-- Baseline schema. IF NOT EXISTS lets databases that were created by hand
+-- before migrations existed adopt this version without changes.
+CREATE TABLE IF NOT EXISTS products (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    name TEXT NOT NULL,
+    image TEXT NOT NULL,
+    category TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    rating BIGINT NOT NULL,
+    num_reviews BIGINT NOT NULL DEFAULT 0,
+    price NUMERIC(10,2) NOT NULL,
+    count_in_stock BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL,
+    password TEXT NOT NULL,
+    is_admin BOOLEAN NOT NULL DEFAULT FALSE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    payment_method TEXT NOT NULL,
+    tax_price NUMERIC(10,2) NOT NULL,
+    shipping_price NUMERIC(10,2) NOT NULL,
+    total_price NUMERIC(10,2) NOT NULL,
+    user_id BIGINT NOT NULL REFERENCES users (id)
+);
+CREATE INDEX IF NOT EXISTS idx_orders_user_id ON orders (user_id);
+
+CREATE TABLE IF NOT EXISTS order_items (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    name TEXT NOT NULL,
+    quantity BIGINT NOT NULL,
+    image TEXT NOT NULL,
+    price NUMERIC(10,2) NOT NULL,
+    product_id BIGINT NOT NULL REFERENCES products (id),
+    order_id BIGINT NOT NULL REFERENCES orders (id)
+);
+CREATE INDEX IF NOT EXISTS idx_order_items_order_id ON order_items (order_id);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id TEXT PRIMARY KEY,
+    user_email TEXT NOT NULL,
+    refresh_token VARCHAR(512) NOT NULL,
+    is_revoked BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL
+);
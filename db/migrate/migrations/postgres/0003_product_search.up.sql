@@ -0,0 +1,2 @@
+-- The expression must match searchVector in storer_pq.
+CREATE INDEX IF NOT EXISTS idx_products_search ON products USING GIN ((setweight(to_tsvector('simple', name), 'A') || setweight(to_tsvector('simple', category), 'B') || setweight(to_tsvector('simple', coalesce(description, '')), 'C')));
@@ -0,0 +1,2 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS items_price;
+ALTER TABLE products DROP COLUMN IF EXISTS is_active;
@@ -0,0 +1,13 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'pending';
+CREATE INDEX IF NOT EXISTS idx_orders_status ON orders (status);
+
+CREATE TABLE IF NOT EXISTS order_status_events (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    order_id BIGINT NOT NULL REFERENCES orders (id),
+    from_status TEXT NOT NULL,
+    to_status TEXT NOT NULL,
+    changed_by BIGINT,
+    note TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_order_status_events_order_id ON order_status_events (order_id);
@@ -0,0 +1,6 @@
+-- Fails while unclaimed guest orders exist; claim or delete them first.
+DROP INDEX IF EXISTS idx_orders_access_token_hash;
+ALTER TABLE orders DROP COLUMN IF EXISTS access_token_hash;
+ALTER TABLE orders DROP COLUMN IF EXISTS shipping_address;
+ALTER TABLE orders DROP COLUMN IF EXISTS email;
+ALTER TABLE orders ALTER COLUMN user_id SET NOT NULL;
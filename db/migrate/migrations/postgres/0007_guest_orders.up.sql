@@ -0,0 +1,5 @@
+ALTER TABLE orders ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS email TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_address TEXT;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS access_token_hash TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_orders_access_token_hash ON orders (access_token_hash);
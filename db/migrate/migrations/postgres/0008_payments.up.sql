@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS payments (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    order_id BIGINT NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    provider TEXT NOT NULL,
+    intent_id TEXT NOT NULL,
+    capture_id TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL,
+    amount NUMERIC(10,2) NOT NULL,
+    currency TEXT NOT NULL,
+    failure_reason TEXT NOT NULL DEFAULT '',
+    UNIQUE (provider, intent_id)
+);
+CREATE INDEX IF NOT EXISTS idx_payments_order_id ON payments (order_id);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS webhook_events (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    provider TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    type TEXT NOT NULL,
+    payment_id BIGINT REFERENCES payments (id) ON DELETE SET NULL,
+    outcome TEXT NOT NULL,
+    UNIQUE (provider, event_id)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS tax_breakdown;
+ALTER TABLE order_items DROP COLUMN IF EXISTS tax;
+ALTER TABLE products DROP COLUMN IF EXISTS tax_category;
@@ -0,0 +1,34 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS discount_price NUMERIC(10,2) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS discounts TEXT;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS discount NUMERIC(10,2) NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS promotions (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    name TEXT NOT NULL,
+    code TEXT,
+    kind TEXT NOT NULL,
+    value NUMERIC(10,2) NOT NULL,
+    buy_quantity BIGINT NOT NULL DEFAULT 0,
+    get_quantity BIGINT NOT NULL DEFAULT 0,
+    min_order NUMERIC(10,2) NOT NULL DEFAULT 0,
+    scope TEXT,
+    starts_at TIMESTAMPTZ,
+    ends_at TIMESTAMPTZ,
+    usage_limit BIGINT NOT NULL DEFAULT 0,
+    per_user_limit BIGINT NOT NULL DEFAULT 0,
+    used_count BIGINT NOT NULL DEFAULT 0,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_promotions_code ON promotions (code);
+
+CREATE TABLE IF NOT EXISTS promotion_redemptions (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    promotion_id BIGINT NOT NULL REFERENCES promotions (id) ON DELETE CASCADE,
+    order_id BIGINT NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    user_id BIGINT,
+    email TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_promotion_redemptions_promotion_id ON promotion_redemptions (promotion_id);
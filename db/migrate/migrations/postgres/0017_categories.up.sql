@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS categories (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    parent_id BIGINT REFERENCES categories (id),
+    name TEXT NOT NULL,
+    slug TEXT NOT NULL,
+    position BIGINT NOT NULL DEFAULT 0
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_categories_slug ON categories (slug);
+CREATE INDEX IF NOT EXISTS idx_categories_parent_id ON categories (parent_id);
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS category_id BIGINT REFERENCES categories (id);
+CREATE INDEX IF NOT EXISTS idx_products_category_id ON products (category_id);
+
+-- the existing product categories are turned into categories by
+-- backfillCategories once these statements have run
@@ -0,0 +1,3 @@
+ALTER TABLE products ALTER COLUMN rating DROP DEFAULT;
+ALTER TABLE products ALTER COLUMN rating TYPE BIGINT USING ROUND(rating);
+DROP TABLE IF EXISTS reviews;
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS reviews (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    product_id BIGINT NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    rating SMALLINT NOT NULL,
+    title TEXT NOT NULL DEFAULT '',
+    body TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'pending',
+    moderated_by BIGINT REFERENCES users (id) ON DELETE SET NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reviews_product_user ON reviews (product_id, user_id);
+CREATE INDEX IF NOT EXISTS idx_reviews_user_id ON reviews (user_id);
+CREATE INDEX IF NOT EXISTS idx_reviews_status ON reviews (status);
+
+-- ratings now come from approved reviews only, the hand-entered ones go
+ALTER TABLE products ALTER COLUMN rating TYPE NUMERIC(3,2);
+ALTER TABLE products ALTER COLUMN rating SET DEFAULT 0;
+UPDATE products SET rating = 0, num_reviews = 0;
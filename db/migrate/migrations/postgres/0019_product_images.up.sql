@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS product_images (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    product_id BIGINT NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    blob_key TEXT NOT NULL DEFAULT '',
+    url TEXT NOT NULL,
+    thumbnail_key TEXT NOT NULL DEFAULT '',
+    thumbnail_url TEXT NOT NULL DEFAULT '',
+    content_type TEXT NOT NULL DEFAULT '',
+    size BIGINT NOT NULL DEFAULT 0,
+    width BIGINT NOT NULL DEFAULT 0,
+    height BIGINT NOT NULL DEFAULT 0,
+    alt_text TEXT NOT NULL DEFAULT '',
+    position BIGINT NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_product_images_product_position ON product_images (product_id, position);
+
+-- the hand-hosted image of every product becomes its first image
+INSERT INTO product_images (created_at, updated_at, product_id, url, alt_text, position)
+SELECT CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, id, image, name, 0
+FROM products WHERE image <> '';
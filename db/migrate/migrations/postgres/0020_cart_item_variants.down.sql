@@ -0,0 +1,5 @@
+-- lines of variants can't be told apart without the column, so they go
+DROP INDEX IF EXISTS idx_cart_items_cart_line;
+DELETE FROM cart_items WHERE variant_id IS NOT NULL;
+ALTER TABLE cart_items DROP COLUMN IF EXISTS variant_id;
+ALTER TABLE cart_items ADD CONSTRAINT cart_items_cart_id_product_id_key UNIQUE (cart_id, product_id);
@@ -0,0 +1,6 @@
+ALTER TABLE cart_items ADD COLUMN IF NOT EXISTS variant_id BIGINT REFERENCES product_variants (id) ON DELETE CASCADE;
+
+-- a cart line is a product, or one variant of it. The upserts in the stores
+-- name this expression as their conflict target.
+ALTER TABLE cart_items DROP CONSTRAINT IF EXISTS cart_items_cart_id_product_id_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cart_items_cart_line ON cart_items (cart_id, product_id, (COALESCE(variant_id, 0)));
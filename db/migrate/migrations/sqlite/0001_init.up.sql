@@ -0,0 +1,58 @@
+CREATE TABLE IF NOT EXISTS products (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    name TEXT NOT NULL,
+    image TEXT NOT NULL,
+    category TEXT NOT NULL,
+    description TEXT,
+    rating INTEGER NOT NULL,
+    num_reviews INTEGER NOT NULL DEFAULT 0,
+    price DECIMAL(10,2) NOT NULL,
+    count_in_stock INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL,
+    password TEXT NOT NULL,
+    is_admin BOOLEAN NOT NULL DEFAULT 0
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    payment_method TEXT NOT NULL,
+    tax_price DECIMAL(10,2) NOT NULL,
+    shipping_price DECIMAL(10,2) NOT NULL,
+    total_price DECIMAL(10,2) NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users (id)
+);
+CREATE INDEX IF NOT EXISTS idx_orders_user_id ON orders (user_id);
+
+CREATE TABLE IF NOT EXISTS order_items (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    name TEXT NOT NULL,
+    quantity INTEGER NOT NULL,
+    image TEXT NOT NULL,
+    price DECIMAL(10,2) NOT NULL,
+    product_id INTEGER NOT NULL REFERENCES products (id),
+    order_id INTEGER NOT NULL REFERENCES orders (id)
+);
+CREATE INDEX IF NOT EXISTS idx_order_items_order_id ON order_items (order_id);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id TEXT PRIMARY KEY,
+    user_email TEXT NOT NULL,
+    refresh_token VARCHAR(512) NOT NULL,
+    is_revoked BOOLEAN NOT NULL DEFAULT 0,
+    created_at DATETIME,
+    expires_at DATETIME NOT NULL
+);
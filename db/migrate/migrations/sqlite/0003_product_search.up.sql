@@ -0,0 +1,2 @@
+-- SQLite uses the built-in search index; nothing to create. The migration
+-- exists to keep versions aligned across dialects.
@@ -0,0 +1,2 @@
+ALTER TABLE orders DROP COLUMN items_price;
+ALTER TABLE products DROP COLUMN is_active;
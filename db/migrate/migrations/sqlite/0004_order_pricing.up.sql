@@ -0,0 +1,2 @@
+ALTER TABLE products ADD COLUMN is_active BOOLEAN NOT NULL DEFAULT 1;
+ALTER TABLE orders ADD COLUMN items_price DECIMAL(10,2) NOT NULL DEFAULT 0;
@@ -0,0 +1,13 @@
+ALTER TABLE orders ADD COLUMN status TEXT NOT NULL DEFAULT 'pending';
+CREATE INDEX IF NOT EXISTS idx_orders_status ON orders (status);
+
+CREATE TABLE IF NOT EXISTS order_status_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    order_id INTEGER NOT NULL REFERENCES orders (id),
+    from_status TEXT NOT NULL,
+    to_status TEXT NOT NULL,
+    changed_by INTEGER,
+    note TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_order_status_events_order_id ON order_status_events (order_id);
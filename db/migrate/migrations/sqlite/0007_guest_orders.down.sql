@@ -0,0 +1,20 @@
+-- Fails while unclaimed guest orders exist; claim or delete them first.
+CREATE TABLE orders_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    payment_method TEXT NOT NULL,
+    tax_price DECIMAL(10,2) NOT NULL,
+    shipping_price DECIMAL(10,2) NOT NULL,
+    total_price DECIMAL(10,2) NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users (id),
+    items_price DECIMAL(10,2) NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'pending'
+);
+INSERT INTO orders_old (id, created_at, updated_at, payment_method, tax_price, shipping_price, total_price, user_id, items_price, status)
+    SELECT id, created_at, updated_at, payment_method, tax_price, shipping_price, total_price, user_id, items_price, status FROM orders;
+DROP TABLE orders;
+ALTER TABLE orders_old RENAME TO orders;
+CREATE INDEX IF NOT EXISTS idx_orders_user_id ON orders (user_id);
+CREATE INDEX IF NOT EXISTS idx_orders_created_at ON orders (created_at);
+CREATE INDEX IF NOT EXISTS idx_orders_status ON orders (status);
@@ -0,0 +1,24 @@
+-- SQLite cannot drop NOT NULL from a column, so orders is rebuilt.
+CREATE TABLE orders_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    payment_method TEXT NOT NULL,
+    tax_price DECIMAL(10,2) NOT NULL,
+    shipping_price DECIMAL(10,2) NOT NULL,
+    total_price DECIMAL(10,2) NOT NULL,
+    user_id INTEGER REFERENCES users (id),
+    items_price DECIMAL(10,2) NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'pending',
+    email TEXT NOT NULL DEFAULT '',
+    shipping_address TEXT,
+    access_token_hash TEXT
+);
+INSERT INTO orders_new (id, created_at, updated_at, payment_method, tax_price, shipping_price, total_price, user_id, items_price, status)
+    SELECT id, created_at, updated_at, payment_method, tax_price, shipping_price, total_price, user_id, items_price, status FROM orders;
+DROP TABLE orders;
+ALTER TABLE orders_new RENAME TO orders;
+CREATE INDEX IF NOT EXISTS idx_orders_user_id ON orders (user_id);
+CREATE INDEX IF NOT EXISTS idx_orders_created_at ON orders (created_at);
+CREATE INDEX IF NOT EXISTS idx_orders_status ON orders (status);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_orders_access_token_hash ON orders (access_token_hash);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS payments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    order_id INTEGER NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    provider TEXT NOT NULL,
+    intent_id TEXT NOT NULL,
+    capture_id TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL,
+    amount DECIMAL(10,2) NOT NULL,
+    currency TEXT NOT NULL,
+    failure_reason TEXT NOT NULL DEFAULT ''
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_provider_intent ON payments (provider, intent_id);
+CREATE INDEX IF NOT EXISTS idx_payments_order_id ON payments (order_id);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS webhook_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    provider TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    type TEXT NOT NULL,
+    payment_id INTEGER REFERENCES payments (id) ON DELETE SET NULL,
+    outcome TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_webhook_events_provider_event ON webhook_events (provider, event_id);
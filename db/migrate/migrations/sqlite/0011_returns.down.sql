@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS refunds;
+DROP TABLE IF EXISTS return_requests;
+ALTER TABLE orders DROP COLUMN refunded_amount;
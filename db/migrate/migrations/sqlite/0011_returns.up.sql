@@ -0,0 +1,34 @@
+ALTER TABLE orders ADD COLUMN refunded_amount DECIMAL(10,2) NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS return_requests (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    order_id INTEGER NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    order_item_id INTEGER NOT NULL REFERENCES order_items (id) ON DELETE CASCADE,
+    quantity INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    comment TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL,
+    reviewed_by INTEGER,
+    review_note TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_return_requests_order_id ON return_requests (order_id);
+CREATE INDEX IF NOT EXISTS idx_return_requests_order_item_id ON return_requests (order_item_id);
+
+CREATE TABLE IF NOT EXISTS refunds (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    order_id INTEGER NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    return_id INTEGER REFERENCES return_requests (id) ON DELETE SET NULL,
+    payment_id INTEGER REFERENCES payments (id) ON DELETE SET NULL,
+    amount DECIMAL(10,2) NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL,
+    provider_refund_id TEXT NOT NULL DEFAULT '',
+    failure_reason TEXT NOT NULL DEFAULT '',
+    created_by INTEGER
+);
+CREATE INDEX IF NOT EXISTS idx_refunds_order_id ON refunds (order_id);
+CREATE INDEX IF NOT EXISTS idx_refunds_return_id ON refunds (return_id);
@@ -0,0 +1,4 @@
+ALTER TABLE products ADD COLUMN weight DECIMAL(10,3) NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN length DECIMAL(10,2) NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN width DECIMAL(10,2) NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN height DECIMAL(10,2) NOT NULL DEFAULT 0;
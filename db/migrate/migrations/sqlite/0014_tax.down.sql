@@ -0,0 +1,3 @@
+ALTER TABLE orders DROP COLUMN tax_breakdown;
+ALTER TABLE order_items DROP COLUMN tax;
+ALTER TABLE products DROP COLUMN tax_category;
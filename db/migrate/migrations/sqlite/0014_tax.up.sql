@@ -0,0 +1,3 @@
+ALTER TABLE products ADD COLUMN tax_category VARCHAR(32) NOT NULL DEFAULT 'standard';
+ALTER TABLE order_items ADD COLUMN tax DECIMAL(10,2) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN tax_breakdown TEXT;
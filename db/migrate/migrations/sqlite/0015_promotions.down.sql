@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS promotion_redemptions;
+DROP TABLE IF EXISTS promotions;
+ALTER TABLE order_items DROP COLUMN discount;
+ALTER TABLE orders DROP COLUMN discounts;
+ALTER TABLE orders DROP COLUMN discount_price;
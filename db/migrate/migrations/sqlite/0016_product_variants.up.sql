@@ -0,0 +1,19 @@
+ALTER TABLE products ADD COLUMN options TEXT;
+
+CREATE TABLE IF NOT EXISTS product_variants (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    product_id INTEGER NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    sku TEXT NOT NULL,
+    options TEXT,
+    price DECIMAL(10,2),
+    count_in_stock INTEGER NOT NULL DEFAULT 0,
+    image TEXT NOT NULL DEFAULT '',
+    is_active BOOLEAN NOT NULL DEFAULT 1
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_product_variants_sku ON product_variants (sku);
+CREATE INDEX IF NOT EXISTS idx_product_variants_product_id ON product_variants (product_id);
+
+ALTER TABLE order_items ADD COLUMN variant_id INTEGER;
+ALTER TABLE order_items ADD COLUMN sku TEXT NOT NULL DEFAULT '';
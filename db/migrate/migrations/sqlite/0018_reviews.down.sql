@@ -0,0 +1,2 @@
+UPDATE products SET rating = ROUND(rating);
+DROP TABLE IF EXISTS reviews;
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS product_images (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME,
+    updated_at DATETIME,
+    product_id INTEGER NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    blob_key TEXT NOT NULL DEFAULT '',
+    url TEXT NOT NULL,
+    thumbnail_key TEXT NOT NULL DEFAULT '',
+    thumbnail_url TEXT NOT NULL DEFAULT '',
+    content_type TEXT NOT NULL DEFAULT '',
+    size INTEGER NOT NULL DEFAULT 0,
+    width INTEGER NOT NULL DEFAULT 0,
+    height INTEGER NOT NULL DEFAULT 0,
+    alt_text TEXT NOT NULL DEFAULT '',
+    position INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_product_images_product_position ON product_images (product_id, position);
+
+-- the hand-hosted image of every product becomes its first image
+INSERT INTO product_images (created_at, updated_at, product_id, url, alt_text, position)
+SELECT CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, id, image, name, 0
+FROM products WHERE image <> '';
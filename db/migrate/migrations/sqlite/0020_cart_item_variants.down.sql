@@ -0,0 +1,5 @@
+-- lines of variants can't be told apart without the column, so they go
+DROP INDEX IF EXISTS idx_cart_items_cart_line;
+DELETE FROM cart_items WHERE variant_id IS NOT NULL;
+ALTER TABLE cart_items DROP COLUMN variant_id;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cart_items_cart_product ON cart_items (cart_id, product_id);
@@ -0,0 +1,6 @@
+ALTER TABLE cart_items ADD COLUMN variant_id INTEGER;
+
+-- a cart line is a product, or one variant of it. The upserts in the stores
+-- name this expression as their conflict target.
+DROP INDEX IF EXISTS idx_cart_items_cart_product;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cart_items_cart_line ON cart_items (cart_id, product_id, COALESCE(variant_id, 0));
@@ -0,0 +1,101 @@
+// Package address checks shipping addresses against the countries the shop
+// ships to and the postal code formats of those countries.
+package address
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/storer"
+)
+
+var ErrInvalidAddress = errors.New("invalid address")
+
+// Error names the address field that was rejected.
+type Error struct {
+	Field  string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s", e.Field, e.Reason)
+}
+
+func (e *Error) Is(target error) bool {
+	return target == ErrInvalidAddress
+}
+
+// postalCodes are the built-in postal code formats, matched after
+// Normalize upper-cases the code.
+var postalCodes = map[string]string{
+	"AU": `^\d{4}$`,
+	"CA": `^[A-Z]\d[A-Z] ?\d[A-Z]\d$`,
+	"DE": `^\d{5}$`,
+	"ES": `^\d{5}$`,
+	"FR": `^\d{5}$`,
+	"GB": `^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`,
+	"ID": `^\d{5}$`,
+	"IN": `^\d{6}$`,
+	"IT": `^\d{5}$`,
+	"JP": `^\d{3}-?\d{4}$`,
+	"NL": `^\d{4} ?[A-Z]{2}$`,
+	"US": `^\d{5}(-\d{4})?$`,
+}
+
+type Validator struct {
+	// countries is nil when every country is accepted.
+	countries   map[string]bool
+	postalCodes map[string]*regexp.Regexp
+}
+
+// NewValidator builds a Validator from a validated cfg; it panics on a
+// postal code pattern that does not compile.
+func NewValidator(cfg config.AddressConfig) *Validator {
+	v := &Validator{postalCodes: make(map[string]*regexp.Regexp)}
+	if len(cfg.Countries) > 0 {
+		v.countries = make(map[string]bool, len(cfg.Countries))
+		for _, c := range cfg.Countries {
+			v.countries[c] = true
+		}
+	}
+	for c, pattern := range postalCodes {
+		v.postalCodes[c] = regexp.MustCompile(pattern)
+	}
+	for c, pattern := range cfg.PostalCodes {
+		v.postalCodes[c] = regexp.MustCompile(pattern)
+	}
+	return v
+}
+
+// Normalize tidies a, trimming every field and upper-casing the country
+// and postal code, and checks the result. The tidied address is returned
+// so it is what gets stored.
+func (v *Validator) Normalize(a storer.ShippingAddress) (storer.ShippingAddress, error) {
+	a.Name = strings.TrimSpace(a.Name)
+	a.Line1 = strings.TrimSpace(a.Line1)
+	a.Line2 = strings.TrimSpace(a.Line2)
+	a.City = strings.TrimSpace(a.City)
+	a.PostalCode = strings.ToUpper(strings.Join(strings.Fields(a.PostalCode), " "))
+	a.Country = strings.ToUpper(strings.TrimSpace(a.Country))
+
+	switch {
+	case a.Name == "":
+		return a, &Error{Field: "name", Reason: "is required"}
+	case a.Line1 == "":
+		return a, &Error{Field: "line1", Reason: "is required"}
+	case a.City == "":
+		return a, &Error{Field: "city", Reason: "is required"}
+	case a.PostalCode == "":
+		return a, &Error{Field: "postal_code", Reason: "is required"}
+	}
+	if v.countries != nil && !v.countries[a.Country] {
+		return a, &Error{Field: "country", Reason: fmt.Sprintf("%q is not a country we ship to", a.Country)}
+	}
+	if re, ok := v.postalCodes[a.Country]; ok && !re.MatchString(a.PostalCode) {
+		return a, &Error{Field: "postal_code", Reason: fmt.Sprintf("%q is not a valid postal code for %s", a.PostalCode, a.Country)}
+	}
+	return a, nil
+}
@@ -0,0 +1,52 @@
+package address
+
+import (
+	"errors"
+	"testing"
+
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/storer"
+)
+
+func TestNormalize(t *testing.T) {
+	v := NewValidator(config.AddressConfig{
+		Countries:   []string{"US", "GB", "NZ"},
+		PostalCodes: map[string]string{"US": `^\d{5}$`},
+	})
+	base := storer.ShippingAddress{Name: "Buyer", Line1: "1 Main St", City: "Springfield"}
+
+	tests := []struct {
+		country, postalCode string
+		want                string
+		field               string
+	}{
+		{country: "us", postalCode: " 12345 ", want: "12345"},
+		{country: "GB", postalCode: "sw1a  1aa", want: "SW1A 1AA"},
+		// no built-in pattern, anything goes
+		{country: "NZ", postalCode: "x", want: "X"},
+		// the configured pattern replaces the built-in one
+		{country: "US", postalCode: "12345-6789", field: "postal_code"},
+		{country: "GB", postalCode: "12345", field: "postal_code"},
+		{country: "DE", postalCode: "10115", field: "country"},
+		{country: "US", postalCode: "", field: "postal_code"},
+	}
+	for _, tt := range tests {
+		a := base
+		a.Country, a.PostalCode = tt.country, tt.postalCode
+		got, err := v.Normalize(a)
+		if tt.field != "" {
+			var aerr *Error
+			if !errors.As(err, &aerr) || aerr.Field != tt.field || !errors.Is(err, ErrInvalidAddress) {
+				t.Errorf("Normalize(%s %q) error = %v, want a %s error", tt.country, tt.postalCode, err, tt.field)
+			}
+			continue
+		}
+		if err != nil || got.PostalCode != tt.want {
+			t.Errorf("Normalize(%s %q) = %q, %v, want %q", tt.country, tt.postalCode, got.PostalCode, err, tt.want)
+		}
+	}
+
+	if _, err := NewValidator(config.AddressConfig{}).Normalize(storer.ShippingAddress{Name: "Buyer", Line1: "1 Rue", City: "Paris", PostalCode: "75001", Country: "FR"}); err != nil {
+		t.Errorf("an empty country list should accept every country: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+// Package blob keeps uploaded files such as product images. Blobs are
+// addressed by keys, slash separated paths like products/7/3f2a.jpg, and
+// clients fetch them from the URL of their key rather than through the
+// API's JSON endpoints.
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"ecom_apiv1/config"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// Store is where blobs are kept. Local and S3 implement it.
+type Store interface {
+	// Put stores the size bytes read from r under key, replacing the blob
+	// already there.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get fails with ErrNotFound when nothing is stored under key.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob under key; a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL is where clients fetch the blob under key from.
+	URL(key string) string
+}
+
+// FromConfig opens the store cfg selects.
+func FromConfig(cfg config.BlobConfig) (Store, error) {
+	switch cfg.Backend {
+	case config.BlobLocal:
+		return NewLocal(cfg.Local.Dir, cfg.Local.BaseURL)
+	case config.BlobS3:
+		return NewS3(cfg.S3, &http.Client{Timeout: 60 * time.Second}), nil
+	default:
+		return nil, fmt.Errorf("unknown blob backend %q", cfg.Backend)
+	}
+}
+
+// checkKey rejects keys that could reach outside the store: empty and
+// absolute keys, keys with empty, . or .. segments and keys with
+// characters other than letters, digits and -_./.
+func checkKey(key string) error {
+	if key == "" {
+		return ErrInvalidKey
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.', r == '/':
+		default:
+			return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+	return nil
+}
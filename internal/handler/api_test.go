@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/payment"
+	"ecom_apiv1/internal/pricing"
+	"ecom_apiv1/internal/server"
+	"ecom_apiv1/internal/storer"
+	"ecom_apiv1/token"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// testAPI is the whole HTTP API over a memory store.
+type testAPI struct {
+	t       *testing.T
+	router  *mux.Router
+	srv     *server.Server
+	store   *storer.MemoryStorage
+	tokens  *token.JWTMaker
+	refunds *recordingRefunds
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	return newPaymentsAPI(t, nil)
+}
+
+// newPaymentsAPI is the API taking payments with the providers of
+// payments. Refunds are still recorded rather than sent to them.
+func newPaymentsAPI(t *testing.T, payments *payment.Registry) *testAPI {
+	t.Helper()
+	store := storer.NewMemoryStorage()
+	srv := server.NewServer(store, pricing.NewCalculator(config.PricingConfig{}), payments)
+	refunds := &recordingRefunds{}
+	srv.SetRefundExecutor(refunds)
+	tokens := token.NewJWTMaker("test-secret-key-that-is-long-enough-for-signing", time.Hour, time.Hour)
+	return &testAPI{
+		t:       t,
+		router:  RegisterRoutes(NewHandler(srv, tokens, config.IdempotencyConfig{TTL: time.Hour, Lease: time.Minute})),
+		srv:     srv,
+		store:   store,
+		tokens:  tokens,
+		refunds: refunds,
+	}
+}
+
+// user creates a user and returns it with an access token.
+func (a *testAPI) user(email string, admin bool) (*storer.User, string) {
+	a.t.Helper()
+	u, err := a.store.CreateUser(context.Background(), &storer.User{Name: email, Email: email, Password: "x", IsAdmin: admin})
+	if err != nil {
+		a.t.Fatalf("CreateUser: %v", err)
+	}
+	tok, _, err := a.tokens.CreateToken(u.ID, u.Email, u.IsAdmin, time.Hour)
+	if err != nil {
+		a.t.Fatalf("CreateToken: %v", err)
+	}
+	return u, tok
+}
+
+// order places an order of one product for the user, or for a guest when
+// userID is nil, and moves it through statuses.
+func (a *testAPI) order(userID *uint, statuses ...storer.OrderStatus) *storer.Order {
+	a.t.Helper()
+	ctx := context.Background()
+	p, err := a.store.CreateProduct(ctx, &storer.Product{Name: "Lamp", Price: 40, CountInStock: 10, IsActive: true})
+	if err != nil {
+		a.t.Fatalf("CreateProduct: %v", err)
+	}
+	o, err := a.srv.CreateOrder(ctx, &storer.Order{UserID: userID, Email: "guest@example.com", PaymentMethod: "Cash", Items: []storer.OrderItem{{ProductID: p.ID, Quantity: 1}}})
+	if err != nil {
+		a.t.Fatalf("CreateOrder: %v", err)
+	}
+	for _, status := range statuses {
+		if o, err = a.store.UpdateOrderStatus(ctx, o.ID, storer.StatusChange{To: status}); err != nil {
+			a.t.Fatalf("UpdateOrderStatus(%s): %v", status, err)
+		}
+	}
+	return o
+}
+
+func (a *testAPI) request(method, path, tok string, body any) *http.Request {
+	a.t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			a.t.Fatalf("encode body: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if tok != "" {
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+	return req
+}
+
+func (a *testAPI) serve(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	a.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func (a *testAPI) do(method, path, tok string, body any) *httptest.ResponseRecorder {
+	a.t.Helper()
+	return a.serve(a.request(method, path, tok, body))
+}
+
+// decode reads a JSON response into v after checking its status.
+func decode(t *testing.T, rec *httptest.ResponseRecorder, status int, v any) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, status, rec.Body.String())
+	}
+	if v != nil {
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+	}
+}
+
+// recordingRefunds pays out every refund and remembers the amounts.
+type recordingRefunds struct {
+	paid []float64
+}
+
+func (r *recordingRefunds) ExecuteRefund(ctx context.Context, o *storer.Order, p *storer.Payment, amount float64) (*payment.Refund, error) {
+	r.paid = append(r.paid, amount)
+	return &payment.Refund{ID: fmt.Sprintf("re_%d", len(r.paid)), Status: payment.RefundSucceeded, Amount: payment.ToMinor(amount)}, nil
+}
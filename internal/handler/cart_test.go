@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestCartVariantLines(t *testing.T) {
+	a := newTestAPI(t)
+	ctx := context.Background()
+	shirt, err := a.srv.CreateProduct(ctx, &storer.Product{Name: "Shirt", Price: 20, IsActive: true, Options: storer.ProductOptions{{Name: "Size", Values: []string{"S", "M"}}}})
+	if err != nil {
+		t.Fatalf("CreateProduct: %v", err)
+	}
+	small, _ := a.srv.CreateVariant(ctx, &storer.ProductVariant{ProductID: shirt.ID, SKU: "SHIRT-S", Options: storer.VariantOptions{"Size": "S"}, CountInStock: 5, IsActive: true})
+	medium, _ := a.srv.CreateVariant(ctx, &storer.ProductVariant{ProductID: shirt.ID, SKU: "SHIRT-M", Options: storer.VariantOptions{"Size": "M"}, CountInStock: 5, IsActive: true})
+
+	if rec := a.do("POST", "/cart/items", "", map[string]any{"product_id": shirt.ID, "quantity": 1}); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("add without a variant: status = %d, want 422", rec.Code)
+	}
+	rec := a.do("POST", "/cart/items", "", map[string]any{"product_id": shirt.ID, "variant_id": small.ID, "quantity": 1})
+	var cart CartRes
+	decode(t, rec, http.StatusOK, &cart)
+	cartToken := rec.Header().Get(cartTokenHeader)
+	withCart := func(req *http.Request) *http.Request {
+		req.Header.Set(cartTokenHeader, cartToken)
+		return req
+	}
+	rec = a.serve(withCart(a.request("POST", "/cart/items", "", map[string]any{"product_id": shirt.ID, "variant_id": medium.ID, "quantity": 2})))
+	decode(t, rec, http.StatusOK, &cart)
+	if len(cart.Items) != 2 || cart.Items[1].VariantID == nil || *cart.Items[1].VariantID != medium.ID || cart.Items[1].SKU != "SHIRT-M" || cart.Items[1].Name != "Shirt (M)" {
+		t.Fatalf("cart = %+v", cart.Items)
+	}
+
+	path := fmt.Sprintf("/cart/items/%d", shirt.ID)
+	if rec := a.serve(withCart(a.request("PATCH", path, "", map[string]any{"quantity": 3}))); rec.Code != http.StatusNotFound {
+		t.Errorf("update without variant_id: status = %d, want 404", rec.Code)
+	}
+	if rec := a.serve(withCart(a.request("PATCH", path+"?variant_id=x", "", map[string]any{"quantity": 3}))); rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed variant_id: status = %d, want 400", rec.Code)
+	}
+	rec = a.serve(withCart(a.request("PATCH", fmt.Sprintf("%s?variant_id=%d", path, medium.ID), "", map[string]any{"quantity": 3})))
+	decode(t, rec, http.StatusOK, &cart)
+	if cart.Items[0].Quantity != 1 || cart.Items[1].Quantity != 3 {
+		t.Errorf("cart after update = %+v", cart.Items)
+	}
+	rec = a.serve(withCart(a.request("DELETE", fmt.Sprintf("%s?variant_id=%d", path, small.ID), "", nil)))
+	decode(t, rec, http.StatusOK, &cart)
+	if len(cart.Items) != 1 || *cart.Items[0].VariantID != medium.ID {
+		t.Errorf("cart after remove = %+v", cart.Items)
+	}
+}
@@ -1,7 +1,14 @@
 package handler
 
 import (
+	"ecom_apiv1/internal/address"
+	"ecom_apiv1/internal/promotion"
+	"ecom_apiv1/internal/server"
+	"ecom_apiv1/internal/storer"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 
 	"github.com/go-playground/validator/v10"
 )
@@ -28,6 +35,8 @@ func getCustomValidationError(err validator.FieldError) ValidationError {
 		return ValidationError{Field: field, Error: fmt.Sprintf("Minimum value is %s", param)}
 	case "max":
 		return ValidationError{Field: field, Error: fmt.Sprintf("Maximum value is %s", param)}
+	case "len":
+		return ValidationError{Field: field, Error: fmt.Sprintf("Length must be %s", param)}
 	case "url":
 		return ValidationError{Field: field, Error: "Invalid URL format"}
 	case "gt":
@@ -51,3 +60,64 @@ func ValidateStruct(v *validator.Validate, s interface{}) []ValidationError {
 
 	return errors
 }
+
+func writeUnavailableItems(w http.ResponseWriter, err *server.UnavailableItemsError) {
+	errs := make([]ValidationError, 0, len(err.Items))
+	for _, item := range err.Items {
+		field := "ProductID"
+		switch item.Reason {
+		case server.ReasonVariantRequired, server.ReasonVariantNotFound, server.ReasonVariantInactive:
+			field = "VariantID"
+		}
+		errs = append(errs, ValidationError{
+			Field: fmt.Sprintf("Items[%d].%s", item.Index, field),
+			Error: item.Reason,
+		})
+	}
+	writeValidationErrors(w, errs)
+}
+
+// writeInvalidAddress reports the rejected address field, prefixed with
+// where the address sits in the request.
+func writeInvalidAddress(w http.ResponseWriter, prefix string, err *address.Error) {
+	writeValidationErrors(w, []ValidationError{{Field: prefix + err.Field, Error: err.Reason}})
+}
+
+// writeCouponError reports why the coupon or a promotion of an order
+// cannot be redeemed. It returns false, writing nothing, for other errors.
+func writeCouponError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, server.ErrUnknownCoupon):
+		http.Error(w, "coupon code not found", http.StatusUnprocessableEntity)
+	case errors.Is(err, promotion.ErrNotActive):
+		http.Error(w, "coupon is not active", http.StatusUnprocessableEntity)
+	case errors.Is(err, promotion.ErrMinimumNotMet):
+		http.Error(w, "order does not reach the coupon minimum", http.StatusUnprocessableEntity)
+	case errors.Is(err, promotion.ErrNotApplicable):
+		http.Error(w, "coupon does not apply to these items", http.StatusUnprocessableEntity)
+	case errors.Is(err, storer.ErrPromotionUsedUp):
+		http.Error(w, "coupon has been used up", http.StatusConflict)
+	case errors.Is(err, storer.ErrPromotionUserLimit):
+		http.Error(w, "coupon was already used the maximum number of times", http.StatusConflict)
+	case errors.Is(err, storer.ErrPromotionNotFound):
+		http.Error(w, "promotion is no longer available", http.StatusConflict)
+	default:
+		return false
+	}
+	return true
+}
+
+func writeInsufficientStock(w http.ResponseWriter, err *storer.InsufficientStockError) {
+	res := InsufficientStockRes{Error: "insufficient stock"}
+	for _, s := range err.Items {
+		res.Items = append(res.Items, StockShortageRes{
+			ProductID: s.ProductID,
+			VariantID: s.VariantID,
+			Requested: s.Requested,
+			Available: s.Available,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(res)
+}
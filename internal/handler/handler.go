@@ -1,16 +1,27 @@
 package handler
 
 import (
+	"cmp"
 	"context"
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/address"
+	"ecom_apiv1/internal/payment"
+	"ecom_apiv1/internal/pricing"
+	"ecom_apiv1/internal/promotion"
 	"ecom_apiv1/internal/server"
+	"ecom_apiv1/internal/shipping"
 	"ecom_apiv1/internal/storer"
+	"ecom_apiv1/internal/tax"
 	"ecom_apiv1/token"
 	"ecom_apiv1/util"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
@@ -22,14 +33,18 @@ type handler struct {
 	server     *server.Server
 	TokenMaker *token.JWTMaker
 	validate   *validator.Validate
+	// idempotency controls how requests sent with an Idempotency-Key are
+	// claimed and replayed.
+	idempotency config.IdempotencyConfig
 }
 
-func NewHandler(server *server.Server, secretKey string) *handler {
+func NewHandler(server *server.Server, tokenMaker *token.JWTMaker, idempotency config.IdempotencyConfig) *handler {
 	return &handler{
-		Ctx:        context.Background(),
-		server:     server,
-		TokenMaker: token.NewJWTMaker(secretKey),
-		validate:   validator.New(),
+		Ctx:         context.Background(),
+		server:      server,
+		TokenMaker:  tokenMaker,
+		validate:    validator.New(),
+		idempotency: idempotency,
 	}
 }
 
@@ -51,6 +66,16 @@ func (h *handler) createProduct(w http.ResponseWriter, r *http.Request) {
 	}
 	p, err := h.server.CreateProduct(h.Ctx, toStorerProduct(productReq))
 	if err != nil {
+		var invalid *server.VariantError
+		if errors.As(err, &invalid) {
+			writeValidationErrors(w, []ValidationError{{Field: invalid.Field, Error: invalid.Reason}})
+			return
+		}
+		var invalidCategory *server.CategoryError
+		if errors.As(err, &invalidCategory) {
+			writeValidationErrors(w, []ValidationError{{Field: invalidCategory.Field, Error: invalidCategory.Reason}})
+			return
+		}
 		http.Error(w, "error creating product", http.StatusInternalServerError)
 		return
 	}
@@ -69,172 +94,1920 @@ func (h *handler) getProduct(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid ID format", http.StatusBadRequest)
 		return
 	}
+	p, err := h.server.GetProductWithVariants(h.Ctx, uint(id))
+	if err != nil {
+		if errors.Is(err, storer.ErrProductNotFound) {
+			http.Error(w, "Product not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error get product", http.StatusInternalServerError)
+		return
+	}
+	res := toProductRes(p.Product)
+	for i := range p.Variants {
+		res.Variants = append(res.Variants, toVariantRes(&p.Variants[i], p.Product))
+	}
+	for i := range p.Images {
+		res.Images = append(res.Images, toProductImageRes(&p.Images[i]))
+	}
+
+	w.Header().Set("Content-Type", "application-json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) Listproducts(w http.ResponseWriter, r *http.Request) {
+	q, validationErrors := parseProductQuery(r)
+	if len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+	page, err := h.server.ListProducts(h.Ctx, q)
+	if err != nil {
+		if errors.Is(err, storer.ErrInvalidQuery) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, storer.ErrCategoryNotFound) {
+			http.Error(w, "category not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "error get list product", http.StatusInternalServerError)
+		return
+	}
+	res := ListProductRes{
+		Products:   []ProductRes{},
+		Total:      page.Total,
+		NextCursor: page.NextCursor,
+	}
+	for _, product := range page.Items {
+		res.Products = append(res.Products, toProductRes(&product))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) searchProducts(w http.ResponseWriter, r *http.Request) {
+	q, validationErrors := parseProductSearchQuery(r)
+	if len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+	result, err := h.server.SearchProducts(h.Ctx, q)
+	if err != nil {
+		if errors.Is(err, storer.ErrInvalidQuery) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "error searching products", http.StatusInternalServerError)
+		return
+	}
+	res := SearchProductRes{
+		Results: []SearchHitRes{},
+		Total:   result.Total,
+	}
+	for _, hit := range result.Hits {
+		res.Results = append(res.Results, SearchHitRes{
+			Product:    toProductRes(&hit.Product),
+			Score:      hit.Score,
+			Highlights: hit.Highlights,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) getProductFacets(w http.ResponseWriter, r *http.Request) {
+	f, validationErrors := parseProductFilter(r)
+	if len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+	facets, err := h.server.GetProductFacets(h.Ctx, f)
+	if err != nil {
+		if errors.Is(err, storer.ErrCategoryNotFound) {
+			http.Error(w, "category not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "error getting product facets", http.StatusInternalServerError)
+		return
+	}
+	res := ProductFacetsRes{
+		Total:      facets.Total,
+		Categories: []FacetCountRes{},
+		Prices:     []PriceBucketRes{},
+		Ratings:    []RatingCountRes{},
+		Availability: AvailabilityRes{
+			InStock:    facets.InStock,
+			OutOfStock: facets.OutOfStock,
+		},
+	}
+	for _, c := range facets.Categories {
+		res.Categories = append(res.Categories, FacetCountRes{Value: c.Value, Count: c.Count})
+	}
+	for _, p := range facets.Prices {
+		res.Prices = append(res.Prices, PriceBucketRes{Min: p.Min, Max: p.Max, Count: p.Count})
+	}
+	for _, rc := range facets.Ratings {
+		res.Ratings = append(res.Ratings, RatingCountRes{Rating: rc.Rating, Count: rc.Count})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) updateProducts(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idString := vars["id"]
+	id, err := strconv.ParseUint(idString, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	var productReq ProductReq
+	err = json.NewDecoder(r.Body).Decode(&productReq)
+	if err != nil {
+		http.Error(w, "error decoding body", http.StatusBadRequest)
+		return
+	}
+
+	validationErrors := ValidateStruct(h.validate, productReq)
+	if len(validationErrors) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"errors": validationErrors,
+		})
+		return
+	}
+
 	p, err := h.server.GetProduct(h.Ctx, uint(id))
 	if err != nil {
 		http.Error(w, "Error get product", http.StatusInternalServerError)
 		return
 	}
-	res := toProductRes(p)
-
-	w.Header().Set("Content-Type", "application-json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(res)
+
+	patchProductReq(p, productReq)
+
+	updatedProduct, err := h.server.UpdateProduct(h.Ctx, p)
+	if err != nil {
+		var invalid *server.VariantError
+		if errors.As(err, &invalid) {
+			writeValidationErrors(w, []ValidationError{{Field: invalid.Field, Error: invalid.Reason}})
+			return
+		}
+		var invalidCategory *server.CategoryError
+		if errors.As(err, &invalidCategory) {
+			writeValidationErrors(w, []ValidationError{{Field: invalidCategory.Field, Error: invalidCategory.Reason}})
+			return
+		}
+		http.Error(w, "error update product", http.StatusInternalServerError)
+		return
+	}
+	res := toProductRes(updatedProduct)
+
+	w.Header().Set("Content-Type", "application-json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idString := vars["id"]
+	id, err := strconv.ParseUint(idString, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	err = h.server.DeleteProduct(h.Ctx, uint(id))
+	if err != nil {
+		if errors.Is(err, storer.ErrProductNotFound) {
+			http.Error(w, "Product not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Error deleting product", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listVariants returns the variant matrix of a product.
+func (h *handler) listVariants(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	p, err := h.server.GetProductWithVariants(h.Ctx, uint(id))
+	if err != nil {
+		writeVariantError(w, err)
+		return
+	}
+	res := []VariantRes{}
+	for i := range p.Variants {
+		res = append(res, toVariantRes(&p.Variants[i], p.Product))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) createVariant(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	var req VariantReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+
+	v := toStorerVariant(req)
+	v.ProductID = uint(id)
+	created, err := h.server.CreateVariant(h.Ctx, v)
+	if err != nil {
+		writeVariantError(w, err)
+		return
+	}
+	h.writeVariant(w, http.StatusCreated, created)
+}
+
+func (h *handler) updateVariant(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	variantID, err := strconv.ParseUint(vars["variant_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	var req VariantReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+
+	v := toStorerVariant(req)
+	v.ID, v.ProductID = uint(variantID), uint(id)
+	updated, err := h.server.UpdateVariant(h.Ctx, v)
+	if err != nil {
+		writeVariantError(w, err)
+		return
+	}
+	h.writeVariant(w, http.StatusOK, updated)
+}
+
+func (h *handler) deleteVariant(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	variantID, err := strconv.ParseUint(vars["variant_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	if err := h.server.DeleteVariant(h.Ctx, uint(id), uint(variantID)); err != nil {
+		writeVariantError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeVariant writes v with the price and image it gets from its product.
+func (h *handler) writeVariant(w http.ResponseWriter, status int, v *storer.ProductVariant) {
+	p, err := h.server.GetProduct(h.Ctx, v.ProductID)
+	if err != nil {
+		http.Error(w, "Error get product", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(toVariantRes(v, p))
+}
+
+// maxImageFormBytes is what a multipart upload may add around the image.
+const maxImageFormBytes = 64 << 10
+
+// listProductImages returns the images of a product in display order.
+func (h *handler) listProductImages(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	images, err := h.server.ListProductImages(h.Ctx, uint(id))
+	if err != nil {
+		writeImageError(w, err)
+		return
+	}
+	writeProductImages(w, images)
+}
+
+// uploadProductImage takes a multipart form with the file in the image
+// field and an optional alt_text field.
+func (h *handler) uploadProductImage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	maxSize := int64(h.server.MaxImageSize())
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+maxImageFormBytes)
+	if err := r.ParseMultipartForm(maxImageFormBytes); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("image must be at most %d bytes", maxSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "expected a multipart form", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		writeValidationErrors(w, []ValidationError{{Field: "image", Error: "is required"}})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		http.Error(w, "error reading image", http.StatusBadRequest)
+		return
+	}
+
+	created, err := h.server.UploadProductImage(h.Ctx, uint(id), data, r.FormValue("alt_text"))
+	if err != nil {
+		writeImageError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toProductImageRes(created))
+}
+
+func (h *handler) updateProductImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	imageID, err := strconv.ParseUint(vars["image_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	var req ProductImageUpdateReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+
+	updated, err := h.server.UpdateProductImage(h.Ctx, uint(id), uint(imageID), req.AltText)
+	if err != nil {
+		writeImageError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toProductImageRes(updated))
+}
+
+// reorderProductImages takes the ids of every image of the product in the
+// order to show them.
+func (h *handler) reorderProductImages(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	var req ProductImageOrderReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+
+	images, err := h.server.ReorderProductImages(h.Ctx, uint(id), req.ImageIDs)
+	if err != nil {
+		writeImageError(w, err)
+		return
+	}
+	writeProductImages(w, images)
+}
+
+func (h *handler) deleteProductImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	imageID, err := strconv.ParseUint(vars["image_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	if err := h.server.DeleteProductImage(h.Ctx, uint(id), uint(imageID)); err != nil {
+		writeImageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeProductImages(w http.ResponseWriter, images []storer.ProductImage) {
+	res := []ProductImageRes{}
+	for i := range images {
+		res = append(res, toProductImageRes(&images[i]))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func writeImageError(w http.ResponseWriter, err error) {
+	var invalid *server.ImageError
+	switch {
+	case errors.As(err, &invalid):
+		writeValidationErrors(w, []ValidationError{{Field: invalid.Field, Error: invalid.Reason}})
+	case errors.Is(err, storer.ErrProductNotFound):
+		http.Error(w, "product not found", http.StatusNotFound)
+	case errors.Is(err, storer.ErrImageNotFound):
+		http.Error(w, "image not found", http.StatusNotFound)
+	case errors.Is(err, server.ErrImagesNotConfigured):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		log.Printf("error saving product image: %v", err)
+		http.Error(w, "error saving image", http.StatusInternalServerError)
+	}
+}
+
+func writeVariantError(w http.ResponseWriter, err error) {
+	var invalid *server.VariantError
+	switch {
+	case errors.As(err, &invalid):
+		writeValidationErrors(w, []ValidationError{{Field: invalid.Field, Error: invalid.Reason}})
+	case errors.Is(err, storer.ErrProductNotFound):
+		http.Error(w, "product not found", http.StatusNotFound)
+	case errors.Is(err, storer.ErrVariantNotFound):
+		http.Error(w, "variant not found", http.StatusNotFound)
+	case errors.Is(err, storer.ErrSKUTaken):
+		http.Error(w, "sku already in use", http.StatusConflict)
+	case errors.Is(err, storer.ErrVariantInUse):
+		http.Error(w, "variant is reserved by open orders, deactivate it instead", http.StatusConflict)
+	default:
+		http.Error(w, "error saving variant", http.StatusInternalServerError)
+	}
+}
+
+func (h *handler) createOrder(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+	var orderReq OrderReq
+	err := json.NewDecoder(r.Body).Decode(&orderReq)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	validationErrors := ValidateStruct(h.validate, orderReq)
+	if len(validationErrors) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"errors": validationErrors,
+		})
+		return
+	}
+
+	so := toStorerOrder(orderReq)
+	so.UserID = &claims.ID
+	so.Email = claims.Email
+	so.AddressID = orderReq.AddressID
+	so.CouponCode = orderReq.CouponCode
+
+	created, err := h.server.CreateOrder(h.Ctx, so)
+	if err != nil {
+		writeCreateOrderError(w, err)
+		return
+	}
+
+	res := toOrderRes(created)
+	w.Header().Set("Content-Type", "application-json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) createGuestOrder(w http.ResponseWriter, r *http.Request) {
+	var req GuestOrderReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+
+	so := &storer.Order{
+		PaymentMethod:   req.PaymentMethod,
+		Email:           req.Email,
+		ShippingAddress: toShippingAddress(req.ShippingAddress),
+		Items:           toStorerOrderItem(req.Items),
+		CouponCode:      req.CouponCode,
+	}
+	created, accessToken, err := h.server.PlaceGuestOrder(h.Ctx, so)
+	if err != nil {
+		writeCreateOrderError(w, err)
+		return
+	}
+	writeGuestOrder(w, created, accessToken)
+}
+
+func (h *handler) getGuestOrder(w http.ResponseWriter, r *http.Request) {
+	o, ok := h.guestOrder(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toOrderRes(o))
+}
+
+func (h *handler) claimGuestOrder(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+	o, err := h.server.ClaimGuestOrder(h.Ctx, mux.Vars(r)["token"], claims.ID, claims.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, storer.ErrOrderNotFound):
+			http.Error(w, "order not found", http.StatusNotFound)
+		case errors.Is(err, server.ErrClaimEmailMismatch):
+			http.Error(w, "order was placed with a different email address", http.StatusForbidden)
+		default:
+			http.Error(w, "error claiming order", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toOrderRes(o))
+}
+
+func writeCreateOrderError(w http.ResponseWriter, err error) {
+	var unavailable *server.UnavailableItemsError
+	var shortage *storer.InsufficientStockError
+	var invalidAddress *address.Error
+	switch {
+	case errors.As(err, &unavailable):
+		writeUnavailableItems(w, unavailable)
+	case errors.As(err, &shortage):
+		writeInsufficientStock(w, shortage)
+	case errors.Is(err, storer.ErrProductNotFound):
+		http.Error(w, "product not found", http.StatusBadRequest)
+	case errors.Is(err, storer.ErrVariantNotFound):
+		http.Error(w, "variant not found", http.StatusBadRequest)
+	case errors.As(err, &invalidAddress):
+		writeInvalidAddress(w, "shipping_address.", invalidAddress)
+	case errors.Is(err, storer.ErrAddressNotFound):
+		http.Error(w, "address not found", http.StatusBadRequest)
+	case errors.Is(err, shipping.ErrNoRate):
+		http.Error(w, "order cannot be shipped to this address", http.StatusUnprocessableEntity)
+	case writeCouponError(w, err):
+	default:
+		http.Error(w, "error creating order", http.StatusInternalServerError)
+	}
+}
+
+// quoteShipping prices items shipped to a country, for the checkout page to
+// show before an address is chosen.
+func (h *handler) quoteShipping(w http.ResponseWriter, r *http.Request) {
+	var req ShippingQuoteReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+
+	b, err := h.server.QuoteShipping(h.Ctx, toStorerOrderItem(req.Items), strings.ToUpper(req.Country), req.CouponCode)
+	if err != nil {
+		var unavailable *server.UnavailableItemsError
+		switch {
+		case errors.As(err, &unavailable):
+			writeUnavailableItems(w, unavailable)
+		case errors.Is(err, shipping.ErrNoRate):
+			http.Error(w, "items cannot be shipped to this country", http.StatusUnprocessableEntity)
+		case writeCouponError(w, err):
+		default:
+			http.Error(w, "error quoting shipping", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ShippingQuoteRes{
+		Country:       strings.ToUpper(req.Country),
+		ItemsPrice:    b.Subtotal,
+		DiscountPrice: pricing.Round(b.Discount + b.ShippingDiscount),
+		TaxPrice:      b.Tax,
+		TaxIncluded:   b.TaxIncluded,
+		ShippingPrice: b.Shipping,
+		TotalPrice:    b.Total,
+	})
+}
+
+func writeGuestOrder(w http.ResponseWriter, o *storer.Order, accessToken string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(GuestOrderRes{OrderRes: toOrderRes(o), AccessToken: accessToken})
+}
+
+// ownedOrder loads the order named by the {id} path variable when the caller
+// owns it or is an admin. Anyone else gets the same 404 as for a missing
+// order so order IDs cannot be probed. It writes the error response itself.
+func (h *handler) ownedOrder(w http.ResponseWriter, r *http.Request) (*storer.Order, *token.UserClaims, bool) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	o, err := h.server.GetOrderByID(h.Ctx, uint(id))
+	if errors.Is(err, storer.ErrOrderNotFound) || (err == nil && !ownsOrder(o, claims) && !claims.IsAdmin) {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if err != nil {
+		http.Error(w, "error getting order", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	return o, claims, true
+}
+
+func ownsOrder(o *storer.Order, claims *token.UserClaims) bool {
+	return o.UserID != nil && *o.UserID == claims.ID
+}
+
+func (h *handler) getOrder(w http.ResponseWriter, r *http.Request) {
+	o, _, ok := h.ownedOrder(w, r)
+	if !ok {
+		return
+	}
+	res := toOrderRes(o)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) listMyOrders(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+	q, validationErrors := parseOrderQuery(r)
+	if len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+	// customers only ever see their own orders, whatever user_id says
+	q.UserID = &claims.ID
+	h.writeOrderPage(w, q)
+}
+
+func (h *handler) listOrders(w http.ResponseWriter, r *http.Request) {
+	q, validationErrors := parseOrderQuery(r)
+	if len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+	h.writeOrderPage(w, q)
+}
+
+func (h *handler) writeOrderPage(w http.ResponseWriter, q storer.OrderQuery) {
+	page, err := h.server.ListOrders(h.Ctx, q)
+	if err != nil {
+		if errors.Is(err, storer.ErrInvalidQuery) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	res := ListOrderRes{
+		Orders:     []OrderRes{},
+		Total:      page.Total,
+		NextCursor: page.NextCursor,
+	}
+	for _, o := range page.Items {
+		res.Orders = append(res.Orders, toOrderRes(&o))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) updateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	var req OrderStatusReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+
+	o, err := h.server.UpdateOrderStatus(h.Ctx, uint(id), storer.StatusChange{
+		To:        storer.OrderStatus(req.Status),
+		ChangedBy: &claims.ID,
+		Note:      req.Note,
+	})
+	if err != nil {
+		writeOrderStatusError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toOrderRes(o))
+}
+
+func (h *handler) getOrderTimeline(w http.ResponseWriter, r *http.Request) {
+	o, _, ok := h.ownedOrder(w, r)
+	if !ok {
+		return
+	}
+	events, err := h.server.ListOrderStatusEvents(h.Ctx, o.ID)
+	if err != nil {
+		http.Error(w, "error getting order timeline", http.StatusInternalServerError)
+		return
+	}
+
+	res := OrderTimelineRes{
+		OrderID: o.ID,
+		Status:  string(o.Status),
+		Events:  []OrderStatusEventRes{},
+	}
+	for _, e := range events {
+		res.Events = append(res.Events, OrderStatusEventRes{
+			FromStatus: string(e.FromStatus),
+			ToStatus:   string(e.ToStatus),
+			ChangedBy:  e.ChangedBy,
+			Note:       e.Note,
+			CreatedAt:  e.CreatedAt,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// cancelOrder lets customers call off an order they have not paid yet.
+// Admins may cancel paid orders too, which refunds them in full.
+func (h *handler) cancelOrder(w http.ResponseWriter, r *http.Request) {
+	o, claims, ok := h.ownedOrder(w, r)
+	if !ok {
+		return
+	}
+	if !claims.IsAdmin && o.Status != storer.OrderPending {
+		http.Error(w, "only pending orders can be cancelled, request a return or refund instead", http.StatusConflict)
+		return
+	}
+	cancelled, err := h.server.UpdateOrderStatus(h.Ctx, o.ID, storer.StatusChange{
+		To:        storer.OrderCancelled,
+		ChangedBy: &claims.ID,
+	})
+	if err != nil {
+		writeOrderStatusError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toOrderRes(cancelled))
+}
+
+// writeOrderStatusError maps the errors of a status change, which may have
+// refunded the order on the way.
+func writeOrderStatusError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storer.ErrOrderNotFound):
+		http.Error(w, "order not found", http.StatusNotFound)
+	case errors.Is(err, storer.ErrInvalidTransition):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		writeReturnError(w, err)
+	}
+}
+
+func (h *handler) deleteOrder(w http.ResponseWriter, r *http.Request) {
+	o, claims, ok := h.ownedOrder(w, r)
+	if !ok {
+		return
+	}
+	// once an order is past pending, customers cancel it instead so the
+	// record and its timeline are kept
+	if !claims.IsAdmin && o.Status != storer.OrderPending {
+		http.Error(w, "only pending orders can be deleted, cancel the order instead", http.StatusConflict)
+		return
+	}
+	err := h.server.DeleteOrder(h.Ctx, o.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, storer.ErrOrderNotFound):
+			http.Error(w, "Order not found", http.StatusNotFound)
+		case errors.Is(err, storer.ErrOrderNotDeletable):
+			http.Error(w, "only pending or cancelled orders can be deleted, refund the order instead", http.StatusConflict)
+		default:
+			http.Error(w, "Error deleting order", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) startPayment(w http.ResponseWriter, r *http.Request) {
+	o, _, ok := h.ownedOrder(w, r)
+	if !ok {
+		return
+	}
+	h.writePaymentSession(w, o)
+}
+
+func (h *handler) capturePayment(w http.ResponseWriter, r *http.Request) {
+	o, _, ok := h.ownedOrder(w, r)
+	if !ok {
+		return
+	}
+	h.writeCapture(w, o)
+}
+
+func (h *handler) listPayments(w http.ResponseWriter, r *http.Request) {
+	o, _, ok := h.ownedOrder(w, r)
+	if !ok {
+		return
+	}
+	payments, err := h.server.ListPayments(h.Ctx, o.ID)
+	if err != nil {
+		http.Error(w, "error listing payments", http.StatusInternalServerError)
+		return
+	}
+	res := make([]PaymentRes, 0, len(payments))
+	for i := range payments {
+		res = append(res, toPaymentRes(&payments[i]))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) startGuestPayment(w http.ResponseWriter, r *http.Request) {
+	o, ok := h.guestOrder(w, r)
+	if !ok {
+		return
+	}
+	h.writePaymentSession(w, o)
+}
+
+func (h *handler) captureGuestPayment(w http.ResponseWriter, r *http.Request) {
+	o, ok := h.guestOrder(w, r)
+	if !ok {
+		return
+	}
+	h.writeCapture(w, o)
+}
+
+func (h *handler) guestOrder(w http.ResponseWriter, r *http.Request) (*storer.Order, bool) {
+	o, err := h.server.GetGuestOrder(h.Ctx, mux.Vars(r)["token"])
+	if err != nil {
+		if errors.Is(err, storer.ErrOrderNotFound) {
+			http.Error(w, "order not found", http.StatusNotFound)
+			return nil, false
+		}
+		http.Error(w, "error getting order", http.StatusInternalServerError)
+		return nil, false
+	}
+	return o, true
+}
+
+func (h *handler) writePaymentSession(w http.ResponseWriter, o *storer.Order) {
+	session, err := h.server.StartPayment(h.Ctx, o)
+	if err != nil {
+		writePaymentError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(PaymentSessionRes{
+		Payment:      toPaymentRes(session.Payment),
+		ClientSecret: session.ClientSecret,
+		ApproveURL:   session.ApproveURL,
+	})
+}
+
+// writeCapture captures the order's pending payment. A capture the provider
+// has not settled yet answers 202 with the still pending payment.
+func (h *handler) writeCapture(w http.ResponseWriter, o *storer.Order) {
+	p, err := h.server.CapturePayment(h.Ctx, o)
+	status := http.StatusOK
+	if errors.Is(err, server.ErrCapturePending) {
+		status = http.StatusAccepted
+	} else if err != nil {
+		writePaymentError(w, err)
+		return
+	}
+	o, err = h.server.GetOrderByID(h.Ctx, o.ID)
+	if err != nil {
+		http.Error(w, "error getting order", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(CapturePaymentRes{Order: toOrderRes(o), Payment: toPaymentRes(p)})
+}
+
+func writePaymentError(w http.ResponseWriter, err error) {
+	var apiErr *payment.APIError
+	switch {
+	case errors.Is(err, payment.ErrProviderNotConfigured):
+		http.Error(w, "payment method is not available", http.StatusBadRequest)
+	case errors.Is(err, server.ErrOrderNotPayable):
+		http.Error(w, "order is not awaiting payment", http.StatusConflict)
+	case errors.Is(err, server.ErrNoPendingPayment):
+		http.Error(w, "order has no pending payment", http.StatusConflict)
+	case errors.Is(err, server.ErrPaymentDeclined):
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+	case errors.Is(err, server.ErrCaptureMismatch):
+		http.Error(w, "payment could not be verified and was refunded", http.StatusBadGateway)
+	case errors.As(err, &apiErr):
+		log.Printf("payment provider error: %v", err)
+		http.Error(w, "payment provider error", http.StatusBadGateway)
+	default:
+		http.Error(w, "error processing payment", http.StatusInternalServerError)
+	}
+}
+
+// maxWebhookBytes bounds the size of a provider notification.
+const maxWebhookBytes = 1 << 20
+
+// receiveWebhook answers 2xx for every event that is handled or safe to
+// drop, and 5xx only when the provider should deliver it again.
+func (h *handler) receiveWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBytes))
+	if err != nil {
+		http.Error(w, "error reading request body", http.StatusBadRequest)
+		return
+	}
+	e, duplicate, err := h.server.HandleWebhook(h.Ctx, mux.Vars(r)["provider"], payload, r.Header)
+	res := WebhookRes{Status: "processed"}
+	switch {
+	case errors.Is(err, payment.ErrProviderNotConfigured):
+		http.Error(w, "unknown payment provider", http.StatusNotFound)
+		return
+	case errors.Is(err, payment.ErrInvalidSignature), errors.Is(err, payment.ErrStaleWebhook):
+		http.Error(w, "invalid webhook signature", http.StatusBadRequest)
+		return
+	case errors.Is(err, payment.ErrUnknownEvent):
+		res.Outcome = server.WebhookIgnored
+	case err != nil:
+		log.Printf("error handling webhook: %v", err)
+		http.Error(w, "error handling webhook", http.StatusInternalServerError)
+		return
+	default:
+		res.Outcome = e.Outcome
+		if duplicate {
+			res.Status = "duplicate"
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) requestReturn(w http.ResponseWriter, r *http.Request) {
+	o, _, ok := h.ownedOrder(w, r)
+	if !ok {
+		return
+	}
+	var req ReturnReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+
+	rr, err := h.server.RequestReturn(h.Ctx, o, &storer.ReturnRequest{
+		OrderItemID: req.OrderItemID,
+		Quantity:    req.Quantity,
+		Reason:      storer.ReturnReason(req.Reason),
+		Comment:     req.Comment,
+	})
+	if err != nil {
+		writeReturnError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toReturnRes(rr))
+}
+
+func (h *handler) listReturns(w http.ResponseWriter, r *http.Request) {
+	o, _, ok := h.ownedOrder(w, r)
+	if !ok {
+		return
+	}
+	returns, err := h.server.ListReturns(h.Ctx, o.ID)
+	if err != nil {
+		http.Error(w, "error listing returns", http.StatusInternalServerError)
+		return
+	}
+	res := make([]ReturnRes, 0, len(returns))
+	for i := range returns {
+		res = append(res, toReturnRes(&returns[i]))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) updateReturnStatus(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	var req ReturnStatusReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+
+	rr, err := h.server.UpdateReturnStatus(h.Ctx, uint(id), storer.ReturnChange{
+		To:        storer.ReturnStatus(req.Status),
+		ChangedBy: &claims.ID,
+		Note:      req.Note,
+	})
+	if err != nil {
+		writeReturnError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toReturnRes(rr))
+}
+
+func (h *handler) refundReturn(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	rf, err := h.server.RefundReturn(h.Ctx, uint(id), &claims.ID)
+	if err != nil {
+		writeReturnError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toRefundRes(rf))
+}
+
+func (h *handler) refundOrder(w http.ResponseWriter, r *http.Request) {
+	o, claims, ok := h.ownedOrder(w, r)
+	if !ok {
+		return
+	}
+	var req RefundReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+
+	rf, err := h.server.RefundOrder(h.Ctx, o, req.Amount, req.Reason, &claims.ID)
+	if err != nil {
+		writeReturnError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toRefundRes(rf))
+}
+
+func (h *handler) listRefunds(w http.ResponseWriter, r *http.Request) {
+	o, _, ok := h.ownedOrder(w, r)
+	if !ok {
+		return
+	}
+	refunds, err := h.server.ListRefunds(h.Ctx, o.ID)
+	if err != nil {
+		http.Error(w, "error listing refunds", http.StatusInternalServerError)
+		return
+	}
+	res := RefundSummaryRes{
+		TotalPrice:     o.TotalPrice,
+		RefundedAmount: o.RefundedAmount,
+		Remaining:      pricing.Round(o.TotalPrice - o.RefundedAmount),
+		Refunds:        make([]RefundRes, 0, len(refunds)),
+	}
+	for i := range refunds {
+		res.Refunds = append(res.Refunds, toRefundRes(&refunds[i]))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func writeReturnError(w http.ResponseWriter, err error) {
+	var apiErr *payment.APIError
+	switch {
+	case errors.Is(err, storer.ErrOrderNotFound):
+		http.Error(w, "order not found", http.StatusNotFound)
+	case errors.Is(err, storer.ErrOrderItemNotFound):
+		http.Error(w, "order item not found", http.StatusNotFound)
+	case errors.Is(err, storer.ErrReturnNotFound):
+		http.Error(w, "return not found", http.StatusNotFound)
+	case errors.Is(err, server.ErrOrderNotReturnable),
+		errors.Is(err, server.ErrOrderNotRefundable),
+		errors.Is(err, server.ErrNothingToRefund),
+		errors.Is(err, storer.ErrInvalidReturnTransition),
+		errors.Is(err, storer.ErrReturnQuantityExceeded),
+		errors.Is(err, storer.ErrReturnNotRefundable),
+		errors.Is(err, storer.ErrRefundExceedsTotal):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, server.ErrRefundDeclined):
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	case errors.As(err, &apiErr):
+		log.Printf("payment provider error: %v", err)
+		http.Error(w, "payment provider error", http.StatusBadGateway)
+	default:
+		log.Printf("error processing return: %v", err)
+		http.Error(w, "error processing return", http.StatusInternalServerError)
+	}
+}
+
+func (h *handler) listAddresses(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+	addresses, err := h.server.ListAddresses(h.Ctx, claims.ID)
+	if err != nil {
+		http.Error(w, "error listing addresses", http.StatusInternalServerError)
+		return
+	}
+	res := make([]AddressRes, 0, len(addresses))
+	for i := range addresses {
+		res = append(res, toAddressRes(&addresses[i]))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) getAddress(w http.ResponseWriter, r *http.Request) {
+	a, ok := h.ownedAddress(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toAddressRes(a))
+}
+
+func (h *handler) createAddress(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+	var req AddressReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+
+	a := &storer.Address{UserID: claims.ID, IsDefault: req.IsDefault}
+	a.SetShippingAddress(toShippingAddress(&req.ShippingAddressReq))
+	created, err := h.server.CreateAddress(h.Ctx, a)
+	if err != nil {
+		writeAddressError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toAddressRes(created))
+}
+
+func (h *handler) updateAddress(w http.ResponseWriter, r *http.Request) {
+	a, ok := h.ownedAddress(w, r)
+	if !ok {
+		return
+	}
+	var req AddressReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+
+	a.SetShippingAddress(toShippingAddress(&req.ShippingAddressReq))
+	a.IsDefault = req.IsDefault
+	updated, err := h.server.UpdateAddress(h.Ctx, a)
+	if err != nil {
+		writeAddressError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toAddressRes(updated))
+}
+
+func (h *handler) deleteAddress(w http.ResponseWriter, r *http.Request) {
+	a, ok := h.ownedAddress(w, r)
+	if !ok {
+		return
+	}
+	if err := h.server.DeleteAddress(h.Ctx, a.ID); err != nil {
+		writeAddressError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ownedAddress loads the address named by the {id} path variable when it
+// belongs to the caller. Other users' addresses are not found. It writes
+// the error response itself.
+func (h *handler) ownedAddress(w http.ResponseWriter, r *http.Request) (*storer.Address, bool) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return nil, false
+	}
+	a, err := h.server.GetAddress(h.Ctx, uint(id))
+	if errors.Is(err, storer.ErrAddressNotFound) || (err == nil && a.UserID != claims.ID) {
+		http.Error(w, "address not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "error getting address", http.StatusInternalServerError)
+		return nil, false
+	}
+	return a, true
+}
+
+func writeAddressError(w http.ResponseWriter, err error) {
+	var invalidAddress *address.Error
+	switch {
+	case errors.As(err, &invalidAddress):
+		writeInvalidAddress(w, "", invalidAddress)
+	case errors.Is(err, storer.ErrAddressNotFound):
+		http.Error(w, "address not found", http.StatusNotFound)
+	case errors.Is(err, storer.ErrUserNotFound):
+		http.Error(w, "user not found", http.StatusNotFound)
+	default:
+		http.Error(w, "error saving address", http.StatusInternalServerError)
+	}
+}
+
+func (h *handler) listPromotions(w http.ResponseWriter, r *http.Request) {
+	promotions, err := h.server.ListPromotions(h.Ctx)
+	if err != nil {
+		http.Error(w, "error listing promotions", http.StatusInternalServerError)
+		return
+	}
+	res := make([]PromotionRes, 0, len(promotions))
+	for i := range promotions {
+		res = append(res, toPromotionRes(&promotions[i]))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (h *handler) getPromotion(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	p, err := h.server.GetPromotion(h.Ctx, uint(id))
+	if err != nil {
+		writePromotionError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toPromotionRes(p))
+}
+
+func (h *handler) createPromotion(w http.ResponseWriter, r *http.Request) {
+	var req PromotionReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+
+	created, err := h.server.CreatePromotion(h.Ctx, toStorerPromotion(req))
+	if err != nil {
+		writePromotionError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toPromotionRes(created))
+}
+
+func (h *handler) updatePromotion(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	var req PromotionReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+
+	p := toStorerPromotion(req)
+	p.ID = uint(id)
+	updated, err := h.server.UpdatePromotion(h.Ctx, p)
+	if err != nil {
+		writePromotionError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toPromotionRes(updated))
+}
+
+func (h *handler) deletePromotion(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	if err := h.server.DeletePromotion(h.Ctx, uint(id)); err != nil {
+		writePromotionError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writePromotionError(w http.ResponseWriter, err error) {
+	var invalid *promotion.Error
+	switch {
+	case errors.As(err, &invalid):
+		writeValidationErrors(w, []ValidationError{{Field: invalid.Field, Error: invalid.Reason}})
+	case errors.Is(err, storer.ErrPromotionNotFound):
+		http.Error(w, "promotion not found", http.StatusNotFound)
+	case errors.Is(err, storer.ErrPromotionCodeTaken):
+		http.Error(w, "promotion code already in use", http.StatusConflict)
+	default:
+		http.Error(w, "error saving promotion", http.StatusInternalServerError)
+	}
+}
+
+func (h *handler) listCategories(w http.ResponseWriter, r *http.Request) {
+	tree, err := h.server.GetCategoryTree(h.Ctx)
+	if err != nil {
+		http.Error(w, "error listing categories", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toCategoryTreeRes(tree))
+}
+
+func (h *handler) getCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	node, err := h.server.GetCategory(h.Ctx, uint(id))
+	if err != nil {
+		writeCategoryError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toCategoryRes(node.Category, node.Children))
+}
+
+func (h *handler) createCategory(w http.ResponseWriter, r *http.Request) {
+	var req CategoryReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+
+	created, err := h.server.CreateCategory(h.Ctx, toStorerCategory(req))
+	if err != nil {
+		writeCategoryError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toCategoryRes(*created, nil))
+}
+
+func (h *handler) updateCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	var req CategoryReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+
+	c := toStorerCategory(req)
+	c.ID = uint(id)
+	updated, err := h.server.UpdateCategory(h.Ctx, c)
+	if err != nil {
+		writeCategoryError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toCategoryRes(*updated, nil))
+}
+
+// deleteCategory removes a category. Its products, if any, are moved to the
+// category given by the move_to parameter.
+func (h *handler) deleteCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	p := newQueryParser(r)
+	moveTo := p.uint("move_to")
+	if len(p.errors) > 0 {
+		writeValidationErrors(w, p.errors)
+		return
+	}
+	if err := h.server.DeleteCategory(h.Ctx, uint(id), moveTo); err != nil {
+		writeCategoryError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeCategoryError(w http.ResponseWriter, err error) {
+	var invalid *server.CategoryError
+	switch {
+	case errors.As(err, &invalid):
+		writeValidationErrors(w, []ValidationError{{Field: invalid.Field, Error: invalid.Reason}})
+	case errors.Is(err, storer.ErrCategoryNotFound):
+		http.Error(w, "category not found", http.StatusNotFound)
+	case errors.Is(err, storer.ErrCategorySlugTaken):
+		http.Error(w, "category slug already in use", http.StatusConflict)
+	case errors.Is(err, storer.ErrCategoryInUse):
+		http.Error(w, "category still has subcategories or products, move them first", http.StatusConflict)
+	default:
+		http.Error(w, "error saving category", http.StatusInternalServerError)
+	}
+}
+
+func (h *handler) listProductReviews(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	p := newQueryParser(r)
+	params := p.listParams()
+	if len(p.errors) > 0 {
+		writeValidationErrors(w, p.errors)
+		return
+	}
+	params.Sort = cmp.Or(params.Sort, "-created_at")
+	page, err := h.server.ListProductReviews(h.Ctx, uint(id), params)
+	h.writeReviewPage(w, page, err)
+}
+
+func (h *handler) listMyReviews(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+	q, validationErrors := parseReviewQuery(r)
+	if len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+	q.UserID = &claims.ID
+	page, err := h.server.ListReviews(h.Ctx, q)
+	h.writeReviewPage(w, page, err)
+}
+
+func (h *handler) listReviews(w http.ResponseWriter, r *http.Request) {
+	q, validationErrors := parseReviewQuery(r)
+	if len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+	page, err := h.server.ListReviews(h.Ctx, q)
+	h.writeReviewPage(w, page, err)
 }
 
-func (h *handler) Listproducts(w http.ResponseWriter, r *http.Request) {
-	products, err := h.server.ListProducts(h.Ctx)
+func (h *handler) writeReviewPage(w http.ResponseWriter, page *storer.Page[storer.Review], err error) {
 	if err != nil {
-		http.Error(w, "error get list product", http.StatusInternalServerError)
+		if errors.Is(err, storer.ErrInvalidQuery) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeReviewError(w, err)
 		return
 	}
-	var res []ProductRes
-	for _, product := range products {
-		res = append(res, toProductRes(&product))
+	res := ListReviewRes{
+		Reviews:    []ReviewRes{},
+		Total:      page.Total,
+		NextCursor: page.NextCursor,
 	}
-
-	w.Header().Set("Content-Type", "application-json")
+	for i := range page.Items {
+		res.Reviews = append(res.Reviews, toReviewRes(&page.Items[i]))
+	}
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(res)
 }
 
-func (h *handler) updateProducts(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	idString := vars["id"]
-	id, err := strconv.ParseUint(idString, 10, 64)
+func (h *handler) createReview(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
 	if err != nil {
 		http.Error(w, "Invalid ID format", http.StatusBadRequest)
 		return
 	}
-	var productReq ProductReq
-	err = json.NewDecoder(r.Body).Decode(&productReq)
-	if err != nil {
-		http.Error(w, "error decoding body", http.StatusBadRequest)
+	var req ReviewReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-
-	validationErrors := ValidateStruct(h.validate, productReq)
-	if len(validationErrors) > 0 {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"errors": validationErrors,
-		})
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
 		return
 	}
 
-	p, err := h.server.GetProduct(h.Ctx, uint(id))
+	created, err := h.server.CreateReview(h.Ctx, &storer.Review{
+		ProductID: uint(id),
+		UserID:    claims.ID,
+		Rating:    req.Rating,
+		Title:     req.Title,
+		Body:      req.Body,
+	})
 	if err != nil {
-		http.Error(w, "Error get product", http.StatusInternalServerError)
+		writeReviewError(w, err)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toReviewRes(created))
+}
 
-	patchProductReq(p, productReq)
+func (h *handler) updateReview(w http.ResponseWriter, r *http.Request) {
+	rv, claims, ok := h.ownedReview(w, r)
+	if !ok {
+		return
+	}
+	// admins moderate reviews, they do not rewrite them
+	if rv.UserID != claims.ID {
+		http.Error(w, "only the author can edit a review", http.StatusForbidden)
+		return
+	}
+	var req ReviewReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
 
-	updatedProduct, err := h.server.UpdateProduct(h.Ctx, p)
+	rv.Rating, rv.Title, rv.Body = req.Rating, req.Title, req.Body
+	updated, err := h.server.UpdateReview(h.Ctx, rv)
 	if err != nil {
-		http.Error(w, "error update product", http.StatusInternalServerError)
+		writeReviewError(w, err)
 		return
 	}
-	res := toProductRes(updatedProduct)
-
-	w.Header().Set("Content-Type", "application-json")
-	json.NewEncoder(w).Encode(res)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toReviewRes(updated))
 }
 
-func (h *handler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	idString := vars["id"]
-	id, err := strconv.ParseUint(idString, 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+func (h *handler) deleteReview(w http.ResponseWriter, r *http.Request) {
+	rv, _, ok := h.ownedReview(w, r)
+	if !ok {
 		return
 	}
-	err = h.server.DeleteProduct(h.Ctx, uint(id))
-	if err != nil {
-		if errors.Is(err, storer.ErrProductNotFound) {
-			http.Error(w, "Product not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Error deleting product", http.StatusInternalServerError)
-		}
+	if err := h.server.DeleteReview(h.Ctx, rv.ID); err != nil {
+		writeReviewError(w, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (h *handler) createOrder(w http.ResponseWriter, r *http.Request) {
+func (h *handler) moderateReview(w http.ResponseWriter, r *http.Request) {
 	claims := r.Context().Value(authKey{}).(*token.UserClaims)
-	var orderReq OrderReq
-	err := json.NewDecoder(r.Body).Decode(&orderReq)
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
 	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	var req ReviewStatusReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-
-	validationErrors := ValidateStruct(h.validate, orderReq)
-	if len(validationErrors) > 0 {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"errors": validationErrors,
-		})
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
 		return
 	}
 
-	so := toStorerOrder(orderReq)
-	so.UserID = claims.ID
-
-	created, err := h.server.CreateOrder(h.Ctx, so)
+	rv, err := h.server.ModerateReview(h.Ctx, uint(id), storer.ReviewStatus(req.Status), claims.ID)
 	if err != nil {
-		http.Error(w, "error creating order", http.StatusInternalServerError)
+		writeReviewError(w, err)
 		return
 	}
-
-	res := toOrderRes(created)
-	w.Header().Set("Content-Type", "application-json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(res)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toReviewRes(rv))
 }
 
-func (h *handler) getOrder(w http.ResponseWriter, r *http.Request) {
+// ownedReview loads the review named by the {id} path variable when the
+// caller wrote it or is an admin. Anyone else gets the same 404 as for a
+// missing review. It writes the error response itself.
+func (h *handler) ownedReview(w http.ResponseWriter, r *http.Request) (*storer.Review, *token.UserClaims, bool) {
 	claims := r.Context().Value(authKey{}).(*token.UserClaims)
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	rv, err := h.server.GetReview(h.Ctx, uint(id))
+	if errors.Is(err, storer.ErrReviewNotFound) || (err == nil && rv.UserID != claims.ID && !claims.IsAdmin) {
+		http.Error(w, "review not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if err != nil {
+		http.Error(w, "error getting review", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	return rv, claims, true
+}
+
+func writeReviewError(w http.ResponseWriter, err error) {
+	var invalid *server.ReviewError
+	switch {
+	case errors.As(err, &invalid):
+		writeValidationErrors(w, []ValidationError{{Field: invalid.Field, Error: invalid.Reason}})
+	case errors.Is(err, storer.ErrProductNotFound):
+		http.Error(w, "product not found", http.StatusNotFound)
+	case errors.Is(err, storer.ErrReviewNotFound):
+		http.Error(w, "review not found", http.StatusNotFound)
+	case errors.Is(err, storer.ErrReviewExists):
+		http.Error(w, "you already reviewed this product", http.StatusConflict)
+	case errors.Is(err, server.ErrReviewNotAllowed):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, "error saving review", http.StatusInternalServerError)
+	}
+}
+
+// cartTokenHeader carries the token of a guest cart. It is issued in the
+// response that creates the cart and sent back on later cart requests.
+const cartTokenHeader = "X-Cart-Token"
+
+// cartOwner resolves whose cart the request works on. Signed-in users always
+// get their own cart; a guest token they still send is ignored, it was
+// merged when they logged in.
+func cartOwner(r *http.Request) server.CartOwner {
+	if claims, ok := r.Context().Value(authKey{}).(*token.UserClaims); ok {
+		return server.CartOwner{UserID: claims.ID}
+	}
+	return server.CartOwner{Token: r.Header.Get(cartTokenHeader)}
+}
 
-	o, err := h.server.GetOrder(h.Ctx, claims.ID)
+func (h *handler) getCart(w http.ResponseWriter, r *http.Request) {
+	view, err := h.server.GetCart(h.Ctx, cartOwner(r))
 	if err != nil {
-		http.Error(w, "error getting order", http.StatusInternalServerError)
+		http.Error(w, "error getting cart", http.StatusInternalServerError)
 		return
 	}
-	res := toOrderRes(o)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(res)
+	writeCart(w, view)
 }
 
-func (h *handler) listOrders(w http.ResponseWriter, r *http.Request) {
-	orders, err := h.server.ListOrders(h.Ctx)
+func (h *handler) addCartItem(w http.ResponseWriter, r *http.Request) {
+	var req CartItemReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+	view, err := h.server.AddCartItem(h.Ctx, cartOwner(r), req.ProductID, req.VariantID, req.Quantity)
 	if err != nil {
-		http.Error(w, "internal server error", http.StatusInternalServerError)
+		writeCartError(w, err)
 		return
 	}
+	if view.Token != "" {
+		w.Header().Set(cartTokenHeader, view.Token)
+	}
+	writeCart(w, view)
+}
 
-	var res []OrderRes
-	for _, o := range orders {
-		res = append(res, toOrderRes(&o))
+// cartLine reads the line a cart item route works on: the product in the
+// path and, for products sold by variant, the variant_id query parameter.
+func cartLine(w http.ResponseWriter, r *http.Request) (productID uint, variantID *uint, ok bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["product_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return 0, nil, false
 	}
+	q := newQueryParser(r)
+	variantID = q.uint("variant_id")
+	if len(q.errors) > 0 {
+		writeValidationErrors(w, q.errors)
+		return 0, nil, false
+	}
+	return uint(id), variantID, true
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(res)
+func (h *handler) updateCartItem(w http.ResponseWriter, r *http.Request) {
+	productID, variantID, ok := cartLine(w, r)
+	if !ok {
+		return
+	}
+	var req CartQuantityReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+	view, err := h.server.SetCartItem(h.Ctx, cartOwner(r), productID, variantID, *req.Quantity)
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
+	writeCart(w, view)
 }
 
-func (h *handler) deleteOrder(w http.ResponseWriter, r *http.Request) {
-	claims := r.Context().Value(authKey{}).(*token.UserClaims)
-	err := h.server.DeleteOrder(h.Ctx, claims.ID)
+func (h *handler) removeCartItem(w http.ResponseWriter, r *http.Request) {
+	productID, variantID, ok := cartLine(w, r)
+	if !ok {
+		return
+	}
+	view, err := h.server.RemoveCartItem(h.Ctx, cartOwner(r), productID, variantID)
 	if err != nil {
-		if errors.Is(err, storer.ErrOrderNotFound) {
-			http.Error(w, "Order not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Error deleting order", http.StatusInternalServerError)
+		writeCartError(w, err)
+		return
+	}
+	writeCart(w, view)
+}
+
+func (h *handler) checkoutCart(w http.ResponseWriter, r *http.Request) {
+	owner := cartOwner(r)
+	var co server.Checkout
+	if claims, ok := r.Context().Value(authKey{}).(*token.UserClaims); ok {
+		var req CheckoutReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+			writeValidationErrors(w, validationErrors)
+			return
+		}
+		co = server.Checkout{
+			PaymentMethod:   req.PaymentMethod,
+			Email:           claims.Email,
+			ShippingAddress: toShippingAddress(req.ShippingAddress),
+			AddressID:       req.AddressID,
+			CouponCode:      req.CouponCode,
+		}
+	} else {
+		var req GuestCheckoutReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if validationErrors := ValidateStruct(h.validate, req); len(validationErrors) > 0 {
+			writeValidationErrors(w, validationErrors)
+			return
+		}
+		co = server.Checkout{
+			PaymentMethod:   req.PaymentMethod,
+			Email:           req.Email,
+			ShippingAddress: toShippingAddress(req.ShippingAddress),
+			CouponCode:      req.CouponCode,
+		}
+	}
+
+	created, accessToken, err := h.server.CheckoutCart(h.Ctx, owner, co)
+	if err != nil {
+		if errors.Is(err, server.ErrEmptyCart) {
+			http.Error(w, "cart is empty", http.StatusBadRequest)
+			return
 		}
+		writeCreateOrderError(w, err)
 		return
 	}
-	w.WriteHeader(http.StatusNoContent)
+	if accessToken != "" {
+		writeGuestOrder(w, created, accessToken)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toOrderRes(created))
+}
+
+func writeCartError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storer.ErrProductNotFound):
+		http.Error(w, "product not found", http.StatusNotFound)
+	case errors.Is(err, server.ErrProductUnavailable):
+		http.Error(w, "product is not available", http.StatusConflict)
+	case errors.Is(err, server.ErrVariantRequired):
+		http.Error(w, "product is sold by variant, a variant_id is required", http.StatusUnprocessableEntity)
+	case errors.Is(err, storer.ErrVariantNotFound):
+		http.Error(w, "variant not found", http.StatusNotFound)
+	case errors.Is(err, storer.ErrCartNotFound):
+		http.Error(w, "cart not found", http.StatusNotFound)
+	case errors.Is(err, storer.ErrCartItemNotFound):
+		http.Error(w, "product is not in the cart", http.StatusNotFound)
+	default:
+		http.Error(w, "error updating cart", http.StatusInternalServerError)
+	}
+}
+
+func writeCart(w http.ResponseWriter, view *server.CartView) {
+	res := CartRes{
+		Token:         view.Token,
+		Items:         []CartItemRes{},
+		ItemsPrice:    view.Pricing.Subtotal,
+		TaxPrice:      view.Pricing.Tax,
+		TaxIncluded:   view.Pricing.TaxIncluded,
+		ShippingPrice: view.Pricing.Shipping,
+		TotalPrice:    view.Pricing.Total,
+		HasWarnings:   view.HasWarnings(),
+	}
+	if view.Cart != nil {
+		res.UpdatedAt = toTimePtr(view.Cart.UpdatedAt)
+	}
+	for _, l := range view.Lines {
+		res.Items = append(res.Items, CartItemRes{
+			ProductID: l.ProductID,
+			VariantID: l.VariantID,
+			SKU:       l.SKU,
+			Name:      l.Name,
+			Image:     l.Image,
+			Quantity:  l.Quantity,
+			UnitPrice: l.UnitPrice,
+			Total:     l.Total,
+			Available: l.Available,
+			Warning:   l.Warning,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
 }
 
 func (h *handler) createUser(w http.ResponseWriter, r *http.Request) {
@@ -274,23 +2047,31 @@ func (h *handler) createUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *handler) listUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := h.server.ListUsers(h.Ctx)
+	q, validationErrors := parseUserQuery(r)
+	if len(validationErrors) > 0 {
+		writeValidationErrors(w, validationErrors)
+		return
+	}
+	page, err := h.server.ListUsers(h.Ctx, q)
 	if err != nil {
+		if errors.Is(err, storer.ErrInvalidQuery) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "error getting list users", http.StatusInternalServerError)
 		return
 	}
 
-	// var res []UserRes
-	// for _, user := range users {
-	// 	res = append(res, toUserRes(&user))
-	// }
-
-	var res ListUserRes
-	for _, u := range users {
+	res := ListUserRes{
+		Users:      []UserRes{},
+		Total:      page.Total,
+		NextCursor: page.NextCursor,
+	}
+	for _, u := range page.Items {
 		res.Users = append(res.Users, toUserRes(&u))
 	}
 
-	w.Header().Set("Content-Type", "application-json")
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(res)
 }
 
@@ -381,15 +2162,22 @@ func (h *handler) loginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Printf("User data: ID=%v, Email=%s, IsAdmin=%v", u.ID, u.Email, u.IsAdmin)
+	// a guest cart filled before logging in carries over to the account;
+	// failing to merge it must not fail the login
+	if cartToken := r.Header.Get(cartTokenHeader); cartToken != "" {
+		if err := h.server.MergeGuestCart(h.Ctx, cartToken, u.ID); err != nil {
+			log.Printf("Error merging guest cart: %v", err)
+		}
+	}
 	// Create JWT and return it as response
-	accessToken, ATclaims, err := h.TokenMaker.CreateToken(u.ID, u.Email, u.IsAdmin, 60*time.Minute)
+	accessToken, ATclaims, err := h.TokenMaker.CreateToken(u.ID, u.Email, u.IsAdmin, h.TokenMaker.AccessTokenDuration)
 	if err != nil {
 		log.Printf("Error creating accesstoken: %v", err)
 		http.Error(w, "error creating token", http.StatusInternalServerError)
 		return
 	}
 
-	refreshToken, RTclaims, err := h.TokenMaker.CreateToken(u.ID, u.Email, u.IsAdmin, 24*time.Hour)
+	refreshToken, RTclaims, err := h.TokenMaker.CreateToken(u.ID, u.Email, u.IsAdmin, h.TokenMaker.RefreshTokenDuration)
 	if err != nil {
 		log.Printf("Error creating refreshtoken: %v", err)
 		http.Error(w, "error creating token", http.StatusInternalServerError)
@@ -469,7 +2257,7 @@ func (h *handler) renewAccessToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Generate New AccessToken
-	accessToken, ATClaims, err := h.TokenMaker.CreateToken(claims.ID, claims.Email, claims.IsAdmin, 60*time.Minute)
+	accessToken, ATClaims, err := h.TokenMaker.CreateToken(claims.ID, claims.Email, claims.IsAdmin, h.TokenMaker.AccessTokenDuration)
 	if err != nil {
 		http.Error(w, "error creating token", http.StatusInternalServerError)
 		return
@@ -532,38 +2320,189 @@ func toUserRes(u *storer.User) UserRes {
 
 func toStorerOrder(o OrderReq) *storer.Order {
 	return &storer.Order{
-		TaxPrice:      o.TaxPrice,
-		TotalPrice:    o.TotalPrice,
-		PaymentMethod: o.PaymentMethod,
-		ShippingPrice: o.ShippingPrice,
-		Items:         toStorerOrderItem(o.Items),
+		PaymentMethod:   o.PaymentMethod,
+		ShippingAddress: toShippingAddress(o.ShippingAddress),
+		Items:           toStorerOrderItem(o.Items),
+	}
+}
+
+func toShippingAddress(a *ShippingAddressReq) storer.ShippingAddress {
+	if a == nil {
+		return storer.ShippingAddress{}
+	}
+	return storer.ShippingAddress{
+		Name:       a.Name,
+		Line1:      a.Line1,
+		Line2:      a.Line2,
+		City:       a.City,
+		PostalCode: a.PostalCode,
+		Country:    strings.ToUpper(a.Country),
 	}
 }
 
-func toStorerOrderItem(items []OrderItem) []storer.OrderItem {
+func toStorerOrderItem(items []OrderItemReq) []storer.OrderItem {
 	var res []storer.OrderItem
 	for _, item := range items {
 		res = append(res, storer.OrderItem{
-			Image:     item.Image,
-			Name:      item.Name,
-			Price:     item.Price,
 			Quantity:  item.Quantity,
 			ProductID: item.ProductID,
+			VariantID: item.VariantID,
 		})
 	}
 	return res
 }
 
 func toOrderRes(o *storer.Order) OrderRes {
-	return OrderRes{
-		ID:            o.ID,
-		ShippingPrice: o.ShippingPrice,
-		PaymentMethod: o.PaymentMethod,
-		TotalPrice:    o.TotalPrice,
-		TaxPrice:      o.TaxPrice,
-		CreatedAt:     o.CreatedAt,
-		UpdatedAt:     o.UpdatedAt,
-		Items:         toOrderItem(o.Items),
+	res := OrderRes{
+		ID:             o.ID,
+		ShippingPrice:  o.ShippingPrice,
+		Status:         string(o.Status),
+		PaymentMethod:  o.PaymentMethod,
+		ItemsPrice:     o.ItemsPrice,
+		DiscountPrice:  o.DiscountPrice,
+		TotalPrice:     o.TotalPrice,
+		TaxPrice:       o.TaxPrice,
+		TaxIncluded:    o.TaxBreakdown.Inclusive,
+		Email:          o.Email,
+		RefundedAmount: o.RefundedAmount,
+		CreatedAt:      o.CreatedAt,
+		UpdatedAt:      o.UpdatedAt,
+		Items:          toOrderItem(o.Items),
+	}
+	if !o.ShippingAddress.IsZero() {
+		a := o.ShippingAddress
+		res.ShippingAddress = &ShippingAddressRes{
+			Name:       a.Name,
+			Line1:      a.Line1,
+			Line2:      a.Line2,
+			City:       a.City,
+			PostalCode: a.PostalCode,
+			Country:    a.Country,
+		}
+	}
+	for _, d := range o.Discounts {
+		res.Discounts = append(res.Discounts, OrderDiscountRes{PromotionID: d.PromotionID, Code: d.Code, Name: d.Name, Kind: string(d.Kind), Amount: d.Amount})
+	}
+	if !o.TaxBreakdown.IsZero() {
+		res.TaxBreakdown = &TaxBreakdownRes{Country: o.TaxBreakdown.Country, Lines: []TaxLineRes{}}
+		for _, l := range o.TaxBreakdown.Lines {
+			res.TaxBreakdown.Lines = append(res.TaxBreakdown.Lines, TaxLineRes{Category: l.Category, Rate: l.Rate, Taxable: l.Taxable, Tax: l.Tax})
+		}
+	}
+	return res
+}
+
+func toStorerPromotion(req PromotionReq) *storer.Promotion {
+	p := &storer.Promotion{
+		Name:         req.Name,
+		Kind:         storer.PromotionKind(req.Kind),
+		Value:        req.Value,
+		BuyQuantity:  req.BuyQuantity,
+		GetQuantity:  req.GetQuantity,
+		MinOrder:     req.MinOrder,
+		Scope:        storer.PromotionScope{ProductIDs: req.ProductIDs, Categories: req.Categories},
+		StartsAt:     req.StartsAt,
+		EndsAt:       req.EndsAt,
+		UsageLimit:   req.UsageLimit,
+		PerUserLimit: req.PerUserLimit,
+		IsActive:     req.IsActive,
+	}
+	if req.Code != "" {
+		p.Code = &req.Code
+	}
+	return p
+}
+
+func toPromotionRes(p *storer.Promotion) PromotionRes {
+	res := PromotionRes{
+		ID:           p.ID,
+		Name:         p.Name,
+		Kind:         string(p.Kind),
+		Value:        p.Value,
+		BuyQuantity:  p.BuyQuantity,
+		GetQuantity:  p.GetQuantity,
+		MinOrder:     p.MinOrder,
+		ProductIDs:   p.Scope.ProductIDs,
+		Categories:   p.Scope.Categories,
+		StartsAt:     p.StartsAt,
+		EndsAt:       p.EndsAt,
+		UsageLimit:   p.UsageLimit,
+		PerUserLimit: p.PerUserLimit,
+		UsedCount:    p.UsedCount,
+		IsActive:     p.IsActive,
+		CreatedAt:    p.CreatedAt,
+		UpdatedAt:    p.UpdatedAt,
+	}
+	if p.Code != nil {
+		res.Code = *p.Code
+	}
+	if res.ProductIDs == nil {
+		res.ProductIDs = []uint{}
+	}
+	if res.Categories == nil {
+		res.Categories = []string{}
+	}
+	return res
+}
+
+func toAddressRes(a *storer.Address) AddressRes {
+	return AddressRes{
+		ID: a.ID,
+		ShippingAddressRes: ShippingAddressRes{
+			Name:       a.Name,
+			Line1:      a.Line1,
+			Line2:      a.Line2,
+			City:       a.City,
+			PostalCode: a.PostalCode,
+			Country:    a.Country,
+		},
+		IsDefault: a.IsDefault,
+		CreatedAt: a.CreatedAt,
+		UpdatedAt: a.UpdatedAt,
+	}
+}
+
+func toPaymentRes(p *storer.Payment) PaymentRes {
+	return PaymentRes{
+		ID:            p.ID,
+		Provider:      p.Provider,
+		IntentID:      p.IntentID,
+		CaptureID:     p.CaptureID,
+		Status:        string(p.Status),
+		Amount:        p.Amount,
+		Currency:      p.Currency,
+		FailureReason: p.FailureReason,
+		CreatedAt:     p.CreatedAt,
+		UpdatedAt:     p.UpdatedAt,
+	}
+}
+
+func toReturnRes(rr *storer.ReturnRequest) ReturnRes {
+	return ReturnRes{
+		ID:          rr.ID,
+		OrderID:     rr.OrderID,
+		OrderItemID: rr.OrderItemID,
+		Quantity:    rr.Quantity,
+		Reason:      string(rr.Reason),
+		Comment:     rr.Comment,
+		Status:      string(rr.Status),
+		ReviewNote:  rr.ReviewNote,
+		CreatedAt:   rr.CreatedAt,
+		UpdatedAt:   rr.UpdatedAt,
+	}
+}
+
+func toRefundRes(rf *storer.Refund) RefundRes {
+	return RefundRes{
+		ID:               rf.ID,
+		OrderID:          rf.OrderID,
+		ReturnID:         rf.ReturnID,
+		Amount:           rf.Amount,
+		Reason:           rf.Reason,
+		Status:           string(rf.Status),
+		ProviderRefundID: rf.ProviderRefundID,
+		FailureReason:    rf.FailureReason,
+		CreatedAt:        rf.CreatedAt,
 	}
 }
 
@@ -571,11 +2510,16 @@ func toOrderItem(items []storer.OrderItem) []OrderItem {
 	var res []OrderItem
 	for _, item := range items {
 		res = append(res, OrderItem{
+			ID:        item.ID,
 			Name:      item.Name,
 			Quantity:  item.Quantity,
 			Image:     item.Image,
 			Price:     item.Price,
+			Tax:       item.Tax,
+			Discount:  item.Discount,
 			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			SKU:       item.SKU,
 		})
 	}
 	return res
@@ -588,24 +2532,41 @@ func patchProductReq(product *storer.Product, p ProductReq) {
 	if p.Image != "" {
 		product.Image = p.Image
 	}
-	if p.Category != "" {
-		product.Category = p.Category
+	if p.CategoryID != nil {
+		product.CategoryID = p.CategoryID
+	} else if p.Category != "" {
+		product.Category, product.CategoryID = p.Category, nil
 	}
 	if p.Description != "" {
 		product.Description = p.Description
 	}
-	if p.Rating != 0 {
-		product.Rating = p.Rating
-	}
-	if p.NumReviews != 0 {
-		product.NumReviews = p.NumReviews
-	}
 	if p.Price != 0 {
 		product.Price = p.Price
 	}
 	if p.CountInStock != 0 {
 		product.CountInStock = p.CountInStock
 	}
+	if p.IsActive != nil {
+		product.IsActive = *p.IsActive
+	}
+	if p.Weight != 0 {
+		product.Weight = p.Weight
+	}
+	if p.Length != 0 {
+		product.Length = p.Length
+	}
+	if p.Width != 0 {
+		product.Width = p.Width
+	}
+	if p.Height != 0 {
+		product.Height = p.Height
+	}
+	if p.TaxCategory != "" {
+		product.TaxCategory = p.TaxCategory
+	}
+	if p.Options != nil {
+		product.Options = toStorerOptions(p.Options)
+	}
 	product.UpdatedAt = time.Now()
 }
 
@@ -619,11 +2580,70 @@ func toStorerProduct(p ProductReq) *storer.Product {
 		CountInStock: p.CountInStock,
 		Image:        p.Image,
 		Category:     p.Category,
+		CategoryID:   p.CategoryID,
 		Description:  p.Description,
-		Rating:       p.Rating,
-		NumReviews:   p.NumReviews,
 		Price:        p.Price,
+		IsActive:     p.IsActive == nil || *p.IsActive,
+		Weight:       p.Weight,
+		Length:       p.Length,
+		Width:        p.Width,
+		Height:       p.Height,
+		TaxCategory:  cmp.Or(p.TaxCategory, tax.StandardCategory),
+		Options:      toStorerOptions(p.Options),
+	}
+}
+
+func toReviewRes(r *storer.Review) ReviewRes {
+	return ReviewRes{
+		ID:          r.ID,
+		ProductID:   r.ProductID,
+		UserID:      r.UserID,
+		Rating:      r.Rating,
+		Title:       r.Title,
+		Body:        r.Body,
+		Status:      string(r.Status),
+		ModeratedBy: r.ModeratedBy,
+		CreatedAt:   r.CreatedAt,
+		UpdatedAt:   r.UpdatedAt,
+	}
+}
+
+func toStorerCategory(c CategoryReq) *storer.Category {
+	return &storer.Category{
+		ParentID: c.ParentID,
+		Name:     c.Name,
+		Slug:     c.Slug,
+		Position: c.Position,
+	}
+}
+
+func toCategoryRes(c storer.Category, children []storer.CategoryNode) CategoryRes {
+	return CategoryRes{
+		ID:        c.ID,
+		ParentID:  c.ParentID,
+		Name:      c.Name,
+		Slug:      c.Slug,
+		Position:  c.Position,
+		Children:  toCategoryTreeRes(children),
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+	}
+}
+
+func toCategoryTreeRes(nodes []storer.CategoryNode) []CategoryRes {
+	res := make([]CategoryRes, 0, len(nodes))
+	for _, n := range nodes {
+		res = append(res, toCategoryRes(n.Category, n.Children))
+	}
+	return res
+}
+
+func toStorerOptions(options []ProductOptionReq) storer.ProductOptions {
+	res := storer.ProductOptions{}
+	for _, o := range options {
+		res = append(res, storer.ProductOption{Name: o.Name, Values: o.Values})
 	}
+	return res
 }
 
 func toProductRes(p *storer.Product) ProductRes {
@@ -632,12 +2652,73 @@ func toProductRes(p *storer.Product) ProductRes {
 		Name:         p.Name,
 		Image:        p.Image,
 		Category:     p.Category,
+		CategoryID:   p.CategoryID,
 		Description:  p.Description,
 		Rating:       p.Rating,
 		NumReviews:   p.NumReviews,
 		Price:        p.Price,
 		CountInStock: p.CountInStock,
+		IsActive:     p.IsActive,
+		Weight:       p.Weight,
+		Length:       p.Length,
+		Width:        p.Width,
+		Height:       p.Height,
+		TaxCategory:  p.TaxCategory,
 		CreatedAt:    p.CreatedAt,
 		UpdatedAt:    p.UpdatedAt,
+		Options:      toProductOptionRes(p.Options),
+	}
+}
+
+func toProductOptionRes(options storer.ProductOptions) []ProductOptionRes {
+	var res []ProductOptionRes
+	for _, o := range options {
+		res = append(res, ProductOptionRes{Name: o.Name, Values: o.Values})
+	}
+	return res
+}
+
+func toProductImageRes(img *storer.ProductImage) ProductImageRes {
+	return ProductImageRes{
+		ID:           img.ID,
+		ProductID:    img.ProductID,
+		URL:          img.URL,
+		ThumbnailURL: img.ThumbnailURL,
+		ContentType:  img.ContentType,
+		Size:         img.Size,
+		Width:        img.Width,
+		Height:       img.Height,
+		AltText:      img.AltText,
+		Position:     img.Position,
+		CreatedAt:    img.CreatedAt,
+		UpdatedAt:    img.UpdatedAt,
+	}
+}
+
+func toStorerVariant(v VariantReq) *storer.ProductVariant {
+	return &storer.ProductVariant{
+		SKU:          v.SKU,
+		Options:      v.Options,
+		Price:        v.PriceOverride,
+		CountInStock: v.CountInStock,
+		Image:        v.Image,
+		IsActive:     v.IsActive == nil || *v.IsActive,
+	}
+}
+
+func toVariantRes(v *storer.ProductVariant, p *storer.Product) VariantRes {
+	return VariantRes{
+		ID:            v.ID,
+		ProductID:     v.ProductID,
+		SKU:           v.SKU,
+		Label:         v.Label(p.Options),
+		Options:       v.Options,
+		Price:         v.PriceOf(p),
+		PriceOverride: v.Price,
+		Image:         cmp.Or(v.Image, p.Image),
+		CountInStock:  v.CountInStock,
+		IsActive:      v.IsActive,
+		CreatedAt:     v.CreatedAt,
+		UpdatedAt:     v.UpdatedAt,
 	}
 }
@@ -1,11 +1,26 @@
 package handler
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/server"
+	"ecom_apiv1/internal/storer"
 	"ecom_apiv1/token"
+	"ecom_apiv1/util"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type authKey struct{}
@@ -48,6 +63,193 @@ func GetAdminMiddlewareFunc(tokenMaker *token.JWTMaker) func(next http.Handler)
 	}
 }
 
+// GetOptionalAuthMiddlewareFunc lets anonymous requests through without
+// claims. A request that does send a token still has to send a valid one.
+func GetOptionalAuthMiddlewareFunc(tokenMaker *token.JWTMaker) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			claims, err := verifyClaimsFromHeader(r, tokenMaker)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error verifying token: %v", err), http.StatusUnauthorized)
+				return
+			}
+			ctx := context.WithValue(r.Context(), authKey{}, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	maxIdempotencyKeyLen = 255
+	// maxIdempotentBodyBytes bounds the request bodies read for fingerprinting.
+	maxIdempotentBodyBytes = 1 << 20
+)
+
+// GetIdempotencyMiddlewareFunc makes mutating requests sent with an
+// Idempotency-Key header safe to retry. The first request with a key is
+// handled and its response kept for cfg.TTL; a repeat of the same request
+// gets that response replayed, a different request with the same key gets
+// 409 and a repeat while the first is still running gets 425, for at most
+// cfg.Lease. Keys are scoped to the signed-in user or guest, so it must run
+// after the auth middleware.
+func GetIdempotencyMiddlewareFunc(srv *server.Server, cfg config.IdempotencyConfig) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyKeyHeader)
+			if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLen {
+				http.Error(w, fmt.Sprintf("idempotency key must be at most %d characters", maxIdempotencyKeyLen), http.StatusBadRequest)
+				return
+			}
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodyBytes))
+			if err != nil {
+				http.Error(w, "error reading request body", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			k := &storer.IdempotencyKey{
+				Scope:       idempotencyScope(r),
+				Key:         key,
+				Fingerprint: requestFingerprint(r, body),
+				ExpiresAt:   time.Now().Add(cfg.Lease),
+			}
+			stored, err := srv.BeginIdempotentRequest(r.Context(), k)
+			switch {
+			case errors.Is(err, server.ErrIdempotencyKeyReused):
+				http.Error(w, "idempotency key was already used for a different request", http.StatusConflict)
+				return
+			case errors.Is(err, server.ErrRequestInFlight):
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "a request with this idempotency key is still in progress", http.StatusTooEarly)
+				return
+			case err != nil:
+				log.Printf("error claiming idempotency key: %v", err)
+				http.Error(w, "error processing idempotency key", http.StatusInternalServerError)
+				return
+			case stored != nil:
+				if stored.ContentType != "" {
+					w.Header().Set("Content-Type", stored.ContentType)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.StatusCode)
+				w.Write(stored.Body)
+				return
+			}
+
+			rec := &responseRecorder{ResponseWriter: w}
+			defer func() {
+				// a panicking handler must not leave the key in flight
+				if p := recover(); p != nil {
+					srv.FinishIdempotentRequest(context.Background(), k.ID, http.StatusInternalServerError, "", nil, time.Time{})
+					panic(p)
+				}
+			}()
+			next.ServeHTTP(rec, r)
+			contentType := w.Header().Get("Content-Type")
+			err = srv.FinishIdempotentRequest(context.Background(), k.ID, rec.status(), contentType,
+				withoutCredentials(contentType, rec.body.Bytes()), time.Now().Add(cfg.TTL))
+			if err != nil {
+				log.Printf("error storing idempotent response: %v", err)
+			}
+		})
+	}
+}
+
+// idempotencyScope keeps the keys of signed-in users apart from each other
+// and from guests. Guests are told apart by their cart token or, before
+// they have one, by their address; both are hashed to fit the scope column
+// and stay out of the database.
+func idempotencyScope(r *http.Request) string {
+	if claims, ok := r.Context().Value(authKey{}).(*token.UserClaims); ok {
+		return "user:" + strconv.FormatUint(uint64(claims.ID), 10)
+	}
+	if cartToken := r.Header.Get(cartTokenHeader); cartToken != "" {
+		return "cart:" + util.HashToken(cartToken)[:32]
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "addr:" + util.HashToken(host)[:32]
+}
+
+// credentialFields are the response fields that hand out tokens. Stored
+// responses go without them: an idempotency key is no secret, and replaying
+// a token to whoever repeats the request would give it away.
+var credentialFields = []string{"access_token", "refresh_token", "token"}
+
+// withoutCredentials returns body with the credentialFields of a JSON
+// object response removed. Other bodies are returned as they are.
+func withoutCredentials(contentType string, body []byte) []byte {
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "application/json" {
+		return body
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return body
+	}
+	found := false
+	for _, name := range credentialFields {
+		if _, ok := fields[name]; ok {
+			delete(fields, name)
+			found = true
+		}
+	}
+	if !found {
+		return body
+	}
+	redacted, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return redacted
+}
+
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder passes a response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(code int) {
+	if rr.code == 0 {
+		rr.code = code
+	}
+	rr.ResponseWriter.WriteHeader(code)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.code == 0 {
+		rr.code = http.StatusOK
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+func (rr *responseRecorder) status() int {
+	if rr.code == 0 {
+		return http.StatusOK
+	}
+	return rr.code
+}
+
 func verifyClaimsFromHeader(r *http.Request, tokenMaker *token.JWTMaker) (*token.UserClaims, error) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"context"
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/pricing"
+	"ecom_apiv1/internal/server"
+	"ecom_apiv1/internal/storer"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	srv := server.NewServer(storer.NewMemoryStorage(), pricing.NewCalculator(config.PricingConfig{}), nil)
+	idempotent := GetIdempotencyMiddlewareFunc(srv, config.IdempotencyConfig{TTL: time.Hour, Lease: time.Minute})
+
+	// each subtest sets respond; calls counts the requests that got through
+	var calls int
+	var respond func(w http.ResponseWriter, r *http.Request)
+	h := idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		respond(w, r)
+	}))
+	created := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"id":%d}`, calls)
+	}
+	send := func(key, body string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/orders", strings.NewReader(body))
+		req.Header.Set(idempotencyKeyHeader, key)
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("replays the response to a repeated request", func(t *testing.T) {
+		calls, respond = 0, created
+		first := send("replay", `{"qty":1}`)
+		again := send("replay", `{"qty":1}`)
+		if calls != 1 || again.Code != http.StatusCreated || again.Body.String() != first.Body.String() || again.Header().Get("Idempotent-Replayed") != "true" {
+			t.Errorf("calls = %d, replay = %d %q %v", calls, again.Code, again.Body.String(), again.Header())
+		}
+	})
+
+	t.Run("rejects another request with the same key", func(t *testing.T) {
+		calls, respond = 0, created
+		send("mismatch", `{"qty":1}`)
+		if rec := send("mismatch", `{"qty":2}`); rec.Code != http.StatusConflict || calls != 1 {
+			t.Errorf("status = %d, calls = %d, want 409 and 1", rec.Code, calls)
+		}
+	})
+
+	t.Run("answers 425 while the first request runs", func(t *testing.T) {
+		calls = 0
+		started, release := make(chan struct{}), make(chan struct{})
+		respond = func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			created(w, r)
+		}
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			send("slow", `{}`)
+		}()
+		<-started
+		rec := send("slow", `{}`)
+		close(release)
+		wg.Wait()
+		if rec.Code != http.StatusTooEarly || rec.Header().Get("Retry-After") == "" || calls != 1 {
+			t.Errorf("status = %d, calls = %d, want 425 with Retry-After", rec.Code, calls)
+		}
+	})
+
+	t.Run("releases the key after a server error", func(t *testing.T) {
+		calls = 0
+		respond = func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+		send("flaky", `{}`)
+		respond = created
+		rec := send("flaky", `{}`)
+		if rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "" || calls != 2 {
+			t.Errorf("retry = %d %v, calls = %d, want it handled afresh", rec.Code, rec.Header(), calls)
+		}
+	})
+
+	t.Run("keeps the keys of guests apart", func(t *testing.T) {
+		calls, respond = 0, created
+		send("guest", `{"qty":1}`, cartTokenHeader, "cart-a")
+		if rec := send("guest", `{"qty":2}`, cartTokenHeader, "cart-b"); rec.Code != http.StatusCreated {
+			t.Errorf("another cart: status = %d, want 201", rec.Code)
+		}
+		if rec := send("guest", `{"qty":3}`); rec.Code != http.StatusCreated || calls != 3 {
+			t.Errorf("a guest without a cart: status = %d, calls = %d", rec.Code, calls)
+		}
+	})
+
+	t.Run("does not store tokens", func(t *testing.T) {
+		calls = 0
+		respond = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]any{"id": 1, "access_token": "secret"})
+		}
+		first := send("token", `{}`)
+		again := send("token", `{}`)
+		var replayed map[string]any
+		json.Unmarshal(again.Body.Bytes(), &replayed)
+		if !strings.Contains(first.Body.String(), "secret") || strings.Contains(again.Body.String(), "secret") || replayed["id"] != 1.0 {
+			t.Errorf("first = %s, replay = %s, want the token left out of the replay only", first.Body.String(), again.Body.String())
+		}
+	})
+}
+
+func TestGuestOrderReplayHidesAccessToken(t *testing.T) {
+	a := newTestAPI(t)
+	p, err := a.store.CreateProduct(context.Background(), &storer.Product{Name: "Lamp", Price: 40, CountInStock: 10, IsActive: true})
+	if err != nil {
+		t.Fatalf("CreateProduct: %v", err)
+	}
+	body := map[string]any{
+		"items":            []map[string]any{{"product_id": p.ID, "quantity": 1}},
+		"payment_method":   "Stripe",
+		"email":            "guest@example.com",
+		"shipping_address": map[string]any{"name": "Guest", "line1": "1 Main St", "city": "Springfield", "postal_code": "12345", "country": "US"},
+	}
+	place := func() *httptest.ResponseRecorder {
+		req := a.request("POST", "/guest/orders", "", body)
+		req.Header.Set(idempotencyKeyHeader, "guest-order")
+		return a.serve(req)
+	}
+
+	var first, again GuestOrderRes
+	decode(t, place(), http.StatusCreated, &first)
+	decode(t, place(), http.StatusCreated, &again)
+	if first.AccessToken == "" || again.AccessToken != "" || again.ID != first.ID {
+		t.Errorf("first = %d %q, replay = %d %q, want the same order without its token", first.ID, first.AccessToken, again.ID, again.AccessToken)
+	}
+	if o, err := a.srv.GetGuestOrder(context.Background(), first.AccessToken); err != nil || o.ID != first.ID {
+		t.Errorf("token of the first response does not open the order: %v", err)
+	}
+}
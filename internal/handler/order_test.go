@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestCancelOrder(t *testing.T) {
+	a := newTestAPI(t)
+	buyer, buyerToken := a.user("buyer@example.com", false)
+	_, otherToken := a.user("other@example.com", false)
+	_, adminToken := a.user("admin@example.com", true)
+	cancel := func(o *storer.Order, tok string) int {
+		return a.do("POST", fmt.Sprintf("/orders/%d/cancel", o.ID), tok, nil).Code
+	}
+
+	pending := a.order(&buyer.ID)
+	if code := cancel(pending, otherToken); code != http.StatusNotFound {
+		t.Errorf("cancelling someone else's order = %d, want 404", code)
+	}
+	var res OrderRes
+	decode(t, a.do("POST", fmt.Sprintf("/orders/%d/cancel", pending.ID), buyerToken, nil), http.StatusOK, &res)
+	if res.Status != string(storer.OrderCancelled) {
+		t.Errorf("cancelled order status = %s", res.Status)
+	}
+
+	for _, statuses := range [][]storer.OrderStatus{{storer.OrderPaid}, {storer.OrderPaid, storer.OrderProcessing}} {
+		paid := a.order(&buyer.ID, statuses...)
+		if code := cancel(paid, buyerToken); code != http.StatusConflict {
+			t.Errorf("customer cancelling a %s order = %d, want 409", paid.Status, code)
+		}
+		if got, _ := a.store.GetOrderByID(context.Background(), paid.ID); got.Status != paid.Status || got.RefundedAmount != 0 {
+			t.Errorf("order after a refused cancel = %s, refunded %v", got.Status, got.RefundedAmount)
+		}
+	}
+
+	paid := a.order(&buyer.ID, storer.OrderPaid)
+	decode(t, a.do("POST", fmt.Sprintf("/orders/%d/cancel", paid.ID), adminToken, nil), http.StatusOK, &res)
+	if res.Status != string(storer.OrderRefunded) || res.RefundedAmount != paid.TotalPrice {
+		t.Errorf("admin cancel of a paid order = %s, refunded %v, want refunded %v", res.Status, res.RefundedAmount, paid.TotalPrice)
+	}
+	if len(a.refunds.paid) != 1 || a.refunds.paid[0] != paid.TotalPrice {
+		t.Errorf("refunds paid out = %v", a.refunds.paid)
+	}
+}
+
+func TestUpdateOrderStatus(t *testing.T) {
+	a := newTestAPI(t)
+	buyer, buyerToken := a.user("buyer@example.com", false)
+	_, adminToken := a.user("admin@example.com", true)
+	path := func(o *storer.Order) string { return fmt.Sprintf("/orders/%d/status", o.ID) }
+
+	o := a.order(&buyer.ID, storer.OrderPaid)
+	if rec := a.do("PATCH", path(o), buyerToken, OrderStatusReq{Status: "refunded"}); rec.Code != http.StatusForbidden {
+		t.Errorf("customer status change = %d, want 403", rec.Code)
+	}
+	if rec := a.do("PATCH", path(o), adminToken, OrderStatusReq{Status: "paid"}); rec.Code != http.StatusConflict {
+		t.Errorf("marking an order paid by hand = %d, want 409", rec.Code)
+	}
+
+	var res OrderRes
+	decode(t, a.do("PATCH", path(o), adminToken, OrderStatusReq{Status: "refunded", Note: "damaged in transit"}), http.StatusOK, &res)
+	if res.Status != string(storer.OrderRefunded) || res.RefundedAmount != o.TotalPrice {
+		t.Errorf("order after refunded = %s, refunded %v", res.Status, res.RefundedAmount)
+	}
+	refunds, _ := a.srv.ListRefunds(context.Background(), o.ID)
+	if len(refunds) != 1 || refunds[0].Reason != "damaged in transit" || len(a.refunds.paid) != 1 {
+		t.Errorf("refunds = %+v, paid out %v", refunds, a.refunds.paid)
+	}
+
+	processing := a.order(&buyer.ID, storer.OrderPaid, storer.OrderProcessing)
+	decode(t, a.do("PATCH", path(processing), adminToken, OrderStatusReq{Status: "cancelled"}), http.StatusOK, &res)
+	if res.Status != string(storer.OrderRefunded) || len(a.refunds.paid) != 2 {
+		t.Errorf("cancelled before shipping = %s, paid out %v", res.Status, a.refunds.paid)
+	}
+
+	shipped := a.order(&buyer.ID, storer.OrderPaid, storer.OrderProcessing, storer.OrderShipped)
+	if rec := a.do("PATCH", path(shipped), adminToken, OrderStatusReq{Status: "cancelled"}); rec.Code != http.StatusConflict {
+		t.Errorf("cancelling a shipped order = %d, want 409", rec.Code)
+	}
+	delivered := a.order(&buyer.ID, storer.OrderPaid, storer.OrderProcessing, storer.OrderShipped, storer.OrderDelivered)
+	if rec := a.do("PATCH", path(delivered), adminToken, OrderStatusReq{Status: "cancelled"}); rec.Code != http.StatusConflict {
+		t.Errorf("cancelling a delivered order = %d, want 409", rec.Code)
+	}
+	if got, _ := a.store.GetOrderByID(context.Background(), delivered.ID); got.Status != storer.OrderDelivered || got.RefundedAmount != 0 {
+		t.Errorf("delivered order after a refused cancel = %s, refunded %v", got.Status, got.RefundedAmount)
+	}
+	if len(a.refunds.paid) != 2 {
+		t.Errorf("refused changes paid out %v", a.refunds.paid)
+	}
+}
+
+func TestDeleteOrder(t *testing.T) {
+	a := newTestAPI(t)
+	buyer, buyerToken := a.user("buyer@example.com", false)
+	_, otherToken := a.user("other@example.com", false)
+	_, adminToken := a.user("admin@example.com", true)
+	del := func(o *storer.Order, tok string) int {
+		return a.do("DELETE", fmt.Sprintf("/orders/%d", o.ID), tok, nil).Code
+	}
+
+	pending := a.order(&buyer.ID)
+	if code := del(pending, otherToken); code != http.StatusNotFound {
+		t.Errorf("deleting someone else's order = %d, want 404", code)
+	}
+	if code := del(pending, buyerToken); code != http.StatusNoContent {
+		t.Errorf("deleting a pending order = %d, want 204", code)
+	}
+
+	cancelled := a.order(&buyer.ID, storer.OrderCancelled)
+	if code := del(cancelled, buyerToken); code != http.StatusConflict {
+		t.Errorf("customer deleting a cancelled order = %d, want 409", code)
+	}
+	if code := del(cancelled, adminToken); code != http.StatusNoContent {
+		t.Errorf("admin deleting a cancelled order = %d, want 204", code)
+	}
+
+	for _, statuses := range [][]storer.OrderStatus{{storer.OrderPaid}, {storer.OrderPaid, storer.OrderRefunded}} {
+		o := a.order(&buyer.ID, statuses...)
+		if code := del(o, adminToken); code != http.StatusConflict {
+			t.Errorf("admin deleting a %s order = %d, want 409", o.Status, code)
+		}
+		if _, err := a.store.GetOrderByID(context.Background(), o.ID); err != nil {
+			t.Errorf("%s order is gone: %v", o.Status, err)
+		}
+	}
+}
+
+func TestRefundOrder(t *testing.T) {
+	a := newTestAPI(t)
+	buyer, buyerToken := a.user("buyer@example.com", false)
+	_, otherToken := a.user("other@example.com", false)
+	_, adminToken := a.user("admin@example.com", true)
+	o := a.order(&buyer.ID, storer.OrderPaid)
+	path := fmt.Sprintf("/orders/%d/refunds", o.ID)
+
+	if rec := a.do("POST", path, buyerToken, RefundReq{Amount: 10}); rec.Code != http.StatusForbidden {
+		t.Errorf("customer refunding their own order = %d, want 403", rec.Code)
+	}
+	if len(a.refunds.paid) != 0 {
+		t.Fatalf("refused refund paid out %v", a.refunds.paid)
+	}
+
+	var rf RefundRes
+	decode(t, a.do("POST", path, adminToken, RefundReq{Amount: 10, Reason: "late delivery"}), http.StatusCreated, &rf)
+	if rf.Amount != 10 || rf.Status != string(storer.RefundSucceeded) || rf.ProviderRefundID != "re_1" {
+		t.Errorf("refund = %+v", rf)
+	}
+	if rec := a.do("POST", path, adminToken, RefundReq{Amount: o.TotalPrice}); rec.Code != http.StatusConflict {
+		t.Errorf("refunding more than is left = %d, want 409", rec.Code)
+	}
+
+	if rec := a.do("GET", path, otherToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("listing someone else's refunds = %d, want 404", rec.Code)
+	}
+	var summary RefundSummaryRes
+	decode(t, a.do("GET", path, buyerToken, nil), http.StatusOK, &summary)
+	if len(summary.Refunds) != 1 || summary.RefundedAmount != 10 || summary.Remaining != o.TotalPrice-10 {
+		t.Errorf("refund summary = %+v", summary)
+	}
+}
+
+func TestOrderQuantityLimit(t *testing.T) {
+	a := newTestAPI(t)
+	_, buyerToken := a.user("buyer@example.com", false)
+	items := []OrderItemReq{{ProductID: 1, Quantity: 1_000_000_000}}
+
+	if rec := a.do("POST", "/shipping/quote", "", ShippingQuoteReq{Items: items, Country: "US"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("quote for a billion units = %d, want 400", rec.Code)
+	}
+	if rec := a.do("POST", "/orders", buyerToken, OrderReq{Items: items, PaymentMethod: "Stripe"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("order of a billion units = %d, want 400", rec.Code)
+	}
+}
+
+func TestGuestOrderAccess(t *testing.T) {
+	a := newTestAPI(t)
+	_, otherToken := a.user("other@example.com", false)
+	guest, guestToken := a.user("guest@example.com", false)
+	p, _ := a.store.CreateProduct(context.Background(), &storer.Product{Name: "Lamp", Price: 40, CountInStock: 10, IsActive: true})
+	var placed GuestOrderRes
+	decode(t, a.do("POST", "/guest/orders", "", map[string]any{
+		"items":            []map[string]any{{"product_id": p.ID, "quantity": 1}},
+		"payment_method":   "Stripe",
+		"email":            "guest@example.com",
+		"shipping_address": map[string]any{"name": "Guest", "line1": "1 Main St", "city": "Springfield", "postal_code": "12345", "country": "US"},
+	}), http.StatusCreated, &placed)
+	orderPath := fmt.Sprintf("/orders/%d", placed.ID)
+
+	if rec := a.do("GET", "/guest/orders/"+placed.AccessToken, "", nil); rec.Code != http.StatusOK {
+		t.Errorf("guest order by its token = %d, want 200", rec.Code)
+	}
+	for _, path := range []string{"/guest/orders/not-the-token", "/guest/orders/" + placed.AccessToken + "x"} {
+		if rec := a.do("GET", path, "", nil); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", path, rec.Code)
+		}
+	}
+	if rec := a.do("POST", "/guest/orders/not-the-token/payments", "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("paying with a wrong token = %d, want 404", rec.Code)
+	}
+
+	// until it is claimed, no account reaches the order by its ID
+	for _, tok := range []string{otherToken, guestToken} {
+		if rec := a.do("GET", orderPath, tok, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("GET unclaimed guest order = %d, want 404", rec.Code)
+		}
+		if rec := a.do("POST", orderPath+"/cancel", tok, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("cancelling an unclaimed guest order = %d, want 404", rec.Code)
+		}
+		if rec := a.do("DELETE", orderPath, tok, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("deleting an unclaimed guest order = %d, want 404", rec.Code)
+		}
+	}
+
+	claim := "/guest/orders/" + placed.AccessToken + "/claim"
+	if rec := a.do("POST", claim, "", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("claiming without an account = %d, want 401", rec.Code)
+	}
+	if rec := a.do("POST", claim, otherToken, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("claiming with another email = %d, want 403", rec.Code)
+	}
+	var claimed OrderRes
+	decode(t, a.do("POST", claim, guestToken, nil), http.StatusOK, &claimed)
+	if got, _ := a.store.GetOrderByID(context.Background(), placed.ID); got.UserID == nil || *got.UserID != guest.ID {
+		t.Errorf("claimed order belongs to %v, want user %d", got.UserID, guest.ID)
+	}
+	if rec := a.do("GET", orderPath, guestToken, nil); rec.Code != http.StatusOK {
+		t.Errorf("GET claimed order = %d, want 200", rec.Code)
+	}
+	if rec := a.do("GET", orderPath, otherToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("GET someone else's claimed order = %d, want 404", rec.Code)
+	}
+}
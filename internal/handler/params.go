@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"ecom_apiv1/internal/storer"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// queryParser collects every malformed query parameter so the client gets
+// them all back in a single 400 response.
+type queryParser struct {
+	values url.Values
+	errors []ValidationError
+}
+
+func newQueryParser(r *http.Request) *queryParser {
+	return &queryParser{values: r.URL.Query()}
+}
+
+func (p *queryParser) fail(field, msg string) {
+	p.errors = append(p.errors, ValidationError{Field: field, Error: msg})
+}
+
+func (p *queryParser) string(name string) string {
+	return p.values.Get(name)
+}
+
+func (p *queryParser) int(name string, min int) int {
+	raw := p.values.Get(name)
+	if raw == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < min {
+		p.fail(name, "Must be an integer of at least "+strconv.Itoa(min))
+		return 0
+	}
+	return n
+}
+
+func (p *queryParser) uint(name string) *uint {
+	raw := p.values.Get(name)
+	if raw == "" {
+		return nil
+	}
+	n, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		p.fail(name, "Invalid ID format")
+		return nil
+	}
+	v := uint(n)
+	return &v
+}
+
+func (p *queryParser) float(name string) *float64 {
+	raw := p.values.Get(name)
+	if raw == "" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil || f < 0 {
+		p.fail(name, "Must be a non-negative number")
+		return nil
+	}
+	return &f
+}
+
+func (p *queryParser) bool(name string) *bool {
+	raw := p.values.Get(name)
+	if raw == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		p.fail(name, "Must be true or false")
+		return nil
+	}
+	return &b
+}
+
+func (p *queryParser) time(name string) *time.Time {
+	raw := p.values.Get(name)
+	if raw == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		p.fail(name, "Must be an RFC 3339 timestamp")
+		return nil
+	}
+	return &t
+}
+
+func (p *queryParser) orderStatus(name string) storer.OrderStatus {
+	s := storer.OrderStatus(p.values.Get(name))
+	if s != "" && !s.Valid() {
+		p.fail(name, "Unknown order status")
+		return ""
+	}
+	return s
+}
+
+func (p *queryParser) reviewStatus(name string) storer.ReviewStatus {
+	s := storer.ReviewStatus(p.values.Get(name))
+	if s != "" && !s.Valid() {
+		p.fail(name, "Unknown review status")
+		return ""
+	}
+	return s
+}
+
+func (p *queryParser) listParams() storer.ListParams {
+	return storer.ListParams{
+		Limit:  p.int("limit", 1),
+		Cursor: p.string("cursor"),
+		Page:   p.int("page", 1),
+		Sort:   p.string("sort"),
+	}
+}
+
+func parseProductQuery(r *http.Request) (storer.ProductQuery, []ValidationError) {
+	p := newQueryParser(r)
+	q := storer.ProductQuery{
+		ListParams:    p.listParams(),
+		ProductFilter: p.productFilter(),
+	}
+	return q, p.errors
+}
+
+func parseProductFilter(r *http.Request) (storer.ProductFilter, []ValidationError) {
+	p := newQueryParser(r)
+	f := p.productFilter()
+	return f, p.errors
+}
+
+func (p *queryParser) productFilter() storer.ProductFilter {
+	f := storer.ProductFilter{
+		Category:    p.string("category"),
+		MinPrice:    p.float("min_price"),
+		MaxPrice:    p.float("max_price"),
+		InStock:     p.bool("in_stock"),
+		CreatedFrom: p.time("created_from"),
+		CreatedTo:   p.time("created_to"),
+	}
+	if id := p.uint("category_id"); id != nil {
+		f.CategoryIDs = []uint{*id}
+	}
+	return f
+}
+
+func parseProductSearchQuery(r *http.Request) (storer.ProductSearchQuery, []ValidationError) {
+	p := newQueryParser(r)
+	q := storer.ProductSearchQuery{
+		Query: p.string("q"),
+		Limit: p.int("limit", 1),
+		Page:  p.int("page", 1),
+	}
+	if q.Query == "" {
+		p.fail("q", "This field is required")
+	}
+	return q, p.errors
+}
+
+func parseOrderQuery(r *http.Request) (storer.OrderQuery, []ValidationError) {
+	p := newQueryParser(r)
+	q := storer.OrderQuery{
+		ListParams: p.listParams(),
+		OrderFilter: storer.OrderFilter{
+			UserID:        p.uint("user_id"),
+			Status:        p.orderStatus("status"),
+			PaymentMethod: p.string("payment_method"),
+			CreatedFrom:   p.time("created_from"),
+			CreatedTo:     p.time("created_to"),
+		},
+	}
+	return q, p.errors
+}
+
+func parseReviewQuery(r *http.Request) (storer.ReviewQuery, []ValidationError) {
+	p := newQueryParser(r)
+	q := storer.ReviewQuery{
+		ListParams: p.listParams(),
+		ReviewFilter: storer.ReviewFilter{
+			ProductID: p.uint("product_id"),
+			UserID:    p.uint("user_id"),
+			Status:    p.reviewStatus("status"),
+		},
+	}
+	return q, p.errors
+}
+
+func parseUserQuery(r *http.Request) (storer.UserQuery, []ValidationError) {
+	p := newQueryParser(r)
+	q := storer.UserQuery{
+		ListParams: p.listParams(),
+		UserFilter: storer.UserFilter{
+			IsAdmin:     p.bool("is_admin"),
+			CreatedFrom: p.time("created_from"),
+			CreatedTo:   p.time("created_to"),
+		},
+	}
+	return q, p.errors
+}
+
+func writeValidationErrors(w http.ResponseWriter, errors []ValidationError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": errors,
+	})
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"ecom_apiv1/config"
 	"net/http"
 
 	"github.com/gorilla/mux"
@@ -11,10 +12,16 @@ var r *mux.Router
 func RegisterRoutes(h *handler) *mux.Router {
 	r = mux.NewRouter()
 	tokenMaker := h.TokenMaker
+	idempotent := GetIdempotencyMiddlewareFunc(h.server, h.idempotency)
 
 	// Products
 	r.HandleFunc("/products", h.Listproducts).Methods("GET")
+	r.HandleFunc("/products/search", h.searchProducts).Methods("GET")
+	r.HandleFunc("/products/facets", h.getProductFacets).Methods("GET")
 	r.HandleFunc("/products/{id}", h.getProduct).Methods("GET")
+	r.HandleFunc("/products/{id}/variants", h.listVariants).Methods("GET")
+	r.HandleFunc("/products/{id}/reviews", h.listProductReviews).Methods("GET")
+	r.HandleFunc("/products/{id}/images", h.listProductImages).Methods("GET")
 
 	// Admin Product routes
 	adminProductRouter := r.PathPrefix("/products").Subrouter()
@@ -22,23 +29,115 @@ func RegisterRoutes(h *handler) *mux.Router {
 	adminProductRouter.HandleFunc("", h.createProduct).Methods("POST")
 	adminProductRouter.HandleFunc("/{id}", h.updateProducts).Methods("PATCH")
 	adminProductRouter.HandleFunc("/{id}", h.DeleteProduct).Methods("DELETE")
+	adminProductRouter.HandleFunc("/{id}/variants", h.createVariant).Methods("POST")
+	adminProductRouter.HandleFunc("/{id}/variants/{variant_id}", h.updateVariant).Methods("PUT")
+	adminProductRouter.HandleFunc("/{id}/variants/{variant_id}", h.deleteVariant).Methods("DELETE")
+	adminProductRouter.HandleFunc("/{id}/images", h.uploadProductImage).Methods("POST")
+	adminProductRouter.HandleFunc("/{id}/images/order", h.reorderProductImages).Methods("PUT")
+	adminProductRouter.HandleFunc("/{id}/images/{image_id}", h.updateProductImage).Methods("PATCH")
+	adminProductRouter.HandleFunc("/{id}/images/{image_id}", h.deleteProductImage).Methods("DELETE")
+
+	// Uploaded files, when they are kept on local disk
+	if path, media := h.server.MediaHandler(); media != nil {
+		r.PathPrefix(path+"/").Methods("GET", "HEAD").Handler(http.StripPrefix(path, media))
+	}
+
+	// Categories
+	r.HandleFunc("/categories", h.listCategories).Methods("GET")
+	r.HandleFunc("/categories/{id}", h.getCategory).Methods("GET")
+
+	// Admin Category routes
+	adminCategoryRouter := r.PathPrefix("/categories").Subrouter()
+	adminCategoryRouter.Use(GetAdminMiddlewareFunc(tokenMaker))
+	adminCategoryRouter.HandleFunc("", h.createCategory).Methods("POST")
+	adminCategoryRouter.HandleFunc("/{id}", h.updateCategory).Methods("PUT")
+	adminCategoryRouter.HandleFunc("/{id}", h.deleteCategory).Methods("DELETE")
+
+	// Cart, for guests and signed-in users alike
+	cartRouter := r.PathPrefix("/cart").Subrouter()
+	cartRouter.Use(GetOptionalAuthMiddlewareFunc(tokenMaker), idempotent)
+	cartRouter.HandleFunc("", h.getCart).Methods("GET")
+	cartRouter.HandleFunc("/items", h.addCartItem).Methods("POST")
+	cartRouter.HandleFunc("/items/{product_id}", h.updateCartItem).Methods("PATCH")
+	cartRouter.HandleFunc("/items/{product_id}", h.removeCartItem).Methods("DELETE")
+	cartRouter.HandleFunc("/checkout", h.checkoutCart).Methods("POST")
+
+	// Shipping, quoted before an address is chosen
+	r.HandleFunc("/shipping/quote", h.quoteShipping).Methods("POST")
+
+	// Guest orders, reached with the access token handed out at checkout
+	r.Handle("/guest/orders", idempotent(http.HandlerFunc(h.createGuestOrder))).Methods("POST")
+	r.HandleFunc("/guest/orders/{token}", h.getGuestOrder).Methods("GET")
+	r.Handle("/guest/orders/{token}/payments", idempotent(http.HandlerFunc(h.startGuestPayment))).Methods("POST")
+	r.Handle("/guest/orders/{token}/payments/capture", idempotent(http.HandlerFunc(h.captureGuestPayment))).Methods("POST")
+
+	// Payment provider notifications, authenticated by their signature
+	r.HandleFunc("/webhooks/{provider}", h.receiveWebhook).Methods("POST")
 
 	// Auth required routes
 	authRouter := r.PathPrefix("").Subrouter()
-	authRouter.Use(GetAuthMiddlewareFunc(tokenMaker))
+	authRouter.Use(GetAuthMiddlewareFunc(tokenMaker), idempotent)
+
+	// Address book
+	authRouter.HandleFunc("/me/addresses", h.listAddresses).Methods("GET")
+	authRouter.HandleFunc("/me/addresses", h.createAddress).Methods("POST")
+	authRouter.HandleFunc("/me/addresses/{id}", h.getAddress).Methods("GET")
+	authRouter.HandleFunc("/me/addresses/{id}", h.updateAddress).Methods("PUT")
+	authRouter.HandleFunc("/me/addresses/{id}", h.deleteAddress).Methods("DELETE")
 
 	// Orders
-	authRouter.HandleFunc("/myorder", h.getOrder).Methods("GET")
+	authRouter.HandleFunc("/me/orders", h.listMyOrders).Methods("GET")
+	// kept for older clients, same as /me/orders
+	authRouter.HandleFunc("/myorder", h.listMyOrders).Methods("GET")
 	authRouter.HandleFunc("/orders", h.createOrder).Methods("POST")
+	authRouter.HandleFunc("/orders/{id}", h.getOrder).Methods("GET")
 	authRouter.HandleFunc("/orders/{id}", h.deleteOrder).Methods("DELETE")
+	authRouter.HandleFunc("/orders/{id}/cancel", h.cancelOrder).Methods("POST")
+	authRouter.HandleFunc("/orders/{id}/timeline", h.getOrderTimeline).Methods("GET")
+	authRouter.HandleFunc("/orders/{id}/payments", h.listPayments).Methods("GET")
+	authRouter.HandleFunc("/orders/{id}/payments", h.startPayment).Methods("POST")
+	authRouter.HandleFunc("/orders/{id}/payments/capture", h.capturePayment).Methods("POST")
+	authRouter.HandleFunc("/orders/{id}/returns", h.listReturns).Methods("GET")
+	authRouter.HandleFunc("/orders/{id}/returns", h.requestReturn).Methods("POST")
+	authRouter.HandleFunc("/orders/{id}/refunds", h.listRefunds).Methods("GET")
+	authRouter.HandleFunc("/guest/orders/{token}/claim", h.claimGuestOrder).Methods("POST")
+
+	// Reviews
+	authRouter.HandleFunc("/me/reviews", h.listMyReviews).Methods("GET")
+	authRouter.HandleFunc("/products/{id}/reviews", h.createReview).Methods("POST")
+	authRouter.HandleFunc("/reviews/{id}", h.updateReview).Methods("PUT")
+	authRouter.HandleFunc("/reviews/{id}", h.deleteReview).Methods("DELETE")
 
 	// Admin Order routes
 	adminOrderRouter := authRouter.PathPrefix("/orders").Subrouter()
 	adminOrderRouter.Use(GetAdminMiddlewareFunc(tokenMaker))
 	adminOrderRouter.HandleFunc("", h.listOrders).Methods("GET")
+	adminOrderRouter.HandleFunc("/{id}/status", h.updateOrderStatus).Methods("PATCH")
+	adminOrderRouter.HandleFunc("/{id}/refunds", h.refundOrder).Methods("POST")
+
+	// Admin Return routes
+	adminReturnRouter := authRouter.PathPrefix("/returns").Subrouter()
+	adminReturnRouter.Use(GetAdminMiddlewareFunc(tokenMaker))
+	adminReturnRouter.HandleFunc("/{id}", h.updateReturnStatus).Methods("PATCH")
+	adminReturnRouter.HandleFunc("/{id}/refund", h.refundReturn).Methods("POST")
+
+	// Admin Review routes
+	adminReviewRouter := authRouter.PathPrefix("/reviews").Subrouter()
+	adminReviewRouter.Use(GetAdminMiddlewareFunc(tokenMaker))
+	adminReviewRouter.HandleFunc("", h.listReviews).Methods("GET")
+	adminReviewRouter.HandleFunc("/{id}/status", h.moderateReview).Methods("PATCH")
+
+	// Admin Promotion routes
+	adminPromotionRouter := authRouter.PathPrefix("/promotions").Subrouter()
+	adminPromotionRouter.Use(GetAdminMiddlewareFunc(tokenMaker))
+	adminPromotionRouter.HandleFunc("", h.listPromotions).Methods("GET")
+	adminPromotionRouter.HandleFunc("", h.createPromotion).Methods("POST")
+	adminPromotionRouter.HandleFunc("/{id}", h.getPromotion).Methods("GET")
+	adminPromotionRouter.HandleFunc("/{id}", h.updatePromotion).Methods("PUT")
+	adminPromotionRouter.HandleFunc("/{id}", h.deletePromotion).Methods("DELETE")
 
 	// Users
-	r.HandleFunc("/users", h.createUser).Methods("POST")
+	r.Handle("/users", idempotent(http.HandlerFunc(h.createUser))).Methods("POST")
 	r.HandleFunc("/users/login", h.loginUser).Methods("POST")
 
 	authRouter.HandleFunc("/users", h.updateUser).Methods("PATCH")
@@ -57,6 +156,12 @@ func RegisterRoutes(h *handler) *mux.Router {
 	return r
 }
 
-func Start(addr string) error {
-	return http.ListenAndServe(addr, r)
+func Start(cfg config.HTTPConfig) error {
+	srv := &http.Server{
+		Addr:         cfg.Addr,
+		Handler:      r,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+	}
+	return srv.ListenAndServe()
 }
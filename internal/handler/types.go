@@ -4,13 +4,63 @@ import "time"
 
 type ProductReq struct {
 	Name         string  `json:"name" validate:"required,min=3,max=255"`
-	Image        string  `json:"image" validate:"required,url"`
-	Category     string  `json:"category" validate:"required,min=2,max=255"`
+	Image        string  `json:"image" validate:"omitempty,url"`
+	Category     string  `json:"category" validate:"required_without=CategoryID,max=255"`
+	CategoryID   *uint   `json:"category_id"`
 	Description  string  `json:"description" validate:"max=1000"`
-	Rating       int     `json:"rating" validate:"min=0,max=5"`
-	NumReviews   int     `json:"num_reviews" validate:"min=0"`
 	Price        float64 `json:"price" validate:"required,gt=0"`
 	CountInStock int     `json:"count_in_stock" validate:"min=0"`
+	// IsActive defaults to true on create; nil leaves it unchanged on update.
+	IsActive *bool `json:"is_active"`
+	// Weight is in kg and the packed dimensions in cm.
+	Weight float64 `json:"weight" validate:"min=0"`
+	Length float64 `json:"length" validate:"min=0"`
+	Width  float64 `json:"width" validate:"min=0"`
+	Height float64 `json:"height" validate:"min=0"`
+	// TaxCategory defaults to standard on create.
+	TaxCategory string `json:"tax_category" validate:"max=32"`
+	// Options are the axes the product is sold by variant along; nil
+	// leaves them unchanged on update and an empty list removes them.
+	Options []ProductOptionReq `json:"options" validate:"dive"`
+}
+
+type ProductOptionReq struct {
+	Name   string   `json:"name" validate:"required,max=64"`
+	Values []string `json:"values" validate:"required,min=1,dive,required,max=64"`
+}
+
+type ProductOptionRes struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+type VariantReq struct {
+	SKU     string            `json:"sku" validate:"required,max=64"`
+	Options map[string]string `json:"options" validate:"required"`
+	// PriceOverride is nil when the variant sells at the product price.
+	PriceOverride *float64 `json:"price_override" validate:"omitempty,min=0"`
+	CountInStock  int      `json:"count_in_stock" validate:"min=0"`
+	// Image is empty when the variant shows the product image.
+	Image string `json:"image" validate:"omitempty,url"`
+	// IsActive defaults to true.
+	IsActive *bool `json:"is_active"`
+}
+
+type VariantRes struct {
+	ID        uint              `json:"id"`
+	ProductID uint              `json:"product_id"`
+	SKU       string            `json:"sku"`
+	Label     string            `json:"label"`
+	Options   map[string]string `json:"options"`
+	// Price and Image are what the variant sells at and shows, its own or
+	// the product's.
+	Price         float64   `json:"price"`
+	PriceOverride *float64  `json:"price_override,omitempty"`
+	Image         string    `json:"image"`
+	CountInStock  int       `json:"count_in_stock"`
+	IsActive      bool      `json:"is_active"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
 }
 
 type ProductRes struct {
@@ -18,40 +68,493 @@ type ProductRes struct {
 	Name         string    `json:"name"`
 	Image        string    `json:"image"`
 	Category     string    `json:"category"`
+	CategoryID   *uint     `json:"category_id"`
 	Description  string    `json:"description"`
-	Rating       int       `json:"rating"`
+	Rating       float64   `json:"rating"`
 	NumReviews   int       `json:"num_reviews"`
 	Price        float64   `json:"price"`
 	CountInStock int       `json:"count_in_stock"`
+	IsActive     bool      `json:"is_active"`
+	Weight       float64   `json:"weight"`
+	Length       float64   `json:"length"`
+	Width        float64   `json:"width"`
+	Height       float64   `json:"height"`
+	TaxCategory  string    `json:"tax_category"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at,omitempty"`
+	// Options and Variants are the variant matrix of a product sold by
+	// variant. Variants and Images are only listed for a single product.
+	Options  []ProductOptionRes `json:"options,omitempty"`
+	Variants []VariantRes       `json:"variants,omitempty"`
+	Images   []ProductImageRes  `json:"images,omitempty"`
+}
+
+type ProductImageUpdateReq struct {
+	AltText string `json:"alt_text" validate:"max=255"`
+}
+
+type ProductImageOrderReq struct {
+	ImageIDs []uint `json:"image_ids" validate:"required"`
+}
+
+type ProductImageRes struct {
+	ID           uint      `json:"id"`
+	ProductID    uint      `json:"product_id"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	AltText      string    `json:"alt_text"`
+	Position     int       `json:"position"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// CategoryReq creates or replaces a category. Slug defaults to the
+// slugified name.
+type CategoryReq struct {
+	Name     string `json:"name" validate:"required,max=255"`
+	Slug     string `json:"slug" validate:"max=128"`
+	ParentID *uint  `json:"parent_id"`
+	Position int    `json:"position"`
+}
+
+type CategoryRes struct {
+	ID        uint          `json:"id"`
+	ParentID  *uint         `json:"parent_id"`
+	Name      string        `json:"name"`
+	Slug      string        `json:"slug"`
+	Position  int           `json:"position"`
+	Children  []CategoryRes `json:"children"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+type ReviewReq struct {
+	Rating int    `json:"rating" validate:"required,min=1,max=5"`
+	Title  string `json:"title" validate:"max=255"`
+	Body   string `json:"body" validate:"max=5000"`
+}
+
+type ReviewStatusReq struct {
+	Status string `json:"status" validate:"required,oneof=pending approved rejected"`
+}
+
+type ReviewRes struct {
+	ID          uint      `json:"id"`
+	ProductID   uint      `json:"product_id"`
+	UserID      uint      `json:"user_id"`
+	Rating      int       `json:"rating"`
+	Title       string    `json:"title"`
+	Body        string    `json:"body"`
+	Status      string    `json:"status"`
+	ModeratedBy *uint     `json:"moderated_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type ListReviewRes struct {
+	Reviews    []ReviewRes `json:"reviews"`
+	Total      int64       `json:"total"`
+	NextCursor string      `json:"next_cursor"`
+}
+
+type ListProductRes struct {
+	Products   []ProductRes `json:"products"`
+	Total      int64        `json:"total"`
+	NextCursor string       `json:"next_cursor"`
 }
 
+type SearchHitRes struct {
+	Product    ProductRes        `json:"product"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+type SearchProductRes struct {
+	Results []SearchHitRes `json:"results"`
+	Total   int64          `json:"total"`
+}
+
+type FacetCountRes struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+type PriceBucketRes struct {
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max"`
+	Count int64    `json:"count"`
+}
+
+type RatingCountRes struct {
+	Rating int   `json:"rating"`
+	Count  int64 `json:"count"`
+}
+
+type AvailabilityRes struct {
+	InStock    int64 `json:"in_stock"`
+	OutOfStock int64 `json:"out_of_stock"`
+}
+
+type ProductFacetsRes struct {
+	Total        int64            `json:"total"`
+	Categories   []FacetCountRes  `json:"categories"`
+	Prices       []PriceBucketRes `json:"prices"`
+	Ratings      []RatingCountRes `json:"ratings"`
+	Availability AvailabilityRes  `json:"availability"`
+}
+
+// OrderReq only carries what the customer chooses; names, prices and
+// totals are looked up and computed by the server.
 type OrderReq struct {
-	Items         []OrderItem `json:"items" validate:"required,min=1,dive"`
-	PaymentMethod string      `json:"payment_method" validate:"required,oneof=PayPal Stripe"`
-	TaxPrice      float64     `json:"tax_price" validate:"min=0"`
-	ShippingPrice float64     `json:"shipping_price" validate:"min=0"`
-	TotalPrice    float64     `json:"total_price" validate:"required,gt=0"`
+	Items           []OrderItemReq      `json:"items" validate:"required,min=1,max=100,dive"`
+	PaymentMethod   string              `json:"payment_method" validate:"required,oneof=PayPal Stripe"`
+	ShippingAddress *ShippingAddressReq `json:"shipping_address"`
+	// AddressID ships to a saved address instead. Without either, the
+	// default saved address is used.
+	AddressID  *uint  `json:"address_id" validate:"excluded_with=ShippingAddress"`
+	CouponCode string `json:"coupon_code" validate:"max=64"`
+}
+
+// GuestOrderReq is an order placed without an account. The email is where
+// the buyer is reached and the one a later claim has to match.
+type GuestOrderReq struct {
+	Items           []OrderItemReq      `json:"items" validate:"required,min=1,max=100,dive"`
+	PaymentMethod   string              `json:"payment_method" validate:"required,oneof=PayPal Stripe"`
+	Email           string              `json:"email" validate:"required,email"`
+	ShippingAddress *ShippingAddressReq `json:"shipping_address" validate:"required"`
+	CouponCode      string              `json:"coupon_code" validate:"max=64"`
+}
+
+type ShippingAddressReq struct {
+	Name       string `json:"name" validate:"required,max=255"`
+	Line1      string `json:"line1" validate:"required,max=255"`
+	Line2      string `json:"line2" validate:"max=255"`
+	City       string `json:"city" validate:"required,max=255"`
+	PostalCode string `json:"postal_code" validate:"required,max=32"`
+	Country    string `json:"country" validate:"required,len=2"`
+}
+
+type ShippingAddressRes struct {
+	Name       string `json:"name"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `json:"city"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+}
+
+// AddressReq is an entry of the signed-in user's address book.
+type AddressReq struct {
+	ShippingAddressReq
+	IsDefault bool `json:"is_default"`
+}
+
+type AddressRes struct {
+	ID uint `json:"id"`
+	ShippingAddressRes
+	IsDefault bool      `json:"is_default"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type OrderItemReq struct {
+	ProductID uint `json:"product_id" validate:"required"`
+	// VariantID is required for products sold by variant.
+	VariantID *uint `json:"variant_id"`
+	Quantity  int   `json:"quantity" validate:"required,min=1,max=1000"`
 }
 
 type OrderItem struct {
+	ID        uint    `json:"id"`
 	Name      string  `json:"name"`
 	Quantity  int     `json:"quantity"`
 	Image     string  `json:"image"`
 	Price     float64 `json:"price"`
+	Tax       float64 `json:"tax"`
+	Discount  float64 `json:"discount"`
 	ProductID uint    `json:"product_id"`
+	VariantID *uint   `json:"variant_id,omitempty"`
+	SKU       string  `json:"sku,omitempty"`
+}
+
+type StockShortageRes struct {
+	ProductID uint `json:"product_id"`
+	VariantID uint `json:"variant_id,omitempty"`
+	Requested int  `json:"requested"`
+	Available int  `json:"available"`
+}
+
+type InsufficientStockRes struct {
+	Error string             `json:"error"`
+	Items []StockShortageRes `json:"items"`
 }
 
 type OrderRes struct {
-	ID            uint        `json:"id"`
-	Items         []OrderItem `json:"items"`
-	PaymentMethod string      `json:"payment_method"`
-	TaxPrice      float64     `json:"tax_price"`
-	ShippingPrice float64     `json:"shipping_price"`
-	TotalPrice    float64     `json:"total_price"`
-	CreatedAt     time.Time   `json:"created_at"`
-	UpdatedAt     time.Time   `json:"updated_at,omitempty"`
+	ID              uint                `json:"id"`
+	Items           []OrderItem         `json:"items"`
+	Status          string              `json:"status"`
+	PaymentMethod   string              `json:"payment_method"`
+	Email           string              `json:"email,omitempty"`
+	ShippingAddress *ShippingAddressRes `json:"shipping_address,omitempty"`
+	ItemsPrice      float64             `json:"items_price"`
+	TaxPrice        float64             `json:"tax_price"`
+	TaxIncluded     bool                `json:"tax_included"`
+	TaxBreakdown    *TaxBreakdownRes    `json:"tax_breakdown,omitempty"`
+	ShippingPrice   float64             `json:"shipping_price"`
+	DiscountPrice   float64             `json:"discount_price"`
+	Discounts       []OrderDiscountRes  `json:"discounts,omitempty"`
+	TotalPrice      float64             `json:"total_price"`
+	RefundedAmount  float64             `json:"refunded_amount"`
+	CreatedAt       time.Time           `json:"created_at"`
+	UpdatedAt       time.Time           `json:"updated_at,omitempty"`
+}
+
+type OrderDiscountRes struct {
+	PromotionID uint    `json:"promotion_id"`
+	Code        string  `json:"code,omitempty"`
+	Name        string  `json:"name"`
+	Kind        string  `json:"kind"`
+	Amount      float64 `json:"amount"`
+}
+
+type TaxBreakdownRes struct {
+	Country string       `json:"country,omitempty"`
+	Lines   []TaxLineRes `json:"lines"`
+}
+
+type TaxLineRes struct {
+	Category string  `json:"category"`
+	Rate     float64 `json:"rate"`
+	Taxable  float64 `json:"taxable"`
+	Tax      float64 `json:"tax"`
+}
+
+// GuestOrderRes is returned once, when a guest order is placed. The access
+// token is not stored and cannot be shown again.
+type GuestOrderRes struct {
+	OrderRes
+	AccessToken string `json:"access_token"`
+}
+
+type PaymentRes struct {
+	ID            uint      `json:"id"`
+	Provider      string    `json:"provider"`
+	IntentID      string    `json:"intent_id"`
+	CaptureID     string    `json:"capture_id,omitempty"`
+	Status        string    `json:"status"`
+	Amount        float64   `json:"amount"`
+	Currency      string    `json:"currency"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// PaymentSessionRes carries what the client needs to let the buyer
+// authorise the payment: a Stripe client secret or a PayPal approve link.
+type PaymentSessionRes struct {
+	Payment      PaymentRes `json:"payment"`
+	ClientSecret string     `json:"client_secret,omitempty"`
+	ApproveURL   string     `json:"approve_url,omitempty"`
+}
+
+type CapturePaymentRes struct {
+	Order   OrderRes   `json:"order"`
+	Payment PaymentRes `json:"payment"`
+}
+
+type WebhookRes struct {
+	// Status is "processed" or "duplicate" for a redelivered event.
+	Status  string `json:"status"`
+	Outcome string `json:"outcome,omitempty"`
+}
+
+type ReturnReq struct {
+	OrderItemID uint   `json:"order_item_id" validate:"required"`
+	Quantity    int    `json:"quantity" validate:"required,min=1"`
+	Reason      string `json:"reason" validate:"required,oneof=damaged wrong_item not_as_described no_longer_needed other"`
+	Comment     string `json:"comment" validate:"max=1024"`
+}
+
+type ReturnStatusReq struct {
+	Status string `json:"status" validate:"required,oneof=approved rejected received"`
+	Note   string `json:"note" validate:"max=1024"`
+}
+
+type ReturnRes struct {
+	ID          uint      `json:"id"`
+	OrderID     uint      `json:"order_id"`
+	OrderItemID uint      `json:"order_item_id"`
+	Quantity    int       `json:"quantity"`
+	Reason      string    `json:"reason"`
+	Comment     string    `json:"comment,omitempty"`
+	Status      string    `json:"status"`
+	ReviewNote  string    `json:"review_note,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// RefundReq refunds part of an order, or all that is left of it when
+// Amount is left out.
+type RefundReq struct {
+	Amount float64 `json:"amount" validate:"gte=0"`
+	Reason string  `json:"reason" validate:"max=1024"`
+}
+
+type RefundRes struct {
+	ID               uint      `json:"id"`
+	OrderID          uint      `json:"order_id"`
+	ReturnID         *uint     `json:"return_id,omitempty"`
+	Amount           float64   `json:"amount"`
+	Reason           string    `json:"reason"`
+	Status           string    `json:"status"`
+	ProviderRefundID string    `json:"provider_refund_id,omitempty"`
+	FailureReason    string    `json:"failure_reason,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+type RefundSummaryRes struct {
+	TotalPrice     float64     `json:"total_price"`
+	RefundedAmount float64     `json:"refunded_amount"`
+	Remaining      float64     `json:"remaining"`
+	Refunds        []RefundRes `json:"refunds"`
+}
+
+type OrderStatusReq struct {
+	Status string `json:"status" validate:"required,oneof=pending paid processing shipped delivered cancelled refunded"`
+	Note   string `json:"note" validate:"max=1024"`
+}
+
+type OrderStatusEventRes struct {
+	FromStatus string    `json:"from_status,omitempty"`
+	ToStatus   string    `json:"to_status"`
+	ChangedBy  *uint     `json:"changed_by"`
+	Note       string    `json:"note,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type OrderTimelineRes struct {
+	OrderID uint                  `json:"order_id"`
+	Status  string                `json:"status"`
+	Events  []OrderStatusEventRes `json:"events"`
+}
+
+type ListOrderRes struct {
+	Orders     []OrderRes `json:"orders"`
+	Total      int64      `json:"total"`
+	NextCursor string     `json:"next_cursor"`
+}
+
+// PromotionReq is a promotion as the admin sets it up. A promotion without
+// a code applies by itself to every order that qualifies.
+type PromotionReq struct {
+	Name        string     `json:"name" validate:"required,max=255"`
+	Code        string     `json:"code" validate:"max=64"`
+	Kind        string     `json:"kind" validate:"required,oneof=percent fixed free_shipping buy_x_get_y"`
+	Value       float64    `json:"value" validate:"min=0"`
+	BuyQuantity int        `json:"buy_quantity" validate:"min=0"`
+	GetQuantity int        `json:"get_quantity" validate:"min=0"`
+	MinOrder    float64    `json:"min_order" validate:"min=0"`
+	ProductIDs  []uint     `json:"product_ids"`
+	Categories  []string   `json:"categories" validate:"dive,required,max=255"`
+	StartsAt    *time.Time `json:"starts_at"`
+	EndsAt      *time.Time `json:"ends_at"`
+	// UsageLimit and PerUserLimit of zero put no limit on the use.
+	UsageLimit   int  `json:"usage_limit" validate:"min=0"`
+	PerUserLimit int  `json:"per_user_limit" validate:"min=0"`
+	IsActive     bool `json:"is_active"`
+}
+
+type PromotionRes struct {
+	ID           uint       `json:"id"`
+	Name         string     `json:"name"`
+	Code         string     `json:"code,omitempty"`
+	Kind         string     `json:"kind"`
+	Value        float64    `json:"value"`
+	BuyQuantity  int        `json:"buy_quantity,omitempty"`
+	GetQuantity  int        `json:"get_quantity,omitempty"`
+	MinOrder     float64    `json:"min_order"`
+	ProductIDs   []uint     `json:"product_ids"`
+	Categories   []string   `json:"categories"`
+	StartsAt     *time.Time `json:"starts_at"`
+	EndsAt       *time.Time `json:"ends_at"`
+	UsageLimit   int        `json:"usage_limit"`
+	PerUserLimit int        `json:"per_user_limit"`
+	UsedCount    int        `json:"used_count"`
+	IsActive     bool       `json:"is_active"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+type CartItemReq struct {
+	ProductID uint `json:"product_id" validate:"required"`
+	// VariantID is required for products sold by variant.
+	VariantID *uint `json:"variant_id"`
+	Quantity  int   `json:"quantity" validate:"required,min=1,max=1000"`
+}
+
+// CartQuantityReq sets the quantity of a line; zero removes it.
+type CartQuantityReq struct {
+	Quantity *int `json:"quantity" validate:"required,min=0,max=1000"`
+}
+
+type CheckoutReq struct {
+	PaymentMethod   string              `json:"payment_method" validate:"required,oneof=PayPal Stripe"`
+	ShippingAddress *ShippingAddressReq `json:"shipping_address"`
+	AddressID       *uint               `json:"address_id" validate:"excluded_with=ShippingAddress"`
+	CouponCode      string              `json:"coupon_code" validate:"max=64"`
+}
+
+type GuestCheckoutReq struct {
+	PaymentMethod   string              `json:"payment_method" validate:"required,oneof=PayPal Stripe"`
+	Email           string              `json:"email" validate:"required,email"`
+	ShippingAddress *ShippingAddressReq `json:"shipping_address" validate:"required"`
+	CouponCode      string              `json:"coupon_code" validate:"max=64"`
+}
+
+type CartItemRes struct {
+	ProductID uint    `json:"product_id"`
+	VariantID *uint   `json:"variant_id,omitempty"`
+	SKU       string  `json:"sku,omitempty"`
+	Name      string  `json:"name"`
+	Image     string  `json:"image"`
+	Quantity  int     `json:"quantity"`
+	UnitPrice float64 `json:"unit_price"`
+	Total     float64 `json:"total"`
+	Available int     `json:"available"`
+	Warning   string  `json:"warning,omitempty"`
+}
+
+type ShippingQuoteReq struct {
+	Items      []OrderItemReq `json:"items" validate:"required,min=1,max=100,dive"`
+	Country    string         `json:"country" validate:"required,len=2"`
+	CouponCode string         `json:"coupon_code" validate:"max=64"`
+}
+
+type ShippingQuoteRes struct {
+	Country       string  `json:"country"`
+	ItemsPrice    float64 `json:"items_price"`
+	DiscountPrice float64 `json:"discount_price"`
+	TaxPrice      float64 `json:"tax_price"`
+	TaxIncluded   bool    `json:"tax_included"`
+	ShippingPrice float64 `json:"shipping_price"`
+	TotalPrice    float64 `json:"total_price"`
+}
+
+type CartRes struct {
+	// Token is only returned when the request created a guest cart.
+	Token         string        `json:"token,omitempty"`
+	Items         []CartItemRes `json:"items"`
+	ItemsPrice    float64       `json:"items_price"`
+	TaxPrice      float64       `json:"tax_price"`
+	TaxIncluded   bool          `json:"tax_included"`
+	ShippingPrice float64       `json:"shipping_price"`
+	TotalPrice    float64       `json:"total_price"`
+	HasWarnings   bool          `json:"has_warnings"`
+	UpdatedAt     *time.Time    `json:"updated_at,omitempty"`
 }
 
 type UserReq struct {
@@ -68,7 +571,9 @@ type UserRes struct {
 }
 
 type ListUserRes struct {
-	Users []UserRes `json:"users"`
+	Users      []UserRes `json:"users"`
+	Total      int64     `json:"total"`
+	NextCursor string    `json:"next_cursor"`
 }
 
 type LoginUserReq struct {
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"context"
+	"ecom_apiv1/internal/payment"
+	"ecom_apiv1/internal/payment/paymenttest"
+	"ecom_apiv1/internal/server"
+	"ecom_apiv1/internal/storer"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReceiveWebhook(t *testing.T) {
+	ctx := context.Background()
+	fake := paymenttest.NewServer(t)
+	a := newPaymentsAPI(t, payment.NewRegistry("USD", payment.NewStripe(fake.StripeConfig(), http.DefaultClient)))
+	buyer, _ := a.user("buyer@example.com", false)
+	p, _ := a.store.CreateProduct(ctx, &storer.Product{Name: "Lamp", Price: 40, CountInStock: 10, IsActive: true})
+	o, err := a.srv.CreateOrder(ctx, &storer.Order{UserID: &buyer.ID, PaymentMethod: "Stripe", Items: []storer.OrderItem{{ProductID: p.ID, Quantity: 1}}})
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	session, err := a.srv.StartPayment(ctx, o)
+	if err != nil {
+		t.Fatalf("StartPayment: %v", err)
+	}
+	intent := session.Payment.IntentID
+	total := payment.ToMinor(o.TotalPrice)
+
+	// webhook posts the payload to the provider's endpoint, signed with
+	// secret unless it is empty.
+	webhook := func(provider, secret, payload string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/webhooks/"+provider, strings.NewReader(payload))
+		if secret != "" {
+			req.Header = paymenttest.StripeHeader(secret, []byte(payload), time.Now())
+		}
+		return a.serve(req)
+	}
+	deliver := func(payload string) WebhookRes {
+		t.Helper()
+		var res WebhookRes
+		decode(t, webhook("stripe", paymenttest.StripeWebhookSecret, payload), http.StatusOK, &res)
+		return res
+	}
+
+	succeeded := fmt.Sprintf(`{"id":"evt_paid","type":"payment_intent.succeeded","data":{"object":{"id":%q,"status":"succeeded","amount_received":%d,"currency":"usd","latest_charge":"ch_1"}}}`, intent, total)
+	if res := deliver(succeeded); res.Status != "processed" || res.Outcome != server.WebhookCaptured {
+		t.Errorf("capture event = %+v", res)
+	}
+	if res := deliver(succeeded); res.Status != "duplicate" {
+		t.Errorf("redelivery = %+v, want duplicate", res)
+	}
+
+	partial := fmt.Sprintf(`{"id":"evt_part","type":"charge.refunded","data":{"object":{"id":"ch_1","payment_intent":%q,"amount_refunded":1000,"currency":"usd","refunds":{"data":[{"id":"re_1","amount":1000}]}}}}`, intent)
+	if res := deliver(partial); res.Outcome != server.WebhookPartialRefund {
+		t.Errorf("partial refund event = %+v", res)
+	}
+	full := fmt.Sprintf(`{"id":"evt_full","type":"charge.refunded","data":{"object":{"id":"ch_1","payment_intent":%q,"amount_refunded":%d,"currency":"usd","refunds":{"data":[{"id":"re_2","amount":%d}]}}}}`, intent, total, total-1000)
+	if res := deliver(full); res.Outcome != server.WebhookRefunded {
+		t.Errorf("full refund event = %+v", res)
+	}
+	got, _ := a.store.GetOrderByID(ctx, o.ID)
+	refunds, _ := a.store.ListRefunds(ctx, o.ID)
+	if got.Status != storer.OrderRefunded || got.RefundedAmount != o.TotalPrice || len(refunds) != 2 {
+		t.Errorf("order after the refunds = %s, refunded %v in %d refunds", got.Status, got.RefundedAmount, len(refunds))
+	}
+	if len(a.refunds.paid) != 0 {
+		t.Errorf("webhooks paid out refunds %v", a.refunds.paid)
+	}
+
+	if res := deliver(`{"id":"evt_other","type":"customer.created"}`); res.Outcome != server.WebhookIgnored {
+		t.Errorf("unknown event type = %+v", res)
+	}
+
+	forged := fmt.Sprintf(`{"id":"evt_forged","type":"payment_intent.succeeded","data":{"object":{"id":%q,"status":"succeeded","amount_received":%d,"currency":"usd"}}}`, intent, total)
+	if rec := webhook("stripe", "whsec_other", forged); rec.Code != http.StatusBadRequest {
+		t.Errorf("event signed with another secret = %d, want 400", rec.Code)
+	}
+	if rec := webhook("stripe", "", forged); rec.Code != http.StatusBadRequest {
+		t.Errorf("unsigned event = %d, want 400", rec.Code)
+	}
+	if rec := webhook("paypal", "", forged); rec.Code != http.StatusNotFound {
+		t.Errorf("event for a provider not configured = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,152 @@
+// Package imaging checks uploaded images and makes their thumbnails. It
+// relies on the standard library codecs, so JPEG, PNG and GIF are the
+// formats accepted.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+// MaxPixels bounds the decoded size of an image, so that a small file
+// claiming huge dimensions cannot exhaust memory.
+const MaxPixels = 40_000_000
+
+var (
+	ErrUnsupported = errors.New("unsupported image type, use JPEG, PNG or GIF")
+	ErrInvalid     = errors.New("image could not be decoded")
+	ErrTooLarge    = errors.New("image dimensions are too large")
+)
+
+// Image is a decoded upload.
+type Image struct {
+	// ContentType is sniffed from the data, whatever the upload claimed.
+	ContentType string
+	Width       int
+	Height      int
+
+	img image.Image
+}
+
+// Decode sniffs the type of data and decodes it.
+func Decode(data []byte) (*Image, error) {
+	contentType := http.DetectContentType(data)
+	var (
+		decodeConfig func([]byte) (image.Config, error)
+		decode       func([]byte) (image.Image, error)
+	)
+	switch contentType {
+	case "image/jpeg":
+		decodeConfig = func(b []byte) (image.Config, error) { return jpeg.DecodeConfig(bytes.NewReader(b)) }
+		decode = func(b []byte) (image.Image, error) { return jpeg.Decode(bytes.NewReader(b)) }
+	case "image/png":
+		decodeConfig = func(b []byte) (image.Config, error) { return png.DecodeConfig(bytes.NewReader(b)) }
+		decode = func(b []byte) (image.Image, error) { return png.Decode(bytes.NewReader(b)) }
+	case "image/gif":
+		// only the first frame of an animation is kept
+		decodeConfig = func(b []byte) (image.Config, error) { return gif.DecodeConfig(bytes.NewReader(b)) }
+		decode = func(b []byte) (image.Image, error) { return gif.Decode(bytes.NewReader(b)) }
+	default:
+		return nil, fmt.Errorf("%w, got %s", ErrUnsupported, contentType)
+	}
+
+	cfg, err := decodeConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, ErrInvalid
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d", ErrTooLarge, cfg.Width, cfg.Height)
+	}
+	img, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return &Image{ContentType: contentType, Width: cfg.Width, Height: cfg.Height, img: img}, nil
+}
+
+// Thumbnail scales the image down until its longest side is at most size
+// pixels, keeping its aspect ratio, and encodes it: JPEG stays JPEG, PNG
+// and GIF become PNG so transparency survives. Smaller images keep their
+// size.
+func (m *Image) Thumbnail(size int) ([]byte, string, error) {
+	w, h := m.Width, m.Height
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+	thumb := scale(m.img, w, h)
+
+	var buf bytes.Buffer
+	if m.ContentType == "image/jpeg" {
+		if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, "", fmt.Errorf("error encoding thumbnail: %w", err)
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	}
+	if err := png.Encode(&buf, thumb); err != nil {
+		return nil, "", fmt.Errorf("error encoding thumbnail: %w", err)
+	}
+	return buf.Bytes(), "image/png", nil
+}
+
+// Extension returns the file extension for an image content type.
+func Extension(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	}
+	return ""
+}
+
+// scale resizes src to w by h, averaging the source pixels under every
+// destination pixel.
+func scale(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	if b.Dx() == w && b.Dy() == h {
+		return rgba
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	sw, sh := b.Dx(), b.Dy()
+	for dy := 0; dy < h; dy++ {
+		y0, y1 := dy*sh/h, max((dy+1)*sh/h, dy*sh/h+1)
+		for dx := 0; dx < w; dx++ {
+			x0, x1 := dx*sw/w, max((dx+1)*sw/w, dx*sw/w+1)
+			var sum [4]int
+			for y := y0; y < y1; y++ {
+				row := rgba.Pix[y*rgba.Stride+x0*4 : y*rgba.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			o := dy*dst.Stride + dx*4
+			for c := range sum {
+				dst.Pix[o+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,83 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encoded(t *testing.T, format string, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "png":
+		err = png.Encode(&buf, img)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatalf("encode %s: %v", format, err)
+	}
+	return buf.Bytes()
+}
+
+func TestThumbnails(t *testing.T) {
+	tests := []struct {
+		format, contentType, thumbType string
+		w, h, size, thumbW, thumbH     int
+	}{
+		{"jpeg", "image/jpeg", "image/jpeg", 800, 600, 200, 200, 150},
+		{"png", "image/png", "image/png", 300, 900, 300, 100, 300},
+		{"gif", "image/gif", "image/png", 120, 80, 320, 120, 80},
+	}
+	for _, tt := range tests {
+		img, err := Decode(encoded(t, tt.format, tt.w, tt.h))
+		if err != nil {
+			t.Fatalf("Decode(%s): %v", tt.format, err)
+		}
+		if img.ContentType != tt.contentType || img.Width != tt.w || img.Height != tt.h {
+			t.Errorf("Decode(%s) = %s %dx%d", tt.format, img.ContentType, img.Width, img.Height)
+		}
+		data, contentType, err := img.Thumbnail(tt.size)
+		if err != nil {
+			t.Fatalf("Thumbnail(%s): %v", tt.format, err)
+		}
+		thumb, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil || contentType != tt.thumbType || thumb.Width != tt.thumbW || thumb.Height != tt.thumbH {
+			t.Errorf("%s thumbnail = %s %dx%d, %v, want %s %dx%d", tt.format, contentType, thumb.Width, thumb.Height, err, tt.thumbType, tt.thumbW, tt.thumbH)
+		}
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	if _, err := Decode([]byte("<svg xmlns='http://www.w3.org/2000/svg'></svg>")); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("svg: expected ErrUnsupported, got %v", err)
+	}
+	if _, err := Decode(encoded(t, "png", 10, 10)[:40]); !errors.Is(err, ErrInvalid) {
+		t.Errorf("truncated png: expected ErrInvalid, got %v", err)
+	}
+
+	// a PNG header claiming 100000x100000 pixels
+	bomb := encoded(t, "png", 1, 1)
+	copy(bomb[16:24], []byte{0, 1, 0x86, 0xa0, 0, 1, 0x86, 0xa0})
+	binary.BigEndian.PutUint32(bomb[29:33], crc32.ChecksumIEEE(bomb[12:29]))
+	if _, err := Decode(bomb); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("huge png: expected ErrTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+// Package payment talks to payment providers. Every provider is reached
+// through the Provider interface so the server never depends on one
+// provider's API; amounts are always in minor units (cents).
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecom_apiv1/config"
+)
+
+var (
+	ErrProviderNotConfigured = errors.New("payment provider is not configured")
+	ErrUnknownEvent          = errors.New("unknown webhook event")
+)
+
+// Provider is one payment provider. Name matches the payment method that
+// orders are placed with, e.g. "Stripe".
+type Provider interface {
+	Name() string
+	// CreateIntent starts a payment the buyer then authorises on the
+	// provider's side, using the returned client secret or approval link.
+	CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error)
+	// Capture collects an authorised payment. A declined payment is not an
+	// error; it comes back with CaptureFailed.
+	Capture(ctx context.Context, intentID string) (*Capture, error)
+	Refund(ctx context.Context, req RefundRequest) (*Refund, error)
+	// ParseWebhook verifies the signature and timestamp of a webhook and
+	// decodes it. Unsigned, forged or stale webhooks are rejected with
+	// ErrInvalidSignature or ErrStaleWebhook.
+	ParseWebhook(payload []byte, header http.Header) (*Event, error)
+}
+
+type IntentRequest struct {
+	OrderID  uint
+	Amount   int64
+	Currency string
+}
+
+type Intent struct {
+	ID string
+	// ClientSecret (Stripe) or ApproveURL (PayPal) is what the buyer needs
+	// to authorise the payment.
+	ClientSecret string
+	ApproveURL   string
+}
+
+type CaptureStatus string
+
+const (
+	CaptureSucceeded CaptureStatus = "succeeded"
+	CapturePending   CaptureStatus = "pending"
+	CaptureFailed    CaptureStatus = "failed"
+)
+
+type Capture struct {
+	ID            string
+	IntentID      string
+	Status        CaptureStatus
+	Amount        int64
+	Currency      string
+	FailureReason string
+}
+
+type RefundRequest struct {
+	IntentID  string
+	CaptureID string
+	Amount    int64
+	Currency  string
+}
+
+type RefundStatus string
+
+const (
+	RefundSucceeded RefundStatus = "succeeded"
+	RefundPending   RefundStatus = "pending"
+	RefundFailed    RefundStatus = "failed"
+)
+
+type Refund struct {
+	ID     string
+	Status RefundStatus
+	Amount int64
+}
+
+type EventType string
+
+const (
+	EventCaptureSucceeded EventType = "capture.succeeded"
+	EventCaptureFailed    EventType = "capture.failed"
+	EventRefunded         EventType = "refunded"
+	// EventRefundPending reports a refund the provider is still paying out,
+	// EventRefundFailed one it gave up on.
+	EventRefundPending EventType = "refund.pending"
+	EventRefundFailed  EventType = "refund.failed"
+)
+
+// Event is a webhook notification reduced to what the shop acts on.
+type Event struct {
+	ID        string
+	Type      EventType
+	IntentID  string
+	CaptureID string
+	// Amount is what was captured, or for a refund event what the refund
+	// paid back. It is zero for a refund the provider does not describe.
+	Amount   int64
+	Currency string
+	// RefundID is the provider's reference for the refund a refund event
+	// reports, when it says which one.
+	RefundID string
+	// Refunded is how much of the payment has been refunded at the
+	// provider so far, when a refund event tells.
+	Refunded int64
+}
+
+// APIError is a non-successful response from a provider's API.
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s API error %d %s: %s", e.Provider, e.StatusCode, e.Code, e.Message)
+}
+
+// Registry holds the configured providers and the currency they charge in.
+type Registry struct {
+	currency  string
+	providers map[string]Provider
+}
+
+func NewRegistry(currency string, providers ...Provider) *Registry {
+	r := &Registry{currency: currency, providers: make(map[string]Provider, len(providers))}
+	for _, p := range providers {
+		r.providers[strings.ToLower(p.Name())] = p
+	}
+	return r
+}
+
+// FromConfig returns a Registry with every provider whose credentials are
+// set in cfg.
+func FromConfig(cfg config.PaymentConfig) *Registry {
+	client := &http.Client{Timeout: 30 * time.Second}
+	var providers []Provider
+	if cfg.Stripe.SecretKey != "" {
+		s := NewStripe(cfg.Stripe, client)
+		s.tolerance = cfg.WebhookTolerance
+		providers = append(providers, s)
+	}
+	if cfg.PayPal.ClientID != "" {
+		p := NewPayPal(cfg.PayPal, client)
+		p.tolerance = cfg.WebhookTolerance
+		providers = append(providers, p)
+	}
+	return NewRegistry(cfg.Currency, providers...)
+}
+
+func (r *Registry) Currency() string {
+	return r.currency
+}
+
+// Get returns the provider called name, ignoring case so webhook paths like
+// /webhooks/stripe find it too.
+func (r *Registry) Get(name string) (Provider, error) {
+	if r != nil {
+		if p, ok := r.providers[strings.ToLower(name)]; ok {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrProviderNotConfigured, name)
+}
+
+// ToMinor converts an amount in major units, as stored on orders, to minor
+// units.
+func ToMinor(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func FromMinor(amount int64) float64 {
+	return float64(amount) / 100
+}
+
+// formatMinor renders minor units as a decimal string, e.g. 1234 as "12.34".
+func formatMinor(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
+
+// parseMinor is the inverse of formatMinor. It accepts up to two decimals.
+func parseMinor(value string) (int64, error) {
+	whole, frac, _ := strings.Cut(value, ".")
+	if len(frac) > 2 {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+	units, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	return units, nil
+}
@@ -0,0 +1,172 @@
+package payment_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/payment"
+	"ecom_apiv1/internal/payment/paymenttest"
+)
+
+func TestProviders(t *testing.T) {
+	fake := paymenttest.NewServer(t)
+	providers := []payment.Provider{
+		payment.NewStripe(fake.StripeConfig(), http.DefaultClient),
+		payment.NewPayPal(fake.PayPalConfig(), http.DefaultClient),
+	}
+	ctx := context.Background()
+	for _, p := range providers {
+		t.Run(p.Name(), func(t *testing.T) {
+			intent, err := p.CreateIntent(ctx, payment.IntentRequest{OrderID: 7, Amount: 4599, Currency: "USD"})
+			if err != nil {
+				t.Fatalf("CreateIntent: %v", err)
+			}
+			if intent.ID == "" || intent.ClientSecret == "" && intent.ApproveURL == "" {
+				t.Fatalf("intent = %+v, want an ID and a way for the buyer to approve it", intent)
+			}
+
+			if _, err := p.Capture(ctx, intent.ID); err == nil {
+				t.Fatal("capturing an unapproved intent succeeded")
+			}
+			fake.Approve(intent.ID)
+			c, err := p.Capture(ctx, intent.ID)
+			if err != nil {
+				t.Fatalf("Capture: %v", err)
+			}
+			if c.Status != payment.CaptureSucceeded || c.IntentID != intent.ID || c.ID == "" || c.Amount != 4599 || c.Currency != "USD" {
+				t.Errorf("capture = %+v", c)
+			}
+
+			r, err := p.Refund(ctx, payment.RefundRequest{IntentID: intent.ID, CaptureID: c.ID, Amount: 1000, Currency: "USD"})
+			if err != nil {
+				t.Fatalf("Refund: %v", err)
+			}
+			if r.Status != payment.RefundSucceeded || r.Amount != 1000 || fake.Refunded(intent.ID) != 1000 {
+				t.Errorf("refund = %+v, refunded %d", r, fake.Refunded(intent.ID))
+			}
+			if _, err := p.Refund(ctx, payment.RefundRequest{IntentID: intent.ID, CaptureID: c.ID, Amount: 4000, Currency: "USD"}); err == nil {
+				t.Error("refunding more than was captured succeeded")
+			}
+
+			declined, err := p.CreateIntent(ctx, payment.IntentRequest{OrderID: 8, Amount: 100, Currency: "USD"})
+			if err != nil {
+				t.Fatalf("CreateIntent: %v", err)
+			}
+			fake.Decline(declined.ID)
+			c, err = p.Capture(ctx, declined.ID)
+			if err != nil {
+				t.Fatalf("Capture of declined payment: %v", err)
+			}
+			if c.Status != payment.CaptureFailed {
+				t.Errorf("declined capture status = %s, want %s", c.Status, payment.CaptureFailed)
+			}
+		})
+	}
+}
+
+func TestParseWebhook(t *testing.T) {
+	stripe := payment.NewStripe(config.StripeConfig{WebhookSecret: paymenttest.StripeWebhookSecret}, http.DefaultClient)
+	payload := []byte(`{"id":"evt_1","type":"payment_intent.succeeded","data":{"object":{"id":"pi_1","status":"succeeded","amount_received":1250,"currency":"usd","latest_charge":"ch_1"}}}`)
+	e, err := stripe.ParseWebhook(payload, paymenttest.StripeHeader(paymenttest.StripeWebhookSecret, payload, time.Now()))
+	if err != nil {
+		t.Fatalf("Stripe ParseWebhook: %v", err)
+	}
+	if *e != (payment.Event{ID: "evt_1", Type: payment.EventCaptureSucceeded, IntentID: "pi_1", CaptureID: "ch_1", Amount: 1250, Currency: "USD"}) {
+		t.Errorf("Stripe event = %+v", e)
+	}
+
+	paypal := payment.NewPayPal(config.PayPalConfig{WebhookSecret: paymenttest.PayPalWebhookSecret}, http.DefaultClient)
+	payload = []byte(`{"id":"WH-1","event_type":"PAYMENT.CAPTURE.REFUNDED","resource":{"id":"RF-1","status":"COMPLETED","amount":{"currency_code":"USD","value":"3.5"},"links":[{"rel":"up","href":"https://api.paypal.com/v2/payments/captures/CAP-9"}]}}`)
+	e, err = paypal.ParseWebhook(payload, paymenttest.PayPalHeader(paymenttest.PayPalWebhookSecret, "tx-1", payload, time.Now()))
+	if err != nil {
+		t.Fatalf("PayPal ParseWebhook: %v", err)
+	}
+	if e.Type != payment.EventRefunded || e.CaptureID != "CAP-9" || e.Amount != 350 || e.RefundID != "RF-1" || e.Refunded != 0 {
+		t.Errorf("PayPal event = %+v", e)
+	}
+	payload = []byte(`{"id":"WH-2","event_type":"PAYMENT.CAPTURE.REFUNDED","resource":{"id":"RF-2","status":"COMPLETED","amount":{"currency_code":"USD","value":"1.00"},"seller_payable_breakdown":{"total_refunded_amount":{"currency_code":"USD","value":"4.50"}}}}`)
+	e, err = paypal.ParseWebhook(payload, paymenttest.PayPalHeader(paymenttest.PayPalWebhookSecret, "tx-2", payload, time.Now()))
+	if err != nil || e.RefundID != "RF-2" || e.Amount != 100 || e.Refunded != 450 {
+		t.Errorf("PayPal event with a refunded total = %+v, %v", e, err)
+	}
+
+	payload = []byte(`{"id":"evt_3","type":"charge.refunded","data":{"object":{"id":"ch_1","payment_intent":"pi_1","amount_refunded":700,"currency":"usd","refunds":{"data":[{"id":"re_2","amount":200},{"id":"re_1","amount":500}]}}}}`)
+	e, err = stripe.ParseWebhook(payload, paymenttest.StripeHeader(paymenttest.StripeWebhookSecret, payload, time.Now()))
+	if err != nil {
+		t.Fatalf("Stripe ParseWebhook: %v", err)
+	}
+	if *e != (payment.Event{ID: "evt_3", Type: payment.EventRefunded, IntentID: "pi_1", CaptureID: "ch_1", Amount: 200, Currency: "USD", RefundID: "re_2", Refunded: 700}) {
+		t.Errorf("Stripe refund event = %+v", e)
+	}
+	payload = []byte(`{"id":"evt_4","type":"refund.updated","data":{"object":{"id":"re_3","object":"refund","amount":300,"currency":"usd","status":"succeeded","payment_intent":"pi_1","charge":"ch_1"}}}`)
+	e, err = stripe.ParseWebhook(payload, paymenttest.StripeHeader(paymenttest.StripeWebhookSecret, payload, time.Now()))
+	if err != nil {
+		t.Fatalf("Stripe ParseWebhook: %v", err)
+	}
+	if *e != (payment.Event{ID: "evt_4", Type: payment.EventRefunded, IntentID: "pi_1", CaptureID: "ch_1", Amount: 300, Currency: "USD", RefundID: "re_3"}) {
+		t.Errorf("Stripe refund update = %+v", e)
+	}
+	for status, want := range map[string]payment.EventType{"pending": payment.EventRefundPending, "failed": payment.EventRefundFailed, "canceled": payment.EventRefundFailed} {
+		payload = []byte(fmt.Sprintf(`{"id":"evt_5","type":"charge.refund.updated","data":{"object":{"id":"re_3","amount":300,"currency":"usd","status":%q,"payment_intent":"pi_1"}}}`, status))
+		e, err = stripe.ParseWebhook(payload, paymenttest.StripeHeader(paymenttest.StripeWebhookSecret, payload, time.Now()))
+		if err != nil || e.Type != want {
+			t.Errorf("Stripe refund %s = %+v, %v, want %s", status, e, err, want)
+		}
+	}
+	for status, want := range map[string]payment.EventType{"PENDING": payment.EventRefundPending, "FAILED": payment.EventRefundFailed, "CANCELLED": payment.EventRefundFailed} {
+		payload = []byte(fmt.Sprintf(`{"id":"WH-3","event_type":"PAYMENT.CAPTURE.REFUNDED","resource":{"id":"RF-3","status":%q,"amount":{"currency_code":"USD","value":"1.00"}}}`, status))
+		e, err = paypal.ParseWebhook(payload, paymenttest.PayPalHeader(paymenttest.PayPalWebhookSecret, "tx-3", payload, time.Now()))
+		if err != nil || e.Type != want {
+			t.Errorf("PayPal refund %s = %+v, %v, want %s", status, e, err, want)
+		}
+	}
+
+	unknown := []byte(`{"id":"evt_2","type":"customer.created"}`)
+	if _, err := stripe.ParseWebhook(unknown, paymenttest.StripeHeader(paymenttest.StripeWebhookSecret, unknown, time.Now())); !errors.Is(err, payment.ErrUnknownEvent) {
+		t.Errorf("expected ErrUnknownEvent, got %v", err)
+	}
+}
+
+func TestWebhookSignatures(t *testing.T) {
+	payload := []byte(`{"id":"evt_1","type":"payment_intent.succeeded","data":{"object":{"id":"pi_1","status":"succeeded"}}}`)
+	stripe := payment.NewStripe(config.StripeConfig{WebhookSecret: paymenttest.StripeWebhookSecret}, http.DefaultClient)
+	paypal := payment.NewPayPal(config.PayPalConfig{WebhookSecret: paymenttest.PayPalWebhookSecret}, http.DefaultClient)
+	tests := []struct {
+		name     string
+		provider payment.Provider
+		header   http.Header
+		want     error
+	}{
+		{"stripe unsigned", stripe, http.Header{}, payment.ErrInvalidSignature},
+		{"stripe wrong secret", stripe, paymenttest.StripeHeader("whsec_other", payload, time.Now()), payment.ErrInvalidSignature},
+		{"stripe replayed", stripe, paymenttest.StripeHeader(paymenttest.StripeWebhookSecret, payload, time.Now().Add(-time.Hour)), payment.ErrStaleWebhook},
+		{"paypal wrong secret", paypal, paymenttest.PayPalHeader("other", "tx-1", payload, time.Now()), payment.ErrInvalidSignature},
+		{"paypal from the future", paypal, paymenttest.PayPalHeader(paymenttest.PayPalWebhookSecret, "tx-1", payload, time.Now().Add(time.Hour)), payment.ErrStaleWebhook},
+		{"no secret configured", payment.NewStripe(config.StripeConfig{}, http.DefaultClient), paymenttest.StripeHeader("", payload, time.Now()), payment.ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		if _, err := tt.provider.ParseWebhook(payload, tt.header); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	header := paymenttest.StripeHeader(paymenttest.StripeWebhookSecret, payload, time.Now())
+	tampered := append([]byte{}, payload...)
+	tampered[len(tampered)-3] = ' '
+	if _, err := stripe.ParseWebhook(tampered, header); !errors.Is(err, payment.ErrInvalidSignature) {
+		t.Errorf("tampered payload: got %v", err)
+	}
+}
+
+func TestToMinor(t *testing.T) {
+	for amount, want := range map[float64]int64{0: 0, 19.99: 1999, 0.1 + 0.2: 30, 1004.5: 100450} {
+		if got := payment.ToMinor(amount); got != want {
+			t.Errorf("ToMinor(%v) = %d, want %d", amount, got, want)
+		}
+	}
+}
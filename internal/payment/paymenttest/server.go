@@ -0,0 +1,391 @@
+// Package paymenttest provides a fake payment provider that speaks the parts
+// of the Stripe and PayPal APIs the payment package uses, so tests can run a
+// whole payment without network access.
+package paymenttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"ecom_apiv1/config"
+)
+
+const (
+	StripeKey           = "sk_test_fake"
+	StripeWebhookSecret = "whsec_fake"
+	PayPalClientID      = "fake-client"
+	PayPalClientSecret  = "fake-secret"
+	PayPalWebhookSecret = "fake-webhook-secret"
+	payPalAccessToken   = "fake-access-token"
+)
+
+type intent struct {
+	id        string
+	provider  string
+	amount    int64
+	currency  string
+	approved  bool
+	declined  bool
+	captured  int64
+	captureID string
+	refunded  int64
+	holding   bool
+}
+
+// Server is a fake Stripe and PayPal API. Intents it creates have to be
+// approved with Approve, standing in for the buyer, before they capture.
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	nextID  int
+	intents map[string]*intent
+}
+
+// NewServer starts a fake provider that is closed when the test ends.
+func NewServer(t testing.TB) *Server {
+	s := &Server{intents: map[string]*intent{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/payment_intents", s.stripeCreate)
+	mux.HandleFunc("POST /v1/payment_intents/{id}/capture", s.stripeCapture)
+	mux.HandleFunc("POST /v1/refunds", s.stripeRefund)
+	mux.HandleFunc("POST /v1/oauth2/token", s.paypalToken)
+	mux.HandleFunc("POST /v2/checkout/orders", s.paypalCreate)
+	mux.HandleFunc("POST /v2/checkout/orders/{id}/capture", s.paypalCapture)
+	mux.HandleFunc("POST /v2/payments/captures/{id}/refund", s.paypalRefund)
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *Server) StripeConfig() config.StripeConfig {
+	return config.StripeConfig{BaseURL: s.URL, SecretKey: StripeKey, WebhookSecret: StripeWebhookSecret}
+}
+
+func (s *Server) PayPalConfig() config.PayPalConfig {
+	return config.PayPalConfig{BaseURL: s.URL, ClientID: PayPalClientID, ClientSecret: PayPalClientSecret, WebhookSecret: PayPalWebhookSecret}
+}
+
+// Approve marks the intent as authorised by the buyer.
+func (s *Server) Approve(id string) {
+	s.update(id, func(in *intent) { in.approved = true })
+}
+
+// Decline makes the intent's capture fail as if the card was declined.
+func (s *Server) Decline(id string) {
+	s.update(id, func(in *intent) { in.approved, in.declined = true, true })
+}
+
+// SetCaptureAmount makes the intent capture a different amount than it was
+// created with, like a provider reporting a partial capture.
+func (s *Server) SetCaptureAmount(id string, amount int64) {
+	s.update(id, func(in *intent) { in.captured = amount })
+}
+
+// HoldRefunds makes refunds of the intent answer pending, as a provider
+// does while it is still paying them out.
+func (s *Server) HoldRefunds(id string) {
+	s.update(id, func(in *intent) { in.holding = true })
+}
+
+// Refunded returns how much of the intent has been refunded.
+func (s *Server) Refunded(id string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if in, ok := s.intents[id]; ok {
+		return in.refunded
+	}
+	return 0
+}
+
+func (s *Server) update(id string, fn func(*intent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if in, ok := s.intents[id]; ok {
+		fn(in)
+	}
+}
+
+func (s *Server) create(provider, prefix string, amount int64, currency string) *intent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	in := &intent{id: fmt.Sprintf("%s_%d", prefix, s.nextID), provider: provider, amount: amount, currency: currency, captured: amount}
+	s.intents[in.id] = in
+	return in
+}
+
+// capture runs the shared capture rules and reports the HTTP status the
+// provider would answer with: 0 on success, otherwise an error status.
+func (s *Server) capture(id, provider string) (*intent, int, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	in, ok := s.intents[id]
+	switch {
+	case !ok || in.provider != provider:
+		return nil, http.StatusNotFound, "resource_missing"
+	case in.captureID != "":
+		return in, http.StatusBadRequest, "already_captured"
+	case !in.approved:
+		return in, http.StatusUnprocessableEntity, "not_approved"
+	case in.declined:
+		return in, http.StatusPaymentRequired, "card_declined"
+	}
+	s.nextID++
+	in.captureID = fmt.Sprintf("cap_%d", s.nextID)
+	return in, 0, ""
+}
+
+func (s *Server) refund(id string, amount int64) (*intent, string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var in *intent
+	for _, candidate := range s.intents {
+		if candidate.id == id || (candidate.captureID != "" && candidate.captureID == id) {
+			in = candidate
+		}
+	}
+	if in == nil || in.captureID == "" || in.refunded+amount > in.captured {
+		return nil, "", false
+	}
+	in.refunded += amount
+	s.nextID++
+	return in, fmt.Sprintf("re_%d", s.nextID), true
+}
+
+func (s *Server) stripeAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Authorization") != "Bearer "+StripeKey {
+		stripeError(w, http.StatusUnauthorized, "invalid_request_error", "invalid api key")
+		return false
+	}
+	return true
+}
+
+func (s *Server) stripeCreate(w http.ResponseWriter, r *http.Request) {
+	if !s.stripeAuthorized(w, r) {
+		return
+	}
+	amount, err := strconv.ParseInt(r.FormValue("amount"), 10, 64)
+	if err != nil || amount <= 0 || r.FormValue("capture_method") != "manual" {
+		stripeError(w, http.StatusBadRequest, "parameter_invalid", "invalid payment intent")
+		return
+	}
+	in := s.create("Stripe", "pi", amount, r.FormValue("currency"))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":            in.id,
+		"status":        "requires_payment_method",
+		"amount":        in.amount,
+		"currency":      in.currency,
+		"client_secret": in.id + "_secret",
+	})
+}
+
+func (s *Server) stripeCapture(w http.ResponseWriter, r *http.Request) {
+	if !s.stripeAuthorized(w, r) {
+		return
+	}
+	in, status, code := s.capture(r.PathValue("id"), "Stripe")
+	switch status {
+	case 0:
+	case http.StatusUnprocessableEntity:
+		stripeError(w, http.StatusBadRequest, "payment_intent_unexpected_state", "payment intent has not been confirmed")
+		return
+	default:
+		stripeError(w, status, code, code)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":              in.id,
+		"status":          "succeeded",
+		"amount":          in.amount,
+		"amount_received": in.captured,
+		"currency":        in.currency,
+		"latest_charge":   in.captureID,
+	})
+}
+
+func (s *Server) stripeRefund(w http.ResponseWriter, r *http.Request) {
+	if !s.stripeAuthorized(w, r) {
+		return
+	}
+	amount, _ := strconv.ParseInt(r.FormValue("amount"), 10, 64)
+	in, id, ok := s.refund(r.FormValue("payment_intent"), amount)
+	if !ok {
+		stripeError(w, http.StatusBadRequest, "charge_already_refunded", "refund exceeds captured amount")
+		return
+	}
+	status := "succeeded"
+	if in.holding {
+		status = "pending"
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":             id,
+		"status":         status,
+		"amount":         amount,
+		"payment_intent": in.id,
+	})
+}
+
+func (s *Server) paypalToken(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != PayPalClientID || secret != PayPalClientSecret {
+		paypalError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": payPalAccessToken,
+		"token_type":   "Bearer",
+		"expires_in":   32400,
+	})
+}
+
+func (s *Server) paypalAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Authorization") != "Bearer "+payPalAccessToken {
+		paypalError(w, http.StatusUnauthorized, "AUTHENTICATION_FAILURE")
+		return false
+	}
+	return true
+}
+
+type paypalAmount struct {
+	CurrencyCode string `json:"currency_code"`
+	Value        string `json:"value"`
+}
+
+func (s *Server) paypalCreate(w http.ResponseWriter, r *http.Request) {
+	if !s.paypalAuthorized(w, r) {
+		return
+	}
+	var req struct {
+		Intent        string `json:"intent"`
+		PurchaseUnits []struct {
+			Amount paypalAmount `json:"amount"`
+		} `json:"purchase_units"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Intent != "CAPTURE" || len(req.PurchaseUnits) != 1 {
+		paypalError(w, http.StatusBadRequest, "INVALID_REQUEST")
+		return
+	}
+	amount, ok := parseAmount(req.PurchaseUnits[0].Amount.Value)
+	if !ok {
+		paypalError(w, http.StatusUnprocessableEntity, "DECIMAL_PRECISION")
+		return
+	}
+	in := s.create("PayPal", "PAY", amount, req.PurchaseUnits[0].Amount.CurrencyCode)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":     in.id,
+		"status": "CREATED",
+		"links": []map[string]string{
+			{"rel": "self", "href": s.URL + "/v2/checkout/orders/" + in.id},
+			{"rel": "approve", "href": s.URL + "/checkoutnow?token=" + in.id},
+		},
+	})
+}
+
+func (s *Server) paypalCapture(w http.ResponseWriter, r *http.Request) {
+	if !s.paypalAuthorized(w, r) {
+		return
+	}
+	in, status, _ := s.capture(r.PathValue("id"), "PayPal")
+	switch status {
+	case 0:
+	case http.StatusNotFound:
+		paypalError(w, status, "RESOURCE_NOT_FOUND")
+		return
+	case http.StatusPaymentRequired:
+		paypalError(w, http.StatusUnprocessableEntity, "INSTRUMENT_DECLINED")
+		return
+	case http.StatusBadRequest:
+		paypalError(w, http.StatusUnprocessableEntity, "ORDER_ALREADY_CAPTURED")
+		return
+	default:
+		paypalError(w, http.StatusUnprocessableEntity, "ORDER_NOT_APPROVED")
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":     in.id,
+		"status": "COMPLETED",
+		"purchase_units": []interface{}{map[string]interface{}{
+			"payments": map[string]interface{}{
+				"captures": []interface{}{map[string]interface{}{
+					"id":     in.captureID,
+					"status": "COMPLETED",
+					"amount": paypalAmount{CurrencyCode: in.currency, Value: formatAmount(in.captured)},
+				}},
+			},
+		}},
+	})
+}
+
+func (s *Server) paypalRefund(w http.ResponseWriter, r *http.Request) {
+	if !s.paypalAuthorized(w, r) {
+		return
+	}
+	var req struct {
+		Amount paypalAmount `json:"amount"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	amount, ok := parseAmount(req.Amount.Value)
+	if !ok {
+		paypalError(w, http.StatusUnprocessableEntity, "DECIMAL_PRECISION")
+		return
+	}
+	in, id, ok := s.refund(r.PathValue("id"), amount)
+	if !ok {
+		paypalError(w, http.StatusUnprocessableEntity, "REFUND_AMOUNT_EXCEEDED")
+		return
+	}
+	status := "COMPLETED"
+	if in.holding {
+		status = "PENDING"
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":     id,
+		"status": status,
+		"amount": req.Amount,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func stripeError(w http.ResponseWriter, status int, code, message string) {
+	errType := "invalid_request_error"
+	if status == http.StatusPaymentRequired {
+		errType = "card_error"
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]string{"type": errType, "code": code, "message": message},
+	})
+}
+
+func paypalError(w http.ResponseWriter, status int, issue string) {
+	writeJSON(w, status, map[string]interface{}{
+		"name":    http.StatusText(status),
+		"message": strings.ToLower(strings.ReplaceAll(issue, "_", " ")),
+		"details": []map[string]string{{"issue": issue}},
+	})
+}
+
+func formatAmount(amount int64) string {
+	return fmt.Sprintf("%d.%02d", amount/100, amount%100)
+}
+
+func parseAmount(value string) (int64, bool) {
+	whole, frac, _ := strings.Cut(value, ".")
+	if len(frac) > 2 {
+		return 0, false
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+	amount, err := strconv.ParseInt(whole+frac, 10, 64)
+	return amount, err == nil && amount > 0
+}
@@ -0,0 +1,34 @@
+package paymenttest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// StripeHeader signs payload the way Stripe does, as sent at the given time.
+func StripeHeader(secret string, payload []byte, at time.Time) http.Header {
+	ts := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "." + string(payload)))
+	h := http.Header{}
+	h.Set("Stripe-Signature", "t="+ts+",v1="+hex.EncodeToString(mac.Sum(nil)))
+	return h
+}
+
+// PayPalHeader returns the transmission headers for payload sent with the
+// given transmission ID at the given time.
+func PayPalHeader(secret, transmissionID string, payload []byte, at time.Time) http.Header {
+	sent := at.UTC().Format(time.RFC3339)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(transmissionID + "|" + sent + "|" + string(payload)))
+	h := http.Header{}
+	h.Set("PayPal-Transmission-Id", transmissionID)
+	h.Set("PayPal-Transmission-Time", sent)
+	h.Set("PayPal-Transmission-Sig", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return h
+}
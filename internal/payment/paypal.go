@@ -0,0 +1,314 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ecom_apiv1/config"
+)
+
+// PayPal uses Orders v2 with the CAPTURE intent: the buyer approves the
+// PayPal order through its approve link and the server captures it
+// afterwards.
+type PayPal struct {
+	cfg       config.PayPalConfig
+	client    *http.Client
+	tolerance time.Duration
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+func NewPayPal(cfg config.PayPalConfig, client *http.Client) *PayPal {
+	return &PayPal{cfg: cfg, client: client, tolerance: DefaultWebhookTolerance}
+}
+
+func (p *PayPal) Name() string {
+	return "PayPal"
+}
+
+type paypalAmount struct {
+	CurrencyCode string `json:"currency_code"`
+	Value        string `json:"value"`
+}
+
+type paypalLink struct {
+	Href string `json:"href"`
+	Rel  string `json:"rel"`
+}
+
+type paypalCapture struct {
+	ID     string       `json:"id"`
+	Status string       `json:"status"`
+	Amount paypalAmount `json:"amount"`
+	Links  []paypalLink `json:"links"`
+}
+
+type paypalOrder struct {
+	ID            string       `json:"id"`
+	Status        string       `json:"status"`
+	Links         []paypalLink `json:"links"`
+	PurchaseUnits []struct {
+		Payments struct {
+			Captures []paypalCapture `json:"captures"`
+		} `json:"payments"`
+	} `json:"purchase_units"`
+}
+
+type paypalError struct {
+	Name    string `json:"name"`
+	Message string `json:"message"`
+	Details []struct {
+		Issue       string `json:"issue"`
+		Description string `json:"description"`
+	} `json:"details"`
+}
+
+func (p *PayPal) CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error) {
+	body := map[string]interface{}{
+		"intent": "CAPTURE",
+		"purchase_units": []map[string]interface{}{{
+			"reference_id": "order-" + strconv.FormatUint(uint64(req.OrderID), 10),
+			"custom_id":    strconv.FormatUint(uint64(req.OrderID), 10),
+			"amount":       paypalAmount{CurrencyCode: req.Currency, Value: formatMinor(req.Amount)},
+		}},
+	}
+	var order paypalOrder
+	if err := p.do(ctx, "/v2/checkout/orders", body, &order); err != nil {
+		return nil, err
+	}
+	intent := &Intent{ID: order.ID}
+	for _, l := range order.Links {
+		if l.Rel == "approve" || l.Rel == "payer-action" {
+			intent.ApproveURL = l.Href
+		}
+	}
+	return intent, nil
+}
+
+func (p *PayPal) Capture(ctx context.Context, intentID string) (*Capture, error) {
+	var order paypalOrder
+	err := p.do(ctx, "/v2/checkout/orders/"+url.PathEscape(intentID)+"/capture", nil, &order)
+	if apiErr, ok := err.(*APIError); ok && apiErr.Code == "INSTRUMENT_DECLINED" {
+		return &Capture{IntentID: intentID, Status: CaptureFailed, FailureReason: apiErr.Message}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(order.PurchaseUnits) == 0 || len(order.PurchaseUnits[0].Payments.Captures) == 0 {
+		return &Capture{IntentID: intentID, Status: CaptureFailed, FailureReason: "no capture in PayPal response"}, nil
+	}
+	return toCapture(intentID, &order.PurchaseUnits[0].Payments.Captures[0])
+}
+
+func toCapture(intentID string, pc *paypalCapture) (*Capture, error) {
+	amount, err := parseMinor(pc.Amount.Value)
+	if err != nil {
+		return nil, fmt.Errorf("error reading PayPal capture: %w", err)
+	}
+	c := &Capture{ID: pc.ID, IntentID: intentID, Amount: amount, Currency: pc.Amount.CurrencyCode}
+	switch pc.Status {
+	case "COMPLETED":
+		c.Status = CaptureSucceeded
+	case "PENDING":
+		c.Status = CapturePending
+	default:
+		c.Status = CaptureFailed
+		c.FailureReason = "capture " + strings.ToLower(pc.Status)
+	}
+	return c, nil
+}
+
+func (p *PayPal) Refund(ctx context.Context, req RefundRequest) (*Refund, error) {
+	body := map[string]interface{}{
+		"amount": paypalAmount{CurrencyCode: req.Currency, Value: formatMinor(req.Amount)},
+	}
+	var res paypalCapture
+	if err := p.do(ctx, "/v2/payments/captures/"+url.PathEscape(req.CaptureID)+"/refund", body, &res); err != nil {
+		return nil, err
+	}
+	r := &Refund{ID: res.ID, Amount: req.Amount, Status: RefundFailed}
+	switch res.Status {
+	case "COMPLETED":
+		r.Status = RefundSucceeded
+	case "PENDING":
+		r.Status = RefundPending
+	}
+	return r, nil
+}
+
+// ParseWebhook checks the transmission headers before decoding the event.
+// PayPal-Transmission-Sig must be the base64 HMAC-SHA256, keyed with the
+// webhook secret, of "<transmission id>|<transmission time>|<payload>";
+// the transmission time is RFC 3339.
+func (p *PayPal) ParseWebhook(payload []byte, header http.Header) (*Event, error) {
+	if err := p.verify(payload, header); err != nil {
+		return nil, err
+	}
+	var evt struct {
+		ID        string `json:"id"`
+		EventType string `json:"event_type"`
+		Resource  struct {
+			paypalCapture
+			SupplementaryData struct {
+				RelatedIDs struct {
+					OrderID string `json:"order_id"`
+				} `json:"related_ids"`
+			} `json:"supplementary_data"`
+			SellerPayableBreakdown struct {
+				TotalRefundedAmount *paypalAmount `json:"total_refunded_amount"`
+			} `json:"seller_payable_breakdown"`
+		} `json:"resource"`
+	}
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		return nil, fmt.Errorf("error decoding PayPal event: %w", err)
+	}
+	res := &evt.Resource
+	switch evt.EventType {
+	case "PAYMENT.CAPTURE.COMPLETED", "PAYMENT.CAPTURE.DENIED", "PAYMENT.CAPTURE.DECLINED":
+		c, err := toCapture(res.SupplementaryData.RelatedIDs.OrderID, &res.paypalCapture)
+		if err != nil {
+			return nil, err
+		}
+		e := &Event{ID: evt.ID, Type: EventCaptureSucceeded, IntentID: c.IntentID, CaptureID: c.ID, Amount: c.Amount, Currency: c.Currency}
+		if evt.EventType != "PAYMENT.CAPTURE.COMPLETED" {
+			e.Type = EventCaptureFailed
+		}
+		return e, nil
+	case "PAYMENT.CAPTURE.REFUNDED":
+		// the resource is the refund; its "up" link points at the capture
+		amount, err := parseMinor(res.Amount.Value)
+		if err != nil {
+			return nil, fmt.Errorf("error reading PayPal refund: %w", err)
+		}
+		e := &Event{ID: evt.ID, Type: EventRefunded, IntentID: res.SupplementaryData.RelatedIDs.OrderID, Amount: amount, Currency: res.Amount.CurrencyCode, RefundID: res.ID}
+		switch res.Status {
+		case "PENDING":
+			e.Type = EventRefundPending
+		case "FAILED", "CANCELLED":
+			e.Type = EventRefundFailed
+		}
+		if total := res.SellerPayableBreakdown.TotalRefundedAmount; total != nil {
+			if e.Refunded, err = parseMinor(total.Value); err != nil {
+				return nil, fmt.Errorf("error reading PayPal refund: %w", err)
+			}
+		}
+		for _, l := range res.Links {
+			if l.Rel == "up" {
+				e.CaptureID = path.Base(l.Href)
+			}
+		}
+		return e, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEvent, evt.EventType)
+	}
+}
+
+func (p *PayPal) verify(payload []byte, header http.Header) error {
+	if p.cfg.WebhookSecret == "" {
+		return fmt.Errorf("%w: no PayPal webhook secret configured", ErrInvalidSignature)
+	}
+	id := header.Get("PayPal-Transmission-Id")
+	sent := header.Get("PayPal-Transmission-Time")
+	sig, err := base64.StdEncoding.DecodeString(header.Get("PayPal-Transmission-Sig"))
+	if id == "" || err != nil {
+		return fmt.Errorf("%w: malformed PayPal transmission headers", ErrInvalidSignature)
+	}
+	at, err := time.Parse(time.RFC3339, sent)
+	if err != nil {
+		return fmt.Errorf("%w: malformed PayPal-Transmission-Time", ErrInvalidSignature)
+	}
+	if !hmac.Equal(sig, sign(p.cfg.WebhookSecret, "|", id, sent, string(payload))) {
+		return ErrInvalidSignature
+	}
+	return checkTimestamp(at, p.tolerance)
+}
+
+// token returns a cached OAuth access token, fetching a new one shortly
+// before the current one expires.
+func (p *PayPal) token(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.accessToken != "" && time.Now().Before(p.expiresAt) {
+		return p.accessToken, nil
+	}
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url("/v1/oauth2/token"), strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("error building PayPal token request: %w", err)
+	}
+	req.SetBasicAuth(p.cfg.ClientID, p.cfg.ClientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var res struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := p.send(req, &res); err != nil {
+		return "", err
+	}
+	p.accessToken = res.AccessToken
+	p.expiresAt = time.Now().Add(time.Duration(res.ExpiresIn)*time.Second - time.Minute)
+	return p.accessToken, nil
+}
+
+func (p *PayPal) do(ctx context.Context, endpoint string, in, out interface{}) error {
+	token, err := p.token(ctx)
+	if err != nil {
+		return err
+	}
+	body := []byte("{}")
+	if in != nil {
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("error encoding PayPal request: %w", err)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url(endpoint), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error building PayPal request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	return p.send(req, out)
+}
+
+func (p *PayPal) send(req *http.Request, out interface{}) error {
+	res, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling PayPal: %w", err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("error reading PayPal response: %w", err)
+	}
+	if res.StatusCode >= 300 {
+		var pe paypalError
+		json.Unmarshal(body, &pe)
+		apiErr := &APIError{Provider: p.Name(), StatusCode: res.StatusCode, Code: pe.Name, Message: pe.Message}
+		if len(pe.Details) > 0 {
+			apiErr.Code = pe.Details[0].Issue
+		}
+		return apiErr
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("error decoding PayPal response: %w", err)
+	}
+	return nil
+}
+
+func (p *PayPal) url(endpoint string) string {
+	return strings.TrimRight(p.cfg.BaseURL, "/") + endpoint
+}
@@ -0,0 +1,38 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultWebhookTolerance is used by providers built without a config.
+const DefaultWebhookTolerance = 5 * time.Minute
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrStaleWebhook     = errors.New("webhook timestamp outside tolerance")
+)
+
+// sign returns the HMAC-SHA256 of the parts joined by sep.
+func sign(secret string, sep string, parts ...string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	for i, p := range parts {
+		if i > 0 {
+			mac.Write([]byte(sep))
+		}
+		mac.Write([]byte(p))
+	}
+	return mac.Sum(nil)
+}
+
+// checkTimestamp rejects a signed timestamp too far from now in either
+// direction, so a captured request cannot be replayed later.
+func checkTimestamp(signed time.Time, tolerance time.Duration) error {
+	if d := time.Since(signed); d > tolerance || d < -tolerance {
+		return fmt.Errorf("%w: signed at %s", ErrStaleWebhook, signed.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+// Package pricing computes order totals on the server from catalog prices,
+// so nothing the client sends about money is trusted.
+package pricing
+
+import (
+	"math"
+
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/shipping"
+	"ecom_apiv1/internal/tax"
+)
+
+type Line struct {
+	UnitPrice float64
+	Quantity  int
+	// Weight is the billable weight of one unit in kg.
+	Weight      float64
+	TaxCategory string
+	// Discount is what promotions take off the whole line.
+	Discount float64
+}
+
+func (l Line) Total() float64 {
+	return Round(l.UnitPrice * float64(l.Quantity))
+}
+
+type Breakdown struct {
+	// Subtotal is the price of the lines before their discounts.
+	Subtotal float64
+	Discount float64
+	Tax      float64
+	Shipping float64
+	// ShippingDiscount is the part of Shipping that was waived.
+	ShippingDiscount float64
+	Total            float64
+	// TaxIncluded is set when Tax is part of Subtotal rather than added
+	// to it.
+	TaxIncluded bool
+	// Taxes details Tax per line and per tax category.
+	Taxes tax.Result
+}
+
+type Calculator struct {
+	shipping shipping.RateCalculator
+	tax      *tax.Engine
+}
+
+// NewCalculator charges cfg.ShippingFee for shipping, free from
+// cfg.FreeShippingOver, and adds cfg.TaxRate, until SetShipping and SetTax
+// install other rules.
+func NewCalculator(cfg config.PricingConfig) *Calculator {
+	return &Calculator{
+		shipping: shipping.FromConfig(cfg, config.ShippingConfig{}),
+		tax:      tax.FromConfig(cfg, config.TaxConfig{}),
+	}
+}
+
+func (c *Calculator) SetShipping(rc shipping.RateCalculator) {
+	c.shipping = rc
+}
+
+func (c *Calculator) SetTax(e *tax.Engine) {
+	c.tax = e
+}
+
+// Price totals lines and applies tax and shipping to country, which may be
+// empty while the destination is unknown. Tax and shipping rates see the
+// line prices after their discounts. Every amount is rounded to cents.
+// It fails with shipping.ErrNoRate when the lines cannot be shipped there.
+func (c *Calculator) Price(lines []Line, country string) (Breakdown, error) {
+	var b Breakdown
+	var weight float64
+	items := make([]tax.Item, len(lines))
+	for i, l := range lines {
+		b.Subtotal += l.Total()
+		b.Discount += l.Discount
+		weight += l.Weight * float64(l.Quantity)
+		items[i] = tax.Item{Category: l.TaxCategory, Amount: Round(l.Total() - l.Discount)}
+	}
+	b.Subtotal = Round(b.Subtotal)
+	b.Discount = Round(b.Discount)
+	b.Taxes = c.tax.Compute(country, items)
+	b.Tax = b.Taxes.Total
+	b.TaxIncluded = b.Taxes.Included()
+	rate, err := c.shipping.Rate(shipping.Parcel{Subtotal: Round(b.Subtotal - b.Discount), Weight: weight, Country: country})
+	if err != nil {
+		return Breakdown{}, err
+	}
+	b.Shipping = Round(rate)
+	b.Total = Round(b.Subtotal - b.Discount + b.Shipping)
+	if !b.TaxIncluded {
+		b.Total = Round(b.Total + b.Tax)
+	}
+	return b, nil
+}
+
+// WaiveShipping takes the shipping off the total, keeping Shipping as what
+// it would have cost.
+func (b *Breakdown) WaiveShipping() {
+	b.ShippingDiscount = b.Shipping
+	b.Total = Round(b.Total - b.Shipping)
+}
+
+// Round rounds an amount to cents, halves away from zero.
+func Round(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,76 @@
+package pricing
+
+import (
+	"testing"
+
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/shipping"
+	"ecom_apiv1/internal/tax"
+)
+
+func TestPrice(t *testing.T) {
+	c := NewCalculator(config.PricingConfig{TaxRate: 0.15, ShippingFee: 10, FreeShippingOver: 100})
+	tests := []struct {
+		name  string
+		lines []Line
+		want  Breakdown
+	}{
+		{
+			name:  "below free shipping",
+			lines: []Line{{UnitPrice: 19.99, Quantity: 2}, {UnitPrice: 5.05, Quantity: 1}},
+			want:  Breakdown{Subtotal: 45.03, Tax: 6.75, Shipping: 10, Total: 61.78},
+		},
+		{
+			name:  "free shipping threshold is inclusive",
+			lines: []Line{{UnitPrice: 50, Quantity: 2}},
+			want:  Breakdown{Subtotal: 100, Tax: 15, Shipping: 0, Total: 115},
+		},
+		{
+			name:  "empty",
+			lines: nil,
+			want:  Breakdown{Shipping: 10, Total: 10},
+		},
+	}
+	for _, tt := range tests {
+		got, err := c.Price(tt.lines, "US")
+		if err != nil || got.Subtotal != tt.want.Subtotal || got.Tax != tt.want.Tax || got.Shipping != tt.want.Shipping || got.Total != tt.want.Total {
+			t.Errorf("%s: Price = %+v, %v, want %+v", tt.name, got, err, tt.want)
+		}
+	}
+
+	noFree := NewCalculator(config.PricingConfig{ShippingFee: 4.5})
+	if got, _ := noFree.Price([]Line{{UnitPrice: 1000, Quantity: 1}}, ""); got.Shipping != 4.5 || got.Total != 1004.5 {
+		t.Errorf("free shipping disabled: got %+v", got)
+	}
+}
+
+func TestPriceShipsByWeight(t *testing.T) {
+	c := NewCalculator(config.PricingConfig{})
+	c.SetShipping(shipping.Tiered{By: shipping.ByWeight, Tiers: []shipping.Tier{{UpTo: 2, Amount: 5}, {Amount: 12.5}}})
+	got, err := c.Price([]Line{{UnitPrice: 10, Quantity: 3, Weight: 0.5}, {UnitPrice: 1, Quantity: 1, Weight: 1}}, "US")
+	if err != nil || got.Shipping != 12.5 || got.Total != 43.5 {
+		t.Errorf("2.5 kg = %+v, %v, want the second tier", got, err)
+	}
+}
+
+func TestPriceIncludedTax(t *testing.T) {
+	c := NewCalculator(config.PricingConfig{ShippingFee: 10})
+	c.SetTax(tax.NewEngine(tax.Jurisdiction{Mode: tax.Inclusive, Rates: map[string]float64{tax.StandardCategory: 0.11, "exempt": 0}}))
+	got, err := c.Price([]Line{{UnitPrice: 55.5, Quantity: 2}, {UnitPrice: 20, Quantity: 1, TaxCategory: "exempt"}}, "ID")
+	if err != nil || !got.TaxIncluded || got.Tax != 11 || got.Total != 141 {
+		t.Errorf("included tax = %+v, %v, want 11 within a total of 141", got, err)
+	}
+}
+
+func TestPriceDiscounts(t *testing.T) {
+	c := NewCalculator(config.PricingConfig{TaxRate: 0.1, ShippingFee: 10, FreeShippingOver: 100})
+	// 110 of items, 20 off: tax on 90 and below the free shipping threshold
+	got, err := c.Price([]Line{{UnitPrice: 55, Quantity: 2, Discount: 20}}, "US")
+	if err != nil || got.Subtotal != 110 || got.Discount != 20 || got.Tax != 9 || got.Shipping != 10 || got.Total != 109 {
+		t.Fatalf("discounted = %+v, %v", got, err)
+	}
+	got.WaiveShipping()
+	if got.Shipping != 10 || got.ShippingDiscount != 10 || got.Total != 99 {
+		t.Errorf("waived shipping = %+v", got)
+	}
+}
@@ -0,0 +1,242 @@
+// Package promotion works out what the promotions of an order take off
+// it: coupons the buyer entered and the automatic promotions the order
+// qualifies for.
+package promotion
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"ecom_apiv1/internal/storer"
+)
+
+var (
+	ErrInvalidPromotion = errors.New("invalid promotion")
+	ErrNotActive        = errors.New("promotion is not active")
+	ErrMinimumNotMet    = errors.New("order does not reach the promotion minimum")
+	ErrNotApplicable    = errors.New("promotion does not apply to the items")
+)
+
+// Error names the promotion field that was rejected.
+type Error struct {
+	Field  string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s", e.Field, e.Reason)
+}
+
+func (e *Error) Is(target error) bool {
+	return target == ErrInvalidPromotion
+}
+
+// NormalizeCode trims a coupon code and upper-cases it, the form codes are
+// stored and looked up in.
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Validate checks the rules of p fit together. It normalizes the code and
+// clears the fields its kind does not use.
+func Validate(p *storer.Promotion) error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Code != nil {
+		code := NormalizeCode(*p.Code)
+		p.Code = &code
+		if code == "" {
+			p.Code = nil
+		}
+	}
+	if p.Kind != storer.PromotionBuyXGetY {
+		p.BuyQuantity, p.GetQuantity = 0, 0
+	}
+	if p.Kind == storer.PromotionFreeShipping {
+		p.Value = 0
+	}
+
+	switch {
+	case p.Name == "":
+		return &Error{Field: "name", Reason: "is required"}
+	case !p.Kind.Valid():
+		return &Error{Field: "kind", Reason: fmt.Sprintf("%q is not a promotion kind", p.Kind)}
+	case p.Kind == storer.PromotionFixed && p.Value <= 0:
+		return &Error{Field: "value", Reason: "must be positive"}
+	case (p.Kind == storer.PromotionPercent || p.Kind == storer.PromotionBuyXGetY) && (p.Value <= 0 || p.Value > 100):
+		return &Error{Field: "value", Reason: "must be a percentage above 0 and up to 100"}
+	case p.Kind == storer.PromotionBuyXGetY && p.BuyQuantity < 1:
+		return &Error{Field: "buy_quantity", Reason: "must be at least 1"}
+	case p.Kind == storer.PromotionBuyXGetY && p.GetQuantity < 1:
+		return &Error{Field: "get_quantity", Reason: "must be at least 1"}
+	case p.StartsAt != nil && p.EndsAt != nil && !p.EndsAt.After(*p.StartsAt):
+		return &Error{Field: "ends_at", Reason: "must be after starts_at"}
+	}
+	return nil
+}
+
+// Line is an order line promotions discount.
+type Line struct {
+	ProductID uint
+	Category  string
+	UnitPrice float64
+	Quantity  int
+}
+
+func (l Line) Total() float64 {
+	return round(l.UnitPrice * float64(l.Quantity))
+}
+
+// Check reports why p cannot be applied to lines at now, or nil when it
+// can. The minimum order is compared with the price of the items before
+// any discount.
+func Check(p *storer.Promotion, lines []Line, now time.Time) error {
+	if !p.Running(now) || p.UsedUp() {
+		return ErrNotActive
+	}
+	var subtotal float64
+	var units int
+	for _, l := range lines {
+		subtotal += l.Total()
+		if p.Scope.Includes(l.ProductID, l.Category) {
+			units += l.Quantity
+		}
+	}
+	if round(subtotal) < p.MinOrder {
+		return ErrMinimumNotMet
+	}
+	if units == 0 || p.Kind == storer.PromotionBuyXGetY && units < p.BuyQuantity+p.GetQuantity {
+		return ErrNotApplicable
+	}
+	return nil
+}
+
+type Result struct {
+	// Lines is the discount of each line, in order.
+	Lines []float64
+	// Discounts records every promotion applied. The amount of a free
+	// shipping promotion is left to whoever prices the shipping.
+	Discounts    []storer.OrderDiscount
+	Total        float64
+	FreeShipping bool
+}
+
+// Apply applies promotions to lines one after the other, each to what the
+// ones before it left of the line prices, so stacked promotions never take
+// a line below zero. The promotions are expected to have passed Check.
+func Apply(promotions []storer.Promotion, lines []Line) Result {
+	res := Result{Lines: make([]float64, len(lines))}
+	left := make([]float64, len(lines))
+	for i, l := range lines {
+		left[i] = l.Total()
+	}
+
+	for _, p := range promotions {
+		var off []float64
+		switch p.Kind {
+		case storer.PromotionPercent:
+			off = percentOff(p, lines, left)
+		case storer.PromotionFixed:
+			off = fixedOff(p, lines, left)
+		case storer.PromotionBuyXGetY:
+			off = buyXGetYOff(p, lines, left)
+		case storer.PromotionFreeShipping:
+			res.FreeShipping = true
+		}
+		d := storer.OrderDiscount{PromotionID: p.ID, Name: p.Name, Kind: p.Kind}
+		if p.Code != nil {
+			d.Code = *p.Code
+		}
+		for i, amount := range off {
+			left[i] = round(left[i] - amount)
+			res.Lines[i] = round(res.Lines[i] + amount)
+			d.Amount = round(d.Amount + amount)
+		}
+		res.Total = round(res.Total + d.Amount)
+		res.Discounts = append(res.Discounts, d)
+	}
+	return res
+}
+
+func percentOff(p storer.Promotion, lines []Line, left []float64) []float64 {
+	off := make([]float64, len(lines))
+	for i, l := range lines {
+		if p.Scope.Includes(l.ProductID, l.Category) {
+			off[i] = round(left[i] * p.Value / 100)
+		}
+	}
+	return off
+}
+
+// fixedOff spreads the amount over the lines in scope in proportion to
+// their prices, the last of them taking the cents lost to rounding.
+func fixedOff(p storer.Promotion, lines []Line, left []float64) []float64 {
+	off := make([]float64, len(lines))
+	var base float64
+	last := -1
+	for i, l := range lines {
+		if p.Scope.Includes(l.ProductID, l.Category) && left[i] > 0 {
+			base += left[i]
+			last = i
+		}
+	}
+	if last < 0 {
+		return off
+	}
+	amount := math.Min(p.Value, round(base))
+	remaining := amount
+	for i, l := range lines {
+		if !p.Scope.Includes(l.ProductID, l.Category) || left[i] <= 0 {
+			continue
+		}
+		if i == last {
+			off[i] = round(remaining)
+			break
+		}
+		off[i] = round(amount * left[i] / base)
+		remaining -= off[i]
+	}
+	return off
+}
+
+// buyXGetYOff discounts GetQuantity units of every BuyQuantity +
+// GetQuantity in scope, picking the cheapest units. Lines are walked
+// cheapest first rather than unit by unit, so the work does not grow with
+// the quantities ordered.
+func buyXGetYOff(p storer.Promotion, lines []Line, left []float64) []float64 {
+	type group struct {
+		line     int
+		quantity int
+		price    float64
+	}
+	var groups []group
+	units := 0
+	for i, l := range lines {
+		if !p.Scope.Includes(l.ProductID, l.Category) || l.Quantity <= 0 {
+			continue
+		}
+		groups = append(groups, group{line: i, quantity: l.Quantity, price: left[i] / float64(l.Quantity)})
+		units += l.Quantity
+	}
+	slices.SortStableFunc(groups, func(a, b group) int { return cmp.Compare(a.price, b.price) })
+
+	off := make([]float64, len(lines))
+	free := units / (p.BuyQuantity + p.GetQuantity) * p.GetQuantity
+	for _, g := range groups {
+		if free == 0 {
+			break
+		}
+		n := min(free, g.quantity)
+		off[g.line] = round(float64(n) * g.price * p.Value / 100)
+		free -= n
+	}
+	return off
+}
+
+func round(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,147 @@
+package promotion
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"ecom_apiv1/internal/storer"
+)
+
+func TestApply(t *testing.T) {
+	lines := []Line{
+		{ProductID: 1, Category: "apparel", UnitPrice: 20, Quantity: 2},
+		{ProductID: 2, Category: "apparel", UnitPrice: 5, Quantity: 2},
+		{ProductID: 3, Category: "kitchen", UnitPrice: 10, Quantity: 1},
+	}
+	apparel := storer.PromotionScope{Categories: []string{"apparel"}}
+
+	tests := []struct {
+		name  string
+		promo []storer.Promotion
+		lines []float64
+	}{
+		{
+			name:  "percent off the scope",
+			promo: []storer.Promotion{{Kind: storer.PromotionPercent, Value: 15, Scope: apparel}},
+			lines: []float64{6, 1.5, 0},
+		},
+		{
+			// 10 spread over 40, 10 and 10
+			name:  "fixed amount spread by price",
+			promo: []storer.Promotion{{Kind: storer.PromotionFixed, Value: 10}},
+			lines: []float64{6.67, 1.67, 1.66},
+		},
+		{
+			name:  "fixed amount capped at the price",
+			promo: []storer.Promotion{{Kind: storer.PromotionFixed, Value: 100, Scope: storer.PromotionScope{ProductIDs: []uint{3}}}},
+			lines: []float64{0, 0, 10},
+		},
+		{
+			// four apparel units make two pairs, the two cheapest are half off
+			name:  "buy one get one half off",
+			promo: []storer.Promotion{{Kind: storer.PromotionBuyXGetY, Value: 50, BuyQuantity: 1, GetQuantity: 1, Scope: apparel}},
+			lines: []float64{0, 5, 0},
+		},
+		{
+			name: "stacked on what is left",
+			promo: []storer.Promotion{
+				{Kind: storer.PromotionPercent, Value: 50},
+				{Kind: storer.PromotionFixed, Value: 30},
+			},
+			lines: []float64{40, 10, 10},
+		},
+	}
+	for _, tt := range tests {
+		res := Apply(tt.promo, lines)
+		var total float64
+		for i, want := range tt.lines {
+			if res.Lines[i] != want {
+				t.Errorf("%s: line %d = %v, want %v", tt.name, i, res.Lines[i], want)
+			}
+			total += want
+		}
+		if round(total) != res.Total {
+			t.Errorf("%s: total = %v, want %v", tt.name, res.Total, round(total))
+		}
+	}
+
+	res := Apply([]storer.Promotion{{Kind: storer.PromotionFreeShipping}}, lines)
+	if !res.FreeShipping || res.Total != 0 || len(res.Discounts) != 1 {
+		t.Errorf("free shipping = %+v", res)
+	}
+}
+
+func TestBuyXGetYLargeQuantities(t *testing.T) {
+	// a quote for a billion units is worked out per line, not per unit
+	lines := []Line{
+		{ProductID: 1, UnitPrice: 10, Quantity: 3},
+		{ProductID: 2, UnitPrice: 2, Quantity: 1_000_000_001},
+	}
+	res := Apply([]storer.Promotion{{Kind: storer.PromotionBuyXGetY, Value: 100, BuyQuantity: 2, GetQuantity: 1}}, lines)
+	if res.Lines[0] != 0 || res.Lines[1] != 666_666_668 {
+		t.Errorf("lines = %v, want the cheaper line to give 333333334 free units", res.Lines)
+	}
+
+	// the free units run over into the next cheapest line
+	lines = []Line{
+		{ProductID: 1, UnitPrice: 20, Quantity: 3},
+		{ProductID: 2, UnitPrice: 5, Quantity: 1},
+	}
+	res = Apply([]storer.Promotion{{Kind: storer.PromotionBuyXGetY, Value: 50, BuyQuantity: 1, GetQuantity: 1}}, lines)
+	if res.Lines[0] != 10 || res.Lines[1] != 2.5 {
+		t.Errorf("lines = %v, want [10 2.5]", res.Lines)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	lines := []Line{{ProductID: 1, Category: "apparel", UnitPrice: 20, Quantity: 2}}
+
+	tests := []struct {
+		name string
+		p    storer.Promotion
+		want error
+	}{
+		{name: "applies", p: storer.Promotion{Kind: storer.PromotionPercent, Value: 10, MinOrder: 40, IsActive: true}},
+		{name: "inactive", p: storer.Promotion{Kind: storer.PromotionPercent, Value: 10}, want: ErrNotActive},
+		{name: "not started", p: storer.Promotion{Kind: storer.PromotionPercent, Value: 10, StartsAt: &later, IsActive: true}, want: ErrNotActive},
+		{name: "used up", p: storer.Promotion{Kind: storer.PromotionPercent, Value: 10, UsageLimit: 5, UsedCount: 5, IsActive: true}, want: ErrNotActive},
+		{name: "below minimum", p: storer.Promotion{Kind: storer.PromotionPercent, Value: 10, MinOrder: 40.01, IsActive: true}, want: ErrMinimumNotMet},
+		{name: "out of scope", p: storer.Promotion{Kind: storer.PromotionPercent, Value: 10, Scope: storer.PromotionScope{ProductIDs: []uint{2}}, IsActive: true}, want: ErrNotApplicable},
+		{name: "too few units", p: storer.Promotion{Kind: storer.PromotionBuyXGetY, Value: 100, BuyQuantity: 2, GetQuantity: 1, IsActive: true}, want: ErrNotApplicable},
+	}
+	for _, tt := range tests {
+		if err := Check(&tt.p, lines, now); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Check = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	code := " summer "
+	p := &storer.Promotion{Name: "Summer", Code: &code, Kind: storer.PromotionFreeShipping, Value: 5, BuyQuantity: 1}
+	if err := Validate(p); err != nil || *p.Code != "SUMMER" || p.Value != 0 || p.BuyQuantity != 0 {
+		t.Errorf("Validate = %+v, %v", p, err)
+	}
+
+	start := time.Now()
+	tests := []struct {
+		p     storer.Promotion
+		field string
+	}{
+		{storer.Promotion{Kind: storer.PromotionFixed, Value: 5}, "name"},
+		{storer.Promotion{Name: "x", Kind: "bogus"}, "kind"},
+		{storer.Promotion{Name: "x", Kind: storer.PromotionPercent, Value: 120}, "value"},
+		{storer.Promotion{Name: "x", Kind: storer.PromotionFixed}, "value"},
+		{storer.Promotion{Name: "x", Kind: storer.PromotionBuyXGetY, Value: 100, GetQuantity: 1}, "buy_quantity"},
+		{storer.Promotion{Name: "x", Kind: storer.PromotionFixed, Value: 5, StartsAt: &start, EndsAt: &start}, "ends_at"},
+	}
+	for _, tt := range tests {
+		var perr *Error
+		if err := Validate(&tt.p); !errors.As(err, &perr) || perr.Field != tt.field || !errors.Is(err, ErrInvalidPromotion) {
+			t.Errorf("Validate(%+v) = %v, want a %s error", tt.p, err, tt.field)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+const (
+	markOpen  = "<mark>"
+	markClose = "</mark>"
+)
+
+// Highlight HTML-escapes text and wraps every word matching one of terms in
+// <mark> tags. When the text is longer than maxLen runes it is cut to a
+// window around the first match. It returns "" if nothing matched.
+func Highlight(text string, terms []string, maxLen int) string {
+	if len(terms) == 0 || text == "" {
+		return ""
+	}
+	want := make(map[string]bool, len(terms))
+	for _, t := range terms {
+		want[t] = true
+	}
+
+	runes := []rune(text)
+	type span struct{ start, end int }
+	var spans []span
+	for i := 0; i < len(runes); {
+		if !isWordRune(runes[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && isWordRune(runes[j]) {
+			j++
+		}
+		if want[strings.ToLower(string(runes[i:j]))] {
+			spans = append(spans, span{i, j})
+		}
+		i = j
+	}
+	if len(spans) == 0 {
+		return ""
+	}
+
+	from, to := 0, len(runes)
+	if maxLen > 0 && len(runes) > maxLen {
+		from = spans[0].start - maxLen/4
+		if from < 0 {
+			from = 0
+		}
+		to = from + maxLen
+		if to > len(runes) {
+			to = len(runes)
+			from = to - maxLen
+		}
+		// don't cut words in half
+		for from > 0 && isWordRune(runes[from-1]) && isWordRune(runes[from]) {
+			from++
+		}
+		for to < len(runes) && to > from && isWordRune(runes[to-1]) && isWordRune(runes[to]) {
+			to--
+		}
+		for from < to && unicode.IsSpace(runes[from]) {
+			from++
+		}
+		for to > from && unicode.IsSpace(runes[to-1]) {
+			to--
+		}
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, s := range spans {
+		if s.start < from || s.end > to {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[pos:s.start])))
+		b.WriteString(markOpen)
+		b.WriteString(html.EscapeString(string(runes[s.start:s.end])))
+		b.WriteString(markClose)
+		pos = s.end
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:to])))
+	if to < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// MatchTerms returns the words of text that a query would match with the
+// same exact/prefix/typo rules as Index.Search. SQL backends use it to
+// highlight rows ranked by the database.
+func MatchTerms(query string, texts ...string) []string {
+	queryTerms := uniqueTerms(Tokenize(query))
+	seen := make(map[string]bool)
+	var out []string
+	for _, text := range texts {
+		for _, word := range Tokenize(text) {
+			if seen[word] {
+				continue
+			}
+			for _, qt := range queryTerms {
+				if word == qt ||
+					(len(qt) >= 3 && strings.HasPrefix(word, qt)) ||
+					(MaxTypos(qt) > 0 && Distance(qt, word, MaxTypos(qt)) <= MaxTypos(qt)) {
+					seen[word] = true
+					out = append(out, word)
+					break
+				}
+			}
+		}
+	}
+	return out
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
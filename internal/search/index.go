@@ -0,0 +1,279 @@
+// Package search is a small in-process inverted index used for product
+// search on backends without native full-text support (SQLite, memory).
+// It ranks with a TF-IDF variant, tolerates typos through bounded edit
+// distance and builds highlighted snippets.
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Expansion weights: how much a vocabulary term counts relative to an exact
+// match of the query term.
+const (
+	exactWeight  = 1.0
+	prefixWeight = 0.8
+	typo1Weight  = 0.6
+	typo2Weight  = 0.4
+)
+
+type Hit struct {
+	ID    uint
+	Score float64
+	// Terms are the indexed terms that matched, used for highlighting.
+	Terms []string
+}
+
+type posting struct {
+	field string
+	tf    int
+}
+
+type Index struct {
+	mu       sync.RWMutex
+	weights  map[string]float64
+	postings map[string]map[uint][]posting
+	docs     map[uint][]string
+}
+
+// NewIndex creates an index whose fields are scored with weights; fields
+// missing from weights count with weight 1.
+func NewIndex(weights map[string]float64) *Index {
+	return &Index{
+		weights:  weights,
+		postings: make(map[string]map[uint][]posting),
+		docs:     make(map[uint][]string),
+	}
+}
+
+// Add indexes (or re-indexes) a document.
+func (ix *Index) Add(id uint, fields map[string]string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.remove(id)
+	var terms []string
+	for field, text := range fields {
+		counts := make(map[string]int)
+		for _, term := range Tokenize(text) {
+			counts[term]++
+		}
+		for term, tf := range counts {
+			docs, ok := ix.postings[term]
+			if !ok {
+				docs = make(map[uint][]posting)
+				ix.postings[term] = docs
+			}
+			if len(docs[id]) == 0 {
+				terms = append(terms, term)
+			}
+			docs[id] = append(docs[id], posting{field: field, tf: tf})
+		}
+	}
+	ix.docs[id] = terms
+}
+
+func (ix *Index) Remove(id uint) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(id)
+}
+
+func (ix *Index) remove(id uint) {
+	for _, term := range ix.docs[id] {
+		delete(ix.postings[term], id)
+		if len(ix.postings[term]) == 0 {
+			delete(ix.postings, term)
+		}
+	}
+	delete(ix.docs, id)
+}
+
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.docs)
+}
+
+// Search returns every matching document ordered by descending score.
+func (ix *Index) Search(query string) []Hit {
+	queryTerms := uniqueTerms(Tokenize(query))
+	if len(queryTerms) == 0 {
+		return nil
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	n := float64(len(ix.docs))
+	type acc struct {
+		score   float64
+		matched map[int]bool
+		terms   map[string]bool
+	}
+	results := make(map[uint]*acc)
+
+	for qi, qt := range queryTerms {
+		for term, weight := range ix.expand(qt) {
+			docs := ix.postings[term]
+			idf := math.Log(1 + n/float64(len(docs)))
+			for id, ps := range docs {
+				a, ok := results[id]
+				if !ok {
+					a = &acc{matched: make(map[int]bool), terms: make(map[string]bool)}
+					results[id] = a
+				}
+				for _, p := range ps {
+					fw, ok := ix.weights[p.field]
+					if !ok {
+						fw = 1
+					}
+					tf := float64(p.tf)
+					a.score += weight * fw * idf * tf / (tf + 1.2)
+				}
+				a.matched[qi] = true
+				a.terms[term] = true
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(results))
+	for id, a := range results {
+		// favour documents that match more of the query
+		coord := float64(len(a.matched)) / float64(len(queryTerms))
+		terms := make([]string, 0, len(a.terms))
+		for t := range a.terms {
+			terms = append(terms, t)
+		}
+		sort.Strings(terms)
+		hits = append(hits, Hit{ID: id, Score: a.score * coord, Terms: terms})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	return hits
+}
+
+// expand maps a query term to the indexed terms it matches and their weight.
+func (ix *Index) expand(qt string) map[string]float64 {
+	out := make(map[string]float64)
+	maxDist := MaxTypos(qt)
+	for term := range ix.postings {
+		var w float64
+		switch {
+		case term == qt:
+			w = exactWeight
+		case len(qt) >= 3 && strings.HasPrefix(term, qt):
+			w = prefixWeight
+		case maxDist > 0 && abs(len(term)-len(qt)) <= maxDist:
+			if d := Distance(qt, term, maxDist); d == 1 {
+				w = typo1Weight
+			} else if d <= maxDist {
+				w = typo2Weight
+			}
+		}
+		if w > out[term] {
+			out[term] = w
+		}
+	}
+	return out
+}
+
+// MaxTypos is the edit distance tolerated for a query term of this length.
+func MaxTypos(term string) int {
+	n := len([]rune(term))
+	switch {
+	case n <= 3:
+		return 0
+	case n <= 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Distance is the Levenshtein distance between a and b, or max+1 once it
+// is known to exceed max.
+func Distance(a, b string, max int) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	cur := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if cur[j] < rowMin {
+				rowMin = cur[j]
+			}
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev, cur = cur, prev
+	}
+	if prev[len(br)] > max {
+		return max + 1
+	}
+	return prev[len(br)]
+}
+
+// Tokenize lowercases s and splits it on anything that is not a letter or
+// a digit.
+func Tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func uniqueTerms(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	out := terms[:0]
+	for _, t := range terms {
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Terms returns the distinct tokens of a query.
+func Terms(query string) []string {
+	return uniqueTerms(Tokenize(query))
+}
+
+// RelaxedTerms turns each query term into a prefix that still matches when
+// the term has trailing typos, for backends that can only do prefix search.
+// Terms too short to tolerate typos are returned unchanged.
+func RelaxedTerms(query string) []string {
+	terms := Terms(query)
+	for i, t := range terms {
+		r := []rune(t)
+		if keep := len(r) - MaxTypos(t); keep < len(r) {
+			terms[i] = string(r[:max(keep, 3)])
+		}
+	}
+	return uniqueTerms(terms)
+}
@@ -0,0 +1,91 @@
+package search
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		max  int
+		want int
+	}{
+		{"keyboard", "keyboard", 2, 0},
+		{"keybord", "keyboard", 2, 1},
+		{"kebord", "keyboard", 2, 2},
+		{"lamp", "mug", 1, 2},
+		{"café", "cafe", 1, 1},
+	}
+	for _, tt := range tests {
+		if got := Distance(tt.a, tt.b, tt.max); got != tt.want {
+			t.Errorf("Distance(%q, %q, %d) = %d, want %d", tt.a, tt.b, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ix := NewIndex(map[string]float64{"name": 3, "description": 1})
+	ix.Add(1, map[string]string{"name": "Mechanical Keyboard", "description": "Loud switches"})
+	ix.Add(2, map[string]string{"name": "Wrist Rest", "description": "Fits any keyboard"})
+	ix.Add(3, map[string]string{"name": "Mouse", "description": "Wireless"})
+
+	ids := func(hits []Hit) []uint {
+		var out []uint
+		for _, h := range hits {
+			out = append(out, h.ID)
+		}
+		return out
+	}
+
+	if got := ids(ix.Search("keyboard")); !slices.Equal(got, []uint{1, 2}) {
+		t.Errorf("keyboard = %v, want [1 2]", got)
+	}
+	if got := ids(ix.Search("mechanicl keybaord")); !slices.Equal(got, []uint{1, 2}) {
+		t.Errorf("typos = %v, want [1 2]", got)
+	}
+	if got := ids(ix.Search("mech")); !slices.Equal(got, []uint{1}) {
+		t.Errorf("prefix = %v, want [1]", got)
+	}
+	if got := ids(ix.Search("mous")); !slices.Equal(got, []uint{3}) {
+		t.Errorf("mous = %v, want [3]", got)
+	}
+	// short terms must match exactly
+	if got := ids(ix.Search("rst")); len(got) != 0 {
+		t.Errorf("rst = %v, want none", got)
+	}
+
+	ix.Add(1, map[string]string{"name": "Piano", "description": "Weighted keys"})
+	ix.Remove(2)
+	if got := ids(ix.Search("keyboard")); len(got) != 0 {
+		t.Errorf("after update = %v, want none", got)
+	}
+	if ix.Len() != 2 {
+		t.Errorf("Len = %d, want 2", ix.Len())
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		text   string
+		terms  []string
+		maxLen int
+		want   string
+	}{
+		{"Mechanical Keyboard", []string{"keyboard"}, 0, "Mechanical <mark>Keyboard</mark>"},
+		{"<b>Keyboard</b> & mouse", []string{"keyboard", "mouse"}, 0, "&lt;b&gt;<mark>Keyboard</mark>&lt;/b&gt; &amp; <mark>mouse</mark>"},
+		{"nothing here", []string{"keyboard"}, 0, ""},
+		{"one two three four five six seven keyboard eight nine ten", []string{"keyboard"}, 24, "…seven <mark>keyboard</mark> eight…"},
+	}
+	for _, tt := range tests {
+		if got := Highlight(tt.text, tt.terms, tt.maxLen); got != tt.want {
+			t.Errorf("Highlight(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRelaxedTerms(t *testing.T) {
+	if got := RelaxedTerms("Keybord mug mouse"); !slices.Equal(got, []string{"keybo", "mug", "mous"}) {
+		t.Errorf("RelaxedTerms = %v", got)
+	}
+}
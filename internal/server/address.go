@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"ecom_apiv1/internal/address"
+	"ecom_apiv1/internal/storer"
+)
+
+// SetAddressValidator replaces the checks shipping addresses go through. By
+// default every country is accepted.
+func (s *Server) SetAddressValidator(v *address.Validator) {
+	s.addresses = v
+}
+
+// CreateAddress checks a and adds it to its user's address book.
+func (s *Server) CreateAddress(ctx context.Context, a *storer.Address) (*storer.Address, error) {
+	sa, err := s.addresses.Normalize(a.ShippingAddress())
+	if err != nil {
+		return nil, err
+	}
+	a.SetShippingAddress(sa)
+	return s.storer.CreateAddress(ctx, a)
+}
+
+func (s *Server) GetAddress(ctx context.Context, id uint) (*storer.Address, error) {
+	return s.storer.GetAddress(ctx, id)
+}
+
+func (s *Server) ListAddresses(ctx context.Context, userID uint) ([]storer.Address, error) {
+	return s.storer.ListAddresses(ctx, userID)
+}
+
+// UpdateAddress checks a and replaces the stored address with it. Orders
+// already placed keep the address they were shipped to.
+func (s *Server) UpdateAddress(ctx context.Context, a *storer.Address) (*storer.Address, error) {
+	sa, err := s.addresses.Normalize(a.ShippingAddress())
+	if err != nil {
+		return nil, err
+	}
+	a.SetShippingAddress(sa)
+	return s.storer.UpdateAddress(ctx, a)
+}
+
+func (s *Server) DeleteAddress(ctx context.Context, id uint) error {
+	return s.storer.DeleteAddress(ctx, id)
+}
+
+// resolveShippingAddress settles where o ships to. The saved address named
+// by o.AddressID, or else the user's default when o brings no address of
+// its own, is copied onto the order, so later edits to the address book
+// never change it. Saved addresses are checked again, the countries
+// shipped to may have changed since they were added.
+func (s *Server) resolveShippingAddress(ctx context.Context, o *storer.Order) error {
+	switch {
+	case o.AddressID != nil:
+		a, err := s.storer.GetAddress(ctx, *o.AddressID)
+		if err != nil {
+			return err
+		}
+		if o.UserID == nil || a.UserID != *o.UserID {
+			return storer.ErrAddressNotFound
+		}
+		o.ShippingAddress = a.ShippingAddress()
+	case o.ShippingAddress.IsZero() && o.UserID != nil:
+		addresses, err := s.storer.ListAddresses(ctx, *o.UserID)
+		if err != nil {
+			return err
+		}
+		if len(addresses) > 0 {
+			o.ShippingAddress = addresses[0].ShippingAddress()
+		}
+	}
+	if o.ShippingAddress.IsZero() {
+		return nil
+	}
+	sa, err := s.addresses.Normalize(o.ShippingAddress)
+	if err != nil {
+		return err
+	}
+	o.ShippingAddress = sa
+	return nil
+}
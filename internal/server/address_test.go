@@ -0,0 +1,63 @@
+package server_test
+
+import (
+	"context"
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/address"
+	"ecom_apiv1/internal/pricing"
+	"ecom_apiv1/internal/server"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"testing"
+)
+
+func TestOrdersSnapshotSavedAddresses(t *testing.T) {
+	ctx := context.Background()
+	store := storer.NewMemoryStorage()
+	srv := server.NewServer(store, pricing.NewCalculator(config.PricingConfig{}), nil)
+	srv.SetAddressValidator(address.NewValidator(config.AddressConfig{Countries: []string{"US", "CA"}}))
+
+	p, _ := store.CreateProduct(ctx, &storer.Product{Name: "Lamp", Price: 30, CountInStock: 10, IsActive: true})
+	u, _ := store.CreateUser(ctx, &storer.User{Name: "Buyer", Email: "buyer@example.com"})
+	other, _ := store.CreateUser(ctx, &storer.User{Name: "Other", Email: "other@example.com"})
+	newOrder := func(userID uint, addressID *uint) *storer.Order {
+		return &storer.Order{UserID: &userID, PaymentMethod: "Stripe", AddressID: addressID, Items: []storer.OrderItem{{ProductID: p.ID, Quantity: 1}}}
+	}
+
+	if _, err := srv.CreateAddress(ctx, &storer.Address{UserID: u.ID, Name: "Buyer", Line1: "1 Rue", City: "Paris", PostalCode: "75001", Country: "FR"}); !errors.Is(err, address.ErrInvalidAddress) {
+		t.Errorf("address in a country not shipped to: expected ErrInvalidAddress, got %v", err)
+	}
+	home, err := srv.CreateAddress(ctx, &storer.Address{UserID: u.ID, Name: "Buyer", Line1: "1 Main St", City: "Springfield", PostalCode: " 12345 ", Country: "us"})
+	if err != nil || home.Country != "US" || home.PostalCode != "12345" {
+		t.Fatalf("CreateAddress = %+v, %v", home, err)
+	}
+	work, _ := srv.CreateAddress(ctx, &storer.Address{UserID: u.ID, Name: "Buyer", Line1: "2 Bay St", City: "Toronto", PostalCode: "m5j 2n8", Country: "CA"})
+
+	o, err := srv.CreateOrder(ctx, newOrder(u.ID, nil))
+	if err != nil || o.ShippingAddress.City != "Springfield" {
+		t.Fatalf("order without an address = %+v, %v, want the default address", o.ShippingAddress, err)
+	}
+	picked, err := srv.CreateOrder(ctx, newOrder(u.ID, &work.ID))
+	if err != nil || picked.ShippingAddress.PostalCode != "M5J 2N8" {
+		t.Fatalf("order to a saved address = %+v, %v", picked.ShippingAddress, err)
+	}
+	if _, err := srv.CreateOrder(ctx, newOrder(other.ID, &work.ID)); !errors.Is(err, storer.ErrAddressNotFound) {
+		t.Errorf("order to another user's address: expected ErrAddressNotFound, got %v", err)
+	}
+
+	home.City = "Shelbyville"
+	if _, err := srv.UpdateAddress(ctx, home); err != nil {
+		t.Fatalf("UpdateAddress: %v", err)
+	}
+	if err := srv.DeleteAddress(ctx, work.ID); err != nil {
+		t.Fatalf("DeleteAddress: %v", err)
+	}
+	got, _ := store.GetOrderByID(ctx, o.ID)
+	if got.ShippingAddress.City != "Springfield" {
+		t.Errorf("editing the address book changed a placed order's address to %s", got.ShippingAddress.City)
+	}
+	got, _ = store.GetOrderByID(ctx, picked.ID)
+	if got.ShippingAddress.City != "Toronto" {
+		t.Errorf("deleting the address changed a placed order's address to %q", got.ShippingAddress.City)
+	}
+}
@@ -0,0 +1,350 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"ecom_apiv1/internal/pricing"
+	"ecom_apiv1/internal/shipping"
+	"ecom_apiv1/internal/storer"
+	"ecom_apiv1/util"
+	"errors"
+	"fmt"
+)
+
+const ReasonInsufficientStock = "not enough stock"
+
+var (
+	ErrEmptyCart          = errors.New("cart is empty")
+	ErrProductUnavailable = errors.New("product is not available")
+)
+
+// CartOwner identifies the cart a request works on: the signed-in user's
+// when UserID is set, otherwise the guest cart issued for Token.
+type CartOwner struct {
+	UserID uint
+	Token  string
+}
+
+// CartLine is a cart item priced against the current catalog.
+type CartLine struct {
+	storer.CartItem
+	// Product is nil once the product has been deleted, and Variant once
+	// the variant has or when the line has none.
+	Product *storer.Product
+	Variant *storer.ProductVariant
+	// Name, Image and SKU are those an order of the line would copy.
+	Name      string
+	Image     string
+	SKU       string
+	UnitPrice float64
+	Total     float64
+	// Warning tells why the line cannot be checked out as it is; it is
+	// empty for lines that can.
+	Warning   string
+	Available int
+}
+
+type CartView struct {
+	// Cart is nil when the owner has no cart yet.
+	Cart *storer.Cart
+	// Token is set when a guest cart was created by this call. The client
+	// has to send it back to use the cart again.
+	Token   string
+	Lines   []CartLine
+	Pricing pricing.Breakdown
+}
+
+func (v *CartView) HasWarnings() bool {
+	for _, l := range v.Lines {
+		if l.Warning != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) GetCart(ctx context.Context, owner CartOwner) (*CartView, error) {
+	c, err := s.findCart(ctx, owner)
+	if errors.Is(err, storer.ErrCartNotFound) {
+		return &CartView{Lines: []CartLine{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.priceCart(ctx, c)
+}
+
+// AddCartItem adds quantity units of the product, or of its variant when
+// variantID is not nil, creating the owner's cart on first use. Products
+// sold by variant need one; inactive products and variants cannot be added.
+// Asking for more than is in stock is allowed and reported as a warning on
+// the line.
+func (s *Server) AddCartItem(ctx context.Context, owner CartOwner, productID uint, variantID *uint, quantity int) (*CartView, error) {
+	p, err := s.storer.GetProduct(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	if !p.IsActive {
+		return nil, ErrProductUnavailable
+	}
+	switch {
+	case variantID != nil:
+		v, err := s.productVariant(ctx, productID, *variantID)
+		if err != nil {
+			return nil, err
+		}
+		if !v.IsActive {
+			return nil, ErrProductUnavailable
+		}
+	case len(p.Options) > 0:
+		return nil, ErrVariantRequired
+	}
+	c, token, err := s.ensureCart(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	c, err = s.storer.AddCartItem(ctx, c.ID, productID, variantID, quantity)
+	if err != nil {
+		return nil, err
+	}
+	view, err := s.priceCart(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	view.Token = token
+	return view, nil
+}
+
+// SetCartItem changes the quantity of a line already in the cart, the line
+// of the product's variant when variantID is not nil. A quantity of zero
+// removes the line.
+func (s *Server) SetCartItem(ctx context.Context, owner CartOwner, productID uint, variantID *uint, quantity int) (*CartView, error) {
+	if quantity == 0 {
+		return s.RemoveCartItem(ctx, owner, productID, variantID)
+	}
+	c, err := s.findCart(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	if !hasCartItem(c, productID, variantID) {
+		return nil, storer.ErrCartItemNotFound
+	}
+	c, err = s.storer.SetCartItem(ctx, c.ID, productID, variantID, quantity)
+	if err != nil {
+		return nil, err
+	}
+	return s.priceCart(ctx, c)
+}
+
+func (s *Server) RemoveCartItem(ctx context.Context, owner CartOwner, productID uint, variantID *uint) (*CartView, error) {
+	c, err := s.findCart(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	c, err = s.storer.RemoveCartItem(ctx, c.ID, productID, variantID)
+	if err != nil {
+		return nil, err
+	}
+	return s.priceCart(ctx, c)
+}
+
+// MergeGuestCart moves the guest cart issued for token into the user's cart.
+// It is a no-op when the token has no cart.
+func (s *Server) MergeGuestCart(ctx context.Context, token string, userID uint) error {
+	guest, err := s.findCart(ctx, CartOwner{Token: token})
+	if errors.Is(err, storer.ErrCartNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c, _, err := s.ensureCart(ctx, CartOwner{UserID: userID})
+	if err != nil {
+		return err
+	}
+	_, err = s.storer.MergeCarts(ctx, guest.ID, c.ID)
+	return err
+}
+
+// Checkout is what the buyer enters when checking out a cart.
+type Checkout struct {
+	PaymentMethod   string
+	Email           string
+	ShippingAddress storer.ShippingAddress
+	// AddressID picks a saved address of a signed-in buyer instead.
+	AddressID  *uint
+	CouponCode string
+}
+
+// CheckoutCart turns the owner's cart into an order priced by CreateOrder.
+// The cart is emptied in the same transaction that stores the order. Guest
+// carts become guest orders; their access token is returned.
+func (s *Server) CheckoutCart(ctx context.Context, owner CartOwner, co Checkout) (*storer.Order, string, error) {
+	c, err := s.findCart(ctx, owner)
+	if errors.Is(err, storer.ErrCartNotFound) {
+		return nil, "", ErrEmptyCart
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if len(c.Items) == 0 {
+		return nil, "", ErrEmptyCart
+	}
+	o := &storer.Order{
+		PaymentMethod:   co.PaymentMethod,
+		Email:           co.Email,
+		ShippingAddress: co.ShippingAddress,
+		AddressID:       co.AddressID,
+		CouponCode:      co.CouponCode,
+		Items:           make([]storer.OrderItem, 0, len(c.Items)),
+		CartID:          &c.ID,
+	}
+	for _, item := range c.Items {
+		o.Items = append(o.Items, storer.OrderItem{ProductID: item.ProductID, VariantID: item.VariantID, Quantity: item.Quantity})
+	}
+	if owner.UserID == 0 {
+		return s.PlaceGuestOrder(ctx, o)
+	}
+	o.UserID = &owner.UserID
+	created, err := s.CreateOrder(ctx, o)
+	return created, "", err
+}
+
+func (s *Server) findCart(ctx context.Context, owner CartOwner) (*storer.Cart, error) {
+	switch {
+	case owner.UserID != 0:
+		return s.storer.GetUserCart(ctx, owner.UserID)
+	case owner.Token != "":
+		return s.storer.GetGuestCart(ctx, util.HashToken(owner.Token))
+	default:
+		return nil, storer.ErrCartNotFound
+	}
+}
+
+// ensureCart returns the owner's cart, creating it when there is none. A
+// new guest cart always gets a freshly generated token, which is returned;
+// tokens sent by clients are never adopted.
+func (s *Server) ensureCart(ctx context.Context, owner CartOwner) (*storer.Cart, string, error) {
+	c, err := s.findCart(ctx, owner)
+	if !errors.Is(err, storer.ErrCartNotFound) {
+		return c, "", err
+	}
+	nc := &storer.Cart{}
+	var token string
+	if owner.UserID != 0 {
+		nc.UserID = &owner.UserID
+	} else {
+		token, err = util.NewToken()
+		if err != nil {
+			return nil, "", err
+		}
+		hash := util.HashToken(token)
+		nc.TokenHash = &hash
+	}
+	c, err = s.storer.CreateCart(ctx, nc)
+	if errors.Is(err, storer.ErrCartAlreadyExists) {
+		// a concurrent request created the user's cart first
+		c, err = s.findCart(ctx, owner)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("error creating cart: %w", err)
+	}
+	return c, token, nil
+}
+
+// priceCart reprices every line from the current catalog and flags lines
+// that would fail at checkout. Lines whose product or variant is gone or
+// inactive, and lines of products sold by variant that have none, are left
+// out of the totals.
+func (s *Server) priceCart(ctx context.Context, c *storer.Cart) (*CartView, error) {
+	ids := make([]uint, 0, len(c.Items))
+	var variantIDs []uint
+	for _, item := range c.Items {
+		ids = append(ids, item.ProductID)
+		if item.VariantID != nil {
+			variantIDs = append(variantIDs, *item.VariantID)
+		}
+	}
+	products, err := s.storer.GetProducts(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]*storer.Product, len(products))
+	for i := range products {
+		byID[products[i].ID] = &products[i]
+	}
+	variants, err := s.storer.GetVariants(ctx, variantIDs)
+	if err != nil {
+		return nil, err
+	}
+	variantByID := make(map[uint]*storer.ProductVariant, len(variants))
+	for i := range variants {
+		variantByID[variants[i].ID] = &variants[i]
+	}
+
+	view := &CartView{Cart: c, Lines: make([]CartLine, 0, len(c.Items))}
+	lines := make([]pricing.Line, 0, len(c.Items))
+	for _, item := range c.Items {
+		line := CartLine{CartItem: item}
+		p, ok := byID[item.ProductID]
+		if !ok {
+			line.Warning = ReasonProductNotFound
+			view.Lines = append(view.Lines, line)
+			continue
+		}
+		line.Product, line.Name, line.Image = p, p.Name, p.Image
+		price, stock := p.Price, p.CountInStock
+		var v *storer.ProductVariant
+		if item.VariantID != nil {
+			if v = variantByID[*item.VariantID]; v != nil && v.ProductID == p.ID {
+				line.Variant = v
+				line.Name = fmt.Sprintf("%s (%s)", p.Name, v.Label(p.Options))
+				line.Image = cmp.Or(v.Image, p.Image)
+				line.SKU = v.SKU
+				price, stock = v.PriceOf(p), v.CountInStock
+			}
+		}
+		switch {
+		case !p.IsActive:
+			line.Warning = ReasonProductInactive
+		case item.VariantID == nil && len(p.Options) > 0:
+			line.Warning = ReasonVariantRequired
+		case item.VariantID != nil && line.Variant == nil:
+			line.Warning = ReasonVariantNotFound
+		case line.Variant != nil && !line.Variant.IsActive:
+			line.Warning = ReasonVariantInactive
+		default:
+			priced := pricing.Line{
+				UnitPrice:   price,
+				Quantity:    item.Quantity,
+				Weight:      shipping.BillableWeight(p.Weight, p.Length, p.Width, p.Height),
+				TaxCategory: p.TaxCategory,
+			}
+			line.UnitPrice = price
+			line.Total = priced.Total()
+			line.Available = stock
+			if stock < item.Quantity {
+				line.Warning = ReasonInsufficientStock
+			}
+			lines = append(lines, priced)
+		}
+		view.Lines = append(view.Lines, line)
+	}
+	// an empty cart owes nothing, not just the shipping fee; the
+	// destination is only known at checkout
+	if len(lines) > 0 {
+		if view.Pricing, err = s.pricing.Price(lines, ""); err != nil {
+			return nil, err
+		}
+	}
+	return view, nil
+}
+
+func hasCartItem(c *storer.Cart, productID uint, variantID *uint) bool {
+	for _, item := range c.Items {
+		if item.IsLine(productID, variantID) {
+			return true
+		}
+	}
+	return false
+}
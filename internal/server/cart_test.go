@@ -0,0 +1,171 @@
+package server_test
+
+import (
+	"context"
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/pricing"
+	"ecom_apiv1/internal/server"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"testing"
+)
+
+func TestCartRepricesAndChecksOut(t *testing.T) {
+	ctx := context.Background()
+	store := storer.NewMemoryStorage()
+	srv := server.NewServer(store, pricing.NewCalculator(config.PricingConfig{TaxRate: 0.1, ShippingFee: 5, FreeShippingOver: 100}), nil)
+
+	keyboard, _ := store.CreateProduct(ctx, &storer.Product{Name: "Keyboard", Image: "k.jpg", Price: 30, CountInStock: 5, IsActive: true})
+	mouse, _ := store.CreateProduct(ctx, &storer.Product{Name: "Mouse", Image: "m.jpg", Price: 10, CountInStock: 1, IsActive: true})
+	retired, _ := store.CreateProduct(ctx, &storer.Product{Name: "Retired", Image: "r.jpg", Price: 1, CountInStock: 5})
+	user, _ := store.CreateUser(ctx, &storer.User{Name: "Buyer", Email: "buyer@example.com", Password: "x"})
+
+	if _, err := srv.AddCartItem(ctx, server.CartOwner{}, retired.ID, nil, 1); !errors.Is(err, server.ErrProductUnavailable) {
+		t.Fatalf("expected ErrProductUnavailable, got %v", err)
+	}
+
+	// a guest fills a cart and gets a token for it
+	view, err := srv.AddCartItem(ctx, server.CartOwner{}, keyboard.ID, nil, 2)
+	if err != nil {
+		t.Fatalf("AddCartItem: %v", err)
+	}
+	guest := server.CartOwner{Token: view.Token}
+	if guest.Token == "" {
+		t.Fatal("expected a guest token for the new cart")
+	}
+	view, err = srv.AddCartItem(ctx, guest, mouse.ID, nil, 2)
+	if err != nil {
+		t.Fatalf("AddCartItem: %v", err)
+	}
+	if view.Token != "" {
+		t.Errorf("existing cart should not get a new token")
+	}
+	if view.Lines[1].Warning != server.ReasonInsufficientStock || view.Lines[1].Available != 1 {
+		t.Errorf("expected a stock warning, got %+v", view.Lines[1])
+	}
+
+	// prices follow the catalog, not the moment an item was added
+	keyboard.Price = 35
+	store.UpdateProduct(ctx, keyboard)
+	view, err = srv.GetCart(ctx, guest)
+	if err != nil {
+		t.Fatalf("GetCart: %v", err)
+	}
+	if view.Lines[0].UnitPrice != 35 || view.Pricing.Subtotal != 90 || view.Pricing.Total != 104 {
+		t.Errorf("cart not repriced: %+v %+v", view.Lines[0], view.Pricing)
+	}
+
+	// logging in moves the guest cart into the user's
+	if err := srv.MergeGuestCart(ctx, guest.Token, user.ID); err != nil {
+		t.Fatalf("MergeGuestCart: %v", err)
+	}
+	owner := server.CartOwner{UserID: user.ID}
+	checkout := server.Checkout{PaymentMethod: "PayPal", Email: user.Email}
+	if _, _, err := srv.CheckoutCart(ctx, owner, checkout); !errors.Is(err, storer.ErrInsufficientStock) {
+		t.Fatalf("expected ErrInsufficientStock, got %v", err)
+	}
+	if _, err := srv.SetCartItem(ctx, owner, mouse.ID, nil, 1); err != nil {
+		t.Fatalf("SetCartItem: %v", err)
+	}
+
+	o, token, err := srv.CheckoutCart(ctx, owner, checkout)
+	if err != nil {
+		t.Fatalf("CheckoutCart: %v", err)
+	}
+	if token != "" {
+		t.Errorf("orders of signed-in users get no access token")
+	}
+	if o.UserID == nil || *o.UserID != user.ID || len(o.Items) != 2 || o.ItemsPrice != 80 || o.TotalPrice != 93 {
+		t.Errorf("unexpected order: %+v", o)
+	}
+	view, err = srv.GetCart(ctx, owner)
+	if err != nil || len(view.Lines) != 0 || view.Pricing.Total != 0 {
+		t.Errorf("cart after checkout = %+v, %v", view, err)
+	}
+	if _, _, err := srv.CheckoutCart(ctx, owner, checkout); !errors.Is(err, server.ErrEmptyCart) {
+		t.Errorf("expected ErrEmptyCart, got %v", err)
+	}
+}
+
+func TestCartVariants(t *testing.T) {
+	ctx := context.Background()
+	store := storer.NewMemoryStorage()
+	srv := server.NewServer(store, pricing.NewCalculator(config.PricingConfig{}), nil)
+
+	shirt, err := srv.CreateProduct(ctx, &storer.Product{
+		Name:     "Shirt",
+		Image:    "shirt.jpg",
+		Price:    20,
+		IsActive: true,
+		Options:  storer.ProductOptions{{Name: "Size", Values: []string{"S", "M", "L"}}},
+	})
+	if err != nil {
+		t.Fatalf("CreateProduct: %v", err)
+	}
+	price := 25.0
+	small, _ := srv.CreateVariant(ctx, &storer.ProductVariant{ProductID: shirt.ID, SKU: "SHIRT-S", Options: storer.VariantOptions{"Size": "S"}, CountInStock: 3, IsActive: true})
+	medium, _ := srv.CreateVariant(ctx, &storer.ProductVariant{ProductID: shirt.ID, SKU: "SHIRT-M", Options: storer.VariantOptions{"Size": "M"}, Price: &price, Image: "m.jpg", CountInStock: 1, IsActive: true})
+	large, _ := srv.CreateVariant(ctx, &storer.ProductVariant{ProductID: shirt.ID, SKU: "SHIRT-L", Options: storer.VariantOptions{"Size": "L"}, CountInStock: 3})
+	hat, _ := srv.CreateProduct(ctx, &storer.Product{Name: "Hat", Price: 10, CountInStock: 5, IsActive: true})
+	user, _ := store.CreateUser(ctx, &storer.User{Name: "Buyer", Email: "buyer@example.com", Password: "x"})
+
+	guest := server.CartOwner{}
+	for name, tc := range map[string]struct {
+		productID uint
+		variantID *uint
+		want      error
+	}{
+		"no variant":              {shirt.ID, nil, server.ErrVariantRequired},
+		"variant of another item": {hat.ID, &small.ID, storer.ErrVariantNotFound},
+		"inactive variant":        {shirt.ID, &large.ID, server.ErrProductUnavailable},
+	} {
+		if _, err := srv.AddCartItem(ctx, guest, tc.productID, tc.variantID, 1); !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", name, tc.want, err)
+		}
+	}
+
+	view, err := srv.AddCartItem(ctx, guest, shirt.ID, &small.ID, 1)
+	if err != nil {
+		t.Fatalf("AddCartItem: %v", err)
+	}
+	guest.Token = view.Token
+	if view, err = srv.AddCartItem(ctx, guest, shirt.ID, &medium.ID, 2); err != nil {
+		t.Fatalf("AddCartItem: %v", err)
+	}
+	m := view.Lines[1]
+	if len(view.Lines) != 2 || m.Name != "Shirt (M)" || m.Image != "m.jpg" || m.SKU != "SHIRT-M" || m.UnitPrice != 25 || m.Available != 1 || m.Warning != server.ReasonInsufficientStock {
+		t.Errorf("variant line = %+v", m)
+	}
+	if view.Pricing.Subtotal != 70 {
+		t.Errorf("subtotal = %v, want 70 at the variant prices", view.Pricing.Subtotal)
+	}
+	if _, err := srv.SetCartItem(ctx, guest, shirt.ID, nil, 1); !errors.Is(err, storer.ErrCartItemNotFound) {
+		t.Errorf("line without a variant: expected ErrCartItemNotFound, got %v", err)
+	}
+
+	// the user already has a small shirt; logging in adds the guest's to it
+	owner := server.CartOwner{UserID: user.ID}
+	if _, err := srv.AddCartItem(ctx, owner, shirt.ID, &small.ID, 1); err != nil {
+		t.Fatalf("AddCartItem: %v", err)
+	}
+	if err := srv.MergeGuestCart(ctx, guest.Token, user.ID); err != nil {
+		t.Fatalf("MergeGuestCart: %v", err)
+	}
+	if view, err = srv.SetCartItem(ctx, owner, shirt.ID, &medium.ID, 1); err != nil {
+		t.Fatalf("SetCartItem: %v", err)
+	}
+	if len(view.Lines) != 2 || view.Lines[0].Quantity != 2 || view.HasWarnings() {
+		t.Fatalf("merged cart = %+v", view.Lines)
+	}
+
+	o, _, err := srv.CheckoutCart(ctx, owner, server.Checkout{PaymentMethod: "PayPal", Email: user.Email})
+	if err != nil {
+		t.Fatalf("CheckoutCart: %v", err)
+	}
+	if len(o.Items) != 2 || o.Items[0].SKU != "SHIRT-S" || o.Items[1].SKU != "SHIRT-M" || o.ItemsPrice != 65 {
+		t.Errorf("order = %+v", o)
+	}
+	if got, _ := store.GetVariant(ctx, small.ID); got.CountInStock != 1 {
+		t.Errorf("small stock = %d, want 1", got.CountInStock)
+	}
+}
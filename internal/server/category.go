@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var ErrInvalidCategory = errors.New("invalid category")
+
+// CategoryError names the category field that was rejected.
+type CategoryError struct {
+	Field  string
+	Reason string
+}
+
+func (e *CategoryError) Error() string {
+	return fmt.Sprintf("%s %s", e.Field, e.Reason)
+}
+
+func (e *CategoryError) Is(target error) bool {
+	return target == ErrInvalidCategory
+}
+
+// GetCategoryTree returns every category arranged under its parent.
+func (s *Server) GetCategoryTree(ctx context.Context) ([]storer.CategoryNode, error) {
+	categories, err := s.storer.ListCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return storer.CategoryTree(categories), nil
+}
+
+// GetCategory returns the category and its subtree.
+func (s *Server) GetCategory(ctx context.Context, id uint) (*storer.CategoryNode, error) {
+	tree, err := s.GetCategoryTree(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if node := findCategory(tree, id); node != nil {
+		return node, nil
+	}
+	return nil, storer.ErrCategoryNotFound
+}
+
+func findCategory(nodes []storer.CategoryNode, id uint) *storer.CategoryNode {
+	for i := range nodes {
+		if nodes[i].ID == id {
+			return &nodes[i]
+		}
+		if node := findCategory(nodes[i].Children, id); node != nil {
+			return node
+		}
+	}
+	return nil
+}
+
+// CreateCategory checks c and stores it. The slug defaults to the slugified
+// name.
+func (s *Server) CreateCategory(ctx context.Context, c *storer.Category) (*storer.Category, error) {
+	if err := validateCategory(c); err != nil {
+		return nil, err
+	}
+	return s.storer.CreateCategory(ctx, c)
+}
+
+// UpdateCategory checks c and replaces the stored category with it. A
+// category cannot be moved under itself or one of its descendants.
+func (s *Server) UpdateCategory(ctx context.Context, c *storer.Category) (*storer.Category, error) {
+	if err := validateCategory(c); err != nil {
+		return nil, err
+	}
+	if c.ParentID != nil {
+		categories, err := s.storer.ListCategories(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(storer.CategorySubtree(categories, c.ID), *c.ParentID) {
+			return nil, &CategoryError{Field: "parent_id", Reason: "must not be the category or one of its subcategories"}
+		}
+	}
+	return s.storer.UpdateCategory(ctx, c)
+}
+
+// DeleteCategory removes a category without subcategories. Its products are
+// moved to the category moveTo, which is required when it has any.
+func (s *Server) DeleteCategory(ctx context.Context, id uint, moveTo *uint) error {
+	if moveTo != nil && *moveTo == id {
+		return &CategoryError{Field: "move_to", Reason: "must be another category"}
+	}
+	return s.storer.DeleteCategory(ctx, id, moveTo)
+}
+
+func validateCategory(c *storer.Category) error {
+	c.Name = strings.TrimSpace(c.Name)
+	c.Slug = storer.Slugify(c.Slug)
+	if c.Slug == "" {
+		c.Slug = storer.Slugify(c.Name)
+	}
+	switch {
+	case c.Name == "":
+		return &CategoryError{Field: "name", Reason: "is required"}
+	case c.Slug == "":
+		return &CategoryError{Field: "slug", Reason: "needs a letter or digit"}
+	case len(c.Slug) > 128:
+		return &CategoryError{Field: "slug", Reason: "must be at most 128 characters"}
+	}
+	return nil
+}
+
+// fileProduct points p at its category, looked up by CategoryID or else by
+// the slug of the Category name, and copies the canonical name over. A
+// product naming no category stays unfiled.
+func (s *Server) fileProduct(ctx context.Context, p *storer.Product) error {
+	var (
+		c   *storer.Category
+		err error
+	)
+	switch {
+	case p.CategoryID != nil:
+		c, err = s.storer.GetCategory(ctx, *p.CategoryID)
+		if errors.Is(err, storer.ErrCategoryNotFound) {
+			return &CategoryError{Field: "category_id", Reason: "does not exist"}
+		}
+	case strings.TrimSpace(p.Category) != "":
+		c, err = s.storer.GetCategoryBySlug(ctx, storer.Slugify(p.Category))
+		if errors.Is(err, storer.ErrCategoryNotFound) {
+			return &CategoryError{Field: "category", Reason: fmt.Sprintf("%q does not exist", strings.TrimSpace(p.Category))}
+		}
+	default:
+		p.Category = ""
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	p.CategoryID, p.Category = &c.ID, c.Name
+	return nil
+}
+
+// resolveCategoryFilter replaces the category name or slug and the category
+// IDs of f with the IDs of those categories and all their descendants. Both
+// given, a product must fall under each. It fails with
+// storer.ErrCategoryNotFound for an unknown category.
+func (s *Server) resolveCategoryFilter(ctx context.Context, f *storer.ProductFilter) error {
+	if f.Category == "" && len(f.CategoryIDs) == 0 {
+		return nil
+	}
+	categories, err := s.storer.ListCategories(ctx)
+	if err != nil {
+		return err
+	}
+	requested := slices.Clone(f.CategoryIDs)
+	if f.Category != "" {
+		slug := storer.Slugify(f.Category)
+		i := slices.IndexFunc(categories, func(c storer.Category) bool { return c.Slug == slug })
+		if i < 0 {
+			return storer.ErrCategoryNotFound
+		}
+		requested = append(requested, categories[i].ID)
+	}
+	var ids []uint
+	for n, id := range requested {
+		if !slices.ContainsFunc(categories, func(c storer.Category) bool { return c.ID == id }) {
+			return storer.ErrCategoryNotFound
+		}
+		subtree := storer.CategorySubtree(categories, id)
+		if n == 0 {
+			ids = subtree
+			continue
+		}
+		ids = slices.DeleteFunc(ids, func(id uint) bool { return !slices.Contains(subtree, id) })
+	}
+	if len(ids) == 0 {
+		// the categories do not overlap, nothing can match
+		ids = []uint{0}
+	}
+	f.Category, f.CategoryIDs = "", ids
+	return nil
+}
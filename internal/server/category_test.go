@@ -0,0 +1,77 @@
+package server_test
+
+import (
+	"context"
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/pricing"
+	"ecom_apiv1/internal/server"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"testing"
+)
+
+func TestCategories(t *testing.T) {
+	ctx := context.Background()
+	store := storer.NewMemoryStorage()
+	srv := server.NewServer(store, pricing.NewCalculator(config.PricingConfig{ShippingFee: 5}), nil)
+
+	electronics, err := srv.CreateCategory(ctx, &storer.Category{Name: " Electronics "})
+	if err != nil || electronics.Name != "Electronics" || electronics.Slug != "electronics" {
+		t.Fatalf("CreateCategory = %+v, %v", electronics, err)
+	}
+	phones, err := srv.CreateCategory(ctx, &storer.Category{Name: "Mobile Phones", ParentID: &electronics.ID})
+	if err != nil || phones.Slug != "mobile-phones" {
+		t.Fatalf("CreateCategory = %+v, %v", phones, err)
+	}
+	if _, err := srv.CreateCategory(ctx, &storer.Category{Name: "!!!"}); !errors.Is(err, server.ErrInvalidCategory) {
+		t.Errorf("name without letters: expected ErrInvalidCategory, got %v", err)
+	}
+	electronics.ParentID = &phones.ID
+	if _, err := srv.UpdateCategory(ctx, electronics); !errors.Is(err, server.ErrInvalidCategory) {
+		t.Errorf("moving a category under its child: expected ErrInvalidCategory, got %v", err)
+	}
+	electronics.ParentID = nil
+
+	// names are matched by slug and replaced with the canonical one
+	tv, err := srv.CreateProduct(ctx, &storer.Product{Name: "TV", Category: "electronics", Price: 300, CountInStock: 1, IsActive: true})
+	if err != nil || tv.CategoryID == nil || *tv.CategoryID != electronics.ID || tv.Category != "Electronics" {
+		t.Fatalf("CreateProduct = %+v, %v", tv, err)
+	}
+	phone, err := srv.CreateProduct(ctx, &storer.Product{Name: "Phone", CategoryID: &phones.ID, Price: 500, CountInStock: 1, IsActive: true})
+	if err != nil || phone.Category != "Mobile Phones" {
+		t.Fatalf("CreateProduct = %+v, %v", phone, err)
+	}
+	if _, err := srv.CreateProduct(ctx, &storer.Product{Name: "Lamp", Category: "Electronic", Price: 20}); !errors.Is(err, server.ErrInvalidCategory) {
+		t.Errorf("unknown category: expected ErrInvalidCategory, got %v", err)
+	}
+
+	page, err := srv.ListProducts(ctx, storer.ProductQuery{ProductFilter: storer.ProductFilter{Category: "Electronics"}})
+	if err != nil || page.Total != 2 {
+		t.Errorf("products under Electronics = %+v, %v, want both", page, err)
+	}
+	page, err = srv.ListProducts(ctx, storer.ProductQuery{ProductFilter: storer.ProductFilter{CategoryIDs: []uint{phones.ID}}})
+	if err != nil || page.Total != 1 || page.Items[0].ID != phone.ID {
+		t.Errorf("products under Mobile Phones = %+v, %v, want the phone", page, err)
+	}
+	if _, err := srv.ListProducts(ctx, storer.ProductQuery{ProductFilter: storer.ProductFilter{Category: "garden"}}); !errors.Is(err, storer.ErrCategoryNotFound) {
+		t.Errorf("unknown category filter: expected ErrCategoryNotFound, got %v", err)
+	}
+	facets, err := srv.GetProductFacets(ctx, storer.ProductFilter{Category: "electronics"})
+	if err != nil || facets.Total != 2 || len(facets.Categories) != 2 {
+		t.Errorf("facets = %+v, %v", facets, err)
+	}
+
+	node, err := srv.GetCategory(ctx, electronics.ID)
+	if err != nil || len(node.Children) != 1 || node.Children[0].ID != phones.ID {
+		t.Errorf("GetCategory = %+v, %v", node, err)
+	}
+	if err := srv.DeleteCategory(ctx, phones.ID, &phones.ID); !errors.Is(err, server.ErrInvalidCategory) {
+		t.Errorf("moving products into the deleted category: expected ErrInvalidCategory, got %v", err)
+	}
+	if err := srv.DeleteCategory(ctx, phones.ID, &electronics.ID); err != nil {
+		t.Fatalf("DeleteCategory: %v", err)
+	}
+	if p, _ := srv.GetProduct(ctx, phone.ID); p.Category != "Electronics" {
+		t.Errorf("category of the moved phone = %q, want Electronics", p.Category)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	ErrIdempotencyKeyReused = errors.New("idempotency key was used with a different request")
+	ErrRequestInFlight      = errors.New("a request with this idempotency key is still being handled")
+)
+
+// BeginIdempotentRequest claims k.Key in k.Scope for the request with
+// k.Fingerprint until k.ExpiresAt, the lease of the request. It returns nil
+// when the request should be handled, with k.ID set for
+// FinishIdempotentRequest, or the stored key whose response should be
+// replayed when the same request already completed. A key whose lease ran
+// out before its request finished is claimed afresh.
+func (s *Server) BeginIdempotentRequest(ctx context.Context, k *storer.IdempotencyKey) (*storer.IdempotencyKey, error) {
+	for {
+		_, err := s.storer.CreateIdempotencyKey(ctx, k)
+		if err == nil {
+			return nil, nil
+		}
+		if !errors.Is(err, storer.ErrIdempotencyKeyExists) {
+			return nil, err
+		}
+		stored, err := s.storer.GetIdempotencyKey(ctx, k.Scope, k.Key)
+		if errors.Is(err, storer.ErrIdempotencyKeyNotFound) {
+			// released by a failed request in the meantime
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if stored.ExpiresAt.Before(time.Now()) {
+			// expired, or held by a request that never finished, but not
+			// cleaned up yet, so the key is free again
+			if err := s.storer.DeleteIdempotencyKey(ctx, stored.ID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		switch {
+		case stored.Fingerprint != k.Fingerprint:
+			return nil, ErrIdempotencyKeyReused
+		case !stored.Completed():
+			return nil, ErrRequestInFlight
+		}
+		return stored, nil
+	}
+}
+
+// FinishIdempotentRequest stores the response to a request begun with
+// BeginIdempotentRequest for replay until expiresAt. A server error is not
+// stored: the key is released so that a retry is handled afresh.
+func (s *Server) FinishIdempotentRequest(ctx context.Context, id uint, statusCode int, contentType string, body []byte, expiresAt time.Time) error {
+	if statusCode >= 500 {
+		return s.storer.DeleteIdempotencyKey(ctx, id)
+	}
+	return s.storer.CompleteIdempotencyKey(ctx, id, statusCode, contentType, body, expiresAt)
+}
+
+// CleanupIdempotencyKeys deletes the keys that have expired.
+func (s *Server) CleanupIdempotencyKeys(ctx context.Context) (int64, error) {
+	n, err := s.storer.DeleteExpiredIdempotencyKeys(ctx, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("error cleaning up idempotency keys: %w", err)
+	}
+	return n, nil
+}
+
+// RunIdempotencyCleanup calls CleanupIdempotencyKeys every interval until
+// ctx is done.
+func (s *Server) RunIdempotencyCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := s.CleanupIdempotencyKeys(ctx); err != nil {
+				log.Print(err)
+			} else if n > 0 {
+				log.Printf("deleted %d expired idempotency keys", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package server_test
+
+import (
+	"context"
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/pricing"
+	"ecom_apiv1/internal/server"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIdempotentRequests(t *testing.T) {
+	ctx := context.Background()
+	store := storer.NewMemoryStorage()
+	srv := server.NewServer(store, pricing.NewCalculator(config.PricingConfig{}), nil)
+	newKey := func(fingerprint string) *storer.IdempotencyKey {
+		return &storer.IdempotencyKey{Scope: "user:1", Key: "order-1", Fingerprint: fingerprint, ExpiresAt: time.Now().Add(time.Hour)}
+	}
+
+	first := newKey("abc")
+	if stored, err := srv.BeginIdempotentRequest(ctx, first); err != nil || stored != nil {
+		t.Fatalf("first request = %+v, %v", stored, err)
+	}
+	if _, err := srv.BeginIdempotentRequest(ctx, newKey("abc")); !errors.Is(err, server.ErrRequestInFlight) {
+		t.Errorf("expected ErrRequestInFlight, got %v", err)
+	}
+	if _, err := srv.BeginIdempotentRequest(ctx, newKey("other")); !errors.Is(err, server.ErrIdempotencyKeyReused) {
+		t.Errorf("expected ErrIdempotencyKeyReused, got %v", err)
+	}
+
+	if err := srv.FinishIdempotentRequest(ctx, first.ID, 201, "application/json", []byte(`{"id":7}`), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("FinishIdempotentRequest: %v", err)
+	}
+	stored, err := srv.BeginIdempotentRequest(ctx, newKey("abc"))
+	if err != nil || stored == nil || stored.StatusCode != 201 || string(stored.Body) != `{"id":7}` {
+		t.Fatalf("repeat = %+v, %v", stored, err)
+	}
+
+	t.Run("server errors release the key", func(t *testing.T) {
+		k := &storer.IdempotencyKey{Scope: "anon", Key: "flaky", Fingerprint: "abc", ExpiresAt: time.Now().Add(time.Hour)}
+		srv.BeginIdempotentRequest(ctx, k)
+		if err := srv.FinishIdempotentRequest(ctx, k.ID, 503, "text/plain", []byte("unavailable"), time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("FinishIdempotentRequest: %v", err)
+		}
+		retry := &storer.IdempotencyKey{Scope: "anon", Key: "flaky", Fingerprint: "abc", ExpiresAt: time.Now().Add(time.Hour)}
+		if stored, err := srv.BeginIdempotentRequest(ctx, retry); err != nil || stored != nil {
+			t.Errorf("retry after a server error = %+v, %v, want it handled afresh", stored, err)
+		}
+	})
+
+	t.Run("abandoned requests lose the key after their lease", func(t *testing.T) {
+		lease := func() *storer.IdempotencyKey {
+			return &storer.IdempotencyKey{Scope: "anon", Key: "crashed", Fingerprint: "abc", ExpiresAt: time.Now().Add(20 * time.Millisecond)}
+		}
+		srv.BeginIdempotentRequest(ctx, lease())
+		if _, err := srv.BeginIdempotentRequest(ctx, lease()); !errors.Is(err, server.ErrRequestInFlight) {
+			t.Fatalf("within the lease: expected ErrRequestInFlight, got %v", err)
+		}
+		time.Sleep(30 * time.Millisecond)
+		retry := lease()
+		if stored, err := srv.BeginIdempotentRequest(ctx, retry); err != nil || stored != nil {
+			t.Fatalf("after the lease = %+v, %v, want the retry handled", stored, err)
+		}
+
+		// the response outlives the lease
+		srv.FinishIdempotentRequest(ctx, retry.ID, 201, "application/json", []byte(`{"id":8}`), time.Now().Add(time.Hour))
+		time.Sleep(30 * time.Millisecond)
+		if stored, err := srv.BeginIdempotentRequest(ctx, lease()); err != nil || stored == nil || string(stored.Body) != `{"id":8}` {
+			t.Errorf("replay after the lease = %+v, %v", stored, err)
+		}
+	})
+
+	t.Run("expired keys are free again", func(t *testing.T) {
+		old := &storer.IdempotencyKey{Scope: "anon", Key: "old", Fingerprint: "abc", ExpiresAt: time.Now().Add(-time.Minute)}
+		store.CreateIdempotencyKey(ctx, old)
+		reused := &storer.IdempotencyKey{Scope: "anon", Key: "old", Fingerprint: "different", ExpiresAt: time.Now().Add(time.Hour)}
+		if stored, err := srv.BeginIdempotentRequest(ctx, reused); err != nil || stored != nil {
+			t.Errorf("reusing an expired key = %+v, %v", stored, err)
+		}
+
+		store.CreateIdempotencyKey(ctx, &storer.IdempotencyKey{Scope: "anon", Key: "stale", Fingerprint: "abc", ExpiresAt: time.Now().Add(-time.Minute)})
+		if n, err := srv.CleanupIdempotencyKeys(ctx); err != nil || n != 1 {
+			t.Errorf("CleanupIdempotencyKeys = %d, %v, want 1", n, err)
+		}
+	})
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/blob"
+	"ecom_apiv1/internal/imaging"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidImage        = errors.New("invalid image")
+	ErrImagesNotConfigured = errors.New("image uploads are not configured")
+)
+
+// ImageError names the image field that was rejected.
+type ImageError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ImageError) Error() string {
+	return fmt.Sprintf("%s %s", e.Field, e.Reason)
+}
+
+func (e *ImageError) Is(target error) bool {
+	return target == ErrInvalidImage
+}
+
+// SetImageStore sets where uploaded product images are kept and the limits
+// they are held to. Uploads fail with ErrImagesNotConfigured until it is
+// called.
+func (s *Server) SetImageStore(store blob.Store, cfg config.ImageConfig) {
+	s.blobs, s.imageCfg = store, cfg
+}
+
+// MaxImageSize is the largest image upload accepted, in bytes.
+func (s *Server) MaxImageSize() int {
+	return s.imageCfg.MaxSize
+}
+
+// MediaHandler serves the uploaded files under path when the image store
+// keeps them on local disk, and is nil otherwise.
+func (s *Server) MediaHandler() (path string, h http.Handler) {
+	local, ok := s.blobs.(*blob.Local)
+	if !ok {
+		return "", nil
+	}
+	return local.MountPath(), local
+}
+
+// UploadProductImage checks data is an image, whatever type the upload
+// claimed, stores it with a thumbnail and adds it after the product's last
+// image.
+func (s *Server) UploadProductImage(ctx context.Context, productID uint, data []byte, altText string) (*storer.ProductImage, error) {
+	if s.blobs == nil {
+		return nil, ErrImagesNotConfigured
+	}
+	altText, err := checkAltText(altText)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(data) == 0:
+		return nil, &ImageError{Field: "image", Reason: "is required"}
+	case len(data) > s.imageCfg.MaxSize:
+		return nil, &ImageError{Field: "image", Reason: fmt.Sprintf("must be at most %d bytes", s.imageCfg.MaxSize)}
+	}
+	if _, err := s.storer.GetProduct(ctx, productID); err != nil {
+		return nil, err
+	}
+	img, err := imaging.Decode(data)
+	if err != nil {
+		return nil, &ImageError{Field: "image", Reason: err.Error()}
+	}
+	thumb, thumbType, err := img.Thumbnail(s.imageCfg.ThumbnailSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// keys are never reused, so the files can be cached for good
+	name := fmt.Sprintf("products/%d/%s", productID, uuid.NewString())
+	pi := &storer.ProductImage{
+		ProductID:    productID,
+		BlobKey:      name + imaging.Extension(img.ContentType),
+		ThumbnailKey: name + "_thumb" + imaging.Extension(thumbType),
+		ContentType:  img.ContentType,
+		Size:         int64(len(data)),
+		Width:        img.Width,
+		Height:       img.Height,
+		AltText:      altText,
+	}
+	pi.URL, pi.ThumbnailURL = s.blobs.URL(pi.BlobKey), s.blobs.URL(pi.ThumbnailKey)
+	if err := s.blobs.Put(ctx, pi.BlobKey, bytes.NewReader(data), int64(len(data)), img.ContentType); err != nil {
+		return nil, err
+	}
+	if err := s.blobs.Put(ctx, pi.ThumbnailKey, bytes.NewReader(thumb), int64(len(thumb)), thumbType); err != nil {
+		s.deleteBlobs(ctx, *pi)
+		return nil, err
+	}
+	created, err := s.storer.CreateProductImage(ctx, pi)
+	if err != nil {
+		s.deleteBlobs(ctx, *pi)
+		return nil, err
+	}
+	if err := s.syncCoverImage(ctx, productID, ""); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// ListProductImages returns the images of a product in display order.
+func (s *Server) ListProductImages(ctx context.Context, productID uint) ([]storer.ProductImage, error) {
+	if _, err := s.storer.GetProduct(ctx, productID); err != nil {
+		return nil, err
+	}
+	return s.storer.ListProductImages(ctx, productID)
+}
+
+// UpdateProductImage replaces the alt text of one of the product's images.
+func (s *Server) UpdateProductImage(ctx context.Context, productID, id uint, altText string) (*storer.ProductImage, error) {
+	altText, err := checkAltText(altText)
+	if err != nil {
+		return nil, err
+	}
+	img, err := s.productImage(ctx, productID, id)
+	if err != nil {
+		return nil, err
+	}
+	img.AltText = altText
+	return s.storer.UpdateProductImage(ctx, img)
+}
+
+// ReorderProductImages shows the images of a product in the order of ids,
+// the first one becoming the product's Image.
+func (s *Server) ReorderProductImages(ctx context.Context, productID uint, ids []uint) ([]storer.ProductImage, error) {
+	images, err := s.storer.ReorderProductImages(ctx, productID, ids)
+	if errors.Is(err, storer.ErrInvalidImageOrder) {
+		return nil, &ImageError{Field: "image_ids", Reason: "must list every image of the product once"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.syncCoverImage(ctx, productID, ""); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// DeleteProductImage removes one of the product's images and its files.
+func (s *Server) DeleteProductImage(ctx context.Context, productID, id uint) error {
+	img, err := s.productImage(ctx, productID, id)
+	if err != nil {
+		return err
+	}
+	if err := s.storer.DeleteProductImage(ctx, id); err != nil {
+		return err
+	}
+	s.deleteBlobs(ctx, *img)
+	return s.syncCoverImage(ctx, productID, img.URL)
+}
+
+// productImage loads an image of the product. Images of other products
+// are not found.
+func (s *Server) productImage(ctx context.Context, productID, id uint) (*storer.ProductImage, error) {
+	img, err := s.storer.GetProductImage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if img.ProductID != productID {
+		return nil, storer.ErrImageNotFound
+	}
+	return img, nil
+}
+
+// syncCoverImage makes the first image of the product its Image, which
+// listings show and order items copy. When the last image is gone the
+// product keeps an Image set by hand, but not removedURL.
+func (s *Server) syncCoverImage(ctx context.Context, productID uint, removedURL string) error {
+	p, err := s.storer.GetProduct(ctx, productID)
+	if err != nil {
+		return err
+	}
+	images, err := s.storer.ListProductImages(ctx, productID)
+	if err != nil {
+		return err
+	}
+	cover := p.Image
+	switch {
+	case len(images) > 0:
+		cover = images[0].URL
+	case removedURL != "" && p.Image == removedURL:
+		cover = ""
+	}
+	if cover == p.Image {
+		return nil
+	}
+	p.Image = cover
+	_, err = s.storer.UpdateProduct(ctx, p)
+	return err
+}
+
+// deleteBlobs removes the files of an image. A file left behind only
+// takes space, so failures are logged rather than returned.
+func (s *Server) deleteBlobs(ctx context.Context, img storer.ProductImage) {
+	if s.blobs == nil {
+		return
+	}
+	for _, key := range []string{img.BlobKey, img.ThumbnailKey} {
+		if key == "" {
+			continue
+		}
+		if err := s.blobs.Delete(ctx, key); err != nil {
+			log.Printf("error deleting blob %s: %v", key, err)
+		}
+	}
+}
+
+func checkAltText(altText string) (string, error) {
+	altText = strings.TrimSpace(altText)
+	if utf8.RuneCountInString(altText) > 255 {
+		return "", &ImageError{Field: "alt_text", Reason: "must be at most 255 characters"}
+	}
+	return altText, nil
+}
@@ -0,0 +1,123 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/blob"
+	"ecom_apiv1/internal/pricing"
+	"ecom_apiv1/internal/server"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func pngOf(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestProductImages(t *testing.T) {
+	ctx := context.Background()
+	store := storer.NewMemoryStorage()
+	srv := server.NewServer(store, pricing.NewCalculator(config.PricingConfig{}), nil)
+	p, err := srv.CreateProduct(ctx, &storer.Product{Name: "Tent", Image: "https://cdn.example.com/tent.jpg", Price: 120, IsActive: true})
+	if err != nil {
+		t.Fatalf("CreateProduct: %v", err)
+	}
+	if _, err := srv.UploadProductImage(ctx, p.ID, pngOf(t, 10, 10), ""); !errors.Is(err, server.ErrImagesNotConfigured) {
+		t.Errorf("upload without a store: expected ErrImagesNotConfigured, got %v", err)
+	}
+
+	blobs, err := blob.NewLocal(t.TempDir(), "/media")
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+	srv.SetImageStore(blobs, config.ImageConfig{MaxSize: 4 << 10, ThumbnailSize: 50})
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"too large": append(pngOf(t, 1, 1), make([]byte, 4<<10)...),
+		"svg":       []byte("<svg xmlns='http://www.w3.org/2000/svg'></svg>"),
+	} {
+		if _, err := srv.UploadProductImage(ctx, p.ID, data, ""); !errors.Is(err, server.ErrInvalidImage) {
+			t.Errorf("%s upload: expected ErrInvalidImage, got %v", name, err)
+		}
+	}
+	if _, err := srv.UploadProductImage(ctx, 9999, pngOf(t, 10, 10), ""); !errors.Is(err, storer.ErrProductNotFound) {
+		t.Errorf("expected ErrProductNotFound, got %v", err)
+	}
+
+	first, err := srv.UploadProductImage(ctx, p.ID, pngOf(t, 200, 100), " Front ")
+	if err != nil {
+		t.Fatalf("UploadProductImage: %v", err)
+	}
+	if first.ContentType != "image/png" || first.Width != 200 || first.Height != 100 || first.AltText != "Front" || first.URL != blobs.URL(first.BlobKey) {
+		t.Errorf("uploaded image = %+v", first)
+	}
+	rc, err := blobs.Get(ctx, first.ThumbnailKey)
+	if err != nil {
+		t.Fatalf("thumbnail not stored: %v", err)
+	}
+	thumb, _ := io.ReadAll(rc)
+	rc.Close()
+	if cfg, err := png.DecodeConfig(bytes.NewReader(thumb)); err != nil || cfg.Width != 50 || cfg.Height != 25 {
+		t.Errorf("thumbnail = %dx%d, %v, want 50x25", cfg.Width, cfg.Height, err)
+	}
+	if got, _ := srv.GetProduct(ctx, p.ID); got.Image != first.URL {
+		t.Errorf("product image = %q, want the first upload %q", got.Image, first.URL)
+	}
+
+	second, err := srv.UploadProductImage(ctx, p.ID, pngOf(t, 10, 10), "")
+	if err != nil {
+		t.Fatalf("UploadProductImage: %v", err)
+	}
+	if _, err := srv.ReorderProductImages(ctx, p.ID, []uint{second.ID}); !errors.Is(err, server.ErrInvalidImage) {
+		t.Errorf("partial order: expected ErrInvalidImage, got %v", err)
+	}
+	images, err := srv.ReorderProductImages(ctx, p.ID, []uint{second.ID, first.ID})
+	if err != nil || len(images) != 2 || images[0].ID != second.ID {
+		t.Fatalf("ReorderProductImages = %+v, %v", images, err)
+	}
+	if got, _ := srv.GetProduct(ctx, p.ID); got.Image != second.URL {
+		t.Errorf("product image after reorder = %q, want %q", got.Image, second.URL)
+	}
+
+	other, _ := srv.CreateProduct(ctx, &storer.Product{Name: "Stove", Price: 40, IsActive: true})
+	if _, err := srv.UpdateProductImage(ctx, other.ID, first.ID, "x"); !errors.Is(err, storer.ErrImageNotFound) {
+		t.Errorf("image of another product: expected ErrImageNotFound, got %v", err)
+	}
+	if updated, err := srv.UpdateProductImage(ctx, p.ID, first.ID, "Side"); err != nil || updated.AltText != "Side" {
+		t.Errorf("UpdateProductImage = %+v, %v", updated, err)
+	}
+
+	if err := srv.DeleteProductImage(ctx, p.ID, second.ID); err != nil {
+		t.Fatalf("DeleteProductImage: %v", err)
+	}
+	if _, err := blobs.Get(ctx, second.BlobKey); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("deleted image still stored: %v", err)
+	}
+	if err := srv.DeleteProductImage(ctx, p.ID, first.ID); err != nil {
+		t.Fatalf("DeleteProductImage: %v", err)
+	}
+	if got, _ := srv.GetProduct(ctx, p.ID); got.Image != "" {
+		t.Errorf("product image after deleting every upload = %q", got.Image)
+	}
+
+	last, err := srv.UploadProductImage(ctx, p.ID, pngOf(t, 10, 10), "")
+	if err != nil {
+		t.Fatalf("UploadProductImage: %v", err)
+	}
+	if err := srv.DeleteProduct(ctx, p.ID); err != nil {
+		t.Fatalf("DeleteProduct: %v", err)
+	}
+	if _, err := blobs.Get(ctx, last.ThumbnailKey); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("images of a deleted product still stored: %v", err)
+	}
+}
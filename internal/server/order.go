@@ -0,0 +1,220 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"ecom_apiv1/internal/pricing"
+	"ecom_apiv1/internal/promotion"
+	"ecom_apiv1/internal/shipping"
+	"ecom_apiv1/internal/storer"
+	"ecom_apiv1/internal/tax"
+	"ecom_apiv1/util"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	ReasonProductNotFound = "product not found"
+	ReasonProductInactive = "product is not available"
+)
+
+var (
+	ErrGuestEmailRequired = errors.New("guest orders need an email address")
+	ErrClaimEmailMismatch = errors.New("order was placed with a different email address")
+)
+
+// UnavailableItem is an order line that cannot be bought.
+type UnavailableItem struct {
+	// Index is the position of the line in the order.
+	Index     int
+	ProductID uint
+	Reason    string
+}
+
+type UnavailableItemsError struct {
+	Items []UnavailableItem
+}
+
+func (e *UnavailableItemsError) Error() string {
+	parts := make([]string, len(e.Items))
+	for i, item := range e.Items {
+		parts[i] = fmt.Sprintf("product %d: %s", item.ProductID, item.Reason)
+	}
+	return "order has unavailable items: " + strings.Join(parts, ", ")
+}
+
+// CreateOrder prices o from the catalog, applies the automatic promotions
+// and the coupon in o.CouponCode, and stores it. Only ProductID, VariantID
+// and Quantity are read from o.Items; names, SKUs, images, prices,
+// discounts and every total are overwritten with server-side values.
+func (s *Server) CreateOrder(ctx context.Context, o *storer.Order) (*storer.Order, error) {
+	if err := s.resolveShippingAddress(ctx, o); err != nil {
+		return nil, err
+	}
+	lines, promoLines, err := s.orderLines(ctx, o.Items)
+	if err != nil {
+		return nil, err
+	}
+	buyer := storer.Redeemer{UserID: o.UserID, Email: o.Email}
+	b, discounts, err := s.priceWithPromotions(ctx, lines, promoLines, o.ShippingAddress.Country, buyer, o.CouponCode)
+	if err != nil {
+		return nil, err
+	}
+	o.ItemsPrice = b.Subtotal
+	o.TaxPrice = b.Tax
+	o.ShippingPrice = b.Shipping
+	o.DiscountPrice = discounts.Total
+	o.TotalPrice = b.Total
+	for i := range o.Items {
+		o.Items[i].Tax = b.Taxes.Lines[i]
+		o.Items[i].Discount = discounts.Lines[i]
+	}
+	o.TaxBreakdown = taxBreakdown(b.Taxes)
+	o.Discounts = discounts.Discounts
+	return s.storer.CreateOrder(ctx, o)
+}
+
+func taxBreakdown(r tax.Result) storer.TaxBreakdown {
+	b := storer.TaxBreakdown{Country: r.Country, Inclusive: r.Included()}
+	for _, a := range r.Amounts {
+		b.Lines = append(b.Lines, storer.TaxLine{Category: a.Category, Rate: a.Rate, Taxable: a.Taxable, Tax: a.Tax})
+	}
+	return b
+}
+
+// orderLines fills in the catalog name, SKU, image and price of items and
+// returns the lines to price and to discount them by, one per item. Items
+// of a product sold by variant need a variant of it, whose price and image
+// win over the product's.
+func (s *Server) orderLines(ctx context.Context, items []storer.OrderItem) ([]pricing.Line, []promotion.Line, error) {
+	ids := make([]uint, 0, len(items))
+	var variantIDs []uint
+	for _, item := range items {
+		ids = append(ids, item.ProductID)
+		if item.VariantID != nil {
+			variantIDs = append(variantIDs, *item.VariantID)
+		}
+	}
+	products, err := s.storer.GetProducts(ctx, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	byID := make(map[uint]*storer.Product, len(products))
+	for i := range products {
+		byID[products[i].ID] = &products[i]
+	}
+	variants, err := s.storer.GetVariants(ctx, variantIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	variantByID := make(map[uint]*storer.ProductVariant, len(variants))
+	for i := range variants {
+		variantByID[variants[i].ID] = &variants[i]
+	}
+
+	var unavailable []UnavailableItem
+	lines := make([]pricing.Line, 0, len(items))
+	promoLines := make([]promotion.Line, 0, len(items))
+	for i := range items {
+		item := &items[i]
+		p, ok := byID[item.ProductID]
+		switch {
+		case !ok:
+			unavailable = append(unavailable, UnavailableItem{Index: i, ProductID: item.ProductID, Reason: ReasonProductNotFound})
+			continue
+		case !p.IsActive:
+			unavailable = append(unavailable, UnavailableItem{Index: i, ProductID: item.ProductID, Reason: ReasonProductInactive})
+			continue
+		}
+		item.Name, item.Image, item.Price, item.SKU = p.Name, p.Image, p.Price, ""
+		if item.VariantID != nil || len(p.Options) > 0 {
+			var v *storer.ProductVariant
+			if item.VariantID != nil {
+				v = variantByID[*item.VariantID]
+			}
+			switch {
+			case item.VariantID == nil:
+				unavailable = append(unavailable, UnavailableItem{Index: i, ProductID: item.ProductID, Reason: ReasonVariantRequired})
+				continue
+			case v == nil || v.ProductID != p.ID:
+				unavailable = append(unavailable, UnavailableItem{Index: i, ProductID: item.ProductID, Reason: ReasonVariantNotFound})
+				continue
+			case !v.IsActive:
+				unavailable = append(unavailable, UnavailableItem{Index: i, ProductID: item.ProductID, Reason: ReasonVariantInactive})
+				continue
+			}
+			item.Name = fmt.Sprintf("%s (%s)", p.Name, v.Label(p.Options))
+			item.Image = cmp.Or(v.Image, p.Image)
+			item.Price = v.PriceOf(p)
+			item.SKU = v.SKU
+		}
+		lines = append(lines, pricing.Line{
+			UnitPrice:   item.Price,
+			Quantity:    item.Quantity,
+			Weight:      shipping.BillableWeight(p.Weight, p.Length, p.Width, p.Height),
+			TaxCategory: p.TaxCategory,
+		})
+		promoLines = append(promoLines, promotion.Line{
+			ProductID: p.ID,
+			Category:  p.Category,
+			UnitPrice: item.Price,
+			Quantity:  item.Quantity,
+		})
+	}
+	if len(unavailable) > 0 {
+		return nil, nil, &UnavailableItemsError{Items: unavailable}
+	}
+	return lines, promoLines, nil
+}
+
+// QuoteShipping prices shipping items to country before an order is placed,
+// with the automatic promotions and coupon applied. Per-user promotion
+// limits are left to CreateOrder, the buyer is not known yet.
+func (s *Server) QuoteShipping(ctx context.Context, items []storer.OrderItem, country, coupon string) (pricing.Breakdown, error) {
+	lines, promoLines, err := s.orderLines(ctx, items)
+	if err != nil {
+		return pricing.Breakdown{}, err
+	}
+	b, _, err := s.priceWithPromotions(ctx, lines, promoLines, country, storer.Redeemer{}, coupon)
+	return b, err
+}
+
+// PlaceGuestOrder creates o for a buyer without an account. The returned
+// token is the only way to reach the order until it is claimed; just its
+// hash is stored.
+func (s *Server) PlaceGuestOrder(ctx context.Context, o *storer.Order) (*storer.Order, string, error) {
+	if o.Email == "" {
+		return nil, "", ErrGuestEmailRequired
+	}
+	token, err := util.NewToken()
+	if err != nil {
+		return nil, "", err
+	}
+	hash := util.HashToken(token)
+	o.UserID = nil
+	o.AccessTokenHash = &hash
+	created, err := s.CreateOrder(ctx, o)
+	if err != nil {
+		return nil, "", err
+	}
+	return created, token, nil
+}
+
+func (s *Server) GetGuestOrder(ctx context.Context, token string) (*storer.Order, error) {
+	return s.storer.GetOrderByAccessToken(ctx, util.HashToken(token))
+}
+
+// ClaimGuestOrder moves a guest order into the account of the signed-in
+// user. The account must use the email the order was placed with, so a
+// forwarded link alone is not enough to take an order over.
+func (s *Server) ClaimGuestOrder(ctx context.Context, token string, userID uint, email string) (*storer.Order, error) {
+	o, err := s.GetGuestOrder(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(o.Email, email) {
+		return nil, ErrClaimEmailMismatch
+	}
+	return s.storer.ClaimOrder(ctx, util.HashToken(token), userID)
+}
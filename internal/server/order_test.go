@@ -0,0 +1,217 @@
+package server_test
+
+import (
+	"context"
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/pricing"
+	"ecom_apiv1/internal/server"
+	"ecom_apiv1/internal/shipping"
+	"ecom_apiv1/internal/storer"
+	"ecom_apiv1/internal/tax"
+	"errors"
+	"testing"
+)
+
+func TestCreateOrderPricesFromCatalog(t *testing.T) {
+	ctx := context.Background()
+	store := storer.NewMemoryStorage()
+	srv := server.NewServer(store, pricing.NewCalculator(config.PricingConfig{TaxRate: 0.1, ShippingFee: 5, FreeShippingOver: 100}), nil)
+
+	keyboard, _ := store.CreateProduct(ctx, &storer.Product{Name: "Keyboard", Image: "k.jpg", Price: 30, CountInStock: 5, IsActive: true})
+	mouse, _ := store.CreateProduct(ctx, &storer.Product{Name: "Mouse", Image: "m.jpg", Price: 12.5, CountInStock: 5, IsActive: true})
+	retired, _ := store.CreateProduct(ctx, &storer.Product{Name: "Retired", Image: "r.jpg", Price: 1, CountInStock: 5})
+	userID := uint(1)
+
+	o, err := srv.CreateOrder(ctx, &storer.Order{
+		UserID:        &userID,
+		PaymentMethod: "PayPal",
+		TotalPrice:    0.01,
+		Items: []storer.OrderItem{
+			{ProductID: keyboard.ID, Quantity: 2, Price: 0.01, Name: "fake"},
+			{ProductID: mouse.ID, Quantity: 1},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if o.ItemsPrice != 72.5 || o.TaxPrice != 7.25 || o.ShippingPrice != 5 || o.TotalPrice != 84.75 {
+		t.Errorf("unexpected pricing: items %v tax %v shipping %v total %v", o.ItemsPrice, o.TaxPrice, o.ShippingPrice, o.TotalPrice)
+	}
+	if item := o.Items[0]; item.Name != "Keyboard" || item.Image != "k.jpg" || item.Price != 30 {
+		t.Errorf("item not snapshotted from catalog: %+v", item)
+	}
+
+	_, err = srv.CreateOrder(ctx, &storer.Order{
+		UserID:        &userID,
+		PaymentMethod: "PayPal",
+		Items: []storer.OrderItem{
+			{ProductID: keyboard.ID, Quantity: 1},
+			{ProductID: retired.ID, Quantity: 1},
+			{ProductID: 999, Quantity: 1},
+		},
+	})
+	var unavailable *server.UnavailableItemsError
+	if !errors.As(err, &unavailable) {
+		t.Fatalf("expected UnavailableItemsError, got %v", err)
+	}
+	want := []server.UnavailableItem{
+		{Index: 1, ProductID: retired.ID, Reason: server.ReasonProductInactive},
+		{Index: 2, ProductID: 999, Reason: server.ReasonProductNotFound},
+	}
+	if len(unavailable.Items) != len(want) || unavailable.Items[0] != want[0] || unavailable.Items[1] != want[1] {
+		t.Errorf("unavailable items = %+v, want %+v", unavailable.Items, want)
+	}
+}
+
+func TestCreateOrderPricesShipping(t *testing.T) {
+	ctx := context.Background()
+	store := storer.NewMemoryStorage()
+	calc := pricing.NewCalculator(config.PricingConfig{})
+	calc.SetShipping(shipping.Zones{Zones: []shipping.Zone{{
+		Name:      "domestic",
+		Countries: []string{"US"},
+		Rate:      shipping.Tiered{By: shipping.ByWeight, Tiers: []shipping.Tier{{UpTo: 5, Amount: 4}, {Amount: 9}}},
+	}}})
+	srv := server.NewServer(store, calc, nil)
+
+	// 40x30x20 cm ships as 4.8 kg
+	box, _ := store.CreateProduct(ctx, &storer.Product{Name: "Box", Price: 20, CountInStock: 10, IsActive: true, Weight: 1, Length: 40, Width: 30, Height: 20})
+	items := func(qty int) []storer.OrderItem {
+		return []storer.OrderItem{{ProductID: box.ID, Quantity: qty}}
+	}
+
+	for qty, want := range map[int]float64{1: 4, 2: 9} {
+		b, err := srv.QuoteShipping(ctx, items(qty), "US", "")
+		if err != nil || b.Shipping != want {
+			t.Errorf("QuoteShipping(%d boxes) = %+v, %v, want shipping %v", qty, b, err, want)
+		}
+	}
+	if _, err := srv.QuoteShipping(ctx, items(1), "NZ", ""); !errors.Is(err, shipping.ErrNoRate) {
+		t.Errorf("quote outside every zone: expected ErrNoRate, got %v", err)
+	}
+
+	userID := uint(1)
+	to := func(country string) *storer.Order {
+		return &storer.Order{
+			UserID:          &userID,
+			PaymentMethod:   "Stripe",
+			ShippingAddress: storer.ShippingAddress{Name: "Buyer", Line1: "1 Main St", City: "Springfield", PostalCode: "12345", Country: country},
+			Items:           items(2),
+		}
+	}
+	o, err := srv.CreateOrder(ctx, to("US"))
+	if err != nil || o.ShippingPrice != 9 || o.TotalPrice != 49 {
+		t.Fatalf("CreateOrder = %+v, %v", o, err)
+	}
+	if _, err := srv.CreateOrder(ctx, to("NZ")); !errors.Is(err, shipping.ErrNoRate) {
+		t.Errorf("order outside every zone: expected ErrNoRate, got %v", err)
+	}
+}
+
+func TestCreateOrderRecordsTax(t *testing.T) {
+	ctx := context.Background()
+	store := storer.NewMemoryStorage()
+	calc := pricing.NewCalculator(config.PricingConfig{TaxRate: 0.1})
+	calc.SetTax(tax.FromConfig(config.PricingConfig{TaxRate: 0.1}, config.TaxConfig{Jurisdictions: []config.TaxJurisdictionConfig{
+		{Country: "ID", Mode: "inclusive", Rates: map[string]float64{"standard": 0.11, "exempt": 0}},
+	}}))
+	srv := server.NewServer(store, calc, nil)
+
+	lamp, _ := store.CreateProduct(ctx, &storer.Product{Name: "Lamp", Price: 55.5, CountInStock: 10, IsActive: true, TaxCategory: "standard"})
+	book, _ := store.CreateProduct(ctx, &storer.Product{Name: "Book", Price: 20, CountInStock: 10, IsActive: true, TaxCategory: "exempt"})
+	userID := uint(1)
+	to := func(country string) *storer.Order {
+		return &storer.Order{
+			UserID:          &userID,
+			PaymentMethod:   "Stripe",
+			ShippingAddress: storer.ShippingAddress{Name: "Buyer", Line1: "Jl. Sudirman 1", City: "Jakarta", PostalCode: "10220", Country: country},
+			Items:           []storer.OrderItem{{ProductID: lamp.ID, Quantity: 2}, {ProductID: book.ID, Quantity: 1}},
+		}
+	}
+
+	o, err := srv.CreateOrder(ctx, to("ID"))
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if o.TaxPrice != 11 || o.TotalPrice != 131 || o.Items[0].Tax != 11 || o.Items[1].Tax != 0 {
+		t.Errorf("inclusive tax: tax %v total %v items %+v", o.TaxPrice, o.TotalPrice, o.Items)
+	}
+	if b := o.TaxBreakdown; b.Country != "ID" || !b.Inclusive || len(b.Lines) != 2 || b.Lines[0].Taxable != 100 {
+		t.Errorf("tax breakdown = %+v", b)
+	}
+
+	// without a jurisdiction the default rate is added, exempt or not
+	abroad, err := srv.CreateOrder(ctx, to("NZ"))
+	if err != nil || abroad.TaxPrice != 13.1 || abroad.TotalPrice != 144.1 || abroad.TaxBreakdown.Inclusive {
+		t.Errorf("default tax = %+v, %v", abroad, err)
+	}
+
+	// a returned lamp is refunded at its price, the tax is already in it
+	for _, status := range []storer.OrderStatus{storer.OrderPaid, storer.OrderProcessing, storer.OrderShipped, storer.OrderDelivered} {
+		if o, err = store.UpdateOrderStatus(ctx, o.ID, storer.StatusChange{To: status}); err != nil {
+			t.Fatalf("UpdateOrderStatus(%s): %v", status, err)
+		}
+	}
+	r, err := srv.RequestReturn(ctx, o, &storer.ReturnRequest{OrderItemID: o.Items[0].ID, Quantity: 1, Reason: storer.ReturnDamaged})
+	if err != nil {
+		t.Fatalf("RequestReturn: %v", err)
+	}
+	for _, status := range []storer.ReturnStatus{storer.ReturnApproved, storer.ReturnReceived} {
+		if _, err := srv.UpdateReturnStatus(ctx, r.ID, storer.ReturnChange{To: status}); err != nil {
+			t.Fatalf("UpdateReturnStatus(%s): %v", status, err)
+		}
+	}
+	srv.SetRefundExecutor(&fakeRefunds{})
+	if rf, err := srv.RefundReturn(ctx, r.ID, nil); err != nil || rf.Amount != 55.5 {
+		t.Errorf("RefundReturn = %+v, %v, want 55.5", rf, err)
+	}
+}
+
+func TestGuestOrderClaim(t *testing.T) {
+	ctx := context.Background()
+	store := storer.NewMemoryStorage()
+	srv := server.NewServer(store, pricing.NewCalculator(config.PricingConfig{ShippingFee: 5}), nil)
+
+	keyboard, _ := store.CreateProduct(ctx, &storer.Product{Name: "Keyboard", Price: 30, CountInStock: 5, IsActive: true})
+	user, _ := store.CreateUser(ctx, &storer.User{Name: "Buyer", Email: "buyer@example.com", Password: "x"})
+	address := storer.ShippingAddress{Name: "Buyer", Line1: "1 Main St", City: "Springfield", PostalCode: "12345", Country: "US"}
+
+	if _, _, err := srv.PlaceGuestOrder(ctx, &storer.Order{PaymentMethod: "PayPal", Items: []storer.OrderItem{{ProductID: keyboard.ID, Quantity: 1}}}); !errors.Is(err, server.ErrGuestEmailRequired) {
+		t.Fatalf("expected ErrGuestEmailRequired, got %v", err)
+	}
+
+	o, token, err := srv.PlaceGuestOrder(ctx, &storer.Order{
+		PaymentMethod:   "PayPal",
+		Email:           "Buyer@Example.com",
+		ShippingAddress: address,
+		Items:           []storer.OrderItem{{ProductID: keyboard.ID, Quantity: 1}},
+	})
+	if err != nil {
+		t.Fatalf("PlaceGuestOrder: %v", err)
+	}
+	if token == "" || o.UserID != nil {
+		t.Fatalf("expected an unowned order with a token, got %+v, %q", o, token)
+	}
+
+	got, err := srv.GetGuestOrder(ctx, token)
+	if err != nil || got.ID != o.ID || got.ShippingAddress != address {
+		t.Fatalf("GetGuestOrder = %+v, %v", got, err)
+	}
+	if _, err := srv.GetGuestOrder(ctx, "wrong"); !errors.Is(err, storer.ErrOrderNotFound) {
+		t.Errorf("expected ErrOrderNotFound, got %v", err)
+	}
+
+	if _, err := srv.ClaimGuestOrder(ctx, token, user.ID, "someone@example.com"); !errors.Is(err, server.ErrClaimEmailMismatch) {
+		t.Fatalf("expected ErrClaimEmailMismatch, got %v", err)
+	}
+	claimed, err := srv.ClaimGuestOrder(ctx, token, user.ID, user.Email)
+	if err != nil {
+		t.Fatalf("ClaimGuestOrder: %v", err)
+	}
+	if claimed.UserID == nil || *claimed.UserID != user.ID || claimed.AccessTokenHash != nil {
+		t.Errorf("order not claimed: %+v", claimed)
+	}
+	if _, err := srv.GetGuestOrder(ctx, token); !errors.Is(err, storer.ErrOrderNotFound) {
+		t.Errorf("claimed order still reachable by token: %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"ecom_apiv1/internal/payment"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrOrderNotPayable  = errors.New("order is not awaiting payment")
+	ErrNoPendingPayment = errors.New("order has no pending payment")
+	ErrPaymentDeclined  = errors.New("payment was declined")
+	ErrCapturePending   = errors.New("payment capture is still pending with the provider")
+	ErrCaptureMismatch  = errors.New("captured payment does not match the order")
+)
+
+// PaymentSession is a started payment and what the buyer needs to
+// authorise it with the provider.
+type PaymentSession struct {
+	Payment      *storer.Payment
+	ClientSecret string
+	ApproveURL   string
+}
+
+// StartPayment opens a payment for the order's total with the provider of
+// its payment method.
+func (s *Server) StartPayment(ctx context.Context, o *storer.Order) (*PaymentSession, error) {
+	if o.Status != storer.OrderPending {
+		return nil, ErrOrderNotPayable
+	}
+	provider, err := s.payments.Get(o.PaymentMethod)
+	if err != nil {
+		return nil, err
+	}
+	intent, err := provider.CreateIntent(ctx, payment.IntentRequest{
+		OrderID:  o.ID,
+		Amount:   payment.ToMinor(o.TotalPrice),
+		Currency: s.payments.Currency(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating payment intent: %w", err)
+	}
+	p, err := s.storer.CreatePayment(ctx, &storer.Payment{
+		OrderID:  o.ID,
+		Provider: provider.Name(),
+		IntentID: intent.ID,
+		Status:   storer.PaymentPending,
+		Amount:   o.TotalPrice,
+		Currency: s.payments.Currency(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &PaymentSession{Payment: p, ClientSecret: intent.ClientSecret, ApproveURL: intent.ApproveURL}, nil
+}
+
+// CapturePayment captures the order's latest pending payment after the
+// buyer has authorised it. The order becomes paid only when the provider
+// reports a completed capture of exactly the payment's amount and currency;
+// a capture that cannot be booked against the order is refunded.
+func (s *Server) CapturePayment(ctx context.Context, o *storer.Order) (*storer.Payment, error) {
+	p, err := s.pendingPayment(ctx, o.ID)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := s.payments.Get(p.Provider)
+	if err != nil {
+		return nil, err
+	}
+	c, err := provider.Capture(ctx, p.IntentID)
+	if err != nil {
+		return nil, fmt.Errorf("error capturing payment: %w", err)
+	}
+	switch c.Status {
+	case payment.CaptureFailed:
+		if _, err := s.storer.FailPayment(ctx, p.ID, c.FailureReason); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s", ErrPaymentDeclined, c.FailureReason)
+	case payment.CapturePending:
+		return p, ErrCapturePending
+	}
+	return s.settleCapture(ctx, provider, p, c)
+}
+
+// settleCapture books a completed capture against its pending payment and
+// marks the order paid, or refunds it when it cannot pay the order.
+func (s *Server) settleCapture(ctx context.Context, provider payment.Provider, p *storer.Payment, c *payment.Capture) (*storer.Payment, error) {
+	if err := verifyCapture(p, c); err != nil {
+		return nil, s.reverseCapture(ctx, provider, p, c, err)
+	}
+	captured, err := s.storer.CapturePayment(ctx, p.ID, c.ID)
+	if errors.Is(err, storer.ErrInvalidTransition) {
+		// the order was cancelled while the buyer was paying
+		return nil, s.reverseCapture(ctx, provider, p, c, ErrOrderNotPayable)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return captured, nil
+}
+
+func (s *Server) ListPayments(ctx context.Context, orderID uint) ([]storer.Payment, error) {
+	return s.storer.ListPayments(ctx, orderID)
+}
+
+func (s *Server) pendingPayment(ctx context.Context, orderID uint) (*storer.Payment, error) {
+	payments, err := s.storer.ListPayments(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(payments) - 1; i >= 0; i-- {
+		if payments[i].Status == storer.PaymentPending {
+			return &payments[i], nil
+		}
+	}
+	return nil, ErrNoPendingPayment
+}
+
+func verifyCapture(p *storer.Payment, c *payment.Capture) error {
+	switch {
+	case c.IntentID != p.IntentID:
+		return fmt.Errorf("%w: capture is for intent %s", ErrCaptureMismatch, c.IntentID)
+	case c.Amount != payment.ToMinor(p.Amount):
+		return fmt.Errorf("%w: captured %d, expected %d", ErrCaptureMismatch, c.Amount, payment.ToMinor(p.Amount))
+	case !strings.EqualFold(c.Currency, p.Currency):
+		return fmt.Errorf("%w: captured in %s, expected %s", ErrCaptureMismatch, c.Currency, p.Currency)
+	}
+	return nil
+}
+
+// reverseCapture marks the payment failed with cause as the reason and
+// refunds money that was captured but cannot pay the order. The payment is
+// failed first so that of two concurrent reports of the same capture only
+// one issues the refund.
+func (s *Server) reverseCapture(ctx context.Context, provider payment.Provider, p *storer.Payment, c *payment.Capture, cause error) error {
+	if _, err := s.storer.FailPayment(ctx, p.ID, cause.Error()); err != nil {
+		return fmt.Errorf("error recording failed payment: %w", err)
+	}
+	_, err := provider.Refund(ctx, payment.RefundRequest{IntentID: p.IntentID, CaptureID: c.ID, Amount: c.Amount, Currency: c.Currency})
+	if err != nil {
+		return fmt.Errorf("%w; error refunding capture %s: %w", cause, c.ID, err)
+	}
+	return cause
+}
@@ -0,0 +1,164 @@
+package server_test
+
+import (
+	"context"
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/payment"
+	"ecom_apiv1/internal/payment/paymenttest"
+	"ecom_apiv1/internal/pricing"
+	"ecom_apiv1/internal/server"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestPaymentCaptureMarksOrderPaid(t *testing.T) {
+	ctx := context.Background()
+	fake := paymenttest.NewServer(t)
+	store := storer.NewMemoryStorage()
+	payments := payment.NewRegistry("USD",
+		payment.NewStripe(fake.StripeConfig(), http.DefaultClient),
+		payment.NewPayPal(fake.PayPalConfig(), http.DefaultClient))
+	srv := server.NewServer(store, pricing.NewCalculator(config.PricingConfig{ShippingFee: 5}), payments)
+
+	p, _ := store.CreateProduct(ctx, &storer.Product{Name: "Desk", Price: 120, CountInStock: 10, IsActive: true})
+	userID := uint(1)
+	newOrder := func(method string) *storer.Order {
+		o, err := srv.CreateOrder(ctx, &storer.Order{UserID: &userID, PaymentMethod: method, Items: []storer.OrderItem{{ProductID: p.ID, Quantity: 1}}})
+		if err != nil {
+			t.Fatalf("CreateOrder: %v", err)
+		}
+		return o
+	}
+
+	for _, method := range []string{"Stripe", "PayPal"} {
+		o := newOrder(method)
+		session, err := srv.StartPayment(ctx, o)
+		if err != nil {
+			t.Fatalf("%s StartPayment: %v", method, err)
+		}
+		if session.Payment.Amount != 125 || session.Payment.Status != storer.PaymentPending {
+			t.Errorf("%s payment = %+v", method, session.Payment)
+		}
+		if _, err := srv.CapturePayment(ctx, o); err == nil {
+			t.Errorf("%s: capture before the buyer approved succeeded", method)
+		}
+		if got, _ := store.GetOrderByID(ctx, o.ID); got.Status != storer.OrderPending {
+			t.Errorf("%s: order status = %s before capture", method, got.Status)
+		}
+
+		fake.Approve(session.Payment.IntentID)
+		captured, err := srv.CapturePayment(ctx, o)
+		if err != nil {
+			t.Fatalf("%s CapturePayment: %v", method, err)
+		}
+		if captured.Status != storer.PaymentCaptured || captured.CaptureID == "" {
+			t.Errorf("%s captured payment = %+v", method, captured)
+		}
+		paid, _ := store.GetOrderByID(ctx, o.ID)
+		if paid.Status != storer.OrderPaid {
+			t.Errorf("%s: order status = %s after capture, want paid", method, paid.Status)
+		}
+		if _, err := srv.StartPayment(ctx, paid); !errors.Is(err, server.ErrOrderNotPayable) {
+			t.Errorf("%s: expected ErrOrderNotPayable for a paid order, got %v", method, err)
+		}
+	}
+
+	t.Run("amount mismatch is refunded", func(t *testing.T) {
+		o := newOrder("Stripe")
+		session, err := srv.StartPayment(ctx, o)
+		if err != nil {
+			t.Fatalf("StartPayment: %v", err)
+		}
+		fake.Approve(session.Payment.IntentID)
+		fake.SetCaptureAmount(session.Payment.IntentID, 100)
+		if _, err := srv.CapturePayment(ctx, o); !errors.Is(err, server.ErrCaptureMismatch) {
+			t.Fatalf("expected ErrCaptureMismatch, got %v", err)
+		}
+		if got, _ := store.GetOrderByID(ctx, o.ID); got.Status != storer.OrderPending {
+			t.Errorf("order status = %s after a mismatched capture", got.Status)
+		}
+		if refunded := fake.Refunded(session.Payment.IntentID); refunded != 100 {
+			t.Errorf("refunded %d, want the 100 captured", refunded)
+		}
+		got, _ := store.GetPayment(ctx, session.Payment.ID)
+		if got.Status != storer.PaymentFailed {
+			t.Errorf("payment status = %s, want failed", got.Status)
+		}
+	})
+
+	t.Run("declined", func(t *testing.T) {
+		o := newOrder("PayPal")
+		session, err := srv.StartPayment(ctx, o)
+		if err != nil {
+			t.Fatalf("StartPayment: %v", err)
+		}
+		fake.Decline(session.Payment.IntentID)
+		if _, err := srv.CapturePayment(ctx, o); !errors.Is(err, server.ErrPaymentDeclined) {
+			t.Fatalf("expected ErrPaymentDeclined, got %v", err)
+		}
+		if _, err := srv.CapturePayment(ctx, o); !errors.Is(err, server.ErrNoPendingPayment) {
+			t.Errorf("expected ErrNoPendingPayment after the decline, got %v", err)
+		}
+	})
+
+	t.Run("cancelling a paid order refunds it", func(t *testing.T) {
+		for _, to := range []storer.OrderStatus{storer.OrderCancelled, storer.OrderRefunded} {
+			o := newOrder("Stripe")
+			session, err := srv.StartPayment(ctx, o)
+			if err != nil {
+				t.Fatalf("StartPayment: %v", err)
+			}
+			fake.Approve(session.Payment.IntentID)
+			if _, err := srv.CapturePayment(ctx, o); err != nil {
+				t.Fatalf("CapturePayment: %v", err)
+			}
+			adminID := uint(99)
+			got, err := srv.UpdateOrderStatus(ctx, o.ID, storer.StatusChange{To: to, ChangedBy: &adminID})
+			if err != nil {
+				t.Fatalf("UpdateOrderStatus(%s): %v", to, err)
+			}
+			if got.Status != storer.OrderRefunded || got.RefundedAmount != 125 {
+				t.Errorf("order after %s = %s, refunded %v, want refunded 125", to, got.Status, got.RefundedAmount)
+			}
+			if refunded := fake.Refunded(session.Payment.IntentID); refunded != 12500 {
+				t.Errorf("provider refunded %d, want 12500", refunded)
+			}
+			if pay, _ := store.GetPayment(ctx, session.Payment.ID); pay.Status != storer.PaymentRefunded {
+				t.Errorf("payment status = %s after %s, want refunded", pay.Status, to)
+			}
+			refunds, _ := srv.ListRefunds(ctx, o.ID)
+			if len(refunds) != 1 || refunds[0].Status != storer.RefundSucceeded || refunds[0].CreatedBy == nil || *refunds[0].CreatedBy != adminID {
+				t.Errorf("refunds = %+v", refunds)
+			}
+			if _, err := store.UpdateOrderStatus(ctx, o.ID, storer.StatusChange{To: storer.OrderCancelled}); !errors.Is(err, storer.ErrInvalidTransition) {
+				t.Errorf("refunded order cancelled: %v", err)
+			}
+		}
+
+		o := newOrder("Stripe")
+		session, _ := srv.StartPayment(ctx, o)
+		fake.Approve(session.Payment.IntentID)
+		if _, err := srv.CapturePayment(ctx, o); err != nil {
+			t.Fatalf("CapturePayment: %v", err)
+		}
+		if _, err := store.UpdateOrderStatus(ctx, o.ID, storer.StatusChange{To: storer.OrderCancelled}); !errors.Is(err, storer.ErrInvalidTransition) {
+			t.Errorf("paid order cancelled without a refund: %v", err)
+		}
+		if pay, _ := store.GetPayment(ctx, session.Payment.ID); pay.Status != storer.PaymentCaptured {
+			t.Errorf("payment status = %s", pay.Status)
+		}
+	})
+
+	t.Run("paid only through a capture", func(t *testing.T) {
+		o := newOrder("Stripe")
+		if _, err := srv.UpdateOrderStatus(ctx, o.ID, storer.StatusChange{To: storer.OrderPaid}); !errors.Is(err, storer.ErrInvalidTransition) {
+			t.Errorf("expected ErrInvalidTransition, got %v", err)
+		}
+		cod := newOrder("Cash")
+		if _, err := srv.StartPayment(ctx, cod); !errors.Is(err, payment.ErrProviderNotConfigured) {
+			t.Errorf("expected ErrProviderNotConfigured, got %v", err)
+		}
+	})
+}
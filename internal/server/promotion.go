@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"ecom_apiv1/internal/pricing"
+	"ecom_apiv1/internal/promotion"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"time"
+)
+
+var ErrUnknownCoupon = errors.New("coupon code not found")
+
+// CreatePromotion checks p and stores it. Its usage count starts at zero.
+func (s *Server) CreatePromotion(ctx context.Context, p *storer.Promotion) (*storer.Promotion, error) {
+	if err := promotion.Validate(p); err != nil {
+		return nil, err
+	}
+	p.UsedCount = 0
+	return s.storer.CreatePromotion(ctx, p)
+}
+
+func (s *Server) GetPromotion(ctx context.Context, id uint) (*storer.Promotion, error) {
+	return s.storer.GetPromotion(ctx, id)
+}
+
+func (s *Server) ListPromotions(ctx context.Context) ([]storer.Promotion, error) {
+	return s.storer.ListPromotions(ctx)
+}
+
+// UpdatePromotion checks p and replaces the rules of the stored promotion
+// with it. Orders already placed keep the discounts they got.
+func (s *Server) UpdatePromotion(ctx context.Context, p *storer.Promotion) (*storer.Promotion, error) {
+	if err := promotion.Validate(p); err != nil {
+		return nil, err
+	}
+	return s.storer.UpdatePromotion(ctx, p)
+}
+
+func (s *Server) DeletePromotion(ctx context.Context, id uint) error {
+	return s.storer.DeletePromotion(ctx, id)
+}
+
+// promotionsFor picks the promotions lines get: every automatic promotion
+// they qualify for, then the coupon, if any. A coupon that does not apply
+// fails with the reason; automatic promotions that do not are left out.
+// The per-user limits are only checked when the buyer is known.
+func (s *Server) promotionsFor(ctx context.Context, lines []promotion.Line, buyer storer.Redeemer, coupon string) ([]storer.Promotion, error) {
+	now := time.Now()
+	all, err := s.storer.ListPromotions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var picked []storer.Promotion
+	for _, p := range all {
+		if p.Code != nil || promotion.Check(&p, lines, now) != nil {
+			continue
+		}
+		if ok, err := s.underUserLimit(ctx, &p, buyer); err != nil {
+			return nil, err
+		} else if ok {
+			picked = append(picked, p)
+		}
+	}
+
+	if coupon = promotion.NormalizeCode(coupon); coupon == "" {
+		return picked, nil
+	}
+	p, err := s.storer.GetPromotionByCode(ctx, coupon)
+	if errors.Is(err, storer.ErrPromotionNotFound) {
+		return nil, ErrUnknownCoupon
+	} else if err != nil {
+		return nil, err
+	}
+	if err := promotion.Check(p, lines, now); err != nil {
+		return nil, err
+	}
+	if ok, err := s.underUserLimit(ctx, p, buyer); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, storer.ErrPromotionUserLimit
+	}
+	return append(picked, *p), nil
+}
+
+func (s *Server) underUserLimit(ctx context.Context, p *storer.Promotion, buyer storer.Redeemer) (bool, error) {
+	if p.PerUserLimit == 0 || buyer.UserID == nil && buyer.Email == "" {
+		return true, nil
+	}
+	n, err := s.storer.CountRedemptions(ctx, p.ID, buyer)
+	if err != nil {
+		return false, err
+	}
+	return n < p.PerUserLimit, nil
+}
+
+// priceWithPromotions applies the promotions of the buyer and the coupon
+// to lines and prices them shipped to country. It returns the discounts
+// applied, a free shipping one carrying the shipping it waived.
+func (s *Server) priceWithPromotions(ctx context.Context, lines []pricing.Line, promoLines []promotion.Line, country string, buyer storer.Redeemer, coupon string) (pricing.Breakdown, promotion.Result, error) {
+	promotions, err := s.promotionsFor(ctx, promoLines, buyer, coupon)
+	if err != nil {
+		return pricing.Breakdown{}, promotion.Result{}, err
+	}
+	res := promotion.Apply(promotions, promoLines)
+	for i := range lines {
+		lines[i].Discount = res.Lines[i]
+	}
+	b, err := s.pricing.Price(lines, country)
+	if err != nil {
+		return pricing.Breakdown{}, promotion.Result{}, err
+	}
+	if res.FreeShipping {
+		b.WaiveShipping()
+		for i := range res.Discounts {
+			if res.Discounts[i].Kind == storer.PromotionFreeShipping {
+				res.Discounts[i].Amount = b.ShippingDiscount
+				res.Total = pricing.Round(res.Total + b.ShippingDiscount)
+				break
+			}
+		}
+	}
+	return b, res, nil
+}
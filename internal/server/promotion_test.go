@@ -0,0 +1,72 @@
+package server_test
+
+import (
+	"context"
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/pricing"
+	"ecom_apiv1/internal/promotion"
+	"ecom_apiv1/internal/server"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"testing"
+)
+
+func TestCreateOrderAppliesPromotions(t *testing.T) {
+	ctx := context.Background()
+	store := storer.NewMemoryStorage()
+	srv := server.NewServer(store, pricing.NewCalculator(config.PricingConfig{TaxRate: 0.1, ShippingFee: 5, FreeShippingOver: 100}), nil)
+
+	shirt, _ := store.CreateProduct(ctx, &storer.Product{Name: "Shirt", Category: "apparel", Price: 20, CountInStock: 10, IsActive: true})
+	mug, _ := store.CreateProduct(ctx, &storer.Product{Name: "Mug", Category: "kitchen", Price: 10, CountInStock: 10, IsActive: true})
+	code := func(s string) *string { return &s }
+	promotions := []*storer.Promotion{
+		{Name: "Third shirt free", Kind: storer.PromotionBuyXGetY, Value: 100, BuyQuantity: 2, GetQuantity: 1, Scope: storer.PromotionScope{Categories: []string{"apparel"}}, IsActive: true},
+		{Name: "Ten off", Code: code("save10"), Kind: storer.PromotionPercent, Value: 10, MinOrder: 50, PerUserLimit: 1, IsActive: true},
+		{Name: "Free shipping", Code: code("SHIPFREE"), Kind: storer.PromotionFreeShipping, IsActive: true},
+	}
+	for _, p := range promotions {
+		if _, err := srv.CreatePromotion(ctx, p); err != nil {
+			t.Fatalf("CreatePromotion(%s): %v", p.Name, err)
+		}
+	}
+
+	alice, bob := uint(1), uint(2)
+	order := func(userID *uint, coupon string, items ...storer.OrderItem) (*storer.Order, error) {
+		return srv.CreateOrder(ctx, &storer.Order{UserID: userID, PaymentMethod: "Stripe", CouponCode: coupon, Items: items})
+	}
+
+	// the third shirt is free, then ten percent comes off what is left
+	o, err := order(&alice, " Save10 ", storer.OrderItem{ProductID: shirt.ID, Quantity: 3}, storer.OrderItem{ProductID: mug.ID, Quantity: 1})
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if o.ItemsPrice != 70 || o.DiscountPrice != 25 || o.TaxPrice != 4.5 || o.ShippingPrice != 5 || o.TotalPrice != 54.5 {
+		t.Errorf("pricing: items %v discount %v tax %v shipping %v total %v", o.ItemsPrice, o.DiscountPrice, o.TaxPrice, o.ShippingPrice, o.TotalPrice)
+	}
+	if o.Items[0].Discount != 24 || o.Items[1].Discount != 1 {
+		t.Errorf("item discounts = %v, %v, want 24 and 1", o.Items[0].Discount, o.Items[1].Discount)
+	}
+	if len(o.Discounts) != 2 || o.Discounts[0].Amount != 20 || o.Discounts[1].Code != "SAVE10" || o.Discounts[1].Amount != 5 {
+		t.Errorf("discounts = %+v", o.Discounts)
+	}
+
+	if _, err := order(&alice, "SAVE10", storer.OrderItem{ProductID: shirt.ID, Quantity: 3}); !errors.Is(err, storer.ErrPromotionUserLimit) {
+		t.Errorf("second use by the same user: expected ErrPromotionUserLimit, got %v", err)
+	}
+	if _, err := order(&bob, "SAVE10", storer.OrderItem{ProductID: mug.ID, Quantity: 1}); !errors.Is(err, promotion.ErrMinimumNotMet) {
+		t.Errorf("below the minimum: expected ErrMinimumNotMet, got %v", err)
+	}
+	if _, err := order(&bob, "NOPE", storer.OrderItem{ProductID: mug.ID, Quantity: 1}); !errors.Is(err, server.ErrUnknownCoupon) {
+		t.Errorf("unknown code: expected ErrUnknownCoupon, got %v", err)
+	}
+
+	// the waived shipping is kept on the order as a discount
+	free, err := order(&bob, "shipfree", storer.OrderItem{ProductID: mug.ID, Quantity: 1})
+	if err != nil || free.ShippingPrice != 5 || free.DiscountPrice != 5 || free.TotalPrice != 11 || len(free.Discounts) != 1 {
+		t.Errorf("free shipping = %+v, %v", free, err)
+	}
+
+	if p, _ := store.GetPromotion(ctx, promotions[1].ID); p.UsedCount != 1 {
+		t.Errorf("SAVE10 used %d times, want 1", p.UsedCount)
+	}
+}
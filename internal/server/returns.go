@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"ecom_apiv1/internal/payment"
+	"ecom_apiv1/internal/pricing"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrOrderNotReturnable = errors.New("only delivered orders can be returned")
+	ErrOrderNotRefundable = errors.New("order has not been paid")
+	ErrNothingToRefund    = errors.New("order has nothing left to refund")
+	ErrRefundDeclined     = errors.New("refund was declined by the payment provider")
+)
+
+// RefundExecutor pays refunds back to the buyer.
+type RefundExecutor interface {
+	// ExecuteRefund pays amount of the order back and returns the refund
+	// as the provider reports it: succeeded, or pending while the provider
+	// is still paying it out. p is the order's captured payment, nil when
+	// the order was paid outside the payment providers.
+	ExecuteRefund(ctx context.Context, o *storer.Order, p *storer.Payment, amount float64) (*payment.Refund, error)
+}
+
+// ProviderRefunds refunds captured payments through their provider. Orders
+// without a captured payment, e.g. cash on delivery, are paid back by hand
+// and only recorded.
+type ProviderRefunds struct {
+	Payments *payment.Registry
+}
+
+func (pr ProviderRefunds) ExecuteRefund(ctx context.Context, o *storer.Order, p *storer.Payment, amount float64) (*payment.Refund, error) {
+	if p == nil {
+		return &payment.Refund{Status: payment.RefundSucceeded, Amount: payment.ToMinor(amount)}, nil
+	}
+	provider, err := pr.Payments.Get(p.Provider)
+	if err != nil {
+		return nil, err
+	}
+	res, err := provider.Refund(ctx, payment.RefundRequest{
+		IntentID:  p.IntentID,
+		CaptureID: p.CaptureID,
+		Amount:    payment.ToMinor(amount),
+		Currency:  p.Currency,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error refunding payment: %w", err)
+	}
+	if res.Status == payment.RefundFailed {
+		return nil, ErrRefundDeclined
+	}
+	return res, nil
+}
+
+// SetRefundExecutor replaces how refunds are paid out, ProviderRefunds by
+// default.
+func (s *Server) SetRefundExecutor(e RefundExecutor) {
+	s.refunds = e
+}
+
+// RequestReturn opens a return for part of an item of a delivered order.
+func (s *Server) RequestReturn(ctx context.Context, o *storer.Order, r *storer.ReturnRequest) (*storer.ReturnRequest, error) {
+	if o.Status != storer.OrderDelivered {
+		return nil, ErrOrderNotReturnable
+	}
+	r.OrderID = o.ID
+	return s.storer.CreateReturn(ctx, r)
+}
+
+func (s *Server) GetReturn(ctx context.Context, id uint) (*storer.ReturnRequest, error) {
+	return s.storer.GetReturn(ctx, id)
+}
+
+func (s *Server) ListReturns(ctx context.Context, orderID uint) ([]storer.ReturnRequest, error) {
+	return s.storer.ListReturns(ctx, orderID)
+}
+
+func (s *Server) UpdateReturnStatus(ctx context.Context, id uint, c storer.ReturnChange) (*storer.ReturnRequest, error) {
+	if c.To == storer.ReturnRefunded {
+		return nil, fmt.Errorf("%w: returns are marked refunded by their refund", storer.ErrInvalidReturnTransition)
+	}
+	return s.storer.UpdateReturnStatus(ctx, id, c)
+}
+
+// RefundReturn pays back a received return: the price of the returned
+// items less their share of the discounts and, unless it was included in
+// the price, their share of the tax.
+func (s *Server) RefundReturn(ctx context.Context, id uint, by *uint) (*storer.Refund, error) {
+	r, err := s.storer.GetReturn(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	o, err := s.storer.GetOrderByID(ctx, r.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	var value float64
+	for _, item := range o.Items {
+		if item.ID != r.OrderItemID {
+			continue
+		}
+		value = item.Price*float64(r.Quantity) - item.Discount*float64(r.Quantity)/float64(item.Quantity)
+		switch {
+		case o.TaxBreakdown.Inclusive:
+		case o.TaxBreakdown.IsZero():
+			// placed before tax was kept per item
+			if o.ItemsPrice > 0 {
+				value += o.TaxPrice * value / o.ItemsPrice
+			}
+		default:
+			value += item.Tax * float64(r.Quantity) / float64(item.Quantity)
+		}
+	}
+	return s.refund(ctx, o, &storer.Refund{
+		ReturnID:  &r.ID,
+		Amount:    pricing.Round(value),
+		Reason:    fmt.Sprintf("return %d: %s", r.ID, r.Reason),
+		CreatedBy: by,
+	})
+}
+
+// RefundOrder pays back amount of a paid order, or all that is left to
+// refund when amount is zero.
+func (s *Server) RefundOrder(ctx context.Context, o *storer.Order, amount float64, reason string, by *uint) (*storer.Refund, error) {
+	switch o.Status {
+	case storer.OrderPaid, storer.OrderProcessing, storer.OrderShipped, storer.OrderDelivered:
+	default:
+		return nil, ErrOrderNotRefundable
+	}
+	if amount == 0 {
+		amount = o.TotalPrice - o.RefundedAmount
+	}
+	amount = pricing.Round(amount)
+	if amount <= 0 {
+		return nil, ErrNothingToRefund
+	}
+	return s.refund(ctx, o, &storer.Refund{Amount: amount, Reason: reason, CreatedBy: by})
+}
+
+func (s *Server) ListRefunds(ctx context.Context, orderID uint) ([]storer.Refund, error) {
+	return s.storer.ListRefunds(ctx, orderID)
+}
+
+// refund records rf against the order before paying it out, so that the
+// order's refunded amount guards against refunding it twice, and settles
+// the record with the outcome. A refund the provider is still paying out
+// stays pending under the provider's ID until its refund webhook settles
+// it.
+func (s *Server) refund(ctx context.Context, o *storer.Order, rf *storer.Refund) (*storer.Refund, error) {
+	p, err := s.capturedPayment(ctx, o.ID)
+	if err != nil {
+		return nil, err
+	}
+	rf.OrderID = o.ID
+	if p != nil {
+		rf.PaymentID = &p.ID
+	}
+	rf, err = s.storer.CreateRefund(ctx, rf)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.refunds.ExecuteRefund(ctx, o, p, rf.Amount)
+	if err != nil {
+		if _, ferr := s.storer.FailRefund(ctx, rf.ID, err.Error()); ferr != nil {
+			return nil, fmt.Errorf("%w; error recording failed refund: %w", err, ferr)
+		}
+		return nil, err
+	}
+	if res.Status == payment.RefundPending {
+		return s.storer.SubmitRefund(ctx, rf.ID, res.ID)
+	}
+	return s.storer.CompleteRefund(ctx, rf.ID, res.ID)
+}
+
+// capturedPayment is the payment that paid the order, nil if there is none.
+func (s *Server) capturedPayment(ctx context.Context, orderID uint) (*storer.Payment, error) {
+	payments, err := s.storer.ListPayments(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(payments) - 1; i >= 0; i-- {
+		if payments[i].Status == storer.PaymentCaptured {
+			return &payments[i], nil
+		}
+	}
+	return nil, nil
+}
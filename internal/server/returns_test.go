@@ -0,0 +1,107 @@
+package server_test
+
+import (
+	"context"
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/payment"
+	"ecom_apiv1/internal/pricing"
+	"ecom_apiv1/internal/server"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// fakeRefunds records the refunds paid out, declines them while declining
+// is set and leaves them pending at the provider while pending is set.
+type fakeRefunds struct {
+	paid      []float64
+	declining bool
+	pending   bool
+}
+
+func (f *fakeRefunds) ExecuteRefund(ctx context.Context, o *storer.Order, p *storer.Payment, amount float64) (*payment.Refund, error) {
+	if f.declining {
+		return nil, server.ErrRefundDeclined
+	}
+	f.paid = append(f.paid, amount)
+	res := &payment.Refund{ID: fmt.Sprintf("re_%d", len(f.paid)), Status: payment.RefundSucceeded, Amount: payment.ToMinor(amount)}
+	if f.pending {
+		res.Status = payment.RefundPending
+	}
+	return res, nil
+}
+
+func TestReturnsAndRefunds(t *testing.T) {
+	ctx := context.Background()
+	store := storer.NewMemoryStorage()
+	srv := server.NewServer(store, pricing.NewCalculator(config.PricingConfig{TaxRate: 0.1}), nil)
+	refunds := &fakeRefunds{}
+	srv.SetRefundExecutor(refunds)
+
+	p, _ := store.CreateProduct(ctx, &storer.Product{Name: "Chair", Price: 40, CountInStock: 10, IsActive: true})
+	userID := uint(1)
+	o, err := srv.CreateOrder(ctx, &storer.Order{UserID: &userID, PaymentMethod: "Stripe", Items: []storer.OrderItem{{ProductID: p.ID, Quantity: 3}}})
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	item := o.Items[0].ID
+	if _, err := srv.RequestReturn(ctx, o, &storer.ReturnRequest{OrderItemID: item, Quantity: 1, Reason: storer.ReturnDamaged}); !errors.Is(err, server.ErrOrderNotReturnable) {
+		t.Errorf("return of an undelivered order: expected ErrOrderNotReturnable, got %v", err)
+	}
+	for _, status := range []storer.OrderStatus{storer.OrderPaid, storer.OrderProcessing, storer.OrderShipped, storer.OrderDelivered} {
+		if o, err = store.UpdateOrderStatus(ctx, o.ID, storer.StatusChange{To: status}); err != nil {
+			t.Fatalf("UpdateOrderStatus(%s): %v", status, err)
+		}
+	}
+
+	r, err := srv.RequestReturn(ctx, o, &storer.ReturnRequest{OrderItemID: item, Quantity: 2, Reason: storer.ReturnDamaged})
+	if err != nil {
+		t.Fatalf("RequestReturn: %v", err)
+	}
+	if _, err := srv.UpdateReturnStatus(ctx, r.ID, storer.ReturnChange{To: storer.ReturnRefunded}); !errors.Is(err, storer.ErrInvalidReturnTransition) {
+		t.Errorf("marking a return refunded by hand: expected ErrInvalidReturnTransition, got %v", err)
+	}
+	for _, status := range []storer.ReturnStatus{storer.ReturnApproved, storer.ReturnReceived} {
+		if _, err := srv.UpdateReturnStatus(ctx, r.ID, storer.ReturnChange{To: status, ChangedBy: &userID}); err != nil {
+			t.Fatalf("UpdateReturnStatus(%s): %v", status, err)
+		}
+	}
+
+	refunds.declining = true
+	if _, err := srv.RefundReturn(ctx, r.ID, &userID); !errors.Is(err, server.ErrRefundDeclined) {
+		t.Fatalf("declined refund: expected ErrRefundDeclined, got %v", err)
+	}
+	refunds.declining = false
+	// two chairs and their share of the tax
+	rf, err := srv.RefundReturn(ctx, r.ID, &userID)
+	if err != nil || rf.Amount != 88 || rf.Status != storer.RefundSucceeded || rf.ProviderRefundID != "re_1" {
+		t.Fatalf("RefundReturn = %+v, %v", rf, err)
+	}
+	if _, err := srv.RefundReturn(ctx, r.ID, &userID); !errors.Is(err, storer.ErrReturnNotRefundable) {
+		t.Errorf("refunding a return twice: expected ErrReturnNotRefundable, got %v", err)
+	}
+
+	o, _ = store.GetOrderByID(ctx, o.ID)
+	if o.RefundedAmount != 88 {
+		t.Errorf("refunded amount = %v, want 88", o.RefundedAmount)
+	}
+	rest, err := srv.RefundOrder(ctx, o, 0, "goodwill", &userID)
+	if err != nil || rest.Amount != pricing.Round(o.TotalPrice-88) {
+		t.Fatalf("RefundOrder of the rest = %+v, %v", rest, err)
+	}
+	o, _ = store.GetOrderByID(ctx, o.ID)
+	if o.Status != storer.OrderRefunded {
+		t.Errorf("order status = %s, want refunded", o.Status)
+	}
+	if _, err := srv.RefundOrder(ctx, o, 1, "", &userID); !errors.Is(err, server.ErrOrderNotRefundable) {
+		t.Errorf("refunding a refunded order: expected ErrOrderNotRefundable, got %v", err)
+	}
+	list, _ := srv.ListRefunds(ctx, o.ID)
+	if len(list) != 3 || list[0].Status != storer.RefundFailed {
+		t.Errorf("refunds = %+v, want the declined one and two succeeded", list)
+	}
+	if len(refunds.paid) != 2 {
+		t.Errorf("paid out %v, want two refunds", refunds.paid)
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidReview    = errors.New("invalid review")
+	ErrReviewNotAllowed = errors.New("only customers with a delivered order of the product can review it")
+)
+
+// ReviewError names the review field that was rejected.
+type ReviewError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ReviewError) Error() string {
+	return fmt.Sprintf("%s %s", e.Field, e.Reason)
+}
+
+func (e *ReviewError) Is(target error) bool {
+	return target == ErrInvalidReview
+}
+
+// CreateReview checks r and stores it for moderation. Only a customer who
+// had the product delivered may review it, once.
+func (s *Server) CreateReview(ctx context.Context, r *storer.Review) (*storer.Review, error) {
+	if err := validateReview(r); err != nil {
+		return nil, err
+	}
+	if _, err := s.storer.GetProduct(ctx, r.ProductID); err != nil {
+		return nil, err
+	}
+	ok, err := s.storer.HasDeliveredOrder(ctx, r.UserID, r.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrReviewNotAllowed
+	}
+	r.Status, r.ModeratedBy = storer.ReviewPending, nil
+	return s.storer.CreateReview(ctx, r)
+}
+
+func (s *Server) GetReview(ctx context.Context, id uint) (*storer.Review, error) {
+	return s.storer.GetReview(ctx, id)
+}
+
+func (s *Server) ListReviews(ctx context.Context, q storer.ReviewQuery) (*storer.Page[storer.Review], error) {
+	return s.storer.ListReviews(ctx, q)
+}
+
+// ListProductReviews lists the approved reviews of a product.
+func (s *Server) ListProductReviews(ctx context.Context, productID uint, p storer.ListParams) (*storer.Page[storer.Review], error) {
+	if _, err := s.storer.GetProduct(ctx, productID); err != nil {
+		return nil, err
+	}
+	return s.storer.ListReviews(ctx, storer.ReviewQuery{
+		ListParams:   p,
+		ReviewFilter: storer.ReviewFilter{ProductID: &productID, Status: storer.ReviewApproved},
+	})
+}
+
+// UpdateReview checks the customer's edit of a review and stores it. An
+// edited review goes back to moderation, and out of the product's rating
+// until approved again.
+func (s *Server) UpdateReview(ctx context.Context, r *storer.Review) (*storer.Review, error) {
+	if err := validateReview(r); err != nil {
+		return nil, err
+	}
+	r.Status, r.ModeratedBy = storer.ReviewPending, nil
+	return s.storer.UpdateReview(ctx, r)
+}
+
+// ModerateReview sets the status of a review on behalf of the admin
+// adminID.
+func (s *Server) ModerateReview(ctx context.Context, id uint, status storer.ReviewStatus, adminID uint) (*storer.Review, error) {
+	if !status.Valid() {
+		return nil, &ReviewError{Field: "status", Reason: fmt.Sprintf("%q is not a review status", status)}
+	}
+	r, err := s.storer.GetReview(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	r.Status, r.ModeratedBy = status, &adminID
+	return s.storer.UpdateReview(ctx, r)
+}
+
+func (s *Server) DeleteReview(ctx context.Context, id uint) error {
+	return s.storer.DeleteReview(ctx, id)
+}
+
+func validateReview(r *storer.Review) error {
+	r.Title = strings.TrimSpace(r.Title)
+	r.Body = strings.TrimSpace(r.Body)
+	switch {
+	case r.Rating < 1 || r.Rating > 5:
+		return &ReviewError{Field: "rating", Reason: "must be between 1 and 5"}
+	case utf8.RuneCountInString(r.Title) > 255:
+		return &ReviewError{Field: "title", Reason: "must be at most 255 characters"}
+	case utf8.RuneCountInString(r.Body) > 5000:
+		return &ReviewError{Field: "body", Reason: "must be at most 5000 characters"}
+	}
+	return nil
+}
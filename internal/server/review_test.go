@@ -0,0 +1,84 @@
+package server_test
+
+import (
+	"context"
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/pricing"
+	"ecom_apiv1/internal/server"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReviews(t *testing.T) {
+	ctx := context.Background()
+	store := storer.NewMemoryStorage()
+	srv := server.NewServer(store, pricing.NewCalculator(config.PricingConfig{}), nil)
+
+	p, err := srv.CreateProduct(ctx, &storer.Product{Name: "Tent", Price: 120, CountInStock: 5, IsActive: true, Rating: 5, NumReviews: 900})
+	if err != nil || p.Rating != 0 || p.NumReviews != 0 {
+		t.Fatalf("CreateProduct = %+v, %v, want no rating", p, err)
+	}
+	buyer, _ := store.CreateUser(ctx, &storer.User{Name: "Buyer", Email: "buyer@example.com", Password: "x"})
+	admin, _ := store.CreateUser(ctx, &storer.User{Name: "Admin", Email: "admin@example.com", Password: "x", IsAdmin: true})
+
+	review := &storer.Review{ProductID: p.ID, UserID: buyer.ID, Rating: 4, Title: " Roomy ", Body: "Sleeps three."}
+	o, err := srv.CreateOrder(ctx, &storer.Order{UserID: &buyer.ID, PaymentMethod: "Stripe", Items: []storer.OrderItem{{ProductID: p.ID, Quantity: 1}}})
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if _, err := srv.CreateReview(ctx, review); !errors.Is(err, server.ErrReviewNotAllowed) {
+		t.Errorf("review before delivery: expected ErrReviewNotAllowed, got %v", err)
+	}
+	for _, status := range []storer.OrderStatus{storer.OrderPaid, storer.OrderProcessing, storer.OrderShipped, storer.OrderDelivered} {
+		if _, err := store.UpdateOrderStatus(ctx, o.ID, storer.StatusChange{To: status}); err != nil {
+			t.Fatalf("UpdateOrderStatus(%s): %v", status, err)
+		}
+	}
+	for _, bad := range []*storer.Review{
+		{ProductID: p.ID, UserID: buyer.ID, Rating: 6},
+		{ProductID: p.ID, UserID: buyer.ID, Rating: 3, Title: strings.Repeat("a", 256)},
+	} {
+		if _, err := srv.CreateReview(ctx, bad); !errors.Is(err, server.ErrInvalidReview) {
+			t.Errorf("CreateReview(%+v): expected ErrInvalidReview, got %v", bad, err)
+		}
+	}
+	if _, err := srv.CreateReview(ctx, &storer.Review{ProductID: 9999, UserID: buyer.ID, Rating: 3}); !errors.Is(err, storer.ErrProductNotFound) {
+		t.Errorf("expected ErrProductNotFound, got %v", err)
+	}
+
+	// a customer cannot approve their own review
+	review.Status = storer.ReviewApproved
+	created, err := srv.CreateReview(ctx, review)
+	if err != nil || created.Status != storer.ReviewPending || created.Title != "Roomy" {
+		t.Fatalf("CreateReview = %+v, %v", created, err)
+	}
+	if page, _ := srv.ListProductReviews(ctx, p.ID, storer.ListParams{}); page.Total != 0 {
+		t.Errorf("pending reviews listed: %+v", page.Items)
+	}
+
+	if _, err := srv.ModerateReview(ctx, created.ID, "spam", admin.ID); !errors.Is(err, server.ErrInvalidReview) {
+		t.Errorf("unknown status: expected ErrInvalidReview, got %v", err)
+	}
+	approved, err := srv.ModerateReview(ctx, created.ID, storer.ReviewApproved, admin.ID)
+	if err != nil || approved.ModeratedBy == nil || *approved.ModeratedBy != admin.ID {
+		t.Fatalf("ModerateReview = %+v, %v", approved, err)
+	}
+	if got, _ := srv.GetProduct(ctx, p.ID); got.Rating != 4 || got.NumReviews != 1 {
+		t.Errorf("rating = %v / %d, want 4 / 1", got.Rating, got.NumReviews)
+	}
+	if page, _ := srv.ListProductReviews(ctx, p.ID, storer.ListParams{}); page.Total != 1 {
+		t.Errorf("approved reviews = %+v, want the review", page.Items)
+	}
+
+	// editing sends the review back to moderation
+	approved.Rating = 1
+	edited, err := srv.UpdateReview(ctx, approved)
+	if err != nil || edited.Status != storer.ReviewPending || edited.ModeratedBy != nil {
+		t.Fatalf("UpdateReview = %+v, %v", edited, err)
+	}
+	if got, _ := srv.GetProduct(ctx, p.ID); got.Rating != 0 || got.NumReviews != 0 {
+		t.Errorf("rating after the edit = %v / %d, want 0 / 0", got.Rating, got.NumReviews)
+	}
+}
@@ -1,21 +1,50 @@
 package server
 
 import (
+	"cmp"
 	"context"
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/address"
+	"ecom_apiv1/internal/blob"
+	"ecom_apiv1/internal/payment"
+	"ecom_apiv1/internal/pricing"
 	"ecom_apiv1/internal/storer"
+	"fmt"
 )
 
 type Server struct {
-	storer *storer.GORMStorage
+	storer    storer.Store
+	pricing   *pricing.Calculator
+	payments  *payment.Registry
+	refunds   RefundExecutor
+	addresses *address.Validator
+	blobs     blob.Store
+	imageCfg  config.ImageConfig
 }
 
-func NewServer(storer *storer.GORMStorage) *Server {
+func NewServer(storer storer.Store, pricing *pricing.Calculator, payments *payment.Registry) *Server {
 	return &Server{
-		storer: storer,
+		storer:    storer,
+		pricing:   pricing,
+		payments:  payments,
+		refunds:   ProviderRefunds{Payments: payments},
+		addresses: address.NewValidator(config.AddressConfig{}),
 	}
 }
 
 func (s *Server) CreateProduct(ctx context.Context, p *storer.Product) (*storer.Product, error) {
+	if err := validateOptions(p); err != nil {
+		return nil, err
+	}
+	if err := s.fileProduct(ctx, p); err != nil {
+		return nil, err
+	}
+	// the stock of a product sold by variant comes from its variants
+	if len(p.Options) > 0 {
+		p.CountInStock = 0
+	}
+	// a new product has no reviews yet
+	p.Rating, p.NumReviews = 0, 0
 	return s.storer.CreateProduct(ctx, p)
 }
 
@@ -23,28 +52,89 @@ func (s *Server) GetProduct(ctx context.Context, id uint) (*storer.Product, erro
 	return s.storer.GetProduct(ctx, id)
 }
 
-func (s *Server) ListProducts(ctx context.Context) ([]storer.Product, error) {
-	return s.storer.ListProducts(ctx)
+// ListProducts lists the products matching q. A category filter takes in
+// the subcategories.
+func (s *Server) ListProducts(ctx context.Context, q storer.ProductQuery) (*storer.Page[storer.Product], error) {
+	if err := s.resolveCategoryFilter(ctx, &q.ProductFilter); err != nil {
+		return nil, err
+	}
+	return s.storer.ListProducts(ctx, q)
 }
 
-func (s *Server) UpdateProduct(ctx context.Context, p *storer.Product) (*storer.Product, error) {
-	return s.storer.UpdateProduct(ctx, p)
+func (s *Server) SearchProducts(ctx context.Context, q storer.ProductSearchQuery) (*storer.SearchResult, error) {
+	return s.storer.SearchProducts(ctx, q)
 }
 
-func (s *Server) DeleteProduct(ctx context.Context, id uint) error {
-	return s.storer.DeleteProduct(ctx, id)
+func (s *Server) GetProductFacets(ctx context.Context, f storer.ProductFilter) (*storer.ProductFacets, error) {
+	if err := s.resolveCategoryFilter(ctx, &f); err != nil {
+		return nil, err
+	}
+	return s.storer.GetProductFacets(ctx, f)
 }
 
-func (s *Server) CreateOrder(ctx context.Context, o *storer.Order) (*storer.Order, error) {
-	return s.storer.CreateOrder(ctx, o)
+func (s *Server) UpdateProduct(ctx context.Context, p *storer.Product) (*storer.Product, error) {
+	if err := s.checkProductOptions(ctx, p); err != nil {
+		return nil, err
+	}
+	if err := s.fileProduct(ctx, p); err != nil {
+		return nil, err
+	}
+	return s.storer.UpdateProduct(ctx, p)
 }
 
-func (s *Server) GetOrder(ctx context.Context, userID uint) (*storer.Order, error) {
-	return s.storer.GetOrder(ctx, userID)
+// DeleteProduct removes the product along with its images and their files.
+func (s *Server) DeleteProduct(ctx context.Context, id uint) error {
+	images, err := s.storer.ListProductImages(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.storer.DeleteProduct(ctx, id); err != nil {
+		return err
+	}
+	for _, img := range images {
+		s.deleteBlobs(ctx, img)
+	}
+	return nil
+}
+
+func (s *Server) ListOrders(ctx context.Context, q storer.OrderQuery) (*storer.Page[storer.Order], error) {
+	return s.storer.ListOrders(ctx, q)
+}
+
+func (s *Server) GetOrderByID(ctx context.Context, id uint) (*storer.Order, error) {
+	return s.storer.GetOrderByID(ctx, id)
+}
+
+// UpdateOrderStatus applies a manual status change. Orders only become paid
+// through CapturePayment. Refunding a paid order, or cancelling one that
+// has not shipped yet, pays back what is left of it through RefundOrder,
+// and the full refund moves the order to refunded. Shipped and delivered
+// orders cannot be cancelled; they are refunded explicitly.
+func (s *Server) UpdateOrderStatus(ctx context.Context, id uint, c storer.StatusChange) (*storer.Order, error) {
+	switch c.To {
+	case storer.OrderPaid:
+		return nil, fmt.Errorf("%w: orders are marked paid by a captured payment", storer.ErrInvalidTransition)
+	case storer.OrderCancelled, storer.OrderRefunded:
+		o, err := s.storer.GetOrderByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if c.To == storer.OrderCancelled && !o.Status.HoldsStock() {
+			return nil, fmt.Errorf("%w: %s orders are refunded, not cancelled", storer.ErrInvalidTransition, o.Status)
+		}
+		if o.Status.CanTransitionTo(storer.OrderRefunded) {
+			reason := cmp.Or(c.Note, fmt.Sprintf("order %s", c.To))
+			if _, err := s.RefundOrder(ctx, o, 0, reason, c.ChangedBy); err != nil {
+				return nil, err
+			}
+			return s.storer.GetOrderByID(ctx, id)
+		}
+	}
+	return s.storer.UpdateOrderStatus(ctx, id, c)
 }
 
-func (s *Server) ListOrders(ctx context.Context) ([]storer.Order, error) {
-	return s.storer.ListOrders(ctx)
+func (s *Server) ListOrderStatusEvents(ctx context.Context, orderID uint) ([]storer.OrderStatusEvent, error) {
+	return s.storer.ListOrderStatusEvents(ctx, orderID)
 }
 
 func (s *Server) DeleteOrder(ctx context.Context, id uint) error {
@@ -59,8 +149,8 @@ func (s *Server) GetUser(ctx context.Context, email string) (*storer.User, error
 	return s.storer.GetUser(ctx, email)
 }
 
-func (s *Server) ListUsers(ctx context.Context) ([]storer.User, error) {
-	return s.storer.ListUsers(ctx)
+func (s *Server) ListUsers(ctx context.Context, q storer.UserQuery) (*storer.Page[storer.User], error) {
+	return s.storer.ListUsers(ctx, q)
 }
 
 func (s *Server) UpdateUser(ctx context.Context, u *storer.User) (*storer.User, error) {
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+const (
+	ReasonVariantRequired = "a variant must be chosen"
+	ReasonVariantNotFound = "variant not found"
+	ReasonVariantInactive = "variant is not available"
+)
+
+var (
+	ErrInvalidVariant  = errors.New("invalid variant")
+	ErrVariantRequired = errors.New("product is sold by variant")
+)
+
+// VariantError names the product or variant field that was rejected.
+type VariantError struct {
+	Field  string
+	Reason string
+}
+
+func (e *VariantError) Error() string {
+	return fmt.Sprintf("%s %s", e.Field, e.Reason)
+}
+
+func (e *VariantError) Is(target error) bool {
+	return target == ErrInvalidVariant
+}
+
+// ProductWithVariants is a product with its variant matrix and images.
+type ProductWithVariants struct {
+	*storer.Product
+	Variants []storer.ProductVariant
+	Images   []storer.ProductImage
+}
+
+// GetProductWithVariants returns the product with every variant and image
+// of it.
+func (s *Server) GetProductWithVariants(ctx context.Context, id uint) (*ProductWithVariants, error) {
+	p, err := s.storer.GetProduct(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	variants, err := s.storer.ListVariants(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	images, err := s.storer.ListProductImages(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &ProductWithVariants{Product: p, Variants: variants, Images: images}, nil
+}
+
+func (s *Server) ListVariants(ctx context.Context, productID uint) ([]storer.ProductVariant, error) {
+	if _, err := s.storer.GetProduct(ctx, productID); err != nil {
+		return nil, err
+	}
+	return s.storer.ListVariants(ctx, productID)
+}
+
+// CreateVariant checks v against the options of its product and stores it.
+// The stock of the product grows by the stock of the variant.
+func (s *Server) CreateVariant(ctx context.Context, v *storer.ProductVariant) (*storer.ProductVariant, error) {
+	p, err := s.storer.GetProduct(ctx, v.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	variants, err := s.storer.ListVariants(ctx, p.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateVariant(v, p, variants); err != nil {
+		return nil, err
+	}
+	return s.storer.CreateVariant(ctx, v)
+}
+
+// UpdateVariant replaces the variant v.ID of product v.ProductID with v.
+func (s *Server) UpdateVariant(ctx context.Context, v *storer.ProductVariant) (*storer.ProductVariant, error) {
+	current, err := s.productVariant(ctx, v.ProductID, v.ID)
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.storer.GetProduct(ctx, current.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	variants, err := s.storer.ListVariants(ctx, p.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateVariant(v, p, variants); err != nil {
+		return nil, err
+	}
+	return s.storer.UpdateVariant(ctx, v)
+}
+
+// DeleteVariant removes a variant of the product once no open order holds
+// its stock. Orders placed for it keep its SKU.
+func (s *Server) DeleteVariant(ctx context.Context, productID, id uint) error {
+	if _, err := s.productVariant(ctx, productID, id); err != nil {
+		return err
+	}
+	return s.storer.DeleteVariant(ctx, id)
+}
+
+// productVariant gets the variant, failing with storer.ErrVariantNotFound
+// when it belongs to another product.
+func (s *Server) productVariant(ctx context.Context, productID, id uint) (*storer.ProductVariant, error) {
+	v, err := s.storer.GetVariant(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if v.ProductID != productID {
+		return nil, storer.ErrVariantNotFound
+	}
+	return v, nil
+}
+
+// validateOptions trims the option names and values of p and checks none
+// of them is empty or repeated.
+func validateOptions(p *storer.Product) error {
+	names := make([]string, 0, len(p.Options))
+	for i := range p.Options {
+		o := &p.Options[i]
+		o.Name = strings.TrimSpace(o.Name)
+		switch {
+		case o.Name == "":
+			return &VariantError{Field: "options", Reason: "need a name"}
+		case slices.Contains(names, o.Name):
+			return &VariantError{Field: "options", Reason: fmt.Sprintf("name %q more than once", o.Name)}
+		case len(o.Values) == 0:
+			return &VariantError{Field: "options", Reason: fmt.Sprintf("%q has no values", o.Name)}
+		}
+		names = append(names, o.Name)
+		for j := range o.Values {
+			o.Values[j] = strings.TrimSpace(o.Values[j])
+			if o.Values[j] == "" || slices.Contains(o.Values[:j], o.Values[j]) {
+				return &VariantError{Field: "options", Reason: fmt.Sprintf("%q has an empty or repeated value", o.Name)}
+			}
+		}
+	}
+	return nil
+}
+
+// fitsOptions reports whether the variant options give every option of the
+// product one of its values, and nothing else.
+func fitsOptions(vo storer.VariantOptions, options storer.ProductOptions) bool {
+	if len(vo) != len(options) {
+		return false
+	}
+	for _, o := range options {
+		if value, ok := vo[o.Name]; !ok || !slices.Contains(o.Values, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateVariant checks v against the options of p and the other variants
+// of p, trimming its SKU.
+func validateVariant(v *storer.ProductVariant, p *storer.Product, variants []storer.ProductVariant) error {
+	v.SKU = strings.TrimSpace(v.SKU)
+	switch {
+	case v.SKU == "":
+		return &VariantError{Field: "sku", Reason: "is required"}
+	case len(v.SKU) > 64:
+		return &VariantError{Field: "sku", Reason: "must be at most 64 characters"}
+	case v.Price != nil && *v.Price < 0:
+		return &VariantError{Field: "price", Reason: "must not be negative"}
+	case v.CountInStock < 0:
+		return &VariantError{Field: "count_in_stock", Reason: "must not be negative"}
+	case len(p.Options) == 0:
+		return &VariantError{Field: "options", Reason: "are not set on the product"}
+	case !fitsOptions(v.Options, p.Options):
+		return &VariantError{Field: "options", Reason: "must give every option of the product one of its values"}
+	}
+	for _, other := range variants {
+		if other.ID != v.ID && maps.Equal(other.Options, v.Options) {
+			return &VariantError{Field: "options", Reason: fmt.Sprintf("already used by variant %s", other.SKU)}
+		}
+	}
+	return nil
+}
+
+// checkProductOptions validates the options of p and checks they still fit
+// every variant of it. The stock of a product with options stays the sum of
+// the stock of its variants.
+func (s *Server) checkProductOptions(ctx context.Context, p *storer.Product) error {
+	if err := validateOptions(p); err != nil {
+		return err
+	}
+	variants, err := s.storer.ListVariants(ctx, p.ID)
+	if err != nil {
+		return err
+	}
+	stock := 0
+	for _, v := range variants {
+		if !fitsOptions(v.Options, p.Options) {
+			return &VariantError{Field: "options", Reason: fmt.Sprintf("no longer fit variant %s", v.SKU)}
+		}
+		stock += v.CountInStock
+	}
+	if len(p.Options) > 0 {
+		p.CountInStock = stock
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package server_test
+
+import (
+	"context"
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/pricing"
+	"ecom_apiv1/internal/server"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"testing"
+)
+
+func TestVariants(t *testing.T) {
+	ctx := context.Background()
+	store := storer.NewMemoryStorage()
+	srv := server.NewServer(store, pricing.NewCalculator(config.PricingConfig{ShippingFee: 5}), nil)
+
+	shirt, err := srv.CreateProduct(ctx, &storer.Product{
+		Name:         "Shirt",
+		Image:        "shirt.jpg",
+		Price:        20,
+		CountInStock: 50,
+		IsActive:     true,
+		Options: storer.ProductOptions{
+			{Name: " Size ", Values: []string{"S", " M "}},
+			{Name: "Color", Values: []string{"Red", "Blue"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateProduct: %v", err)
+	}
+	if shirt.CountInStock != 0 || shirt.Options[0].Name != "Size" || shirt.Options[0].Values[1] != "M" {
+		t.Errorf("product = %+v, want trimmed options and the stock left to the variants", shirt)
+	}
+	if _, err := srv.CreateProduct(ctx, &storer.Product{Name: "Bad", Options: storer.ProductOptions{{Name: "Size"}}}); !errors.Is(err, server.ErrInvalidVariant) {
+		t.Errorf("option without values: expected ErrInvalidVariant, got %v", err)
+	}
+
+	price := 25.0
+	small, err := srv.CreateVariant(ctx, &storer.ProductVariant{ProductID: shirt.ID, SKU: " SHIRT-S-RED ", Options: storer.VariantOptions{"Size": "S", "Color": "Red"}, CountInStock: 3, IsActive: true})
+	if err != nil || small.SKU != "SHIRT-S-RED" {
+		t.Fatalf("CreateVariant = %+v, %v", small, err)
+	}
+	large, err := srv.CreateVariant(ctx, &storer.ProductVariant{ProductID: shirt.ID, SKU: "SHIRT-M-BLUE", Options: storer.VariantOptions{"Size": "M", "Color": "Blue"}, Price: &price, Image: "blue.jpg", CountInStock: 2, IsActive: true})
+	if err != nil {
+		t.Fatalf("CreateVariant: %v", err)
+	}
+	invalid := []storer.VariantOptions{
+		{"Size": "XL", "Color": "Red"},
+		{"Size": "S"},
+		{"Size": "S", "Color": "Red", "Fit": "Slim"},
+		// the combination of SHIRT-S-RED
+		{"Size": "S", "Color": "Red"},
+	}
+	for _, options := range invalid {
+		_, err := srv.CreateVariant(ctx, &storer.ProductVariant{ProductID: shirt.ID, SKU: "OTHER", Options: options})
+		if !errors.Is(err, server.ErrInvalidVariant) {
+			t.Errorf("CreateVariant(%v): expected ErrInvalidVariant, got %v", options, err)
+		}
+	}
+	if err := srv.DeleteVariant(ctx, shirt.ID+1, small.ID); !errors.Is(err, storer.ErrVariantNotFound) {
+		t.Errorf("variant of another product: expected ErrVariantNotFound, got %v", err)
+	}
+
+	// dropping a value a variant uses is refused, the stock comes from the variants
+	update := *shirt
+	update.Options = storer.ProductOptions{{Name: "Size", Values: []string{"S", "M"}}, {Name: "Color", Values: []string{"Red"}}}
+	if _, err := srv.UpdateProduct(ctx, &update); !errors.Is(err, server.ErrInvalidVariant) {
+		t.Errorf("UpdateProduct dropping Blue: expected ErrInvalidVariant, got %v", err)
+	}
+	update = *shirt
+	update.CountInStock = 100
+	if p, err := srv.UpdateProduct(ctx, &update); err != nil || p.CountInStock != 5 {
+		t.Errorf("UpdateProduct = %+v, %v, want the stock of the variants", p, err)
+	}
+
+	if _, err := srv.AddCartItem(ctx, server.CartOwner{UserID: 1}, shirt.ID, nil, 1); !errors.Is(err, server.ErrVariantRequired) {
+		t.Errorf("AddCartItem: expected ErrVariantRequired, got %v", err)
+	}
+
+	_, err = srv.CreateOrder(ctx, &storer.Order{PaymentMethod: "Stripe", Items: []storer.OrderItem{{ProductID: shirt.ID, Quantity: 1}}})
+	var unavailable *server.UnavailableItemsError
+	if !errors.As(err, &unavailable) || unavailable.Items[0].Reason != server.ReasonVariantRequired {
+		t.Errorf("order without a variant: expected %q, got %v", server.ReasonVariantRequired, err)
+	}
+
+	o, err := srv.CreateOrder(ctx, &storer.Order{PaymentMethod: "Stripe", Items: []storer.OrderItem{
+		{ProductID: shirt.ID, VariantID: &small.ID, Quantity: 2},
+		{ProductID: shirt.ID, VariantID: &large.ID, Quantity: 1},
+	}})
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	want := []storer.OrderItem{
+		{Name: "Shirt (S / Red)", SKU: "SHIRT-S-RED", Image: "shirt.jpg", Price: 20},
+		{Name: "Shirt (M / Blue)", SKU: "SHIRT-M-BLUE", Image: "blue.jpg", Price: 25},
+	}
+	for i, w := range want {
+		if it := o.Items[i]; it.Name != w.Name || it.SKU != w.SKU || it.Image != w.Image || it.Price != w.Price {
+			t.Errorf("item %d = %+v, want %+v", i, it, w)
+		}
+	}
+	if o.ItemsPrice != 65 {
+		t.Errorf("items price = %v, want 65", o.ItemsPrice)
+	}
+	if v, _ := store.GetVariant(ctx, small.ID); v.CountInStock != 1 {
+		t.Errorf("stock of SHIRT-S-RED = %d, want 1", v.CountInStock)
+	}
+
+	large.IsActive = false
+	if _, err := srv.UpdateVariant(ctx, large); err != nil {
+		t.Fatalf("UpdateVariant: %v", err)
+	}
+	_, err = srv.CreateOrder(ctx, &storer.Order{PaymentMethod: "Stripe", Items: []storer.OrderItem{{ProductID: shirt.ID, VariantID: &large.ID, Quantity: 1}}})
+	if !errors.As(err, &unavailable) || unavailable.Items[0].Reason != server.ReasonVariantInactive {
+		t.Errorf("inactive variant: expected %q, got %v", server.ReasonVariantInactive, err)
+	}
+}
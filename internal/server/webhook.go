@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"ecom_apiv1/internal/payment"
+	"ecom_apiv1/internal/pricing"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Outcomes recorded for a handled webhook event.
+const (
+	WebhookCaptured      = "captured"
+	WebhookFailed        = "failed"
+	WebhookRefunded      = "refunded"
+	WebhookPartialRefund = "partial_refund"
+	WebhookRefundFailed  = "refund_failed"
+	WebhookReversed      = "reversed"
+	WebhookUnchanged     = "unchanged"
+	WebhookIgnored       = "ignored"
+)
+
+// HandleWebhook verifies a provider notification and applies it to the
+// payment it is about. Each event is applied at most once: a redelivery of
+// an event already recorded is reported as a duplicate and changes nothing,
+// and concurrent deliveries are kept apart by the guarded payment status
+// transitions and the order's refunded amount, so an order is never marked
+// paid or refunded twice.
+func (s *Server) HandleWebhook(ctx context.Context, providerName string, payload []byte, header http.Header) (e *storer.WebhookEvent, duplicate bool, err error) {
+	provider, err := s.payments.Get(providerName)
+	if err != nil {
+		return nil, false, err
+	}
+	ev, err := provider.ParseWebhook(payload, header)
+	if err != nil {
+		return nil, false, err
+	}
+	seen, err := s.storer.GetWebhookEvent(ctx, provider.Name(), ev.ID)
+	if err == nil {
+		return seen, true, nil
+	}
+	if !errors.Is(err, storer.ErrWebhookEventNotFound) {
+		return nil, false, err
+	}
+
+	paymentID, outcome, err := s.applyWebhook(ctx, provider, ev)
+	if err != nil {
+		return nil, false, err
+	}
+	e, err = s.storer.CreateWebhookEvent(ctx, &storer.WebhookEvent{
+		Provider:  provider.Name(),
+		EventID:   ev.ID,
+		Type:      string(ev.Type),
+		PaymentID: paymentID,
+		Outcome:   outcome,
+	})
+	if errors.Is(err, storer.ErrWebhookEventExists) {
+		// a concurrent delivery of the same event got there first
+		seen, err := s.storer.GetWebhookEvent(ctx, provider.Name(), ev.ID)
+		return seen, true, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return e, false, nil
+}
+
+func (s *Server) applyWebhook(ctx context.Context, provider payment.Provider, ev *payment.Event) (*uint, string, error) {
+	p, err := s.storer.GetPaymentByIntent(ctx, provider.Name(), ev.IntentID)
+	if errors.Is(err, storer.ErrPaymentNotFound) {
+		return nil, WebhookIgnored, nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	var outcome string
+	switch ev.Type {
+	case payment.EventCaptureSucceeded:
+		outcome = WebhookCaptured
+		_, err = s.settleCapture(ctx, provider, p, &payment.Capture{
+			ID:       ev.CaptureID,
+			IntentID: ev.IntentID,
+			Status:   payment.CaptureSucceeded,
+			Amount:   ev.Amount,
+			Currency: ev.Currency,
+		})
+	case payment.EventCaptureFailed:
+		outcome = WebhookFailed
+		_, err = s.storer.FailPayment(ctx, p.ID, fmt.Sprintf("declined by %s", provider.Name()))
+	case payment.EventRefunded, payment.EventRefundFailed:
+		outcome, err = s.applyRefundEvent(ctx, provider, p, ev)
+	default:
+		return &p.ID, WebhookIgnored, nil
+	}
+
+	switch {
+	case errors.Is(err, storer.ErrPaymentNotPending), errors.Is(err, storer.ErrPaymentNotCaptured),
+		errors.Is(err, ErrNothingToRefund), errors.Is(err, storer.ErrRefundExceedsTotal),
+		errors.Is(err, storer.ErrRefundNotPending):
+		// already settled by the buyer's capture, our own refund or an
+		// earlier delivery
+		return &p.ID, WebhookUnchanged, nil
+	case errors.Is(err, ErrCaptureMismatch), errors.Is(err, ErrOrderNotPayable):
+		return &p.ID, WebhookReversed, nil
+	case err != nil:
+		return nil, "", err
+	}
+	return &p.ID, outcome, nil
+}
+
+// applyRefundEvent settles a refund of ours the provider was still paying
+// out, matched on the provider's refund ID, with the outcome the provider
+// reports. Any other refund it reports was made at the provider and is
+// recorded as such.
+func (s *Server) applyRefundEvent(ctx context.Context, provider payment.Provider, p *storer.Payment, ev *payment.Event) (string, error) {
+	o, err := s.storer.GetOrderByID(ctx, p.OrderID)
+	if err != nil {
+		return "", err
+	}
+	refunds, err := s.storer.ListRefunds(ctx, o.ID)
+	if err != nil {
+		return "", err
+	}
+	var refunded float64
+	for _, rf := range refunds {
+		if rf.Status == storer.RefundSucceeded {
+			refunded += rf.Amount
+		}
+	}
+	for _, rf := range refunds {
+		if ev.RefundID == "" || rf.ProviderRefundID != ev.RefundID || rf.Status != storer.RefundPending {
+			continue
+		}
+		if ev.Type == payment.EventRefundFailed {
+			if _, err := s.storer.FailRefund(ctx, rf.ID, fmt.Sprintf("declined by %s", provider.Name())); err != nil {
+				return "", err
+			}
+			return WebhookRefundFailed, nil
+		}
+		if _, err := s.storer.CompleteRefund(ctx, rf.ID, rf.ProviderRefundID); err != nil {
+			return "", err
+		}
+		if storer.FullyRefunded(refunded+rf.Amount, o.TotalPrice) {
+			return WebhookRefunded, nil
+		}
+		return WebhookPartialRefund, nil
+	}
+	if ev.Type == payment.EventRefundFailed {
+		// a refund already settled, or never paid out
+		return "", ErrNothingToRefund
+	}
+	return s.recordProviderRefund(ctx, provider, p, o, refunds, ev)
+}
+
+// recordProviderRefund records a refund made at the provider, from its
+// dashboard or by a dispute, so the order's refunded amount and refunds
+// match what was paid back. The refund is kept under the provider's ID, so
+// a later event about it changes nothing. A refund of ours still being
+// paid out already counts towards the refunded amount and is not recorded
+// again.
+func (s *Server) recordProviderRefund(ctx context.Context, provider payment.Provider, p *storer.Payment, o *storer.Order, refunds []storer.Refund, ev *payment.Event) (string, error) {
+	if p.Status != storer.PaymentCaptured {
+		return "", storer.ErrPaymentNotCaptured
+	}
+	providerRefundID := ev.RefundID
+	if providerRefundID == "" {
+		providerRefundID = ev.ID
+	}
+
+	amount := payment.FromMinor(ev.Amount)
+	if ev.Refunded > 0 {
+		// the provider's total tells the refunds we know of apart
+		amount = payment.FromMinor(ev.Refunded) - o.RefundedAmount
+	}
+	for _, rf := range refunds {
+		switch {
+		case rf.ProviderRefundID == providerRefundID && rf.Status != storer.RefundFailed:
+			return "", ErrNothingToRefund
+		case ev.Refunded == 0 && rf.Status == storer.RefundPending && rf.ProviderRefundID == "" && payment.ToMinor(rf.Amount) == ev.Amount:
+			// ours, reported before we heard back from the provider
+			return "", ErrNothingToRefund
+		}
+	}
+	amount = pricing.Round(min(amount, o.TotalPrice-o.RefundedAmount))
+	if amount <= 0 {
+		return "", ErrNothingToRefund
+	}
+	rf, err := s.storer.CreateRefund(ctx, &storer.Refund{
+		OrderID:   o.ID,
+		PaymentID: &p.ID,
+		Amount:    amount,
+		Reason:    fmt.Sprintf("refunded at %s", provider.Name()),
+	})
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.storer.CompleteRefund(ctx, rf.ID, providerRefundID); err != nil {
+		return "", err
+	}
+	if storer.FullyRefunded(o.RefundedAmount+amount, o.TotalPrice) {
+		return WebhookRefunded, nil
+	}
+	return WebhookPartialRefund, nil
+}
@@ -0,0 +1,337 @@
+package server_test
+
+import (
+	"context"
+	"ecom_apiv1/config"
+	"ecom_apiv1/internal/payment"
+	"ecom_apiv1/internal/payment/paymenttest"
+	"ecom_apiv1/internal/pricing"
+	"ecom_apiv1/internal/server"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWebhookAppliesEachEventOnce(t *testing.T) {
+	ctx := context.Background()
+	fake := paymenttest.NewServer(t)
+	store := storer.NewMemoryStorage()
+	payments := payment.NewRegistry("USD", payment.NewStripe(fake.StripeConfig(), http.DefaultClient))
+	srv := server.NewServer(store, pricing.NewCalculator(config.PricingConfig{ShippingFee: 5}), payments)
+
+	p, _ := store.CreateProduct(ctx, &storer.Product{Name: "Desk", Price: 120, CountInStock: 10, IsActive: true})
+	userID := uint(1)
+	o, err := srv.CreateOrder(ctx, &storer.Order{UserID: &userID, PaymentMethod: "Stripe", Items: []storer.OrderItem{{ProductID: p.ID, Quantity: 1}}})
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	session, err := srv.StartPayment(ctx, o)
+	if err != nil {
+		t.Fatalf("StartPayment: %v", err)
+	}
+	intent := session.Payment.IntentID
+
+	deliver := func(payload string) (*storer.WebhookEvent, bool, error) {
+		body := []byte(payload)
+		return srv.HandleWebhook(ctx, "stripe", body, paymenttest.StripeHeader(paymenttest.StripeWebhookSecret, body, time.Now()))
+	}
+	succeeded := fmt.Sprintf(`{"id":"evt_paid","type":"payment_intent.succeeded","data":{"object":{"id":%q,"status":"succeeded","amount_received":12500,"currency":"usd","latest_charge":"ch_1"}}}`, intent)
+	refunded := fmt.Sprintf(`{"id":"evt_refund","type":"charge.refunded","data":{"object":{"id":"ch_1","payment_intent":%q,"amount_refunded":12500,"currency":"usd"}}}`, intent)
+
+	e, duplicate, err := deliver(succeeded)
+	if err != nil || duplicate || e.Outcome != server.WebhookCaptured {
+		t.Fatalf("first delivery = %+v, %v, %v", e, duplicate, err)
+	}
+	if _, duplicate, err := deliver(succeeded); err != nil || !duplicate {
+		t.Errorf("redelivery: duplicate = %v, err = %v", duplicate, err)
+	}
+	// a second event about the same capture finds the payment settled
+	again := fmt.Sprintf(`{"id":"evt_paid_2","type":"payment_intent.succeeded","data":{"object":{"id":%q,"status":"succeeded","amount_received":12500,"currency":"usd","latest_charge":"ch_1"}}}`, intent)
+	if e, _, err := deliver(again); err != nil || e.Outcome != server.WebhookUnchanged {
+		t.Errorf("second capture event = %+v, %v", e, err)
+	}
+	history, _ := store.ListOrderStatusEvents(ctx, o.ID)
+	paid := 0
+	for _, ev := range history {
+		if ev.ToStatus == storer.OrderPaid {
+			paid++
+		}
+	}
+	if paid != 1 {
+		t.Errorf("order marked paid %d times, want 1", paid)
+	}
+
+	if e, _, err := deliver(refunded); err != nil || e.Outcome != server.WebhookRefunded {
+		t.Fatalf("refund event = %+v, %v", e, err)
+	}
+	if _, duplicate, err := deliver(refunded); err != nil || !duplicate {
+		t.Errorf("refund redelivery: duplicate = %v, err = %v", duplicate, err)
+	}
+	got, _ := store.GetOrderByID(ctx, o.ID)
+	if got.Status != storer.OrderRefunded {
+		t.Errorf("order status = %s, want refunded", got.Status)
+	}
+	if n := fake.Refunded(intent); n != 0 {
+		t.Errorf("webhook issued a refund of %d", n)
+	}
+	if got.RefundedAmount != got.TotalPrice {
+		t.Errorf("refunded amount = %v, want %v", got.RefundedAmount, got.TotalPrice)
+	}
+	refunds, _ := store.ListRefunds(ctx, o.ID)
+	if len(refunds) != 1 || refunds[0].Status != storer.RefundSucceeded || refunds[0].Amount != got.TotalPrice || refunds[0].ProviderRefundID != "evt_refund" {
+		t.Errorf("refunds = %+v, want one succeeded refund of the total", refunds)
+	}
+	if pay, _ := store.GetPayment(ctx, session.Payment.ID); pay.Status != storer.PaymentRefunded {
+		t.Errorf("payment status = %s, want refunded", pay.Status)
+	}
+
+	t.Run("rejected", func(t *testing.T) {
+		body := []byte(succeeded)
+		if _, _, err := srv.HandleWebhook(ctx, "stripe", body, paymenttest.StripeHeader("whsec_other", body, time.Now())); !errors.Is(err, payment.ErrInvalidSignature) {
+			t.Errorf("expected ErrInvalidSignature, got %v", err)
+		}
+		if _, _, err := srv.HandleWebhook(ctx, "paypal", body, http.Header{}); !errors.Is(err, payment.ErrProviderNotConfigured) {
+			t.Errorf("expected ErrProviderNotConfigured, got %v", err)
+		}
+		if e, _, err := deliver(`{"id":"evt_other","type":"payment_intent.succeeded","data":{"object":{"id":"pi_unknown","status":"succeeded"}}}`); err != nil || e.Outcome != server.WebhookIgnored {
+			t.Errorf("event for an unknown payment = %+v, %v", e, err)
+		}
+	})
+}
+
+func TestWebhookAfterOwnRefund(t *testing.T) {
+	ctx := context.Background()
+	fake := paymenttest.NewServer(t)
+	store := storer.NewMemoryStorage()
+	payments := payment.NewRegistry("USD", payment.NewStripe(fake.StripeConfig(), http.DefaultClient))
+	srv := server.NewServer(store, pricing.NewCalculator(config.PricingConfig{}), payments)
+
+	p, _ := store.CreateProduct(ctx, &storer.Product{Name: "Desk", Price: 120, CountInStock: 10, IsActive: true})
+	userID := uint(1)
+	o, _ := srv.CreateOrder(ctx, &storer.Order{UserID: &userID, PaymentMethod: "Stripe", Items: []storer.OrderItem{{ProductID: p.ID, Quantity: 1}}})
+	session, err := srv.StartPayment(ctx, o)
+	if err != nil {
+		t.Fatalf("StartPayment: %v", err)
+	}
+	intent := session.Payment.IntentID
+	fake.Approve(intent)
+	if _, err := srv.CapturePayment(ctx, o); err != nil {
+		t.Fatalf("CapturePayment: %v", err)
+	}
+	o, _ = store.GetOrderByID(ctx, o.ID)
+	if _, err := srv.RefundOrder(ctx, o, 0, "changed mind", nil); err != nil {
+		t.Fatalf("RefundOrder: %v", err)
+	}
+
+	// the provider reports the refund we just made
+	body := []byte(fmt.Sprintf(`{"id":"evt_refund","type":"charge.refunded","data":{"object":{"id":"ch_1","payment_intent":%q,"amount_refunded":12000,"currency":"usd"}}}`, intent))
+	e, _, err := srv.HandleWebhook(ctx, "stripe", body, paymenttest.StripeHeader(paymenttest.StripeWebhookSecret, body, time.Now()))
+	if err != nil || e.Outcome != server.WebhookUnchanged {
+		t.Fatalf("refund event = %+v, %v", e, err)
+	}
+	refunds, _ := store.ListRefunds(ctx, o.ID)
+	if len(refunds) != 1 {
+		t.Errorf("refunds = %+v, want only our own", refunds)
+	}
+	if got, _ := store.GetOrderByID(ctx, o.ID); got.RefundedAmount != 120 || got.Status != storer.OrderRefunded {
+		t.Errorf("order after the event = %s, refunded %v", got.Status, got.RefundedAmount)
+	}
+}
+
+func TestWebhookPartialRefunds(t *testing.T) {
+	ctx := context.Background()
+	fake := paymenttest.NewServer(t)
+	store := storer.NewMemoryStorage()
+	payments := payment.NewRegistry("USD",
+		payment.NewStripe(fake.StripeConfig(), http.DefaultClient),
+		payment.NewPayPal(fake.PayPalConfig(), http.DefaultClient))
+	srv := server.NewServer(store, pricing.NewCalculator(config.PricingConfig{}), payments)
+	p, _ := store.CreateProduct(ctx, &storer.Product{Name: "Desk", Price: 100, CountInStock: 10, IsActive: true})
+	userID := uint(1)
+	paid := func(method string) (*storer.Order, string) {
+		t.Helper()
+		o, err := srv.CreateOrder(ctx, &storer.Order{UserID: &userID, PaymentMethod: method, Items: []storer.OrderItem{{ProductID: p.ID, Quantity: 1}}})
+		if err != nil {
+			t.Fatalf("CreateOrder: %v", err)
+		}
+		session, err := srv.StartPayment(ctx, o)
+		if err != nil {
+			t.Fatalf("StartPayment: %v", err)
+		}
+		fake.Approve(session.Payment.IntentID)
+		if _, err := srv.CapturePayment(ctx, o); err != nil {
+			t.Fatalf("CapturePayment: %v", err)
+		}
+		return o, session.Payment.IntentID
+	}
+	refunds := func(o *storer.Order) (float64, []string) {
+		got, _ := store.GetOrderByID(ctx, o.ID)
+		list, _ := store.ListRefunds(ctx, o.ID)
+		var ids []string
+		for _, rf := range list {
+			ids = append(ids, fmt.Sprintf("%s=%v", rf.ProviderRefundID, rf.Amount))
+		}
+		return got.RefundedAmount, ids
+	}
+
+	t.Run("stripe", func(t *testing.T) {
+		o, intent := paid("Stripe")
+		deliver := func(id string, refunded int64, refundID string, amount int64) string {
+			t.Helper()
+			body := []byte(fmt.Sprintf(`{"id":%q,"type":"charge.refunded","data":{"object":{"id":"ch_1","payment_intent":%q,"amount_refunded":%d,"currency":"usd","refunds":{"data":[{"id":%q,"amount":%d}]}}}}`, id, intent, refunded, refundID, amount))
+			e, _, err := srv.HandleWebhook(ctx, "stripe", body, paymenttest.StripeHeader(paymenttest.StripeWebhookSecret, body, time.Now()))
+			if err != nil {
+				t.Fatalf("HandleWebhook: %v", err)
+			}
+			return e.Outcome
+		}
+
+		if outcome := deliver("evt_1", 3000, "re_1", 3000); outcome != server.WebhookPartialRefund {
+			t.Errorf("first refund = %s, want partial_refund", outcome)
+		}
+		// another event about the same refund
+		if outcome := deliver("evt_2", 3000, "re_1", 3000); outcome != server.WebhookUnchanged {
+			t.Errorf("same refund again = %s, want unchanged", outcome)
+		}
+		if amount, ids := refunds(o); amount != 30 || len(ids) != 1 || ids[0] != "re_1=30" {
+			t.Errorf("after one refund: refunded %v, refunds %v", amount, ids)
+		}
+		if outcome := deliver("evt_3", 10000, "re_2", 7000); outcome != server.WebhookRefunded {
+			t.Errorf("rest refunded = %s, want refunded", outcome)
+		}
+		amount, ids := refunds(o)
+		if amount != 100 || len(ids) != 2 || ids[1] != "re_2=70" {
+			t.Errorf("after the rest: refunded %v, refunds %v", amount, ids)
+		}
+		if got, _ := store.GetOrderByID(ctx, o.ID); got.Status != storer.OrderRefunded {
+			t.Errorf("order status = %s, want refunded", got.Status)
+		}
+	})
+
+	t.Run("paypal", func(t *testing.T) {
+		o, intent := paid("PayPal")
+		deliver := func(id, refundID, value string) string {
+			t.Helper()
+			body := []byte(fmt.Sprintf(`{"id":%q,"event_type":"PAYMENT.CAPTURE.REFUNDED","resource":{"id":%q,"status":"COMPLETED","amount":{"currency_code":"USD","value":%q},"supplementary_data":{"related_ids":{"order_id":%q}}}}`, id, refundID, value, intent))
+			e, _, err := srv.HandleWebhook(ctx, "paypal", body, paymenttest.PayPalHeader(paymenttest.PayPalWebhookSecret, id, body, time.Now()))
+			if err != nil {
+				t.Fatalf("HandleWebhook: %v", err)
+			}
+			return e.Outcome
+		}
+
+		if outcome := deliver("WH-1", "RF-1", "25.00"); outcome != server.WebhookPartialRefund {
+			t.Errorf("first refund = %s, want partial_refund", outcome)
+		}
+		if outcome := deliver("WH-2", "RF-1", "25.00"); outcome != server.WebhookUnchanged {
+			t.Errorf("same refund again = %s, want unchanged", outcome)
+		}
+		if outcome := deliver("WH-3", "RF-2", "25.00"); outcome != server.WebhookPartialRefund {
+			t.Errorf("second refund of the same amount = %s, want partial_refund", outcome)
+		}
+		if amount, ids := refunds(o); amount != 50 || len(ids) != 2 {
+			t.Errorf("refunded %v, refunds %v", amount, ids)
+		}
+	})
+}
+
+func TestWebhookSettlesPendingRefund(t *testing.T) {
+	ctx := context.Background()
+	fake := paymenttest.NewServer(t)
+	store := storer.NewMemoryStorage()
+	payments := payment.NewRegistry("USD", payment.NewStripe(fake.StripeConfig(), http.DefaultClient))
+	srv := server.NewServer(store, pricing.NewCalculator(config.PricingConfig{}), payments)
+	p, _ := store.CreateProduct(ctx, &storer.Product{Name: "Desk", Price: 100, CountInStock: 10, IsActive: true})
+	userID := uint(1)
+
+	// refunded places an order, pays it and refunds it in full, with the
+	// provider still paying the refund out.
+	refunded := func() (*storer.Order, string, *storer.Refund) {
+		t.Helper()
+		o, err := srv.CreateOrder(ctx, &storer.Order{UserID: &userID, PaymentMethod: "Stripe", Items: []storer.OrderItem{{ProductID: p.ID, Quantity: 1}}})
+		if err != nil {
+			t.Fatalf("CreateOrder: %v", err)
+		}
+		session, err := srv.StartPayment(ctx, o)
+		if err != nil {
+			t.Fatalf("StartPayment: %v", err)
+		}
+		intent := session.Payment.IntentID
+		fake.Approve(intent)
+		fake.HoldRefunds(intent)
+		if _, err := srv.CapturePayment(ctx, o); err != nil {
+			t.Fatalf("CapturePayment: %v", err)
+		}
+		o, _ = store.GetOrderByID(ctx, o.ID)
+		rf, err := srv.RefundOrder(ctx, o, 0, "changed mind", nil)
+		if err != nil {
+			t.Fatalf("RefundOrder: %v", err)
+		}
+		if rf.Status != storer.RefundPending || rf.ProviderRefundID == "" {
+			t.Fatalf("refund held by the provider = %s under %q, want pending under its ID", rf.Status, rf.ProviderRefundID)
+		}
+		got, _ := store.GetOrderByID(ctx, o.ID)
+		if got.Status != storer.OrderPaid || got.RefundedAmount != 100 {
+			t.Errorf("order while the refund is pending = %s, refunded %v", got.Status, got.RefundedAmount)
+		}
+		if pay, _ := store.GetPayment(ctx, session.Payment.ID); pay.Status != storer.PaymentCaptured {
+			t.Errorf("payment while the refund is pending = %s, want captured", pay.Status)
+		}
+		return o, intent, rf
+	}
+	deliver := func(id, typ, intent string, rf *storer.Refund, status string) string {
+		t.Helper()
+		body := []byte(fmt.Sprintf(`{"id":%q,"type":%q,"data":{"object":{"id":%q,"object":"refund","amount":10000,"currency":"usd","status":%q,"payment_intent":%q}}}`, id, typ, rf.ProviderRefundID, status, intent))
+		e, _, err := srv.HandleWebhook(ctx, "stripe", body, paymenttest.StripeHeader(paymenttest.StripeWebhookSecret, body, time.Now()))
+		if err != nil {
+			t.Fatalf("HandleWebhook: %v", err)
+		}
+		return e.Outcome
+	}
+	refund := func(o *storer.Order) storer.Refund {
+		t.Helper()
+		refunds, _ := store.ListRefunds(ctx, o.ID)
+		if len(refunds) != 1 {
+			t.Fatalf("refunds = %+v, want one", refunds)
+		}
+		return refunds[0]
+	}
+
+	t.Run("succeeded", func(t *testing.T) {
+		o, intent, rf := refunded()
+		if outcome := deliver("evt_pending", "refund.updated", intent, rf, "pending"); outcome != server.WebhookIgnored {
+			t.Errorf("still pending = %s, want ignored", outcome)
+		}
+		if outcome := deliver("evt_done", "refund.updated", intent, rf, "succeeded"); outcome != server.WebhookRefunded {
+			t.Errorf("refund paid out = %s, want refunded", outcome)
+		}
+		if got := refund(o); got.Status != storer.RefundSucceeded || got.ProviderRefundID != rf.ProviderRefundID {
+			t.Errorf("refund after the event = %+v", got)
+		}
+		if got, _ := store.GetOrderByID(ctx, o.ID); got.Status != storer.OrderRefunded || got.RefundedAmount != 100 {
+			t.Errorf("order after the event = %s, refunded %v", got.Status, got.RefundedAmount)
+		}
+		if outcome := deliver("evt_done_2", "charge.refund.updated", intent, rf, "succeeded"); outcome != server.WebhookUnchanged {
+			t.Errorf("another event about the refund = %s, want unchanged", outcome)
+		}
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		o, intent, rf := refunded()
+		if outcome := deliver("evt_failed", "refund.failed", intent, rf, "failed"); outcome != server.WebhookRefundFailed {
+			t.Errorf("refund failed = %s, want refund_failed", outcome)
+		}
+		if got := refund(o); got.Status != storer.RefundFailed {
+			t.Errorf("refund after the event = %+v", got)
+		}
+		if got, _ := store.GetOrderByID(ctx, o.ID); got.Status != storer.OrderPaid || got.RefundedAmount != 0 {
+			t.Errorf("order after the event = %s, refunded %v", got.Status, got.RefundedAmount)
+		}
+		if outcome := deliver("evt_failed_2", "refund.failed", intent, rf, "failed"); outcome != server.WebhookUnchanged {
+			t.Errorf("redelivered failure = %s, want unchanged", outcome)
+		}
+	})
+}
@@ -0,0 +1,156 @@
+// Package shipping prices the delivery of an order from its weight, its
+// value and where it goes. The rules are built from config so they can
+// change without a release.
+package shipping
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"ecom_apiv1/config"
+)
+
+// VolumetricDivisor turns a package volume in cm³ into the weight in kg
+// carriers bill bulky but light items at.
+const VolumetricDivisor = 5000
+
+var ErrNoRate = errors.New("no shipping rate applies")
+
+// Parcel is what a rate is calculated for.
+type Parcel struct {
+	// Subtotal is the price of the items shipped.
+	Subtotal float64
+	// Weight is the billable weight in kg.
+	Weight float64
+	// Country is the ISO 3166-1 alpha-2 code of the destination, empty
+	// when it is not known yet.
+	Country string
+}
+
+type RateCalculator interface {
+	// Rate returns the shipping price of p before rounding. It fails with
+	// ErrNoRate when p cannot be shipped.
+	Rate(p Parcel) (float64, error)
+}
+
+// BillableWeight is the greater of the actual weight in kg and the
+// volumetric weight of the dimensions in cm.
+func BillableWeight(weight, length, width, height float64) float64 {
+	return math.Max(weight, length*width*height/VolumetricDivisor)
+}
+
+// FlatRate charges the same for every parcel.
+type FlatRate float64
+
+func (f FlatRate) Rate(Parcel) (float64, error) {
+	return float64(f), nil
+}
+
+// FreeOver waives the rate of Next for parcels whose subtotal reaches
+// Threshold.
+type FreeOver struct {
+	Threshold float64
+	Next      RateCalculator
+}
+
+func (f FreeOver) Rate(p Parcel) (float64, error) {
+	if p.Subtotal >= f.Threshold {
+		return 0, nil
+	}
+	return f.Next.Rate(p)
+}
+
+type Basis string
+
+const (
+	ByWeight Basis = "weight"
+	ByPrice  Basis = "price"
+)
+
+// Tier charges Amount up to and including UpTo; a zero UpTo has no limit.
+type Tier struct {
+	UpTo   float64
+	Amount float64
+}
+
+// Tiered charges the amount of the first tier the parcel's weight or
+// subtotal, as By says, does not exceed. Tiers are in ascending order.
+type Tiered struct {
+	By    Basis
+	Tiers []Tier
+}
+
+func (t Tiered) Rate(p Parcel) (float64, error) {
+	v := p.Weight
+	if t.By == ByPrice {
+		v = p.Subtotal
+	}
+	for _, tier := range t.Tiers {
+		if tier.UpTo == 0 || v <= tier.UpTo {
+			return tier.Amount, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %v by %s is over the last tier", ErrNoRate, v, t.By)
+}
+
+type Zone struct {
+	Name      string
+	Countries []string
+	Rate      RateCalculator
+}
+
+// Zones charges the rate of the zone the parcel's country is in, and the
+// Default rate elsewhere. Without a Default only zone countries ship.
+type Zones struct {
+	Zones   []Zone
+	Default RateCalculator
+}
+
+func (z Zones) Rate(p Parcel) (float64, error) {
+	for _, zone := range z.Zones {
+		for _, c := range zone.Countries {
+			if c == p.Country {
+				return zone.Rate.Rate(p)
+			}
+		}
+	}
+	if z.Default == nil {
+		return 0, fmt.Errorf("%w: no zone ships to %q", ErrNoRate, p.Country)
+	}
+	return z.Default.Rate(p)
+}
+
+// FromConfig builds the configured rules. Orders outside every zone pay
+// pricing.ShippingFee, or the shipping tiers when there are any, and ship
+// free from pricing.FreeShippingOver.
+func FromConfig(pricing config.PricingConfig, cfg config.ShippingConfig) RateCalculator {
+	def := rule(pricing.ShippingFee, cfg.TierBy, cfg.Tiers, pricing.FreeShippingOver)
+	if len(cfg.Zones) == 0 {
+		return def
+	}
+	zones := Zones{Default: def}
+	for _, z := range cfg.Zones {
+		zones.Zones = append(zones.Zones, Zone{
+			Name:      z.Name,
+			Countries: z.Countries,
+			Rate:      rule(z.Fee, z.TierBy, z.Tiers, z.FreeOver),
+		})
+	}
+	return zones
+}
+
+func rule(fee float64, tierBy string, tiers []config.ShippingTierConfig, freeOver float64) RateCalculator {
+	var rc RateCalculator = FlatRate(fee)
+	if len(tiers) > 0 {
+		t := Tiered{By: Basis(tierBy)}
+		for _, tier := range tiers {
+			t.Tiers = append(t.Tiers, Tier{UpTo: tier.UpTo, Amount: tier.Amount})
+		}
+		rc = t
+	}
+	if freeOver > 0 {
+		rc = FreeOver{Threshold: freeOver, Next: rc}
+	}
+	return rc
+}
@@ -0,0 +1,53 @@
+package shipping
+
+import (
+	"errors"
+	"testing"
+
+	"ecom_apiv1/config"
+)
+
+func TestFromConfig(t *testing.T) {
+	rc := FromConfig(config.PricingConfig{ShippingFee: 10, FreeShippingOver: 100}, config.ShippingConfig{
+		Zones: []config.ShippingZoneConfig{
+			{Name: "domestic", Countries: []string{"US"}, Fee: 5, FreeOver: 50},
+			{Name: "europe", Countries: []string{"DE", "FR"}, TierBy: "weight", Tiers: []config.ShippingTierConfig{{UpTo: 1, Amount: 15}, {UpTo: 5, Amount: 25}, {Amount: 40}}},
+		},
+	})
+	tests := []struct {
+		name string
+		p    Parcel
+		want float64
+	}{
+		{"domestic", Parcel{Subtotal: 20, Country: "US"}, 5},
+		{"domestic free over", Parcel{Subtotal: 50, Country: "US"}, 0},
+		{"light to europe", Parcel{Subtotal: 20, Weight: 1, Country: "DE"}, 15},
+		{"heavy to europe", Parcel{Subtotal: 500, Weight: 7.5, Country: "FR"}, 40},
+		{"elsewhere", Parcel{Subtotal: 20, Country: "JP"}, 10},
+		{"elsewhere free over", Parcel{Subtotal: 100, Country: "JP"}, 0},
+		{"unknown destination", Parcel{Subtotal: 20}, 10},
+	}
+	for _, tt := range tests {
+		if got, err := rc.Rate(tt.p); err != nil || got != tt.want {
+			t.Errorf("%s: Rate = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+
+	zonesOnly := Zones{Zones: []Zone{{Name: "domestic", Countries: []string{"US"}, Rate: FlatRate(5)}}}
+	if _, err := zonesOnly.Rate(Parcel{Country: "JP"}); !errors.Is(err, ErrNoRate) {
+		t.Errorf("expected ErrNoRate outside every zone without a default, got %v", err)
+	}
+	bounded := Tiered{By: ByPrice, Tiers: []Tier{{UpTo: 100, Amount: 5}}}
+	if _, err := bounded.Rate(Parcel{Subtotal: 150}); !errors.Is(err, ErrNoRate) {
+		t.Errorf("expected ErrNoRate over the last tier, got %v", err)
+	}
+}
+
+func TestBillableWeight(t *testing.T) {
+	if got := BillableWeight(1, 50, 40, 30); got != 12 {
+		t.Errorf("bulky parcel = %v kg, want its volumetric 12 kg", got)
+	}
+	if got := BillableWeight(3, 10, 10, 10); got != 3 {
+		t.Errorf("dense parcel = %v kg, want its actual 3 kg", got)
+	}
+}
@@ -0,0 +1,93 @@
+package storer
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Address is an entry in a user's address book. Orders never point at it:
+// checkout copies it onto the order as a ShippingAddress.
+type Address struct {
+	ID         uint      `gorm:"primaryKey" db:"id"`
+	CreatedAt  time.Time `db:"created_at"`
+	UpdatedAt  time.Time `db:"updated_at"`
+	UserID     uint      `gorm:"not null;index" db:"user_id"`
+	Name       string    `gorm:"not null" db:"name"`
+	Line1      string    `gorm:"not null" db:"line1"`
+	Line2      string    `gorm:"not null" db:"line2"`
+	City       string    `gorm:"not null" db:"city"`
+	PostalCode string    `gorm:"not null;type:varchar(32)" db:"postal_code"`
+	Country    string    `gorm:"not null;type:varchar(2)" db:"country"`
+	// IsDefault marks the address used when checkout names none. A user
+	// with addresses always has exactly one default.
+	IsDefault bool `gorm:"not null;default:false" db:"is_default"`
+}
+
+func (a *Address) ShippingAddress() ShippingAddress {
+	return ShippingAddress{
+		Name:       a.Name,
+		Line1:      a.Line1,
+		Line2:      a.Line2,
+		City:       a.City,
+		PostalCode: a.PostalCode,
+		Country:    a.Country,
+	}
+}
+
+// SetShippingAddress copies the fields of sa onto a.
+func (a *Address) SetShippingAddress(sa ShippingAddress) {
+	a.Name = sa.Name
+	a.Line1 = sa.Line1
+	a.Line2 = sa.Line2
+	a.City = sa.City
+	a.PostalCode = sa.PostalCode
+	a.Country = sa.Country
+}
+
+// ShippingAddress is where an order ships to. It is copied onto the order
+// when it is placed and stored as JSON, so later changes to the buyer's
+// details never rewrite past orders.
+type ShippingAddress struct {
+	Name       string `json:"name"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `json:"city"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+}
+
+func (a ShippingAddress) IsZero() bool {
+	return a == ShippingAddress{}
+}
+
+func (a ShippingAddress) Value() (driver.Value, error) {
+	if a.IsZero() {
+		return nil, nil
+	}
+	b, err := json.Marshal(a)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding shipping address: %w", err)
+	}
+	return string(b), nil
+}
+
+func (a *ShippingAddress) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*a = ShippingAddress{}
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into ShippingAddress", src)
+	}
+	if err := json.Unmarshal(b, a); err != nil {
+		return fmt.Errorf("error decoding shipping address: %w", err)
+	}
+	return nil
+}
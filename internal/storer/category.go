@@ -0,0 +1,91 @@
+package storer
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Category is a node of the category tree products are filed under.
+type Category struct {
+	ID        uint      `gorm:"primaryKey" db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+	// ParentID is nil for top-level categories.
+	ParentID *uint  `gorm:"index" db:"parent_id"`
+	Name     string `gorm:"not null" db:"name"`
+	Slug     string `gorm:"not null;uniqueIndex;type:varchar(128)" db:"slug"`
+	// Position orders the category among its siblings, lowest first.
+	Position int `gorm:"not null;default:0" db:"position"`
+}
+
+// Slugify turns a category name into its URL form: lower case letters and
+// digits, with every other run of characters replaced by one hyphen.
+func Slugify(s string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(strings.TrimSpace(s)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+			continue
+		}
+		hyphen = true
+	}
+	return b.String()
+}
+
+// CategoryNode is a category with its subcategories.
+type CategoryNode struct {
+	Category
+	Children []CategoryNode
+}
+
+// CategoryTree arranges categories into trees, siblings ordered by
+// position, then name. Categories whose parent is missing are left out.
+func CategoryTree(categories []Category) []CategoryNode {
+	children := make(map[uint][]Category)
+	var roots []Category
+	for _, c := range categories {
+		if c.ParentID == nil {
+			roots = append(roots, c)
+		} else {
+			children[*c.ParentID] = append(children[*c.ParentID], c)
+		}
+	}
+	var build func(level []Category) []CategoryNode
+	build = func(level []Category) []CategoryNode {
+		sortCategories(level)
+		nodes := make([]CategoryNode, 0, len(level))
+		for _, c := range level {
+			nodes = append(nodes, CategoryNode{Category: c, Children: build(children[c.ID])})
+		}
+		return nodes
+	}
+	return build(roots)
+}
+
+// CategorySubtree returns the ID of the category and of all its
+// descendants.
+func CategorySubtree(categories []Category, id uint) []uint {
+	ids := []uint{id}
+	for i := 0; i < len(ids); i++ {
+		for _, c := range categories {
+			if c.ParentID != nil && *c.ParentID == ids[i] && !slices.Contains(ids, c.ID) {
+				ids = append(ids, c.ID)
+			}
+		}
+	}
+	return ids
+}
+
+func sortCategories(categories []Category) {
+	slices.SortFunc(categories, func(a, b Category) int {
+		return cmp.Or(cmp.Compare(a.Position, b.Position), strings.Compare(a.Name, b.Name), cmp.Compare(a.ID, b.ID))
+	})
+}
@@ -0,0 +1,203 @@
+package storer
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	FacetCategory     = "category"
+	FacetPrice        = "price"
+	FacetRating       = "rating"
+	FacetAvailability = "availability"
+)
+
+const (
+	inStockValue    = "in_stock"
+	outOfStockValue = "out_of_stock"
+)
+
+// PriceBuckets are the lower bounds of the price facet ranges; each bucket
+// ends where the next one starts and the last one is open-ended.
+var PriceBuckets = []float64{0, 25, 50, 100, 250, 500}
+
+type FacetCount struct {
+	Value string
+	Count int64
+}
+
+type PriceBucketCount struct {
+	Min float64
+	// Max is nil for the open-ended last bucket.
+	Max   *float64
+	Count int64
+}
+
+// RatingCount counts the products whose average rating rounds down to
+// Rating stars.
+type RatingCount struct {
+	Rating int
+	Count  int64
+}
+
+type ProductFacets struct {
+	Total      int64
+	Categories []FacetCount
+	Prices     []PriceBucketCount
+	Ratings    []RatingCount
+	InStock    int64
+	OutOfStock int64
+}
+
+// FacetRow is one group of a facet query.
+type FacetRow struct {
+	Value string `db:"facet_value" gorm:"column:facet_value"`
+	Count int64  `db:"facet_count" gorm:"column:facet_count"`
+}
+
+// FacetQuery is the aggregate for one facet. SQL selects facet_value and
+// facet_count columns and uses ? placeholders.
+type FacetQuery struct {
+	Facet string
+	SQL   string
+	Args  []interface{}
+}
+
+// FacetQueries returns one grouped query per facet, restricted to the
+// products matching f.
+func (f ProductFilter) FacetQueries() []FacetQuery {
+	where, args := f.where()
+	if where != "" {
+		where = " WHERE " + where
+	}
+	grouped := func(facet, expr string) FacetQuery {
+		return FacetQuery{
+			Facet: facet,
+			SQL:   "SELECT " + expr + " AS facet_value, COUNT(*) AS facet_count FROM products" + where + " GROUP BY facet_value",
+			Args:  args,
+		}
+	}
+	return []FacetQuery{
+		grouped(FacetCategory, "category"),
+		grouped(FacetPrice, priceBucketSQL()),
+		grouped(FacetRating, ratingBucketSQL()),
+		grouped(FacetAvailability, fmt.Sprintf("CASE WHEN count_in_stock > 0 THEN '%s' ELSE '%s' END", inStockValue, outOfStockValue)),
+	}
+}
+
+func priceBucketSQL() string {
+	var b strings.Builder
+	b.WriteString("CASE")
+	for i := 1; i < len(PriceBuckets); i++ {
+		fmt.Fprintf(&b, " WHEN price < %s THEN %d", strconv.FormatFloat(PriceBuckets[i], 'f', -1, 64), i-1)
+	}
+	fmt.Fprintf(&b, " ELSE %d END", len(PriceBuckets)-1)
+	return b.String()
+}
+
+func ratingBucketSQL() string {
+	var b strings.Builder
+	b.WriteString("CASE")
+	for stars := 5; stars > 0; stars-- {
+		fmt.Fprintf(&b, " WHEN rating >= %d THEN %d", stars, stars)
+	}
+	b.WriteString(" ELSE 0 END")
+	return b.String()
+}
+
+// facetValue is the Go equivalent of the facet expressions in FacetQueries.
+func facetValue(facet string, p *Product) string {
+	switch facet {
+	case FacetCategory:
+		return p.Category
+	case FacetPrice:
+		bucket := 0
+		for i := 1; i < len(PriceBuckets) && p.Price >= PriceBuckets[i]; i++ {
+			bucket = i
+		}
+		return strconv.Itoa(bucket)
+	case FacetRating:
+		return strconv.Itoa(int(math.Floor(p.Rating)))
+	case FacetAvailability:
+		if p.CountInStock > 0 {
+			return inStockValue
+		}
+		return outOfStockValue
+	}
+	return ""
+}
+
+// BuildProductFacets assembles the rows returned by FacetQueries, keyed by
+// facet name.
+func BuildProductFacets(rows map[string][]FacetRow) (*ProductFacets, error) {
+	facets := &ProductFacets{
+		Categories: []FacetCount{},
+		Prices:     make([]PriceBucketCount, len(PriceBuckets)),
+		Ratings:    []RatingCount{},
+	}
+	for i, min := range PriceBuckets {
+		facets.Prices[i].Min = min
+		if i+1 < len(PriceBuckets) {
+			max := PriceBuckets[i+1]
+			facets.Prices[i].Max = &max
+		}
+	}
+
+	for _, row := range rows[FacetCategory] {
+		facets.Categories = append(facets.Categories, FacetCount{Value: row.Value, Count: row.Count})
+	}
+	slices.SortFunc(facets.Categories, func(a, b FacetCount) int {
+		if a.Count != b.Count {
+			return cmp.Compare(b.Count, a.Count)
+		}
+		return strings.Compare(a.Value, b.Value)
+	})
+
+	for _, row := range rows[FacetPrice] {
+		bucket, err := strconv.Atoi(row.Value)
+		if err != nil || bucket < 0 || bucket >= len(PriceBuckets) {
+			return nil, fmt.Errorf("error reading price facet: unexpected bucket %q", row.Value)
+		}
+		facets.Prices[bucket].Count = row.Count
+	}
+
+	for _, row := range rows[FacetRating] {
+		rating, err := strconv.Atoi(row.Value)
+		if err != nil {
+			return nil, fmt.Errorf("error reading rating facet: unexpected rating %q", row.Value)
+		}
+		facets.Ratings = append(facets.Ratings, RatingCount{Rating: rating, Count: row.Count})
+	}
+	slices.SortFunc(facets.Ratings, func(a, b RatingCount) int { return b.Rating - a.Rating })
+
+	for _, row := range rows[FacetAvailability] {
+		switch row.Value {
+		case inStockValue:
+			facets.InStock = row.Count
+		case outOfStockValue:
+			facets.OutOfStock = row.Count
+		}
+	}
+	facets.Total = facets.InStock + facets.OutOfStock
+	return facets, nil
+}
+
+// memoryFacets computes the same rows as FacetQueries over products that
+// already match the filter.
+func memoryFacets(products []Product) (*ProductFacets, error) {
+	rows := make(map[string][]FacetRow)
+	for _, facet := range []string{FacetCategory, FacetPrice, FacetRating, FacetAvailability} {
+		counts := make(map[string]int64)
+		for i := range products {
+			counts[facetValue(facet, &products[i])]++
+		}
+		for value, count := range counts {
+			rows[facet] = append(rows[facet], FacetRow{Value: value, Count: count})
+		}
+	}
+	return BuildProductFacets(rows)
+}
@@ -0,0 +1,24 @@
+package storer
+
+import "time"
+
+// IdempotencyKey is a client's Idempotency-Key together with the request
+// that first used it and, once that request has finished, its response.
+type IdempotencyKey struct {
+	ID        uint      `gorm:"primaryKey" db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	// Scope keeps the keys of different clients apart, e.g. "user:12".
+	Scope string `gorm:"not null;uniqueIndex:idx_idempotency_keys_scope_key;type:varchar(64)" db:"scope"`
+	Key   string `gorm:"column:idempotency_key;not null;uniqueIndex:idx_idempotency_keys_scope_key;type:varchar(255)" db:"idempotency_key"`
+	// Fingerprint is a hash of the request's method, path and body.
+	Fingerprint string `gorm:"not null;type:varchar(64)" db:"fingerprint"`
+	// StatusCode is zero while the request is still being handled.
+	StatusCode  int       `gorm:"not null" db:"status_code"`
+	ContentType string    `gorm:"not null" db:"content_type"`
+	Body        []byte    `db:"body"`
+	ExpiresAt   time.Time `gorm:"not null;index" db:"expires_at"`
+}
+
+func (k *IdempotencyKey) Completed() bool {
+	return k.StatusCode != 0
+}
@@ -0,0 +1,41 @@
+package storer
+
+import "time"
+
+// ProductImage is one picture of a product, shown in Position order. The
+// blob keys name the uploaded file and its thumbnail in the blob store;
+// they are empty for images hosted elsewhere.
+type ProductImage struct {
+	ID           uint      `gorm:"primaryKey" db:"id"`
+	CreatedAt    time.Time `db:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at"`
+	ProductID    uint      `gorm:"not null;index:idx_product_images_product_position,priority:1" db:"product_id"`
+	BlobKey      string    `gorm:"not null;default:''" db:"blob_key"`
+	URL          string    `gorm:"not null" db:"url"`
+	ThumbnailKey string    `gorm:"not null;default:''" db:"thumbnail_key"`
+	ThumbnailURL string    `gorm:"not null;default:''" db:"thumbnail_url"`
+	ContentType  string    `gorm:"not null;default:''" db:"content_type"`
+	Size         int64     `gorm:"not null;default:0" db:"size"`
+	Width        int       `gorm:"not null;default:0" db:"width"`
+	Height       int       `gorm:"not null;default:0" db:"height"`
+	AltText      string    `gorm:"not null;default:''" db:"alt_text"`
+	Position     int       `gorm:"not null;default:0;index:idx_product_images_product_position,priority:2" db:"position"`
+}
+
+// CheckImageOrder fails with ErrInvalidImageOrder unless ids names each of
+// the images exactly once.
+func CheckImageOrder(images []ProductImage, ids []uint) error {
+	if len(ids) != len(images) {
+		return ErrInvalidImageOrder
+	}
+	seen := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		seen[id] = true
+	}
+	for _, img := range images {
+		if !seen[img.ID] {
+			return ErrInvalidImageOrder
+		}
+	}
+	return nil
+}
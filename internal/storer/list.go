@@ -0,0 +1,721 @@
+package storer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+var ErrInvalidQuery = errors.New("invalid list query")
+
+// ListParams controls paging and ordering of list calls. Cursor and Page are
+// mutually exclusive; Sort is a comma separated list of fields where a
+// leading "-" means descending, e.g. "-price,name".
+type ListParams struct {
+	Limit  int
+	Cursor string
+	Page   int
+	Sort   string
+}
+
+type Page[T any] struct {
+	Items      []T
+	Total      int64
+	NextCursor string
+}
+
+type ProductFilter struct {
+	Category    string
+	MinPrice    *float64
+	MaxPrice    *float64
+	InStock     *bool
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	// CategoryIDs keeps the products filed under any of the categories.
+	CategoryIDs []uint
+}
+
+type ProductQuery struct {
+	ListParams
+	ProductFilter
+}
+
+type OrderFilter struct {
+	UserID        *uint
+	Status        OrderStatus
+	PaymentMethod string
+	CreatedFrom   *time.Time
+	CreatedTo     *time.Time
+}
+
+type OrderQuery struct {
+	ListParams
+	OrderFilter
+}
+
+type UserFilter struct {
+	IsAdmin     *bool
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+}
+
+type UserQuery struct {
+	ListParams
+	UserFilter
+}
+
+type ReviewFilter struct {
+	ProductID *uint
+	UserID    *uint
+	Status    ReviewStatus
+}
+
+type ReviewQuery struct {
+	ListParams
+	ReviewFilter
+}
+
+type fieldKind int
+
+const (
+	kindUint fieldKind = iota
+	kindInt
+	kindFloat
+	kindString
+	kindTime
+)
+
+var (
+	productSortFields = map[string]fieldKind{
+		"id":             kindUint,
+		"name":           kindString,
+		"category":       kindString,
+		"price":          kindFloat,
+		"rating":         kindFloat,
+		"count_in_stock": kindInt,
+		"created_at":     kindTime,
+	}
+	orderSortFields = map[string]fieldKind{
+		"id":          kindUint,
+		"total_price": kindFloat,
+		"created_at":  kindTime,
+	}
+	userSortFields = map[string]fieldKind{
+		"id":         kindUint,
+		"name":       kindString,
+		"email":      kindString,
+		"created_at": kindTime,
+	}
+	reviewSortFields = map[string]fieldKind{
+		"id":         kindUint,
+		"rating":     kindInt,
+		"created_at": kindTime,
+	}
+)
+
+type sortSpec struct {
+	Column string
+	Desc   bool
+	kind   fieldKind
+}
+
+// ListQuery is a list request compiled to SQL fragments with "?"
+// placeholders. Both SQL backends build their statements from it.
+type ListQuery struct {
+	// Filter and FilterArgs select the rows counted in Page.Total.
+	Filter     string
+	FilterArgs []interface{}
+	// After and AfterArgs restrict rows to those following the cursor.
+	After     string
+	AfterArgs []interface{}
+	OrderBy   string
+	Limit     int
+	Offset    int
+
+	sort   []sortSpec
+	values []interface{}
+}
+
+// SelectSQL renders the page query for table with "?" placeholders.
+func (lq *ListQuery) SelectSQL(table string) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	if lq.Filter != "" {
+		where = append(where, lq.Filter)
+		args = append(args, lq.FilterArgs...)
+	}
+	if lq.After != "" {
+		where = append(where, lq.After)
+		args = append(args, lq.AfterArgs...)
+	}
+	query := "SELECT * FROM " + table
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY %s LIMIT %d OFFSET %d", lq.OrderBy, lq.Limit+1, lq.Offset)
+	return query, args
+}
+
+// CountSQL renders the total count query for table with "?" placeholders.
+func (lq *ListQuery) CountSQL(table string) (string, []interface{}) {
+	query := "SELECT COUNT(*) FROM " + table
+	if lq.Filter != "" {
+		query += " WHERE " + lq.Filter
+	}
+	return query, lq.FilterArgs
+}
+
+func (q ProductQuery) Compile() (*ListQuery, error) {
+	lq, err := compileList(q.ListParams, productSortFields)
+	if err != nil {
+		return nil, err
+	}
+	lq.Filter, lq.FilterArgs = q.ProductFilter.where()
+	return lq, nil
+}
+
+func (f ProductFilter) where() (string, []interface{}) {
+	var w whereBuilder
+	if f.Category != "" {
+		w.add("category = ?", f.Category)
+	}
+	if len(f.CategoryIDs) > 0 {
+		args := make([]interface{}, len(f.CategoryIDs))
+		for i, id := range f.CategoryIDs {
+			args[i] = id
+		}
+		w.add("category_id IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")+")", args...)
+	}
+	if f.MinPrice != nil {
+		w.add("price >= ?", *f.MinPrice)
+	}
+	if f.MaxPrice != nil {
+		w.add("price <= ?", *f.MaxPrice)
+	}
+	if f.InStock != nil {
+		if *f.InStock {
+			w.add("count_in_stock > 0")
+		} else {
+			w.add("count_in_stock <= 0")
+		}
+	}
+	w.addTimeRange("created_at", f.CreatedFrom, f.CreatedTo)
+	return w.build()
+}
+
+func (q OrderQuery) Compile() (*ListQuery, error) {
+	lq, err := compileList(q.ListParams, orderSortFields)
+	if err != nil {
+		return nil, err
+	}
+	var w whereBuilder
+	if q.UserID != nil {
+		w.add("user_id = ?", *q.UserID)
+	}
+	if q.Status != "" {
+		w.add("status = ?", q.Status)
+	}
+	if q.PaymentMethod != "" {
+		w.add("payment_method = ?", q.PaymentMethod)
+	}
+	w.addTimeRange("created_at", q.CreatedFrom, q.CreatedTo)
+	lq.Filter, lq.FilterArgs = w.build()
+	return lq, nil
+}
+
+func (q UserQuery) Compile() (*ListQuery, error) {
+	lq, err := compileList(q.ListParams, userSortFields)
+	if err != nil {
+		return nil, err
+	}
+	var w whereBuilder
+	if q.IsAdmin != nil {
+		w.add("is_admin = ?", *q.IsAdmin)
+	}
+	w.addTimeRange("created_at", q.CreatedFrom, q.CreatedTo)
+	lq.Filter, lq.FilterArgs = w.build()
+	return lq, nil
+}
+
+func (q ReviewQuery) Compile() (*ListQuery, error) {
+	lq, err := compileList(q.ListParams, reviewSortFields)
+	if err != nil {
+		return nil, err
+	}
+	var w whereBuilder
+	if q.ProductID != nil {
+		w.add("product_id = ?", *q.ProductID)
+	}
+	if q.UserID != nil {
+		w.add("user_id = ?", *q.UserID)
+	}
+	if q.Status != "" {
+		w.add("status = ?", q.Status)
+	}
+	lq.Filter, lq.FilterArgs = w.build()
+	return lq, nil
+}
+
+func (f ProductFilter) match(p *Product) bool {
+	if f.Category != "" && p.Category != f.Category {
+		return false
+	}
+	if len(f.CategoryIDs) > 0 && (p.CategoryID == nil || !slices.Contains(f.CategoryIDs, *p.CategoryID)) {
+		return false
+	}
+	if f.MinPrice != nil && p.Price < *f.MinPrice {
+		return false
+	}
+	if f.MaxPrice != nil && p.Price > *f.MaxPrice {
+		return false
+	}
+	if f.InStock != nil && *f.InStock != (p.CountInStock > 0) {
+		return false
+	}
+	return inTimeRange(p.CreatedAt, f.CreatedFrom, f.CreatedTo)
+}
+
+func (f OrderFilter) match(o *Order) bool {
+	if f.UserID != nil && (o.UserID == nil || *o.UserID != *f.UserID) {
+		return false
+	}
+	if f.Status != "" && o.Status != f.Status {
+		return false
+	}
+	if f.PaymentMethod != "" && o.PaymentMethod != f.PaymentMethod {
+		return false
+	}
+	return inTimeRange(o.CreatedAt, f.CreatedFrom, f.CreatedTo)
+}
+
+func (f UserFilter) match(u *User) bool {
+	if f.IsAdmin != nil && u.IsAdmin != *f.IsAdmin {
+		return false
+	}
+	return inTimeRange(u.CreatedAt, f.CreatedFrom, f.CreatedTo)
+}
+
+func (f ReviewFilter) match(r *Review) bool {
+	if f.ProductID != nil && r.ProductID != *f.ProductID {
+		return false
+	}
+	if f.UserID != nil && r.UserID != *f.UserID {
+		return false
+	}
+	return f.Status == "" || r.Status == f.Status
+}
+
+func productSortValue(p *Product, column string) interface{} {
+	switch column {
+	case "name":
+		return p.Name
+	case "category":
+		return p.Category
+	case "price":
+		return p.Price
+	case "rating":
+		return p.Rating
+	case "count_in_stock":
+		return int64(p.CountInStock)
+	case "created_at":
+		return p.CreatedAt
+	default:
+		return uint64(p.ID)
+	}
+}
+
+func orderSortValue(o *Order, column string) interface{} {
+	switch column {
+	case "total_price":
+		return o.TotalPrice
+	case "created_at":
+		return o.CreatedAt
+	default:
+		return uint64(o.ID)
+	}
+}
+
+func userSortValue(u *User, column string) interface{} {
+	switch column {
+	case "name":
+		return u.Name
+	case "email":
+		return u.Email
+	case "created_at":
+		return u.CreatedAt
+	default:
+		return uint64(u.ID)
+	}
+}
+
+func reviewSortValue(r *Review, column string) interface{} {
+	switch column {
+	case "rating":
+		return int64(r.Rating)
+	case "created_at":
+		return r.CreatedAt
+	default:
+		return uint64(r.ID)
+	}
+}
+
+// ProductPage, OrderPage, UserPage and ReviewPage trim the limit+1 rows fetched by a
+// backend to the requested size and attach the cursor of the last row.
+func ProductPage(lq *ListQuery, rows []Product, total int64) *Page[Product] {
+	return finishPage(lq, rows, total, productSortValue)
+}
+
+func OrderPage(lq *ListQuery, rows []Order, total int64) *Page[Order] {
+	return finishPage(lq, rows, total, orderSortValue)
+}
+
+func UserPage(lq *ListQuery, rows []User, total int64) *Page[User] {
+	return finishPage(lq, rows, total, userSortValue)
+}
+
+func ReviewPage(lq *ListQuery, rows []Review, total int64) *Page[Review] {
+	return finishPage(lq, rows, total, reviewSortValue)
+}
+
+func finishPage[T any](lq *ListQuery, rows []T, total int64, value func(*T, string) interface{}) *Page[T] {
+	page := &Page[T]{Items: rows, Total: total}
+	if len(rows) > lq.Limit {
+		page.Items = rows[:lq.Limit]
+		last := &page.Items[len(page.Items)-1]
+		values := make([]interface{}, len(lq.sort))
+		for i, s := range lq.sort {
+			values[i] = value(last, s.Column)
+		}
+		page.NextCursor = encodeCursor(lq.sort, values)
+	}
+	if page.Items == nil {
+		page.Items = []T{}
+	}
+	return page
+}
+
+func compileList(p ListParams, fields map[string]fieldKind) (*ListQuery, error) {
+	lq := &ListQuery{Limit: p.Limit}
+	if lq.Limit <= 0 {
+		lq.Limit = DefaultPageSize
+	}
+	if lq.Limit > MaxPageSize {
+		return nil, fmt.Errorf("%w: limit must be at most %d", ErrInvalidQuery, MaxPageSize)
+	}
+	if p.Page < 0 {
+		return nil, fmt.Errorf("%w: page must be positive", ErrInvalidQuery)
+	}
+	if p.Page > 0 && p.Cursor != "" {
+		return nil, fmt.Errorf("%w: page and cursor are mutually exclusive", ErrInvalidQuery)
+	}
+
+	sort, err := parseSort(p.Sort, fields)
+	if err != nil {
+		return nil, err
+	}
+	lq.sort = sort
+
+	order := make([]string, len(sort))
+	for i, s := range sort {
+		dir := "ASC"
+		if s.Desc {
+			dir = "DESC"
+		}
+		order[i] = s.Column + " " + dir
+	}
+	lq.OrderBy = strings.Join(order, ", ")
+
+	if p.Page > 1 {
+		lq.Offset = (p.Page - 1) * lq.Limit
+	}
+	if p.Cursor != "" {
+		values, err := decodeCursor(p.Cursor, sort)
+		if err != nil {
+			return nil, err
+		}
+		lq.values = values
+		lq.After, lq.AfterArgs = keysetCondition(sort, values)
+	}
+	return lq, nil
+}
+
+func parseSort(raw string, fields map[string]fieldKind) ([]sortSpec, error) {
+	var specs []sortSpec
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		desc := strings.HasPrefix(part, "-")
+		column := strings.TrimPrefix(strings.TrimPrefix(part, "-"), "+")
+		kind, ok := fields[column]
+		if !ok {
+			return nil, fmt.Errorf("%w: cannot sort by %q", ErrInvalidQuery, column)
+		}
+		if seen[column] {
+			return nil, fmt.Errorf("%w: %q appears twice in sort", ErrInvalidQuery, column)
+		}
+		seen[column] = true
+		specs = append(specs, sortSpec{Column: column, Desc: desc, kind: kind})
+	}
+	// id breaks ties so keyset pagination never skips or repeats rows
+	if !seen["id"] {
+		specs = append(specs, sortSpec{Column: "id", kind: kindUint})
+	}
+	return specs, nil
+}
+
+// keysetCondition expands (a, b, id) > (va, vb, vid) honouring each column's
+// direction, which row-value comparison cannot do for mixed orderings.
+func keysetCondition(sort []sortSpec, values []interface{}) (string, []interface{}) {
+	var ors []string
+	var args []interface{}
+	for i := range sort {
+		var ands []string
+		for j := 0; j < i; j++ {
+			ands = append(ands, sort[j].Column+" = ?")
+			args = append(args, values[j])
+		}
+		op := ">"
+		if sort[i].Desc {
+			op = "<"
+		}
+		ands = append(ands, sort[i].Column+" "+op+" ?")
+		args = append(args, values[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+type cursorPayload struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+}
+
+func sortSignature(sort []sortSpec) string {
+	parts := make([]string, len(sort))
+	for i, s := range sort {
+		parts[i] = s.Column
+		if s.Desc {
+			parts[i] = "-" + s.Column
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func encodeCursor(sort []sortSpec, values []interface{}) string {
+	encoded := make([]interface{}, len(values))
+	for i, v := range values {
+		if t, ok := v.(time.Time); ok {
+			encoded[i] = t.UTC().Format(time.RFC3339Nano)
+			continue
+		}
+		encoded[i] = v
+	}
+	b, _ := json.Marshal(cursorPayload{Sort: sortSignature(sort), Values: encoded})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(raw string, sort []sortSpec) ([]interface{}, error) {
+	invalid := fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, invalid
+	}
+	var payload cursorPayload
+	dec := json.NewDecoder(strings.NewReader(string(b)))
+	dec.UseNumber()
+	if err := dec.Decode(&payload); err != nil {
+		return nil, invalid
+	}
+	if payload.Sort != sortSignature(sort) {
+		return nil, fmt.Errorf("%w: cursor was issued for a different sort order", ErrInvalidQuery)
+	}
+	if len(payload.Values) != len(sort) {
+		return nil, invalid
+	}
+
+	values := make([]interface{}, len(sort))
+	for i, s := range sort {
+		v := payload.Values[i]
+		switch s.kind {
+		case kindString:
+			str, ok := v.(string)
+			if !ok {
+				return nil, invalid
+			}
+			values[i] = str
+		case kindTime:
+			str, ok := v.(string)
+			if !ok {
+				return nil, invalid
+			}
+			t, err := time.Parse(time.RFC3339Nano, str)
+			if err != nil {
+				return nil, invalid
+			}
+			// stored timestamps carry the local zone, SQLite compares them as text
+			values[i] = t.Local()
+		case kindFloat:
+			n, ok := v.(json.Number)
+			if !ok {
+				return nil, invalid
+			}
+			f, err := n.Float64()
+			if err != nil {
+				return nil, invalid
+			}
+			values[i] = f
+		case kindInt, kindUint:
+			n, ok := v.(json.Number)
+			if !ok {
+				return nil, invalid
+			}
+			i64, err := n.Int64()
+			if err != nil || (s.kind == kindUint && i64 < 0) {
+				return nil, invalid
+			}
+			if s.kind == kindUint {
+				values[i] = uint64(i64)
+			} else {
+				values[i] = i64
+			}
+		}
+	}
+	return values, nil
+}
+
+type whereBuilder struct {
+	clauses []string
+	args    []interface{}
+}
+
+func (w *whereBuilder) add(clause string, args ...interface{}) {
+	w.clauses = append(w.clauses, clause)
+	w.args = append(w.args, args...)
+}
+
+func (w *whereBuilder) addTimeRange(column string, from, to *time.Time) {
+	if from != nil {
+		w.add(column+" >= ?", from.Local())
+	}
+	if to != nil {
+		w.add(column+" <= ?", to.Local())
+	}
+}
+
+func (w *whereBuilder) build() (string, []interface{}) {
+	if len(w.clauses) == 0 {
+		return "", nil
+	}
+	return strings.Join(w.clauses, " AND "), w.args
+}
+
+func inTimeRange(t time.Time, from, to *time.Time) bool {
+	if from != nil && t.Before(*from) {
+		return false
+	}
+	if to != nil && t.After(*to) {
+		return false
+	}
+	return true
+}
+
+// compareSortValues orders two values produced by the *SortValue helpers.
+func compareSortValues(a, b interface{}) int {
+	switch av := a.(type) {
+	case string:
+		return strings.Compare(av, b.(string))
+	case float64:
+		bv := b.(float64)
+		switch {
+		case av < bv:
+			return -1
+		case av > bv:
+			return 1
+		}
+	case int64:
+		bv := b.(int64)
+		switch {
+		case av < bv:
+			return -1
+		case av > bv:
+			return 1
+		}
+	case uint64:
+		bv := b.(uint64)
+		switch {
+		case av < bv:
+			return -1
+		case av > bv:
+			return 1
+		}
+	case time.Time:
+		return av.Compare(b.(time.Time))
+	}
+	return 0
+}
+
+// memoryPage applies a compiled query to rows already filtered in Go.
+func memoryPage[T any](lq *ListQuery, rows []T, value func(*T, string) interface{}) *Page[T] {
+	cmp := func(a, b *T) int {
+		for _, s := range lq.sort {
+			c := compareSortValues(value(a, s.Column), value(b, s.Column))
+			if s.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+	slices.SortFunc(rows, func(a, b T) int { return cmp(&a, &b) })
+	total := int64(len(rows))
+
+	start := 0
+	if lq.values != nil {
+		start = len(rows)
+		for i := range rows {
+			if cmpToValues(lq.sort, &rows[i], lq.values, value) > 0 {
+				start = i
+				break
+			}
+		}
+	} else if lq.Offset > 0 {
+		start = lq.Offset
+	}
+	if start > len(rows) {
+		start = len(rows)
+	}
+	end := start + lq.Limit + 1
+	if end > len(rows) {
+		end = len(rows)
+	}
+	window := make([]T, end-start)
+	copy(window, rows[start:end])
+	return finishPage(lq, window, total, value)
+}
+
+func cmpToValues[T any](sort []sortSpec, row *T, values []interface{}, value func(*T, string) interface{}) int {
+	for i, s := range sort {
+		c := compareSortValues(value(row, s.Column), values[i])
+		if s.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
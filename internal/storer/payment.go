@@ -0,0 +1,64 @@
+package storer
+
+import (
+	"fmt"
+	"time"
+)
+
+type PaymentStatus string
+
+const (
+	PaymentPending  PaymentStatus = "pending"
+	PaymentCaptured PaymentStatus = "captured"
+	PaymentFailed   PaymentStatus = "failed"
+	PaymentRefunded PaymentStatus = "refunded"
+)
+
+// Payment is one attempt to pay an order through a provider. Only a
+// captured payment moves its order to paid; failed attempts are kept for
+// the record.
+type Payment struct {
+	ID        uint      `gorm:"primaryKey" db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+	OrderID   uint      `gorm:"not null;index" db:"order_id"`
+	Provider  string    `gorm:"not null;uniqueIndex:idx_payments_provider_intent;type:varchar(32)" db:"provider"`
+	// IntentID is the provider's reference for the payment, a Stripe
+	// PaymentIntent or a PayPal order.
+	IntentID string `gorm:"not null;uniqueIndex:idx_payments_provider_intent;type:varchar(255)" db:"intent_id"`
+	// CaptureID is empty until the payment is captured.
+	CaptureID     string        `gorm:"not null" db:"capture_id"`
+	Status        PaymentStatus `gorm:"not null" db:"status"`
+	Amount        float64       `gorm:"not null;type:decimal(10,2)" db:"amount"`
+	Currency      string        `gorm:"not null;type:varchar(3)" db:"currency"`
+	FailureReason string        `gorm:"not null" db:"failure_reason"`
+}
+
+// CaptureStatusChange is the transition a captured payment makes on its
+// order.
+func CaptureStatusChange(p *Payment) StatusChange {
+	return StatusChange{To: OrderPaid, Note: fmt.Sprintf("payment captured by %s (%s)", p.Provider, p.CaptureID)}
+}
+
+// notInStatus is the error for settling a payment that is not in status
+// from.
+func notInStatus(from PaymentStatus) error {
+	if from == PaymentCaptured {
+		return ErrPaymentNotCaptured
+	}
+	return ErrPaymentNotPending
+}
+
+// WebhookEvent records a provider notification that has been handled, so
+// a redelivery of the same event is recognised and skipped.
+type WebhookEvent struct {
+	ID        uint      `gorm:"primaryKey" db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	Provider  string    `gorm:"not null;uniqueIndex:idx_webhook_events_provider_event;type:varchar(32)" db:"provider"`
+	EventID   string    `gorm:"not null;uniqueIndex:idx_webhook_events_provider_event;type:varchar(255)" db:"event_id"`
+	Type      string    `gorm:"not null" db:"type"`
+	// PaymentID is nil for events that matched no payment.
+	PaymentID *uint `db:"payment_id"`
+	// Outcome says what handling the event did, e.g. "captured" or "ignored".
+	Outcome string `gorm:"not null" db:"outcome"`
+}
@@ -0,0 +1,185 @@
+package storer
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+type PromotionKind string
+
+const (
+	// PromotionPercent takes Value percent off the items in scope.
+	PromotionPercent PromotionKind = "percent"
+	// PromotionFixed takes the amount Value off the items in scope.
+	PromotionFixed PromotionKind = "fixed"
+	// PromotionFreeShipping waives the shipping price.
+	PromotionFreeShipping PromotionKind = "free_shipping"
+	// PromotionBuyXGetY takes Value percent off GetQuantity of every
+	// BuyQuantity + GetQuantity items in scope, the cheapest first.
+	PromotionBuyXGetY PromotionKind = "buy_x_get_y"
+)
+
+func (k PromotionKind) Valid() bool {
+	switch k {
+	case PromotionPercent, PromotionFixed, PromotionFreeShipping, PromotionBuyXGetY:
+		return true
+	}
+	return false
+}
+
+// Promotion is a discount rule. Promotions with a Code are coupons the
+// buyer has to enter; the others apply by themselves to every order that
+// qualifies.
+type Promotion struct {
+	ID        uint      `gorm:"primaryKey" db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+	Name      string    `gorm:"not null" db:"name"`
+	// Code is stored upper case and matched case-insensitively.
+	Code        *string        `gorm:"uniqueIndex;type:varchar(64)" db:"code"`
+	Kind        PromotionKind  `gorm:"not null;type:varchar(16)" db:"kind"`
+	Value       float64        `gorm:"not null;type:decimal(10,2)" db:"value"`
+	BuyQuantity int            `gorm:"not null;default:0" db:"buy_quantity"`
+	GetQuantity int            `gorm:"not null;default:0" db:"get_quantity"`
+	MinOrder    float64        `gorm:"not null;default:0;type:decimal(10,2)" db:"min_order"`
+	Scope       PromotionScope `gorm:"type:text" db:"scope"`
+	// StartsAt and EndsAt bound when the promotion applies; nil is open.
+	StartsAt *time.Time `db:"starts_at"`
+	EndsAt   *time.Time `db:"ends_at"`
+	// UsageLimit caps the orders the promotion is redeemed on and
+	// PerUserLimit the orders of one buyer; 0 is no limit.
+	UsageLimit   int  `gorm:"not null;default:0" db:"usage_limit"`
+	PerUserLimit int  `gorm:"not null;default:0" db:"per_user_limit"`
+	UsedCount    int  `gorm:"not null;default:0" db:"used_count"`
+	IsActive     bool `gorm:"not null" db:"is_active"`
+}
+
+// Running reports whether the promotion is active and inside its validity
+// window at now.
+func (p *Promotion) Running(now time.Time) bool {
+	return p.IsActive &&
+		(p.StartsAt == nil || !now.Before(*p.StartsAt)) &&
+		(p.EndsAt == nil || now.Before(*p.EndsAt))
+}
+
+// UsedUp reports whether the promotion reached its usage limit.
+func (p *Promotion) UsedUp() bool {
+	return p.UsageLimit > 0 && p.UsedCount >= p.UsageLimit
+}
+
+// PromotionScope limits a promotion to some products and categories. The
+// empty scope covers every item.
+type PromotionScope struct {
+	ProductIDs []uint   `json:"product_ids,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+}
+
+func (s PromotionScope) IsZero() bool {
+	return len(s.ProductIDs) == 0 && len(s.Categories) == 0
+}
+
+// Includes reports whether an item of the product and category is in scope.
+func (s PromotionScope) Includes(productID uint, category string) bool {
+	return s.IsZero() || slices.Contains(s.ProductIDs, productID) || slices.Contains(s.Categories, category)
+}
+
+func (s PromotionScope) Value() (driver.Value, error) {
+	if s.IsZero() {
+		return nil, nil
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding promotion scope: %w", err)
+	}
+	return string(b), nil
+}
+
+func (s *PromotionScope) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*s = PromotionScope{}
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into PromotionScope", src)
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return fmt.Errorf("error decoding promotion scope: %w", err)
+	}
+	return nil
+}
+
+// PromotionRedemption is one use of a promotion, by a user or, for guest
+// orders, an email address.
+type PromotionRedemption struct {
+	ID          uint      `gorm:"primaryKey" db:"id"`
+	CreatedAt   time.Time `db:"created_at"`
+	PromotionID uint      `gorm:"not null;index" db:"promotion_id"`
+	OrderID     uint      `gorm:"not null" db:"order_id"`
+	UserID      *uint     `db:"user_id"`
+	Email       string    `gorm:"not null" db:"email"`
+}
+
+// Redeemer identifies the buyer per-user limits count against: the user,
+// or the email address of a guest.
+type Redeemer struct {
+	UserID *uint
+	Email  string
+}
+
+func (r Redeemer) matches(rd PromotionRedemption) bool {
+	if r.UserID != nil {
+		return rd.UserID != nil && *rd.UserID == *r.UserID
+	}
+	return rd.UserID == nil && rd.Email == r.Email
+}
+
+// OrderDiscount is a promotion applied to an order, copied onto it when it
+// is placed.
+type OrderDiscount struct {
+	PromotionID uint          `json:"promotion_id"`
+	Code        string        `json:"code,omitempty"`
+	Name        string        `json:"name"`
+	Kind        PromotionKind `json:"kind"`
+	Amount      float64       `json:"amount"`
+}
+
+// OrderDiscounts is stored as JSON next to the order.
+type OrderDiscounts []OrderDiscount
+
+func (d OrderDiscounts) Value() (driver.Value, error) {
+	if len(d) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding order discounts: %w", err)
+	}
+	return string(b), nil
+}
+
+func (d *OrderDiscounts) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*d = nil
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into OrderDiscounts", src)
+	}
+	if err := json.Unmarshal(b, d); err != nil {
+		return fmt.Errorf("error decoding order discounts: %w", err)
+	}
+	return nil
+}
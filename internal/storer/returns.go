@@ -0,0 +1,155 @@
+package storer
+
+import (
+	"fmt"
+	"time"
+)
+
+type ReturnReason string
+
+const (
+	ReturnDamaged        ReturnReason = "damaged"
+	ReturnWrongItem      ReturnReason = "wrong_item"
+	ReturnNotAsDescribed ReturnReason = "not_as_described"
+	ReturnNoLongerNeeded ReturnReason = "no_longer_needed"
+	ReturnReasonOther    ReturnReason = "other"
+)
+
+func (r ReturnReason) Valid() bool {
+	switch r {
+	case ReturnDamaged, ReturnWrongItem, ReturnNotAsDescribed, ReturnNoLongerNeeded, ReturnReasonOther:
+		return true
+	}
+	return false
+}
+
+type ReturnStatus string
+
+const (
+	ReturnRequested ReturnStatus = "requested"
+	ReturnApproved  ReturnStatus = "approved"
+	ReturnRejected  ReturnStatus = "rejected"
+	ReturnReceived  ReturnStatus = "received"
+	ReturnRefunded  ReturnStatus = "refunded"
+)
+
+// returnTransitions lists the statuses each return status may move to.
+// Rejected and refunded are final.
+var returnTransitions = map[ReturnStatus][]ReturnStatus{
+	ReturnRequested: {ReturnApproved, ReturnRejected},
+	ReturnApproved:  {ReturnReceived},
+	ReturnReceived:  {ReturnRefunded},
+	ReturnRejected:  nil,
+	ReturnRefunded:  nil,
+}
+
+func (s ReturnStatus) CanTransitionTo(next ReturnStatus) bool {
+	for _, allowed := range returnTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// ReturnRequest asks to send back part or all of one order item.
+type ReturnRequest struct {
+	ID          uint         `gorm:"primaryKey" db:"id"`
+	CreatedAt   time.Time    `db:"created_at"`
+	UpdatedAt   time.Time    `db:"updated_at"`
+	OrderID     uint         `gorm:"not null;index" db:"order_id"`
+	OrderItemID uint         `gorm:"not null;index" db:"order_item_id"`
+	Quantity    int          `gorm:"not null" db:"quantity"`
+	Reason      ReturnReason `gorm:"not null;type:varchar(32)" db:"reason"`
+	Comment     string       `gorm:"not null" db:"comment"`
+	Status      ReturnStatus `gorm:"not null;type:varchar(16)" db:"status"`
+	// ReviewedBy is the admin that last moved the return along.
+	ReviewedBy *uint  `db:"reviewed_by"`
+	ReviewNote string `gorm:"not null" db:"review_note"`
+}
+
+// ReturnChange is a requested transition of a return.
+type ReturnChange struct {
+	To        ReturnStatus
+	ChangedBy *uint
+	Note      string
+}
+
+type InvalidReturnTransitionError struct {
+	From ReturnStatus
+	To   ReturnStatus
+}
+
+func (e *InvalidReturnTransitionError) Error() string {
+	return fmt.Sprintf("cannot change return status from %s to %s", e.From, e.To)
+}
+
+func (e *InvalidReturnTransitionError) Is(target error) bool {
+	return target == ErrInvalidReturnTransition
+}
+
+func CheckReturnTransition(from ReturnStatus, c ReturnChange) error {
+	if !from.CanTransitionTo(c.To) {
+		return &InvalidReturnTransitionError{From: from, To: c.To}
+	}
+	return nil
+}
+
+// ReturnedQuantity is how many of the order item the returns take back,
+// leaving out rejected ones.
+func ReturnedQuantity(returns []ReturnRequest, orderItemID uint) int {
+	n := 0
+	for _, r := range returns {
+		if r.OrderItemID == orderItemID && r.Status != ReturnRejected {
+			n += r.Quantity
+		}
+	}
+	return n
+}
+
+type RefundStatus string
+
+const (
+	RefundPending   RefundStatus = "pending"
+	RefundSucceeded RefundStatus = "succeeded"
+	RefundFailed    RefundStatus = "failed"
+)
+
+// Refund is money paid back on an order, for a return or as a goodwill or
+// full refund. Pending and succeeded refunds count towards the order's
+// RefundedAmount, so an order is never refunded more than it cost.
+type Refund struct {
+	ID        uint         `gorm:"primaryKey" db:"id"`
+	CreatedAt time.Time    `db:"created_at"`
+	UpdatedAt time.Time    `db:"updated_at"`
+	OrderID   uint         `gorm:"not null;index" db:"order_id"`
+	ReturnID  *uint        `gorm:"index" db:"return_id"`
+	PaymentID *uint        `db:"payment_id"`
+	Amount    float64      `gorm:"not null;type:decimal(10,2)" db:"amount"`
+	Reason    string       `gorm:"not null" db:"reason"`
+	Status    RefundStatus `gorm:"not null;type:varchar(16)" db:"status"`
+	// ProviderRefundID is empty for refunds paid out by hand.
+	ProviderRefundID string `gorm:"not null" db:"provider_refund_id"`
+	FailureReason    string `gorm:"not null" db:"failure_reason"`
+	CreatedBy        *uint  `db:"created_by"`
+}
+
+// refundTolerance absorbs the rounding of amounts summed as floats.
+const refundTolerance = 0.005
+
+// ExceedsTotal reports whether refunding amount on top of refunded would pay
+// back more than total.
+func ExceedsTotal(refunded, amount, total float64) bool {
+	return refunded+amount > total+refundTolerance
+}
+
+// FullyRefunded reports whether refunds of refunded cover total.
+func FullyRefunded(refunded, total float64) bool {
+	return refunded >= total-refundTolerance
+}
+
+// FullRefundStatusChange is the transition an order makes once its refunds
+// cover its total.
+func FullRefundStatusChange(r *Refund) StatusChange {
+	return StatusChange{To: OrderRefunded, ChangedBy: r.CreatedBy, Note: fmt.Sprintf("fully refunded (refund %d)", r.ID)}
+}
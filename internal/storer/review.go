@@ -0,0 +1,47 @@
+package storer
+
+import (
+	"math"
+	"time"
+)
+
+type ReviewStatus string
+
+const (
+	ReviewPending  ReviewStatus = "pending"
+	ReviewApproved ReviewStatus = "approved"
+	ReviewRejected ReviewStatus = "rejected"
+)
+
+func (s ReviewStatus) Valid() bool {
+	switch s {
+	case ReviewPending, ReviewApproved, ReviewRejected:
+		return true
+	}
+	return false
+}
+
+// Review is what a customer thinks of a product they bought. Only approved
+// reviews are shown and count towards the product's Rating and NumReviews.
+type Review struct {
+	ID        uint         `gorm:"primaryKey" db:"id"`
+	CreatedAt time.Time    `db:"created_at"`
+	UpdatedAt time.Time    `db:"updated_at"`
+	ProductID uint         `gorm:"not null;uniqueIndex:idx_reviews_product_user" db:"product_id"`
+	UserID    uint         `gorm:"not null;uniqueIndex:idx_reviews_product_user;index" db:"user_id"`
+	Rating    int          `gorm:"not null" db:"rating"`
+	Title     string       `gorm:"not null" db:"title"`
+	Body      string       `gorm:"type:text;not null" db:"body"`
+	Status    ReviewStatus `gorm:"not null;type:varchar(16);index" db:"status"`
+	// ModeratedBy is the admin that last approved or rejected the review.
+	ModeratedBy *uint `db:"moderated_by"`
+}
+
+// averageRating rounds the mean of the approved ratings to the two decimals
+// Product.Rating keeps.
+func averageRating(sum, count int) float64 {
+	if count == 0 {
+		return 0
+	}
+	return math.Round(float64(sum)/float64(count)*100) / 100
+}
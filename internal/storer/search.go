@@ -0,0 +1,110 @@
+package storer
+
+import (
+	"fmt"
+
+	"ecom_apiv1/internal/search"
+)
+
+// snippetLength is the maximum number of runes in a description snippet.
+const snippetLength = 160
+
+type ProductSearchQuery struct {
+	Query string
+	Limit int
+	Page  int
+}
+
+type ProductHit struct {
+	Product Product
+	Score   float64
+	// Highlights maps a field name (name, category, description) to an
+	// HTML-escaped snippet with matches wrapped in <mark> tags.
+	Highlights map[string]string
+}
+
+type SearchResult struct {
+	Hits  []ProductHit
+	Total int64
+}
+
+// Bounds validates the query and returns its limit and offset.
+func (q ProductSearchQuery) Bounds() (limit, offset int, err error) {
+	if len(search.Terms(q.Query)) == 0 {
+		return 0, 0, fmt.Errorf("%w: search query must contain a word", ErrInvalidQuery)
+	}
+	limit = q.Limit
+	switch {
+	case limit == 0:
+		limit = DefaultPageSize
+	case limit < 0 || limit > MaxPageSize:
+		return 0, 0, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidQuery, MaxPageSize)
+	}
+	if q.Page < 0 {
+		return 0, 0, fmt.Errorf("%w: page must be positive", ErrInvalidQuery)
+	}
+	if q.Page > 1 {
+		offset = (q.Page - 1) * limit
+	}
+	return limit, offset, nil
+}
+
+func newProductIndex() *search.Index {
+	return search.NewIndex(map[string]float64{"name": 3, "category": 2, "description": 1})
+}
+
+func productSearchFields(p *Product) map[string]string {
+	return map[string]string{"name": p.Name, "category": p.Category, "description": p.Description}
+}
+
+func productHighlights(p *Product, terms []string) map[string]string {
+	out := make(map[string]string)
+	for field, text := range productSearchFields(p) {
+		maxLen := 0
+		if field == "description" {
+			maxLen = snippetLength
+		}
+		if s := search.Highlight(text, terms, maxLen); s != "" {
+			out[field] = s
+		}
+	}
+	return out
+}
+
+// NewProductHit scores a row ranked by a database and highlights the words
+// the query matched.
+func NewProductHit(query string, p Product, score float64) ProductHit {
+	terms := search.MatchTerms(query, p.Name, p.Category, p.Description)
+	return ProductHit{Product: p, Score: score, Highlights: productHighlights(&p, terms)}
+}
+
+// searchIndex runs q against ix and loads the page of hits with get.
+func searchIndex(ix *search.Index, q ProductSearchQuery, get func(ids []uint) (map[uint]Product, error)) (*SearchResult, error) {
+	limit, offset, err := q.Bounds()
+	if err != nil {
+		return nil, err
+	}
+	hits := ix.Search(q.Query)
+	res := &SearchResult{Hits: []ProductHit{}, Total: int64(len(hits))}
+	if offset >= len(hits) {
+		return res, nil
+	}
+	hits = hits[offset:min(offset+limit, len(hits))]
+
+	ids := make([]uint, len(hits))
+	for i, h := range hits {
+		ids[i] = h.ID
+	}
+	products, err := get(ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range hits {
+		p, ok := products[h.ID]
+		if !ok {
+			continue
+		}
+		res.Hits = append(res.Hits, ProductHit{Product: p, Score: h.Score, Highlights: productHighlights(&p, h.Terms)})
+	}
+	return res, nil
+}
@@ -0,0 +1,118 @@
+package storer
+
+import (
+	"fmt"
+	"time"
+)
+
+type OrderStatus string
+
+const (
+	OrderPending    OrderStatus = "pending"
+	OrderPaid       OrderStatus = "paid"
+	OrderProcessing OrderStatus = "processing"
+	OrderShipped    OrderStatus = "shipped"
+	OrderDelivered  OrderStatus = "delivered"
+	OrderCancelled  OrderStatus = "cancelled"
+	OrderRefunded   OrderStatus = "refunded"
+)
+
+// orderTransitions lists the statuses each status may move to. Cancelled
+// and refunded are final. Only unpaid orders are cancelled; once paid, an
+// order is called off by refunding it.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderPending:    {OrderPaid, OrderCancelled},
+	OrderPaid:       {OrderProcessing, OrderRefunded},
+	OrderProcessing: {OrderShipped, OrderRefunded},
+	OrderShipped:    {OrderDelivered},
+	OrderDelivered:  {OrderRefunded},
+	OrderCancelled:  nil,
+	OrderRefunded:   nil,
+}
+
+func (s OrderStatus) Valid() bool {
+	_, ok := orderTransitions[s]
+	return ok
+}
+
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, allowed := range orderTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// HoldsStock reports whether the order's items are reserved but have not
+// left the warehouse, i.e. whether cancelling it returns them to stock.
+func (s OrderStatus) HoldsStock() bool {
+	return s == OrderPending || s == OrderPaid || s == OrderProcessing
+}
+
+// StockHoldingStatuses are the statuses HoldsStock reports, for queries.
+var StockHoldingStatuses = []OrderStatus{OrderPending, OrderPaid, OrderProcessing}
+
+// Deletable reports whether orders in the status may be deleted. Orders
+// that were paid for stay on record; they are refunded instead.
+func (s OrderStatus) Deletable() bool {
+	return s == OrderPending || s == OrderCancelled
+}
+
+// ReleasesStock reports whether moving from one status to another puts the
+// order's items back into stock.
+func ReleasesStock(from, to OrderStatus) bool {
+	return from.HoldsStock() && (to == OrderCancelled || to == OrderRefunded)
+}
+
+// OrderStatusEvent is one entry of an order's timeline. The first event of
+// every order has an empty FromStatus.
+type OrderStatusEvent struct {
+	ID         uint        `gorm:"primaryKey" db:"id"`
+	CreatedAt  time.Time   `db:"created_at"`
+	OrderID    uint        `gorm:"not null" db:"order_id"`
+	FromStatus OrderStatus `gorm:"not null" db:"from_status"`
+	ToStatus   OrderStatus `gorm:"not null" db:"to_status"`
+	// ChangedBy is the user that made the change, nil for the system.
+	ChangedBy *uint  `db:"changed_by"`
+	Note      string `gorm:"not null" db:"note"`
+}
+
+// StatusChange is a requested transition of an order.
+type StatusChange struct {
+	To        OrderStatus
+	ChangedBy *uint
+	Note      string
+}
+
+type InvalidTransitionError struct {
+	From OrderStatus
+	To   OrderStatus
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("cannot change order status from %s to %s", e.From, e.To)
+}
+
+func (e *InvalidTransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+// CheckTransition validates a change from the order's current status.
+func CheckTransition(from OrderStatus, c StatusChange) error {
+	if !c.To.Valid() || !from.CanTransitionTo(c.To) {
+		return &InvalidTransitionError{From: from, To: c.To}
+	}
+	return nil
+}
+
+func NewStatusEvent(orderID uint, from OrderStatus, c StatusChange, at time.Time) OrderStatusEvent {
+	return OrderStatusEvent{
+		CreatedAt:  at,
+		OrderID:    orderID,
+		FromStatus: from,
+		ToStatus:   c.To,
+		ChangedBy:  c.ChangedBy,
+		Note:       c.Note,
+	}
+}
@@ -0,0 +1,70 @@
+package storer
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// StockShortage is a product, or a variant of it, that cannot cover the
+// quantity ordered.
+type StockShortage struct {
+	ProductID uint
+	// VariantID is zero for products not sold by variant.
+	VariantID uint
+	Requested int
+	Available int
+}
+
+// InsufficientStockError lists every product of an order that is short on
+// stock. It matches ErrInsufficientStock with errors.Is.
+type InsufficientStockError struct {
+	Items []StockShortage
+}
+
+func (e *InsufficientStockError) Error() string {
+	parts := make([]string, len(e.Items))
+	for i, s := range e.Items {
+		if s.VariantID != 0 {
+			parts[i] = fmt.Sprintf("product %d variant %d (requested %d, available %d)", s.ProductID, s.VariantID, s.Requested, s.Available)
+			continue
+		}
+		parts[i] = fmt.Sprintf("product %d (requested %d, available %d)", s.ProductID, s.Requested, s.Available)
+	}
+	return "insufficient stock: " + strings.Join(parts, ", ")
+}
+
+func (e *InsufficientStockError) Is(target error) bool {
+	return target == ErrInsufficientStock
+}
+
+type StockLine struct {
+	ProductID uint
+	// VariantID is zero for items without a variant.
+	VariantID uint
+	Quantity  int
+}
+
+// StockDemand sums item quantities per product and variant. Lines come back
+// ordered by product and variant ID so concurrent orders lock rows in the
+// same order and cannot deadlock each other.
+func StockDemand(items []OrderItem) []StockLine {
+	type key struct{ product, variant uint }
+	totals := make(map[key]int, len(items))
+	for _, item := range items {
+		k := key{product: item.ProductID}
+		if item.VariantID != nil {
+			k.variant = *item.VariantID
+		}
+		totals[k] += item.Quantity
+	}
+	lines := make([]StockLine, 0, len(totals))
+	for k, qty := range totals {
+		lines = append(lines, StockLine{ProductID: k.product, VariantID: k.variant, Quantity: qty})
+	}
+	slices.SortFunc(lines, func(a, b StockLine) int {
+		return cmp.Or(cmp.Compare(a.ProductID, b.ProductID), cmp.Compare(a.VariantID, b.VariantID))
+	})
+	return lines
+}
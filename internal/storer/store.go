@@ -0,0 +1,220 @@
+package storer
+
+import (
+	"context"
+	"time"
+)
+
+// Store is the persistence contract used by server.Server. GORMStorage,
+// storerpq.PostgresStorage and MemoryStorage all implement it.
+type Store interface {
+	CreateProduct(ctx context.Context, p *Product) (*Product, error)
+	GetProduct(ctx context.Context, id uint) (*Product, error)
+	// GetProducts returns the products with the given IDs ordered by ID;
+	// unknown IDs are skipped.
+	GetProducts(ctx context.Context, ids []uint) ([]Product, error)
+	ListProducts(ctx context.Context, q ProductQuery) (*Page[Product], error)
+	SearchProducts(ctx context.Context, q ProductSearchQuery) (*SearchResult, error)
+	GetProductFacets(ctx context.Context, f ProductFilter) (*ProductFacets, error)
+	// UpdateProduct replaces a product except for its Rating and
+	// NumReviews, which follow its reviews.
+	UpdateProduct(ctx context.Context, p *Product) (*Product, error)
+	DeleteProduct(ctx context.Context, id uint) error
+
+	// CreateVariant adds a variant to its product, whose stock grows by
+	// the variant's. It fails with ErrProductNotFound or ErrSKUTaken.
+	CreateVariant(ctx context.Context, v *ProductVariant) (*ProductVariant, error)
+	GetVariant(ctx context.Context, id uint) (*ProductVariant, error)
+	// GetVariants returns the variants with the given IDs ordered by ID;
+	// unknown IDs are skipped.
+	GetVariants(ctx context.Context, ids []uint) ([]ProductVariant, error)
+	// ListVariants returns the variants of a product ordered by ID.
+	ListVariants(ctx context.Context, productID uint) ([]ProductVariant, error)
+	// UpdateVariant replaces a variant, keeping the product it belongs to,
+	// and moves the product stock by the change in the variant's.
+	UpdateVariant(ctx context.Context, v *ProductVariant) (*ProductVariant, error)
+	// DeleteVariant removes a variant and its stock from the product. It
+	// fails with ErrVariantInUse while orders holding stock of the variant
+	// are open, since cancelling them would return it to no variant.
+	DeleteVariant(ctx context.Context, id uint) error
+
+	// CreateProductImage adds an image after the last one of its product.
+	// It fails with ErrProductNotFound.
+	CreateProductImage(ctx context.Context, img *ProductImage) (*ProductImage, error)
+	GetProductImage(ctx context.Context, id uint) (*ProductImage, error)
+	// ListProductImages returns the images of a product ordered by
+	// position, then ID.
+	ListProductImages(ctx context.Context, productID uint) ([]ProductImage, error)
+	// UpdateProductImage replaces the alt text of an image.
+	UpdateProductImage(ctx context.Context, img *ProductImage) (*ProductImage, error)
+	// ReorderProductImages puts the images of a product in the order of
+	// ids. It fails with ErrInvalidImageOrder unless ids names each of
+	// them exactly once.
+	ReorderProductImages(ctx context.Context, productID uint, ids []uint) ([]ProductImage, error)
+	DeleteProductImage(ctx context.Context, id uint) error
+
+	// CreateCategory fails with ErrCategoryNotFound when the parent does not
+	// exist and with ErrCategorySlugTaken.
+	CreateCategory(ctx context.Context, c *Category) (*Category, error)
+	GetCategory(ctx context.Context, id uint) (*Category, error)
+	GetCategoryBySlug(ctx context.Context, slug string) (*Category, error)
+	// ListCategories returns every category ordered by position, then name.
+	ListCategories(ctx context.Context) ([]Category, error)
+	// UpdateCategory replaces a category and renames its products along.
+	UpdateCategory(ctx context.Context, c *Category) (*Category, error)
+	// DeleteCategory moves the products of the category to moveTo, if set,
+	// and deletes it. It fails with ErrCategoryInUse when the category has
+	// subcategories, or products and no moveTo.
+	DeleteCategory(ctx context.Context, id uint, moveTo *uint) error
+
+	// CreateReview fails with ErrProductNotFound, and with ErrReviewExists
+	// when the user already reviewed the product. Every review write
+	// recomputes the product's Rating and NumReviews from its approved
+	// reviews in the same transaction.
+	CreateReview(ctx context.Context, r *Review) (*Review, error)
+	GetReview(ctx context.Context, id uint) (*Review, error)
+	ListReviews(ctx context.Context, q ReviewQuery) (*Page[Review], error)
+	// UpdateReview replaces the rating, text, status and moderator of a
+	// review.
+	UpdateReview(ctx context.Context, r *Review) (*Review, error)
+	DeleteReview(ctx context.Context, id uint) error
+	// HasDeliveredOrder reports whether one of the user's delivered orders
+	// has the product in it.
+	HasDeliveredOrder(ctx context.Context, userID, productID uint) (bool, error)
+
+	// CreateOrder stores o, takes its items out of stock and redeems the
+	// promotions in o.Discounts. Items with a variant come out of the
+	// variant's stock as well as the product's. It fails with
+	// ErrVariantNotFound when a variant is not of the item's product, and
+	// with ErrPromotionUsedUp or ErrPromotionUserLimit when a promotion
+	// reached one of its limits.
+	CreateOrder(ctx context.Context, o *Order) (*Order, error)
+	GetOrderByID(ctx context.Context, id uint) (*Order, error)
+	GetOrderByAccessToken(ctx context.Context, tokenHash string) (*Order, error)
+	// ClaimOrder moves the guest order with the given access token to the
+	// user and clears the token. Claimed orders are not found again.
+	ClaimOrder(ctx context.Context, tokenHash string, userID uint) (*Order, error)
+	ListOrders(ctx context.Context, q OrderQuery) (*Page[Order], error)
+	// DeleteOrder removes a pending or cancelled order and everything
+	// recorded about it. Other orders, and orders with a captured payment,
+	// fail with ErrOrderNotDeletable.
+	DeleteOrder(ctx context.Context, id uint) error
+	// UpdateOrderStatus applies a transition allowed by the status table and
+	// records it in the order's timeline. Cancelling or refunding an order
+	// that has not shipped returns its items to stock.
+	UpdateOrderStatus(ctx context.Context, id uint, c StatusChange) (*Order, error)
+	ListOrderStatusEvents(ctx context.Context, orderID uint) ([]OrderStatusEvent, error)
+
+	// CreatePayment records a new pending payment for an order.
+	CreatePayment(ctx context.Context, p *Payment) (*Payment, error)
+	GetPayment(ctx context.Context, id uint) (*Payment, error)
+	GetPaymentByIntent(ctx context.Context, provider, intentID string) (*Payment, error)
+	ListPayments(ctx context.Context, orderID uint) ([]Payment, error)
+	// CapturePayment marks a pending payment captured and moves its order to
+	// paid in one transaction; neither happens if the order cannot be paid.
+	CapturePayment(ctx context.Context, id uint, captureID string) (*Payment, error)
+	FailPayment(ctx context.Context, id uint, reason string) (*Payment, error)
+
+	GetWebhookEvent(ctx context.Context, provider, eventID string) (*WebhookEvent, error)
+	// CreateWebhookEvent fails with ErrWebhookEventExists when the provider's
+	// event has already been recorded.
+	CreateWebhookEvent(ctx context.Context, e *WebhookEvent) (*WebhookEvent, error)
+
+	// CreateReturn opens a return for part of an order item. It fails with
+	// ErrReturnQuantityExceeded when more would come back than was ordered.
+	CreateReturn(ctx context.Context, r *ReturnRequest) (*ReturnRequest, error)
+	GetReturn(ctx context.Context, id uint) (*ReturnRequest, error)
+	ListReturns(ctx context.Context, orderID uint) ([]ReturnRequest, error)
+	// UpdateReturnStatus applies a transition allowed by the return status
+	// table. Receiving a return puts its items back into stock.
+	UpdateReturnStatus(ctx context.Context, id uint, c ReturnChange) (*ReturnRequest, error)
+
+	// CreateRefund records a pending refund and adds it to the order's
+	// RefundedAmount. It fails with ErrRefundExceedsTotal when the order
+	// would be refunded more than it cost, and with ErrReturnNotRefundable
+	// for a return that is not received or already has a refund.
+	CreateRefund(ctx context.Context, r *Refund) (*Refund, error)
+	// SubmitRefund records the provider's ID for a pending refund the
+	// provider is still paying out. The refund stays pending until
+	// CompleteRefund or FailRefund settles it.
+	SubmitRefund(ctx context.Context, id uint, providerRefundID string) (*Refund, error)
+	// CompleteRefund marks a pending refund succeeded. A refund for a return
+	// moves the return to refunded; once the order's succeeded refunds cover
+	// its total, its captured payment and the order become refunded.
+	CompleteRefund(ctx context.Context, id uint, providerRefundID string) (*Refund, error)
+	// FailRefund marks a pending refund failed and takes it off the order's
+	// RefundedAmount.
+	FailRefund(ctx context.Context, id uint, reason string) (*Refund, error)
+	ListRefunds(ctx context.Context, orderID uint) ([]Refund, error)
+
+	// CreateIdempotencyKey claims a key for a request. It fails with
+	// ErrIdempotencyKeyExists when the scope already holds the key.
+	CreateIdempotencyKey(ctx context.Context, k *IdempotencyKey) (*IdempotencyKey, error)
+	GetIdempotencyKey(ctx context.Context, scope, key string) (*IdempotencyKey, error)
+	// CompleteIdempotencyKey stores the response to the request that
+	// claimed the key and keeps it until expiresAt.
+	CompleteIdempotencyKey(ctx context.Context, id uint, statusCode int, contentType string, body []byte, expiresAt time.Time) error
+	DeleteIdempotencyKey(ctx context.Context, id uint) error
+	// DeleteExpiredIdempotencyKeys removes the keys that expired before now
+	// and returns how many there were.
+	DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error)
+
+	// CreateCart fails with ErrCartAlreadyExists when the user or guest token
+	// already has a cart.
+	CreateCart(ctx context.Context, c *Cart) (*Cart, error)
+	GetUserCart(ctx context.Context, userID uint) (*Cart, error)
+	GetGuestCart(ctx context.Context, tokenHash string) (*Cart, error)
+	// AddCartItem adds quantity to the line of the product and variant,
+	// creating it if needed. variantID is nil for products not sold by
+	// variant; a variant of another product is ErrVariantNotFound.
+	AddCartItem(ctx context.Context, cartID, productID uint, variantID *uint, quantity int) (*Cart, error)
+	// SetCartItem replaces the quantity of the line of the product and variant.
+	SetCartItem(ctx context.Context, cartID, productID uint, variantID *uint, quantity int) (*Cart, error)
+	RemoveCartItem(ctx context.Context, cartID, productID uint, variantID *uint) (*Cart, error)
+	// MergeCarts moves every line of cart fromID into cart intoID, adding up
+	// quantities of lines found in both, and deletes cart fromID.
+	MergeCarts(ctx context.Context, fromID, intoID uint) (*Cart, error)
+
+	CreateUser(ctx context.Context, u *User) (*User, error)
+	GetUser(ctx context.Context, email string) (*User, error)
+	ListUsers(ctx context.Context, q UserQuery) (*Page[User], error)
+	UpdateUser(ctx context.Context, u *User) (*User, error)
+	DeleteUser(ctx context.Context, id uint) error
+
+	// CreateAddress adds an address to its user's address book. The user's
+	// first address, or one created with IsDefault, becomes the default.
+	CreateAddress(ctx context.Context, a *Address) (*Address, error)
+	GetAddress(ctx context.Context, id uint) (*Address, error)
+	// ListAddresses returns the user's addresses, the default first.
+	ListAddresses(ctx context.Context, userID uint) ([]Address, error)
+	// UpdateAddress replaces the fields of an address. Setting IsDefault
+	// makes it the default; the default can only be moved, not cleared.
+	UpdateAddress(ctx context.Context, a *Address) (*Address, error)
+	// DeleteAddress removes an address. When it was the default, the oldest
+	// remaining address takes over.
+	DeleteAddress(ctx context.Context, id uint) error
+
+	// CreatePromotion fails with ErrPromotionCodeTaken when another
+	// promotion has the same code.
+	CreatePromotion(ctx context.Context, p *Promotion) (*Promotion, error)
+	GetPromotion(ctx context.Context, id uint) (*Promotion, error)
+	GetPromotionByCode(ctx context.Context, code string) (*Promotion, error)
+	// ListPromotions returns every promotion ordered by ID.
+	ListPromotions(ctx context.Context) ([]Promotion, error)
+	// UpdatePromotion replaces the rules of a promotion, keeping its usage
+	// count.
+	UpdatePromotion(ctx context.Context, p *Promotion) (*Promotion, error)
+	DeletePromotion(ctx context.Context, id uint) error
+	// CountRedemptions is how many orders of r redeemed the promotion.
+	CountRedemptions(ctx context.Context, promotionID uint, r Redeemer) (int, error)
+
+	CreateSession(ctx context.Context, s *Session) (*Session, error)
+	GetSession(ctx context.Context, id string) (*Session, error)
+	RevokeSession(ctx context.Context, id string) error
+	DeleteSession(ctx context.Context, id string) error
+}
+
+var (
+	_ Store = (*GORMStorage)(nil)
+	_ Store = (*MemoryStorage)(nil)
+)
@@ -1,22 +1,74 @@
 package storer
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"ecom_apiv1/internal/search"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
-	ErrProductNotFound = errors.New("product not found")
-	ErrUserNotFound    = errors.New("user not found")
-	ErrOrderNotFound   = errors.New("order not found")
-	ErrSessionNotFound = errors.New("session not found")
+	ErrProductNotFound        = errors.New("product not found")
+	ErrUserNotFound           = errors.New("user not found")
+	ErrOrderNotFound          = errors.New("order not found")
+	ErrSessionNotFound        = errors.New("session not found")
+	ErrCartNotFound           = errors.New("cart not found")
+	ErrCartItemNotFound       = errors.New("cart item not found")
+	ErrPaymentNotFound        = errors.New("payment not found")
+	ErrWebhookEventNotFound   = errors.New("webhook event not found")
+	ErrIdempotencyKeyNotFound = errors.New("idempotency key not found")
+	ErrOrderItemNotFound      = errors.New("order item not found")
+	ErrReturnNotFound         = errors.New("return not found")
+	ErrRefundNotFound         = errors.New("refund not found")
+	ErrAddressNotFound        = errors.New("address not found")
+	ErrPromotionNotFound      = errors.New("promotion not found")
+	ErrVariantNotFound        = errors.New("variant not found")
+	ErrCategoryNotFound       = errors.New("category not found")
+	ErrReviewNotFound         = errors.New("review not found")
+	ErrImageNotFound          = errors.New("product image not found")
+
+	ErrUserAlreadyExists       = errors.New("user already exists")
+	ErrInsufficientStock       = errors.New("insufficient stock")
+	ErrInvalidTransition       = errors.New("invalid order status transition")
+	ErrCartAlreadyExists       = errors.New("cart already exists")
+	ErrInvalidQuantity         = errors.New("quantity must be at least 1")
+	ErrPaymentNotPending       = errors.New("payment is not pending")
+	ErrPaymentNotCaptured      = errors.New("payment is not captured")
+	ErrWebhookEventExists      = errors.New("webhook event already recorded")
+	ErrIdempotencyKeyExists    = errors.New("idempotency key already used")
+	ErrInvalidReturnTransition = errors.New("invalid return status transition")
+	ErrReturnQuantityExceeded  = errors.New("return quantity exceeds the quantity ordered")
+	ErrReturnNotRefundable     = errors.New("return is not awaiting a refund")
+	ErrRefundExceedsTotal      = errors.New("refund exceeds the order total")
+	ErrRefundNotPending        = errors.New("refund is not pending")
+	ErrPromotionCodeTaken      = errors.New("promotion code already in use")
+	ErrPromotionUsedUp         = errors.New("promotion has been used up")
+	ErrPromotionUserLimit      = errors.New("promotion was already used the maximum number of times")
+	ErrSKUTaken                = errors.New("sku already in use")
+	ErrCategorySlugTaken       = errors.New("category slug already in use")
+	ErrCategoryInUse           = errors.New("category still has subcategories or products")
+	ErrVariantInUse            = errors.New("variant is reserved by open orders")
+	ErrReviewExists            = errors.New("user already reviewed this product")
+	ErrInvalidImageOrder       = errors.New("image order must list every image of the product once")
+	ErrOrderNotDeletable       = errors.New("only pending or cancelled orders without a captured payment can be deleted")
 )
 
 type GORMStorage struct {
 	DB *gorm.DB
+
+	// searchIx backs SearchProducts on dialects without FULLTEXT support.
+	// It is built on first use and kept current by the product writes.
+	searchMu sync.Mutex
+	searchIx *search.Index
 }
 
 func NewGORMStorage(db *gorm.DB) *GORMStorage {
@@ -30,6 +82,7 @@ func (gs *GORMStorage) CreateProduct(ctx context.Context, p *Product) (*Product,
 	if result.Error != nil {
 		return nil, fmt.Errorf("error inserting product: %w", result.Error)
 	}
+	gs.reindex(p)
 	return p, nil
 }
 
@@ -45,20 +98,62 @@ func (gs *GORMStorage) GetProduct(ctx context.Context, id uint) (*Product, error
 	return &p, nil
 }
 
-func (gs *GORMStorage) ListProducts(ctx context.Context) ([]Product, error) {
+func (gs *GORMStorage) GetProducts(ctx context.Context, ids []uint) ([]Product, error) {
+	products := []Product{}
+	if len(ids) == 0 {
+		return products, nil
+	}
+	if err := gs.DB.WithContext(ctx).Where("id IN ?", ids).Order("id").Find(&products).Error; err != nil {
+		return nil, fmt.Errorf("error getting products: %w", err)
+	}
+	return products, nil
+}
+
+func (gs *GORMStorage) ListProducts(ctx context.Context, q ProductQuery) (*Page[Product], error) {
+	lq, err := q.Compile()
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	if err := filtered(gs.DB.WithContext(ctx).Model(&Product{}), lq).Count(&total).Error; err != nil {
+		return nil, fmt.Errorf("error counting products: %w", err)
+	}
 	var products []Product
-	result := gs.DB.WithContext(ctx).Find(&products)
+	result := paged(gs.DB.WithContext(ctx), lq).Find(&products)
 	if result.Error != nil {
 		return nil, fmt.Errorf("error listing products: %w", result.Error)
 	}
-	return products, nil
+	return ProductPage(lq, products, total), nil
+}
+
+func (gs *GORMStorage) GetProductFacets(ctx context.Context, f ProductFilter) (*ProductFacets, error) {
+	rows := make(map[string][]FacetRow)
+	for _, fq := range f.FacetQueries() {
+		var facetRows []FacetRow
+		if err := gs.DB.WithContext(ctx).Raw(fq.SQL, fq.Args...).Scan(&facetRows).Error; err != nil {
+			return nil, fmt.Errorf("error counting %s facet: %w", fq.Facet, err)
+		}
+		rows[fq.Facet] = facetRows
+	}
+	return BuildProductFacets(rows)
 }
 
 func (gs *GORMStorage) UpdateProduct(ctx context.Context, p *Product) (*Product, error) {
-	result := gs.DB.WithContext(ctx).Save(p)
+	if p.ID == 0 {
+		return nil, ErrProductNotFound
+	}
+	// rating and num_reviews belong to the reviews, see refreshRating
+	result := gs.DB.WithContext(ctx).Model(p).Select("*").Omit("id", "created_at", "rating", "num_reviews").Updates(p)
 	if result.Error != nil {
 		return nil, fmt.Errorf("error updating product: %w", result.Error)
 	}
+	if result.RowsAffected == 0 {
+		return nil, ErrProductNotFound
+	}
+	if err := gs.DB.WithContext(ctx).Select("rating", "num_reviews").First(p, p.ID).Error; err != nil {
+		return nil, fmt.Errorf("error getting product rating: %w", err)
+	}
+	gs.reindex(p)
 	return p, nil
 }
 
@@ -70,175 +165,2053 @@ func (gs *GORMStorage) DeleteProduct(ctx context.Context, id uint) error {
 	if result.RowsAffected == 0 {
 		return ErrProductNotFound
 	}
+	gs.unindex(id)
 	return nil
 }
 
-/*
-func (gs *GORMStorage) CreateOrder(ctx context.Context, o *Order) (*Order, error) {
+func (gs *GORMStorage) CreateVariant(ctx context.Context, v *ProductVariant) (*ProductVariant, error) {
 	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		if err := tx.Create(o).Error; err != nil {
-			return fmt.Errorf("error creating order: %w", err)
+		var n int64
+		if err := tx.Model(&Product{}).Where("id = ?", v.ProductID).Count(&n).Error; err != nil {
+			return fmt.Errorf("error checking product: %w", err)
 		}
-		for i := range o.Items {
-			o.Items[i].OrderID = o.ID
-			if err := tx.Create(&o.Items[i]).Error; err != nil {
-				return fmt.Errorf("error creating order item: %w", err)
+		if n == 0 {
+			return ErrProductNotFound
+		}
+		if err := tx.Create(v).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return ErrSKUTaken
 			}
+			return fmt.Errorf("error saving variant: %w", err)
 		}
-		return nil
+		return moveProductStock(tx, v.ProductID, v.CountInStock)
 	})
 	if err != nil {
-		return nil, fmt.Errorf("error creating order: %w", err)
+		return nil, fmt.Errorf("error creating variant: %w", err)
 	}
-	return o, nil
+	return v, nil
 }
-*/
 
-// teknik bulk insert -> memasukkan data yang banyak sekaligus tanpa 1-1 ke db
-func (gs *GORMStorage) CreateOrder(ctx context.Context, o *Order) (*Order, error) {
+func (gs *GORMStorage) GetVariant(ctx context.Context, id uint) (*ProductVariant, error) {
+	return getVariant(gs.DB.WithContext(ctx), id)
+}
+
+func (gs *GORMStorage) GetVariants(ctx context.Context, ids []uint) ([]ProductVariant, error) {
+	variants := []ProductVariant{}
+	if len(ids) == 0 {
+		return variants, nil
+	}
+	if err := gs.DB.WithContext(ctx).Where("id IN ?", ids).Order("id").Find(&variants).Error; err != nil {
+		return nil, fmt.Errorf("error getting variants: %w", err)
+	}
+	return variants, nil
+}
+
+func (gs *GORMStorage) ListVariants(ctx context.Context, productID uint) ([]ProductVariant, error) {
+	variants := []ProductVariant{}
+	if err := gs.DB.WithContext(ctx).Where("product_id = ?", productID).Order("id").Find(&variants).Error; err != nil {
+		return nil, fmt.Errorf("error listing variants: %w", err)
+	}
+	return variants, nil
+}
+
+func (gs *GORMStorage) UpdateVariant(ctx context.Context, v *ProductVariant) (*ProductVariant, error) {
 	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		if err := tx.Create(o).Error; err != nil {
-			return fmt.Errorf("error creating order: %w", err)
+		current, err := lockVariant(tx, v.ID)
+		if err != nil {
+			return err
 		}
-		if len(o.Items) > 0 {
-			for i := range o.Items {
-				o.Items[i].OrderID = o.ID
-			}
-			if err := tx.Create(&o.Items).Error; err != nil {
-				return fmt.Errorf("error creating order items: %w", err)
+		v.ProductID, v.CreatedAt = current.ProductID, current.CreatedAt
+		if err := tx.Model(v).Select("*").Omit("id", "created_at").Updates(v).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return ErrSKUTaken
 			}
+			return fmt.Errorf("error saving variant: %w", err)
+		}
+		return moveProductStock(tx, v.ProductID, v.CountInStock-current.CountInStock)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating variant: %w", err)
+	}
+	return v, nil
+}
+
+func (gs *GORMStorage) DeleteVariant(ctx context.Context, id uint) error {
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		v, err := lockVariant(tx, id)
+		if err != nil {
+			return err
+		}
+		var open int64
+		err = tx.Model(&OrderItem{}).Joins("JOIN orders ON orders.id = order_items.order_id").
+			Where("order_items.variant_id = ? AND orders.status IN ?", id, StockHoldingStatuses).Count(&open).Error
+		if err != nil {
+			return fmt.Errorf("error counting open orders: %w", err)
+		}
+		if open > 0 {
+			return ErrVariantInUse
+		}
+		if err := tx.Model(&OrderItem{}).Where("variant_id = ?", id).Update("variant_id", nil).Error; err != nil {
+			return fmt.Errorf("error detaching order items: %w", err)
+		}
+		if err := tx.Where("variant_id = ?", id).Delete(&CartItem{}).Error; err != nil {
+			return fmt.Errorf("error deleting cart items: %w", err)
+		}
+		if err := tx.Delete(&ProductVariant{}, id).Error; err != nil {
+			return fmt.Errorf("error deleting variant: %w", err)
+		}
+		return moveProductStock(tx, v.ProductID, -v.CountInStock)
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting variant: %w", err)
+	}
+	return nil
+}
+
+func (gs *GORMStorage) CreateProductImage(ctx context.Context, img *ProductImage) (*ProductImage, error) {
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockProduct(tx, img.ProductID); err != nil {
+			return err
+		}
+		var last *int
+		if err := tx.Model(&ProductImage{}).Where("product_id = ?", img.ProductID).Select("MAX(position)").Scan(&last).Error; err != nil {
+			return fmt.Errorf("error finding last image: %w", err)
+		}
+		img.Position = 0
+		if last != nil {
+			img.Position = *last + 1
+		}
+		if err := tx.Create(img).Error; err != nil {
+			return fmt.Errorf("error saving image: %w", err)
 		}
 		return nil
 	})
 	if err != nil {
-		return nil, fmt.Errorf("error creating order: %w", err)
+		return nil, fmt.Errorf("error creating product image: %w", err)
 	}
-	return o, nil
+	return img, nil
 }
 
-func (gs *GORMStorage) GetOrder(ctx context.Context, userID uint) (*Order, error) {
-	var o Order
-	result := gs.DB.WithContext(ctx).Preload("Items").Where("user_id = ?", userID).First(&o)
+func (gs *GORMStorage) GetProductImage(ctx context.Context, id uint) (*ProductImage, error) {
+	return getProductImage(gs.DB.WithContext(ctx), id)
+}
+
+func (gs *GORMStorage) ListProductImages(ctx context.Context, productID uint) ([]ProductImage, error) {
+	return listProductImages(gs.DB.WithContext(ctx), productID)
+}
+
+func (gs *GORMStorage) UpdateProductImage(ctx context.Context, img *ProductImage) (*ProductImage, error) {
+	result := gs.DB.WithContext(ctx).Model(&ProductImage{}).Where("id = ?", img.ID).
+		Updates(map[string]interface{}{"alt_text": img.AltText, "updated_at": time.Now()})
 	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, ErrOrderNotFound
+		return nil, fmt.Errorf("error updating product image: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrImageNotFound
+	}
+	return getProductImage(gs.DB.WithContext(ctx), img.ID)
+}
+
+func (gs *GORMStorage) ReorderProductImages(ctx context.Context, productID uint, ids []uint) ([]ProductImage, error) {
+	var images []ProductImage
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockProduct(tx, productID); err != nil {
+			return err
 		}
-		return nil, fmt.Errorf("error getting order: %w", result.Error)
+		current, err := listProductImages(tx, productID)
+		if err != nil {
+			return err
+		}
+		if err := CheckImageOrder(current, ids); err != nil {
+			return err
+		}
+		for position, id := range ids {
+			if err := tx.Model(&ProductImage{}).Where("id = ?", id).Update("position", position).Error; err != nil {
+				return fmt.Errorf("error moving image: %w", err)
+			}
+		}
+		images, err = listProductImages(tx, productID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reordering product images: %w", err)
 	}
-	return &o, nil
+	return images, nil
 }
 
-func (gs *GORMStorage) ListOrders(ctx context.Context) ([]Order, error) {
-	var orders []Order
-	result := gs.DB.WithContext(ctx).Preload("Items").Find(&orders)
+func (gs *GORMStorage) DeleteProductImage(ctx context.Context, id uint) error {
+	result := gs.DB.WithContext(ctx).Delete(&ProductImage{}, id)
 	if result.Error != nil {
-		return nil, fmt.Errorf("error listing orders: %w", result.Error)
+		return fmt.Errorf("error deleting product image: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrImageNotFound
 	}
-	return orders, nil
+	return nil
 }
 
-func (gs *GORMStorage) DeleteOrder(ctx context.Context, id uint) error {
+func (gs *GORMStorage) CreateCategory(ctx context.Context, c *Category) (*Category, error) {
 	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		if err := tx.Where("order_id = ?", id).Delete(&OrderItem{}).Error; err != nil {
-			return fmt.Errorf("error deleting order items: %w", err)
+		if err := ensureCategoryExists(tx, c.ParentID); err != nil {
+			return err
 		}
-		if err := tx.Delete(&Order{}, id).Error; err != nil {
-			return fmt.Errorf("error deleting order: %w", err)
+		if err := tx.Create(c).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return ErrCategorySlugTaken
+			}
+			return fmt.Errorf("error saving category: %w", err)
 		}
 		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("error deleting order: %w", err)
+		return nil, fmt.Errorf("error creating category: %w", err)
 	}
-	return nil
+	return c, nil
 }
 
-func (gs *GORMStorage) CreateUser(ctx context.Context, u *User) (*User, error) {
-	result := gs.DB.WithContext(ctx).Create(u)
-	if result.Error != nil {
-		return nil, fmt.Errorf("error inserting user: %w", result.Error)
+func (gs *GORMStorage) GetCategory(ctx context.Context, id uint) (*Category, error) {
+	return getCategory(gs.DB.WithContext(ctx), "id = ?", id)
+}
+
+func (gs *GORMStorage) GetCategoryBySlug(ctx context.Context, slug string) (*Category, error) {
+	return getCategory(gs.DB.WithContext(ctx), "slug = ?", slug)
+}
+
+func (gs *GORMStorage) ListCategories(ctx context.Context) ([]Category, error) {
+	categories := []Category{}
+	if err := gs.DB.WithContext(ctx).Order("position, name, id").Find(&categories).Error; err != nil {
+		return nil, fmt.Errorf("error listing categories: %w", err)
 	}
-	return u, nil
+	return categories, nil
 }
 
-func (gs *GORMStorage) GetUser(ctx context.Context, email string) (*User, error) {
-	var u User
-	result := gs.DB.WithContext(ctx).Where("email = ?", email).First(&u)
-	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, ErrUserNotFound
+func (gs *GORMStorage) UpdateCategory(ctx context.Context, c *Category) (*Category, error) {
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := ensureCategoryExists(tx, c.ParentID); err != nil {
+			return err
 		}
-		return nil, fmt.Errorf("error getting user: %w", result.Error)
+		result := tx.Model(c).Select("parent_id", "name", "slug", "position", "updated_at").Updates(c)
+		if result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+				return ErrCategorySlugTaken
+			}
+			return fmt.Errorf("error saving category: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrCategoryNotFound
+		}
+		if err := tx.Model(&Product{}).Where("category_id = ?", c.ID).Update("category", c.Name).Error; err != nil {
+			return fmt.Errorf("error renaming products: %w", err)
+		}
+		updated, err := getCategory(tx, "id = ?", c.ID)
+		if err != nil {
+			return err
+		}
+		*c = *updated
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating category: %w", err)
 	}
-	return &u, nil
+	gs.resetIndex()
+	return c, nil
 }
 
-func (gs *GORMStorage) ListUsers(ctx context.Context) ([]User, error) {
-	var users []User
-	result := gs.DB.WithContext(ctx).Find(&users)
-	if result.Error != nil {
-		return nil, fmt.Errorf("error listing users: %w", result.Error)
+func (gs *GORMStorage) DeleteCategory(ctx context.Context, id uint, moveTo *uint) error {
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := getCategory(tx, "id = ?", id); err != nil {
+			return err
+		}
+		var children, products int64
+		if err := tx.Model(&Category{}).Where("parent_id = ?", id).Count(&children).Error; err != nil {
+			return fmt.Errorf("error counting subcategories: %w", err)
+		}
+		if err := tx.Model(&Product{}).Where("category_id = ?", id).Count(&products).Error; err != nil {
+			return fmt.Errorf("error counting products: %w", err)
+		}
+		if children > 0 || products > 0 && moveTo == nil {
+			return ErrCategoryInUse
+		}
+		if products > 0 {
+			target, err := getCategory(tx, "id = ?", *moveTo)
+			if err != nil {
+				return err
+			}
+			err = tx.Model(&Product{}).Where("category_id = ?", id).
+				Updates(map[string]interface{}{"category_id": target.ID, "category": target.Name}).Error
+			if err != nil {
+				return fmt.Errorf("error moving products: %w", err)
+			}
+		}
+		if err := tx.Delete(&Category{}, id).Error; err != nil {
+			return fmt.Errorf("error deleting category: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting category: %w", err)
 	}
-	return users, nil
+	gs.resetIndex()
+	return nil
 }
 
-func (gs *GORMStorage) UpdateUser(ctx context.Context, u *User) (*User, error) {
-	result := gs.DB.WithContext(ctx).Save(u)
-	if result.Error != nil {
-		return nil, fmt.Errorf("error updating user: %w", result.Error)
+func (gs *GORMStorage) CreateReview(ctx context.Context, r *Review) (*Review, error) {
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockProduct(tx, r.ProductID); err != nil {
+			return err
+		}
+		if err := tx.Create(r).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return ErrReviewExists
+			}
+			return fmt.Errorf("error saving review: %w", err)
+		}
+		return refreshRating(tx, r.ProductID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating review: %w", err)
 	}
-	return u, nil
+	return r, nil
 }
 
-func (gs *GORMStorage) DeleteUser(ctx context.Context, id uint) error {
-	result := gs.DB.WithContext(ctx).Delete(&User{}, id)
-	if result.Error != nil {
-		return fmt.Errorf("error deleting user: %w", result.Error)
+func (gs *GORMStorage) GetReview(ctx context.Context, id uint) (*Review, error) {
+	return getReview(gs.DB.WithContext(ctx), id)
+}
+
+func (gs *GORMStorage) ListReviews(ctx context.Context, q ReviewQuery) (*Page[Review], error) {
+	lq, err := q.Compile()
+	if err != nil {
+		return nil, err
 	}
-	if result.RowsAffected == 0 {
-		return ErrUserNotFound
+	var total int64
+	if err := filtered(gs.DB.WithContext(ctx).Model(&Review{}), lq).Count(&total).Error; err != nil {
+		return nil, fmt.Errorf("error counting reviews: %w", err)
+	}
+	var reviews []Review
+	if err := paged(gs.DB.WithContext(ctx), lq).Find(&reviews).Error; err != nil {
+		return nil, fmt.Errorf("error listing reviews: %w", err)
+	}
+	return ReviewPage(lq, reviews, total), nil
+}
+
+func (gs *GORMStorage) UpdateReview(ctx context.Context, r *Review) (*Review, error) {
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		current, err := lockReview(tx, r.ID)
+		if err != nil {
+			return err
+		}
+		if err := lockProduct(tx, current.ProductID); err != nil {
+			return err
+		}
+		err = tx.Model(r).Select("rating", "title", "body", "status", "moderated_by", "updated_at").Updates(r).Error
+		if err != nil {
+			return fmt.Errorf("error saving review: %w", err)
+		}
+		if err := refreshRating(tx, current.ProductID); err != nil {
+			return err
+		}
+		updated, err := getReview(tx, r.ID)
+		if err != nil {
+			return err
+		}
+		*r = *updated
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating review: %w", err)
+	}
+	return r, nil
+}
+
+func (gs *GORMStorage) DeleteReview(ctx context.Context, id uint) error {
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		r, err := lockReview(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := lockProduct(tx, r.ProductID); err != nil {
+			return err
+		}
+		if err := tx.Delete(&Review{}, id).Error; err != nil {
+			return fmt.Errorf("error deleting review: %w", err)
+		}
+		return refreshRating(tx, r.ProductID)
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting review: %w", err)
 	}
 	return nil
 }
 
-func (gs *GORMStorage) CreateSession(ctx context.Context, s *Session) (*Session, error) {
-	result := gs.DB.WithContext(ctx).Create(s)
-	if result.Error != nil {
-		return nil, fmt.Errorf("error inserting session: %w", result.Error)
+func (gs *GORMStorage) HasDeliveredOrder(ctx context.Context, userID, productID uint) (bool, error) {
+	var n int64
+	err := gs.DB.WithContext(ctx).Model(&OrderItem{}).
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("orders.user_id = ? AND orders.status = ? AND order_items.product_id = ?", userID, OrderDelivered, productID).
+		Count(&n).Error
+	if err != nil {
+		return false, fmt.Errorf("error checking delivered orders: %w", err)
 	}
-	return s, nil
+	return n > 0, nil
 }
 
-func (gs *GORMStorage) GetSession(ctx context.Context, id string) (*Session, error) {
-	var s Session
-	result := gs.DB.WithContext(ctx).First(&s, "id = ?", id)
-	if result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, ErrSessionNotFound
+// mysqlMatch is the FULLTEXT expression covered by idx_products_search.
+const mysqlMatch = "MATCH(name, category, description) AGAINST (? IN %s MODE)"
+
+func (gs *GORMStorage) SearchProducts(ctx context.Context, q ProductSearchQuery) (*SearchResult, error) {
+	if gs.DB.Dialector.Name() != "mysql" {
+		ix, err := gs.productIndex(ctx)
+		if err != nil {
+			return nil, err
 		}
-		return nil, fmt.Errorf("error getting session: %w", result.Error)
+		return searchIndex(ix, q, func(ids []uint) (map[uint]Product, error) {
+			var products []Product
+			if err := gs.DB.WithContext(ctx).Where("id IN ?", ids).Find(&products).Error; err != nil {
+				return nil, fmt.Errorf("error loading search hits: %w", err)
+			}
+			byID := make(map[uint]Product, len(products))
+			for _, p := range products {
+				byID[p.ID] = p
+			}
+			return byID, nil
+		})
 	}
-	return &s, nil
+
+	limit, offset, err := q.Bounds()
+	if err != nil {
+		return nil, err
+	}
+	// Natural language mode ranks whole words; when it finds nothing, fall
+	// back to prefixes of the terms so trailing typos still match.
+	res, err := gs.searchFullText(ctx, q.Query, "NATURAL LANGUAGE", q.Query, limit, offset)
+	if err != nil || res.Total > 0 {
+		return res, err
+	}
+	relaxed := search.RelaxedTerms(q.Query)
+	for i, t := range relaxed {
+		relaxed[i] = t + "*"
+	}
+	return gs.searchFullText(ctx, q.Query, "BOOLEAN", strings.Join(relaxed, " "), limit, offset)
 }
 
-func (gs *GORMStorage) RevokeSession(ctx context.Context, id string) error {
-	result := gs.DB.WithContext(ctx).Model(&Session{}).Where("id = ?", id).Update("is_revoked", true)
+func (gs *GORMStorage) searchFullText(ctx context.Context, query, mode, against string, limit, offset int) (*SearchResult, error) {
+	match := fmt.Sprintf(mysqlMatch, mode)
+	var total int64
+	if err := gs.DB.WithContext(ctx).Model(&Product{}).Where(match, against).Count(&total).Error; err != nil {
+		return nil, fmt.Errorf("error counting search hits: %w", err)
+	}
+	var rows []struct {
+		Product `gorm:"embedded"`
+		Score   float64
+	}
+	result := gs.DB.WithContext(ctx).Model(&Product{}).
+		Select("products.*, "+match+" AS score", against).
+		Where(match, against).
+		Order("score DESC, id").
+		Limit(limit).Offset(offset).
+		Find(&rows)
 	if result.Error != nil {
-		return fmt.Errorf("error revoking session: %w", result.Error)
+		return nil, fmt.Errorf("error searching products: %w", result.Error)
 	}
-	if result.RowsAffected == 0 {
-		return ErrSessionNotFound
+	res := &SearchResult{Hits: make([]ProductHit, 0, len(rows)), Total: total}
+	for _, row := range rows {
+		res.Hits = append(res.Hits, NewProductHit(query, row.Product, row.Score))
 	}
-	return nil
+	return res, nil
 }
 
-func (gs *GORMStorage) DeleteSession(ctx context.Context, id string) error {
-	result := gs.DB.WithContext(ctx).Delete(&Session{}, "id = ?", id)
-	if result.Error != nil {
-		return fmt.Errorf("error deleting session: %w", result.Error)
+// productIndex returns the built-in search index, loading every product
+// into it the first time.
+func (gs *GORMStorage) productIndex(ctx context.Context) (*search.Index, error) {
+	gs.searchMu.Lock()
+	defer gs.searchMu.Unlock()
+
+	if gs.searchIx != nil {
+		return gs.searchIx, nil
 	}
-	if result.RowsAffected == 0 {
-		return ErrSessionNotFound
+	ix := newProductIndex()
+	var products []Product
+	err := gs.DB.WithContext(ctx).Select("id", "name", "category", "description").
+		FindInBatches(&products, 500, func(tx *gorm.DB, batch int) error {
+			for i := range products {
+				ix.Add(products[i].ID, productSearchFields(&products[i]))
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return nil, fmt.Errorf("error building search index: %w", err)
 	}
-	return nil
+	gs.searchIx = ix
+	return ix, nil
+}
+
+func (gs *GORMStorage) reindex(p *Product) {
+	gs.searchMu.Lock()
+	defer gs.searchMu.Unlock()
+	if gs.searchIx != nil {
+		gs.searchIx.Add(p.ID, productSearchFields(p))
+	}
+}
+
+// resetIndex drops the search index after writes to many products; the
+// next search rebuilds it.
+func (gs *GORMStorage) resetIndex() {
+	gs.searchMu.Lock()
+	defer gs.searchMu.Unlock()
+	gs.searchIx = nil
+}
+
+func (gs *GORMStorage) unindex(id uint) {
+	gs.searchMu.Lock()
+	defer gs.searchMu.Unlock()
+	if gs.searchIx != nil {
+		gs.searchIx.Remove(id)
+	}
+}
+
+/*
+func (gs *GORMStorage) CreateOrder(ctx context.Context, o *Order) (*Order, error) {
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(o).Error; err != nil {
+			return fmt.Errorf("error creating order: %w", err)
+		}
+		for i := range o.Items {
+			o.Items[i].OrderID = o.ID
+			if err := tx.Create(&o.Items[i]).Error; err != nil {
+				return fmt.Errorf("error creating order item: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating order: %w", err)
+	}
+	return o, nil
+}
+*/
+
+// teknik bulk insert -> memasukkan data yang banyak sekaligus tanpa 1-1 ke db
+func (gs *GORMStorage) CreateOrder(ctx context.Context, o *Order) (*Order, error) {
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// first, so on MySQL the redemption counts are read after the
+		// promotions are locked
+		redemptions, err := claimPromotions(tx, o)
+		if err != nil {
+			return err
+		}
+		if err := ensureProductsExist(tx, o.Items); err != nil {
+			return err
+		}
+		if err := reserveStock(tx, o.Items); err != nil {
+			return err
+		}
+		if o.Status == "" {
+			o.Status = OrderPending
+		}
+		// items are inserted in bulk below, without Omit GORM would already
+		// write them (and upsert User) as part of the order row
+		if err := tx.Omit(clause.Associations).Create(o).Error; err != nil {
+			return fmt.Errorf("error creating order: %w", err)
+		}
+		event := NewStatusEvent(o.ID, "", StatusChange{To: o.Status, ChangedBy: o.UserID}, o.CreatedAt)
+		if err := tx.Create(&event).Error; err != nil {
+			return fmt.Errorf("error recording order status: %w", err)
+		}
+		if len(o.Items) > 0 {
+			for i := range o.Items {
+				o.Items[i].OrderID = o.ID
+			}
+			if err := tx.Omit(clause.Associations).Create(&o.Items).Error; err != nil {
+				return fmt.Errorf("error creating order items: %w", err)
+			}
+		}
+		if len(redemptions) > 0 {
+			for i := range redemptions {
+				redemptions[i].OrderID = o.ID
+			}
+			if err := tx.Create(&redemptions).Error; err != nil {
+				return fmt.Errorf("error recording promotion redemptions: %w", err)
+			}
+		}
+		if o.CartID != nil {
+			if err := tx.Where("cart_id = ?", *o.CartID).Delete(&CartItem{}).Error; err != nil {
+				return fmt.Errorf("error emptying cart: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating order: %w", err)
+	}
+	return o, nil
+}
+
+func (gs *GORMStorage) GetOrderByID(ctx context.Context, id uint) (*Order, error) {
+	var o Order
+	result := gs.DB.WithContext(ctx).Preload("Items").First(&o, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, fmt.Errorf("error getting order: %w", result.Error)
+	}
+	return &o, nil
+}
+
+func (gs *GORMStorage) GetOrderByAccessToken(ctx context.Context, tokenHash string) (*Order, error) {
+	var o Order
+	result := gs.DB.WithContext(ctx).Preload("Items").Where("access_token_hash = ?", tokenHash).First(&o)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, fmt.Errorf("error getting order: %w", result.Error)
+	}
+	return &o, nil
+}
+
+func (gs *GORMStorage) ClaimOrder(ctx context.Context, tokenHash string, userID uint) (*Order, error) {
+	var o Order
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("id").Where("access_token_hash = ?", tokenHash).First(&o).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrOrderNotFound
+			}
+			return fmt.Errorf("error getting order: %w", err)
+		}
+		// guarded by the token so only one of two concurrent claims wins
+		result := tx.Model(&Order{}).Where("id = ? AND access_token_hash = ?", o.ID, tokenHash).
+			Updates(map[string]interface{}{"user_id": userID, "access_token_hash": nil, "updated_at": time.Now()})
+		if result.Error != nil {
+			return fmt.Errorf("error claiming order: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrOrderNotFound
+		}
+		return tx.Preload("Items").First(&o, o.ID).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error claiming order: %w", err)
+	}
+	return &o, nil
+}
+
+func (gs *GORMStorage) UpdateOrderStatus(ctx context.Context, id uint, c StatusChange) (*Order, error) {
+	var o *Order
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) (err error) {
+		o, err = updateOrderStatus(tx, id, c)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating order status: %w", err)
+	}
+	return o, nil
+}
+
+func (gs *GORMStorage) ListOrderStatusEvents(ctx context.Context, orderID uint) ([]OrderStatusEvent, error) {
+	var count int64
+	if err := gs.DB.WithContext(ctx).Model(&Order{}).Where("id = ?", orderID).Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("error getting order: %w", err)
+	}
+	if count == 0 {
+		return nil, ErrOrderNotFound
+	}
+	events := []OrderStatusEvent{}
+	result := gs.DB.WithContext(ctx).Where("order_id = ?", orderID).Order("created_at, id").Find(&events)
+	if result.Error != nil {
+		return nil, fmt.Errorf("error listing order status events: %w", result.Error)
+	}
+	return events, nil
+}
+
+func (gs *GORMStorage) ListOrders(ctx context.Context, q OrderQuery) (*Page[Order], error) {
+	lq, err := q.Compile()
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	if err := filtered(gs.DB.WithContext(ctx).Model(&Order{}), lq).Count(&total).Error; err != nil {
+		return nil, fmt.Errorf("error counting orders: %w", err)
+	}
+	var orders []Order
+	result := paged(gs.DB.WithContext(ctx).Preload("Items"), lq).Find(&orders)
+	if result.Error != nil {
+		return nil, fmt.Errorf("error listing orders: %w", result.Error)
+	}
+	return OrderPage(lq, orders, total), nil
+}
+
+func (gs *GORMStorage) DeleteOrder(ctx context.Context, id uint) error {
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// touching the order first keeps a payment from being captured
+		// while it is checked
+		if err := tx.Model(&Order{}).Where("id = ?", id).Update("updated_at", time.Now()).Error; err != nil {
+			return fmt.Errorf("error locking order: %w", err)
+		}
+		var o Order
+		if err := tx.Select("id", "status").First(&o, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrOrderNotFound
+			}
+			return fmt.Errorf("error getting order: %w", err)
+		}
+		var captured int64
+		if err := tx.Model(&Payment{}).Where("order_id = ? AND status = ?", id, PaymentCaptured).Count(&captured).Error; err != nil {
+			return fmt.Errorf("error checking payments: %w", err)
+		}
+		if !o.Status.Deletable() || captured > 0 {
+			return ErrOrderNotDeletable
+		}
+		if o.Status.HoldsStock() {
+			if err := restoreStock(tx, id); err != nil {
+				return err
+			}
+		}
+		if err := releasePromotions(tx, id); err != nil {
+			return err
+		}
+		if err := tx.Where("order_id = ?", id).Delete(&OrderStatusEvent{}).Error; err != nil {
+			return fmt.Errorf("error deleting order status events: %w", err)
+		}
+		if err := tx.Where("order_id = ?", id).Delete(&Refund{}).Error; err != nil {
+			return fmt.Errorf("error deleting refunds: %w", err)
+		}
+		if err := tx.Where("order_id = ?", id).Delete(&ReturnRequest{}).Error; err != nil {
+			return fmt.Errorf("error deleting returns: %w", err)
+		}
+		if err := tx.Where("order_id = ?", id).Delete(&Payment{}).Error; err != nil {
+			return fmt.Errorf("error deleting payments: %w", err)
+		}
+		if err := tx.Where("order_id = ?", id).Delete(&OrderItem{}).Error; err != nil {
+			return fmt.Errorf("error deleting order items: %w", err)
+		}
+		result := tx.Delete(&Order{}, id)
+		if result.Error != nil {
+			return fmt.Errorf("error deleting order: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrOrderNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting order: %w", err)
+	}
+	return nil
+}
+
+func (gs *GORMStorage) CreateCart(ctx context.Context, c *Cart) (*Cart, error) {
+	result := gs.DB.WithContext(ctx).Omit(clause.Associations).Create(c)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			return nil, ErrCartAlreadyExists
+		}
+		return nil, fmt.Errorf("error creating cart: %w", result.Error)
+	}
+	c.Items = []CartItem{}
+	return c, nil
+}
+
+func (gs *GORMStorage) GetUserCart(ctx context.Context, userID uint) (*Cart, error) {
+	return getCart(gs.DB.WithContext(ctx), "user_id = ?", userID)
+}
+
+func (gs *GORMStorage) GetGuestCart(ctx context.Context, tokenHash string) (*Cart, error) {
+	return getCart(gs.DB.WithContext(ctx), "token_hash = ?", tokenHash)
+}
+
+func (gs *GORMStorage) AddCartItem(ctx context.Context, cartID, productID uint, variantID *uint, quantity int) (*Cart, error) {
+	return gs.upsertCartItem(ctx, cartID, productID, variantID, quantity, gorm.Expr("cart_items.quantity + ?", quantity))
+}
+
+func (gs *GORMStorage) SetCartItem(ctx context.Context, cartID, productID uint, variantID *uint, quantity int) (*Cart, error) {
+	return gs.upsertCartItem(ctx, cartID, productID, variantID, quantity, quantity)
+}
+
+// cartLine is the conflict target of the unique index on cart lines, which
+// counts every line without a variant as variant 0.
+var cartLine = []clause.Column{{Name: "cart_id"}, {Name: "product_id"}, {Name: "(COALESCE(variant_id, 0))", Raw: true}}
+
+// upsertCartItem inserts the line of the product and variant or, when the
+// cart already has one, sets its quantity to update. The upsert keeps two
+// concurrent adds of the same line from failing on the unique index.
+func (gs *GORMStorage) upsertCartItem(ctx context.Context, cartID, productID uint, variantID *uint, quantity int, update interface{}) (*Cart, error) {
+	if quantity < 1 {
+		return nil, ErrInvalidQuantity
+	}
+	var c *Cart
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := touchCart(tx, cartID); err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&Product{}).Where("id = ?", productID).Count(&count).Error; err != nil {
+			return fmt.Errorf("error checking product: %w", err)
+		}
+		if count == 0 {
+			return ErrProductNotFound
+		}
+		if variantID != nil {
+			if err := tx.Model(&ProductVariant{}).Where("id = ? AND product_id = ?", *variantID, productID).Count(&count).Error; err != nil {
+				return fmt.Errorf("error checking variant: %w", err)
+			}
+			if count == 0 {
+				return ErrVariantNotFound
+			}
+		}
+		now := time.Now()
+		item := CartItem{CartID: cartID, ProductID: productID, VariantID: variantID, Quantity: quantity, CreatedAt: now, UpdatedAt: now}
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   cartLine,
+			DoUpdates: clause.Assignments(map[string]interface{}{"quantity": update, "updated_at": now}),
+		}).Create(&item).Error
+		if err != nil {
+			return fmt.Errorf("error saving cart item: %w", err)
+		}
+		c, err = getCart(tx, "id = ?", cartID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating cart: %w", err)
+	}
+	return c, nil
+}
+
+func (gs *GORMStorage) RemoveCartItem(ctx context.Context, cartID, productID uint, variantID *uint) (*Cart, error) {
+	var c *Cart
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := touchCart(tx, cartID); err != nil {
+			return err
+		}
+		line := tx.Where("cart_id = ? AND product_id = ?", cartID, productID)
+		if variantID == nil {
+			line = line.Where("variant_id IS NULL")
+		} else {
+			line = line.Where("variant_id = ?", *variantID)
+		}
+		result := line.Delete(&CartItem{})
+		if result.Error != nil {
+			return fmt.Errorf("error deleting cart item: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrCartItemNotFound
+		}
+		var err error
+		c, err = getCart(tx, "id = ?", cartID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating cart: %w", err)
+	}
+	return c, nil
+}
+
+func (gs *GORMStorage) MergeCarts(ctx context.Context, fromID, intoID uint) (*Cart, error) {
+	var c *Cart
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		from, err := getCart(tx, "id = ?", fromID)
+		if err != nil {
+			return err
+		}
+		if fromID == intoID {
+			c = from
+			return nil
+		}
+		if err := touchCart(tx, intoID); err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, item := range from.Items {
+			moved := CartItem{CartID: intoID, ProductID: item.ProductID, VariantID: item.VariantID, Quantity: item.Quantity, CreatedAt: now, UpdatedAt: now}
+			err := tx.Clauses(clause.OnConflict{
+				Columns: cartLine,
+				DoUpdates: clause.Assignments(map[string]interface{}{
+					"quantity":   gorm.Expr("cart_items.quantity + ?", item.Quantity),
+					"updated_at": now,
+				}),
+			}).Create(&moved).Error
+			if err != nil {
+				return fmt.Errorf("error merging cart item: %w", err)
+			}
+		}
+		if err := tx.Where("cart_id = ?", fromID).Delete(&CartItem{}).Error; err != nil {
+			return fmt.Errorf("error deleting cart items: %w", err)
+		}
+		if err := tx.Delete(&Cart{}, fromID).Error; err != nil {
+			return fmt.Errorf("error deleting cart: %w", err)
+		}
+		c, err = getCart(tx, "id = ?", intoID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error merging carts: %w", err)
+	}
+	return c, nil
+}
+
+func (gs *GORMStorage) CreatePayment(ctx context.Context, p *Payment) (*Payment, error) {
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&Order{}).Where("id = ?", p.OrderID).Count(&count).Error; err != nil {
+			return fmt.Errorf("error getting order: %w", err)
+		}
+		if count == 0 {
+			return ErrOrderNotFound
+		}
+		if err := tx.Omit(clause.Associations).Create(p).Error; err != nil {
+			return fmt.Errorf("error creating payment: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating payment: %w", err)
+	}
+	return p, nil
+}
+
+func (gs *GORMStorage) GetPayment(ctx context.Context, id uint) (*Payment, error) {
+	return getPayment(gs.DB.WithContext(ctx), "id = ?", id)
+}
+
+func (gs *GORMStorage) GetPaymentByIntent(ctx context.Context, provider, intentID string) (*Payment, error) {
+	return getPayment(gs.DB.WithContext(ctx), "provider = ? AND intent_id = ?", provider, intentID)
+}
+
+func (gs *GORMStorage) ListPayments(ctx context.Context, orderID uint) ([]Payment, error) {
+	var count int64
+	if err := gs.DB.WithContext(ctx).Model(&Order{}).Where("id = ?", orderID).Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("error getting order: %w", err)
+	}
+	if count == 0 {
+		return nil, ErrOrderNotFound
+	}
+	payments := []Payment{}
+	if err := gs.DB.WithContext(ctx).Where("order_id = ?", orderID).Order("id").Find(&payments).Error; err != nil {
+		return nil, fmt.Errorf("error listing payments: %w", err)
+	}
+	return payments, nil
+}
+
+func (gs *GORMStorage) CapturePayment(ctx context.Context, id uint, captureID string) (*Payment, error) {
+	var p *Payment
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) (err error) {
+		p, err = settlePayment(tx, id, PaymentPending, map[string]interface{}{"status": PaymentCaptured, "capture_id": captureID})
+		if err != nil {
+			return err
+		}
+		_, err = updateOrderStatus(tx, p.OrderID, CaptureStatusChange(p))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error capturing payment: %w", err)
+	}
+	return p, nil
+}
+
+func (gs *GORMStorage) FailPayment(ctx context.Context, id uint, reason string) (*Payment, error) {
+	p, err := settlePayment(gs.DB.WithContext(ctx), id, PaymentPending, map[string]interface{}{"status": PaymentFailed, "failure_reason": reason})
+	if err != nil {
+		return nil, fmt.Errorf("error failing payment: %w", err)
+	}
+	return p, nil
+}
+
+func (gs *GORMStorage) GetWebhookEvent(ctx context.Context, provider, eventID string) (*WebhookEvent, error) {
+	var e WebhookEvent
+	err := gs.DB.WithContext(ctx).Where("provider = ? AND event_id = ?", provider, eventID).First(&e).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebhookEventNotFound
+		}
+		return nil, fmt.Errorf("error getting webhook event: %w", err)
+	}
+	return &e, nil
+}
+
+func (gs *GORMStorage) CreateWebhookEvent(ctx context.Context, e *WebhookEvent) (*WebhookEvent, error) {
+	if err := gs.DB.WithContext(ctx).Create(e).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrWebhookEventExists
+		}
+		return nil, fmt.Errorf("error recording webhook event: %w", err)
+	}
+	return e, nil
+}
+
+func (gs *GORMStorage) CreateReturn(ctx context.Context, r *ReturnRequest) (*ReturnRequest, error) {
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// touching the item first serialises concurrent returns of it
+		result := tx.Model(&OrderItem{}).Where("id = ? AND order_id = ?", r.OrderItemID, r.OrderID).Update("updated_at", time.Now())
+		if result.Error != nil {
+			return fmt.Errorf("error getting order item: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrOrderItemNotFound
+		}
+		var item OrderItem
+		if err := tx.First(&item, r.OrderItemID).Error; err != nil {
+			return fmt.Errorf("error getting order item: %w", err)
+		}
+		var existing []ReturnRequest
+		if err := tx.Where("order_item_id = ?", item.ID).Find(&existing).Error; err != nil {
+			return fmt.Errorf("error listing returns: %w", err)
+		}
+		if ReturnedQuantity(existing, item.ID)+r.Quantity > item.Quantity {
+			return ErrReturnQuantityExceeded
+		}
+		r.Status = ReturnRequested
+		if err := tx.Create(r).Error; err != nil {
+			return fmt.Errorf("error creating return: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating return: %w", err)
+	}
+	return r, nil
+}
+
+func (gs *GORMStorage) GetReturn(ctx context.Context, id uint) (*ReturnRequest, error) {
+	return getReturn(gs.DB.WithContext(ctx), id)
+}
+
+func (gs *GORMStorage) ListReturns(ctx context.Context, orderID uint) ([]ReturnRequest, error) {
+	if err := orderExists(gs.DB.WithContext(ctx), orderID); err != nil {
+		return nil, err
+	}
+	returns := []ReturnRequest{}
+	if err := gs.DB.WithContext(ctx).Where("order_id = ?", orderID).Order("id").Find(&returns).Error; err != nil {
+		return nil, fmt.Errorf("error listing returns: %w", err)
+	}
+	return returns, nil
+}
+
+func (gs *GORMStorage) UpdateReturnStatus(ctx context.Context, id uint, c ReturnChange) (*ReturnRequest, error) {
+	var r *ReturnRequest
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) (err error) {
+		r, err = updateReturnStatus(tx, id, c)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating return status: %w", err)
+	}
+	return r, nil
+}
+
+func (gs *GORMStorage) CreateRefund(ctx context.Context, r *Refund) (*Refund, error) {
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if r.ReturnID != nil {
+			// the status guard and the refund check run on a locked return
+			result := tx.Model(&ReturnRequest{}).Where("id = ? AND order_id = ? AND status = ?", *r.ReturnID, r.OrderID, ReturnReceived).
+				Update("updated_at", time.Now())
+			if result.Error != nil {
+				return fmt.Errorf("error getting return: %w", result.Error)
+			}
+			var count int64
+			if err := tx.Model(&Refund{}).Where("return_id = ? AND status <> ?", *r.ReturnID, RefundFailed).Count(&count).Error; err != nil {
+				return fmt.Errorf("error listing refunds: %w", err)
+			}
+			if result.RowsAffected == 0 || count > 0 {
+				return ErrReturnNotRefundable
+			}
+		}
+		result := tx.Model(&Order{}).Where("id = ? AND refunded_amount + ? <= total_price + ?", r.OrderID, r.Amount, refundTolerance).
+			UpdateColumn("refunded_amount", gorm.Expr("refunded_amount + ?", r.Amount))
+		if result.Error != nil {
+			return fmt.Errorf("error updating refunded amount: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			if err := orderExists(tx, r.OrderID); err != nil {
+				return err
+			}
+			return ErrRefundExceedsTotal
+		}
+		r.Status = RefundPending
+		if err := tx.Create(r).Error; err != nil {
+			return fmt.Errorf("error creating refund: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating refund: %w", err)
+	}
+	return r, nil
+}
+
+func (gs *GORMStorage) SubmitRefund(ctx context.Context, id uint, providerRefundID string) (*Refund, error) {
+	r, err := settleRefund(gs.DB.WithContext(ctx), id, map[string]interface{}{"provider_refund_id": providerRefundID})
+	if err != nil {
+		return nil, fmt.Errorf("error submitting refund: %w", err)
+	}
+	return r, nil
+}
+
+func (gs *GORMStorage) CompleteRefund(ctx context.Context, id uint, providerRefundID string) (*Refund, error) {
+	var r *Refund
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) (err error) {
+		r, err = settleRefund(tx, id, map[string]interface{}{"status": RefundSucceeded, "provider_refund_id": providerRefundID})
+		if err != nil {
+			return err
+		}
+		if r.ReturnID != nil {
+			change := ReturnChange{To: ReturnRefunded, ChangedBy: r.CreatedBy, Note: fmt.Sprintf("refund %d", r.ID)}
+			if _, err := updateReturnStatus(tx, *r.ReturnID, change); err != nil {
+				return err
+			}
+		}
+		var refunded float64
+		err = tx.Model(&Refund{}).Select("COALESCE(SUM(amount), 0)").
+			Where("order_id = ? AND status = ?", r.OrderID, RefundSucceeded).Scan(&refunded).Error
+		if err != nil {
+			return fmt.Errorf("error summing refunds: %w", err)
+		}
+		var o Order
+		if err := tx.Select("id", "status", "total_price").First(&o, r.OrderID).Error; err != nil {
+			return fmt.Errorf("error getting order: %w", err)
+		}
+		if !FullyRefunded(refunded, o.TotalPrice) {
+			return nil
+		}
+		if r.PaymentID != nil {
+			_, err := settlePayment(tx, *r.PaymentID, PaymentCaptured, map[string]interface{}{"status": PaymentRefunded})
+			if err != nil && !errors.Is(err, ErrPaymentNotCaptured) {
+				return err
+			}
+		}
+		if o.Status.CanTransitionTo(OrderRefunded) {
+			_, err = updateOrderStatus(tx, o.ID, FullRefundStatusChange(r))
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error completing refund: %w", err)
+	}
+	return r, nil
+}
+
+func (gs *GORMStorage) FailRefund(ctx context.Context, id uint, reason string) (*Refund, error) {
+	var r *Refund
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) (err error) {
+		r, err = settleRefund(tx, id, map[string]interface{}{"status": RefundFailed, "failure_reason": reason})
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&Order{}).Where("id = ?", r.OrderID).
+			UpdateColumn("refunded_amount", gorm.Expr("refunded_amount - ?", r.Amount)).Error
+		if err != nil {
+			return fmt.Errorf("error updating refunded amount: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error failing refund: %w", err)
+	}
+	return r, nil
+}
+
+func (gs *GORMStorage) ListRefunds(ctx context.Context, orderID uint) ([]Refund, error) {
+	if err := orderExists(gs.DB.WithContext(ctx), orderID); err != nil {
+		return nil, err
+	}
+	refunds := []Refund{}
+	if err := gs.DB.WithContext(ctx).Where("order_id = ?", orderID).Order("id").Find(&refunds).Error; err != nil {
+		return nil, fmt.Errorf("error listing refunds: %w", err)
+	}
+	return refunds, nil
+}
+
+func (gs *GORMStorage) CreateIdempotencyKey(ctx context.Context, k *IdempotencyKey) (*IdempotencyKey, error) {
+	if err := gs.DB.WithContext(ctx).Create(k).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrIdempotencyKeyExists
+		}
+		return nil, fmt.Errorf("error creating idempotency key: %w", err)
+	}
+	return k, nil
+}
+
+func (gs *GORMStorage) GetIdempotencyKey(ctx context.Context, scope, key string) (*IdempotencyKey, error) {
+	var k IdempotencyKey
+	err := gs.DB.WithContext(ctx).Where("scope = ? AND idempotency_key = ?", scope, key).First(&k).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrIdempotencyKeyNotFound
+		}
+		return nil, fmt.Errorf("error getting idempotency key: %w", err)
+	}
+	return &k, nil
+}
+
+func (gs *GORMStorage) CompleteIdempotencyKey(ctx context.Context, id uint, statusCode int, contentType string, body []byte, expiresAt time.Time) error {
+	result := gs.DB.WithContext(ctx).Model(&IdempotencyKey{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status_code":  statusCode,
+		"content_type": contentType,
+		"body":         body,
+		"expires_at":   expiresAt,
+	})
+	if result.Error != nil {
+		return fmt.Errorf("error completing idempotency key: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrIdempotencyKeyNotFound
+	}
+	return nil
+}
+
+func (gs *GORMStorage) DeleteIdempotencyKey(ctx context.Context, id uint) error {
+	if err := gs.DB.WithContext(ctx).Delete(&IdempotencyKey{}, id).Error; err != nil {
+		return fmt.Errorf("error deleting idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (gs *GORMStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	result := gs.DB.WithContext(ctx).Where("expires_at < ?", now).Delete(&IdempotencyKey{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("error deleting expired idempotency keys: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+func (gs *GORMStorage) CreateUser(ctx context.Context, u *User) (*User, error) {
+	result := gs.DB.WithContext(ctx).Create(u)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			return nil, ErrUserAlreadyExists
+		}
+		return nil, fmt.Errorf("error inserting user: %w", result.Error)
+	}
+	return u, nil
+}
+
+func (gs *GORMStorage) GetUser(ctx context.Context, email string) (*User, error) {
+	var u User
+	result := gs.DB.WithContext(ctx).Where("email = ?", email).First(&u)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("error getting user: %w", result.Error)
+	}
+	return &u, nil
+}
+
+func (gs *GORMStorage) ListUsers(ctx context.Context, q UserQuery) (*Page[User], error) {
+	lq, err := q.Compile()
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	if err := filtered(gs.DB.WithContext(ctx).Model(&User{}), lq).Count(&total).Error; err != nil {
+		return nil, fmt.Errorf("error counting users: %w", err)
+	}
+	var users []User
+	result := paged(gs.DB.WithContext(ctx), lq).Find(&users)
+	if result.Error != nil {
+		return nil, fmt.Errorf("error listing users: %w", result.Error)
+	}
+	return UserPage(lq, users, total), nil
+}
+
+func (gs *GORMStorage) UpdateUser(ctx context.Context, u *User) (*User, error) {
+	if u.ID == 0 {
+		return nil, ErrUserNotFound
+	}
+	result := gs.DB.WithContext(ctx).Model(u).Select("*").Omit("id", "created_at").Updates(u)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			return nil, ErrUserAlreadyExists
+		}
+		return nil, fmt.Errorf("error updating user: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (gs *GORMStorage) DeleteUser(ctx context.Context, id uint) error {
+	result := gs.DB.WithContext(ctx).Delete(&User{}, id)
+	if result.Error != nil {
+		return fmt.Errorf("error deleting user: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (gs *GORMStorage) CreateAddress(ctx context.Context, a *Address) (*Address, error) {
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockAddressBook(tx, a.UserID); err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&Address{}).Where("user_id = ?", a.UserID).Count(&count).Error; err != nil {
+			return fmt.Errorf("error counting addresses: %w", err)
+		}
+		a.IsDefault = a.IsDefault || count == 0
+		if a.IsDefault {
+			if err := clearDefaultAddress(tx, a.UserID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Omit(clause.Associations).Create(a).Error; err != nil {
+			return fmt.Errorf("error inserting address: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating address: %w", err)
+	}
+	return a, nil
+}
+
+func (gs *GORMStorage) GetAddress(ctx context.Context, id uint) (*Address, error) {
+	return getAddress(gs.DB.WithContext(ctx), id)
+}
+
+func (gs *GORMStorage) ListAddresses(ctx context.Context, userID uint) ([]Address, error) {
+	addresses := []Address{}
+	err := gs.DB.WithContext(ctx).Where("user_id = ?", userID).Order("is_default DESC").Order("id").Find(&addresses).Error
+	if err != nil {
+		return nil, fmt.Errorf("error listing addresses: %w", err)
+	}
+	return addresses, nil
+}
+
+func (gs *GORMStorage) UpdateAddress(ctx context.Context, a *Address) (*Address, error) {
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		current, err := getAddress(tx, a.ID)
+		if err != nil {
+			return err
+		}
+		if err := lockAddressBook(tx, current.UserID); err != nil {
+			return err
+		}
+		a.UserID = current.UserID
+		a.CreatedAt = current.CreatedAt
+		a.IsDefault = a.IsDefault || current.IsDefault
+		if a.IsDefault && !current.IsDefault {
+			if err := clearDefaultAddress(tx, a.UserID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Omit(clause.Associations).Save(a).Error; err != nil {
+			return fmt.Errorf("error saving address: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating address: %w", err)
+	}
+	return a, nil
+}
+
+func (gs *GORMStorage) DeleteAddress(ctx context.Context, id uint) error {
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		a, err := getAddress(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := lockAddressBook(tx, a.UserID); err != nil {
+			return err
+		}
+		result := tx.Delete(&Address{}, id)
+		if result.Error != nil {
+			return fmt.Errorf("error deleting address: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrAddressNotFound
+		}
+		if !a.IsDefault {
+			return nil
+		}
+		var next Address
+		err = tx.Where("user_id = ?", a.UserID).Order("id").First(&next).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error getting address: %w", err)
+		}
+		if err := tx.Model(&next).Update("is_default", true).Error; err != nil {
+			return fmt.Errorf("error setting default address: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting address: %w", err)
+	}
+	return nil
+}
+
+func (gs *GORMStorage) CreatePromotion(ctx context.Context, p *Promotion) (*Promotion, error) {
+	if err := gs.DB.WithContext(ctx).Create(p).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrPromotionCodeTaken
+		}
+		return nil, fmt.Errorf("error creating promotion: %w", err)
+	}
+	return p, nil
+}
+
+func (gs *GORMStorage) GetPromotion(ctx context.Context, id uint) (*Promotion, error) {
+	return getPromotion(gs.DB.WithContext(ctx).Where("id = ?", id))
+}
+
+func (gs *GORMStorage) GetPromotionByCode(ctx context.Context, code string) (*Promotion, error) {
+	return getPromotion(gs.DB.WithContext(ctx).Where("code = ?", code))
+}
+
+func (gs *GORMStorage) ListPromotions(ctx context.Context) ([]Promotion, error) {
+	promotions := []Promotion{}
+	if err := gs.DB.WithContext(ctx).Order("id").Find(&promotions).Error; err != nil {
+		return nil, fmt.Errorf("error listing promotions: %w", err)
+	}
+	return promotions, nil
+}
+
+func (gs *GORMStorage) UpdatePromotion(ctx context.Context, p *Promotion) (*Promotion, error) {
+	err := gs.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		current, err := getPromotion(tx.Where("id = ?", p.ID))
+		if err != nil {
+			return err
+		}
+		p.CreatedAt = current.CreatedAt
+		result := tx.Model(p).Select("*").Omit("id", "created_at", "used_count").Updates(p)
+		if result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+				return ErrPromotionCodeTaken
+			}
+			return fmt.Errorf("error saving promotion: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrPromotionNotFound
+		}
+		updated, err := getPromotion(tx.Where("id = ?", p.ID))
+		if err != nil {
+			return err
+		}
+		*p = *updated
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating promotion: %w", err)
+	}
+	return p, nil
+}
+
+func (gs *GORMStorage) DeletePromotion(ctx context.Context, id uint) error {
+	result := gs.DB.WithContext(ctx).Delete(&Promotion{}, id)
+	if result.Error != nil {
+		return fmt.Errorf("error deleting promotion: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrPromotionNotFound
+	}
+	return nil
+}
+
+func (gs *GORMStorage) CountRedemptions(ctx context.Context, promotionID uint, r Redeemer) (int, error) {
+	var n int64
+	if err := redemptionsOf(gs.DB.WithContext(ctx), promotionID, r).Count(&n).Error; err != nil {
+		return 0, fmt.Errorf("error counting promotion redemptions: %w", err)
+	}
+	return int(n), nil
+}
+
+func (gs *GORMStorage) CreateSession(ctx context.Context, s *Session) (*Session, error) {
+	result := gs.DB.WithContext(ctx).Create(s)
+	if result.Error != nil {
+		return nil, fmt.Errorf("error inserting session: %w", result.Error)
+	}
+	return s, nil
+}
+
+func (gs *GORMStorage) GetSession(ctx context.Context, id string) (*Session, error) {
+	var s Session
+	result := gs.DB.WithContext(ctx).First(&s, "id = ?", id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("error getting session: %w", result.Error)
+	}
+	return &s, nil
+}
+
+func (gs *GORMStorage) RevokeSession(ctx context.Context, id string) error {
+	result := gs.DB.WithContext(ctx).Model(&Session{}).Where("id = ?", id).Update("is_revoked", true)
+	if result.Error != nil {
+		return fmt.Errorf("error revoking session: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (gs *GORMStorage) DeleteSession(ctx context.Context, id string) error {
+	result := gs.DB.WithContext(ctx).Delete(&Session{}, "id = ?", id)
+	if result.Error != nil {
+		return fmt.Errorf("error deleting session: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// ensureProductsExist fails with ErrProductNotFound when an item points at a
+// product that does not exist, so CreateOrder rolls back instead of storing
+// a dangling order.
+func ensureProductsExist(tx *gorm.DB, items []OrderItem) error {
+	ids := make(map[uint]struct{}, len(items))
+	for _, item := range items {
+		ids[item.ProductID] = struct{}{}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	productIDs := make([]uint, 0, len(ids))
+	for id := range ids {
+		productIDs = append(productIDs, id)
+	}
+	var count int64
+	if err := tx.Model(&Product{}).Where("id IN ?", productIDs).Count(&count).Error; err != nil {
+		return fmt.Errorf("error checking products: %w", err)
+	}
+	if int(count) != len(productIDs) {
+		return ErrProductNotFound
+	}
+	for _, item := range items {
+		if item.VariantID == nil {
+			continue
+		}
+		var n int64
+		if err := tx.Model(&ProductVariant{}).Where("id = ? AND product_id = ?", *item.VariantID, item.ProductID).Count(&n).Error; err != nil {
+			return fmt.Errorf("error checking variants: %w", err)
+		}
+		if n == 0 {
+			return ErrVariantNotFound
+		}
+	}
+	return nil
+}
+
+// reserveStock takes the ordered quantities out of stock with conditional
+// updates, so two transactions can never both take the last unit. Every
+// short product is reported, not just the first.
+func reserveStock(tx *gorm.DB, items []OrderItem) error {
+	var shortages []StockShortage
+	for _, line := range StockDemand(items) {
+		if line.VariantID != 0 {
+			shortage, err := reserveVariantStock(tx, line)
+			if err != nil {
+				return err
+			}
+			if shortage != nil {
+				shortages = append(shortages, *shortage)
+			}
+			continue
+		}
+		result := tx.Model(&Product{}).
+			Where("id = ? AND count_in_stock >= ?", line.ProductID, line.Quantity).
+			UpdateColumn("count_in_stock", gorm.Expr("count_in_stock - ?", line.Quantity))
+		if result.Error != nil {
+			return fmt.Errorf("error reserving stock: %w", result.Error)
+		}
+		if result.RowsAffected == 1 {
+			continue
+		}
+		var available int
+		if err := tx.Model(&Product{}).Where("id = ?", line.ProductID).Select("count_in_stock").Scan(&available).Error; err != nil {
+			return fmt.Errorf("error reading stock: %w", err)
+		}
+		shortages = append(shortages, StockShortage{ProductID: line.ProductID, Requested: line.Quantity, Available: available})
+	}
+	if len(shortages) > 0 {
+		return &InsufficientStockError{Items: shortages}
+	}
+	return nil
+}
+
+// reserveVariantStock takes line out of the stock of its variant and of the
+// product, whose stock counts every variant's. It returns the shortage when
+// the variant is short.
+func reserveVariantStock(tx *gorm.DB, line StockLine) (*StockShortage, error) {
+	result := tx.Model(&ProductVariant{}).
+		Where("id = ? AND count_in_stock >= ?", line.VariantID, line.Quantity).
+		UpdateColumn("count_in_stock", gorm.Expr("count_in_stock - ?", line.Quantity))
+	if result.Error != nil {
+		return nil, fmt.Errorf("error reserving stock: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		var available int
+		if err := tx.Model(&ProductVariant{}).Where("id = ?", line.VariantID).Select("count_in_stock").Scan(&available).Error; err != nil {
+			return nil, fmt.Errorf("error reading stock: %w", err)
+		}
+		return &StockShortage{ProductID: line.ProductID, VariantID: line.VariantID, Requested: line.Quantity, Available: available}, nil
+	}
+	if err := moveProductStock(tx, line.ProductID, -line.Quantity); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// restock puts quantity units of the product, and of the variant when
+// variantID is set, back into stock.
+func restock(tx *gorm.DB, productID uint, variantID *uint, quantity int) error {
+	if variantID != nil {
+		err := tx.Model(&ProductVariant{}).Where("id = ?", *variantID).
+			UpdateColumn("count_in_stock", gorm.Expr("count_in_stock + ?", quantity)).Error
+		if err != nil {
+			return fmt.Errorf("error restoring stock: %w", err)
+		}
+	}
+	return moveProductStock(tx, productID, quantity)
+}
+
+func moveProductStock(tx *gorm.DB, productID uint, delta int) error {
+	err := tx.Model(&Product{}).Where("id = ?", productID).
+		UpdateColumn("count_in_stock", gorm.Expr("count_in_stock + ?", delta)).Error
+	if err != nil {
+		return fmt.Errorf("error updating product stock: %w", err)
+	}
+	return nil
+}
+
+func getVariant(db *gorm.DB, id uint) (*ProductVariant, error) {
+	var v ProductVariant
+	if err := db.First(&v, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrVariantNotFound
+		}
+		return nil, fmt.Errorf("error getting variant: %w", err)
+	}
+	return &v, nil
+}
+
+func getProductImage(db *gorm.DB, id uint) (*ProductImage, error) {
+	var img ProductImage
+	if err := db.First(&img, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrImageNotFound
+		}
+		return nil, fmt.Errorf("error getting product image: %w", err)
+	}
+	return &img, nil
+}
+
+func listProductImages(db *gorm.DB, productID uint) ([]ProductImage, error) {
+	images := []ProductImage{}
+	if err := db.Where("product_id = ?", productID).Order("position, id").Find(&images).Error; err != nil {
+		return nil, fmt.Errorf("error listing product images: %w", err)
+	}
+	return images, nil
+}
+
+func getReview(db *gorm.DB, id uint) (*Review, error) {
+	var r Review
+	if err := db.First(&r, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrReviewNotFound
+		}
+		return nil, fmt.Errorf("error getting review: %w", err)
+	}
+	return &r, nil
+}
+
+func lockReview(tx *gorm.DB, id uint) (*Review, error) {
+	result := tx.Model(&Review{}).Where("id = ?", id).Update("updated_at", time.Now())
+	if result.Error != nil {
+		return nil, fmt.Errorf("error locking review: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrReviewNotFound
+	}
+	return getReview(tx, id)
+}
+
+// lockProduct holds the product row until the transaction ends, so that
+// concurrent review changes recompute its rating one after the other.
+func lockProduct(tx *gorm.DB, id uint) error {
+	result := tx.Model(&Product{}).Where("id = ?", id).Update("updated_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("error locking product: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrProductNotFound
+	}
+	return nil
+}
+
+// refreshRating recomputes the Rating and NumReviews of a locked product
+// from its approved reviews.
+func refreshRating(tx *gorm.DB, productID uint) error {
+	approved := func() *gorm.DB {
+		return tx.Model(&Review{}).Where("product_id = ? AND status = ?", productID, ReviewApproved)
+	}
+	err := tx.Model(&Product{}).Where("id = ?", productID).UpdateColumns(map[string]interface{}{
+		"num_reviews": approved().Select("COUNT(*)"),
+		"rating":      gorm.Expr("COALESCE((?), 0)", approved().Select("ROUND(AVG(rating), 2)")),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("error updating product rating: %w", err)
+	}
+	return nil
+}
+
+func getCategory(db *gorm.DB, where string, args ...interface{}) (*Category, error) {
+	var c Category
+	if err := db.Where(where, args...).First(&c).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCategoryNotFound
+		}
+		return nil, fmt.Errorf("error getting category: %w", err)
+	}
+	return &c, nil
+}
+
+// ensureCategoryExists fails with ErrCategoryNotFound when id is set and no
+// category has it.
+func ensureCategoryExists(db *gorm.DB, id *uint) error {
+	if id == nil {
+		return nil
+	}
+	_, err := getCategory(db, "id = ?", *id)
+	return err
+}
+
+// lockVariant loads a variant after touching it, so its stock cannot move
+// until tx ends.
+func lockVariant(tx *gorm.DB, id uint) (*ProductVariant, error) {
+	result := tx.Model(&ProductVariant{}).Where("id = ?", id).Update("updated_at", time.Now())
+	if result.Error != nil {
+		return nil, fmt.Errorf("error locking variant: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrVariantNotFound
+	}
+	return getVariant(tx, id)
+}
+
+func getPromotion(q *gorm.DB) (*Promotion, error) {
+	var p Promotion
+	if err := q.First(&p).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPromotionNotFound
+		}
+		return nil, fmt.Errorf("error getting promotion: %w", err)
+	}
+	return &p, nil
+}
+
+func redemptionsOf(db *gorm.DB, promotionID uint, r Redeemer) *gorm.DB {
+	q := db.Model(&PromotionRedemption{}).Where("promotion_id = ?", promotionID)
+	if r.UserID != nil {
+		return q.Where("user_id = ?", *r.UserID)
+	}
+	return q.Where("user_id IS NULL AND email = ?", r.Email)
+}
+
+// claimPromotions counts a use of every promotion of the order against its
+// limits. The conditional increment locks the promotion row, so concurrent
+// orders redeeming it wait for each other before counting the buyer's
+// redemptions; promotions are claimed in ID order so that two orders never
+// wait on each other's. The count is a locking read, which sees the
+// redemptions committed while waiting even under MySQL's repeatable read.
+// It returns the redemptions to record once the order exists.
+func claimPromotions(tx *gorm.DB, o *Order) ([]PromotionRedemption, error) {
+	buyer := Redeemer{UserID: o.UserID, Email: o.Email}
+	discounts := slices.Clone(o.Discounts)
+	slices.SortFunc(discounts, func(a, b OrderDiscount) int { return cmp.Compare(a.PromotionID, b.PromotionID) })
+	var redemptions []PromotionRedemption
+	for _, d := range discounts {
+		result := tx.Model(&Promotion{}).
+			Where("id = ? AND (usage_limit = 0 OR used_count < usage_limit)", d.PromotionID).
+			UpdateColumn("used_count", gorm.Expr("used_count + 1"))
+		if result.Error != nil {
+			return nil, fmt.Errorf("error redeeming promotion: %w", result.Error)
+		}
+		p, err := getPromotion(tx.Where("id = ?", d.PromotionID))
+		if err != nil {
+			return nil, err
+		}
+		if result.RowsAffected == 0 {
+			return nil, ErrPromotionUsedUp
+		}
+		if p.PerUserLimit > 0 {
+			var n int64
+			if err := redemptionsOf(tx, p.ID, buyer).Clauses(clause.Locking{Strength: "UPDATE"}).Count(&n).Error; err != nil {
+				return nil, fmt.Errorf("error counting promotion redemptions: %w", err)
+			}
+			if int(n) >= p.PerUserLimit {
+				return nil, ErrPromotionUserLimit
+			}
+		}
+		redemptions = append(redemptions, PromotionRedemption{PromotionID: p.ID, UserID: o.UserID, Email: o.Email})
+	}
+	return redemptions, nil
+}
+
+// updateOrderStatus applies c to the order inside tx, restoring stock and
+// recording the status event.
+func updateOrderStatus(tx *gorm.DB, id uint, c StatusChange) (*Order, error) {
+	var o Order
+	if err := tx.First(&o, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, fmt.Errorf("error getting order: %w", err)
+	}
+	from := o.Status
+	if err := CheckTransition(from, c); err != nil {
+		return nil, err
+	}
+	// the status guard makes a concurrent change lose instead of
+	// applying a transition from a status the order has already left
+	now := time.Now()
+	result := tx.Model(&Order{}).Where("id = ? AND status = ?", id, from).
+		Updates(map[string]interface{}{"status": c.To, "updated_at": now})
+	if result.Error != nil {
+		return nil, fmt.Errorf("error updating order status: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("%w: order %d changed concurrently", ErrInvalidTransition, id)
+	}
+	if ReleasesStock(from, c.To) {
+		if err := restoreStock(tx, id); err != nil {
+			return nil, err
+		}
+	}
+	if c.To == OrderCancelled {
+		if err := releasePromotions(tx, id); err != nil {
+			return nil, err
+		}
+	}
+	event := NewStatusEvent(id, from, c, now)
+	if err := tx.Create(&event).Error; err != nil {
+		return nil, fmt.Errorf("error recording order status: %w", err)
+	}
+	if err := tx.Preload("Items").First(&o, id).Error; err != nil {
+		return nil, fmt.Errorf("error getting order: %w", err)
+	}
+	return &o, nil
+}
+
+// restoreStock puts the items of an order back into stock.
+func restoreStock(tx *gorm.DB, orderID uint) error {
+	var items []OrderItem
+	if err := tx.Where("order_id = ?", orderID).Find(&items).Error; err != nil {
+		return fmt.Errorf("error loading order items: %w", err)
+	}
+	for _, line := range StockDemand(items) {
+		var variantID *uint
+		if line.VariantID != 0 {
+			variantID = &line.VariantID
+		}
+		if err := restock(tx, line.ProductID, variantID, line.Quantity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releasePromotions gives back the promotion uses of an order, so that a
+// cancelled or deleted order counts against no usage limit.
+func releasePromotions(tx *gorm.DB, orderID uint) error {
+	redeemed := tx.Model(&PromotionRedemption{}).Select("promotion_id").Where("order_id = ?", orderID)
+	err := tx.Model(&Promotion{}).Where("id IN (?) AND used_count > 0", redeemed).
+		UpdateColumn("used_count", gorm.Expr("used_count - 1")).Error
+	if err != nil {
+		return fmt.Errorf("error releasing promotions: %w", err)
+	}
+	if err := tx.Where("order_id = ?", orderID).Delete(&PromotionRedemption{}).Error; err != nil {
+		return fmt.Errorf("error deleting promotion redemptions: %w", err)
+	}
+	return nil
+}
+
+func getCart(db *gorm.DB, query string, args ...interface{}) (*Cart, error) {
+	var c Cart
+	result := db.Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).Where(query, args...).First(&c)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrCartNotFound
+		}
+		return nil, fmt.Errorf("error getting cart: %w", result.Error)
+	}
+	return &c, nil
+}
+
+// touchCart bumps the cart's updated_at, failing with ErrCartNotFound when
+// it does not exist.
+func touchCart(tx *gorm.DB, cartID uint) error {
+	var count int64
+	if err := tx.Model(&Cart{}).Where("id = ?", cartID).Count(&count).Error; err != nil {
+		return fmt.Errorf("error getting cart: %w", err)
+	}
+	if count == 0 {
+		return ErrCartNotFound
+	}
+	if err := tx.Model(&Cart{}).Where("id = ?", cartID).Update("updated_at", time.Now()).Error; err != nil {
+		return fmt.Errorf("error updating cart: %w", err)
+	}
+	return nil
+}
+
+func getPayment(db *gorm.DB, query string, args ...interface{}) (*Payment, error) {
+	var p Payment
+	if err := db.Where(query, args...).First(&p).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPaymentNotFound
+		}
+		return nil, fmt.Errorf("error getting payment: %w", err)
+	}
+	return &p, nil
+}
+
+// settlePayment applies updates to a payment that is in status from. The
+// guard makes every settlement happen once, however often it is retried.
+func settlePayment(db *gorm.DB, id uint, from PaymentStatus, updates map[string]interface{}) (*Payment, error) {
+	updates["updated_at"] = time.Now()
+	result := db.Model(&Payment{}).Where("id = ? AND status = ?", id, from).Updates(updates)
+	if result.Error != nil {
+		return nil, fmt.Errorf("error updating payment: %w", result.Error)
+	}
+	p, err := getPayment(db, "id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if result.RowsAffected == 0 {
+		return nil, notInStatus(from)
+	}
+	return p, nil
+}
+
+// getAddress fails with ErrAddressNotFound when there is no such address.
+func getAddress(db *gorm.DB, id uint) (*Address, error) {
+	var a Address
+	if err := db.First(&a, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAddressNotFound
+		}
+		return nil, fmt.Errorf("error getting address: %w", err)
+	}
+	return &a, nil
+}
+
+// lockAddressBook touches the user so that changes to their addresses, and
+// so to which one is the default, run one at a time.
+func lockAddressBook(tx *gorm.DB, userID uint) error {
+	result := tx.Model(&User{}).Where("id = ?", userID).Update("updated_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("error locking user: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func clearDefaultAddress(tx *gorm.DB, userID uint) error {
+	err := tx.Model(&Address{}).Where("user_id = ? AND is_default = ?", userID, true).Update("is_default", false).Error
+	if err != nil {
+		return fmt.Errorf("error clearing default address: %w", err)
+	}
+	return nil
+}
+
+// orderExists fails with ErrOrderNotFound when there is no such order.
+func orderExists(db *gorm.DB, id uint) error {
+	var count int64
+	if err := db.Model(&Order{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		return fmt.Errorf("error getting order: %w", err)
+	}
+	if count == 0 {
+		return ErrOrderNotFound
+	}
+	return nil
+}
+
+func getReturn(db *gorm.DB, id uint) (*ReturnRequest, error) {
+	var r ReturnRequest
+	if err := db.First(&r, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrReturnNotFound
+		}
+		return nil, fmt.Errorf("error getting return: %w", err)
+	}
+	return &r, nil
+}
+
+// updateReturnStatus applies c to the return inside tx, restocking its
+// items when it is received.
+func updateReturnStatus(tx *gorm.DB, id uint, c ReturnChange) (*ReturnRequest, error) {
+	r, err := getReturn(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckReturnTransition(r.Status, c); err != nil {
+		return nil, err
+	}
+	result := tx.Model(&ReturnRequest{}).Where("id = ? AND status = ?", id, r.Status).Updates(map[string]interface{}{
+		"status":      c.To,
+		"reviewed_by": c.ChangedBy,
+		"review_note": c.Note,
+		"updated_at":  time.Now(),
+	})
+	if result.Error != nil {
+		return nil, fmt.Errorf("error updating return status: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("%w: return %d changed concurrently", ErrInvalidReturnTransition, id)
+	}
+	if c.To == ReturnReceived {
+		var item OrderItem
+		if err := tx.First(&item, r.OrderItemID).Error; err != nil {
+			return nil, fmt.Errorf("error getting order item: %w", err)
+		}
+		if err := restock(tx, item.ProductID, item.VariantID, r.Quantity); err != nil {
+			return nil, fmt.Errorf("error restocking returned items: %w", err)
+		}
+	}
+	return getReturn(tx, id)
+}
+
+// settleRefund applies updates to a pending refund, once.
+func settleRefund(db *gorm.DB, id uint, updates map[string]interface{}) (*Refund, error) {
+	updates["updated_at"] = time.Now()
+	result := db.Model(&Refund{}).Where("id = ? AND status = ?", id, RefundPending).Updates(updates)
+	if result.Error != nil {
+		return nil, fmt.Errorf("error updating refund: %w", result.Error)
+	}
+	var r Refund
+	if err := db.First(&r, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRefundNotFound
+		}
+		return nil, fmt.Errorf("error getting refund: %w", err)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrRefundNotPending
+	}
+	return &r, nil
+}
+
+func filtered(db *gorm.DB, lq *ListQuery) *gorm.DB {
+	if lq.Filter != "" {
+		db = db.Where(lq.Filter, lq.FilterArgs...)
+	}
+	return db
+}
+
+func paged(db *gorm.DB, lq *ListQuery) *gorm.DB {
+	db = filtered(db, lq)
+	if lq.After != "" {
+		db = db.Where(lq.After, lq.AfterArgs...)
+	}
+	return db.Order(lq.OrderBy).Limit(lq.Limit + 1).Offset(lq.Offset)
 }
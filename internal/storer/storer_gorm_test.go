@@ -0,0 +1,41 @@
+package storer_test
+
+import (
+	"context"
+	"ecom_apiv1/db/migrate"
+	"ecom_apiv1/internal/storer"
+	"ecom_apiv1/internal/storer/storertest"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestGORMStorageSQLite(t *testing.T) {
+	storertest.Run(t, func(t *testing.T) storer.Store {
+		db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+			Logger:         logger.Default.LogMode(logger.Silent),
+			TranslateError: true,
+		})
+		if err != nil {
+			t.Fatalf("failed to open sqlite: %v", err)
+		}
+		sqlDB, err := db.DB()
+		if err != nil {
+			t.Fatalf("failed to get sql.DB: %v", err)
+		}
+		// :memory: databases are per connection, keep everything on one.
+		sqlDB.SetMaxOpenConns(1)
+		t.Cleanup(func() { sqlDB.Close() })
+
+		migrator, err := migrate.New(sqlDB, migrate.SQLite)
+		if err != nil {
+			t.Fatalf("failed to load migrations: %v", err)
+		}
+		if err := migrator.Up(context.Background()); err != nil {
+			t.Fatalf("failed to migrate: %v", err)
+		}
+		return storer.NewGORMStorage(db)
+	})
+}
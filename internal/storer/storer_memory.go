@@ -0,0 +1,1931 @@
+package storer
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"ecom_apiv1/internal/search"
+)
+
+// MemoryStorage is a thread-safe, map-backed Store. It is meant for unit
+// tests and local development; nothing is persisted.
+type MemoryStorage struct {
+	mu sync.RWMutex
+
+	products map[uint]Product
+	variants map[uint]ProductVariant
+	cats     map[uint]Category
+	reviews  map[uint]Review
+	images   map[uint]ProductImage
+	orders   map[uint]Order
+	users    map[uint]User
+	sessions map[string]Session
+	events   map[uint][]OrderStatusEvent
+	carts    map[uint]Cart
+	payments map[uint]Payment
+	webhooks map[string]WebhookEvent
+	idemKeys map[string]IdempotencyKey
+	returns  map[uint]ReturnRequest
+	refunds  map[uint]Refund
+	addrs    map[uint]Address
+	promos   map[uint]Promotion
+	redeemed []PromotionRedemption
+	index    *search.Index
+
+	nextProductID   uint
+	nextVariantID   uint
+	nextCategoryID  uint
+	nextReviewID    uint
+	nextImageID     uint
+	nextOrderID     uint
+	nextOrderItemID uint
+	nextEventID     uint
+	nextUserID      uint
+	nextCartID      uint
+	nextCartItemID  uint
+	nextPaymentID   uint
+	nextWebhookID   uint
+	nextIdemKeyID   uint
+	nextReturnID    uint
+	nextRefundID    uint
+	nextAddressID   uint
+	nextPromotionID uint
+	nextRedeemedID  uint
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		products: make(map[uint]Product),
+		variants: make(map[uint]ProductVariant),
+		cats:     make(map[uint]Category),
+		reviews:  make(map[uint]Review),
+		images:   make(map[uint]ProductImage),
+		orders:   make(map[uint]Order),
+		users:    make(map[uint]User),
+		sessions: make(map[string]Session),
+		events:   make(map[uint][]OrderStatusEvent),
+		carts:    make(map[uint]Cart),
+		payments: make(map[uint]Payment),
+		webhooks: make(map[string]WebhookEvent),
+		idemKeys: make(map[string]IdempotencyKey),
+		returns:  make(map[uint]ReturnRequest),
+		refunds:  make(map[uint]Refund),
+		addrs:    make(map[uint]Address),
+		promos:   make(map[uint]Promotion),
+		index:    newProductIndex(),
+	}
+}
+
+func (ms *MemoryStorage) CreateProduct(ctx context.Context, p *Product) (*Product, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.nextProductID++
+	p.ID = ms.nextProductID
+	now := time.Now()
+	p.CreatedAt, p.UpdatedAt = now, now
+	ms.products[p.ID] = copyProduct(*p)
+	ms.index.Add(p.ID, productSearchFields(p))
+	return p, nil
+}
+
+func (ms *MemoryStorage) GetProduct(ctx context.Context, id uint) (*Product, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	p, ok := ms.products[id]
+	if !ok {
+		return nil, ErrProductNotFound
+	}
+	return &p, nil
+}
+
+func (ms *MemoryStorage) GetProducts(ctx context.Context, ids []uint) ([]Product, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	products := []Product{}
+	for _, id := range ids {
+		if p, ok := ms.products[id]; ok {
+			products = append(products, p)
+		}
+	}
+	slices.SortFunc(products, func(a, b Product) int { return cmp.Compare(a.ID, b.ID) })
+	return slices.CompactFunc(products, func(a, b Product) bool { return a.ID == b.ID }), nil
+}
+
+func (ms *MemoryStorage) ListProducts(ctx context.Context, q ProductQuery) (*Page[Product], error) {
+	lq, err := q.Compile()
+	if err != nil {
+		return nil, err
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	products := make([]Product, 0, len(ms.products))
+	for _, p := range ms.products {
+		if q.ProductFilter.match(&p) {
+			products = append(products, p)
+		}
+	}
+	return memoryPage(lq, products, productSortValue), nil
+}
+
+func (ms *MemoryStorage) SearchProducts(ctx context.Context, q ProductSearchQuery) (*SearchResult, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return searchIndex(ms.index, q, func(ids []uint) (map[uint]Product, error) {
+		products := make(map[uint]Product, len(ids))
+		for _, id := range ids {
+			if p, ok := ms.products[id]; ok {
+				products[id] = p
+			}
+		}
+		return products, nil
+	})
+}
+
+func (ms *MemoryStorage) GetProductFacets(ctx context.Context, f ProductFilter) (*ProductFacets, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	products := make([]Product, 0, len(ms.products))
+	for _, p := range ms.products {
+		if f.match(&p) {
+			products = append(products, p)
+		}
+	}
+	return memoryFacets(products)
+}
+
+func (ms *MemoryStorage) UpdateProduct(ctx context.Context, p *Product) (*Product, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	current, ok := ms.products[p.ID]
+	if !ok {
+		return nil, fmt.Errorf("error updating product: %w", ErrProductNotFound)
+	}
+	p.Rating, p.NumReviews = current.Rating, current.NumReviews
+	p.UpdatedAt = time.Now()
+	ms.products[p.ID] = copyProduct(*p)
+	ms.index.Add(p.ID, productSearchFields(p))
+	return p, nil
+}
+
+func (ms *MemoryStorage) DeleteProduct(ctx context.Context, id uint) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.products[id]; !ok {
+		return ErrProductNotFound
+	}
+	delete(ms.products, id)
+	for vid, v := range ms.variants {
+		if v.ProductID == id {
+			delete(ms.variants, vid)
+		}
+	}
+	for rid, r := range ms.reviews {
+		if r.ProductID == id {
+			delete(ms.reviews, rid)
+		}
+	}
+	for iid, img := range ms.images {
+		if img.ProductID == id {
+			delete(ms.images, iid)
+		}
+	}
+	ms.index.Remove(id)
+	return nil
+}
+
+func (ms *MemoryStorage) CreateVariant(ctx context.Context, v *ProductVariant) (*ProductVariant, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.products[v.ProductID]; !ok {
+		return nil, fmt.Errorf("error creating variant: %w", ErrProductNotFound)
+	}
+	if ms.skuTaken(v.SKU, 0) {
+		return nil, fmt.Errorf("error creating variant: %w", ErrSKUTaken)
+	}
+	ms.nextVariantID++
+	v.ID = ms.nextVariantID
+	now := time.Now()
+	v.CreatedAt, v.UpdatedAt = now, now
+	ms.variants[v.ID] = copyVariant(*v)
+	ms.moveProductStock(v.ProductID, v.CountInStock)
+	return v, nil
+}
+
+func (ms *MemoryStorage) GetVariant(ctx context.Context, id uint) (*ProductVariant, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	v, ok := ms.variants[id]
+	if !ok {
+		return nil, ErrVariantNotFound
+	}
+	v = copyVariant(v)
+	return &v, nil
+}
+
+func (ms *MemoryStorage) GetVariants(ctx context.Context, ids []uint) ([]ProductVariant, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	ids = slices.Clone(ids)
+	slices.Sort(ids)
+	variants := []ProductVariant{}
+	for _, id := range slices.Compact(ids) {
+		if v, ok := ms.variants[id]; ok {
+			variants = append(variants, copyVariant(v))
+		}
+	}
+	return variants, nil
+}
+
+func (ms *MemoryStorage) ListVariants(ctx context.Context, productID uint) ([]ProductVariant, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	variants := []ProductVariant{}
+	for _, v := range ms.variants {
+		if v.ProductID == productID {
+			variants = append(variants, copyVariant(v))
+		}
+	}
+	slices.SortFunc(variants, func(a, b ProductVariant) int { return cmp.Compare(a.ID, b.ID) })
+	return variants, nil
+}
+
+func (ms *MemoryStorage) UpdateVariant(ctx context.Context, v *ProductVariant) (*ProductVariant, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	current, ok := ms.variants[v.ID]
+	if !ok {
+		return nil, fmt.Errorf("error updating variant: %w", ErrVariantNotFound)
+	}
+	if ms.skuTaken(v.SKU, v.ID) {
+		return nil, fmt.Errorf("error updating variant: %w", ErrSKUTaken)
+	}
+	v.ProductID, v.CreatedAt = current.ProductID, current.CreatedAt
+	v.UpdatedAt = time.Now()
+	ms.variants[v.ID] = copyVariant(*v)
+	ms.moveProductStock(v.ProductID, v.CountInStock-current.CountInStock)
+	return v, nil
+}
+
+func (ms *MemoryStorage) DeleteVariant(ctx context.Context, id uint) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	v, ok := ms.variants[id]
+	if !ok {
+		return fmt.Errorf("error deleting variant: %w", ErrVariantNotFound)
+	}
+	for _, o := range ms.orders {
+		if !o.Status.HoldsStock() {
+			continue
+		}
+		for _, item := range o.Items {
+			if item.VariantID != nil && *item.VariantID == id {
+				return fmt.Errorf("error deleting variant: %w", ErrVariantInUse)
+			}
+		}
+	}
+	for oid, o := range ms.orders {
+		for i, item := range o.Items {
+			if item.VariantID != nil && *item.VariantID == id {
+				o.Items[i].VariantID = nil
+			}
+		}
+		ms.orders[oid] = o
+	}
+	for cid, c := range ms.carts {
+		c.Items = slices.DeleteFunc(copyCart(c).Items, func(item CartItem) bool { return item.IsLine(v.ProductID, &id) })
+		ms.carts[cid] = c
+	}
+	delete(ms.variants, id)
+	ms.moveProductStock(v.ProductID, -v.CountInStock)
+	return nil
+}
+
+func (ms *MemoryStorage) CreateProductImage(ctx context.Context, img *ProductImage) (*ProductImage, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.products[img.ProductID]; !ok {
+		return nil, fmt.Errorf("error creating product image: %w", ErrProductNotFound)
+	}
+	img.Position = 0
+	for _, other := range ms.images {
+		if other.ProductID == img.ProductID && other.Position >= img.Position {
+			img.Position = other.Position + 1
+		}
+	}
+	ms.nextImageID++
+	img.ID = ms.nextImageID
+	now := time.Now()
+	img.CreatedAt, img.UpdatedAt = now, now
+	ms.images[img.ID] = *img
+	return img, nil
+}
+
+func (ms *MemoryStorage) GetProductImage(ctx context.Context, id uint) (*ProductImage, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	img, ok := ms.images[id]
+	if !ok {
+		return nil, ErrImageNotFound
+	}
+	return &img, nil
+}
+
+func (ms *MemoryStorage) ListProductImages(ctx context.Context, productID uint) ([]ProductImage, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return ms.productImages(productID), nil
+}
+
+func (ms *MemoryStorage) UpdateProductImage(ctx context.Context, img *ProductImage) (*ProductImage, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	current, ok := ms.images[img.ID]
+	if !ok {
+		return nil, fmt.Errorf("error updating product image: %w", ErrImageNotFound)
+	}
+	current.AltText = img.AltText
+	current.UpdatedAt = time.Now()
+	ms.images[img.ID] = current
+	return &current, nil
+}
+
+func (ms *MemoryStorage) ReorderProductImages(ctx context.Context, productID uint, ids []uint) ([]ProductImage, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.products[productID]; !ok {
+		return nil, fmt.Errorf("error reordering product images: %w", ErrProductNotFound)
+	}
+	if err := CheckImageOrder(ms.productImages(productID), ids); err != nil {
+		return nil, fmt.Errorf("error reordering product images: %w", err)
+	}
+	for position, id := range ids {
+		img := ms.images[id]
+		img.Position = position
+		ms.images[id] = img
+	}
+	return ms.productImages(productID), nil
+}
+
+func (ms *MemoryStorage) DeleteProductImage(ctx context.Context, id uint) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.images[id]; !ok {
+		return fmt.Errorf("error deleting product image: %w", ErrImageNotFound)
+	}
+	delete(ms.images, id)
+	return nil
+}
+
+// productImages returns the images of a product in display order; callers
+// hold ms.mu.
+func (ms *MemoryStorage) productImages(productID uint) []ProductImage {
+	images := []ProductImage{}
+	for _, img := range ms.images {
+		if img.ProductID == productID {
+			images = append(images, img)
+		}
+	}
+	slices.SortFunc(images, func(a, b ProductImage) int {
+		return cmp.Or(cmp.Compare(a.Position, b.Position), cmp.Compare(a.ID, b.ID))
+	})
+	return images
+}
+
+func (ms *MemoryStorage) CreateCategory(ctx context.Context, c *Category) (*Category, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if err := ms.checkCategory(c); err != nil {
+		return nil, fmt.Errorf("error creating category: %w", err)
+	}
+	ms.nextCategoryID++
+	c.ID = ms.nextCategoryID
+	now := time.Now()
+	c.CreatedAt, c.UpdatedAt = now, now
+	ms.cats[c.ID] = *c
+	return c, nil
+}
+
+func (ms *MemoryStorage) GetCategory(ctx context.Context, id uint) (*Category, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	c, ok := ms.cats[id]
+	if !ok {
+		return nil, ErrCategoryNotFound
+	}
+	return &c, nil
+}
+
+func (ms *MemoryStorage) GetCategoryBySlug(ctx context.Context, slug string) (*Category, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, c := range ms.cats {
+		if c.Slug == slug {
+			return &c, nil
+		}
+	}
+	return nil, ErrCategoryNotFound
+}
+
+func (ms *MemoryStorage) ListCategories(ctx context.Context) ([]Category, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	categories := make([]Category, 0, len(ms.cats))
+	for _, c := range ms.cats {
+		categories = append(categories, c)
+	}
+	sortCategories(categories)
+	return categories, nil
+}
+
+func (ms *MemoryStorage) UpdateCategory(ctx context.Context, c *Category) (*Category, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	current, ok := ms.cats[c.ID]
+	if !ok {
+		return nil, fmt.Errorf("error updating category: %w", ErrCategoryNotFound)
+	}
+	if err := ms.checkCategory(c); err != nil {
+		return nil, fmt.Errorf("error updating category: %w", err)
+	}
+	c.CreatedAt, c.UpdatedAt = current.CreatedAt, time.Now()
+	ms.cats[c.ID] = *c
+	ms.moveCategoryProducts(c.ID, c)
+	return c, nil
+}
+
+func (ms *MemoryStorage) DeleteCategory(ctx context.Context, id uint, moveTo *uint) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.cats[id]; !ok {
+		return fmt.Errorf("error deleting category: %w", ErrCategoryNotFound)
+	}
+	for _, c := range ms.cats {
+		if c.ParentID != nil && *c.ParentID == id {
+			return fmt.Errorf("error deleting category: %w", ErrCategoryInUse)
+		}
+	}
+	var target *Category
+	if moveTo != nil {
+		c, ok := ms.cats[*moveTo]
+		if !ok {
+			return fmt.Errorf("error deleting category: %w", ErrCategoryNotFound)
+		}
+		target = &c
+	}
+	for _, p := range ms.products {
+		if p.CategoryID != nil && *p.CategoryID == id && target == nil {
+			return fmt.Errorf("error deleting category: %w", ErrCategoryInUse)
+		}
+	}
+	if target != nil {
+		ms.moveCategoryProducts(id, target)
+	}
+	delete(ms.cats, id)
+	return nil
+}
+
+func (ms *MemoryStorage) CreateReview(ctx context.Context, r *Review) (*Review, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.products[r.ProductID]; !ok {
+		return nil, fmt.Errorf("error creating review: %w", ErrProductNotFound)
+	}
+	for _, other := range ms.reviews {
+		if other.ProductID == r.ProductID && other.UserID == r.UserID {
+			return nil, fmt.Errorf("error creating review: %w", ErrReviewExists)
+		}
+	}
+	ms.nextReviewID++
+	r.ID = ms.nextReviewID
+	now := time.Now()
+	r.CreatedAt, r.UpdatedAt = now, now
+	ms.reviews[r.ID] = *r
+	ms.refreshRating(r.ProductID)
+	return r, nil
+}
+
+func (ms *MemoryStorage) GetReview(ctx context.Context, id uint) (*Review, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	r, ok := ms.reviews[id]
+	if !ok {
+		return nil, ErrReviewNotFound
+	}
+	return &r, nil
+}
+
+func (ms *MemoryStorage) ListReviews(ctx context.Context, q ReviewQuery) (*Page[Review], error) {
+	lq, err := q.Compile()
+	if err != nil {
+		return nil, err
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	reviews := make([]Review, 0, len(ms.reviews))
+	for _, r := range ms.reviews {
+		if q.ReviewFilter.match(&r) {
+			reviews = append(reviews, r)
+		}
+	}
+	return memoryPage(lq, reviews, reviewSortValue), nil
+}
+
+func (ms *MemoryStorage) UpdateReview(ctx context.Context, r *Review) (*Review, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	current, ok := ms.reviews[r.ID]
+	if !ok {
+		return nil, fmt.Errorf("error updating review: %w", ErrReviewNotFound)
+	}
+	r.ProductID, r.UserID, r.CreatedAt = current.ProductID, current.UserID, current.CreatedAt
+	r.UpdatedAt = time.Now()
+	ms.reviews[r.ID] = *r
+	ms.refreshRating(r.ProductID)
+	return r, nil
+}
+
+func (ms *MemoryStorage) DeleteReview(ctx context.Context, id uint) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	r, ok := ms.reviews[id]
+	if !ok {
+		return fmt.Errorf("error deleting review: %w", ErrReviewNotFound)
+	}
+	delete(ms.reviews, id)
+	ms.refreshRating(r.ProductID)
+	return nil
+}
+
+func (ms *MemoryStorage) HasDeliveredOrder(ctx context.Context, userID, productID uint) (bool, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, o := range ms.orders {
+		if o.UserID == nil || *o.UserID != userID || o.Status != OrderDelivered {
+			continue
+		}
+		for _, item := range o.Items {
+			if item.ProductID == productID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// refreshRating recomputes the Rating and NumReviews of the product from
+// its approved reviews; callers hold ms.mu.
+func (ms *MemoryStorage) refreshRating(productID uint) {
+	p, ok := ms.products[productID]
+	if !ok {
+		return
+	}
+	sum, count := 0, 0
+	for _, r := range ms.reviews {
+		if r.ProductID == productID && r.Status == ReviewApproved {
+			sum += r.Rating
+			count++
+		}
+	}
+	p.Rating, p.NumReviews = averageRating(sum, count), count
+	p.UpdatedAt = time.Now()
+	ms.products[productID] = p
+}
+
+// checkCategory checks the parent of c exists and its slug is free; callers
+// hold ms.mu.
+func (ms *MemoryStorage) checkCategory(c *Category) error {
+	if c.ParentID != nil {
+		if _, ok := ms.cats[*c.ParentID]; !ok {
+			return ErrCategoryNotFound
+		}
+	}
+	for _, other := range ms.cats {
+		if other.Slug == c.Slug && other.ID != c.ID {
+			return ErrCategorySlugTaken
+		}
+	}
+	return nil
+}
+
+// moveCategoryProducts files the products of category from under to;
+// callers hold ms.mu.
+func (ms *MemoryStorage) moveCategoryProducts(from uint, to *Category) {
+	for id, p := range ms.products {
+		if p.CategoryID != nil && *p.CategoryID == from {
+			categoryID := to.ID
+			p.CategoryID, p.Category = &categoryID, to.Name
+			ms.products[id] = p
+			ms.index.Add(id, productSearchFields(&p))
+		}
+	}
+}
+
+// skuTaken reports whether a variant other than except uses sku; callers
+// hold ms.mu.
+func (ms *MemoryStorage) skuTaken(sku string, except uint) bool {
+	for _, v := range ms.variants {
+		if v.SKU == sku && v.ID != except {
+			return true
+		}
+	}
+	return false
+}
+
+func (ms *MemoryStorage) CreateOrder(ctx context.Context, o *Order) (*Order, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, item := range o.Items {
+		if _, ok := ms.products[item.ProductID]; !ok {
+			return nil, fmt.Errorf("error creating order: %w", ErrProductNotFound)
+		}
+		if item.VariantID == nil {
+			continue
+		}
+		if v, ok := ms.variants[*item.VariantID]; !ok || v.ProductID != item.ProductID {
+			return nil, fmt.Errorf("error creating order: %w", ErrVariantNotFound)
+		}
+	}
+	demand := StockDemand(o.Items)
+	var shortages []StockShortage
+	for _, line := range demand {
+		available := ms.products[line.ProductID].CountInStock
+		if line.VariantID != 0 {
+			available = ms.variants[line.VariantID].CountInStock
+		}
+		if available < line.Quantity {
+			shortages = append(shortages, StockShortage{ProductID: line.ProductID, VariantID: line.VariantID, Requested: line.Quantity, Available: available})
+		}
+	}
+	if len(shortages) > 0 {
+		return nil, fmt.Errorf("error creating order: %w", &InsufficientStockError{Items: shortages})
+	}
+	buyer := Redeemer{UserID: o.UserID, Email: o.Email}
+	for _, d := range o.Discounts {
+		p, ok := ms.promos[d.PromotionID]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("error creating order: %w", ErrPromotionNotFound)
+		case p.UsedUp():
+			return nil, fmt.Errorf("error creating order: %w", ErrPromotionUsedUp)
+		case p.PerUserLimit > 0 && ms.countRedemptions(p.ID, buyer) >= p.PerUserLimit:
+			return nil, fmt.Errorf("error creating order: %w", ErrPromotionUserLimit)
+		}
+	}
+	for _, line := range demand {
+		if line.VariantID != 0 {
+			v := ms.variants[line.VariantID]
+			v.CountInStock -= line.Quantity
+			ms.variants[v.ID] = v
+		}
+		ms.moveProductStock(line.ProductID, -line.Quantity)
+	}
+	ms.nextOrderID++
+	o.ID = ms.nextOrderID
+	now := time.Now()
+	o.CreatedAt, o.UpdatedAt = now, now
+	for i := range o.Items {
+		ms.nextOrderItemID++
+		o.Items[i].ID = ms.nextOrderItemID
+		o.Items[i].OrderID = o.ID
+		o.Items[i].CreatedAt, o.Items[i].UpdatedAt = now, now
+	}
+	if o.Status == "" {
+		o.Status = OrderPending
+	}
+	for _, d := range o.Discounts {
+		p := ms.promos[d.PromotionID]
+		p.UsedCount++
+		ms.promos[p.ID] = p
+		ms.nextRedeemedID++
+		ms.redeemed = append(ms.redeemed, PromotionRedemption{
+			ID:          ms.nextRedeemedID,
+			CreatedAt:   now,
+			PromotionID: p.ID,
+			OrderID:     o.ID,
+			UserID:      o.UserID,
+			Email:       o.Email,
+		})
+	}
+	ms.orders[o.ID] = copyOrder(*o)
+	if o.CartID != nil {
+		if c, ok := ms.carts[*o.CartID]; ok {
+			c.Items = []CartItem{}
+			c.UpdatedAt = now
+			ms.carts[c.ID] = c
+		}
+	}
+	ms.addEvent(NewStatusEvent(o.ID, "", StatusChange{To: o.Status, ChangedBy: o.UserID}, now))
+	return o, nil
+}
+
+func (ms *MemoryStorage) GetOrderByID(ctx context.Context, id uint) (*Order, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	o, ok := ms.orders[id]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	o = copyOrder(o)
+	return &o, nil
+}
+
+func (ms *MemoryStorage) GetOrderByAccessToken(ctx context.Context, tokenHash string) (*Order, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, o := range ms.orders {
+		if o.AccessTokenHash != nil && *o.AccessTokenHash == tokenHash {
+			o = copyOrder(o)
+			return &o, nil
+		}
+	}
+	return nil, ErrOrderNotFound
+}
+
+func (ms *MemoryStorage) ClaimOrder(ctx context.Context, tokenHash string, userID uint) (*Order, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for id, o := range ms.orders {
+		if o.AccessTokenHash != nil && *o.AccessTokenHash == tokenHash {
+			o.UserID = &userID
+			o.AccessTokenHash = nil
+			o.UpdatedAt = time.Now()
+			ms.orders[id] = o
+			o = copyOrder(o)
+			return &o, nil
+		}
+	}
+	return nil, ErrOrderNotFound
+}
+
+func (ms *MemoryStorage) UpdateOrderStatus(ctx context.Context, id uint, c StatusChange) (*Order, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	o, err := ms.updateOrderStatus(id, c)
+	if err != nil {
+		return nil, fmt.Errorf("error updating order status: %w", err)
+	}
+	return o, nil
+}
+
+// updateOrderStatus applies c to the order; callers hold ms.mu.
+func (ms *MemoryStorage) updateOrderStatus(id uint, c StatusChange) (*Order, error) {
+	o, ok := ms.orders[id]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	from := o.Status
+	if err := CheckTransition(from, c); err != nil {
+		return nil, err
+	}
+	if ReleasesStock(from, c.To) {
+		ms.restoreStock(o.Items)
+	}
+	if c.To == OrderCancelled {
+		ms.releasePromotions(id)
+	}
+	now := time.Now()
+	o.Status = c.To
+	o.UpdatedAt = now
+	ms.orders[id] = o
+	ms.addEvent(NewStatusEvent(id, from, c, now))
+	o = copyOrder(o)
+	return &o, nil
+}
+
+func (ms *MemoryStorage) ListOrderStatusEvents(ctx context.Context, orderID uint) ([]OrderStatusEvent, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if _, ok := ms.orders[orderID]; !ok {
+		return nil, ErrOrderNotFound
+	}
+	return append([]OrderStatusEvent{}, ms.events[orderID]...), nil
+}
+
+// addEvent stores e with a fresh ID; callers hold ms.mu.
+func (ms *MemoryStorage) addEvent(e OrderStatusEvent) {
+	ms.nextEventID++
+	e.ID = ms.nextEventID
+	ms.events[e.OrderID] = append(ms.events[e.OrderID], e)
+}
+
+func (ms *MemoryStorage) ListOrders(ctx context.Context, q OrderQuery) (*Page[Order], error) {
+	lq, err := q.Compile()
+	if err != nil {
+		return nil, err
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	orders := make([]Order, 0, len(ms.orders))
+	for _, o := range ms.orders {
+		if q.OrderFilter.match(&o) {
+			orders = append(orders, copyOrder(o))
+		}
+	}
+	return memoryPage(lq, orders, orderSortValue), nil
+}
+
+func (ms *MemoryStorage) DeleteOrder(ctx context.Context, id uint) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	o, ok := ms.orders[id]
+	if !ok {
+		return ErrOrderNotFound
+	}
+	if !o.Status.Deletable() {
+		return ErrOrderNotDeletable
+	}
+	for _, p := range ms.payments {
+		if p.OrderID == id && p.Status == PaymentCaptured {
+			return ErrOrderNotDeletable
+		}
+	}
+	if o.Status.HoldsStock() {
+		ms.restoreStock(o.Items)
+	}
+	ms.releasePromotions(id)
+	delete(ms.orders, id)
+	delete(ms.events, id)
+	for pid, p := range ms.payments {
+		if p.OrderID == id {
+			delete(ms.payments, pid)
+		}
+	}
+	for rid, r := range ms.returns {
+		if r.OrderID == id {
+			delete(ms.returns, rid)
+		}
+	}
+	for rid, r := range ms.refunds {
+		if r.OrderID == id {
+			delete(ms.refunds, rid)
+		}
+	}
+	return nil
+}
+
+// releasePromotions gives back the promotion uses of an order; callers
+// hold ms.mu.
+func (ms *MemoryStorage) releasePromotions(orderID uint) {
+	ms.redeemed = slices.DeleteFunc(ms.redeemed, func(rd PromotionRedemption) bool {
+		if rd.OrderID != orderID {
+			return false
+		}
+		if p, ok := ms.promos[rd.PromotionID]; ok && p.UsedCount > 0 {
+			p.UsedCount--
+			ms.promos[p.ID] = p
+		}
+		return true
+	})
+}
+
+// restoreStock puts items back into stock; callers hold ms.mu.
+func (ms *MemoryStorage) restoreStock(items []OrderItem) {
+	for _, item := range items {
+		ms.restock(item.ProductID, item.VariantID, item.Quantity)
+	}
+}
+
+// restock puts quantity units of the product, and of its variant if any,
+// back into stock; callers hold ms.mu.
+func (ms *MemoryStorage) restock(productID uint, variantID *uint, quantity int) {
+	if variantID != nil {
+		if v, ok := ms.variants[*variantID]; ok {
+			v.CountInStock += quantity
+			ms.variants[v.ID] = v
+		}
+	}
+	ms.moveProductStock(productID, quantity)
+}
+
+// moveProductStock adds delta to the stock of the product, if it still
+// exists; callers hold ms.mu.
+func (ms *MemoryStorage) moveProductStock(productID uint, delta int) {
+	if p, ok := ms.products[productID]; ok {
+		p.CountInStock += delta
+		ms.products[productID] = p
+	}
+}
+
+func (ms *MemoryStorage) CreatePayment(ctx context.Context, p *Payment) (*Payment, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.orders[p.OrderID]; !ok {
+		return nil, ErrOrderNotFound
+	}
+	for _, existing := range ms.payments {
+		if existing.Provider == p.Provider && existing.IntentID == p.IntentID {
+			return nil, fmt.Errorf("error creating payment: intent %s already recorded", p.IntentID)
+		}
+	}
+	ms.nextPaymentID++
+	now := time.Now()
+	p.ID = ms.nextPaymentID
+	p.CreatedAt = now
+	p.UpdatedAt = now
+	ms.payments[p.ID] = *p
+	return p, nil
+}
+
+func (ms *MemoryStorage) GetPayment(ctx context.Context, id uint) (*Payment, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	p, ok := ms.payments[id]
+	if !ok {
+		return nil, ErrPaymentNotFound
+	}
+	return &p, nil
+}
+
+func (ms *MemoryStorage) GetPaymentByIntent(ctx context.Context, provider, intentID string) (*Payment, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, p := range ms.payments {
+		if p.Provider == provider && p.IntentID == intentID {
+			return &p, nil
+		}
+	}
+	return nil, ErrPaymentNotFound
+}
+
+func (ms *MemoryStorage) ListPayments(ctx context.Context, orderID uint) ([]Payment, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if _, ok := ms.orders[orderID]; !ok {
+		return nil, ErrOrderNotFound
+	}
+	payments := []Payment{}
+	for _, p := range ms.payments {
+		if p.OrderID == orderID {
+			payments = append(payments, p)
+		}
+	}
+	slices.SortFunc(payments, func(a, b Payment) int { return cmp.Compare(a.ID, b.ID) })
+	return payments, nil
+}
+
+func (ms *MemoryStorage) CapturePayment(ctx context.Context, id uint, captureID string) (*Payment, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	p, ok := ms.payments[id]
+	if !ok {
+		return nil, ErrPaymentNotFound
+	}
+	if p.Status != PaymentPending {
+		return nil, ErrPaymentNotPending
+	}
+	p.Status = PaymentCaptured
+	p.CaptureID = captureID
+	p.UpdatedAt = time.Now()
+	if _, err := ms.updateOrderStatus(p.OrderID, CaptureStatusChange(&p)); err != nil {
+		return nil, fmt.Errorf("error capturing payment: %w", err)
+	}
+	ms.payments[id] = p
+	return &p, nil
+}
+
+func (ms *MemoryStorage) FailPayment(ctx context.Context, id uint, reason string) (*Payment, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	p, ok := ms.payments[id]
+	if !ok {
+		return nil, ErrPaymentNotFound
+	}
+	if p.Status != PaymentPending {
+		return nil, ErrPaymentNotPending
+	}
+	p.Status = PaymentFailed
+	p.FailureReason = reason
+	p.UpdatedAt = time.Now()
+	ms.payments[id] = p
+	return &p, nil
+}
+
+func (ms *MemoryStorage) GetWebhookEvent(ctx context.Context, provider, eventID string) (*WebhookEvent, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	e, ok := ms.webhooks[provider+"\x00"+eventID]
+	if !ok {
+		return nil, ErrWebhookEventNotFound
+	}
+	return &e, nil
+}
+
+func (ms *MemoryStorage) CreateWebhookEvent(ctx context.Context, e *WebhookEvent) (*WebhookEvent, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := e.Provider + "\x00" + e.EventID
+	if _, ok := ms.webhooks[key]; ok {
+		return nil, ErrWebhookEventExists
+	}
+	ms.nextWebhookID++
+	e.ID = ms.nextWebhookID
+	e.CreatedAt = time.Now()
+	ms.webhooks[key] = *e
+	return e, nil
+}
+
+func (ms *MemoryStorage) CreateReturn(ctx context.Context, r *ReturnRequest) (*ReturnRequest, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	o, ok := ms.orders[r.OrderID]
+	if !ok {
+		return nil, ErrOrderItemNotFound
+	}
+	var item *OrderItem
+	for i := range o.Items {
+		if o.Items[i].ID == r.OrderItemID {
+			item = &o.Items[i]
+		}
+	}
+	if item == nil {
+		return nil, ErrOrderItemNotFound
+	}
+	if ReturnedQuantity(ms.orderReturns(r.OrderID), item.ID)+r.Quantity > item.Quantity {
+		return nil, ErrReturnQuantityExceeded
+	}
+	ms.nextReturnID++
+	now := time.Now()
+	r.ID = ms.nextReturnID
+	r.CreatedAt = now
+	r.UpdatedAt = now
+	r.Status = ReturnRequested
+	ms.returns[r.ID] = *r
+	return r, nil
+}
+
+func (ms *MemoryStorage) GetReturn(ctx context.Context, id uint) (*ReturnRequest, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	r, ok := ms.returns[id]
+	if !ok {
+		return nil, ErrReturnNotFound
+	}
+	return &r, nil
+}
+
+func (ms *MemoryStorage) ListReturns(ctx context.Context, orderID uint) ([]ReturnRequest, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if _, ok := ms.orders[orderID]; !ok {
+		return nil, ErrOrderNotFound
+	}
+	return ms.orderReturns(orderID), nil
+}
+
+// orderReturns lists an order's returns by ID; callers hold ms.mu.
+func (ms *MemoryStorage) orderReturns(orderID uint) []ReturnRequest {
+	returns := []ReturnRequest{}
+	for _, r := range ms.returns {
+		if r.OrderID == orderID {
+			returns = append(returns, r)
+		}
+	}
+	slices.SortFunc(returns, func(a, b ReturnRequest) int { return cmp.Compare(a.ID, b.ID) })
+	return returns
+}
+
+func (ms *MemoryStorage) UpdateReturnStatus(ctx context.Context, id uint, c ReturnChange) (*ReturnRequest, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.updateReturnStatus(id, c)
+}
+
+// updateReturnStatus applies c to the return, restocking its items when it
+// is received; callers hold ms.mu.
+func (ms *MemoryStorage) updateReturnStatus(id uint, c ReturnChange) (*ReturnRequest, error) {
+	r, ok := ms.returns[id]
+	if !ok {
+		return nil, ErrReturnNotFound
+	}
+	if err := CheckReturnTransition(r.Status, c); err != nil {
+		return nil, err
+	}
+	if c.To == ReturnReceived {
+		for _, item := range ms.orders[r.OrderID].Items {
+			if item.ID == r.OrderItemID {
+				ms.restock(item.ProductID, item.VariantID, r.Quantity)
+			}
+		}
+	}
+	r.Status = c.To
+	r.ReviewedBy = c.ChangedBy
+	r.ReviewNote = c.Note
+	r.UpdatedAt = time.Now()
+	ms.returns[id] = r
+	return &r, nil
+}
+
+func (ms *MemoryStorage) CreateRefund(ctx context.Context, r *Refund) (*Refund, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	o, ok := ms.orders[r.OrderID]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	if r.ReturnID != nil {
+		ret, ok := ms.returns[*r.ReturnID]
+		if !ok || ret.OrderID != r.OrderID || ret.Status != ReturnReceived {
+			return nil, ErrReturnNotRefundable
+		}
+		for _, existing := range ms.refunds {
+			if existing.ReturnID != nil && *existing.ReturnID == ret.ID && existing.Status != RefundFailed {
+				return nil, ErrReturnNotRefundable
+			}
+		}
+	}
+	if ExceedsTotal(o.RefundedAmount, r.Amount, o.TotalPrice) {
+		return nil, ErrRefundExceedsTotal
+	}
+	o.RefundedAmount += r.Amount
+	ms.orders[o.ID] = o
+
+	ms.nextRefundID++
+	now := time.Now()
+	r.ID = ms.nextRefundID
+	r.CreatedAt = now
+	r.UpdatedAt = now
+	r.Status = RefundPending
+	ms.refunds[r.ID] = *r
+	return r, nil
+}
+
+func (ms *MemoryStorage) SubmitRefund(ctx context.Context, id uint, providerRefundID string) (*Refund, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	r, ok := ms.refunds[id]
+	if !ok {
+		return nil, ErrRefundNotFound
+	}
+	if r.Status != RefundPending {
+		return nil, ErrRefundNotPending
+	}
+	r.ProviderRefundID = providerRefundID
+	r.UpdatedAt = time.Now()
+	ms.refunds[id] = r
+	return &r, nil
+}
+
+func (ms *MemoryStorage) CompleteRefund(ctx context.Context, id uint, providerRefundID string) (*Refund, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	r, ok := ms.refunds[id]
+	if !ok {
+		return nil, ErrRefundNotFound
+	}
+	if r.Status != RefundPending {
+		return nil, ErrRefundNotPending
+	}
+	if r.ReturnID != nil {
+		change := ReturnChange{To: ReturnRefunded, ChangedBy: r.CreatedBy, Note: fmt.Sprintf("refund %d", r.ID)}
+		if _, err := ms.updateReturnStatus(*r.ReturnID, change); err != nil {
+			return nil, fmt.Errorf("error completing refund: %w", err)
+		}
+	}
+	r.Status = RefundSucceeded
+	r.ProviderRefundID = providerRefundID
+	r.UpdatedAt = time.Now()
+	ms.refunds[id] = r
+
+	var refunded float64
+	for _, other := range ms.refunds {
+		if other.OrderID == r.OrderID && other.Status == RefundSucceeded {
+			refunded += other.Amount
+		}
+	}
+	o := ms.orders[r.OrderID]
+	if FullyRefunded(refunded, o.TotalPrice) {
+		if r.PaymentID != nil {
+			if p, ok := ms.payments[*r.PaymentID]; ok && p.Status == PaymentCaptured {
+				p.Status = PaymentRefunded
+				p.UpdatedAt = time.Now()
+				ms.payments[p.ID] = p
+			}
+		}
+		if o.Status.CanTransitionTo(OrderRefunded) {
+			if _, err := ms.updateOrderStatus(o.ID, FullRefundStatusChange(&r)); err != nil {
+				return nil, fmt.Errorf("error completing refund: %w", err)
+			}
+		}
+	}
+	return &r, nil
+}
+
+func (ms *MemoryStorage) FailRefund(ctx context.Context, id uint, reason string) (*Refund, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	r, ok := ms.refunds[id]
+	if !ok {
+		return nil, ErrRefundNotFound
+	}
+	if r.Status != RefundPending {
+		return nil, ErrRefundNotPending
+	}
+	r.Status = RefundFailed
+	r.FailureReason = reason
+	r.UpdatedAt = time.Now()
+	ms.refunds[id] = r
+	if o, ok := ms.orders[r.OrderID]; ok {
+		o.RefundedAmount -= r.Amount
+		ms.orders[o.ID] = o
+	}
+	return &r, nil
+}
+
+func (ms *MemoryStorage) ListRefunds(ctx context.Context, orderID uint) ([]Refund, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if _, ok := ms.orders[orderID]; !ok {
+		return nil, ErrOrderNotFound
+	}
+	refunds := []Refund{}
+	for _, r := range ms.refunds {
+		if r.OrderID == orderID {
+			refunds = append(refunds, r)
+		}
+	}
+	slices.SortFunc(refunds, func(a, b Refund) int { return cmp.Compare(a.ID, b.ID) })
+	return refunds, nil
+}
+
+func (ms *MemoryStorage) CreateIdempotencyKey(ctx context.Context, k *IdempotencyKey) (*IdempotencyKey, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := k.Scope + "\x00" + k.Key
+	if _, ok := ms.idemKeys[key]; ok {
+		return nil, ErrIdempotencyKeyExists
+	}
+	ms.nextIdemKeyID++
+	k.ID = ms.nextIdemKeyID
+	k.CreatedAt = time.Now()
+	ms.idemKeys[key] = *k
+	return k, nil
+}
+
+func (ms *MemoryStorage) GetIdempotencyKey(ctx context.Context, scope, key string) (*IdempotencyKey, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	k, ok := ms.idemKeys[scope+"\x00"+key]
+	if !ok {
+		return nil, ErrIdempotencyKeyNotFound
+	}
+	k.Body = append([]byte(nil), k.Body...)
+	return &k, nil
+}
+
+func (ms *MemoryStorage) CompleteIdempotencyKey(ctx context.Context, id uint, statusCode int, contentType string, body []byte, expiresAt time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for key, k := range ms.idemKeys {
+		if k.ID == id {
+			k.StatusCode = statusCode
+			k.ContentType = contentType
+			k.Body = append([]byte(nil), body...)
+			k.ExpiresAt = expiresAt
+			ms.idemKeys[key] = k
+			return nil
+		}
+	}
+	return ErrIdempotencyKeyNotFound
+}
+
+func (ms *MemoryStorage) DeleteIdempotencyKey(ctx context.Context, id uint) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for key, k := range ms.idemKeys {
+		if k.ID == id {
+			delete(ms.idemKeys, key)
+		}
+	}
+	return nil
+}
+
+func (ms *MemoryStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var n int64
+	for key, k := range ms.idemKeys {
+		if k.ExpiresAt.Before(now) {
+			delete(ms.idemKeys, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (ms *MemoryStorage) CreateCart(ctx context.Context, c *Cart) (*Cart, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, existing := range ms.carts {
+		if (c.UserID != nil && existing.UserID != nil && *existing.UserID == *c.UserID) ||
+			(c.TokenHash != nil && existing.TokenHash != nil && *existing.TokenHash == *c.TokenHash) {
+			return nil, ErrCartAlreadyExists
+		}
+	}
+	ms.nextCartID++
+	c.ID = ms.nextCartID
+	now := time.Now()
+	c.CreatedAt, c.UpdatedAt = now, now
+	c.Items = []CartItem{}
+	ms.carts[c.ID] = copyCart(*c)
+	return c, nil
+}
+
+func (ms *MemoryStorage) GetUserCart(ctx context.Context, userID uint) (*Cart, error) {
+	return ms.findCart(func(c *Cart) bool { return c.UserID != nil && *c.UserID == userID })
+}
+
+func (ms *MemoryStorage) GetGuestCart(ctx context.Context, tokenHash string) (*Cart, error) {
+	return ms.findCart(func(c *Cart) bool { return c.TokenHash != nil && *c.TokenHash == tokenHash })
+}
+
+func (ms *MemoryStorage) findCart(match func(c *Cart) bool) (*Cart, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, c := range ms.carts {
+		if match(&c) {
+			c = copyCart(c)
+			return &c, nil
+		}
+	}
+	return nil, ErrCartNotFound
+}
+
+func (ms *MemoryStorage) AddCartItem(ctx context.Context, cartID, productID uint, variantID *uint, quantity int) (*Cart, error) {
+	return ms.updateCartItem(cartID, productID, variantID, quantity, func(current int) int { return current + quantity })
+}
+
+func (ms *MemoryStorage) SetCartItem(ctx context.Context, cartID, productID uint, variantID *uint, quantity int) (*Cart, error) {
+	return ms.updateCartItem(cartID, productID, variantID, quantity, func(int) int { return quantity })
+}
+
+func (ms *MemoryStorage) updateCartItem(cartID, productID uint, variantID *uint, quantity int, update func(current int) int) (*Cart, error) {
+	if quantity < 1 {
+		return nil, ErrInvalidQuantity
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	c, ok := ms.carts[cartID]
+	if !ok {
+		return nil, ErrCartNotFound
+	}
+	if _, ok := ms.products[productID]; !ok {
+		return nil, fmt.Errorf("error updating cart: %w", ErrProductNotFound)
+	}
+	if variantID != nil {
+		if v, ok := ms.variants[*variantID]; !ok || v.ProductID != productID {
+			return nil, fmt.Errorf("error updating cart: %w", ErrVariantNotFound)
+		}
+	}
+	now := time.Now()
+	ms.putCartItem(&c, productID, variantID, update, now)
+	c.UpdatedAt = now
+	ms.carts[cartID] = c
+	c = copyCart(c)
+	return &c, nil
+}
+
+// putCartItem applies update to the line of the product and variant in c,
+// adding the line when c has none; callers hold ms.mu.
+func (ms *MemoryStorage) putCartItem(c *Cart, productID uint, variantID *uint, update func(current int) int, now time.Time) {
+	for i := range c.Items {
+		if c.Items[i].IsLine(productID, variantID) {
+			c.Items[i].Quantity = update(c.Items[i].Quantity)
+			c.Items[i].UpdatedAt = now
+			return
+		}
+	}
+	if variantID != nil {
+		id := *variantID
+		variantID = &id
+	}
+	ms.nextCartItemID++
+	c.Items = append(c.Items, CartItem{
+		ID:        ms.nextCartItemID,
+		CreatedAt: now,
+		UpdatedAt: now,
+		CartID:    c.ID,
+		ProductID: productID,
+		VariantID: variantID,
+		Quantity:  update(0),
+	})
+}
+
+func (ms *MemoryStorage) RemoveCartItem(ctx context.Context, cartID, productID uint, variantID *uint) (*Cart, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	c, ok := ms.carts[cartID]
+	if !ok {
+		return nil, ErrCartNotFound
+	}
+	i := slices.IndexFunc(c.Items, func(item CartItem) bool { return item.IsLine(productID, variantID) })
+	if i < 0 {
+		return nil, ErrCartItemNotFound
+	}
+	c.Items = slices.Delete(copyCart(c).Items, i, i+1)
+	c.UpdatedAt = time.Now()
+	ms.carts[cartID] = c
+	c = copyCart(c)
+	return &c, nil
+}
+
+func (ms *MemoryStorage) MergeCarts(ctx context.Context, fromID, intoID uint) (*Cart, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	from, ok := ms.carts[fromID]
+	if !ok {
+		return nil, ErrCartNotFound
+	}
+	if fromID == intoID {
+		from = copyCart(from)
+		return &from, nil
+	}
+	into, ok := ms.carts[intoID]
+	if !ok {
+		return nil, ErrCartNotFound
+	}
+	into = copyCart(into)
+	now := time.Now()
+	for _, item := range from.Items {
+		ms.putCartItem(&into, item.ProductID, item.VariantID, func(current int) int { return current + item.Quantity }, now)
+	}
+	into.UpdatedAt = now
+	ms.carts[intoID] = into
+	delete(ms.carts, fromID)
+	into = copyCart(into)
+	return &into, nil
+}
+
+func (ms *MemoryStorage) CreateUser(ctx context.Context, u *User) (*User, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, existing := range ms.users {
+		if existing.Email == u.Email {
+			return nil, ErrUserAlreadyExists
+		}
+	}
+	ms.nextUserID++
+	u.ID = ms.nextUserID
+	now := time.Now()
+	u.CreatedAt, u.UpdatedAt = now, now
+	ms.users[u.ID] = *u
+	return u, nil
+}
+
+func (ms *MemoryStorage) GetUser(ctx context.Context, email string) (*User, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, u := range ms.users {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (ms *MemoryStorage) ListUsers(ctx context.Context, q UserQuery) (*Page[User], error) {
+	lq, err := q.Compile()
+	if err != nil {
+		return nil, err
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	users := make([]User, 0, len(ms.users))
+	for _, u := range ms.users {
+		if q.UserFilter.match(&u) {
+			users = append(users, u)
+		}
+	}
+	return memoryPage(lq, users, userSortValue), nil
+}
+
+func (ms *MemoryStorage) UpdateUser(ctx context.Context, u *User) (*User, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.users[u.ID]; !ok {
+		return nil, fmt.Errorf("error updating user: %w", ErrUserNotFound)
+	}
+	for _, existing := range ms.users {
+		if existing.ID != u.ID && existing.Email == u.Email {
+			return nil, ErrUserAlreadyExists
+		}
+	}
+	u.UpdatedAt = time.Now()
+	ms.users[u.ID] = *u
+	return u, nil
+}
+
+func (ms *MemoryStorage) DeleteUser(ctx context.Context, id uint) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.users[id]; !ok {
+		return ErrUserNotFound
+	}
+	delete(ms.users, id)
+	for _, a := range ms.userAddresses(id) {
+		delete(ms.addrs, a.ID)
+	}
+	return nil
+}
+
+func (ms *MemoryStorage) CreateAddress(ctx context.Context, a *Address) (*Address, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.users[a.UserID]; !ok {
+		return nil, ErrUserNotFound
+	}
+	existing := ms.userAddresses(a.UserID)
+	a.IsDefault = a.IsDefault || len(existing) == 0
+	if a.IsDefault {
+		ms.clearDefaultAddress(existing)
+	}
+	ms.nextAddressID++
+	now := time.Now()
+	a.ID = ms.nextAddressID
+	a.CreatedAt = now
+	a.UpdatedAt = now
+	ms.addrs[a.ID] = *a
+	return a, nil
+}
+
+func (ms *MemoryStorage) GetAddress(ctx context.Context, id uint) (*Address, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	a, ok := ms.addrs[id]
+	if !ok {
+		return nil, ErrAddressNotFound
+	}
+	return &a, nil
+}
+
+func (ms *MemoryStorage) ListAddresses(ctx context.Context, userID uint) ([]Address, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	addresses := ms.userAddresses(userID)
+	// the default first, the rest in ID order
+	slices.SortStableFunc(addresses, func(a, b Address) int {
+		switch {
+		case a.IsDefault == b.IsDefault:
+			return 0
+		case a.IsDefault:
+			return -1
+		}
+		return 1
+	})
+	return addresses, nil
+}
+
+func (ms *MemoryStorage) UpdateAddress(ctx context.Context, a *Address) (*Address, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	current, ok := ms.addrs[a.ID]
+	if !ok {
+		return nil, ErrAddressNotFound
+	}
+	a.UserID = current.UserID
+	a.CreatedAt = current.CreatedAt
+	a.UpdatedAt = time.Now()
+	a.IsDefault = a.IsDefault || current.IsDefault
+	if a.IsDefault && !current.IsDefault {
+		ms.clearDefaultAddress(ms.userAddresses(a.UserID))
+	}
+	ms.addrs[a.ID] = *a
+	return a, nil
+}
+
+func (ms *MemoryStorage) DeleteAddress(ctx context.Context, id uint) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	a, ok := ms.addrs[id]
+	if !ok {
+		return ErrAddressNotFound
+	}
+	delete(ms.addrs, id)
+	if rest := ms.userAddresses(a.UserID); a.IsDefault && len(rest) > 0 {
+		next := rest[0]
+		next.IsDefault = true
+		ms.addrs[next.ID] = next
+	}
+	return nil
+}
+
+func (ms *MemoryStorage) CreatePromotion(ctx context.Context, p *Promotion) (*Promotion, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.codeTaken(p) {
+		return nil, ErrPromotionCodeTaken
+	}
+	ms.nextPromotionID++
+	now := time.Now()
+	p.ID = ms.nextPromotionID
+	p.CreatedAt, p.UpdatedAt = now, now
+	ms.promos[p.ID] = copyPromotion(*p)
+	return p, nil
+}
+
+func (ms *MemoryStorage) GetPromotion(ctx context.Context, id uint) (*Promotion, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	p, ok := ms.promos[id]
+	if !ok {
+		return nil, ErrPromotionNotFound
+	}
+	p = copyPromotion(p)
+	return &p, nil
+}
+
+func (ms *MemoryStorage) GetPromotionByCode(ctx context.Context, code string) (*Promotion, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, p := range ms.promos {
+		if p.Code != nil && *p.Code == code {
+			p = copyPromotion(p)
+			return &p, nil
+		}
+	}
+	return nil, ErrPromotionNotFound
+}
+
+func (ms *MemoryStorage) ListPromotions(ctx context.Context) ([]Promotion, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	promotions := make([]Promotion, 0, len(ms.promos))
+	for _, p := range ms.promos {
+		promotions = append(promotions, copyPromotion(p))
+	}
+	slices.SortFunc(promotions, func(a, b Promotion) int { return cmp.Compare(a.ID, b.ID) })
+	return promotions, nil
+}
+
+func (ms *MemoryStorage) UpdatePromotion(ctx context.Context, p *Promotion) (*Promotion, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	current, ok := ms.promos[p.ID]
+	if !ok {
+		return nil, ErrPromotionNotFound
+	}
+	if ms.codeTaken(p) {
+		return nil, ErrPromotionCodeTaken
+	}
+	p.CreatedAt = current.CreatedAt
+	p.UpdatedAt = time.Now()
+	p.UsedCount = current.UsedCount
+	ms.promos[p.ID] = copyPromotion(*p)
+	return p, nil
+}
+
+func (ms *MemoryStorage) DeletePromotion(ctx context.Context, id uint) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.promos[id]; !ok {
+		return ErrPromotionNotFound
+	}
+	delete(ms.promos, id)
+	ms.redeemed = slices.DeleteFunc(ms.redeemed, func(r PromotionRedemption) bool { return r.PromotionID == id })
+	return nil
+}
+
+func (ms *MemoryStorage) CountRedemptions(ctx context.Context, promotionID uint, r Redeemer) (int, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return ms.countRedemptions(promotionID, r), nil
+}
+
+// countRedemptions counts r's redemptions of a promotion; callers hold ms.mu.
+func (ms *MemoryStorage) countRedemptions(promotionID uint, r Redeemer) int {
+	n := 0
+	for _, rd := range ms.redeemed {
+		if rd.PromotionID == promotionID && r.matches(rd) {
+			n++
+		}
+	}
+	return n
+}
+
+// codeTaken reports whether another promotion has p's code; callers hold
+// ms.mu.
+func (ms *MemoryStorage) codeTaken(p *Promotion) bool {
+	if p.Code == nil {
+		return false
+	}
+	for _, other := range ms.promos {
+		if other.ID != p.ID && other.Code != nil && *other.Code == *p.Code {
+			return true
+		}
+	}
+	return false
+}
+
+// userAddresses lists a user's addresses by ID; callers hold ms.mu.
+func (ms *MemoryStorage) userAddresses(userID uint) []Address {
+	addresses := []Address{}
+	for _, a := range ms.addrs {
+		if a.UserID == userID {
+			addresses = append(addresses, a)
+		}
+	}
+	slices.SortFunc(addresses, func(a, b Address) int { return cmp.Compare(a.ID, b.ID) })
+	return addresses
+}
+
+// clearDefaultAddress unsets the default among addresses; callers hold ms.mu.
+func (ms *MemoryStorage) clearDefaultAddress(addresses []Address) {
+	for _, a := range addresses {
+		if a.IsDefault {
+			a.IsDefault = false
+			ms.addrs[a.ID] = a
+		}
+	}
+}
+
+func (ms *MemoryStorage) CreateSession(ctx context.Context, s *Session) (*Session, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.sessions[s.ID]; ok {
+		return nil, fmt.Errorf("error inserting session: id %q already exists", s.ID)
+	}
+	if s.CreatedAt.IsZero() {
+		s.CreatedAt = time.Now()
+	}
+	ms.sessions[s.ID] = *s
+	return s, nil
+}
+
+func (ms *MemoryStorage) GetSession(ctx context.Context, id string) (*Session, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	s, ok := ms.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return &s, nil
+}
+
+func (ms *MemoryStorage) RevokeSession(ctx context.Context, id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	s, ok := ms.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	s.IsRevoked = true
+	ms.sessions[id] = s
+	return nil
+}
+
+func (ms *MemoryStorage) DeleteSession(ctx context.Context, id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.sessions[id]; !ok {
+		return ErrSessionNotFound
+	}
+	delete(ms.sessions, id)
+	return nil
+}
+
+// copyOrder detaches the Items slice so callers can't mutate stored state.
+func copyOrder(o Order) Order {
+	if o.Items != nil {
+		items := make([]OrderItem, len(o.Items))
+		copy(items, o.Items)
+		o.Items = items
+	}
+	if o.TaxBreakdown.Lines != nil {
+		o.TaxBreakdown.Lines = slices.Clone(o.TaxBreakdown.Lines)
+	}
+	if o.Discounts != nil {
+		o.Discounts = slices.Clone(o.Discounts)
+	}
+	return o
+}
+
+// copyPromotion detaches the scope slices so callers can't mutate stored
+// state.
+func copyPromotion(p Promotion) Promotion {
+	p.Scope.ProductIDs = slices.Clone(p.Scope.ProductIDs)
+	p.Scope.Categories = slices.Clone(p.Scope.Categories)
+	return p
+}
+
+// copyVariant detaches the options and price so callers can't mutate
+// stored state.
+func copyVariant(v ProductVariant) ProductVariant {
+	v.Options = maps.Clone(v.Options)
+	if v.Price != nil {
+		price := *v.Price
+		v.Price = &price
+	}
+	return v
+}
+
+// copyProduct detaches the options so callers can't mutate stored state.
+func copyProduct(p Product) Product {
+	if p.Options != nil {
+		options := make(ProductOptions, len(p.Options))
+		for i, o := range p.Options {
+			options[i] = ProductOption{Name: o.Name, Values: slices.Clone(o.Values)}
+		}
+		p.Options = options
+	}
+	return p
+}
+
+// copyCart detaches the Items slice so callers can't mutate stored state.
+func copyCart(c Cart) Cart {
+	items := make([]CartItem, len(c.Items))
+	copy(items, c.Items)
+	c.Items = items
+	return c
+}
@@ -0,0 +1,13 @@
+package storer_test
+
+import (
+	"ecom_apiv1/internal/storer"
+	"ecom_apiv1/internal/storer/storertest"
+	"testing"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storertest.Run(t, func(t *testing.T) storer.Store {
+		return storer.NewMemoryStorage()
+	})
+}
@@ -0,0 +1,100 @@
+package storertest
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"testing"
+)
+
+func testAddresses(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+	newAddress := func(userID uint, city string, isDefault bool) *storer.Address {
+		return &storer.Address{UserID: userID, Name: "Buyer", Line1: "1 Main St", City: city, PostalCode: "12345", Country: "US", IsDefault: isDefault}
+	}
+	defaultCity := func(t *testing.T, s storer.Store, userID uint) string {
+		t.Helper()
+		addresses, err := s.ListAddresses(ctx, userID)
+		if err != nil {
+			t.Fatalf("ListAddresses: %v", err)
+		}
+		defaults := 0
+		for _, a := range addresses {
+			if a.IsDefault {
+				defaults++
+			}
+		}
+		if len(addresses) == 0 {
+			return ""
+		}
+		if defaults != 1 || !addresses[0].IsDefault {
+			t.Fatalf("want exactly one default listed first, got %+v", addresses)
+		}
+		return addresses[0].City
+	}
+
+	t.Run("Default address", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		other := mustCreateUser(t, s, "other@example.com", false)
+
+		first, err := s.CreateAddress(ctx, newAddress(u.ID, "Springfield", false))
+		if err != nil || first.ID == 0 || !first.IsDefault {
+			t.Fatalf("first address = %+v, %v, want it made the default", first, err)
+		}
+		second, _ := s.CreateAddress(ctx, newAddress(u.ID, "Shelbyville", false))
+		if second.IsDefault || defaultCity(t, s, u.ID) != "Springfield" {
+			t.Errorf("a second address must not take the default")
+		}
+		s.CreateAddress(ctx, newAddress(other.ID, "Capital City", false))
+		if addresses, _ := s.ListAddresses(ctx, u.ID); len(addresses) != 2 {
+			t.Errorf("listed %d addresses, want the user's 2", len(addresses))
+		}
+
+		third, _ := s.CreateAddress(ctx, newAddress(u.ID, "Ogdenville", true))
+		if !third.IsDefault || defaultCity(t, s, u.ID) != "Ogdenville" {
+			t.Errorf("an address created as default must take the default")
+		}
+
+		second.IsDefault = true
+		second.City = "North Haverbrook"
+		if _, err := s.UpdateAddress(ctx, second); err != nil {
+			t.Fatalf("UpdateAddress: %v", err)
+		}
+		if got := defaultCity(t, s, u.ID); got != "North Haverbrook" {
+			t.Errorf("default is in %s after moving it", got)
+		}
+		// the default is moved, never cleared
+		second.IsDefault = false
+		if updated, err := s.UpdateAddress(ctx, second); err != nil || !updated.IsDefault {
+			t.Errorf("clearing the default = %+v, %v", updated, err)
+		}
+		if _, err := s.UpdateAddress(ctx, &storer.Address{ID: 9999, Name: "x"}); !errors.Is(err, storer.ErrAddressNotFound) {
+			t.Errorf("expected ErrAddressNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		first, _ := s.CreateAddress(ctx, newAddress(u.ID, "Springfield", false))
+		s.CreateAddress(ctx, newAddress(u.ID, "Shelbyville", false))
+		s.CreateAddress(ctx, newAddress(u.ID, "Ogdenville", false))
+
+		if err := s.DeleteAddress(ctx, first.ID); err != nil {
+			t.Fatalf("DeleteAddress: %v", err)
+		}
+		if got := defaultCity(t, s, u.ID); got != "Shelbyville" {
+			t.Errorf("default moved to %s, want the oldest remaining address", got)
+		}
+		if _, err := s.GetAddress(ctx, first.ID); !errors.Is(err, storer.ErrAddressNotFound) {
+			t.Errorf("expected ErrAddressNotFound, got %v", err)
+		}
+		if err := s.DeleteAddress(ctx, first.ID); !errors.Is(err, storer.ErrAddressNotFound) {
+			t.Errorf("expected ErrAddressNotFound, got %v", err)
+		}
+		if _, err := s.CreateAddress(ctx, newAddress(9999, "Nowhere", false)); !errors.Is(err, storer.ErrUserNotFound) {
+			t.Errorf("address for a missing user: expected ErrUserNotFound, got %v", err)
+		}
+	})
+}
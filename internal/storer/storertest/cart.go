@@ -0,0 +1,257 @@
+package storertest
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"testing"
+)
+
+func testCarts(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	mustCreateCart := func(t *testing.T, s storer.Store, c *storer.Cart) *storer.Cart {
+		t.Helper()
+		created, err := s.CreateCart(ctx, c)
+		if err != nil {
+			t.Fatalf("CreateCart: %v", err)
+		}
+		return created
+	}
+	quantities := func(c *storer.Cart) map[uint]int {
+		q := make(map[uint]int, len(c.Items))
+		for _, item := range c.Items {
+			q[item.ProductID] = item.Quantity
+		}
+		return q
+	}
+
+	t.Run("Create and get by owner", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		hash := "guest-hash"
+		userCart := mustCreateCart(t, s, &storer.Cart{UserID: &u.ID})
+		guestCart := mustCreateCart(t, s, &storer.Cart{TokenHash: &hash})
+
+		got, err := s.GetUserCart(ctx, u.ID)
+		if err != nil || got.ID != userCart.ID || len(got.Items) != 0 {
+			t.Fatalf("GetUserCart = %+v, %v", got, err)
+		}
+		got, err = s.GetGuestCart(ctx, hash)
+		if err != nil || got.ID != guestCart.ID {
+			t.Fatalf("GetGuestCart = %+v, %v", got, err)
+		}
+		if _, err := s.GetGuestCart(ctx, "other"); !errors.Is(err, storer.ErrCartNotFound) {
+			t.Errorf("expected ErrCartNotFound, got %v", err)
+		}
+		if _, err := s.CreateCart(ctx, &storer.Cart{UserID: &u.ID}); !errors.Is(err, storer.ErrCartAlreadyExists) {
+			t.Errorf("expected ErrCartAlreadyExists, got %v", err)
+		}
+	})
+
+	t.Run("Add, set and remove items", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		a := mustCreateProduct(t, s, "Keyboard", 50)
+		b := mustCreateProduct(t, s, "Mouse", 20)
+		c := mustCreateCart(t, s, &storer.Cart{UserID: &u.ID})
+
+		if _, err := s.AddCartItem(ctx, c.ID, a.ID, nil, 2); err != nil {
+			t.Fatalf("AddCartItem: %v", err)
+		}
+		if _, err := s.AddCartItem(ctx, c.ID, b.ID, nil, 1); err != nil {
+			t.Fatalf("AddCartItem: %v", err)
+		}
+		got, err := s.AddCartItem(ctx, c.ID, a.ID, nil, 3)
+		if err != nil {
+			t.Fatalf("AddCartItem: %v", err)
+		}
+		if q := quantities(got); len(got.Items) != 2 || q[a.ID] != 5 || q[b.ID] != 1 {
+			t.Fatalf("unexpected items after add: %+v", got.Items)
+		}
+
+		got, err = s.SetCartItem(ctx, c.ID, a.ID, nil, 1)
+		if err != nil {
+			t.Fatalf("SetCartItem: %v", err)
+		}
+		if q := quantities(got); q[a.ID] != 1 {
+			t.Errorf("quantity after set = %d, want 1", q[a.ID])
+		}
+
+		got, err = s.RemoveCartItem(ctx, c.ID, b.ID, nil)
+		if err != nil {
+			t.Fatalf("RemoveCartItem: %v", err)
+		}
+		if len(got.Items) != 1 || got.Items[0].ProductID != a.ID {
+			t.Errorf("unexpected items after remove: %+v", got.Items)
+		}
+		if _, err := s.RemoveCartItem(ctx, c.ID, b.ID, nil); !errors.Is(err, storer.ErrCartItemNotFound) {
+			t.Errorf("expected ErrCartItemNotFound, got %v", err)
+		}
+
+		got, err = s.GetUserCart(ctx, u.ID)
+		if err != nil || len(got.Items) != 1 || got.Items[0].Quantity != 1 {
+			t.Errorf("GetUserCart = %+v, %v", got, err)
+		}
+	})
+
+	t.Run("Invalid items", func(t *testing.T) {
+		s := newStore(t)
+		p := mustCreateProduct(t, s, "Keyboard", 50)
+		hash := "guest-hash"
+		c := mustCreateCart(t, s, &storer.Cart{TokenHash: &hash})
+
+		if _, err := s.AddCartItem(ctx, c.ID, 999, nil, 1); !errors.Is(err, storer.ErrProductNotFound) {
+			t.Errorf("expected ErrProductNotFound, got %v", err)
+		}
+		if _, err := s.AddCartItem(ctx, 999, p.ID, nil, 1); !errors.Is(err, storer.ErrCartNotFound) {
+			t.Errorf("expected ErrCartNotFound, got %v", err)
+		}
+		if _, err := s.SetCartItem(ctx, c.ID, p.ID, nil, 0); !errors.Is(err, storer.ErrInvalidQuantity) {
+			t.Errorf("expected ErrInvalidQuantity, got %v", err)
+		}
+	})
+
+	t.Run("Merge", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		a := mustCreateProduct(t, s, "Keyboard", 50)
+		b := mustCreateProduct(t, s, "Mouse", 20)
+		hash := "guest-hash"
+		guest := mustCreateCart(t, s, &storer.Cart{TokenHash: &hash})
+		user := mustCreateCart(t, s, &storer.Cart{UserID: &u.ID})
+		for _, add := range []struct {
+			cart, product uint
+			quantity      int
+		}{{guest.ID, a.ID, 2}, {guest.ID, b.ID, 1}, {user.ID, a.ID, 1}} {
+			if _, err := s.AddCartItem(ctx, add.cart, add.product, nil, add.quantity); err != nil {
+				t.Fatalf("AddCartItem: %v", err)
+			}
+		}
+
+		got, err := s.MergeCarts(ctx, guest.ID, user.ID)
+		if err != nil {
+			t.Fatalf("MergeCarts: %v", err)
+		}
+		if q := quantities(got); got.ID != user.ID || len(got.Items) != 2 || q[a.ID] != 3 || q[b.ID] != 1 {
+			t.Errorf("unexpected merged cart: %+v", got)
+		}
+		if _, err := s.GetGuestCart(ctx, hash); !errors.Is(err, storer.ErrCartNotFound) {
+			t.Errorf("guest cart should be gone, got %v", err)
+		}
+	})
+
+	t.Run("Variant lines", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		shirt := mustCreateProduct(t, s, "Shirt", 20)
+		other := mustCreateProduct(t, s, "Hat", 10)
+		small, err := s.CreateVariant(ctx, &storer.ProductVariant{ProductID: shirt.ID, SKU: "SHIRT-S", CountInStock: 5, IsActive: true})
+		if err != nil {
+			t.Fatalf("CreateVariant: %v", err)
+		}
+		large, err := s.CreateVariant(ctx, &storer.ProductVariant{ProductID: shirt.ID, SKU: "SHIRT-L", CountInStock: 5, IsActive: true})
+		if err != nil {
+			t.Fatalf("CreateVariant: %v", err)
+		}
+		lineOf := func(c *storer.Cart, variantID *uint) *storer.CartItem {
+			for i := range c.Items {
+				if c.Items[i].IsLine(shirt.ID, variantID) {
+					return &c.Items[i]
+				}
+			}
+			return nil
+		}
+		hash := "guest-hash"
+		guest := mustCreateCart(t, s, &storer.Cart{TokenHash: &hash})
+		user := mustCreateCart(t, s, &storer.Cart{UserID: &u.ID})
+
+		for _, variantID := range []*uint{&small.ID, &large.ID, &small.ID} {
+			if _, err := s.AddCartItem(ctx, user.ID, shirt.ID, variantID, 1); err != nil {
+				t.Fatalf("AddCartItem: %v", err)
+			}
+		}
+		got, err := s.AddCartItem(ctx, user.ID, shirt.ID, nil, 1)
+		if err != nil {
+			t.Fatalf("AddCartItem without a variant: %v", err)
+		}
+		if len(got.Items) != 3 || lineOf(got, &small.ID).Quantity != 2 || lineOf(got, &large.ID).Quantity != 1 || lineOf(got, nil).Quantity != 1 {
+			t.Fatalf("expected a line per variant, got %+v", got.Items)
+		}
+		if _, err := s.AddCartItem(ctx, user.ID, other.ID, &small.ID, 1); !errors.Is(err, storer.ErrVariantNotFound) {
+			t.Errorf("variant of another product: expected ErrVariantNotFound, got %v", err)
+		}
+
+		if got, err = s.SetCartItem(ctx, user.ID, shirt.ID, &large.ID, 4); err != nil || lineOf(got, &large.ID).Quantity != 4 || lineOf(got, &small.ID).Quantity != 2 {
+			t.Fatalf("SetCartItem = %+v, %v", got, err)
+		}
+		if got, err = s.RemoveCartItem(ctx, user.ID, shirt.ID, nil); err != nil || len(got.Items) != 2 || lineOf(got, nil) != nil {
+			t.Fatalf("RemoveCartItem without a variant = %+v, %v", got, err)
+		}
+		if _, err := s.RemoveCartItem(ctx, user.ID, shirt.ID, nil); !errors.Is(err, storer.ErrCartItemNotFound) {
+			t.Errorf("expected ErrCartItemNotFound, got %v", err)
+		}
+
+		if _, err := s.AddCartItem(ctx, guest.ID, shirt.ID, &small.ID, 3); err != nil {
+			t.Fatalf("AddCartItem: %v", err)
+		}
+		if got, err = s.MergeCarts(ctx, guest.ID, user.ID); err != nil || len(got.Items) != 2 || lineOf(got, &small.ID).Quantity != 5 || lineOf(got, &large.ID).Quantity != 4 {
+			t.Fatalf("MergeCarts = %+v, %v", got, err)
+		}
+
+		if err := s.DeleteVariant(ctx, large.ID); err != nil {
+			t.Fatalf("DeleteVariant: %v", err)
+		}
+		if got, err = s.GetUserCart(ctx, u.ID); err != nil || len(got.Items) != 1 || lineOf(got, &small.ID) == nil {
+			t.Errorf("cart after deleting a variant = %+v, %v", got, err)
+		}
+	})
+
+	t.Run("Checkout empties the cart", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		p := mustCreateProduct(t, s, "Keyboard", 50)
+		c := mustCreateCart(t, s, &storer.Cart{UserID: &u.ID})
+		if _, err := s.AddCartItem(ctx, c.ID, p.ID, nil, 2); err != nil {
+			t.Fatalf("AddCartItem: %v", err)
+		}
+
+		_, err := s.CreateOrder(ctx, &storer.Order{
+			UserID:        &u.ID,
+			PaymentMethod: "PayPal",
+			Items:         []storer.OrderItem{{ProductID: p.ID, Name: p.Name, Image: p.Image, Price: p.Price, Quantity: 2}},
+			CartID:        &c.ID,
+		})
+		if err != nil {
+			t.Fatalf("CreateOrder: %v", err)
+		}
+		got, err := s.GetUserCart(ctx, u.ID)
+		if err != nil || len(got.Items) != 0 {
+			t.Errorf("cart after checkout = %+v, %v", got, err)
+		}
+	})
+
+	t.Run("Failed checkout keeps the cart", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		p := mustCreateProduct(t, s, "Keyboard", 50)
+		c := mustCreateCart(t, s, &storer.Cart{UserID: &u.ID})
+		if _, err := s.AddCartItem(ctx, c.ID, p.ID, nil, 11); err != nil {
+			t.Fatalf("AddCartItem: %v", err)
+		}
+
+		_, err := s.CreateOrder(ctx, &storer.Order{
+			UserID:        &u.ID,
+			PaymentMethod: "PayPal",
+			Items:         []storer.OrderItem{{ProductID: p.ID, Name: p.Name, Image: p.Image, Price: p.Price, Quantity: 11}},
+			CartID:        &c.ID,
+		})
+		if !errors.Is(err, storer.ErrInsufficientStock) {
+			t.Fatalf("expected ErrInsufficientStock, got %v", err)
+		}
+		got, err := s.GetUserCart(ctx, u.ID)
+		if err != nil || len(got.Items) != 1 {
+			t.Errorf("cart after failed checkout = %+v, %v", got, err)
+		}
+	})
+}
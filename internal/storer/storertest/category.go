@@ -0,0 +1,136 @@
+package storertest
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func testCategories(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	newCategory := func(t *testing.T, s storer.Store, name string, parentID *uint, position int) *storer.Category {
+		t.Helper()
+		c, err := s.CreateCategory(ctx, &storer.Category{ParentID: parentID, Name: name, Slug: storer.Slugify(name), Position: position})
+		if err != nil {
+			t.Fatalf("CreateCategory(%s): %v", name, err)
+		}
+		return c
+	}
+	fileUnder := func(t *testing.T, s storer.Store, name string, c *storer.Category) *storer.Product {
+		t.Helper()
+		p := mustCreateProduct(t, s, name, 10)
+		p.CategoryID, p.Category = &c.ID, c.Name
+		if _, err := s.UpdateProduct(ctx, p); err != nil {
+			t.Fatalf("UpdateProduct: %v", err)
+		}
+		return p
+	}
+
+	t.Run("Create, update and list", func(t *testing.T) {
+		s := newStore(t)
+		electronics := newCategory(t, s, "Electronics", nil, 1)
+		books := newCategory(t, s, "Books", nil, 0)
+		phones := newCategory(t, s, "Phones", &electronics.ID, 0)
+		if _, err := s.CreateCategory(ctx, &storer.Category{Name: "electronics", Slug: "electronics"}); !errors.Is(err, storer.ErrCategorySlugTaken) {
+			t.Errorf("duplicate slug: expected ErrCategorySlugTaken, got %v", err)
+		}
+		missing := uint(9999)
+		if _, err := s.CreateCategory(ctx, &storer.Category{ParentID: &missing, Name: "Orphan", Slug: "orphan"}); !errors.Is(err, storer.ErrCategoryNotFound) {
+			t.Errorf("missing parent: expected ErrCategoryNotFound, got %v", err)
+		}
+
+		if got, err := s.GetCategoryBySlug(ctx, "phones"); err != nil || got.ID != phones.ID || *got.ParentID != electronics.ID {
+			t.Errorf("GetCategoryBySlug = %+v, %v", got, err)
+		}
+		if _, err := s.GetCategory(ctx, 9999); !errors.Is(err, storer.ErrCategoryNotFound) {
+			t.Errorf("expected ErrCategoryNotFound, got %v", err)
+		}
+		list, err := s.ListCategories(ctx)
+		if err != nil || len(list) != 3 {
+			t.Fatalf("ListCategories = %+v, %v", list, err)
+		}
+		tree := storer.CategoryTree(list)
+		if len(tree) != 2 || tree[0].ID != books.ID || tree[1].ID != electronics.ID || len(tree[1].Children) != 1 {
+			t.Errorf("tree = %+v, want Books then Electronics > Phones", tree)
+		}
+
+		p := fileUnder(t, s, "Phone", phones)
+		phones.Name, phones.Slug = "Mobile Phones", "mobile-phones"
+		updated, err := s.UpdateCategory(ctx, phones)
+		if err != nil || updated.Slug != "mobile-phones" || updated.CreatedAt.IsZero() {
+			t.Fatalf("UpdateCategory = %+v, %v", updated, err)
+		}
+		if got, _ := s.GetProduct(ctx, p.ID); got.Category != "Mobile Phones" {
+			t.Errorf("product category = %q, want it renamed along", got.Category)
+		}
+		phones.Slug = "books"
+		if _, err := s.UpdateCategory(ctx, phones); !errors.Is(err, storer.ErrCategorySlugTaken) {
+			t.Errorf("expected ErrCategorySlugTaken, got %v", err)
+		}
+		if _, err := s.UpdateCategory(ctx, &storer.Category{ID: 9999, Name: "Nope", Slug: "nope"}); !errors.Is(err, storer.ErrCategoryNotFound) {
+			t.Errorf("expected ErrCategoryNotFound, got %v", err)
+		}
+	})
+
+	t.Run("List products by category", func(t *testing.T) {
+		s := newStore(t)
+		electronics := newCategory(t, s, "Electronics", nil, 0)
+		phones := newCategory(t, s, "Phones", &electronics.ID, 0)
+		books := newCategory(t, s, "Books", nil, 0)
+		tv := fileUnder(t, s, "TV", electronics)
+		phone := fileUnder(t, s, "Phone", phones)
+		fileUnder(t, s, "Novel", books)
+
+		list, err := s.ListCategories(ctx)
+		if err != nil {
+			t.Fatalf("ListCategories: %v", err)
+		}
+		ids := storer.CategorySubtree(list, electronics.ID)
+		page, err := s.ListProducts(ctx, storer.ProductQuery{ListParams: storer.ListParams{Limit: 10}, ProductFilter: storer.ProductFilter{CategoryIDs: ids}})
+		if err != nil {
+			t.Fatalf("ListProducts: %v", err)
+		}
+		var got []uint
+		for _, p := range page.Items {
+			got = append(got, p.ID)
+		}
+		slices.Sort(got)
+		if page.Total != 2 || !slices.Equal(got, []uint{tv.ID, phone.ID}) {
+			t.Errorf("products under Electronics = %v (total %d), want %v", got, page.Total, []uint{tv.ID, phone.ID})
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		s := newStore(t)
+		electronic := newCategory(t, s, "Electronic", nil, 0)
+		electronics := newCategory(t, s, "Electronics", nil, 0)
+		cables := newCategory(t, s, "Cables", &electronic.ID, 0)
+		p := fileUnder(t, s, "Charger", electronic)
+
+		if err := s.DeleteCategory(ctx, electronic.ID, nil); !errors.Is(err, storer.ErrCategoryInUse) {
+			t.Errorf("category with subcategories: expected ErrCategoryInUse, got %v", err)
+		}
+		if err := s.DeleteCategory(ctx, cables.ID, nil); err != nil {
+			t.Fatalf("DeleteCategory: %v", err)
+		}
+		if err := s.DeleteCategory(ctx, electronic.ID, nil); !errors.Is(err, storer.ErrCategoryInUse) {
+			t.Errorf("category with products: expected ErrCategoryInUse, got %v", err)
+		}
+		if err := s.DeleteCategory(ctx, electronic.ID, &electronics.ID); err != nil {
+			t.Fatalf("DeleteCategory moving products: %v", err)
+		}
+		got, err := s.GetProduct(ctx, p.ID)
+		if err != nil || got.CategoryID == nil || *got.CategoryID != electronics.ID || got.Category != "Electronics" {
+			t.Errorf("moved product = %+v, %v", got, err)
+		}
+		if _, err := s.GetCategory(ctx, electronic.ID); !errors.Is(err, storer.ErrCategoryNotFound) {
+			t.Errorf("expected ErrCategoryNotFound, got %v", err)
+		}
+		if err := s.DeleteCategory(ctx, electronic.ID, nil); !errors.Is(err, storer.ErrCategoryNotFound) {
+			t.Errorf("expected ErrCategoryNotFound, got %v", err)
+		}
+	})
+}
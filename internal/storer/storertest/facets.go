@@ -0,0 +1,101 @@
+package storertest
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func testFacets(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+	s := newStore(t)
+	reviewer := mustCreateUser(t, s, "reviewer@example.com", false)
+
+	for i, p := range []struct {
+		category string
+		price    float64
+		rating   int
+		stock    int
+	}{
+		{"Books", 10, 5, 3},
+		{"Books", 24.99, 4, 0},
+		{"Games", 25, 4, 1},
+		{"Games", 60, 3, 0},
+		{"Games", 499.99, 5, 2},
+		{"Music", 800, 5, 7},
+	} {
+		created := mustCreateProduct(t, s, fmt.Sprintf("Facet %d", i), p.price)
+		created.Category = p.category
+		created.CountInStock = p.stock
+		if _, err := s.UpdateProduct(ctx, created); err != nil {
+			t.Fatalf("UpdateProduct: %v", err)
+		}
+		review := &storer.Review{ProductID: created.ID, UserID: reviewer.ID, Rating: p.rating, Status: storer.ReviewApproved}
+		if _, err := s.CreateReview(ctx, review); err != nil {
+			t.Fatalf("CreateReview: %v", err)
+		}
+	}
+
+	priceCounts := func(f *storer.ProductFacets) []int64 {
+		var out []int64
+		for _, b := range f.Prices {
+			out = append(out, b.Count)
+		}
+		return out
+	}
+
+	t.Run("All products", func(t *testing.T) {
+		f, err := s.GetProductFacets(ctx, storer.ProductFilter{})
+		if err != nil {
+			t.Fatalf("GetProductFacets: %v", err)
+		}
+		if f.Total != 6 || f.InStock != 4 || f.OutOfStock != 2 {
+			t.Errorf("total/in/out = %d/%d/%d, want 6/4/2", f.Total, f.InStock, f.OutOfStock)
+		}
+		wantCategories := []storer.FacetCount{{Value: "Games", Count: 3}, {Value: "Books", Count: 2}, {Value: "Music", Count: 1}}
+		if !reflect.DeepEqual(f.Categories, wantCategories) {
+			t.Errorf("categories = %+v, want %+v", f.Categories, wantCategories)
+		}
+		if got, want := priceCounts(f), []int64{2, 1, 1, 0, 1, 1}; !reflect.DeepEqual(got, want) {
+			t.Errorf("price buckets = %v, want %v", got, want)
+		}
+		if last := f.Prices[len(f.Prices)-1]; last.Max != nil {
+			t.Errorf("last price bucket max = %v, want open-ended", *last.Max)
+		}
+		wantRatings := []storer.RatingCount{{Rating: 5, Count: 3}, {Rating: 4, Count: 2}, {Rating: 3, Count: 1}}
+		if !reflect.DeepEqual(f.Ratings, wantRatings) {
+			t.Errorf("ratings = %+v, want %+v", f.Ratings, wantRatings)
+		}
+	})
+
+	t.Run("Filtered", func(t *testing.T) {
+		inStock := true
+		maxPrice := 500.0
+		f, err := s.GetProductFacets(ctx, storer.ProductFilter{InStock: &inStock, MaxPrice: &maxPrice})
+		if err != nil {
+			t.Fatalf("GetProductFacets: %v", err)
+		}
+		if f.Total != 3 || f.InStock != 3 || f.OutOfStock != 0 {
+			t.Errorf("total/in/out = %d/%d/%d, want 3/3/0", f.Total, f.InStock, f.OutOfStock)
+		}
+		wantCategories := []storer.FacetCount{{Value: "Games", Count: 2}, {Value: "Books", Count: 1}}
+		if !reflect.DeepEqual(f.Categories, wantCategories) {
+			t.Errorf("categories = %+v, want %+v", f.Categories, wantCategories)
+		}
+		if got, want := priceCounts(f), []int64{1, 1, 0, 0, 1, 0}; !reflect.DeepEqual(got, want) {
+			t.Errorf("price buckets = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("No matches", func(t *testing.T) {
+		f, err := s.GetProductFacets(ctx, storer.ProductFilter{Category: "Garden"})
+		if err != nil {
+			t.Fatalf("GetProductFacets: %v", err)
+		}
+		if f.Total != 0 || len(f.Categories) != 0 || len(f.Prices) != len(storer.PriceBuckets) {
+			t.Errorf("got %+v, want empty facets with every price bucket", f)
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package storertest
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"testing"
+)
+
+func testGuestOrders(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Get by token and claim", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		p := mustCreateProduct(t, s, "Keyboard", 50)
+		hash := "order-token-hash"
+		address := storer.ShippingAddress{Name: "Buyer", Line1: "1 Main St", City: "Springfield", PostalCode: "12345", Country: "US"}
+
+		o, err := s.CreateOrder(ctx, &storer.Order{
+			PaymentMethod:   "PayPal",
+			Email:           "buyer@example.com",
+			ShippingAddress: address,
+			AccessTokenHash: &hash,
+			Items:           []storer.OrderItem{{ProductID: p.ID, Name: p.Name, Image: p.Image, Price: p.Price, Quantity: 1}},
+		})
+		if err != nil {
+			t.Fatalf("CreateOrder: %v", err)
+		}
+
+		got, err := s.GetOrderByAccessToken(ctx, hash)
+		if err != nil {
+			t.Fatalf("GetOrderByAccessToken: %v", err)
+		}
+		if got.ID != o.ID || got.UserID != nil || got.Email != "buyer@example.com" || got.ShippingAddress != address || len(got.Items) != 1 {
+			t.Errorf("unexpected guest order: %+v", got)
+		}
+		events, err := s.ListOrderStatusEvents(ctx, o.ID)
+		if err != nil || len(events) != 1 || events[0].ChangedBy != nil {
+			t.Errorf("guest order events = %+v, %v", events, err)
+		}
+
+		claimed, err := s.ClaimOrder(ctx, hash, u.ID)
+		if err != nil {
+			t.Fatalf("ClaimOrder: %v", err)
+		}
+		if claimed.UserID == nil || *claimed.UserID != u.ID || claimed.AccessTokenHash != nil || len(claimed.Items) != 1 {
+			t.Errorf("unexpected claimed order: %+v", claimed)
+		}
+		if _, err := s.GetOrderByAccessToken(ctx, hash); !errors.Is(err, storer.ErrOrderNotFound) {
+			t.Errorf("expected ErrOrderNotFound after claim, got %v", err)
+		}
+		if _, err := s.ClaimOrder(ctx, hash, u.ID); !errors.Is(err, storer.ErrOrderNotFound) {
+			t.Errorf("expected second claim to fail, got %v", err)
+		}
+
+		page, err := s.ListOrders(ctx, storer.OrderQuery{OrderFilter: storer.OrderFilter{UserID: &u.ID}})
+		if err != nil || len(page.Items) != 1 || page.Items[0].ID != o.ID {
+			t.Errorf("claimed order not listed for user: %+v, %v", page, err)
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package storertest
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testIdempotencyKeys(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Claim and complete", func(t *testing.T) {
+		s := newStore(t)
+		k, err := s.CreateIdempotencyKey(ctx, &storer.IdempotencyKey{Scope: "user:1", Key: "k1", Fingerprint: "abc", ExpiresAt: time.Now().Add(time.Hour)})
+		if err != nil || k.ID == 0 {
+			t.Fatalf("CreateIdempotencyKey = %+v, %v", k, err)
+		}
+		got, err := s.GetIdempotencyKey(ctx, "user:1", "k1")
+		if err != nil || got.Completed() || got.Fingerprint != "abc" {
+			t.Fatalf("GetIdempotencyKey before completion = %+v, %v", got, err)
+		}
+		if _, err := s.CreateIdempotencyKey(ctx, &storer.IdempotencyKey{Scope: "user:1", Key: "k1", Fingerprint: "abc", ExpiresAt: time.Now().Add(time.Hour)}); !errors.Is(err, storer.ErrIdempotencyKeyExists) {
+			t.Errorf("expected ErrIdempotencyKeyExists, got %v", err)
+		}
+		if _, err := s.CreateIdempotencyKey(ctx, &storer.IdempotencyKey{Scope: "user:2", Key: "k1", Fingerprint: "def", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+			t.Errorf("same key in another scope: %v", err)
+		}
+
+		kept := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+		if err := s.CompleteIdempotencyKey(ctx, k.ID, 201, "application/json", []byte(`{"id":1}`), kept); err != nil {
+			t.Fatalf("CompleteIdempotencyKey: %v", err)
+		}
+		got, err = s.GetIdempotencyKey(ctx, "user:1", "k1")
+		if err != nil || got.StatusCode != 201 || got.ContentType != "application/json" || string(got.Body) != `{"id":1}` {
+			t.Errorf("GetIdempotencyKey after completion = %+v, %v", got, err)
+		}
+		if got != nil && !got.ExpiresAt.Equal(kept) {
+			t.Errorf("completed key expires at %v, want %v", got.ExpiresAt, kept)
+		}
+		if err := s.CompleteIdempotencyKey(ctx, 9999, 200, "", nil, kept); !errors.Is(err, storer.ErrIdempotencyKeyNotFound) {
+			t.Errorf("expected ErrIdempotencyKeyNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Delete and expire", func(t *testing.T) {
+		s := newStore(t)
+		now := time.Now()
+		k, _ := s.CreateIdempotencyKey(ctx, &storer.IdempotencyKey{Scope: "anon", Key: "released", Fingerprint: "a", ExpiresAt: now.Add(time.Hour)})
+		if err := s.DeleteIdempotencyKey(ctx, k.ID); err != nil {
+			t.Fatalf("DeleteIdempotencyKey: %v", err)
+		}
+		if _, err := s.GetIdempotencyKey(ctx, "anon", "released"); !errors.Is(err, storer.ErrIdempotencyKeyNotFound) {
+			t.Errorf("expected ErrIdempotencyKeyNotFound after delete, got %v", err)
+		}
+
+		s.CreateIdempotencyKey(ctx, &storer.IdempotencyKey{Scope: "anon", Key: "old", Fingerprint: "a", ExpiresAt: now.Add(-time.Minute)})
+		s.CreateIdempotencyKey(ctx, &storer.IdempotencyKey{Scope: "anon", Key: "fresh", Fingerprint: "a", ExpiresAt: now.Add(time.Hour)})
+		n, err := s.DeleteExpiredIdempotencyKeys(ctx, now)
+		if err != nil || n != 1 {
+			t.Fatalf("DeleteExpiredIdempotencyKeys = %d, %v, want 1", n, err)
+		}
+		if _, err := s.GetIdempotencyKey(ctx, "anon", "old"); !errors.Is(err, storer.ErrIdempotencyKeyNotFound) {
+			t.Errorf("expired key still found: %v", err)
+		}
+		if _, err := s.GetIdempotencyKey(ctx, "anon", "fresh"); err != nil {
+			t.Errorf("fresh key: %v", err)
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package storertest
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"testing"
+)
+
+func testProductImages(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+	s := newStore(t)
+	p := mustCreateProduct(t, s, "Vase", 25)
+	other := mustCreateProduct(t, s, "Bowl", 15)
+
+	var ids []uint
+	for _, key := range []string{"front.jpg", "side.jpg", "back.jpg"} {
+		img, err := s.CreateProductImage(ctx, &storer.ProductImage{
+			ProductID:    p.ID,
+			BlobKey:      key,
+			URL:          "https://cdn.example.com/" + key,
+			ThumbnailKey: "thumb-" + key,
+			ContentType:  "image/jpeg",
+			Size:         2048,
+			Width:        800,
+			Height:       600,
+			AltText:      "A blue vase",
+		})
+		if err != nil || img.ID == 0 || img.Position != len(ids) {
+			t.Fatalf("CreateProductImage(%s) = %+v, %v", key, img, err)
+		}
+		ids = append(ids, img.ID)
+	}
+	if _, err := s.CreateProductImage(ctx, &storer.ProductImage{ProductID: other.ID, URL: "https://cdn.example.com/bowl.jpg"}); err != nil {
+		t.Fatalf("CreateProductImage: %v", err)
+	}
+	if _, err := s.CreateProductImage(ctx, &storer.ProductImage{ProductID: 9999, URL: "https://cdn.example.com/x.jpg"}); !errors.Is(err, storer.ErrProductNotFound) {
+		t.Errorf("expected ErrProductNotFound, got %v", err)
+	}
+
+	got, err := s.GetProductImage(ctx, ids[0])
+	if err != nil || got.BlobKey != "front.jpg" || got.Width != 800 || got.Size != 2048 {
+		t.Errorf("GetProductImage = %+v, %v", got, err)
+	}
+	got.AltText, got.Position = "The front of a blue vase", 7
+	updated, err := s.UpdateProductImage(ctx, got)
+	if err != nil || updated.AltText != "The front of a blue vase" || updated.Position != 0 || updated.BlobKey != "front.jpg" {
+		t.Errorf("UpdateProductImage = %+v, %v, want only the alt text changed", updated, err)
+	}
+
+	for _, bad := range [][]uint{{ids[2], ids[1]}, {ids[2], ids[1], ids[1]}, {ids[2], ids[1], ids[0], 9999}} {
+		if _, err := s.ReorderProductImages(ctx, p.ID, bad); !errors.Is(err, storer.ErrInvalidImageOrder) {
+			t.Errorf("ReorderProductImages(%v): expected ErrInvalidImageOrder, got %v", bad, err)
+		}
+	}
+	images, err := s.ReorderProductImages(ctx, p.ID, []uint{ids[2], ids[0], ids[1]})
+	if err != nil || len(images) != 3 || images[0].ID != ids[2] || images[1].ID != ids[0] || images[2].ID != ids[1] {
+		t.Fatalf("ReorderProductImages = %+v, %v", images, err)
+	}
+	if images, _ := s.ListProductImages(ctx, p.ID); len(images) != 3 || images[0].ID != ids[2] || images[0].Position != 0 {
+		t.Errorf("ListProductImages = %+v", images)
+	}
+
+	if err := s.DeleteProductImage(ctx, ids[2]); err != nil {
+		t.Fatalf("DeleteProductImage: %v", err)
+	}
+	if _, err := s.GetProductImage(ctx, ids[2]); !errors.Is(err, storer.ErrImageNotFound) {
+		t.Errorf("expected ErrImageNotFound, got %v", err)
+	}
+	if err := s.DeleteProductImage(ctx, ids[2]); !errors.Is(err, storer.ErrImageNotFound) {
+		t.Errorf("expected ErrImageNotFound, got %v", err)
+	}
+	if _, err := s.UpdateProductImage(ctx, &storer.ProductImage{ID: 9999}); !errors.Is(err, storer.ErrImageNotFound) {
+		t.Errorf("expected ErrImageNotFound, got %v", err)
+	}
+	// a new image goes after the last one left
+	last, err := s.CreateProductImage(ctx, &storer.ProductImage{ProductID: p.ID, URL: "https://cdn.example.com/top.jpg"})
+	if err != nil || last.Position != 3 {
+		t.Errorf("CreateProductImage = %+v, %v, want position 3", last, err)
+	}
+	if images, _ := s.ListProductImages(ctx, other.ID); len(images) != 1 {
+		t.Errorf("images of the other product = %+v", images)
+	}
+}
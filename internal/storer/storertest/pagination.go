@@ -0,0 +1,178 @@
+package storertest
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func testPagination(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	seed := func(t *testing.T) storer.Store {
+		s := newStore(t)
+		prices := []float64{10, 30, 20, 30, 50, 40, 30}
+		for i, price := range prices {
+			category := "Books"
+			if i%2 == 0 {
+				category = "Games"
+			}
+			p := mustCreateProduct(t, s, fmt.Sprintf("Product %d", i), price)
+			p.Category = category
+			p.CountInStock = i % 3
+			if _, err := s.UpdateProduct(ctx, p); err != nil {
+				t.Fatalf("UpdateProduct: %v", err)
+			}
+		}
+		return s
+	}
+
+	t.Run("Cursor walks every row once", func(t *testing.T) {
+		s := seed(t)
+		var seen []storer.Product
+		q := storer.ProductQuery{ListParams: storer.ListParams{Limit: 2, Sort: "-price,name"}}
+		for pages := 0; ; pages++ {
+			if pages > 10 {
+				t.Fatal("cursor pagination did not terminate")
+			}
+			page, err := s.ListProducts(ctx, q)
+			if err != nil {
+				t.Fatalf("ListProducts: %v", err)
+			}
+			if page.Total != 7 {
+				t.Errorf("expected total 7, got %d", page.Total)
+			}
+			seen = append(seen, page.Items...)
+			if page.NextCursor == "" {
+				break
+			}
+			q.Cursor = page.NextCursor
+		}
+
+		if len(seen) != 7 {
+			t.Fatalf("expected 7 products across pages, got %d", len(seen))
+		}
+		ids := make(map[uint]bool)
+		for i, p := range seen {
+			if ids[p.ID] {
+				t.Errorf("product %d returned twice", p.ID)
+			}
+			ids[p.ID] = true
+			if i == 0 {
+				continue
+			}
+			prev := seen[i-1]
+			if prev.Price < p.Price || (prev.Price == p.Price && prev.Name > p.Name) {
+				t.Errorf("rows out of order at %d: %s %.2f before %s %.2f", i, prev.Name, prev.Price, p.Name, p.Price)
+			}
+		}
+	})
+
+	t.Run("Page numbers", func(t *testing.T) {
+		s := seed(t)
+		page, err := s.ListProducts(ctx, storer.ProductQuery{ListParams: storer.ListParams{Limit: 3, Page: 3}})
+		if err != nil {
+			t.Fatalf("ListProducts: %v", err)
+		}
+		if len(page.Items) != 1 || page.NextCursor != "" {
+			t.Errorf("expected 1 product on the last page, got %d (next %q)", len(page.Items), page.NextCursor)
+		}
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		s := seed(t)
+		min, max := 20.0, 40.0
+		inStock := true
+		page, err := s.ListProducts(ctx, storer.ProductQuery{
+			ProductFilter: storer.ProductFilter{Category: "Games", MinPrice: &min, MaxPrice: &max, InStock: &inStock},
+		})
+		if err != nil {
+			t.Fatalf("ListProducts: %v", err)
+		}
+		for _, p := range page.Items {
+			if p.Category != "Games" || p.Price < min || p.Price > max || p.CountInStock <= 0 {
+				t.Errorf("product does not match filter: %+v", p)
+			}
+		}
+		// Games are the even indexes: 0 (10, stock 0), 2 (20, stock 2), 4 (50, stock 1), 6 (30, stock 0)
+		if page.Total != 1 {
+			t.Errorf("expected 1 matching product, got %d", page.Total)
+		}
+	})
+
+	t.Run("Invalid queries", func(t *testing.T) {
+		s := newStore(t)
+		bad := []storer.ProductQuery{
+			{ListParams: storer.ListParams{Sort: "password"}},
+			{ListParams: storer.ListParams{Cursor: "not-a-cursor"}},
+			{ListParams: storer.ListParams{Limit: storer.MaxPageSize + 1}},
+			{ListParams: storer.ListParams{Page: 2, Cursor: "abc"}},
+		}
+		for _, q := range bad {
+			if _, err := s.ListProducts(ctx, q); !errors.Is(err, storer.ErrInvalidQuery) {
+				t.Errorf("expected ErrInvalidQuery for %+v, got %v", q.ListParams, err)
+			}
+		}
+	})
+
+	t.Run("Cursor bound to sort", func(t *testing.T) {
+		s := seed(t)
+		page, err := s.ListProducts(ctx, storer.ProductQuery{ListParams: storer.ListParams{Limit: 2, Sort: "price"}})
+		if err != nil {
+			t.Fatalf("ListProducts: %v", err)
+		}
+		_, err = s.ListProducts(ctx, storer.ProductQuery{ListParams: storer.ListParams{Limit: 2, Sort: "name", Cursor: page.NextCursor}})
+		if !errors.Is(err, storer.ErrInvalidQuery) {
+			t.Errorf("expected ErrInvalidQuery reusing a cursor with another sort, got %v", err)
+		}
+	})
+
+	t.Run("Orders by user and users by role", func(t *testing.T) {
+		s := newStore(t)
+		alice := mustCreateUser(t, s, "alice@example.com", true)
+		bob := mustCreateUser(t, s, "bob@example.com", false)
+		p := mustCreateProduct(t, s, "Pen", 2)
+		mustCreateOrder(t, s, alice.ID, p, 1)
+		mustCreateOrder(t, s, bob.ID, p, 1)
+		mustCreateOrder(t, s, bob.ID, p, 2)
+
+		q := storer.OrderQuery{
+			ListParams:  storer.ListParams{Limit: 1, Sort: "-created_at"},
+			OrderFilter: storer.OrderFilter{UserID: &bob.ID},
+		}
+		var seen []storer.Order
+		for i := 0; i < 3; i++ {
+			orders, err := s.ListOrders(ctx, q)
+			if err != nil {
+				t.Fatalf("ListOrders: %v", err)
+			}
+			if orders.Total != 2 {
+				t.Errorf("expected 2 orders for bob, got %d", orders.Total)
+			}
+			seen = append(seen, orders.Items...)
+			if orders.NextCursor == "" {
+				break
+			}
+			q.Cursor = orders.NextCursor
+		}
+		if len(seen) != 2 {
+			t.Fatalf("expected to walk 2 orders, got %d", len(seen))
+		}
+		for _, o := range seen {
+			if o.UserID == nil || *o.UserID != bob.ID {
+				t.Errorf("order %d belongs to user %v", o.ID, o.UserID)
+			}
+		}
+
+		admin := true
+		users, err := s.ListUsers(ctx, storer.UserQuery{UserFilter: storer.UserFilter{IsAdmin: &admin}})
+		if err != nil {
+			t.Fatalf("ListUsers: %v", err)
+		}
+		if users.Total != 1 || users.Items[0].ID != alice.ID {
+			t.Errorf("expected only alice to be admin, got %+v", users.Items)
+		}
+	})
+}
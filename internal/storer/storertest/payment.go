@@ -0,0 +1,119 @@
+package storertest
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"testing"
+)
+
+func testPayments(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Capture marks the order paid", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "payer@example.com", false)
+		p := mustCreateProduct(t, s, "Monitor", 120)
+		o := mustCreateOrder(t, s, u.ID, p, 1)
+
+		pay, err := s.CreatePayment(ctx, &storer.Payment{OrderID: o.ID, Provider: "Stripe", IntentID: "pi_1", Status: storer.PaymentPending, Amount: o.TotalPrice, Currency: "USD"})
+		if err != nil {
+			t.Fatalf("CreatePayment: %v", err)
+		}
+		got, err := s.GetPaymentByIntent(ctx, "Stripe", "pi_1")
+		if err != nil || got.ID != pay.ID || got.Status != storer.PaymentPending {
+			t.Fatalf("GetPaymentByIntent = %+v, %v", got, err)
+		}
+		if _, err := s.GetPaymentByIntent(ctx, "PayPal", "pi_1"); !errors.Is(err, storer.ErrPaymentNotFound) {
+			t.Errorf("expected ErrPaymentNotFound for another provider, got %v", err)
+		}
+
+		captured, err := s.CapturePayment(ctx, pay.ID, "ch_1")
+		if err != nil {
+			t.Fatalf("CapturePayment: %v", err)
+		}
+		if captured.Status != storer.PaymentCaptured || captured.CaptureID != "ch_1" {
+			t.Errorf("unexpected captured payment: %+v", captured)
+		}
+		paid, err := s.GetOrderByID(ctx, o.ID)
+		if err != nil || paid.Status != storer.OrderPaid {
+			t.Fatalf("order after capture = %+v, %v", paid, err)
+		}
+		events, err := s.ListOrderStatusEvents(ctx, o.ID)
+		if err != nil || len(events) != 2 || events[1].ToStatus != storer.OrderPaid || events[1].ChangedBy != nil {
+			t.Errorf("events after capture = %+v, %v", events, err)
+		}
+
+		if _, err := s.CapturePayment(ctx, pay.ID, "ch_2"); !errors.Is(err, storer.ErrPaymentNotPending) {
+			t.Errorf("expected ErrPaymentNotPending on second capture, got %v", err)
+		}
+		if _, err := s.FailPayment(ctx, pay.ID, "late decline"); !errors.Is(err, storer.ErrPaymentNotPending) {
+			t.Errorf("expected ErrPaymentNotPending failing a captured payment, got %v", err)
+		}
+	})
+
+	t.Run("Capture rolls back when the order cannot be paid", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "late@example.com", false)
+		p := mustCreateProduct(t, s, "Lamp", 30)
+		o := mustCreateOrder(t, s, u.ID, p, 1)
+		pay, err := s.CreatePayment(ctx, &storer.Payment{OrderID: o.ID, Provider: "PayPal", IntentID: "PAY-1", Status: storer.PaymentPending, Amount: o.TotalPrice, Currency: "USD"})
+		if err != nil {
+			t.Fatalf("CreatePayment: %v", err)
+		}
+		if _, err := s.UpdateOrderStatus(ctx, o.ID, storer.StatusChange{To: storer.OrderCancelled}); err != nil {
+			t.Fatalf("UpdateOrderStatus: %v", err)
+		}
+
+		if _, err := s.CapturePayment(ctx, pay.ID, "CAP-1"); !errors.Is(err, storer.ErrInvalidTransition) {
+			t.Fatalf("expected ErrInvalidTransition, got %v", err)
+		}
+		got, err := s.GetPayment(ctx, pay.ID)
+		if err != nil || got.Status != storer.PaymentPending || got.CaptureID != "" {
+			t.Errorf("payment after failed capture = %+v, %v", got, err)
+		}
+
+		failed, err := s.FailPayment(ctx, pay.ID, "order cancelled")
+		if err != nil || failed.Status != storer.PaymentFailed || failed.FailureReason != "order cancelled" {
+			t.Errorf("FailPayment = %+v, %v", failed, err)
+		}
+		payments, err := s.ListPayments(ctx, o.ID)
+		if err != nil || len(payments) != 1 || payments[0].Status != storer.PaymentFailed {
+			t.Errorf("ListPayments = %+v, %v", payments, err)
+		}
+	})
+
+	t.Run("Webhook events", func(t *testing.T) {
+		s := newStore(t)
+		if _, err := s.GetWebhookEvent(ctx, "Stripe", "evt_1"); !errors.Is(err, storer.ErrWebhookEventNotFound) {
+			t.Errorf("expected ErrWebhookEventNotFound, got %v", err)
+		}
+		e, err := s.CreateWebhookEvent(ctx, &storer.WebhookEvent{Provider: "Stripe", EventID: "evt_1", Type: "capture.succeeded", Outcome: "ignored"})
+		if err != nil || e.ID == 0 {
+			t.Fatalf("CreateWebhookEvent = %+v, %v", e, err)
+		}
+		got, err := s.GetWebhookEvent(ctx, "Stripe", "evt_1")
+		if err != nil || got.ID != e.ID || got.Outcome != "ignored" || got.PaymentID != nil {
+			t.Errorf("GetWebhookEvent = %+v, %v", got, err)
+		}
+		if _, err := s.CreateWebhookEvent(ctx, &storer.WebhookEvent{Provider: "Stripe", EventID: "evt_1", Type: "capture.succeeded", Outcome: "ignored"}); !errors.Is(err, storer.ErrWebhookEventExists) {
+			t.Errorf("expected ErrWebhookEventExists, got %v", err)
+		}
+		if _, err := s.CreateWebhookEvent(ctx, &storer.WebhookEvent{Provider: "PayPal", EventID: "evt_1", Type: "refunded", Outcome: "ignored"}); err != nil {
+			t.Errorf("same event ID from another provider: %v", err)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		s := newStore(t)
+		if _, err := s.CreatePayment(ctx, &storer.Payment{OrderID: 999, Provider: "Stripe", IntentID: "pi_x", Status: storer.PaymentPending}); !errors.Is(err, storer.ErrOrderNotFound) {
+			t.Errorf("expected ErrOrderNotFound, got %v", err)
+		}
+		if _, err := s.ListPayments(ctx, 999); !errors.Is(err, storer.ErrOrderNotFound) {
+			t.Errorf("expected ErrOrderNotFound, got %v", err)
+		}
+		if _, err := s.CapturePayment(ctx, 999, "ch_x"); !errors.Is(err, storer.ErrPaymentNotFound) {
+			t.Errorf("expected ErrPaymentNotFound, got %v", err)
+		}
+	})
+}
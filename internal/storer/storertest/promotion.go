@@ -0,0 +1,196 @@
+package storertest
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testPromotions(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+	code := func(s string) *string { return &s }
+
+	t.Run("Create, update and delete", func(t *testing.T) {
+		s := newStore(t)
+		ends := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+		p, err := s.CreatePromotion(ctx, &storer.Promotion{
+			Name:     "Summer sale",
+			Code:     code("SUMMER"),
+			Kind:     storer.PromotionPercent,
+			Value:    15,
+			MinOrder: 50,
+			Scope:    storer.PromotionScope{ProductIDs: []uint{1, 2}, Categories: []string{"Electronics"}},
+			EndsAt:   &ends,
+			IsActive: true,
+		})
+		if err != nil || p.ID == 0 {
+			t.Fatalf("CreatePromotion = %+v, %v", p, err)
+		}
+		got, err := s.GetPromotionByCode(ctx, "SUMMER")
+		if err != nil || got.ID != p.ID || got.Value != 15 || len(got.Scope.ProductIDs) != 2 || got.Scope.Categories[0] != "Electronics" {
+			t.Fatalf("GetPromotionByCode = %+v, %v", got, err)
+		}
+		if got.EndsAt == nil || !got.EndsAt.Equal(ends) || got.StartsAt != nil {
+			t.Errorf("validity window = %v - %v, want open until %v", got.StartsAt, got.EndsAt, ends)
+		}
+		if _, err := s.CreatePromotion(ctx, &storer.Promotion{Name: "Copy", Code: code("SUMMER"), Kind: storer.PromotionFixed, Value: 5}); !errors.Is(err, storer.ErrPromotionCodeTaken) {
+			t.Errorf("duplicate code: expected ErrPromotionCodeTaken, got %v", err)
+		}
+		// automatic promotions have no code, any number of them
+		for _, name := range []string{"Auto one", "Auto two"} {
+			if _, err := s.CreatePromotion(ctx, &storer.Promotion{Name: name, Kind: storer.PromotionFreeShipping, IsActive: true}); err != nil {
+				t.Fatalf("CreatePromotion(%s): %v", name, err)
+			}
+		}
+		if list, err := s.ListPromotions(ctx); err != nil || len(list) != 3 || list[0].ID != p.ID {
+			t.Errorf("ListPromotions = %+v, %v", list, err)
+		}
+
+		got.Value = 20
+		got.Scope = storer.PromotionScope{}
+		got.UsedCount = 99
+		updated, err := s.UpdatePromotion(ctx, got)
+		if err != nil || updated.Value != 20 || !updated.Scope.IsZero() || updated.UsedCount != 0 {
+			t.Errorf("UpdatePromotion = %+v, %v, want the usage count kept", updated, err)
+		}
+		if _, err := s.UpdatePromotion(ctx, &storer.Promotion{ID: 9999, Name: "x", Kind: storer.PromotionFixed}); !errors.Is(err, storer.ErrPromotionNotFound) {
+			t.Errorf("expected ErrPromotionNotFound, got %v", err)
+		}
+
+		if err := s.DeletePromotion(ctx, p.ID); err != nil {
+			t.Fatalf("DeletePromotion: %v", err)
+		}
+		if _, err := s.GetPromotion(ctx, p.ID); !errors.Is(err, storer.ErrPromotionNotFound) {
+			t.Errorf("expected ErrPromotionNotFound, got %v", err)
+		}
+		if err := s.DeletePromotion(ctx, p.ID); !errors.Is(err, storer.ErrPromotionNotFound) {
+			t.Errorf("expected ErrPromotionNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Redeem", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		product := mustCreateProduct(t, s, "Widget", 10)
+		p, err := s.CreatePromotion(ctx, &storer.Promotion{Name: "Welcome", Code: code("WELCOME"), Kind: storer.PromotionFixed, Value: 2, UsageLimit: 3, PerUserLimit: 1, IsActive: true})
+		if err != nil {
+			t.Fatalf("CreatePromotion: %v", err)
+		}
+		order := func(userID *uint, email string) (*storer.Order, error) {
+			return s.CreateOrder(ctx, &storer.Order{
+				PaymentMethod: "PayPal",
+				UserID:        userID,
+				Email:         email,
+				ItemsPrice:    10,
+				DiscountPrice: 2,
+				TotalPrice:    8,
+				Discounts:     storer.OrderDiscounts{{PromotionID: p.ID, Code: "WELCOME", Name: p.Name, Kind: p.Kind, Amount: 2}},
+				Items:         []storer.OrderItem{{Name: product.Name, Price: product.Price, Quantity: 1, Discount: 2, ProductID: product.ID}},
+			})
+		}
+
+		o, err := order(&u.ID, u.Email)
+		if err != nil {
+			t.Fatalf("CreateOrder: %v", err)
+		}
+		got, err := s.GetOrderByID(ctx, o.ID)
+		if err != nil || got.DiscountPrice != 2 || len(got.Discounts) != 1 || got.Discounts[0].Code != "WELCOME" || got.Items[0].Discount != 2 {
+			t.Fatalf("GetOrderByID = %+v, %v, want the discounts stored", got, err)
+		}
+		if n, err := s.CountRedemptions(ctx, p.ID, storer.Redeemer{UserID: &u.ID}); err != nil || n != 1 {
+			t.Errorf("CountRedemptions = %d, %v, want 1", n, err)
+		}
+
+		// the failed order redeems nothing and takes nothing out of stock
+		if _, err := order(&u.ID, u.Email); !errors.Is(err, storer.ErrPromotionUserLimit) {
+			t.Errorf("second use: expected ErrPromotionUserLimit, got %v", err)
+		}
+		if after, _ := s.GetProduct(ctx, product.ID); after.CountInStock != 9 {
+			t.Errorf("stock = %d after a rejected order, want 9", after.CountInStock)
+		}
+
+		// guests are told apart by email
+		if _, err := order(nil, "guest@example.com"); err != nil {
+			t.Fatalf("guest CreateOrder: %v", err)
+		}
+		if _, err := order(nil, "guest@example.com"); !errors.Is(err, storer.ErrPromotionUserLimit) {
+			t.Errorf("second guest use: expected ErrPromotionUserLimit, got %v", err)
+		}
+		if n, _ := s.CountRedemptions(ctx, p.ID, storer.Redeemer{Email: "buyer@example.com"}); n != 0 {
+			t.Errorf("a user's redemption counted against a guest with the same email")
+		}
+
+		if _, err := order(nil, "third@example.com"); err != nil {
+			t.Fatalf("third CreateOrder: %v", err)
+		}
+		if _, err := order(nil, "fourth@example.com"); !errors.Is(err, storer.ErrPromotionUsedUp) {
+			t.Errorf("past the usage limit: expected ErrPromotionUsedUp, got %v", err)
+		}
+		if p, _ = s.GetPromotion(ctx, p.ID); p.UsedCount != 3 {
+			t.Errorf("used %d times, want 3", p.UsedCount)
+		}
+	})
+
+	t.Run("Cancel and delete give uses back", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		product := mustCreateProduct(t, s, "Widget", 10)
+		p, err := s.CreatePromotion(ctx, &storer.Promotion{Name: "Once", Code: code("ONCE"), Kind: storer.PromotionFixed, Value: 2, UsageLimit: 2, PerUserLimit: 1, IsActive: true})
+		if err != nil {
+			t.Fatalf("CreatePromotion: %v", err)
+		}
+		order := func(userID *uint, email string) *storer.Order {
+			t.Helper()
+			o, err := s.CreateOrder(ctx, &storer.Order{
+				PaymentMethod: "PayPal",
+				UserID:        userID,
+				Email:         email,
+				ItemsPrice:    10,
+				DiscountPrice: 2,
+				TotalPrice:    8,
+				Discounts:     storer.OrderDiscounts{{PromotionID: p.ID, Code: "ONCE", Name: p.Name, Kind: p.Kind, Amount: 2}},
+				Items:         []storer.OrderItem{{Name: product.Name, Price: product.Price, Quantity: 1, Discount: 2, ProductID: product.ID}},
+			})
+			if err != nil {
+				t.Fatalf("CreateOrder: %v", err)
+			}
+			return o
+		}
+		used := func() int {
+			got, _ := s.GetPromotion(ctx, p.ID)
+			return got.UsedCount
+		}
+
+		mine := order(&u.ID, u.Email)
+		guest := order(nil, "guest@example.com")
+		if _, err := s.UpdateOrderStatus(ctx, mine.ID, storer.StatusChange{To: storer.OrderCancelled}); err != nil {
+			t.Fatalf("UpdateOrderStatus: %v", err)
+		}
+		if n, _ := s.CountRedemptions(ctx, p.ID, storer.Redeemer{UserID: &u.ID}); n != 0 || used() != 1 {
+			t.Errorf("after cancelling: %d redemptions, used %d times, want 0 and 1", n, used())
+		}
+		// deleting the cancelled order gives nothing back twice
+		if err := s.DeleteOrder(ctx, mine.ID); err != nil {
+			t.Fatalf("DeleteOrder: %v", err)
+		}
+		if used() != 1 {
+			t.Errorf("used %d times after deleting a cancelled order, want 1", used())
+		}
+
+		if err := s.DeleteOrder(ctx, guest.ID); err != nil {
+			t.Fatalf("DeleteOrder: %v", err)
+		}
+		if n, _ := s.CountRedemptions(ctx, p.ID, storer.Redeemer{Email: "guest@example.com"}); n != 0 || used() != 0 {
+			t.Errorf("after deleting: %d redemptions, used %d times, want 0 and 0", n, used())
+		}
+
+		// both buyers may use the code again
+		order(&u.ID, u.Email)
+		order(nil, "guest@example.com")
+		if used() != 2 {
+			t.Errorf("used %d times, want 2", used())
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package storertest
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"testing"
+)
+
+func testReturns(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	// deliveredOrder places an order for three of p and walks it to delivered.
+	deliveredOrder := func(t *testing.T, s storer.Store, p *storer.Product) *storer.Order {
+		t.Helper()
+		u := mustCreateUser(t, s, "returns@example.com", false)
+		o := mustCreateOrder(t, s, u.ID, p, 3)
+		for _, to := range []storer.OrderStatus{storer.OrderPaid, storer.OrderProcessing, storer.OrderShipped, storer.OrderDelivered} {
+			if _, err := s.UpdateOrderStatus(ctx, o.ID, storer.StatusChange{To: to}); err != nil {
+				t.Fatalf("UpdateOrderStatus(%s): %v", to, err)
+			}
+		}
+		return o
+	}
+
+	t.Run("Return and restock", func(t *testing.T) {
+		s := newStore(t)
+		p := mustCreateProduct(t, s, "Lamp", 40)
+		o := deliveredOrder(t, s, p)
+		item := o.Items[0]
+
+		r, err := s.CreateReturn(ctx, &storer.ReturnRequest{OrderID: o.ID, OrderItemID: item.ID, Quantity: 2, Reason: storer.ReturnDamaged})
+		if err != nil || r.ID == 0 || r.Status != storer.ReturnRequested {
+			t.Fatalf("CreateReturn = %+v, %v", r, err)
+		}
+		if _, err := s.CreateReturn(ctx, &storer.ReturnRequest{OrderID: o.ID, OrderItemID: item.ID, Quantity: 2, Reason: storer.ReturnDamaged}); !errors.Is(err, storer.ErrReturnQuantityExceeded) {
+			t.Errorf("expected ErrReturnQuantityExceeded, got %v", err)
+		}
+		if _, err := s.CreateReturn(ctx, &storer.ReturnRequest{OrderID: o.ID, OrderItemID: 9999, Quantity: 1, Reason: storer.ReturnDamaged}); !errors.Is(err, storer.ErrOrderItemNotFound) {
+			t.Errorf("expected ErrOrderItemNotFound, got %v", err)
+		}
+		if _, err := s.UpdateReturnStatus(ctx, r.ID, storer.ReturnChange{To: storer.ReturnReceived}); !errors.Is(err, storer.ErrInvalidReturnTransition) {
+			t.Errorf("expected ErrInvalidReturnTransition receiving an unapproved return, got %v", err)
+		}
+
+		adminID := uint(99)
+		if _, err := s.UpdateReturnStatus(ctx, r.ID, storer.ReturnChange{To: storer.ReturnApproved, ChangedBy: &adminID, Note: "ok"}); err != nil {
+			t.Fatalf("approve: %v", err)
+		}
+		before, _ := s.GetProduct(ctx, p.ID)
+		received, err := s.UpdateReturnStatus(ctx, r.ID, storer.ReturnChange{To: storer.ReturnReceived, ChangedBy: &adminID})
+		if err != nil || received.Status != storer.ReturnReceived || received.ReviewedBy == nil || *received.ReviewedBy != adminID {
+			t.Fatalf("receive = %+v, %v", received, err)
+		}
+		after, _ := s.GetProduct(ctx, p.ID)
+		if after.CountInStock != before.CountInStock+2 {
+			t.Errorf("stock after receipt = %d, want %d", after.CountInStock, before.CountInStock+2)
+		}
+
+		rejected, _ := s.CreateReturn(ctx, &storer.ReturnRequest{OrderID: o.ID, OrderItemID: item.ID, Quantity: 1, Reason: storer.ReturnNoLongerNeeded})
+		s.UpdateReturnStatus(ctx, rejected.ID, storer.ReturnChange{To: storer.ReturnRejected})
+		if _, err := s.CreateReturn(ctx, &storer.ReturnRequest{OrderID: o.ID, OrderItemID: item.ID, Quantity: 1, Reason: storer.ReturnReasonOther}); err != nil {
+			t.Errorf("a rejected return should not count against the quantity: %v", err)
+		}
+		returns, err := s.ListReturns(ctx, o.ID)
+		if err != nil || len(returns) != 3 {
+			t.Errorf("ListReturns = %d returns, %v", len(returns), err)
+		}
+	})
+
+	t.Run("Refunds", func(t *testing.T) {
+		s := newStore(t)
+		p := mustCreateProduct(t, s, "Vase", 40)
+		o := deliveredOrder(t, s, p) // 3 x 40 + 5 shipping
+		r, _ := s.CreateReturn(ctx, &storer.ReturnRequest{OrderID: o.ID, OrderItemID: o.Items[0].ID, Quantity: 2, Reason: storer.ReturnWrongItem})
+
+		if _, err := s.CreateRefund(ctx, &storer.Refund{OrderID: o.ID, ReturnID: &r.ID, Amount: 80}); !errors.Is(err, storer.ErrReturnNotRefundable) {
+			t.Errorf("expected ErrReturnNotRefundable before receipt, got %v", err)
+		}
+		s.UpdateReturnStatus(ctx, r.ID, storer.ReturnChange{To: storer.ReturnApproved})
+		s.UpdateReturnStatus(ctx, r.ID, storer.ReturnChange{To: storer.ReturnReceived})
+
+		refund, err := s.CreateRefund(ctx, &storer.Refund{OrderID: o.ID, ReturnID: &r.ID, Amount: 80, Reason: "returned"})
+		if err != nil || refund.Status != storer.RefundPending {
+			t.Fatalf("CreateRefund = %+v, %v", refund, err)
+		}
+		if _, err := s.CreateRefund(ctx, &storer.Refund{OrderID: o.ID, ReturnID: &r.ID, Amount: 1}); !errors.Is(err, storer.ErrReturnNotRefundable) {
+			t.Errorf("expected ErrReturnNotRefundable for a second refund, got %v", err)
+		}
+		if _, err := s.CreateRefund(ctx, &storer.Refund{OrderID: o.ID, Amount: 50}); !errors.Is(err, storer.ErrRefundExceedsTotal) {
+			t.Errorf("expected ErrRefundExceedsTotal, got %v", err)
+		}
+		if got, _ := s.GetOrderByID(ctx, o.ID); got.RefundedAmount != 80 {
+			t.Errorf("refunded amount = %v, want 80", got.RefundedAmount)
+		}
+
+		if _, err := s.CompleteRefund(ctx, refund.ID, "re_1"); err != nil {
+			t.Fatalf("CompleteRefund: %v", err)
+		}
+		if _, err := s.CompleteRefund(ctx, refund.ID, "re_1"); !errors.Is(err, storer.ErrRefundNotPending) {
+			t.Errorf("expected ErrRefundNotPending completing twice, got %v", err)
+		}
+		if got, _ := s.GetReturn(ctx, r.ID); got.Status != storer.ReturnRefunded {
+			t.Errorf("return status = %s, want refunded", got.Status)
+		}
+		if got, _ := s.GetOrderByID(ctx, o.ID); got.Status != storer.OrderDelivered {
+			t.Errorf("order status = %s after a partial refund", got.Status)
+		}
+
+		failed, _ := s.CreateRefund(ctx, &storer.Refund{OrderID: o.ID, Amount: 45})
+		if _, err := s.FailRefund(ctx, failed.ID, "card expired"); err != nil {
+			t.Fatalf("FailRefund: %v", err)
+		}
+		if got, _ := s.GetOrderByID(ctx, o.ID); got.RefundedAmount != 80 {
+			t.Errorf("refunded amount after a failed refund = %v, want 80", got.RefundedAmount)
+		}
+
+		rest, err := s.CreateRefund(ctx, &storer.Refund{OrderID: o.ID, Amount: 45})
+		if err != nil {
+			t.Fatalf("CreateRefund of the rest: %v", err)
+		}
+		submitted, err := s.SubmitRefund(ctx, rest.ID, "re_2")
+		if err != nil || submitted.Status != storer.RefundPending || submitted.ProviderRefundID != "re_2" {
+			t.Fatalf("SubmitRefund = %+v, %v", submitted, err)
+		}
+		if got, _ := s.GetOrderByID(ctx, o.ID); got.Status != storer.OrderDelivered || got.RefundedAmount != 125 {
+			t.Errorf("order with a submitted refund = %s, %v", got.Status, got.RefundedAmount)
+		}
+		if _, err := s.SubmitRefund(ctx, refund.ID, "re_3"); !errors.Is(err, storer.ErrRefundNotPending) {
+			t.Errorf("expected ErrRefundNotPending submitting a completed refund, got %v", err)
+		}
+		if _, err := s.CompleteRefund(ctx, rest.ID, "re_2"); err != nil {
+			t.Fatalf("CompleteRefund: %v", err)
+		}
+		if got, _ := s.GetOrderByID(ctx, o.ID); got.Status != storer.OrderRefunded || got.RefundedAmount != 125 {
+			t.Errorf("order after a full refund = %s, %v", got.Status, got.RefundedAmount)
+		}
+		refunds, err := s.ListRefunds(ctx, o.ID)
+		if err != nil || len(refunds) != 3 {
+			t.Errorf("ListRefunds = %d refunds, %v", len(refunds), err)
+		}
+		if _, err := s.ListRefunds(ctx, 9999); !errors.Is(err, storer.ErrOrderNotFound) {
+			t.Errorf("expected ErrOrderNotFound, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package storertest
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"testing"
+)
+
+func testReviews(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	ratingOf := func(t *testing.T, s storer.Store, p *storer.Product) (float64, int) {
+		t.Helper()
+		got, err := s.GetProduct(ctx, p.ID)
+		if err != nil {
+			t.Fatalf("GetProduct: %v", err)
+		}
+		return got.Rating, got.NumReviews
+	}
+
+	t.Run("Rating follows approved reviews", func(t *testing.T) {
+		s := newStore(t)
+		p := mustCreateProduct(t, s, "Kettle", 30)
+		alice := mustCreateUser(t, s, "alice@example.com", false)
+		bob := mustCreateUser(t, s, "bob@example.com", false)
+		carol := mustCreateUser(t, s, "carol@example.com", false)
+
+		pending, err := s.CreateReview(ctx, &storer.Review{ProductID: p.ID, UserID: alice.ID, Rating: 4, Title: "Good", Body: "Boils fast", Status: storer.ReviewPending})
+		if err != nil || pending.ID == 0 {
+			t.Fatalf("CreateReview = %+v, %v", pending, err)
+		}
+		if rating, n := ratingOf(t, s, p); rating != 0 || n != 0 {
+			t.Errorf("rating with a pending review = %v / %d, want 0 / 0", rating, n)
+		}
+		if _, err := s.CreateReview(ctx, &storer.Review{ProductID: p.ID, UserID: alice.ID, Rating: 1, Status: storer.ReviewPending}); !errors.Is(err, storer.ErrReviewExists) {
+			t.Errorf("second review: expected ErrReviewExists, got %v", err)
+		}
+		if _, err := s.CreateReview(ctx, &storer.Review{ProductID: 9999, UserID: alice.ID, Rating: 1, Status: storer.ReviewPending}); !errors.Is(err, storer.ErrProductNotFound) {
+			t.Errorf("expected ErrProductNotFound, got %v", err)
+		}
+
+		pending.Status = storer.ReviewApproved
+		pending.ModeratedBy = &carol.ID
+		approved, err := s.UpdateReview(ctx, pending)
+		if err != nil || approved.Status != storer.ReviewApproved || approved.ModeratedBy == nil || approved.Title != "Good" {
+			t.Fatalf("UpdateReview = %+v, %v", approved, err)
+		}
+		for _, r := range []*storer.Review{
+			{ProductID: p.ID, UserID: bob.ID, Rating: 5, Status: storer.ReviewApproved},
+			{ProductID: p.ID, UserID: carol.ID, Rating: 5, Status: storer.ReviewApproved},
+		} {
+			if _, err := s.CreateReview(ctx, r); err != nil {
+				t.Fatalf("CreateReview: %v", err)
+			}
+		}
+		if rating, n := ratingOf(t, s, p); rating != 4.67 || n != 3 {
+			t.Errorf("rating = %v / %d, want 4.67 / 3", rating, n)
+		}
+
+		// the rating is not the product editor's to change
+		p.Rating, p.NumReviews = 1, 100
+		updated, err := s.UpdateProduct(ctx, p)
+		if err != nil || updated.Rating != 4.67 || updated.NumReviews != 3 {
+			t.Errorf("UpdateProduct = %+v, %v, want the rating of the reviews", updated, err)
+		}
+
+		approved.Status = storer.ReviewRejected
+		if _, err := s.UpdateReview(ctx, approved); err != nil {
+			t.Fatalf("UpdateReview: %v", err)
+		}
+		if rating, n := ratingOf(t, s, p); rating != 5 || n != 2 {
+			t.Errorf("rating after rejecting = %v / %d, want 5 / 2", rating, n)
+		}
+
+		page, err := s.ListReviews(ctx, storer.ReviewQuery{
+			ListParams:   storer.ListParams{Limit: 1, Sort: "-created_at"},
+			ReviewFilter: storer.ReviewFilter{ProductID: &p.ID, Status: storer.ReviewApproved},
+		})
+		if err != nil || page.Total != 2 || len(page.Items) != 1 || page.NextCursor == "" {
+			t.Fatalf("ListReviews = %+v, %v", page, err)
+		}
+		next, err := s.ListReviews(ctx, storer.ReviewQuery{
+			ListParams:   storer.ListParams{Limit: 1, Sort: "-created_at", Cursor: page.NextCursor},
+			ReviewFilter: storer.ReviewFilter{ProductID: &p.ID, Status: storer.ReviewApproved},
+		})
+		if err != nil || len(next.Items) != 1 || next.Items[0].ID == page.Items[0].ID || next.Items[0].Status != storer.ReviewApproved {
+			t.Errorf("second page = %+v, %v", next, err)
+		}
+		mine, err := s.ListReviews(ctx, storer.ReviewQuery{ReviewFilter: storer.ReviewFilter{UserID: &alice.ID}})
+		if err != nil || len(mine.Items) != 1 || mine.Items[0].ID != approved.ID {
+			t.Errorf("ListReviews by user = %+v, %v", mine, err)
+		}
+
+		if err := s.DeleteReview(ctx, next.Items[0].ID); err != nil {
+			t.Fatalf("DeleteReview: %v", err)
+		}
+		if rating, n := ratingOf(t, s, p); rating != 5 || n != 1 {
+			t.Errorf("rating after delete = %v / %d, want 5 / 1", rating, n)
+		}
+		if _, err := s.GetReview(ctx, next.Items[0].ID); !errors.Is(err, storer.ErrReviewNotFound) {
+			t.Errorf("expected ErrReviewNotFound, got %v", err)
+		}
+		if err := s.DeleteReview(ctx, 9999); !errors.Is(err, storer.ErrReviewNotFound) {
+			t.Errorf("expected ErrReviewNotFound, got %v", err)
+		}
+		if _, err := s.UpdateReview(ctx, &storer.Review{ID: 9999, Rating: 3, Status: storer.ReviewPending}); !errors.Is(err, storer.ErrReviewNotFound) {
+			t.Errorf("expected ErrReviewNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Delivered orders", func(t *testing.T) {
+		s := newStore(t)
+		p := mustCreateProduct(t, s, "Lamp", 40)
+		other := mustCreateProduct(t, s, "Rug", 90)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		o := mustCreateOrder(t, s, u.ID, p, 1)
+
+		if ok, err := s.HasDeliveredOrder(ctx, u.ID, p.ID); err != nil || ok {
+			t.Errorf("HasDeliveredOrder before delivery = %v, %v", ok, err)
+		}
+		for _, to := range []storer.OrderStatus{storer.OrderPaid, storer.OrderProcessing, storer.OrderShipped, storer.OrderDelivered} {
+			if _, err := s.UpdateOrderStatus(ctx, o.ID, storer.StatusChange{To: to}); err != nil {
+				t.Fatalf("UpdateOrderStatus(%s): %v", to, err)
+			}
+		}
+		if ok, err := s.HasDeliveredOrder(ctx, u.ID, p.ID); err != nil || !ok {
+			t.Errorf("HasDeliveredOrder = %v, %v, want true", ok, err)
+		}
+		if ok, _ := s.HasDeliveredOrder(ctx, u.ID, other.ID); ok {
+			t.Error("HasDeliveredOrder for a product not in the order = true")
+		}
+		if ok, _ := s.HasDeliveredOrder(ctx, u.ID+1, p.ID); ok {
+			t.Error("HasDeliveredOrder for another user = true")
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package storertest
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testSearch(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	seed := func(t *testing.T) (storer.Store, map[string]*storer.Product) {
+		s := newStore(t)
+		products := map[string]*storer.Product{}
+		for _, p := range []struct{ name, category, description string }{
+			{"Mechanical Keyboard", "Electronics", "Hot-swappable switches and RGB lighting."},
+			{"Wrist Rest", "Accessories", "Memory foam rest that fits any keyboard."},
+			{"Gaming Mouse", "Electronics", "Lightweight mouse with a 26k DPI sensor."},
+			{"Desk Lamp", "Home", "Warm LED lamp for late nights."},
+			{"Coffee Mug", "Kitchen", "Ceramic mug, dishwasher safe."},
+		} {
+			created := mustCreateProduct(t, s, p.name, 20)
+			created.Category = p.category
+			created.Description = p.description
+			if _, err := s.UpdateProduct(ctx, created); err != nil {
+				t.Fatalf("UpdateProduct: %v", err)
+			}
+			products[p.name] = created
+		}
+		return s, products
+	}
+
+	t.Run("Ranks name matches first", func(t *testing.T) {
+		s, products := seed(t)
+		res, err := s.SearchProducts(ctx, storer.ProductSearchQuery{Query: "keyboard"})
+		if err != nil {
+			t.Fatalf("SearchProducts: %v", err)
+		}
+		if res.Total != 2 || len(res.Hits) != 2 {
+			t.Fatalf("got total %d with %d hits, want 2", res.Total, len(res.Hits))
+		}
+		if res.Hits[0].Product.ID != products["Mechanical Keyboard"].ID {
+			t.Errorf("top hit = %q, want Mechanical Keyboard", res.Hits[0].Product.Name)
+		}
+		if res.Hits[0].Score < res.Hits[1].Score {
+			t.Errorf("hits not ordered by score: %v < %v", res.Hits[0].Score, res.Hits[1].Score)
+		}
+	})
+
+	t.Run("Tolerates typos", func(t *testing.T) {
+		s, products := seed(t)
+		res, err := s.SearchProducts(ctx, storer.ProductSearchQuery{Query: "keybord"})
+		if err != nil {
+			t.Fatalf("SearchProducts: %v", err)
+		}
+		if len(res.Hits) == 0 || res.Hits[0].Product.ID != products["Mechanical Keyboard"].ID {
+			t.Fatalf("typo did not find Mechanical Keyboard: %+v", res.Hits)
+		}
+	})
+
+	t.Run("Highlights matches", func(t *testing.T) {
+		s, _ := seed(t)
+		res, err := s.SearchProducts(ctx, storer.ProductSearchQuery{Query: "keyboard"})
+		if err != nil {
+			t.Fatalf("SearchProducts: %v", err)
+		}
+		if got := res.Hits[0].Highlights["name"]; got != "Mechanical <mark>Keyboard</mark>" {
+			t.Errorf("name highlight = %q", got)
+		}
+		if got := res.Hits[1].Highlights["description"]; !strings.Contains(got, "<mark>keyboard</mark>") {
+			t.Errorf("description highlight = %q", got)
+		}
+	})
+
+	t.Run("Pages", func(t *testing.T) {
+		s, _ := seed(t)
+		first, err := s.SearchProducts(ctx, storer.ProductSearchQuery{Query: "keyboard", Limit: 1})
+		if err != nil {
+			t.Fatalf("SearchProducts: %v", err)
+		}
+		second, err := s.SearchProducts(ctx, storer.ProductSearchQuery{Query: "keyboard", Limit: 1, Page: 2})
+		if err != nil {
+			t.Fatalf("SearchProducts: %v", err)
+		}
+		if len(first.Hits) != 1 || len(second.Hits) != 1 || first.Total != 2 {
+			t.Fatalf("got %d and %d hits, total %d", len(first.Hits), len(second.Hits), first.Total)
+		}
+		if first.Hits[0].Product.ID == second.Hits[0].Product.ID {
+			t.Errorf("page 2 repeats page 1")
+		}
+	})
+
+	t.Run("Follows writes", func(t *testing.T) {
+		s, products := seed(t)
+		// search once so lazily built indexes exist before the writes
+		if _, err := s.SearchProducts(ctx, storer.ProductSearchQuery{Query: "lamp"}); err != nil {
+			t.Fatalf("SearchProducts: %v", err)
+		}
+		lamp := products["Desk Lamp"]
+		lamp.Name = "Reading Light"
+		lamp.Description = "Warm LED light."
+		if _, err := s.UpdateProduct(ctx, lamp); err != nil {
+			t.Fatalf("UpdateProduct: %v", err)
+		}
+		if err := s.DeleteProduct(ctx, products["Coffee Mug"].ID); err != nil {
+			t.Fatalf("DeleteProduct: %v", err)
+		}
+		for query, want := range map[string]int64{"lamp": 0, "reading": 1, "mug": 0} {
+			res, err := s.SearchProducts(ctx, storer.ProductSearchQuery{Query: query})
+			if err != nil {
+				t.Fatalf("SearchProducts(%q): %v", query, err)
+			}
+			if res.Total != want {
+				t.Errorf("SearchProducts(%q) total = %d, want %d", query, res.Total, want)
+			}
+		}
+	})
+
+	t.Run("Invalid queries", func(t *testing.T) {
+		s := newStore(t)
+		for _, q := range []storer.ProductSearchQuery{
+			{Query: ""},
+			{Query: "  ?! "},
+			{Query: "mouse", Limit: storer.MaxPageSize + 1},
+		} {
+			if _, err := s.SearchProducts(ctx, q); !errors.Is(err, storer.ErrInvalidQuery) {
+				t.Errorf("SearchProducts(%+v) error = %v, want ErrInvalidQuery", q, err)
+			}
+		}
+	})
+}
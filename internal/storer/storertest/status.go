@@ -0,0 +1,177 @@
+package storertest
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"testing"
+)
+
+func testOrderStatus(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	change := func(t *testing.T, s storer.Store, id uint, to storer.OrderStatus, by uint) *storer.Order {
+		t.Helper()
+		o, err := s.UpdateOrderStatus(ctx, id, storer.StatusChange{To: to, ChangedBy: &by, Note: "to " + string(to)})
+		if err != nil {
+			t.Fatalf("UpdateOrderStatus(%s): %v", to, err)
+		}
+		return o
+	}
+	stockOf := func(t *testing.T, s storer.Store, id uint) int {
+		t.Helper()
+		p, err := s.GetProduct(ctx, id)
+		if err != nil {
+			t.Fatalf("GetProduct: %v", err)
+		}
+		return p.CountInStock
+	}
+
+	t.Run("Lifecycle and timeline", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		admin := mustCreateUser(t, s, "admin@example.com", true)
+		p := mustCreateProduct(t, s, "Laptop", 999)
+		o := mustCreateOrder(t, s, u.ID, p, 1)
+		if o.Status != storer.OrderPending {
+			t.Fatalf("new order status = %q, want pending", o.Status)
+		}
+
+		path := []storer.OrderStatus{storer.OrderPaid, storer.OrderProcessing, storer.OrderShipped, storer.OrderDelivered}
+		for _, to := range path {
+			got := change(t, s, o.ID, to, admin.ID)
+			if got.Status != to || len(got.Items) != 1 {
+				t.Fatalf("after change to %s got %+v", to, got)
+			}
+		}
+		got, err := s.GetOrderByID(ctx, o.ID)
+		if err != nil {
+			t.Fatalf("GetOrderByID: %v", err)
+		}
+		if got.Status != storer.OrderDelivered {
+			t.Errorf("stored status = %q, want delivered", got.Status)
+		}
+
+		events, err := s.ListOrderStatusEvents(ctx, o.ID)
+		if err != nil {
+			t.Fatalf("ListOrderStatusEvents: %v", err)
+		}
+		if len(events) != 5 {
+			t.Fatalf("expected 5 events, got %d", len(events))
+		}
+		if e := events[0]; e.FromStatus != "" || e.ToStatus != storer.OrderPending || e.ChangedBy == nil || *e.ChangedBy != u.ID {
+			t.Errorf("unexpected creation event: %+v", e)
+		}
+		from := storer.OrderPending
+		for i, to := range path {
+			e := events[i+1]
+			if e.FromStatus != from || e.ToStatus != to || e.ChangedBy == nil || *e.ChangedBy != admin.ID || e.Note != "to "+string(to) {
+				t.Errorf("event %d = %+v, want %s -> %s by %d", i+1, e, from, to, admin.ID)
+			}
+			from = to
+		}
+		if stockOf(t, s, p.ID) != 9 {
+			t.Errorf("delivered order changed stock")
+		}
+	})
+
+	t.Run("Rejects transitions outside the table", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		p := mustCreateProduct(t, s, "Laptop", 999)
+		o := mustCreateOrder(t, s, u.ID, p, 1)
+
+		for _, to := range []storer.OrderStatus{storer.OrderShipped, storer.OrderRefunded, storer.OrderPending, "lost"} {
+			_, err := s.UpdateOrderStatus(ctx, o.ID, storer.StatusChange{To: to})
+			if !errors.Is(err, storer.ErrInvalidTransition) {
+				t.Errorf("pending -> %s: expected ErrInvalidTransition, got %v", to, err)
+			}
+		}
+		change(t, s, o.ID, storer.OrderCancelled, u.ID)
+		if _, err := s.UpdateOrderStatus(ctx, o.ID, storer.StatusChange{To: storer.OrderPaid}); !errors.Is(err, storer.ErrInvalidTransition) {
+			t.Errorf("cancelled -> paid: expected ErrInvalidTransition, got %v", err)
+		}
+		events, err := s.ListOrderStatusEvents(ctx, o.ID)
+		if err != nil {
+			t.Fatalf("ListOrderStatusEvents: %v", err)
+		}
+		if len(events) != 2 {
+			t.Errorf("rejected transitions were recorded: %+v", events)
+		}
+
+		// a paid order is refunded, not cancelled
+		paid := mustCreateOrder(t, s, u.ID, p, 1)
+		change(t, s, paid.ID, storer.OrderPaid, u.ID)
+		for _, from := range []storer.OrderStatus{storer.OrderPaid, storer.OrderProcessing} {
+			if from != storer.OrderPaid {
+				change(t, s, paid.ID, from, u.ID)
+			}
+			if _, err := s.UpdateOrderStatus(ctx, paid.ID, storer.StatusChange{To: storer.OrderCancelled}); !errors.Is(err, storer.ErrInvalidTransition) {
+				t.Errorf("%s -> cancelled: expected ErrInvalidTransition, got %v", from, err)
+			}
+		}
+	})
+
+	t.Run("Cancel and refund restore stock once", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		p := mustCreateProduct(t, s, "Laptop", 999)
+
+		cancelled := mustCreateOrder(t, s, u.ID, p, 2)
+		change(t, s, cancelled.ID, storer.OrderCancelled, u.ID)
+		if got := stockOf(t, s, p.ID); got != 10 {
+			t.Errorf("stock after cancel = %d, want 10", got)
+		}
+		if err := s.DeleteOrder(ctx, cancelled.ID); err != nil {
+			t.Fatalf("DeleteOrder: %v", err)
+		}
+		if got := stockOf(t, s, p.ID); got != 10 {
+			t.Errorf("stock after deleting cancelled order = %d, want 10", got)
+		}
+
+		refunded := mustCreateOrder(t, s, u.ID, p, 3)
+		change(t, s, refunded.ID, storer.OrderPaid, u.ID)
+		change(t, s, refunded.ID, storer.OrderRefunded, u.ID)
+		if got := stockOf(t, s, p.ID); got != 10 {
+			t.Errorf("stock after refund before shipping = %d, want 10", got)
+		}
+
+		delivered := mustCreateOrder(t, s, u.ID, p, 4)
+		for _, to := range []storer.OrderStatus{storer.OrderPaid, storer.OrderProcessing, storer.OrderShipped, storer.OrderDelivered, storer.OrderRefunded} {
+			change(t, s, delivered.ID, to, u.ID)
+		}
+		if got := stockOf(t, s, p.ID); got != 6 {
+			t.Errorf("stock after refunding a delivered order = %d, want 6", got)
+		}
+	})
+
+	t.Run("Filter by status", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		p := mustCreateProduct(t, s, "Laptop", 999)
+		mustCreateOrder(t, s, u.ID, p, 1)
+		paid := mustCreateOrder(t, s, u.ID, p, 1)
+		change(t, s, paid.ID, storer.OrderPaid, u.ID)
+
+		page, err := s.ListOrders(ctx, storer.OrderQuery{OrderFilter: storer.OrderFilter{Status: storer.OrderPaid}})
+		if err != nil {
+			t.Fatalf("ListOrders: %v", err)
+		}
+		if page.Total != 1 || page.Items[0].ID != paid.ID {
+			t.Errorf("expected only order %d, got %+v", paid.ID, page.Items)
+		}
+	})
+
+	t.Run("Missing order", func(t *testing.T) {
+		s := newStore(t)
+		if _, err := s.UpdateOrderStatus(ctx, 999, storer.StatusChange{To: storer.OrderPaid}); !errors.Is(err, storer.ErrOrderNotFound) {
+			t.Errorf("UpdateOrderStatus: expected ErrOrderNotFound, got %v", err)
+		}
+		if _, err := s.ListOrderStatusEvents(ctx, 999); !errors.Is(err, storer.ErrOrderNotFound) {
+			t.Errorf("ListOrderStatusEvents: expected ErrOrderNotFound, got %v", err)
+		}
+		if _, err := s.GetOrderByID(ctx, 999); !errors.Is(err, storer.ErrOrderNotFound) {
+			t.Errorf("GetOrderByID: expected ErrOrderNotFound, got %v", err)
+		}
+	})
+}
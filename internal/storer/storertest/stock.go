@@ -0,0 +1,132 @@
+package storertest
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func testStock(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	stockOf := func(t *testing.T, s storer.Store, id uint) int {
+		t.Helper()
+		p, err := s.GetProduct(ctx, id)
+		if err != nil {
+			t.Fatalf("GetProduct: %v", err)
+		}
+		return p.CountInStock
+	}
+
+	t.Run("Order decrements and delete restores", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		p := mustCreateProduct(t, s, "Keyboard", 50)
+
+		o := mustCreateOrder(t, s, u.ID, p, 3)
+		if got := stockOf(t, s, p.ID); got != 7 {
+			t.Errorf("stock after order = %d, want 7", got)
+		}
+		if err := s.DeleteOrder(ctx, o.ID); err != nil {
+			t.Fatalf("DeleteOrder: %v", err)
+		}
+		if got := stockOf(t, s, p.ID); got != 10 {
+			t.Errorf("stock after delete = %d, want 10", got)
+		}
+	})
+
+	t.Run("Insufficient stock fails the whole order", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		a := mustCreateProduct(t, s, "Keyboard", 50)
+		b := mustCreateProduct(t, s, "Mouse", 20)
+		c := mustCreateProduct(t, s, "Monitor", 200)
+
+		_, err := s.CreateOrder(ctx, &storer.Order{
+			UserID:        &u.ID,
+			PaymentMethod: "PayPal",
+			Items: []storer.OrderItem{
+				{ProductID: a.ID, Name: a.Name, Image: a.Image, Price: a.Price, Quantity: 2},
+				{ProductID: b.ID, Name: b.Name, Image: b.Image, Price: b.Price, Quantity: 6},
+				{ProductID: c.ID, Name: c.Name, Image: c.Image, Price: c.Price, Quantity: 11},
+				// lines for the same product add up
+				{ProductID: b.ID, Name: b.Name, Image: b.Image, Price: b.Price, Quantity: 6},
+			},
+		})
+		if !errors.Is(err, storer.ErrInsufficientStock) {
+			t.Fatalf("expected ErrInsufficientStock, got %v", err)
+		}
+		var shortage *storer.InsufficientStockError
+		if !errors.As(err, &shortage) {
+			t.Fatalf("expected *InsufficientStockError, got %T", err)
+		}
+		want := []storer.StockShortage{
+			{ProductID: b.ID, Requested: 12, Available: 10},
+			{ProductID: c.ID, Requested: 11, Available: 10},
+		}
+		if len(shortage.Items) != 2 || shortage.Items[0] != want[0] || shortage.Items[1] != want[1] {
+			t.Errorf("shortages = %+v, want %+v", shortage.Items, want)
+		}
+		for _, p := range []*storer.Product{a, b, c} {
+			if got := stockOf(t, s, p.ID); got != 10 {
+				t.Errorf("stock of %s = %d, want 10 after rollback", p.Name, got)
+			}
+		}
+		page, err := s.ListOrders(ctx, storer.OrderQuery{})
+		if err != nil {
+			t.Fatalf("ListOrders: %v", err)
+		}
+		if len(page.Items) != 0 {
+			t.Errorf("expected no orders, got %d", len(page.Items))
+		}
+	})
+
+	t.Run("Concurrent orders never oversell", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		p := mustCreateProduct(t, s, "Limited Edition", 99)
+		p.CountInStock = 5
+		if _, err := s.UpdateProduct(ctx, p); err != nil {
+			t.Fatalf("UpdateProduct: %v", err)
+		}
+
+		const buyers = 20
+		var (
+			wg        sync.WaitGroup
+			mu        sync.Mutex
+			succeeded int
+			failures  []error
+		)
+		for i := 0; i < buyers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := s.CreateOrder(ctx, &storer.Order{
+					UserID:        &u.ID,
+					PaymentMethod: "PayPal",
+					Items:         []storer.OrderItem{{ProductID: p.ID, Name: p.Name, Image: p.Image, Price: p.Price, Quantity: 1}},
+				})
+				mu.Lock()
+				defer mu.Unlock()
+				if err == nil {
+					succeeded++
+				} else if !errors.Is(err, storer.ErrInsufficientStock) {
+					failures = append(failures, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		for _, err := range failures {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if succeeded != 5 {
+			t.Errorf("%d orders succeeded, want 5", succeeded)
+		}
+		if got := stockOf(t, s, p.ID); got != 0 {
+			t.Errorf("stock = %d, want 0", got)
+		}
+	})
+}
@@ -0,0 +1,509 @@
+// Package storertest is a backend-agnostic conformance suite for
+// storer.Store. A new backend is validated by calling Run from its own test
+// file with a factory that hands out empty stores.
+package storertest
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Factory returns an empty, ready to use Store. It is called once per
+// subtest so cases never see each other's rows.
+type Factory func(t *testing.T) storer.Store
+
+func Run(t *testing.T, newStore Factory) {
+	t.Run("Products", func(t *testing.T) { testProducts(t, newStore) })
+	t.Run("Orders", func(t *testing.T) { testOrders(t, newStore) })
+	t.Run("Users", func(t *testing.T) { testUsers(t, newStore) })
+	t.Run("Sessions", func(t *testing.T) { testSessions(t, newStore) })
+	t.Run("OrderRollback", func(t *testing.T) { testOrderRollback(t, newStore) })
+	t.Run("Pagination", func(t *testing.T) { testPagination(t, newStore) })
+	t.Run("Search", func(t *testing.T) { testSearch(t, newStore) })
+	t.Run("Facets", func(t *testing.T) { testFacets(t, newStore) })
+	t.Run("Stock", func(t *testing.T) { testStock(t, newStore) })
+	t.Run("OrderStatus", func(t *testing.T) { testOrderStatus(t, newStore) })
+	t.Run("Carts", func(t *testing.T) { testCarts(t, newStore) })
+	t.Run("GuestOrders", func(t *testing.T) { testGuestOrders(t, newStore) })
+	t.Run("Payments", func(t *testing.T) { testPayments(t, newStore) })
+	t.Run("Returns", func(t *testing.T) { testReturns(t, newStore) })
+	t.Run("Addresses", func(t *testing.T) { testAddresses(t, newStore) })
+	t.Run("IdempotencyKeys", func(t *testing.T) { testIdempotencyKeys(t, newStore) })
+	t.Run("Promotions", func(t *testing.T) { testPromotions(t, newStore) })
+	t.Run("Variants", func(t *testing.T) { testVariants(t, newStore) })
+	t.Run("ProductImages", func(t *testing.T) { testProductImages(t, newStore) })
+	t.Run("Categories", func(t *testing.T) { testCategories(t, newStore) })
+	t.Run("Reviews", func(t *testing.T) { testReviews(t, newStore) })
+}
+
+func testProducts(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Create and get", func(t *testing.T) {
+		s := newStore(t)
+		p := mustCreateProduct(t, s, "Keyboard", 49.99)
+		if p.ID == 0 {
+			t.Fatal("expected product ID to be set")
+		}
+		if p.CreatedAt.IsZero() {
+			t.Error("expected CreatedAt to be set")
+		}
+
+		got, err := s.GetProduct(ctx, p.ID)
+		if err != nil {
+			t.Fatalf("GetProduct: %v", err)
+		}
+		if got.Name != "Keyboard" || got.Price != 49.99 || got.CountInStock != 10 || !got.IsActive {
+			t.Errorf("unexpected product: %+v", got)
+		}
+	})
+
+	t.Run("Get many", func(t *testing.T) {
+		s := newStore(t)
+		a := mustCreateProduct(t, s, "Mouse", 19.99)
+		b := mustCreateProduct(t, s, "Monitor", 199.00)
+		b.IsActive = false
+		if _, err := s.UpdateProduct(ctx, b); err != nil {
+			t.Fatalf("UpdateProduct: %v", err)
+		}
+
+		got, err := s.GetProducts(ctx, []uint{b.ID, 999, a.ID, b.ID})
+		if err != nil {
+			t.Fatalf("GetProducts: %v", err)
+		}
+		if len(got) != 2 || got[0].ID != a.ID || got[1].ID != b.ID {
+			t.Fatalf("expected products %d and %d, got %+v", a.ID, b.ID, got)
+		}
+		if !got[0].IsActive || got[1].IsActive {
+			t.Errorf("IsActive not persisted: %+v", got)
+		}
+		if got, err := s.GetProducts(ctx, nil); err != nil || len(got) != 0 {
+			t.Errorf("GetProducts(nil) = %v, %v", got, err)
+		}
+	})
+
+	t.Run("Get missing", func(t *testing.T) {
+		s := newStore(t)
+		_, err := s.GetProduct(ctx, 999)
+		if !errors.Is(err, storer.ErrProductNotFound) {
+			t.Errorf("expected ErrProductNotFound, got %v", err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		s := newStore(t)
+		a := mustCreateProduct(t, s, "Mouse", 19.99)
+		b := mustCreateProduct(t, s, "Monitor", 199.00)
+
+		page, err := s.ListProducts(ctx, storer.ProductQuery{})
+		if err != nil {
+			t.Fatalf("ListProducts: %v", err)
+		}
+		products := page.Items
+		if len(products) != 2 {
+			t.Fatalf("expected 2 products, got %d", len(products))
+		}
+		if products[0].ID != a.ID || products[1].ID != b.ID {
+			t.Errorf("expected products ordered by ID, got %d, %d", products[0].ID, products[1].ID)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		s := newStore(t)
+		p := mustCreateProduct(t, s, "Headset", 59.00)
+		p.Name = "Wireless Headset"
+		p.Price = 79.50
+		p.CountInStock = 0
+		p.Weight, p.Length, p.Width, p.Height = 0.35, 20, 18, 9.5
+		if _, err := s.UpdateProduct(ctx, p); err != nil {
+			t.Fatalf("UpdateProduct: %v", err)
+		}
+
+		got, err := s.GetProduct(ctx, p.ID)
+		if err != nil {
+			t.Fatalf("GetProduct: %v", err)
+		}
+		if got.Name != "Wireless Headset" || got.Price != 79.50 || got.CountInStock != 0 ||
+			got.Weight != 0.35 || got.Length != 20 || got.Width != 18 || got.Height != 9.5 {
+			t.Errorf("update not persisted: %+v", got)
+		}
+	})
+
+	t.Run("Update missing", func(t *testing.T) {
+		s := newStore(t)
+		_, err := s.UpdateProduct(ctx, &storer.Product{ID: 999, Name: "Ghost", Price: 1})
+		if !errors.Is(err, storer.ErrProductNotFound) {
+			t.Errorf("expected ErrProductNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		s := newStore(t)
+		p := mustCreateProduct(t, s, "Webcam", 35.00)
+		if err := s.DeleteProduct(ctx, p.ID); err != nil {
+			t.Fatalf("DeleteProduct: %v", err)
+		}
+		if _, err := s.GetProduct(ctx, p.ID); !errors.Is(err, storer.ErrProductNotFound) {
+			t.Errorf("expected ErrProductNotFound after delete, got %v", err)
+		}
+		if err := s.DeleteProduct(ctx, p.ID); !errors.Is(err, storer.ErrProductNotFound) {
+			t.Errorf("expected ErrProductNotFound deleting twice, got %v", err)
+		}
+	})
+}
+
+func testOrders(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Create with items", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		p := mustCreateProduct(t, s, "Laptop", 999.99)
+
+		o := mustCreateOrder(t, s, u.ID, p, 2)
+		if o.ID == 0 {
+			t.Fatal("expected order ID to be set")
+		}
+		for _, item := range o.Items {
+			if item.ID == 0 || item.OrderID != o.ID {
+				t.Errorf("order item not linked to order: %+v", item)
+			}
+		}
+
+		got, err := s.GetOrderByID(ctx, o.ID)
+		if err != nil {
+			t.Fatalf("GetOrderByID: %v", err)
+		}
+		if got.ID != o.ID {
+			t.Errorf("expected order %d, got %d", o.ID, got.ID)
+		}
+		if len(got.Items) != 1 || got.Items[0].Quantity != 2 || got.Items[0].ProductID != p.ID {
+			t.Errorf("unexpected order items: %+v", got.Items)
+		}
+		if got.TotalPrice != o.TotalPrice || got.PaymentMethod != "PayPal" {
+			t.Errorf("unexpected order: %+v", got)
+		}
+	})
+
+	t.Run("Tax", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		p := mustCreateProduct(t, s, "Rice", 11.10)
+		breakdown := storer.TaxBreakdown{Country: "ID", Inclusive: true, Lines: []storer.TaxLine{{Category: "standard", Rate: 0.11, Taxable: 20, Tax: 2.2}}}
+		o, err := s.CreateOrder(ctx, &storer.Order{
+			PaymentMethod: "PayPal",
+			UserID:        &u.ID,
+			ItemsPrice:    22.2,
+			TaxPrice:      2.2,
+			TotalPrice:    22.2,
+			TaxBreakdown:  breakdown,
+			Items:         []storer.OrderItem{{Name: p.Name, Price: p.Price, Quantity: 2, Tax: 2.2, ProductID: p.ID}},
+		})
+		if err != nil {
+			t.Fatalf("CreateOrder: %v", err)
+		}
+
+		got, err := s.GetOrderByID(ctx, o.ID)
+		if err != nil {
+			t.Fatalf("GetOrderByID: %v", err)
+		}
+		if got.Items[0].Tax != 2.2 {
+			t.Errorf("item tax = %v, want 2.2", got.Items[0].Tax)
+		}
+		b := got.TaxBreakdown
+		if b.Country != "ID" || !b.Inclusive || len(b.Lines) != 1 || b.Lines[0] != breakdown.Lines[0] {
+			t.Errorf("tax breakdown = %+v, want %+v", b, breakdown)
+		}
+	})
+
+	t.Run("Get missing", func(t *testing.T) {
+		s := newStore(t)
+		_, err := s.GetOrderByID(ctx, 999)
+		if !errors.Is(err, storer.ErrOrderNotFound) {
+			t.Errorf("expected ErrOrderNotFound, got %v", err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		p := mustCreateProduct(t, s, "Tablet", 300.00)
+		mustCreateOrder(t, s, u.ID, p, 1)
+		mustCreateOrder(t, s, u.ID, p, 3)
+
+		page, err := s.ListOrders(ctx, storer.OrderQuery{})
+		if err != nil {
+			t.Fatalf("ListOrders: %v", err)
+		}
+		if len(page.Items) != 2 {
+			t.Fatalf("expected 2 orders, got %d", len(page.Items))
+		}
+		for _, o := range page.Items {
+			if len(o.Items) != 1 {
+				t.Errorf("expected items to be loaded for order %d", o.ID)
+			}
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		p := mustCreateProduct(t, s, "Phone", 499.00)
+		o := mustCreateOrder(t, s, u.ID, p, 1)
+
+		if err := s.DeleteOrder(ctx, o.ID); err != nil {
+			t.Fatalf("DeleteOrder: %v", err)
+		}
+		if _, err := s.GetOrderByID(ctx, o.ID); !errors.Is(err, storer.ErrOrderNotFound) {
+			t.Errorf("expected ErrOrderNotFound after delete, got %v", err)
+		}
+		if err := s.DeleteOrder(ctx, o.ID); !errors.Is(err, storer.ErrOrderNotFound) {
+			t.Errorf("expected ErrOrderNotFound deleting twice, got %v", err)
+		}
+	})
+
+	t.Run("Delete keeps paid orders", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		p := mustCreateProduct(t, s, "Phone", 499.00)
+		for _, statuses := range [][]storer.OrderStatus{
+			{storer.OrderPaid},
+			{storer.OrderPaid, storer.OrderProcessing, storer.OrderShipped},
+			{storer.OrderPaid, storer.OrderRefunded},
+		} {
+			o := mustCreateOrder(t, s, u.ID, p, 1)
+			for _, status := range statuses {
+				if _, err := s.UpdateOrderStatus(ctx, o.ID, storer.StatusChange{To: status}); err != nil {
+					t.Fatalf("UpdateOrderStatus(%s): %v", status, err)
+				}
+			}
+			if err := s.DeleteOrder(ctx, o.ID); !errors.Is(err, storer.ErrOrderNotDeletable) {
+				t.Errorf("%s order: expected ErrOrderNotDeletable, got %v", statuses[len(statuses)-1], err)
+			}
+			if _, err := s.GetOrderByID(ctx, o.ID); err != nil {
+				t.Errorf("%s order is gone: %v", statuses[len(statuses)-1], err)
+			}
+		}
+
+	})
+}
+
+func testUsers(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Create and get", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "alice@example.com", true)
+		if u.ID == 0 {
+			t.Fatal("expected user ID to be set")
+		}
+
+		got, err := s.GetUser(ctx, "alice@example.com")
+		if err != nil {
+			t.Fatalf("GetUser: %v", err)
+		}
+		if got.ID != u.ID || !got.IsAdmin || got.Password != u.Password {
+			t.Errorf("unexpected user: %+v", got)
+		}
+	})
+
+	t.Run("Duplicate email", func(t *testing.T) {
+		s := newStore(t)
+		mustCreateUser(t, s, "alice@example.com", false)
+		_, err := s.CreateUser(ctx, &storer.User{Name: "Alice 2", Email: "alice@example.com", Password: "x"})
+		if !errors.Is(err, storer.ErrUserAlreadyExists) {
+			t.Errorf("expected ErrUserAlreadyExists, got %v", err)
+		}
+	})
+
+	t.Run("Get missing", func(t *testing.T) {
+		s := newStore(t)
+		_, err := s.GetUser(ctx, "nobody@example.com")
+		if !errors.Is(err, storer.ErrUserNotFound) {
+			t.Errorf("expected ErrUserNotFound, got %v", err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		s := newStore(t)
+		mustCreateUser(t, s, "a@example.com", false)
+		mustCreateUser(t, s, "b@example.com", false)
+		page, err := s.ListUsers(ctx, storer.UserQuery{})
+		if err != nil {
+			t.Fatalf("ListUsers: %v", err)
+		}
+		if len(page.Items) != 2 || page.Total != 2 {
+			t.Errorf("expected 2 users, got %d (total %d)", len(page.Items), page.Total)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "bob@example.com", false)
+		u.Name = "Robert"
+		u.IsAdmin = true
+		if _, err := s.UpdateUser(ctx, u); err != nil {
+			t.Fatalf("UpdateUser: %v", err)
+		}
+		got, err := s.GetUser(ctx, "bob@example.com")
+		if err != nil {
+			t.Fatalf("GetUser: %v", err)
+		}
+		if got.Name != "Robert" || !got.IsAdmin {
+			t.Errorf("update not persisted: %+v", got)
+		}
+	})
+
+	t.Run("Update missing", func(t *testing.T) {
+		s := newStore(t)
+		_, err := s.UpdateUser(ctx, &storer.User{ID: 999, Name: "Ghost", Email: "ghost@example.com", Password: "x"})
+		if !errors.Is(err, storer.ErrUserNotFound) {
+			t.Errorf("expected ErrUserNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "carol@example.com", false)
+		if err := s.DeleteUser(ctx, u.ID); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		if err := s.DeleteUser(ctx, u.ID); !errors.Is(err, storer.ErrUserNotFound) {
+			t.Errorf("expected ErrUserNotFound deleting twice, got %v", err)
+		}
+	})
+}
+
+func testSessions(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	t.Run("Create, revoke and delete", func(t *testing.T) {
+		s := newStore(t)
+		se := &storer.Session{
+			ID:           "session-1",
+			UserEmail:    "alice@example.com",
+			RefreshToken: "refresh-token",
+			ExpiresAt:    time.Now().Add(time.Hour),
+		}
+		if _, err := s.CreateSession(ctx, se); err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+
+		got, err := s.GetSession(ctx, "session-1")
+		if err != nil {
+			t.Fatalf("GetSession: %v", err)
+		}
+		if got.UserEmail != se.UserEmail || got.RefreshToken != se.RefreshToken || got.IsRevoked {
+			t.Errorf("unexpected session: %+v", got)
+		}
+
+		if err := s.RevokeSession(ctx, "session-1"); err != nil {
+			t.Fatalf("RevokeSession: %v", err)
+		}
+		got, err = s.GetSession(ctx, "session-1")
+		if err != nil {
+			t.Fatalf("GetSession: %v", err)
+		}
+		if !got.IsRevoked {
+			t.Error("expected session to be revoked")
+		}
+
+		if err := s.DeleteSession(ctx, "session-1"); err != nil {
+			t.Fatalf("DeleteSession: %v", err)
+		}
+		if _, err := s.GetSession(ctx, "session-1"); !errors.Is(err, storer.ErrSessionNotFound) {
+			t.Errorf("expected ErrSessionNotFound after delete, got %v", err)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		s := newStore(t)
+		if err := s.RevokeSession(ctx, "nope"); !errors.Is(err, storer.ErrSessionNotFound) {
+			t.Errorf("RevokeSession: expected ErrSessionNotFound, got %v", err)
+		}
+		if err := s.DeleteSession(ctx, "nope"); !errors.Is(err, storer.ErrSessionNotFound) {
+			t.Errorf("DeleteSession: expected ErrSessionNotFound, got %v", err)
+		}
+	})
+}
+
+func testOrderRollback(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+	s := newStore(t)
+	u := mustCreateUser(t, s, "buyer@example.com", false)
+	p := mustCreateProduct(t, s, "Camera", 650.00)
+
+	_, err := s.CreateOrder(ctx, &storer.Order{
+		PaymentMethod: "Stripe",
+		TotalPrice:    650.00,
+		UserID:        &u.ID,
+		Items: []storer.OrderItem{
+			{Name: p.Name, Image: p.Image, Price: p.Price, Quantity: 1, ProductID: p.ID},
+			{Name: "Ghost", Image: p.Image, Price: 1, Quantity: 1, ProductID: p.ID + 1000},
+		},
+	})
+	if !errors.Is(err, storer.ErrProductNotFound) {
+		t.Fatalf("expected ErrProductNotFound, got %v", err)
+	}
+
+	page, err := s.ListOrders(ctx, storer.OrderQuery{})
+	if err != nil {
+		t.Fatalf("ListOrders: %v", err)
+	}
+	if page.Total != 0 {
+		t.Errorf("expected failed order to be rolled back, found %d orders", page.Total)
+	}
+}
+
+func mustCreateProduct(t *testing.T, s storer.Store, name string, price float64) *storer.Product {
+	t.Helper()
+	p, err := s.CreateProduct(context.Background(), &storer.Product{
+		Name:         name,
+		Image:        "https://example.com/" + name + ".jpg",
+		Category:     "Electronics",
+		Description:  name + " description",
+		Rating:       4,
+		NumReviews:   3,
+		Price:        price,
+		CountInStock: 10,
+		IsActive:     true,
+	})
+	if err != nil {
+		t.Fatalf("CreateProduct: %v", err)
+	}
+	return p
+}
+
+func mustCreateUser(t *testing.T, s storer.Store, email string, isAdmin bool) *storer.User {
+	t.Helper()
+	u, err := s.CreateUser(context.Background(), &storer.User{
+		Name:     "Test User",
+		Email:    email,
+		Password: "$2a$12$LQv3c1yqBWVHxkd0LHAkCOYz6TtxMQJqhN8/LewdBPj/VcSAg/9qm",
+		IsAdmin:  isAdmin,
+	})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return u
+}
+
+func mustCreateOrder(t *testing.T, s storer.Store, userID uint, p *storer.Product, qty int) *storer.Order {
+	t.Helper()
+	o, err := s.CreateOrder(context.Background(), &storer.Order{
+		PaymentMethod: "PayPal",
+		ShippingPrice: 5.00,
+		TotalPrice:    p.Price*float64(qty) + 5.00,
+		UserID:        &userID,
+		Items: []storer.OrderItem{
+			{Name: p.Name, Image: p.Image, Price: p.Price, Quantity: qty, ProductID: p.ID},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	return o
+}
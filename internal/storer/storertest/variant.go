@@ -0,0 +1,227 @@
+package storertest
+
+import (
+	"context"
+	"ecom_apiv1/internal/storer"
+	"errors"
+	"testing"
+)
+
+func testVariants(t *testing.T, newStore Factory) {
+	ctx := context.Background()
+
+	newShirt := func(t *testing.T, s storer.Store) *storer.Product {
+		t.Helper()
+		p, err := s.CreateProduct(ctx, &storer.Product{
+			Name:     "Shirt",
+			Image:    "https://example.com/shirt.jpg",
+			Category: "Apparel",
+			Price:    20,
+			IsActive: true,
+			Options: storer.ProductOptions{
+				{Name: "Size", Values: []string{"S", "M"}},
+				{Name: "Color", Values: []string{"Red"}},
+			},
+		})
+		if err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+		return p
+	}
+	newVariant := func(t *testing.T, s storer.Store, productID uint, sku, size string, stock int) *storer.ProductVariant {
+		t.Helper()
+		v, err := s.CreateVariant(ctx, &storer.ProductVariant{
+			ProductID:    productID,
+			SKU:          sku,
+			Options:      storer.VariantOptions{"Size": size, "Color": "Red"},
+			CountInStock: stock,
+			IsActive:     true,
+		})
+		if err != nil {
+			t.Fatalf("CreateVariant(%s): %v", sku, err)
+		}
+		return v
+	}
+	stockOf := func(t *testing.T, s storer.Store, p *storer.Product, v *storer.ProductVariant) (int, int) {
+		t.Helper()
+		gotP, err := s.GetProduct(ctx, p.ID)
+		if err != nil {
+			t.Fatalf("GetProduct: %v", err)
+		}
+		gotV, err := s.GetVariant(ctx, v.ID)
+		if err != nil {
+			t.Fatalf("GetVariant: %v", err)
+		}
+		return gotP.CountInStock, gotV.CountInStock
+	}
+
+	t.Run("Create, update and delete", func(t *testing.T) {
+		s := newStore(t)
+		p := newShirt(t, s)
+		if got, err := s.GetProduct(ctx, p.ID); err != nil || len(got.Options) != 2 || got.Options[0].Values[1] != "M" {
+			t.Fatalf("GetProduct options = %+v, %v", got, err)
+		}
+
+		small := newVariant(t, s, p.ID, "SHIRT-S-RED", "S", 3)
+		price := 25.0
+		medium, err := s.CreateVariant(ctx, &storer.ProductVariant{
+			ProductID:    p.ID,
+			SKU:          "SHIRT-M-RED",
+			Options:      storer.VariantOptions{"Size": "M", "Color": "Red"},
+			Price:        &price,
+			CountInStock: 4,
+			Image:        "https://example.com/shirt-m.jpg",
+			IsActive:     true,
+		})
+		if err != nil {
+			t.Fatalf("CreateVariant: %v", err)
+		}
+		if _, err := s.CreateVariant(ctx, &storer.ProductVariant{ProductID: p.ID, SKU: "SHIRT-S-RED"}); !errors.Is(err, storer.ErrSKUTaken) {
+			t.Errorf("duplicate SKU: expected ErrSKUTaken, got %v", err)
+		}
+		if _, err := s.CreateVariant(ctx, &storer.ProductVariant{ProductID: 9999, SKU: "NOPE"}); !errors.Is(err, storer.ErrProductNotFound) {
+			t.Errorf("expected ErrProductNotFound, got %v", err)
+		}
+		if got, _ := stockOf(t, s, p, small); got != 7 {
+			t.Errorf("product stock = %d, want the 7 of its variants", got)
+		}
+
+		got, err := s.GetVariant(ctx, medium.ID)
+		if err != nil || got.Price == nil || *got.Price != 25 || got.Options["Size"] != "M" || got.Image == "" {
+			t.Fatalf("GetVariant = %+v, %v", got, err)
+		}
+		if got.PriceOf(p) != 25 || small.PriceOf(p) != 20 || got.Label(p.Options) != "M / Red" {
+			t.Errorf("price %v / %v, label %q", got.PriceOf(p), small.PriceOf(p), got.Label(p.Options))
+		}
+		if list, err := s.ListVariants(ctx, p.ID); err != nil || len(list) != 2 || list[0].ID != small.ID {
+			t.Errorf("ListVariants = %+v, %v", list, err)
+		}
+		if list, err := s.GetVariants(ctx, []uint{medium.ID, 9999, small.ID}); err != nil || len(list) != 2 || list[1].ID != medium.ID {
+			t.Errorf("GetVariants = %+v, %v", list, err)
+		}
+
+		small.CountInStock = 5
+		small.Price = &price
+		if _, err := s.UpdateVariant(ctx, small); err != nil {
+			t.Fatalf("UpdateVariant: %v", err)
+		}
+		if gotP, gotV := stockOf(t, s, p, small); gotP != 9 || gotV != 5 {
+			t.Errorf("stock after update = %d / %d, want 9 / 5", gotP, gotV)
+		}
+		small.SKU = medium.SKU
+		if _, err := s.UpdateVariant(ctx, small); !errors.Is(err, storer.ErrSKUTaken) {
+			t.Errorf("expected ErrSKUTaken, got %v", err)
+		}
+		if _, err := s.UpdateVariant(ctx, &storer.ProductVariant{ID: 9999, SKU: "NOPE"}); !errors.Is(err, storer.ErrVariantNotFound) {
+			t.Errorf("expected ErrVariantNotFound, got %v", err)
+		}
+
+		if err := s.DeleteVariant(ctx, medium.ID); err != nil {
+			t.Fatalf("DeleteVariant: %v", err)
+		}
+		if _, err := s.GetVariant(ctx, medium.ID); !errors.Is(err, storer.ErrVariantNotFound) {
+			t.Errorf("expected ErrVariantNotFound, got %v", err)
+		}
+		if got, _ := stockOf(t, s, p, small); got != 5 {
+			t.Errorf("product stock after delete = %d, want 5", got)
+		}
+	})
+
+	t.Run("Orders take variant stock", func(t *testing.T) {
+		s := newStore(t)
+		u := mustCreateUser(t, s, "buyer@example.com", false)
+		p := newShirt(t, s)
+		small := newVariant(t, s, p.ID, "SHIRT-S-RED", "S", 3)
+		medium := newVariant(t, s, p.ID, "SHIRT-M-RED", "M", 4)
+		other := mustCreateProduct(t, s, "Keyboard", 50)
+
+		item := func(v *storer.ProductVariant, qty int) storer.OrderItem {
+			return storer.OrderItem{ProductID: p.ID, VariantID: &v.ID, SKU: v.SKU, Name: "Shirt (" + v.Label(p.Options) + ")", Price: 20, Quantity: qty}
+		}
+		_, err := s.CreateOrder(ctx, &storer.Order{
+			UserID:        &u.ID,
+			PaymentMethod: "PayPal",
+			Items:         []storer.OrderItem{{ProductID: other.ID, VariantID: &small.ID, Name: "Keyboard", Price: 50, Quantity: 1}},
+		})
+		if !errors.Is(err, storer.ErrVariantNotFound) {
+			t.Errorf("variant of another product: expected ErrVariantNotFound, got %v", err)
+		}
+
+		_, err = s.CreateOrder(ctx, &storer.Order{
+			UserID:        &u.ID,
+			PaymentMethod: "PayPal",
+			Items:         []storer.OrderItem{item(small, 2), item(medium, 1), item(small, 2)},
+		})
+		var shortage *storer.InsufficientStockError
+		if !errors.As(err, &shortage) {
+			t.Fatalf("expected *InsufficientStockError, got %v", err)
+		}
+		want := storer.StockShortage{ProductID: p.ID, VariantID: small.ID, Requested: 4, Available: 3}
+		if len(shortage.Items) != 1 || shortage.Items[0] != want {
+			t.Errorf("shortages = %+v, want %+v", shortage.Items, want)
+		}
+		if gotP, gotV := stockOf(t, s, p, medium); gotP != 7 || gotV != 4 {
+			t.Errorf("stock after rollback = %d / %d, want 7 / 4", gotP, gotV)
+		}
+
+		o, err := s.CreateOrder(ctx, &storer.Order{
+			UserID:        &u.ID,
+			PaymentMethod: "PayPal",
+			Items:         []storer.OrderItem{item(small, 2), item(medium, 1)},
+		})
+		if err != nil {
+			t.Fatalf("CreateOrder: %v", err)
+		}
+		if gotP, gotV := stockOf(t, s, p, small); gotP != 4 || gotV != 1 {
+			t.Errorf("stock after order = %d / %d, want 4 / 1", gotP, gotV)
+		}
+		got, err := s.GetOrderByID(ctx, o.ID)
+		if err != nil {
+			t.Fatalf("GetOrderByID: %v", err)
+		}
+		if it := got.Items[0]; it.VariantID == nil || *it.VariantID != small.ID || it.SKU != "SHIRT-S-RED" || it.Name != "Shirt (S / Red)" {
+			t.Errorf("order item = %+v", it)
+		}
+
+		// cancelling the order would return the item to a variant that is gone
+		if err := s.DeleteVariant(ctx, medium.ID); !errors.Is(err, storer.ErrVariantInUse) {
+			t.Errorf("variant of an open order: expected ErrVariantInUse, got %v", err)
+		}
+		if _, err := s.GetVariant(ctx, medium.ID); err != nil {
+			t.Errorf("variant after a refused delete: %v", err)
+		}
+		for _, to := range []storer.OrderStatus{storer.OrderPaid, storer.OrderProcessing, storer.OrderShipped} {
+			if _, err := s.UpdateOrderStatus(ctx, o.ID, storer.StatusChange{To: to}); err != nil {
+				t.Fatalf("UpdateOrderStatus(%s): %v", to, err)
+			}
+		}
+		if err := s.DeleteVariant(ctx, medium.ID); err != nil {
+			t.Fatalf("DeleteVariant once shipped: %v", err)
+		}
+		got, err = s.GetOrderByID(ctx, o.ID)
+		if err != nil {
+			t.Fatalf("GetOrderByID: %v", err)
+		}
+		if it := got.Items[1]; it.VariantID != nil || it.SKU != "SHIRT-M-RED" {
+			t.Errorf("order item of a deleted variant = %+v, want the SKU kept and no variant", it)
+		}
+		if gotP, gotV := stockOf(t, s, p, small); gotP != 1 || gotV != 1 {
+			t.Errorf("stock after delete = %d / %d, want 1 / 1", gotP, gotV)
+		}
+
+		// the stock of a cancelled order is back in the variant already
+		cancelled, err := s.CreateOrder(ctx, &storer.Order{UserID: &u.ID, PaymentMethod: "PayPal", Items: []storer.OrderItem{item(small, 1)}})
+		if err != nil {
+			t.Fatalf("CreateOrder: %v", err)
+		}
+		if _, err := s.UpdateOrderStatus(ctx, cancelled.ID, storer.StatusChange{To: storer.OrderCancelled}); err != nil {
+			t.Fatalf("UpdateOrderStatus(cancelled): %v", err)
+		}
+		if err := s.DeleteVariant(ctx, small.ID); err != nil {
+			t.Fatalf("DeleteVariant of a cancelled order's variant: %v", err)
+		}
+		if got, _ := s.GetProduct(ctx, p.ID); got.CountInStock != 0 {
+			t.Errorf("product stock without variants = %d, want 0", got.CountInStock)
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package storer
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// TaxBreakdown records how an order was taxed when it was placed, stored as
+// JSON next to the order for tax reporting.
+type TaxBreakdown struct {
+	// Country is the jurisdiction, empty when the default tax rate applied.
+	Country string `json:"country,omitempty"`
+	// Inclusive is set when the tax is contained in the item prices.
+	Inclusive bool      `json:"inclusive"`
+	Lines     []TaxLine `json:"lines"`
+}
+
+// TaxLine is the tax of the order's items of one tax category.
+type TaxLine struct {
+	Category string  `json:"category"`
+	Rate     float64 `json:"rate"`
+	// Taxable is the price of the items without their tax.
+	Taxable float64 `json:"taxable"`
+	Tax     float64 `json:"tax"`
+}
+
+func (b TaxBreakdown) IsZero() bool {
+	return b.Country == "" && !b.Inclusive && len(b.Lines) == 0
+}
+
+func (b TaxBreakdown) Value() (driver.Value, error) {
+	if b.IsZero() {
+		return nil, nil
+	}
+	data, err := json.Marshal(b)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding tax breakdown: %w", err)
+	}
+	return string(data), nil
+}
+
+func (b *TaxBreakdown) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*b = TaxBreakdown{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into TaxBreakdown", src)
+	}
+	if err := json.Unmarshal(data, b); err != nil {
+		return fmt.Errorf("error decoding tax breakdown: %w", err)
+	}
+	return nil
+}
@@ -5,61 +5,143 @@ import (
 )
 
 type Product struct {
-	ID           uint `gorm:"primaryKey"`
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
-	Name         string  `gorm:"not null"`
-	Image        string  `gorm:"not null"`
-	Category     string  `gorm:"not null"`
-	Description  string  `gorm:"type:text"`
-	Rating       int     `gorm:"not null"`
-	NumReviews   int     `gorm:"not null;default:0"`
-	Price        float64 `gorm:"not null;type:decimal(10,2)"`
-	CountInStock int     `gorm:"not null"`
+	ID           uint      `gorm:"primaryKey" db:"id"`
+	CreatedAt    time.Time `db:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at"`
+	Name         string    `gorm:"not null" db:"name"`
+	Image        string    `gorm:"not null" db:"image"`
+	Category     string    `gorm:"not null" db:"category"`
+	CategoryID   *uint     `gorm:"index" db:"category_id"`
+	Description  string    `gorm:"type:text" db:"description"`
+	Rating       float64   `gorm:"not null;default:0;type:decimal(3,2)" db:"rating"`
+	NumReviews   int       `gorm:"not null;default:0" db:"num_reviews"`
+	Price        float64   `gorm:"not null;type:decimal(10,2)" db:"price"`
+	CountInStock int       `gorm:"not null" db:"count_in_stock"`
+	// IsActive products are listed and can be ordered. There is no GORM
+	// default so that creating an inactive product stores false.
+	IsActive bool `gorm:"not null" db:"is_active"`
+	// Weight in kg and the packed dimensions in cm set what shipping the
+	// product costs; see shipping.BillableWeight.
+	Weight float64 `gorm:"not null;default:0;type:decimal(10,3)" db:"weight"`
+	Length float64 `gorm:"not null;default:0;type:decimal(10,2)" db:"length"`
+	Width  float64 `gorm:"not null;default:0;type:decimal(10,2)" db:"width"`
+	Height float64 `gorm:"not null;default:0;type:decimal(10,2)" db:"height"`
+	// TaxCategory picks the product's tax rate in each jurisdiction.
+	TaxCategory string `gorm:"not null;default:standard;type:varchar(32)" db:"tax_category"`
+	// Options are the axes the variants of the product differ in. The
+	// stock of a product with options is the sum of its variants' stock.
+	Options ProductOptions `gorm:"type:text" db:"options"`
 }
 
 type Order struct {
-	ID            uint `gorm:"primaryKey"`
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
-	PaymentMethod string      `gorm:"not null"`
-	TaxPrice      float64     `gorm:"not null;type:decimal(10,2)"`
-	ShippingPrice float64     `gorm:"not null;type:decimal(10,2)"`
-	TotalPrice    float64     `gorm:"not null;type:decimal(10,2)"`
-	UserID        uint        `gorm:"not null"`
-	User          User        `gorm:"foreignKey:UserID"`
-	Items         []OrderItem `gorm:"foreignKey:OrderID"`
+	ID            uint        `gorm:"primaryKey" db:"id"`
+	CreatedAt     time.Time   `db:"created_at"`
+	UpdatedAt     time.Time   `db:"updated_at"`
+	Status        OrderStatus `gorm:"not null" db:"status"`
+	PaymentMethod string      `gorm:"not null" db:"payment_method"`
+	ItemsPrice    float64     `gorm:"not null;type:decimal(10,2)" db:"items_price"`
+	TaxPrice      float64     `gorm:"not null;type:decimal(10,2)" db:"tax_price"`
+	ShippingPrice float64     `gorm:"not null;type:decimal(10,2)" db:"shipping_price"`
+	TotalPrice    float64     `gorm:"not null;type:decimal(10,2)" db:"total_price"`
+	// DiscountPrice is what the promotions in Discounts took off the items
+	// and the shipping.
+	DiscountPrice float64        `gorm:"not null;default:0;type:decimal(10,2)" db:"discount_price"`
+	Discounts     OrderDiscounts `gorm:"type:text" db:"discounts"`
+	// RefundedAmount is the running total of the order's pending and
+	// succeeded refunds.
+	RefundedAmount float64 `gorm:"not null;default:0;type:decimal(10,2)" db:"refunded_amount"`
+	// UserID is nil for guest orders until they are claimed.
+	UserID          *uint           `db:"user_id"`
+	User            User            `gorm:"foreignKey:UserID" db:"-"`
+	Email           string          `gorm:"not null" db:"email"`
+	ShippingAddress ShippingAddress `gorm:"type:text" db:"shipping_address"`
+	TaxBreakdown    TaxBreakdown    `gorm:"type:text" db:"tax_breakdown"`
+	// AccessTokenHash lets a guest follow the order without an account. It
+	// is cleared when the order is claimed.
+	AccessTokenHash *string     `gorm:"uniqueIndex;type:varchar(64)" db:"access_token_hash"`
+	Items           []OrderItem `gorm:"foreignKey:OrderID" db:"-"`
+	// CartID, when set, names the cart the order was checked out from.
+	// CreateOrder empties that cart in the same transaction.
+	CartID *uint `gorm:"-" db:"-"`
+	// AddressID, when set, names the saved address of the user the order
+	// ships to. It is only read at checkout; ShippingAddress keeps a copy.
+	AddressID *uint `gorm:"-" db:"-"`
+	// CouponCode is the coupon entered at checkout. Discounts records the
+	// promotion it redeemed.
+	CouponCode string `gorm:"-" db:"-"`
 }
 
 type OrderItem struct {
-	ID        uint `gorm:"primaryKey"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Name      string  `gorm:"not null"`
-	Quantity  int     `gorm:"not null"`
-	Image     string  `gorm:"not null"`
-	Price     float64 `gorm:"not null;type:decimal(10,2)"`
-	ProductID uint    `gorm:"not null"`
-	OrderID   uint    `gorm:"not null"`
-	Product   Product `gorm:"foreignKey:ProductID"`
-	Order     Order   `gorm:"foreignKey:OrderID"`
+	ID        uint      `gorm:"primaryKey" db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+	Name      string    `gorm:"not null" db:"name"`
+	Quantity  int       `gorm:"not null" db:"quantity"`
+	Image     string    `gorm:"not null" db:"image"`
+	Price     float64   `gorm:"not null;type:decimal(10,2)" db:"price"`
+	ProductID uint      `gorm:"not null" db:"product_id"`
+	OrderID   uint      `gorm:"not null" db:"order_id"`
+	Product   Product   `gorm:"foreignKey:ProductID" db:"-"`
+	Order     Order     `gorm:"foreignKey:OrderID" db:"-"`
+	// Tax is the tax of the whole line. In inclusive jurisdictions it is
+	// contained in Price.
+	Tax float64 `gorm:"not null;default:0;type:decimal(10,2)" db:"tax"`
+	// Discount is what promotions took off the whole line.
+	Discount float64 `gorm:"not null;default:0;type:decimal(10,2)" db:"discount"`
+	// VariantID is the variant ordered, for products sold by variant. SKU
+	// keeps its SKU should the variant be deleted.
+	VariantID *uint  `db:"variant_id"`
+	SKU       string `gorm:"not null;default:''" db:"sku"`
+}
+
+// Cart is a shopping cart kept on the server. It belongs either to a user
+// or to a guest, who is identified by the hash of the token they were given.
+type Cart struct {
+	ID        uint       `gorm:"primaryKey" db:"id"`
+	CreatedAt time.Time  `db:"created_at"`
+	UpdatedAt time.Time  `db:"updated_at"`
+	UserID    *uint      `gorm:"uniqueIndex" db:"user_id"`
+	TokenHash *string    `gorm:"uniqueIndex;type:varchar(64)" db:"token_hash"`
+	Items     []CartItem `gorm:"foreignKey:CartID" db:"-"`
+}
+
+// CartItem holds the quantity of one product, or of one variant of it, in
+// a cart. Prices are not stored; carts are always priced against the
+// current catalog.
+type CartItem struct {
+	ID        uint      `gorm:"primaryKey" db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+	CartID    uint      `gorm:"not null" db:"cart_id"`
+	ProductID uint      `gorm:"not null" db:"product_id"`
+	VariantID *uint     `db:"variant_id"`
+	Quantity  int       `gorm:"not null" db:"quantity"`
+}
+
+// IsLine reports whether the item is the cart line of productID and
+// variantID, nil for a product not sold by variant.
+func (item CartItem) IsLine(productID uint, variantID *uint) bool {
+	if item.ProductID != productID || (item.VariantID == nil) != (variantID == nil) {
+		return false
+	}
+	return variantID == nil || *item.VariantID == *variantID
 }
 
 type User struct {
-	ID        uint `gorm:"primaryKey"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Name      string `gorm:"not null"`
-	Email     string `gorm:"not null;uniqueIndex"`
-	Password  string `gorm:"not null"`
-	IsAdmin   bool   `gorm:"not null;default:false"`
+	ID        uint      `gorm:"primaryKey" db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+	Name      string    `gorm:"not null" db:"name"`
+	Email     string    `gorm:"not null;uniqueIndex" db:"email"`
+	Password  string    `gorm:"not null" db:"password"`
+	IsAdmin   bool      `gorm:"not null;default:false" db:"is_admin"`
 }
 
 type Session struct {
-	ID           string    `gorm:"primaryKey"`
-	UserEmail    string    `gorm:"not null"`
-	RefreshToken string    `gorm:"not null;type:varchar(512)"`
-	IsRevoked    bool      `gorm:"not null;default:false"`
-	CreatedAt    time.Time `gorm:"autoCreateTime"`
-	ExpiresAt    time.Time `gorm:"not null"`
+	ID           string    `gorm:"primaryKey" db:"id"`
+	UserEmail    string    `gorm:"not null" db:"user_email"`
+	RefreshToken string    `gorm:"not null;type:varchar(512)" db:"refresh_token"`
+	IsRevoked    bool      `gorm:"not null;default:false" db:"is_revoked"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" db:"created_at"`
+	ExpiresAt    time.Time `gorm:"not null" db:"expires_at"`
 }
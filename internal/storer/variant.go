@@ -0,0 +1,120 @@
+package storer
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ProductOption is an axis a product varies along, such as size or color,
+// with the values it takes.
+type ProductOption struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// ProductOptions is stored as JSON next to the product. A product with
+// options is sold by variant.
+type ProductOptions []ProductOption
+
+func (o ProductOptions) Value() (driver.Value, error) {
+	if len(o) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(o)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding product options: %w", err)
+	}
+	return string(b), nil
+}
+
+func (o *ProductOptions) Scan(src interface{}) error {
+	b, err := jsonBytes(src, "ProductOptions")
+	if b == nil || err != nil {
+		*o = nil
+		return err
+	}
+	if err := json.Unmarshal(b, o); err != nil {
+		return fmt.Errorf("error decoding product options: %w", err)
+	}
+	return nil
+}
+
+// VariantOptions maps every option of the product to the value the
+// variant has.
+type VariantOptions map[string]string
+
+func (o VariantOptions) Value() (driver.Value, error) {
+	if len(o) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(o)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding variant options: %w", err)
+	}
+	return string(b), nil
+}
+
+func (o *VariantOptions) Scan(src interface{}) error {
+	b, err := jsonBytes(src, "VariantOptions")
+	if b == nil || err != nil {
+		*o = nil
+		return err
+	}
+	if err := json.Unmarshal(b, o); err != nil {
+		return fmt.Errorf("error decoding variant options: %w", err)
+	}
+	return nil
+}
+
+func jsonBytes(src interface{}, into string) ([]byte, error) {
+	switch v := src.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	default:
+		return nil, fmt.Errorf("cannot scan %T into %s", src, into)
+	}
+}
+
+// ProductVariant is one combination of the options of a product. It has its
+// own SKU and stock and may override the price and image of the product.
+type ProductVariant struct {
+	ID        uint           `gorm:"primaryKey" db:"id"`
+	CreatedAt time.Time      `db:"created_at"`
+	UpdatedAt time.Time      `db:"updated_at"`
+	ProductID uint           `gorm:"not null;index" db:"product_id"`
+	SKU       string         `gorm:"not null;uniqueIndex;type:varchar(64)" db:"sku"`
+	Options   VariantOptions `gorm:"type:text" db:"options"`
+	// Price is nil when the variant sells at the product price.
+	Price        *float64 `gorm:"type:decimal(10,2)" db:"price"`
+	CountInStock int      `gorm:"not null;default:0" db:"count_in_stock"`
+	// Image is empty when the variant shows the product image.
+	Image    string `gorm:"not null;default:''" db:"image"`
+	IsActive bool   `gorm:"not null" db:"is_active"`
+}
+
+// PriceOf is what the variant of p sells at.
+func (v *ProductVariant) PriceOf(p *Product) float64 {
+	if v.Price != nil {
+		return *v.Price
+	}
+	return p.Price
+}
+
+// Label names the variant by its option values, in the order of the
+// product's options, such as "M / Red".
+func (v *ProductVariant) Label(options ProductOptions) string {
+	values := make([]string, 0, len(options))
+	for _, o := range options {
+		if value, ok := v.Options[o.Name]; ok {
+			values = append(values, value)
+		}
+	}
+	return strings.Join(values, " / ")
+}
@@ -1,44 +1,42 @@
 package storerpq
 
 import (
+	"cmp"
 	"context"
+	"database/sql"
+	"ecom_apiv1/internal/search"
 	"ecom_apiv1/internal/storer"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type PostgresStorage struct {
 	*sqlx.DB
 }
 
+var _ storer.Store = (*PostgresStorage)(nil)
+
 func NewPostgresStorage(db *sqlx.DB) *PostgresStorage {
 	return &PostgresStorage{
 		DB: db,
 	}
 }
 
-// func (ps *PostgresStorage) CreateProduct(ctx context.Context, p *storer.Product) (*storer.Product, error) {
-// 	res, err := ps.DB.NamedExecContext(ctx, "INSERT INTO products (name, image, category, description, rating, num_reviews, price, count_in_stock) VALUES (:name, :image, :category, :description, :rating, :num_reviews, :price, :count_in_stock)", p)
-// 	if err != nil {
-// 		return nil, fmt.Errorf("error inserting product: %w", err)
-// 	}
-//
-// 	var id int
-// 	err = ps.DB.GetContext(ctx, &id, "SELECT LASTVAL()")
-// 	if err != nil {
-// 		return nil, fmt.Errorf("error getting last insert ID: %w", err)
-// 	}
-// 	p.ID = id
-// 	return p, nil
-// }
-
 func (ps *PostgresStorage) CreateProduct(ctx context.Context, p *storer.Product) (*storer.Product, error) {
 	query := `
-		INSERT INTO products (name, image, category, description, rating, num_reviews, price, count_in_stock) 
-		VALUES (:name, :image, :category, :description, :rating, :num_reviews, :price, :count_in_stock) 
+		INSERT INTO products (created_at, updated_at, name, image, category, description, rating, num_reviews, price, count_in_stock, is_active, weight, length, width, height, tax_category, options, category_id) 
+		VALUES (:created_at, :updated_at, :name, :image, :category, :description, :rating, :num_reviews, :price, :count_in_stock, :is_active, :weight, :length, :width, :height, :tax_category, :options, :category_id) 
 		RETURNING id`
 
+	now := time.Now()
+	p.CreatedAt, p.UpdatedAt = now, now
+
 	stmt, err := ps.DB.PrepareNamedContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("error preparing named statement for product: %w", err)
@@ -53,252 +51,2159 @@ func (ps *PostgresStorage) CreateProduct(ctx context.Context, p *storer.Product)
 	return p, nil
 }
 
-func (ps *PostgresStorage) GetProduct(ctx context.Context, id int) (*storer.Product, error) {
+func (ps *PostgresStorage) GetProduct(ctx context.Context, id uint) (*storer.Product, error) {
 	var p storer.Product
-	err := ps.DB.GetContext(ctx, &p, "SELECT * FROM products WHERE ID = $1", id)
+	err := ps.DB.GetContext(ctx, &p, "SELECT * FROM products WHERE id = $1", id)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storer.ErrProductNotFound
+		}
 		return nil, fmt.Errorf("error getting product: %w", err)
 	}
 	return &p, nil
 }
 
-func (ps *PostgresStorage) ListProducts(ctx context.Context) ([]storer.Product, error) {
+func (ps *PostgresStorage) GetProducts(ctx context.Context, ids []uint) ([]storer.Product, error) {
+	if len(ids) == 0 {
+		return []storer.Product{}, nil
+	}
+	query, args, err := sqlx.In("SELECT * FROM products WHERE id IN (?) ORDER BY id", ids)
+	if err != nil {
+		return nil, fmt.Errorf("error building products query: %w", err)
+	}
+	products := []storer.Product{}
+	if err := ps.DB.SelectContext(ctx, &products, ps.DB.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("error getting products: %w", err)
+	}
+	return products, nil
+}
+
+func (ps *PostgresStorage) ListProducts(ctx context.Context, q storer.ProductQuery) (*storer.Page[storer.Product], error) {
+	lq, err := q.Compile()
+	if err != nil {
+		return nil, err
+	}
+	total, err := ps.count(ctx, lq, "products")
+	if err != nil {
+		return nil, fmt.Errorf("error counting products: %w", err)
+	}
 	var products []storer.Product
-	err := ps.DB.SelectContext(ctx, &products, "SELECT * FROM products")
+	query, args := lq.SelectSQL("products")
+	err = ps.DB.SelectContext(ctx, &products, ps.DB.Rebind(query), args...)
 	if err != nil {
 		return nil, fmt.Errorf("error listing products: %w", err)
 	}
-	return products, nil
+	return storer.ProductPage(lq, products, total), nil
+}
+
+func (ps *PostgresStorage) GetProductFacets(ctx context.Context, f storer.ProductFilter) (*storer.ProductFacets, error) {
+	rows := make(map[string][]storer.FacetRow)
+	for _, fq := range f.FacetQueries() {
+		var facetRows []storer.FacetRow
+		if err := ps.DB.SelectContext(ctx, &facetRows, ps.DB.Rebind(fq.SQL), fq.Args...); err != nil {
+			return nil, fmt.Errorf("error counting %s facet: %w", fq.Facet, err)
+		}
+		rows[fq.Facet] = facetRows
+	}
+	return storer.BuildProductFacets(rows)
+}
+
+// searchVector is the expression indexed by idx_products_search; queries
+// must repeat it verbatim for the planner to use the index.
+const searchVector = `setweight(to_tsvector('simple', name), 'A') || setweight(to_tsvector('simple', category), 'B') || setweight(to_tsvector('simple', coalesce(description, '')), 'C')`
+
+func (ps *PostgresStorage) SearchProducts(ctx context.Context, q storer.ProductSearchQuery) (*storer.SearchResult, error) {
+	limit, offset, err := q.Bounds()
+	if err != nil {
+		return nil, err
+	}
+	// Exact words first; when nothing matches, retry with prefixes of the
+	// terms so trailing typos still match.
+	res, err := ps.searchTSQuery(ctx, q.Query, strings.Join(search.Terms(q.Query), " | "), limit, offset)
+	if err != nil || res.Total > 0 {
+		return res, err
+	}
+	relaxed := search.RelaxedTerms(q.Query)
+	for i, t := range relaxed {
+		relaxed[i] = t + ":*"
+	}
+	return ps.searchTSQuery(ctx, q.Query, strings.Join(relaxed, " | "), limit, offset)
+}
+
+func (ps *PostgresStorage) searchTSQuery(ctx context.Context, query, tsquery string, limit, offset int) (*storer.SearchResult, error) {
+	var total int64
+	err := ps.DB.GetContext(ctx, &total, "SELECT COUNT(*) FROM products WHERE "+searchVector+" @@ to_tsquery('simple', $1)", tsquery)
+	if err != nil {
+		return nil, fmt.Errorf("error counting search hits: %w", err)
+	}
+	var rows []struct {
+		storer.Product
+		Score float64 `db:"score"`
+	}
+	err = ps.DB.SelectContext(ctx, &rows, `
+		SELECT *, ts_rank(`+searchVector+`, to_tsquery('simple', $1)) AS score
+		FROM products
+		WHERE `+searchVector+` @@ to_tsquery('simple', $1)
+		ORDER BY score DESC, id
+		LIMIT $2 OFFSET $3`, tsquery, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error searching products: %w", err)
+	}
+	res := &storer.SearchResult{Hits: make([]storer.ProductHit, 0, len(rows)), Total: total}
+	for _, row := range rows {
+		res.Hits = append(res.Hits, storer.NewProductHit(query, row.Product, row.Score))
+	}
+	return res, nil
 }
 
 func (ps *PostgresStorage) UpdateProduct(ctx context.Context, p *storer.Product) (*storer.Product, error) {
-	_, err := ps.DB.NamedExecContext(ctx, "UPDATE products SET name=:name, image=:image, category=:category, description=:description, rating=:rating, num_reviews=:num_reviews, price=:price, count_in_stock=:count_in_stock WHERE id=:id", p)
+	p.UpdatedAt = time.Now()
+	// rating and num_reviews belong to the reviews, see refreshRating
+	stmt, err := ps.DB.PrepareNamedContext(ctx, "UPDATE products SET updated_at=:updated_at, name=:name, image=:image, category=:category, description=:description, price=:price, count_in_stock=:count_in_stock, is_active=:is_active, weight=:weight, length=:length, width=:width, height=:height, tax_category=:tax_category, options=:options, category_id=:category_id WHERE id=:id RETURNING rating, num_reviews")
 	if err != nil {
+		return nil, fmt.Errorf("error preparing named statement for product: %w", err)
+	}
+	defer stmt.Close()
+	if err := stmt.QueryRowxContext(ctx, p).Scan(&p.Rating, &p.NumReviews); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storer.ErrProductNotFound
+		}
 		return nil, fmt.Errorf("error updating product: %w", err)
 	}
 	return p, nil
 }
 
-func (ps *PostgresStorage) DeleteProduct(ctx context.Context, id int) error {
-	_, err := ps.DB.ExecContext(ctx, "DELETE FROM products WHERE id=$1", id)
+func (ps *PostgresStorage) DeleteProduct(ctx context.Context, id uint) error {
+	res, err := ps.DB.ExecContext(ctx, "DELETE FROM products WHERE id=$1", id)
 	if err != nil {
 		return fmt.Errorf("error deleting product: %w", err)
 	}
-	return nil
+	return expectAffected(res, storer.ErrProductNotFound)
 }
 
-func (ps *PostgresStorage) execTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
-	tx, err := ps.DB.BeginTxx(ctx, nil)
+func (ps *PostgresStorage) CreateVariant(ctx context.Context, v *storer.ProductVariant) (*storer.ProductVariant, error) {
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		var exists bool
+		if err := tx.GetContext(ctx, &exists, "SELECT EXISTS(SELECT 1 FROM products WHERE id=$1)", v.ProductID); err != nil {
+			return fmt.Errorf("error checking product: %w", err)
+		}
+		if !exists {
+			return storer.ErrProductNotFound
+		}
+		now := time.Now()
+		v.CreatedAt, v.UpdatedAt = now, now
+		stmt, err := tx.PrepareNamedContext(ctx, `
+			INSERT INTO product_variants (created_at, updated_at, product_id, sku, options, price, count_in_stock, image, is_active)
+			VALUES (:created_at, :updated_at, :product_id, :sku, :options, :price, :count_in_stock, :image, :is_active)
+			RETURNING id`)
+		if err != nil {
+			return fmt.Errorf("error preparing named statement for variant: %w", err)
+		}
+		defer stmt.Close()
+		if err := stmt.GetContext(ctx, &v.ID, v); err != nil {
+			if isUniqueViolation(err) {
+				return storer.ErrSKUTaken
+			}
+			return fmt.Errorf("error inserting variant: %w", err)
+		}
+		return moveProductStock(ctx, tx, v.ProductID, v.CountInStock)
+	})
 	if err != nil {
-		return fmt.Errorf("error starting transaction: %w", err)
+		return nil, fmt.Errorf("error creating variant: %w", err)
 	}
+	return v, nil
+}
 
-	err = fn(tx)
+func (ps *PostgresStorage) GetVariant(ctx context.Context, id uint) (*storer.ProductVariant, error) {
+	return getVariant(ctx, ps.DB, "id=$1", id)
+}
+
+func (ps *PostgresStorage) GetVariants(ctx context.Context, ids []uint) ([]storer.ProductVariant, error) {
+	variants := []storer.ProductVariant{}
+	if len(ids) == 0 {
+		return variants, nil
+	}
+	query, args, err := sqlx.In("SELECT * FROM product_variants WHERE id IN (?) ORDER BY id", ids)
 	if err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
-			return fmt.Errorf("error rolling back transaction: %w", err)
-		}
-		return fmt.Errorf("error in transaction: %w", err)
+		return nil, fmt.Errorf("error building variants query: %w", err)
 	}
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("error committing transaction: %w", err)
+	if err := ps.DB.SelectContext(ctx, &variants, ps.DB.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("error getting variants: %w", err)
 	}
-
-	return nil
+	return variants, nil
 }
 
-func createOrder(ctx context.Context, tx *sqlx.Tx, o *storer.Order) (*storer.Order, error) {
-	query := `
-		INSERT INTO orders (payment_method, tax_price, shipping_price, total_price, user_id) 
-		VALUES (:payment_method, :tax_price, :shipping_price, :total_price, :user_id) 
-		RETURNING id`
-
-	stmt, err := tx.PrepareNamedContext(ctx, query)
-	if err != nil {
-		return nil, fmt.Errorf("error preparing named statement for order: %w", err)
+func (ps *PostgresStorage) ListVariants(ctx context.Context, productID uint) ([]storer.ProductVariant, error) {
+	variants := []storer.ProductVariant{}
+	if err := ps.DB.SelectContext(ctx, &variants, "SELECT * FROM product_variants WHERE product_id=$1 ORDER BY id", productID); err != nil {
+		return nil, fmt.Errorf("error listing variants: %w", err)
 	}
-	defer stmt.Close()
+	return variants, nil
+}
 
-	err = stmt.GetContext(ctx, &o.ID, o)
+func (ps *PostgresStorage) UpdateVariant(ctx context.Context, v *storer.ProductVariant) (*storer.ProductVariant, error) {
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		current, err := getVariant(ctx, tx, "id=$1 FOR UPDATE", v.ID)
+		if err != nil {
+			return err
+		}
+		v.ProductID, v.CreatedAt = current.ProductID, current.CreatedAt
+		v.UpdatedAt = time.Now()
+		_, err = tx.NamedExecContext(ctx, `
+			UPDATE product_variants SET updated_at=:updated_at, sku=:sku, options=:options, price=:price,
+				count_in_stock=:count_in_stock, image=:image, is_active=:is_active
+			WHERE id=:id`, v)
+		if err != nil {
+			if isUniqueViolation(err) {
+				return storer.ErrSKUTaken
+			}
+			return fmt.Errorf("error saving variant: %w", err)
+		}
+		return moveProductStock(ctx, tx, v.ProductID, v.CountInStock-current.CountInStock)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error inserting order and getting ID: %w", err)
+		return nil, fmt.Errorf("error updating variant: %w", err)
 	}
-
-	return o, nil
+	return v, nil
 }
 
-func createOrderItem(ctx context.Context, tx *sqlx.Tx, oi *storer.OrderItem) error {
-	_, err := tx.NamedExecContext(ctx, "INSERT INTO order_items (name, quantity, image, price, product_id, order_id) VALUES (:name, :quantity, :image, :price, :product_id, :order_id)", oi)
+func (ps *PostgresStorage) DeleteVariant(ctx context.Context, id uint) error {
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		v, err := getVariant(ctx, tx, "id=$1 FOR UPDATE", id)
+		if err != nil {
+			return err
+		}
+		var open bool
+		err = tx.GetContext(ctx, &open, `
+			SELECT EXISTS (SELECT 1 FROM order_items i JOIN orders o ON o.id = i.order_id
+			WHERE i.variant_id=$1 AND o.status IN ($2, $3, $4))`,
+			id, storer.OrderPending, storer.OrderPaid, storer.OrderProcessing)
+		if err != nil {
+			return fmt.Errorf("error checking open orders: %w", err)
+		}
+		if open {
+			return storer.ErrVariantInUse
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM product_variants WHERE id=$1", id); err != nil {
+			return fmt.Errorf("error deleting variant: %w", err)
+		}
+		return moveProductStock(ctx, tx, v.ProductID, -v.CountInStock)
+	})
 	if err != nil {
-		return fmt.Errorf("error inserting order item: %w", err)
+		return fmt.Errorf("error deleting variant: %w", err)
 	}
 	return nil
 }
 
-func (ps *PostgresStorage) CreateOrder(ctx context.Context, o *storer.Order) (*storer.Order, error) {
+func (ps *PostgresStorage) CreateProductImage(ctx context.Context, img *storer.ProductImage) (*storer.ProductImage, error) {
 	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
-		order, err := createOrder(ctx, tx, o)
+		if err := lockProduct(ctx, tx, img.ProductID); err != nil {
+			return err
+		}
+		if err := tx.GetContext(ctx, &img.Position, "SELECT COALESCE(MAX(position) + 1, 0) FROM product_images WHERE product_id=$1", img.ProductID); err != nil {
+			return fmt.Errorf("error finding last image: %w", err)
+		}
+		now := time.Now()
+		img.CreatedAt, img.UpdatedAt = now, now
+		stmt, err := tx.PrepareNamedContext(ctx, `
+			INSERT INTO product_images (created_at, updated_at, product_id, blob_key, url, thumbnail_key, thumbnail_url, content_type, size, width, height, alt_text, position)
+			VALUES (:created_at, :updated_at, :product_id, :blob_key, :url, :thumbnail_key, :thumbnail_url, :content_type, :size, :width, :height, :alt_text, :position)
+			RETURNING id`)
 		if err != nil {
-			return fmt.Errorf("error creating order: %w", err)
+			return fmt.Errorf("error preparing named statement for product image: %w", err)
 		}
-
-		for _, oi := range o.Items {
-			oi.OrderID = order.ID
-			err = createOrderItem(ctx, tx, &oi)
-			if err != nil {
-				return fmt.Errorf("error creating order item: %w", err)
-			}
+		defer stmt.Close()
+		if err := stmt.GetContext(ctx, &img.ID, img); err != nil {
+			return fmt.Errorf("error inserting product image: %w", err)
 		}
 		return nil
 	})
 	if err != nil {
-		return nil, fmt.Errorf("error creating order: %w", err)
+		return nil, fmt.Errorf("error creating product image: %w", err)
 	}
-	return o, nil
+	return img, nil
 }
 
-func (ps *PostgresStorage) GetOrder(ctx context.Context, userId int) (*storer.Order, error) {
-	var o storer.Order
-	err := ps.DB.GetContext(ctx, &o, "SELECT * FROM orders WHERE user_id=$1", userId)
+func (ps *PostgresStorage) GetProductImage(ctx context.Context, id uint) (*storer.ProductImage, error) {
+	return getProductImage(ctx, ps.DB, "id=$1", id)
+}
+
+func (ps *PostgresStorage) ListProductImages(ctx context.Context, productID uint) ([]storer.ProductImage, error) {
+	return listProductImages(ctx, ps.DB, productID)
+}
+
+func (ps *PostgresStorage) UpdateProductImage(ctx context.Context, img *storer.ProductImage) (*storer.ProductImage, error) {
+	res, err := ps.DB.ExecContext(ctx, "UPDATE product_images SET alt_text=$1, updated_at=$2 WHERE id=$3", img.AltText, time.Now(), img.ID)
 	if err != nil {
-		return nil, fmt.Errorf("error getting order: %w", err)
+		return nil, fmt.Errorf("error updating product image: %w", err)
 	}
-	var oi []storer.OrderItem
-	err = ps.DB.SelectContext(ctx, &oi, "SELECT * FROM order_items WHERE order_id=$1", o.ID)
+	if err := expectAffected(res, storer.ErrImageNotFound); err != nil {
+		return nil, err
+	}
+	return getProductImage(ctx, ps.DB, "id=$1", img.ID)
+}
+
+func (ps *PostgresStorage) ReorderProductImages(ctx context.Context, productID uint, ids []uint) ([]storer.ProductImage, error) {
+	var images []storer.ProductImage
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		if err := lockProduct(ctx, tx, productID); err != nil {
+			return err
+		}
+		current, err := listProductImages(ctx, tx, productID)
+		if err != nil {
+			return err
+		}
+		if err := storer.CheckImageOrder(current, ids); err != nil {
+			return err
+		}
+		for position, id := range ids {
+			if _, err := tx.ExecContext(ctx, "UPDATE product_images SET position=$1 WHERE id=$2", position, id); err != nil {
+				return fmt.Errorf("error moving image: %w", err)
+			}
+		}
+		images, err = listProductImages(ctx, tx, productID)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error getting order items: %w", err)
+		return nil, fmt.Errorf("error reordering product images: %w", err)
 	}
-	o.Items = oi
-	return &o, nil
+	return images, nil
 }
 
-func (ps *PostgresStorage) ListOrders(ctx context.Context) ([]storer.Order, error) {
-	var orders []storer.Order
-	err := ps.DB.SelectContext(ctx, &orders, "SELECT * FROM orders")
+func (ps *PostgresStorage) DeleteProductImage(ctx context.Context, id uint) error {
+	res, err := ps.DB.ExecContext(ctx, "DELETE FROM product_images WHERE id=$1", id)
 	if err != nil {
-		return nil, fmt.Errorf("error listing orders: %w", err)
+		return fmt.Errorf("error deleting product image: %w", err)
 	}
-	for i := range orders {
-		var items []storer.OrderItem
-		err := ps.DB.SelectContext(ctx, &items, "SELECT * FROM order_items WHERE order_id=$1", orders[i].ID)
+	return expectAffected(res, storer.ErrImageNotFound)
+}
+
+func (ps *PostgresStorage) CreateCategory(ctx context.Context, c *storer.Category) (*storer.Category, error) {
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		if c.ParentID != nil {
+			if _, err := getCategory(ctx, tx, "id=$1", *c.ParentID); err != nil {
+				return err
+			}
+		}
+		now := time.Now()
+		c.CreatedAt, c.UpdatedAt = now, now
+		stmt, err := tx.PrepareNamedContext(ctx, `
+			INSERT INTO categories (created_at, updated_at, parent_id, name, slug, position)
+			VALUES (:created_at, :updated_at, :parent_id, :name, :slug, :position)
+			RETURNING id`)
 		if err != nil {
-			return nil, fmt.Errorf("error listing order items: %w", err)
+			return fmt.Errorf("error preparing named statement for category: %w", err)
 		}
-		orders[i].Items = items
+		defer stmt.Close()
+		if err := stmt.GetContext(ctx, &c.ID, c); err != nil {
+			if isUniqueViolation(err) {
+				return storer.ErrCategorySlugTaken
+			}
+			return fmt.Errorf("error inserting category: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating category: %w", err)
+	}
+	return c, nil
+}
+
+func (ps *PostgresStorage) GetCategory(ctx context.Context, id uint) (*storer.Category, error) {
+	return getCategory(ctx, ps.DB, "id=$1", id)
+}
+
+func (ps *PostgresStorage) GetCategoryBySlug(ctx context.Context, slug string) (*storer.Category, error) {
+	return getCategory(ctx, ps.DB, "slug=$1", slug)
+}
+
+func (ps *PostgresStorage) ListCategories(ctx context.Context) ([]storer.Category, error) {
+	categories := []storer.Category{}
+	if err := ps.DB.SelectContext(ctx, &categories, "SELECT * FROM categories ORDER BY position, name, id"); err != nil {
+		return nil, fmt.Errorf("error listing categories: %w", err)
 	}
-	return orders, nil
+	return categories, nil
 }
 
-func (ps *PostgresStorage) DeleteOrder(ctx context.Context, id int) error {
+func (ps *PostgresStorage) UpdateCategory(ctx context.Context, c *storer.Category) (*storer.Category, error) {
 	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
-		_, err := tx.ExecContext(ctx, "DELETE FROM order_items WHERE order_id=$1", id)
+		if c.ParentID != nil {
+			if _, err := getCategory(ctx, tx, "id=$1", *c.ParentID); err != nil {
+				return err
+			}
+		}
+		c.UpdatedAt = time.Now()
+		res, err := tx.NamedExecContext(ctx, `
+			UPDATE categories SET updated_at=:updated_at, parent_id=:parent_id, name=:name, slug=:slug, position=:position
+			WHERE id=:id`, c)
 		if err != nil {
-			return fmt.Errorf("error deleting order items: %w", err)
+			if isUniqueViolation(err) {
+				return storer.ErrCategorySlugTaken
+			}
+			return fmt.Errorf("error saving category: %w", err)
 		}
-
-		_, err = tx.ExecContext(ctx, "DELETE FROM orders WHERE id=$1", id)
+		if err := expectAffected(res, storer.ErrCategoryNotFound); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE products SET category=$1 WHERE category_id=$2", c.Name, c.ID); err != nil {
+			return fmt.Errorf("error renaming products: %w", err)
+		}
+		updated, err := getCategory(ctx, tx, "id=$1", c.ID)
 		if err != nil {
-			return fmt.Errorf("error deleting order: %w", err)
+			return err
 		}
+		*c = *updated
 		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("error deleting order: %w", err)
+		return nil, fmt.Errorf("error updating category: %w", err)
 	}
-	return nil
+	return c, nil
 }
 
-func (ps *PostgresStorage) CreateUser(ctx context.Context, u *storer.User) (*storer.User, error) {
-	query := `
-		INSERT INTO users (name, email, password, is_admin) 
-		VALUES (:name, :email, :password, :is_admin) 
-		RETURNING id`
-
-	stmt, err := ps.DB.PrepareNamedContext(ctx, query)
+func (ps *PostgresStorage) DeleteCategory(ctx context.Context, id uint, moveTo *uint) error {
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		if _, err := getCategory(ctx, tx, "id=$1 FOR UPDATE", id); err != nil {
+			return err
+		}
+		var children, products int
+		if err := tx.GetContext(ctx, &children, "SELECT COUNT(*) FROM categories WHERE parent_id=$1", id); err != nil {
+			return fmt.Errorf("error counting subcategories: %w", err)
+		}
+		if err := tx.GetContext(ctx, &products, "SELECT COUNT(*) FROM products WHERE category_id=$1", id); err != nil {
+			return fmt.Errorf("error counting products: %w", err)
+		}
+		if children > 0 || products > 0 && moveTo == nil {
+			return storer.ErrCategoryInUse
+		}
+		if products > 0 {
+			target, err := getCategory(ctx, tx, "id=$1", *moveTo)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, "UPDATE products SET category_id=$1, category=$2 WHERE category_id=$3", target.ID, target.Name, id); err != nil {
+				return fmt.Errorf("error moving products: %w", err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM categories WHERE id=$1", id); err != nil {
+			return fmt.Errorf("error deleting category: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error preparing named statement for user: %w", err)
+		return fmt.Errorf("error deleting category: %w", err)
 	}
-	defer stmt.Close()
+	return nil
+}
 
-	err = stmt.GetContext(ctx, &u.ID, u)
+func (ps *PostgresStorage) CreateReview(ctx context.Context, r *storer.Review) (*storer.Review, error) {
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		if err := lockProduct(ctx, tx, r.ProductID); err != nil {
+			return err
+		}
+		now := time.Now()
+		r.CreatedAt, r.UpdatedAt = now, now
+		stmt, err := tx.PrepareNamedContext(ctx, `
+			INSERT INTO reviews (created_at, updated_at, product_id, user_id, rating, title, body, status, moderated_by)
+			VALUES (:created_at, :updated_at, :product_id, :user_id, :rating, :title, :body, :status, :moderated_by)
+			RETURNING id`)
+		if err != nil {
+			return fmt.Errorf("error preparing named statement for review: %w", err)
+		}
+		defer stmt.Close()
+		if err := stmt.GetContext(ctx, &r.ID, r); err != nil {
+			if isUniqueViolation(err) {
+				return storer.ErrReviewExists
+			}
+			return fmt.Errorf("error inserting review: %w", err)
+		}
+		return refreshRating(ctx, tx, r.ProductID)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error inserting user and getting ID: %w", err)
+		return nil, fmt.Errorf("error creating review: %w", err)
 	}
+	return r, nil
+}
 
-	return u, nil
+func (ps *PostgresStorage) GetReview(ctx context.Context, id uint) (*storer.Review, error) {
+	return getReview(ctx, ps.DB, "id=$1", id)
 }
 
-func (ps *PostgresStorage) GetUser(ctx context.Context, email string) (*storer.User, error) {
-	var u storer.User
-	err := ps.DB.GetContext(ctx, &u, "SELECT * FROM users WHERE email=$1", email)
+func (ps *PostgresStorage) ListReviews(ctx context.Context, q storer.ReviewQuery) (*storer.Page[storer.Review], error) {
+	lq, err := q.Compile()
 	if err != nil {
-		return nil, fmt.Errorf("error getting user: %w", err)
+		return nil, err
 	}
-
-	return &u, nil
-}
-
-func (ps *PostgresStorage) ListUsers(ctx context.Context) ([]storer.User, error) {
-	var users []storer.User
-	err := ps.DB.SelectContext(ctx, &users, "SELECT * FROM users")
+	total, err := ps.count(ctx, lq, "reviews")
 	if err != nil {
-		return nil, fmt.Errorf("error listing users: %w", err)
+		return nil, fmt.Errorf("error counting reviews: %w", err)
 	}
-
-	return users, nil
+	var reviews []storer.Review
+	query, args := lq.SelectSQL("reviews")
+	if err := ps.DB.SelectContext(ctx, &reviews, ps.DB.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("error listing reviews: %w", err)
+	}
+	return storer.ReviewPage(lq, reviews, total), nil
 }
 
-func (ps *PostgresStorage) UpdateUser(ctx context.Context, u *storer.User) (*storer.User, error) {
-	_, err := ps.DB.NamedExecContext(ctx, "UPDATE users SET name=:name, email=:email, password=:password, is_admin=:is_admin WHERE id=:id", u)
+func (ps *PostgresStorage) UpdateReview(ctx context.Context, r *storer.Review) (*storer.Review, error) {
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		current, err := getReview(ctx, tx, "id=$1 FOR UPDATE", r.ID)
+		if err != nil {
+			return err
+		}
+		if err := lockProduct(ctx, tx, current.ProductID); err != nil {
+			return err
+		}
+		r.UpdatedAt = time.Now()
+		_, err = tx.NamedExecContext(ctx, `
+			UPDATE reviews SET updated_at=:updated_at, rating=:rating, title=:title, body=:body, status=:status,
+				moderated_by=:moderated_by
+			WHERE id=:id`, r)
+		if err != nil {
+			return fmt.Errorf("error saving review: %w", err)
+		}
+		if err := refreshRating(ctx, tx, current.ProductID); err != nil {
+			return err
+		}
+		updated, err := getReview(ctx, tx, "id=$1", r.ID)
+		if err != nil {
+			return err
+		}
+		*r = *updated
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error updating user: %w", err)
+		return nil, fmt.Errorf("error updating review: %w", err)
 	}
-
-	return u, nil
+	return r, nil
 }
 
-func (ps *PostgresStorage) DeleteUser(ctx context.Context, id int) error {
-	_, err := ps.DB.ExecContext(ctx, "DELETE FROM users WHERE id=$1", id)
+func (ps *PostgresStorage) DeleteReview(ctx context.Context, id uint) error {
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		r, err := getReview(ctx, tx, "id=$1 FOR UPDATE", id)
+		if err != nil {
+			return err
+		}
+		if err := lockProduct(ctx, tx, r.ProductID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM reviews WHERE id=$1", id); err != nil {
+			return fmt.Errorf("error deleting review: %w", err)
+		}
+		return refreshRating(ctx, tx, r.ProductID)
+	})
 	if err != nil {
-		return fmt.Errorf("error deleting user: %w", err)
+		return fmt.Errorf("error deleting review: %w", err)
 	}
-
 	return nil
 }
 
-func (ps *PostgresStorage) CreateSession(ctx context.Context, s *storer.Session) (*storer.Session, error) {
-	_, err := ps.DB.NamedExecContext(ctx, "INSERT INTO sessions (id, user_email, refresh_token, is_revoked, expires_at) VALUES (:id, :user_email, :refresh_token, :is_revoked, :expires_at)", s)
+func (ps *PostgresStorage) HasDeliveredOrder(ctx context.Context, userID, productID uint) (bool, error) {
+	var delivered bool
+	err := ps.DB.GetContext(ctx, &delivered, `
+		SELECT EXISTS(
+			SELECT 1 FROM order_items i JOIN orders o ON o.id = i.order_id
+			WHERE o.user_id=$1 AND o.status=$2 AND i.product_id=$3
+		)`, userID, storer.OrderDelivered, productID)
 	if err != nil {
-		return nil, fmt.Errorf("error inserting session: %w", err)
+		return false, fmt.Errorf("error checking delivered orders: %w", err)
 	}
-
-	return s, nil
+	return delivered, nil
 }
 
-func (ps *PostgresStorage) GetSession(ctx context.Context, id string) (*storer.Session, error) {
-	var s storer.Session
-	err := ps.DB.GetContext(ctx, &s, "SELECT * FROM sessions WHERE id=$1", id)
+func (ps *PostgresStorage) execTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	tx, err := ps.DB.BeginTxx(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error getting session: %w", err)
+		return fmt.Errorf("error starting transaction: %w", err)
 	}
 
-	return &s, nil
-}
-
-func (ps *PostgresStorage) RevokeSession(ctx context.Context, id string) error {
-	_, err := ps.DB.NamedExecContext(ctx, "UPDATE sessions SET is_revoked=1 WHERE id=:id", map[string]interface{}{"id": id})
+	err = fn(tx)
 	if err != nil {
-		return fmt.Errorf("error revoking session: %w", err)
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("error rolling back transaction: %w", err)
+		}
+		return fmt.Errorf("error in transaction: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
 	}
 
 	return nil
 }
 
-func (ps *PostgresStorage) DeleteSession(ctx context.Context, id string) error {
-	_, err := ps.DB.ExecContext(ctx, "DELETE FROM sessions WHERE id=$1", id)
+func createOrder(ctx context.Context, tx *sqlx.Tx, o *storer.Order) (*storer.Order, error) {
+	query := `
+		INSERT INTO orders (created_at, updated_at, status, payment_method, items_price, tax_price, shipping_price, discount_price, total_price, user_id, email, shipping_address, tax_breakdown, discounts, access_token_hash) 
+		VALUES (:created_at, :updated_at, :status, :payment_method, :items_price, :tax_price, :shipping_price, :discount_price, :total_price, :user_id, :email, :shipping_address, :tax_breakdown, :discounts, :access_token_hash) 
+		RETURNING id`
+
+	stmt, err := tx.PrepareNamedContext(ctx, query)
 	if err != nil {
-		return fmt.Errorf("error deleting session: %w", err)
+		return nil, fmt.Errorf("error preparing named statement for order: %w", err)
 	}
+	defer stmt.Close()
 
-	return nil
+	err = stmt.GetContext(ctx, &o.ID, o)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting order and getting ID: %w", err)
+	}
+
+	return o, nil
+}
+
+func createOrderItem(ctx context.Context, tx *sqlx.Tx, oi *storer.OrderItem) error {
+	query := `
+		INSERT INTO order_items (created_at, updated_at, name, quantity, image, price, tax, discount, product_id, variant_id, sku, order_id) 
+		VALUES (:created_at, :updated_at, :name, :quantity, :image, :price, :tax, :discount, :product_id, :variant_id, :sku, :order_id) 
+		RETURNING id`
+
+	stmt, err := tx.PrepareNamedContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("error preparing named statement for order item: %w", err)
+	}
+	defer stmt.Close()
+
+	err = stmt.GetContext(ctx, &oi.ID, oi)
+	if err != nil {
+		return fmt.Errorf("error inserting order item: %w", err)
+	}
+	return nil
+}
+
+func insertStatusEvent(ctx context.Context, tx *sqlx.Tx, e storer.OrderStatusEvent) error {
+	_, err := tx.NamedExecContext(ctx, `
+		INSERT INTO order_status_events (created_at, order_id, from_status, to_status, changed_by, note)
+		VALUES (:created_at, :order_id, :from_status, :to_status, :changed_by, :note)`, e)
+	if err != nil {
+		return fmt.Errorf("error recording order status: %w", err)
+	}
+	return nil
+}
+
+// ensureProductsExist mirrors the GORM backend: an order item pointing at a
+// missing product aborts the whole order with storer.ErrProductNotFound, and
+// one pointing at a variant of another product with
+// storer.ErrVariantNotFound.
+func ensureProductsExist(ctx context.Context, tx *sqlx.Tx, items []storer.OrderItem) error {
+	for _, item := range items {
+		var exists bool
+		err := tx.GetContext(ctx, &exists, "SELECT EXISTS(SELECT 1 FROM products WHERE id=$1)", item.ProductID)
+		if err != nil {
+			return fmt.Errorf("error checking product: %w", err)
+		}
+		if !exists {
+			return storer.ErrProductNotFound
+		}
+		if item.VariantID == nil {
+			continue
+		}
+		err = tx.GetContext(ctx, &exists, "SELECT EXISTS(SELECT 1 FROM product_variants WHERE id=$1 AND product_id=$2)", *item.VariantID, item.ProductID)
+		if err != nil {
+			return fmt.Errorf("error checking variant: %w", err)
+		}
+		if !exists {
+			return storer.ErrVariantNotFound
+		}
+	}
+	return nil
+}
+
+// reserveStock takes the ordered quantities out of stock with conditional
+// updates, so two transactions can never both take the last unit.
+func reserveStock(ctx context.Context, tx *sqlx.Tx, items []storer.OrderItem) error {
+	var shortages []storer.StockShortage
+	for _, line := range storer.StockDemand(items) {
+		if line.VariantID != 0 {
+			shortage, err := reserveVariantStock(ctx, tx, line)
+			if err != nil {
+				return err
+			}
+			if shortage != nil {
+				shortages = append(shortages, *shortage)
+			}
+			continue
+		}
+		res, err := tx.ExecContext(ctx, "UPDATE products SET count_in_stock = count_in_stock - $1 WHERE id=$2 AND count_in_stock >= $1", line.Quantity, line.ProductID)
+		if err != nil {
+			return fmt.Errorf("error reserving stock: %w", err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return fmt.Errorf("error reserving stock: %w", err)
+		} else if n == 1 {
+			continue
+		}
+		var available int
+		if err := tx.GetContext(ctx, &available, "SELECT count_in_stock FROM products WHERE id=$1", line.ProductID); err != nil {
+			return fmt.Errorf("error reading stock: %w", err)
+		}
+		shortages = append(shortages, storer.StockShortage{ProductID: line.ProductID, Requested: line.Quantity, Available: available})
+	}
+	if len(shortages) > 0 {
+		return &storer.InsufficientStockError{Items: shortages}
+	}
+	return nil
+}
+
+// reserveVariantStock takes line out of the stock of its variant and of the
+// product, or returns the shortage of the variant.
+func reserveVariantStock(ctx context.Context, tx *sqlx.Tx, line storer.StockLine) (*storer.StockShortage, error) {
+	res, err := tx.ExecContext(ctx, "UPDATE product_variants SET count_in_stock = count_in_stock - $1 WHERE id=$2 AND count_in_stock >= $1", line.Quantity, line.VariantID)
+	if err != nil {
+		return nil, fmt.Errorf("error reserving stock: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, fmt.Errorf("error reserving stock: %w", err)
+	} else if n == 0 {
+		var available int
+		if err := tx.GetContext(ctx, &available, "SELECT count_in_stock FROM product_variants WHERE id=$1", line.VariantID); err != nil {
+			return nil, fmt.Errorf("error reading stock: %w", err)
+		}
+		return &storer.StockShortage{ProductID: line.ProductID, VariantID: line.VariantID, Requested: line.Quantity, Available: available}, nil
+	}
+	return nil, moveProductStock(ctx, tx, line.ProductID, -line.Quantity)
+}
+
+// releasePromotions gives back the promotion uses of an order, so that a
+// cancelled or deleted order counts against no usage limit.
+func releasePromotions(ctx context.Context, tx *sqlx.Tx, orderID uint) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE promotions SET used_count = used_count - 1
+		WHERE id IN (SELECT promotion_id FROM promotion_redemptions WHERE order_id=$1) AND used_count > 0`, orderID)
+	if err != nil {
+		return fmt.Errorf("error releasing promotions: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM promotion_redemptions WHERE order_id=$1", orderID); err != nil {
+		return fmt.Errorf("error deleting promotion redemptions: %w", err)
+	}
+	return nil
+}
+
+// restoreStock puts the items of an order back into stock.
+func restoreStock(ctx context.Context, tx *sqlx.Tx, orderID uint) error {
+	var items []storer.OrderItem
+	if err := tx.SelectContext(ctx, &items, "SELECT * FROM order_items WHERE order_id=$1", orderID); err != nil {
+		return fmt.Errorf("error loading order items: %w", err)
+	}
+	for _, line := range storer.StockDemand(items) {
+		var variantID *uint
+		if line.VariantID != 0 {
+			variantID = &line.VariantID
+		}
+		if err := restock(ctx, tx, line.ProductID, variantID, line.Quantity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restock puts quantity units of the product, and of its variant if any,
+// back into stock.
+func restock(ctx context.Context, tx *sqlx.Tx, productID uint, variantID *uint, quantity int) error {
+	if variantID != nil {
+		_, err := tx.ExecContext(ctx, "UPDATE product_variants SET count_in_stock = count_in_stock + $1 WHERE id=$2", quantity, *variantID)
+		if err != nil {
+			return fmt.Errorf("error restoring stock: %w", err)
+		}
+	}
+	return moveProductStock(ctx, tx, productID, quantity)
+}
+
+func moveProductStock(ctx context.Context, tx *sqlx.Tx, productID uint, delta int) error {
+	if delta == 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, "UPDATE products SET count_in_stock = count_in_stock + $1 WHERE id=$2", delta, productID)
+	if err != nil {
+		return fmt.Errorf("error moving product stock: %w", err)
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) CreateOrder(ctx context.Context, o *storer.Order) (*storer.Order, error) {
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		redemptions, err := claimPromotions(ctx, tx, o)
+		if err != nil {
+			return err
+		}
+		if err := ensureProductsExist(ctx, tx, o.Items); err != nil {
+			return err
+		}
+		if err := reserveStock(ctx, tx, o.Items); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		o.CreatedAt, o.UpdatedAt = now, now
+		if o.Status == "" {
+			o.Status = storer.OrderPending
+		}
+		order, err := createOrder(ctx, tx, o)
+		if err != nil {
+			return fmt.Errorf("error creating order: %w", err)
+		}
+		if err := insertStatusEvent(ctx, tx, storer.NewStatusEvent(order.ID, "", storer.StatusChange{To: o.Status, ChangedBy: o.UserID}, now)); err != nil {
+			return err
+		}
+
+		for i := range o.Items {
+			oi := &o.Items[i]
+			oi.OrderID = order.ID
+			oi.CreatedAt, oi.UpdatedAt = now, now
+			err = createOrderItem(ctx, tx, oi)
+			if err != nil {
+				return fmt.Errorf("error creating order item: %w", err)
+			}
+		}
+		for _, rd := range redemptions {
+			rd.OrderID, rd.CreatedAt = order.ID, now
+			_, err := tx.NamedExecContext(ctx, `
+				INSERT INTO promotion_redemptions (created_at, promotion_id, order_id, user_id, email)
+				VALUES (:created_at, :promotion_id, :order_id, :user_id, :email)`, rd)
+			if err != nil {
+				return fmt.Errorf("error recording promotion redemption: %w", err)
+			}
+		}
+		if o.CartID != nil {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM cart_items WHERE cart_id=$1", *o.CartID); err != nil {
+				return fmt.Errorf("error emptying cart: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating order: %w", err)
+	}
+	return o, nil
+}
+
+func (ps *PostgresStorage) GetOrderByID(ctx context.Context, id uint) (*storer.Order, error) {
+	var o storer.Order
+	err := ps.DB.GetContext(ctx, &o, "SELECT * FROM orders WHERE id=$1", id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storer.ErrOrderNotFound
+		}
+		return nil, fmt.Errorf("error getting order: %w", err)
+	}
+	o.Items = []storer.OrderItem{}
+	err = ps.DB.SelectContext(ctx, &o.Items, "SELECT * FROM order_items WHERE order_id=$1 ORDER BY id", o.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting order items: %w", err)
+	}
+	return &o, nil
+}
+
+func (ps *PostgresStorage) GetOrderByAccessToken(ctx context.Context, tokenHash string) (*storer.Order, error) {
+	var o storer.Order
+	err := ps.DB.GetContext(ctx, &o, "SELECT * FROM orders WHERE access_token_hash=$1", tokenHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storer.ErrOrderNotFound
+		}
+		return nil, fmt.Errorf("error getting order: %w", err)
+	}
+	o.Items = []storer.OrderItem{}
+	err = ps.DB.SelectContext(ctx, &o.Items, "SELECT * FROM order_items WHERE order_id=$1 ORDER BY id", o.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting order items: %w", err)
+	}
+	return &o, nil
+}
+
+func (ps *PostgresStorage) ClaimOrder(ctx context.Context, tokenHash string, userID uint) (*storer.Order, error) {
+	var id uint
+	err := ps.DB.GetContext(ctx, &id,
+		"UPDATE orders SET user_id=$1, access_token_hash=NULL, updated_at=$2 WHERE access_token_hash=$3 RETURNING id",
+		userID, time.Now(), tokenHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storer.ErrOrderNotFound
+		}
+		return nil, fmt.Errorf("error claiming order: %w", err)
+	}
+	return ps.GetOrderByID(ctx, id)
+}
+
+func (ps *PostgresStorage) UpdateOrderStatus(ctx context.Context, id uint, c storer.StatusChange) (*storer.Order, error) {
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		return updateOrderStatus(ctx, tx, id, c)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating order status: %w", err)
+	}
+	return ps.GetOrderByID(ctx, id)
+}
+
+// updateOrderStatus applies c to the locked order row, restoring stock and
+// recording the status event.
+func updateOrderStatus(ctx context.Context, tx *sqlx.Tx, id uint, c storer.StatusChange) error {
+	var from storer.OrderStatus
+	err := tx.GetContext(ctx, &from, "SELECT status FROM orders WHERE id=$1 FOR UPDATE", id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storer.ErrOrderNotFound
+		}
+		return fmt.Errorf("error getting order: %w", err)
+	}
+	if err := storer.CheckTransition(from, c); err != nil {
+		return err
+	}
+	now := time.Now()
+	if _, err := tx.ExecContext(ctx, "UPDATE orders SET status=$1, updated_at=$2 WHERE id=$3", c.To, now, id); err != nil {
+		return fmt.Errorf("error updating order status: %w", err)
+	}
+	if storer.ReleasesStock(from, c.To) {
+		if err := restoreStock(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+	if c.To == storer.OrderCancelled {
+		if err := releasePromotions(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+	return insertStatusEvent(ctx, tx, storer.NewStatusEvent(id, from, c, now))
+}
+
+func (ps *PostgresStorage) ListOrderStatusEvents(ctx context.Context, orderID uint) ([]storer.OrderStatusEvent, error) {
+	var exists bool
+	if err := ps.DB.GetContext(ctx, &exists, "SELECT EXISTS(SELECT 1 FROM orders WHERE id=$1)", orderID); err != nil {
+		return nil, fmt.Errorf("error getting order: %w", err)
+	}
+	if !exists {
+		return nil, storer.ErrOrderNotFound
+	}
+	events := []storer.OrderStatusEvent{}
+	err := ps.DB.SelectContext(ctx, &events, "SELECT * FROM order_status_events WHERE order_id=$1 ORDER BY created_at, id", orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing order status events: %w", err)
+	}
+	return events, nil
+}
+
+func (ps *PostgresStorage) ListOrders(ctx context.Context, q storer.OrderQuery) (*storer.Page[storer.Order], error) {
+	lq, err := q.Compile()
+	if err != nil {
+		return nil, err
+	}
+	total, err := ps.count(ctx, lq, "orders")
+	if err != nil {
+		return nil, fmt.Errorf("error counting orders: %w", err)
+	}
+	var orders []storer.Order
+	query, args := lq.SelectSQL("orders")
+	err = ps.DB.SelectContext(ctx, &orders, ps.DB.Rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing orders: %w", err)
+	}
+	if err := ps.loadOrderItems(ctx, orders); err != nil {
+		return nil, err
+	}
+	return storer.OrderPage(lq, orders, total), nil
+}
+
+// loadOrderItems fetches the items of every order in one query.
+func (ps *PostgresStorage) loadOrderItems(ctx context.Context, orders []storer.Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(orders))
+	index := make(map[uint]int, len(orders))
+	for i, o := range orders {
+		ids[i] = o.ID
+		index[o.ID] = i
+	}
+	query, args, err := sqlx.In("SELECT * FROM order_items WHERE order_id IN (?) ORDER BY id", ids)
+	if err != nil {
+		return fmt.Errorf("error building order items query: %w", err)
+	}
+	var items []storer.OrderItem
+	if err := ps.DB.SelectContext(ctx, &items, ps.DB.Rebind(query), args...); err != nil {
+		return fmt.Errorf("error listing order items: %w", err)
+	}
+	for _, item := range items {
+		o := &orders[index[item.OrderID]]
+		o.Items = append(o.Items, item)
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) count(ctx context.Context, lq *storer.ListQuery, table string) (int64, error) {
+	var total int64
+	query, args := lq.CountSQL(table)
+	err := ps.DB.GetContext(ctx, &total, ps.DB.Rebind(query), args...)
+	return total, err
+}
+
+func (ps *PostgresStorage) DeleteOrder(ctx context.Context, id uint) error {
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		var status storer.OrderStatus
+		err := tx.GetContext(ctx, &status, "SELECT status FROM orders WHERE id=$1 FOR UPDATE", id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return storer.ErrOrderNotFound
+			}
+			return fmt.Errorf("error getting order: %w", err)
+		}
+		var captured bool
+		err = tx.GetContext(ctx, &captured, "SELECT EXISTS(SELECT 1 FROM payments WHERE order_id=$1 AND status=$2)", id, storer.PaymentCaptured)
+		if err != nil {
+			return fmt.Errorf("error checking payments: %w", err)
+		}
+		if !status.Deletable() || captured {
+			return storer.ErrOrderNotDeletable
+		}
+		if status.HoldsStock() {
+			if err := restoreStock(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+		if err := releasePromotions(ctx, tx, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM order_status_events WHERE order_id=$1", id); err != nil {
+			return fmt.Errorf("error deleting order status events: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM refunds WHERE order_id=$1", id); err != nil {
+			return fmt.Errorf("error deleting refunds: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM return_requests WHERE order_id=$1", id); err != nil {
+			return fmt.Errorf("error deleting returns: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM payments WHERE order_id=$1", id); err != nil {
+			return fmt.Errorf("error deleting payments: %w", err)
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM order_items WHERE order_id=$1", id)
+		if err != nil {
+			return fmt.Errorf("error deleting order items: %w", err)
+		}
+
+		res, err := tx.ExecContext(ctx, "DELETE FROM orders WHERE id=$1", id)
+		if err != nil {
+			return fmt.Errorf("error deleting order: %w", err)
+		}
+		return expectAffected(res, storer.ErrOrderNotFound)
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting order: %w", err)
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) CreatePayment(ctx context.Context, p *storer.Payment) (*storer.Payment, error) {
+	var exists bool
+	if err := ps.DB.GetContext(ctx, &exists, "SELECT EXISTS(SELECT 1 FROM orders WHERE id=$1)", p.OrderID); err != nil {
+		return nil, fmt.Errorf("error getting order: %w", err)
+	}
+	if !exists {
+		return nil, storer.ErrOrderNotFound
+	}
+	now := time.Now()
+	p.CreatedAt, p.UpdatedAt = now, now
+	err := ps.DB.GetContext(ctx, &p.ID, `
+		INSERT INTO payments (created_at, updated_at, order_id, provider, intent_id, capture_id, status, amount, currency, failure_reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`,
+		p.CreatedAt, p.UpdatedAt, p.OrderID, p.Provider, p.IntentID, p.CaptureID, p.Status, p.Amount, p.Currency, p.FailureReason)
+	if err != nil {
+		return nil, fmt.Errorf("error creating payment: %w", err)
+	}
+	return p, nil
+}
+
+func (ps *PostgresStorage) GetPayment(ctx context.Context, id uint) (*storer.Payment, error) {
+	return getPayment(ctx, ps.DB, "id=$1", id)
+}
+
+func (ps *PostgresStorage) GetPaymentByIntent(ctx context.Context, provider, intentID string) (*storer.Payment, error) {
+	return getPayment(ctx, ps.DB, "provider=$1 AND intent_id=$2", provider, intentID)
+}
+
+func (ps *PostgresStorage) ListPayments(ctx context.Context, orderID uint) ([]storer.Payment, error) {
+	var exists bool
+	if err := ps.DB.GetContext(ctx, &exists, "SELECT EXISTS(SELECT 1 FROM orders WHERE id=$1)", orderID); err != nil {
+		return nil, fmt.Errorf("error getting order: %w", err)
+	}
+	if !exists {
+		return nil, storer.ErrOrderNotFound
+	}
+	payments := []storer.Payment{}
+	if err := ps.DB.SelectContext(ctx, &payments, "SELECT * FROM payments WHERE order_id=$1 ORDER BY id", orderID); err != nil {
+		return nil, fmt.Errorf("error listing payments: %w", err)
+	}
+	return payments, nil
+}
+
+func (ps *PostgresStorage) CapturePayment(ctx context.Context, id uint, captureID string) (*storer.Payment, error) {
+	var p *storer.Payment
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) (err error) {
+		p, err = settlePayment(ctx, tx, id, storer.PaymentPending, storer.PaymentCaptured, "capture_id", captureID)
+		if err != nil {
+			return err
+		}
+		return updateOrderStatus(ctx, tx, p.OrderID, storer.CaptureStatusChange(p))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error capturing payment: %w", err)
+	}
+	return p, nil
+}
+
+func (ps *PostgresStorage) FailPayment(ctx context.Context, id uint, reason string) (*storer.Payment, error) {
+	var p *storer.Payment
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) (err error) {
+		p, err = settlePayment(ctx, tx, id, storer.PaymentPending, storer.PaymentFailed, "failure_reason", reason)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error failing payment: %w", err)
+	}
+	return p, nil
+}
+
+func (ps *PostgresStorage) GetWebhookEvent(ctx context.Context, provider, eventID string) (*storer.WebhookEvent, error) {
+	var e storer.WebhookEvent
+	err := ps.DB.GetContext(ctx, &e, "SELECT * FROM webhook_events WHERE provider=$1 AND event_id=$2", provider, eventID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storer.ErrWebhookEventNotFound
+		}
+		return nil, fmt.Errorf("error getting webhook event: %w", err)
+	}
+	return &e, nil
+}
+
+func (ps *PostgresStorage) CreateWebhookEvent(ctx context.Context, e *storer.WebhookEvent) (*storer.WebhookEvent, error) {
+	e.CreatedAt = time.Now()
+	err := ps.DB.GetContext(ctx, &e.ID, `
+		INSERT INTO webhook_events (created_at, provider, event_id, type, payment_id, outcome)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		e.CreatedAt, e.Provider, e.EventID, e.Type, e.PaymentID, e.Outcome)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, storer.ErrWebhookEventExists
+		}
+		return nil, fmt.Errorf("error recording webhook event: %w", err)
+	}
+	return e, nil
+}
+
+func (ps *PostgresStorage) CreateReturn(ctx context.Context, r *storer.ReturnRequest) (*storer.ReturnRequest, error) {
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		// locking the item serialises concurrent returns of it
+		var ordered int
+		err := tx.GetContext(ctx, &ordered, "SELECT quantity FROM order_items WHERE id=$1 AND order_id=$2 FOR UPDATE", r.OrderItemID, r.OrderID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return storer.ErrOrderItemNotFound
+			}
+			return fmt.Errorf("error getting order item: %w", err)
+		}
+		var existing []storer.ReturnRequest
+		if err := tx.SelectContext(ctx, &existing, "SELECT * FROM return_requests WHERE order_item_id=$1", r.OrderItemID); err != nil {
+			return fmt.Errorf("error listing returns: %w", err)
+		}
+		if storer.ReturnedQuantity(existing, r.OrderItemID)+r.Quantity > ordered {
+			return storer.ErrReturnQuantityExceeded
+		}
+		now := time.Now()
+		r.CreatedAt, r.UpdatedAt, r.Status = now, now, storer.ReturnRequested
+		return tx.GetContext(ctx, &r.ID, `
+			INSERT INTO return_requests (created_at, updated_at, order_id, order_item_id, quantity, reason, comment, status, reviewed_by, review_note)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`,
+			r.CreatedAt, r.UpdatedAt, r.OrderID, r.OrderItemID, r.Quantity, r.Reason, r.Comment, r.Status, r.ReviewedBy, r.ReviewNote)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating return: %w", err)
+	}
+	return r, nil
+}
+
+func (ps *PostgresStorage) GetReturn(ctx context.Context, id uint) (*storer.ReturnRequest, error) {
+	return getReturn(ctx, ps.DB, id)
+}
+
+func (ps *PostgresStorage) ListReturns(ctx context.Context, orderID uint) ([]storer.ReturnRequest, error) {
+	if err := ps.orderExists(ctx, orderID); err != nil {
+		return nil, err
+	}
+	returns := []storer.ReturnRequest{}
+	if err := ps.DB.SelectContext(ctx, &returns, "SELECT * FROM return_requests WHERE order_id=$1 ORDER BY id", orderID); err != nil {
+		return nil, fmt.Errorf("error listing returns: %w", err)
+	}
+	return returns, nil
+}
+
+func (ps *PostgresStorage) UpdateReturnStatus(ctx context.Context, id uint, c storer.ReturnChange) (*storer.ReturnRequest, error) {
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		return updateReturnStatus(ctx, tx, id, c)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating return status: %w", err)
+	}
+	return ps.GetReturn(ctx, id)
+}
+
+func (ps *PostgresStorage) CreateRefund(ctx context.Context, r *storer.Refund) (*storer.Refund, error) {
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		if r.ReturnID != nil {
+			var status storer.ReturnStatus
+			err := tx.GetContext(ctx, &status, "SELECT status FROM return_requests WHERE id=$1 AND order_id=$2 FOR UPDATE", *r.ReturnID, r.OrderID)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("error getting return: %w", err)
+			}
+			var refunded bool
+			err = tx.GetContext(ctx, &refunded, "SELECT EXISTS(SELECT 1 FROM refunds WHERE return_id=$1 AND status<>$2)", *r.ReturnID, storer.RefundFailed)
+			if err != nil {
+				return fmt.Errorf("error listing refunds: %w", err)
+			}
+			if status != storer.ReturnReceived || refunded {
+				return storer.ErrReturnNotRefundable
+			}
+		}
+		var o storer.Order
+		err := tx.GetContext(ctx, &o, "SELECT * FROM orders WHERE id=$1 FOR UPDATE", r.OrderID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return storer.ErrOrderNotFound
+			}
+			return fmt.Errorf("error getting order: %w", err)
+		}
+		if storer.ExceedsTotal(o.RefundedAmount, r.Amount, o.TotalPrice) {
+			return storer.ErrRefundExceedsTotal
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE orders SET refunded_amount=refunded_amount+$1 WHERE id=$2", r.Amount, r.OrderID); err != nil {
+			return fmt.Errorf("error updating refunded amount: %w", err)
+		}
+		now := time.Now()
+		r.CreatedAt, r.UpdatedAt, r.Status = now, now, storer.RefundPending
+		return tx.GetContext(ctx, &r.ID, `
+			INSERT INTO refunds (created_at, updated_at, order_id, return_id, payment_id, amount, reason, status, provider_refund_id, failure_reason, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`,
+			r.CreatedAt, r.UpdatedAt, r.OrderID, r.ReturnID, r.PaymentID, r.Amount, r.Reason, r.Status, r.ProviderRefundID, r.FailureReason, r.CreatedBy)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating refund: %w", err)
+	}
+	return r, nil
+}
+
+func (ps *PostgresStorage) SubmitRefund(ctx context.Context, id uint, providerRefundID string) (*storer.Refund, error) {
+	var r *storer.Refund
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) (err error) {
+		r, err = settleRefund(ctx, tx, id, storer.RefundPending, "provider_refund_id", providerRefundID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error submitting refund: %w", err)
+	}
+	return r, nil
+}
+
+func (ps *PostgresStorage) CompleteRefund(ctx context.Context, id uint, providerRefundID string) (*storer.Refund, error) {
+	var r *storer.Refund
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) (err error) {
+		r, err = settleRefund(ctx, tx, id, storer.RefundSucceeded, "provider_refund_id", providerRefundID)
+		if err != nil {
+			return err
+		}
+		if r.ReturnID != nil {
+			change := storer.ReturnChange{To: storer.ReturnRefunded, ChangedBy: r.CreatedBy, Note: fmt.Sprintf("refund %d", r.ID)}
+			if err := updateReturnStatus(ctx, tx, *r.ReturnID, change); err != nil {
+				return err
+			}
+		}
+		var o storer.Order
+		if err := tx.GetContext(ctx, &o, "SELECT * FROM orders WHERE id=$1 FOR UPDATE", r.OrderID); err != nil {
+			return fmt.Errorf("error getting order: %w", err)
+		}
+		var refunded float64
+		err = tx.GetContext(ctx, &refunded, "SELECT COALESCE(SUM(amount), 0) FROM refunds WHERE order_id=$1 AND status=$2", r.OrderID, storer.RefundSucceeded)
+		if err != nil {
+			return fmt.Errorf("error summing refunds: %w", err)
+		}
+		if !storer.FullyRefunded(refunded, o.TotalPrice) {
+			return nil
+		}
+		if r.PaymentID != nil {
+			_, err := settlePayment(ctx, tx, *r.PaymentID, storer.PaymentCaptured, storer.PaymentRefunded, "", "")
+			if err != nil && !errors.Is(err, storer.ErrPaymentNotCaptured) {
+				return err
+			}
+		}
+		if !o.Status.CanTransitionTo(storer.OrderRefunded) {
+			return nil
+		}
+		return updateOrderStatus(ctx, tx, o.ID, storer.FullRefundStatusChange(r))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error completing refund: %w", err)
+	}
+	return r, nil
+}
+
+func (ps *PostgresStorage) FailRefund(ctx context.Context, id uint, reason string) (*storer.Refund, error) {
+	var r *storer.Refund
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) (err error) {
+		r, err = settleRefund(ctx, tx, id, storer.RefundFailed, "failure_reason", reason)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE orders SET refunded_amount=refunded_amount-$1 WHERE id=$2", r.Amount, r.OrderID); err != nil {
+			return fmt.Errorf("error updating refunded amount: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error failing refund: %w", err)
+	}
+	return r, nil
+}
+
+func (ps *PostgresStorage) ListRefunds(ctx context.Context, orderID uint) ([]storer.Refund, error) {
+	if err := ps.orderExists(ctx, orderID); err != nil {
+		return nil, err
+	}
+	refunds := []storer.Refund{}
+	if err := ps.DB.SelectContext(ctx, &refunds, "SELECT * FROM refunds WHERE order_id=$1 ORDER BY id", orderID); err != nil {
+		return nil, fmt.Errorf("error listing refunds: %w", err)
+	}
+	return refunds, nil
+}
+
+func (ps *PostgresStorage) CreateIdempotencyKey(ctx context.Context, k *storer.IdempotencyKey) (*storer.IdempotencyKey, error) {
+	k.CreatedAt = time.Now()
+	err := ps.DB.GetContext(ctx, &k.ID, `
+		INSERT INTO idempotency_keys (created_at, scope, idempotency_key, fingerprint, status_code, content_type, body, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		k.CreatedAt, k.Scope, k.Key, k.Fingerprint, k.StatusCode, k.ContentType, k.Body, k.ExpiresAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, storer.ErrIdempotencyKeyExists
+		}
+		return nil, fmt.Errorf("error creating idempotency key: %w", err)
+	}
+	return k, nil
+}
+
+func (ps *PostgresStorage) GetIdempotencyKey(ctx context.Context, scope, key string) (*storer.IdempotencyKey, error) {
+	var k storer.IdempotencyKey
+	err := ps.DB.GetContext(ctx, &k, "SELECT * FROM idempotency_keys WHERE scope=$1 AND idempotency_key=$2", scope, key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storer.ErrIdempotencyKeyNotFound
+		}
+		return nil, fmt.Errorf("error getting idempotency key: %w", err)
+	}
+	return &k, nil
+}
+
+func (ps *PostgresStorage) CompleteIdempotencyKey(ctx context.Context, id uint, statusCode int, contentType string, body []byte, expiresAt time.Time) error {
+	res, err := ps.DB.ExecContext(ctx,
+		"UPDATE idempotency_keys SET status_code=$1, content_type=$2, body=$3, expires_at=$4 WHERE id=$5",
+		statusCode, contentType, body, expiresAt, id)
+	if err != nil {
+		return fmt.Errorf("error completing idempotency key: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("error completing idempotency key: %w", err)
+	} else if n == 0 {
+		return storer.ErrIdempotencyKeyNotFound
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) DeleteIdempotencyKey(ctx context.Context, id uint) error {
+	if _, err := ps.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE id=$1", id); err != nil {
+		return fmt.Errorf("error deleting idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	res, err := ps.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at < $1", now)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting expired idempotency keys: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error deleting expired idempotency keys: %w", err)
+	}
+	return n, nil
+}
+
+func (ps *PostgresStorage) CreateCart(ctx context.Context, c *storer.Cart) (*storer.Cart, error) {
+	now := time.Now()
+	c.CreatedAt, c.UpdatedAt = now, now
+	err := ps.DB.GetContext(ctx, &c.ID,
+		"INSERT INTO carts (created_at, updated_at, user_id, token_hash) VALUES ($1, $2, $3, $4) RETURNING id",
+		c.CreatedAt, c.UpdatedAt, c.UserID, c.TokenHash)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, storer.ErrCartAlreadyExists
+		}
+		return nil, fmt.Errorf("error creating cart: %w", err)
+	}
+	c.Items = []storer.CartItem{}
+	return c, nil
+}
+
+func (ps *PostgresStorage) GetUserCart(ctx context.Context, userID uint) (*storer.Cart, error) {
+	return getCart(ctx, ps.DB, "user_id=$1", userID)
+}
+
+func (ps *PostgresStorage) GetGuestCart(ctx context.Context, tokenHash string) (*storer.Cart, error) {
+	return getCart(ctx, ps.DB, "token_hash=$1", tokenHash)
+}
+
+func (ps *PostgresStorage) AddCartItem(ctx context.Context, cartID, productID uint, variantID *uint, quantity int) (*storer.Cart, error) {
+	return ps.upsertCartItem(ctx, cartID, productID, variantID, quantity, "cart_items.quantity + EXCLUDED.quantity")
+}
+
+func (ps *PostgresStorage) SetCartItem(ctx context.Context, cartID, productID uint, variantID *uint, quantity int) (*storer.Cart, error) {
+	return ps.upsertCartItem(ctx, cartID, productID, variantID, quantity, "EXCLUDED.quantity")
+}
+
+func (ps *PostgresStorage) upsertCartItem(ctx context.Context, cartID, productID uint, variantID *uint, quantity int, update string) (*storer.Cart, error) {
+	if quantity < 1 {
+		return nil, storer.ErrInvalidQuantity
+	}
+	var c *storer.Cart
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		if err := touchCart(ctx, tx, cartID); err != nil {
+			return err
+		}
+		var exists bool
+		if err := tx.GetContext(ctx, &exists, "SELECT EXISTS(SELECT 1 FROM products WHERE id=$1)", productID); err != nil {
+			return fmt.Errorf("error checking product: %w", err)
+		}
+		if !exists {
+			return storer.ErrProductNotFound
+		}
+		if variantID != nil {
+			if err := tx.GetContext(ctx, &exists, "SELECT EXISTS(SELECT 1 FROM product_variants WHERE id=$1 AND product_id=$2)", *variantID, productID); err != nil {
+				return fmt.Errorf("error checking variant: %w", err)
+			}
+			if !exists {
+				return storer.ErrVariantNotFound
+			}
+		}
+		if err := upsertCartItem(ctx, tx, cartID, productID, variantID, quantity, update); err != nil {
+			return err
+		}
+		var err error
+		c, err = getCart(ctx, tx, "id=$1", cartID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating cart: %w", err)
+	}
+	return c, nil
+}
+
+func (ps *PostgresStorage) RemoveCartItem(ctx context.Context, cartID, productID uint, variantID *uint) (*storer.Cart, error) {
+	var c *storer.Cart
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		if err := touchCart(ctx, tx, cartID); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, "DELETE FROM cart_items WHERE cart_id=$1 AND product_id=$2 AND variant_id IS NOT DISTINCT FROM $3", cartID, productID, variantID)
+		if err != nil {
+			return fmt.Errorf("error deleting cart item: %w", err)
+		}
+		if err := expectAffected(res, storer.ErrCartItemNotFound); err != nil {
+			return err
+		}
+		c, err = getCart(ctx, tx, "id=$1", cartID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating cart: %w", err)
+	}
+	return c, nil
+}
+
+func (ps *PostgresStorage) MergeCarts(ctx context.Context, fromID, intoID uint) (*storer.Cart, error) {
+	var c *storer.Cart
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		from, err := getCart(ctx, tx, "id=$1", fromID)
+		if err != nil {
+			return err
+		}
+		if fromID == intoID {
+			c = from
+			return nil
+		}
+		if err := touchCart(ctx, tx, intoID); err != nil {
+			return err
+		}
+		for _, item := range from.Items {
+			if err := upsertCartItem(ctx, tx, intoID, item.ProductID, item.VariantID, item.Quantity, "cart_items.quantity + EXCLUDED.quantity"); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM cart_items WHERE cart_id=$1", fromID); err != nil {
+			return fmt.Errorf("error deleting cart items: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM carts WHERE id=$1", fromID); err != nil {
+			return fmt.Errorf("error deleting cart: %w", err)
+		}
+		c, err = getCart(ctx, tx, "id=$1", intoID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error merging carts: %w", err)
+	}
+	return c, nil
+}
+
+// getCart loads the cart matched by where and its items. db is either the
+// pool or a transaction.
+func getCart(ctx context.Context, db sqlx.QueryerContext, where string, args ...interface{}) (*storer.Cart, error) {
+	var c storer.Cart
+	err := sqlx.GetContext(ctx, db, &c, "SELECT * FROM carts WHERE "+where, args...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storer.ErrCartNotFound
+		}
+		return nil, fmt.Errorf("error getting cart: %w", err)
+	}
+	c.Items = []storer.CartItem{}
+	err = sqlx.SelectContext(ctx, db, &c.Items, "SELECT * FROM cart_items WHERE cart_id=$1 ORDER BY id", c.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting cart items: %w", err)
+	}
+	return &c, nil
+}
+
+func getPayment(ctx context.Context, db sqlx.QueryerContext, where string, args ...interface{}) (*storer.Payment, error) {
+	var p storer.Payment
+	if err := sqlx.GetContext(ctx, db, &p, "SELECT * FROM payments WHERE "+where, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storer.ErrPaymentNotFound
+		}
+		return nil, fmt.Errorf("error getting payment: %w", err)
+	}
+	return &p, nil
+}
+
+// settlePayment moves a payment from one status to another, also setting
+// column to value when column is not empty. The guard makes every
+// settlement happen once, however often it is retried.
+func settlePayment(ctx context.Context, tx *sqlx.Tx, id uint, from, to storer.PaymentStatus, column, value string) (*storer.Payment, error) {
+	set, args := "status=$1, updated_at=$2", []interface{}{to, time.Now()}
+	if column != "" {
+		set += ", " + column + "=$3"
+		args = append(args, value)
+	}
+	args = append(args, id, from)
+	n := len(args)
+	res, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE payments SET %s WHERE id=$%d AND status=$%d", set, n-1, n), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error updating payment: %w", err)
+	}
+	p, err := getPayment(ctx, tx, "id=$1", id)
+	if err != nil {
+		return nil, err
+	}
+	notIn := storer.ErrPaymentNotPending
+	if from == storer.PaymentCaptured {
+		notIn = storer.ErrPaymentNotCaptured
+	}
+	if err := expectAffected(res, notIn); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (ps *PostgresStorage) orderExists(ctx context.Context, id uint) error {
+	var exists bool
+	if err := ps.DB.GetContext(ctx, &exists, "SELECT EXISTS(SELECT 1 FROM orders WHERE id=$1)", id); err != nil {
+		return fmt.Errorf("error getting order: %w", err)
+	}
+	if !exists {
+		return storer.ErrOrderNotFound
+	}
+	return nil
+}
+
+func getAddress(ctx context.Context, db sqlx.QueryerContext, id uint) (*storer.Address, error) {
+	var a storer.Address
+	if err := sqlx.GetContext(ctx, db, &a, "SELECT * FROM addresses WHERE id=$1", id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storer.ErrAddressNotFound
+		}
+		return nil, fmt.Errorf("error getting address: %w", err)
+	}
+	return &a, nil
+}
+
+// lockAddressBook locks the user row so that changes to their addresses,
+// and so to which one is the default, run one at a time.
+func lockAddressBook(ctx context.Context, tx *sqlx.Tx, userID uint) error {
+	var id uint
+	if err := tx.GetContext(ctx, &id, "SELECT id FROM users WHERE id=$1 FOR UPDATE", userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storer.ErrUserNotFound
+		}
+		return fmt.Errorf("error locking user: %w", err)
+	}
+	return nil
+}
+
+func getReturn(ctx context.Context, db sqlx.QueryerContext, id uint) (*storer.ReturnRequest, error) {
+	var r storer.ReturnRequest
+	if err := sqlx.GetContext(ctx, db, &r, "SELECT * FROM return_requests WHERE id=$1", id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storer.ErrReturnNotFound
+		}
+		return nil, fmt.Errorf("error getting return: %w", err)
+	}
+	return &r, nil
+}
+
+// updateReturnStatus applies c to the locked return row, restocking its
+// items when it is received.
+func updateReturnStatus(ctx context.Context, tx *sqlx.Tx, id uint, c storer.ReturnChange) error {
+	var r storer.ReturnRequest
+	if err := tx.GetContext(ctx, &r, "SELECT * FROM return_requests WHERE id=$1 FOR UPDATE", id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storer.ErrReturnNotFound
+		}
+		return fmt.Errorf("error getting return: %w", err)
+	}
+	if err := storer.CheckReturnTransition(r.Status, c); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "UPDATE return_requests SET status=$1, reviewed_by=$2, review_note=$3, updated_at=$4 WHERE id=$5",
+		c.To, c.ChangedBy, c.Note, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("error updating return status: %w", err)
+	}
+	if c.To != storer.ReturnReceived {
+		return nil
+	}
+	var item storer.OrderItem
+	if err := tx.GetContext(ctx, &item, "SELECT * FROM order_items WHERE id=$1", r.OrderItemID); err != nil {
+		return fmt.Errorf("error restocking returned items: %w", err)
+	}
+	return restock(ctx, tx, item.ProductID, item.VariantID, r.Quantity)
+}
+
+// settleRefund moves a pending refund to status to, setting column to
+// value, once.
+func settleRefund(ctx context.Context, tx *sqlx.Tx, id uint, to storer.RefundStatus, column, value string) (*storer.Refund, error) {
+	res, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE refunds SET status=$1, %s=$2, updated_at=$3 WHERE id=$4 AND status=$5", column),
+		to, value, time.Now(), id, storer.RefundPending)
+	if err != nil {
+		return nil, fmt.Errorf("error updating refund: %w", err)
+	}
+	var r storer.Refund
+	if err := tx.GetContext(ctx, &r, "SELECT * FROM refunds WHERE id=$1", id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storer.ErrRefundNotFound
+		}
+		return nil, fmt.Errorf("error getting refund: %w", err)
+	}
+	if err := expectAffected(res, storer.ErrRefundNotPending); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// touchCart bumps the cart's updated_at and locks its row, failing with
+// ErrCartNotFound when it does not exist.
+func touchCart(ctx context.Context, tx *sqlx.Tx, cartID uint) error {
+	res, err := tx.ExecContext(ctx, "UPDATE carts SET updated_at=$1 WHERE id=$2", time.Now(), cartID)
+	if err != nil {
+		return fmt.Errorf("error updating cart: %w", err)
+	}
+	return expectAffected(res, storer.ErrCartNotFound)
+}
+
+// upsertCartItem inserts the product's line or, on conflict with an existing
+// line, sets its quantity to the update expression.
+// upsertCartItem inserts a cart line or sets the quantity of the existing
+// one to update. The conflict target is the expression of the unique index
+// idx_cart_items_cart_line.
+func upsertCartItem(ctx context.Context, tx *sqlx.Tx, cartID, productID uint, variantID *uint, quantity int, update string) error {
+	now := time.Now()
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO cart_items (created_at, updated_at, cart_id, product_id, variant_id, quantity)
+		VALUES ($1, $1, $2, $3, $4, $5)
+		ON CONFLICT (cart_id, product_id, (COALESCE(variant_id, 0))) DO UPDATE SET quantity = `+update+`, updated_at = EXCLUDED.updated_at`,
+		now, cartID, productID, variantID, quantity)
+	if err != nil {
+		return fmt.Errorf("error saving cart item: %w", err)
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) CreateUser(ctx context.Context, u *storer.User) (*storer.User, error) {
+	query := `
+		INSERT INTO users (created_at, updated_at, name, email, password, is_admin) 
+		VALUES (:created_at, :updated_at, :name, :email, :password, :is_admin) 
+		RETURNING id`
+
+	now := time.Now()
+	u.CreatedAt, u.UpdatedAt = now, now
+
+	stmt, err := ps.DB.PrepareNamedContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing named statement for user: %w", err)
+	}
+	defer stmt.Close()
+
+	err = stmt.GetContext(ctx, &u.ID, u)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, storer.ErrUserAlreadyExists
+		}
+		return nil, fmt.Errorf("error inserting user and getting ID: %w", err)
+	}
+
+	return u, nil
+}
+
+func (ps *PostgresStorage) GetUser(ctx context.Context, email string) (*storer.User, error) {
+	var u storer.User
+	err := ps.DB.GetContext(ctx, &u, "SELECT * FROM users WHERE email=$1", email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storer.ErrUserNotFound
+		}
+		return nil, fmt.Errorf("error getting user: %w", err)
+	}
+
+	return &u, nil
+}
+
+func (ps *PostgresStorage) ListUsers(ctx context.Context, q storer.UserQuery) (*storer.Page[storer.User], error) {
+	lq, err := q.Compile()
+	if err != nil {
+		return nil, err
+	}
+	total, err := ps.count(ctx, lq, "users")
+	if err != nil {
+		return nil, fmt.Errorf("error counting users: %w", err)
+	}
+	var users []storer.User
+	query, args := lq.SelectSQL("users")
+	err = ps.DB.SelectContext(ctx, &users, ps.DB.Rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing users: %w", err)
+	}
+
+	return storer.UserPage(lq, users, total), nil
+}
+
+func (ps *PostgresStorage) UpdateUser(ctx context.Context, u *storer.User) (*storer.User, error) {
+	u.UpdatedAt = time.Now()
+	res, err := ps.DB.NamedExecContext(ctx, "UPDATE users SET updated_at=:updated_at, name=:name, email=:email, password=:password, is_admin=:is_admin WHERE id=:id", u)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, storer.ErrUserAlreadyExists
+		}
+		return nil, fmt.Errorf("error updating user: %w", err)
+	}
+	if err := expectAffected(res, storer.ErrUserNotFound); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func (ps *PostgresStorage) DeleteUser(ctx context.Context, id uint) error {
+	res, err := ps.DB.ExecContext(ctx, "DELETE FROM users WHERE id=$1", id)
+	if err != nil {
+		return fmt.Errorf("error deleting user: %w", err)
+	}
+
+	return expectAffected(res, storer.ErrUserNotFound)
+}
+
+func (ps *PostgresStorage) CreateAddress(ctx context.Context, a *storer.Address) (*storer.Address, error) {
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		if err := lockAddressBook(ctx, tx, a.UserID); err != nil {
+			return err
+		}
+		var count int
+		if err := tx.GetContext(ctx, &count, "SELECT COUNT(*) FROM addresses WHERE user_id=$1", a.UserID); err != nil {
+			return fmt.Errorf("error counting addresses: %w", err)
+		}
+		a.IsDefault = a.IsDefault || count == 0
+		if a.IsDefault {
+			if _, err := tx.ExecContext(ctx, "UPDATE addresses SET is_default=false WHERE user_id=$1 AND is_default", a.UserID); err != nil {
+				return fmt.Errorf("error clearing default address: %w", err)
+			}
+		}
+		now := time.Now()
+		a.CreatedAt, a.UpdatedAt = now, now
+		return tx.GetContext(ctx, &a.ID, `
+			INSERT INTO addresses (created_at, updated_at, user_id, name, line1, line2, city, postal_code, country, is_default)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`,
+			a.CreatedAt, a.UpdatedAt, a.UserID, a.Name, a.Line1, a.Line2, a.City, a.PostalCode, a.Country, a.IsDefault)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating address: %w", err)
+	}
+	return a, nil
+}
+
+func (ps *PostgresStorage) GetAddress(ctx context.Context, id uint) (*storer.Address, error) {
+	return getAddress(ctx, ps.DB, id)
+}
+
+func (ps *PostgresStorage) ListAddresses(ctx context.Context, userID uint) ([]storer.Address, error) {
+	addresses := []storer.Address{}
+	if err := ps.DB.SelectContext(ctx, &addresses, "SELECT * FROM addresses WHERE user_id=$1 ORDER BY is_default DESC, id", userID); err != nil {
+		return nil, fmt.Errorf("error listing addresses: %w", err)
+	}
+	return addresses, nil
+}
+
+func (ps *PostgresStorage) UpdateAddress(ctx context.Context, a *storer.Address) (*storer.Address, error) {
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		current, err := getAddress(ctx, tx, a.ID)
+		if err != nil {
+			return err
+		}
+		if err := lockAddressBook(ctx, tx, current.UserID); err != nil {
+			return err
+		}
+		a.UserID, a.CreatedAt, a.UpdatedAt = current.UserID, current.CreatedAt, time.Now()
+		a.IsDefault = a.IsDefault || current.IsDefault
+		if a.IsDefault && !current.IsDefault {
+			if _, err := tx.ExecContext(ctx, "UPDATE addresses SET is_default=false WHERE user_id=$1 AND is_default", a.UserID); err != nil {
+				return fmt.Errorf("error clearing default address: %w", err)
+			}
+		}
+		res, err := tx.NamedExecContext(ctx, `
+			UPDATE addresses SET updated_at=:updated_at, name=:name, line1=:line1, line2=:line2, city=:city,
+				postal_code=:postal_code, country=:country, is_default=:is_default
+			WHERE id=:id`, a)
+		if err != nil {
+			return fmt.Errorf("error saving address: %w", err)
+		}
+		return expectAffected(res, storer.ErrAddressNotFound)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating address: %w", err)
+	}
+	return a, nil
+}
+
+func (ps *PostgresStorage) DeleteAddress(ctx context.Context, id uint) error {
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		a, err := getAddress(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if err := lockAddressBook(ctx, tx, a.UserID); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, "DELETE FROM addresses WHERE id=$1", id)
+		if err != nil {
+			return fmt.Errorf("error deleting address: %w", err)
+		}
+		if err := expectAffected(res, storer.ErrAddressNotFound); err != nil || !a.IsDefault {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE addresses SET is_default=true
+			WHERE id = (SELECT id FROM addresses WHERE user_id=$1 ORDER BY id LIMIT 1)`, a.UserID)
+		if err != nil {
+			return fmt.Errorf("error setting default address: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting address: %w", err)
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) CreatePromotion(ctx context.Context, p *storer.Promotion) (*storer.Promotion, error) {
+	now := time.Now()
+	p.CreatedAt, p.UpdatedAt = now, now
+	stmt, err := ps.DB.PrepareNamedContext(ctx, `
+		INSERT INTO promotions (created_at, updated_at, name, code, kind, value, buy_quantity, get_quantity, min_order, scope,
+			starts_at, ends_at, usage_limit, per_user_limit, used_count, is_active)
+		VALUES (:created_at, :updated_at, :name, :code, :kind, :value, :buy_quantity, :get_quantity, :min_order, :scope,
+			:starts_at, :ends_at, :usage_limit, :per_user_limit, :used_count, :is_active)
+		RETURNING id`)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing named statement for promotion: %w", err)
+	}
+	defer stmt.Close()
+	if err := stmt.GetContext(ctx, &p.ID, p); err != nil {
+		if isUniqueViolation(err) {
+			return nil, storer.ErrPromotionCodeTaken
+		}
+		return nil, fmt.Errorf("error inserting promotion: %w", err)
+	}
+	return p, nil
+}
+
+func (ps *PostgresStorage) GetPromotion(ctx context.Context, id uint) (*storer.Promotion, error) {
+	return getPromotion(ctx, ps.DB, "id=$1", id)
+}
+
+func (ps *PostgresStorage) GetPromotionByCode(ctx context.Context, code string) (*storer.Promotion, error) {
+	return getPromotion(ctx, ps.DB, "code=$1", code)
+}
+
+func (ps *PostgresStorage) ListPromotions(ctx context.Context) ([]storer.Promotion, error) {
+	promotions := []storer.Promotion{}
+	if err := ps.DB.SelectContext(ctx, &promotions, "SELECT * FROM promotions ORDER BY id"); err != nil {
+		return nil, fmt.Errorf("error listing promotions: %w", err)
+	}
+	return promotions, nil
+}
+
+func (ps *PostgresStorage) UpdatePromotion(ctx context.Context, p *storer.Promotion) (*storer.Promotion, error) {
+	err := ps.execTx(ctx, func(tx *sqlx.Tx) error {
+		p.UpdatedAt = time.Now()
+		res, err := tx.NamedExecContext(ctx, `
+			UPDATE promotions SET updated_at=:updated_at, name=:name, code=:code, kind=:kind, value=:value,
+				buy_quantity=:buy_quantity, get_quantity=:get_quantity, min_order=:min_order, scope=:scope,
+				starts_at=:starts_at, ends_at=:ends_at, usage_limit=:usage_limit, per_user_limit=:per_user_limit,
+				is_active=:is_active
+			WHERE id=:id`, p)
+		if err != nil {
+			if isUniqueViolation(err) {
+				return storer.ErrPromotionCodeTaken
+			}
+			return fmt.Errorf("error saving promotion: %w", err)
+		}
+		if err := expectAffected(res, storer.ErrPromotionNotFound); err != nil {
+			return err
+		}
+		updated, err := getPromotion(ctx, tx, "id=$1", p.ID)
+		if err != nil {
+			return err
+		}
+		*p = *updated
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error updating promotion: %w", err)
+	}
+	return p, nil
+}
+
+func (ps *PostgresStorage) DeletePromotion(ctx context.Context, id uint) error {
+	res, err := ps.DB.ExecContext(ctx, "DELETE FROM promotions WHERE id=$1", id)
+	if err != nil {
+		return fmt.Errorf("error deleting promotion: %w", err)
+	}
+	return expectAffected(res, storer.ErrPromotionNotFound)
+}
+
+func (ps *PostgresStorage) CountRedemptions(ctx context.Context, promotionID uint, r storer.Redeemer) (int, error) {
+	return countRedemptions(ctx, ps.DB, promotionID, r, false)
+}
+
+func (ps *PostgresStorage) CreateSession(ctx context.Context, s *storer.Session) (*storer.Session, error) {
+	if s.CreatedAt.IsZero() {
+		s.CreatedAt = time.Now()
+	}
+	_, err := ps.DB.NamedExecContext(ctx, "INSERT INTO sessions (id, user_email, refresh_token, is_revoked, created_at, expires_at) VALUES (:id, :user_email, :refresh_token, :is_revoked, :created_at, :expires_at)", s)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting session: %w", err)
+	}
+
+	return s, nil
+}
+
+func (ps *PostgresStorage) GetSession(ctx context.Context, id string) (*storer.Session, error) {
+	var s storer.Session
+	err := ps.DB.GetContext(ctx, &s, "SELECT * FROM sessions WHERE id=$1", id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storer.ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("error getting session: %w", err)
+	}
+
+	return &s, nil
+}
+
+func (ps *PostgresStorage) RevokeSession(ctx context.Context, id string) error {
+	res, err := ps.DB.ExecContext(ctx, "UPDATE sessions SET is_revoked=true WHERE id=$1", id)
+	if err != nil {
+		return fmt.Errorf("error revoking session: %w", err)
+	}
+
+	return expectAffected(res, storer.ErrSessionNotFound)
+}
+
+func (ps *PostgresStorage) DeleteSession(ctx context.Context, id string) error {
+	res, err := ps.DB.ExecContext(ctx, "DELETE FROM sessions WHERE id=$1", id)
+	if err != nil {
+		return fmt.Errorf("error deleting session: %w", err)
+	}
+
+	return expectAffected(res, storer.ErrSessionNotFound)
+}
+
+func getPromotion(ctx context.Context, db sqlx.QueryerContext, where string, args ...interface{}) (*storer.Promotion, error) {
+	var p storer.Promotion
+	if err := sqlx.GetContext(ctx, db, &p, "SELECT * FROM promotions WHERE "+where, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storer.ErrPromotionNotFound
+		}
+		return nil, fmt.Errorf("error getting promotion: %w", err)
+	}
+	return &p, nil
+}
+
+func getCategory(ctx context.Context, db sqlx.QueryerContext, where string, args ...interface{}) (*storer.Category, error) {
+	var c storer.Category
+	if err := sqlx.GetContext(ctx, db, &c, "SELECT * FROM categories WHERE "+where, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storer.ErrCategoryNotFound
+		}
+		return nil, fmt.Errorf("error getting category: %w", err)
+	}
+	return &c, nil
+}
+
+func getProductImage(ctx context.Context, db sqlx.QueryerContext, where string, args ...interface{}) (*storer.ProductImage, error) {
+	var img storer.ProductImage
+	if err := sqlx.GetContext(ctx, db, &img, "SELECT * FROM product_images WHERE "+where, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storer.ErrImageNotFound
+		}
+		return nil, fmt.Errorf("error getting product image: %w", err)
+	}
+	return &img, nil
+}
+
+func listProductImages(ctx context.Context, db sqlx.QueryerContext, productID uint) ([]storer.ProductImage, error) {
+	images := []storer.ProductImage{}
+	if err := sqlx.SelectContext(ctx, db, &images, "SELECT * FROM product_images WHERE product_id=$1 ORDER BY position, id", productID); err != nil {
+		return nil, fmt.Errorf("error listing product images: %w", err)
+	}
+	return images, nil
+}
+
+func getReview(ctx context.Context, db sqlx.QueryerContext, where string, args ...interface{}) (*storer.Review, error) {
+	var r storer.Review
+	if err := sqlx.GetContext(ctx, db, &r, "SELECT * FROM reviews WHERE "+where, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storer.ErrReviewNotFound
+		}
+		return nil, fmt.Errorf("error getting review: %w", err)
+	}
+	return &r, nil
+}
+
+// lockProduct holds the product row until the transaction ends, so that
+// concurrent review changes recompute its rating one after the other.
+func lockProduct(ctx context.Context, tx *sqlx.Tx, id uint) error {
+	var locked uint
+	if err := tx.GetContext(ctx, &locked, "SELECT id FROM products WHERE id=$1 FOR UPDATE", id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storer.ErrProductNotFound
+		}
+		return fmt.Errorf("error locking product: %w", err)
+	}
+	return nil
+}
+
+// refreshRating recomputes the Rating and NumReviews of a locked product
+// from its approved reviews.
+func refreshRating(ctx context.Context, tx *sqlx.Tx, productID uint) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE products SET updated_at=$3,
+			num_reviews=(SELECT COUNT(*) FROM reviews WHERE product_id=$1 AND status=$2),
+			rating=COALESCE((SELECT ROUND(AVG(rating), 2) FROM reviews WHERE product_id=$1 AND status=$2), 0)
+		WHERE id=$1`, productID, storer.ReviewApproved, time.Now())
+	if err != nil {
+		return fmt.Errorf("error updating product rating: %w", err)
+	}
+	return nil
+}
+
+func getVariant(ctx context.Context, db sqlx.QueryerContext, where string, args ...interface{}) (*storer.ProductVariant, error) {
+	var v storer.ProductVariant
+	if err := sqlx.GetContext(ctx, db, &v, "SELECT * FROM product_variants WHERE "+where, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storer.ErrVariantNotFound
+		}
+		return nil, fmt.Errorf("error getting variant: %w", err)
+	}
+	return &v, nil
+}
+
+func countRedemptions(ctx context.Context, db sqlx.QueryerContext, promotionID uint, r storer.Redeemer, lock bool) (int, error) {
+	query, args := "SELECT 1 FROM promotion_redemptions WHERE promotion_id=$1 AND user_id IS NULL AND email=$2", []interface{}{promotionID, r.Email}
+	if r.UserID != nil {
+		query, args = "SELECT 1 FROM promotion_redemptions WHERE promotion_id=$1 AND user_id=$2", []interface{}{promotionID, *r.UserID}
+	}
+	if lock {
+		// aggregates cannot lock, the rows they count can
+		query += " FOR UPDATE"
+	}
+	var n int
+	if err := sqlx.GetContext(ctx, db, &n, "SELECT COUNT(*) FROM ("+query+") r", args...); err != nil {
+		return 0, fmt.Errorf("error counting promotion redemptions: %w", err)
+	}
+	return n, nil
+}
+
+// claimPromotions counts a use of every promotion of the order against its
+// limits. The conditional increment locks the promotion row, so concurrent
+// orders redeeming it wait for each other before counting the buyer's
+// redemptions; promotions are claimed in ID order so that two orders never
+// wait on each other's. The buyer's redemptions are locked as they are
+// counted, so the count is of the rows committed while waiting. It returns
+// the redemptions to record once the order exists.
+func claimPromotions(ctx context.Context, tx *sqlx.Tx, o *storer.Order) ([]storer.PromotionRedemption, error) {
+	buyer := storer.Redeemer{UserID: o.UserID, Email: o.Email}
+	discounts := slices.Clone(o.Discounts)
+	slices.SortFunc(discounts, func(a, b storer.OrderDiscount) int { return cmp.Compare(a.PromotionID, b.PromotionID) })
+	var redemptions []storer.PromotionRedemption
+	for _, d := range discounts {
+		res, err := tx.ExecContext(ctx, "UPDATE promotions SET used_count = used_count + 1 WHERE id=$1 AND (usage_limit = 0 OR used_count < usage_limit)", d.PromotionID)
+		if err != nil {
+			return nil, fmt.Errorf("error redeeming promotion: %w", err)
+		}
+		p, err := getPromotion(ctx, tx, "id=$1", d.PromotionID)
+		if err != nil {
+			return nil, err
+		}
+		if err := expectAffected(res, storer.ErrPromotionUsedUp); err != nil {
+			return nil, err
+		}
+		if p.PerUserLimit > 0 {
+			n, err := countRedemptions(ctx, tx, p.ID, buyer, true)
+			if err != nil {
+				return nil, err
+			}
+			if n >= p.PerUserLimit {
+				return nil, storer.ErrPromotionUserLimit
+			}
+		}
+		redemptions = append(redemptions, storer.PromotionRedemption{PromotionID: p.ID, UserID: o.UserID, Email: o.Email})
+	}
+	return redemptions, nil
+}
+
+// expectAffected turns a statement that touched no rows into notFound.
+func expectAffected(res sql.Result, notFound error) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error reading affected rows: %w", err)
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
 }
@@ -0,0 +1,42 @@
+package storerpq_test
+
+import (
+	"context"
+	"ecom_apiv1/db/migrate"
+	"ecom_apiv1/internal/storer"
+	"ecom_apiv1/internal/storer/storertest"
+	storerpq "ecom_apiv1/internal/storer_pq"
+	"os"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+// The suite runs against a real Postgres, e.g.
+// STORER_PQ_TEST_DSN="user=dev password=dev dbname=ecom_test sslmode=disable"
+const dsnEnv = "STORER_PQ_TEST_DSN"
+
+func TestPostgresStorage(t *testing.T) {
+	dsn := os.Getenv(dsnEnv)
+	if dsn == "" {
+		t.Skipf("%s not set, skipping Postgres conformance suite", dsnEnv)
+	}
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		t.Fatalf("failed to connect postgres: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrator, err := migrate.New(db.DB, migrate.Postgres)
+	if err != nil {
+		t.Fatalf("failed to load migrations: %v", err)
+	}
+	if err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	storertest.Run(t, func(t *testing.T) storer.Store {
+		db.MustExec("TRUNCATE product_images, reviews, categories, product_variants, promotion_redemptions, promotions, addresses, idempotency_keys, webhook_events, refunds, return_requests, payments, cart_items, carts, order_status_events, order_items, orders, products, users, sessions RESTART IDENTITY CASCADE")
+		return storerpq.NewPostgresStorage(db)
+	})
+}
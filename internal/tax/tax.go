@@ -0,0 +1,202 @@
+// Package tax computes the tax of every order line from the product's tax
+// category and the jurisdiction the order ships to.
+package tax
+
+import (
+	"math"
+
+	"ecom_apiv1/config"
+)
+
+// StandardCategory is the category of products without one, and the rate
+// categories a jurisdiction does not list are taxed at.
+const StandardCategory = "standard"
+
+type Mode string
+
+const (
+	// Exclusive tax is added to the prices, as US sales tax is.
+	Exclusive Mode = "exclusive"
+	// Inclusive tax is contained in the prices, as VAT or PPN is.
+	Inclusive Mode = "inclusive"
+)
+
+type RoundMode string
+
+const (
+	HalfUp   RoundMode = "half_up"
+	HalfEven RoundMode = "half_even"
+	Down     RoundMode = "down"
+)
+
+// Round rounds amount to cents. Half up rounds halves away from zero.
+func (m RoundMode) Round(amount float64) float64 {
+	// drop the float noise of the multiplication so 0.29 does not become
+	// 28.999... cents
+	cents := math.Round(amount*100*1e6) / 1e6
+	switch m {
+	case HalfEven:
+		return math.RoundToEven(cents) / 100
+	case Down:
+		return math.Trunc(cents) / 100
+	default:
+		return math.Round(cents) / 100
+	}
+}
+
+// Jurisdiction is how orders shipped to Country are taxed.
+type Jurisdiction struct {
+	Country string
+	Mode    Mode
+	// Rates maps a tax category to its rate.
+	Rates map[string]float64
+	// PerLine rounds the tax of every line on its own. Otherwise the tax
+	// of the order is rounded once and the lines absorb the difference.
+	PerLine   bool
+	RoundMode RoundMode
+}
+
+// Rate is the rate of category, the standard rate when it is not listed.
+func (j Jurisdiction) Rate(category string) float64 {
+	if rate, ok := j.Rates[category]; ok {
+		return rate
+	}
+	return j.Rates[StandardCategory]
+}
+
+// Item is an order line to tax.
+type Item struct {
+	Category string
+	// Amount is the price of the line, tax included in inclusive
+	// jurisdictions.
+	Amount float64
+}
+
+// Amount is the tax of the lines of one category.
+type Amount struct {
+	Category string
+	Rate     float64
+	// Taxable is the price of the lines without their tax.
+	Taxable float64
+	Tax     float64
+}
+
+type Result struct {
+	Country string
+	Mode    Mode
+	// Lines is the tax of each item, in order.
+	Lines   []float64
+	Amounts []Amount
+	Total   float64
+}
+
+// Included reports whether the tax is already part of the item prices.
+func (r Result) Included() bool {
+	return r.Mode == Inclusive
+}
+
+type Engine struct {
+	jurisdictions map[string]Jurisdiction
+	fallback      Jurisdiction
+}
+
+// NewEngine taxes orders in the jurisdiction of their country and in
+// fallback everywhere else.
+func NewEngine(fallback Jurisdiction, jurisdictions ...Jurisdiction) *Engine {
+	e := &Engine{jurisdictions: make(map[string]Jurisdiction, len(jurisdictions)), fallback: fallback}
+	for _, j := range jurisdictions {
+		e.jurisdictions[j.Country] = j
+	}
+	return e
+}
+
+// FromConfig builds the configured jurisdictions. Orders elsewhere pay
+// pricing.TaxRate on top of every item, rounded once per order, and orders
+// to an unknown country are taxed as if shipped to cfg.DefaultCountry.
+func FromConfig(pricing config.PricingConfig, cfg config.TaxConfig) *Engine {
+	fallback := Jurisdiction{
+		Mode:      Exclusive,
+		Rates:     map[string]float64{StandardCategory: pricing.TaxRate},
+		RoundMode: HalfUp,
+	}
+	js := make([]Jurisdiction, 0, len(cfg.Jurisdictions))
+	for _, j := range cfg.Jurisdictions {
+		roundMode := RoundMode(j.RoundMode)
+		if roundMode == "" {
+			roundMode = HalfUp
+		}
+		js = append(js, Jurisdiction{
+			Country:   j.Country,
+			Mode:      Mode(j.Mode),
+			Rates:     j.Rates,
+			PerLine:   j.Rounding == "line",
+			RoundMode: roundMode,
+		})
+	}
+	e := NewEngine(fallback, js...)
+	if j, ok := e.jurisdictions[cfg.DefaultCountry]; ok {
+		e.jurisdictions[""] = j
+	}
+	return e
+}
+
+// Jurisdiction is where orders shipped to country are taxed.
+func (e *Engine) Jurisdiction(country string) Jurisdiction {
+	if j, ok := e.jurisdictions[country]; ok {
+		return j
+	}
+	return e.fallback
+}
+
+// Compute taxes items shipped to country.
+func (e *Engine) Compute(country string, items []Item) Result {
+	j := e.Jurisdiction(country)
+	res := Result{Country: j.Country, Mode: j.Mode, Lines: make([]float64, len(items))}
+
+	var exact, rounded float64
+	largest := -1
+	raw := make([]float64, len(items))
+	for i, item := range items {
+		rate := j.Rate(item.Category)
+		if j.Mode == Inclusive {
+			raw[i] = item.Amount * rate / (1 + rate)
+		} else {
+			raw[i] = item.Amount * rate
+		}
+		res.Lines[i] = j.RoundMode.Round(raw[i])
+		exact += raw[i]
+		rounded += res.Lines[i]
+		if largest < 0 || raw[i] > raw[largest] {
+			largest = i
+		}
+	}
+	res.Total = HalfUp.Round(rounded)
+	if !j.PerLine && largest >= 0 {
+		// the largest line takes the cents lost to rounding each line, so
+		// the lines still add up to the order's tax
+		total := j.RoundMode.Round(exact)
+		res.Lines[largest] = HalfUp.Round(res.Lines[largest] + total - res.Total)
+		res.Total = total
+	}
+
+	byCategory := make(map[string]int)
+	for i, item := range items {
+		category := item.Category
+		if category == "" {
+			category = StandardCategory
+		}
+		k, ok := byCategory[category]
+		if !ok {
+			k = len(res.Amounts)
+			byCategory[category] = k
+			res.Amounts = append(res.Amounts, Amount{Category: category, Rate: j.Rate(category)})
+		}
+		taxable := item.Amount
+		if j.Mode == Inclusive {
+			taxable -= res.Lines[i]
+		}
+		res.Amounts[k].Taxable = HalfUp.Round(res.Amounts[k].Taxable + taxable)
+		res.Amounts[k].Tax = HalfUp.Round(res.Amounts[k].Tax + res.Lines[i])
+	}
+	return res
+}
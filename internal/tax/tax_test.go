@@ -0,0 +1,89 @@
+package tax
+
+import (
+	"testing"
+
+	"ecom_apiv1/config"
+)
+
+func TestCompute(t *testing.T) {
+	e := FromConfig(config.PricingConfig{TaxRate: 0.1}, config.TaxConfig{
+		DefaultCountry: "ID",
+		Jurisdictions: []config.TaxJurisdictionConfig{
+			{Country: "ID", Mode: "inclusive", Rates: map[string]float64{"standard": 0.11, "exempt": 0}},
+			{Country: "US", Mode: "exclusive", Rates: map[string]float64{"standard": 0.0725, "food": 0.0225}, Rounding: "line"},
+		},
+	})
+	items := []Item{{Amount: 111}, {Category: "exempt", Amount: 50}, {Amount: 0.99}}
+
+	tests := []struct {
+		country string
+		mode    Mode
+		lines   []float64
+		total   float64
+	}{
+		// 11 of 111 and 0.0981 of 0.99 are tax, 11.10 once rounded
+		{country: "ID", mode: Inclusive, lines: []float64{11, 0, 0.10}, total: 11.10},
+		// carts before checkout are taxed in the default country
+		{country: "", mode: Inclusive, lines: []float64{11, 0, 0.10}, total: 11.10},
+		// exempt is not a US category, so it pays the standard rate
+		{country: "US", mode: Exclusive, lines: []float64{8.05, 3.63, 0.07}, total: 11.75},
+		{country: "FR", mode: Exclusive, lines: []float64{11.10, 5, 0.10}, total: 16.20},
+	}
+	for _, tt := range tests {
+		res := e.Compute(tt.country, items)
+		if res.Mode != tt.mode || res.Total != tt.total {
+			t.Errorf("Compute(%q) = %s %v, want %s %v", tt.country, res.Mode, res.Total, tt.mode, tt.total)
+		}
+		for i, want := range tt.lines {
+			if res.Lines[i] != want {
+				t.Errorf("Compute(%q) line %d = %v, want %v", tt.country, i, res.Lines[i], want)
+			}
+		}
+	}
+
+	res := e.Compute("ID", items)
+	want := []Amount{
+		{Category: StandardCategory, Rate: 0.11, Taxable: 100.89, Tax: 11.10},
+		{Category: "exempt", Rate: 0, Taxable: 50, Tax: 0},
+	}
+	if len(res.Amounts) != len(want) || res.Amounts[0] != want[0] || res.Amounts[1] != want[1] {
+		t.Errorf("amounts = %+v, want %+v", res.Amounts, want)
+	}
+}
+
+func TestComputeRoundsOncePerOrder(t *testing.T) {
+	e := NewEngine(Jurisdiction{Mode: Exclusive, Rates: map[string]float64{StandardCategory: 0.05}, RoundMode: HalfUp})
+	// every line is 0.025 tax: 3 cents each on its own, 8 cents in total
+	res := e.Compute("", []Item{{Amount: 0.5}, {Amount: 0.5}, {Amount: 0.5}})
+	if res.Total != 0.08 {
+		t.Errorf("total = %v, want 0.08", res.Total)
+	}
+	var sum float64
+	for _, l := range res.Lines {
+		sum += l
+	}
+	if HalfUp.Round(sum) != res.Total {
+		t.Errorf("lines %v do not add up to %v", res.Lines, res.Total)
+	}
+}
+
+func TestRoundMode(t *testing.T) {
+	tests := []struct {
+		mode   RoundMode
+		amount float64
+		want   float64
+	}{
+		{HalfUp, 0.125, 0.13},
+		{HalfUp, -0.125, -0.13},
+		{HalfEven, 0.125, 0.12},
+		{HalfEven, 0.135, 0.14},
+		{Down, 0.129, 0.12},
+		{Down, 0.29, 0.29},
+	}
+	for _, tt := range tests {
+		if got := tt.mode.Round(tt.amount); got != tt.want {
+			t.Errorf("%s.Round(%v) = %v, want %v", tt.mode, tt.amount, got, tt.want)
+		}
+	}
+}
@@ -8,12 +8,16 @@ import (
 )
 
 type JWTMaker struct {
-	SecretKey string
+	SecretKey            string
+	AccessTokenDuration  time.Duration
+	RefreshTokenDuration time.Duration
 }
 
-func NewJWTMaker(secretKey string) *JWTMaker {
+func NewJWTMaker(secretKey string, accessTokenDuration, refreshTokenDuration time.Duration) *JWTMaker {
 	return &JWTMaker{
-		SecretKey: secretKey,
+		SecretKey:            secretKey,
+		AccessTokenDuration:  accessTokenDuration,
+		RefreshTokenDuration: refreshTokenDuration,
 	}
 }
 